@@ -0,0 +1,389 @@
+// Package server exposes factory and container operations over a small
+// JSON HTTP API served on a unix socket.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/zakarynichols/hackontainer/api/types"
+	"github.com/zakarynichols/hackontainer/libcontainer"
+	"github.com/zakarynichols/hackontainer/libcontainer/audit"
+	"golang.org/x/sys/unix"
+)
+
+// shutdownTimeout bounds how long in-flight requests get on shutdown.
+const shutdownTimeout = 10 * time.Second
+
+type peerCredKey struct{}
+
+// Server serves the API for the containers under one factory root.
+type Server struct {
+	factory libcontainer.Factory
+	root    string
+
+	// allowedUID may use the API in addition to root. Negative means
+	// root only.
+	allowedUID int
+}
+
+// New returns a server for the containers under root.
+func New(factory libcontainer.Factory, root string, allowedUID int) *Server {
+	return &Server{factory: factory, root: root, allowedUID: allowedUID}
+}
+
+// CreateRequest is the body of POST /containers. Config, when set, is
+// used instead of the bundle's config.json.
+type CreateRequest struct {
+	ID     string      `json:"id"`
+	Bundle string      `json:"bundle"`
+	Config *specs.Spec `json:"config,omitempty"`
+}
+
+// KillRequest is the body of POST /containers/{id}/kill. Signal is a
+// name (SIGTERM, TERM) or number and defaults to SIGTERM. All signals
+// every process in the container instead of just its init.
+type KillRequest struct {
+	Signal string `json:"signal,omitempty"`
+	All    bool   `json:"all,omitempty"`
+}
+
+// Handler returns the API routes, guarded by the peer credential check.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /containers", s.handleCreate)
+	mux.HandleFunc("GET /containers", s.handleList)
+	mux.HandleFunc("POST /containers/{id}/start", s.handleStart)
+	mux.HandleFunc("GET /containers/{id}/state", s.handleState)
+	mux.HandleFunc("POST /containers/{id}/kill", s.handleKill)
+	mux.HandleFunc("DELETE /containers/{id}", s.handleDelete)
+	mux.HandleFunc("GET /events", s.handleEvents)
+	return s.authorize(mux)
+}
+
+// ListenAndServe serves on address, which must be unix:///path/to.sock,
+// until ctx is cancelled.
+func (s *Server) ListenAndServe(ctx context.Context, address string) error {
+	path, ok := strings.CutPrefix(address, "unix://")
+	if !ok || path == "" {
+		return fmt.Errorf("unsupported listen address %q: only unix:///path sockets are supported", address)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+
+	// Access control is by peer credentials, but keep other users from
+	// connecting at all unless one is explicitly allowed
+	mode := os.FileMode(0600)
+	if s.allowedUID >= 0 {
+		mode = 0666
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return err
+	}
+
+	srv := &http.Server{
+		Handler:     s.Handler(),
+		BaseContext: func(net.Listener) context.Context { return ctx },
+		ConnContext: withPeerCred,
+	}
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.Serve(listener) }()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errCh; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// withPeerCred records the SO_PEERCRED credentials of unix socket peers.
+func withPeerCred(ctx context.Context, conn net.Conn) context.Context {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return ctx
+	}
+
+	var cred *unix.Ucred
+	raw.Control(func(fd uintptr) {
+		cred, err = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, peerCredKey{}, cred)
+}
+
+// callerOf is who made the request, for the audit log.
+func callerOf(r *http.Request) audit.Caller {
+	cred, _ := r.Context().Value(peerCredKey{}).(*unix.Ucred)
+	if cred == nil {
+		return audit.Caller{UID: -1, GID: -1, PID: -1}
+	}
+	return audit.Caller{UID: int(cred.Uid), GID: int(cred.Gid), PID: int(cred.Pid)}
+}
+
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cred, _ := r.Context().Value(peerCredKey{}).(*unix.Ucred)
+		if cred == nil {
+			writeError(w, http.StatusForbidden, fmt.Errorf("peer credentials unavailable"))
+			return
+		}
+		if cred.Uid != 0 && (s.allowedUID < 0 || cred.Uid != uint32(s.allowedUID)) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("uid %d is not allowed to use the API", cred.Uid))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var req CreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if req.Bundle == "" || !filepath.IsAbs(req.Bundle) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("bundle must be an absolute path"))
+		return
+	}
+
+	var opts []libcontainer.CreateOption
+	if req.Config != nil {
+		configPath, err := s.writeUploadedConfig(req.Config)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		// Create freezes its own copy, so the upload is only needed briefly
+		defer os.Remove(configPath)
+		opts = append(opts, libcontainer.WithConfigPath(configPath))
+	}
+
+	opts = append(opts, libcontainer.WithCreateCaller(callerOf(r)))
+	container, err := s.factory.Create(req.ID, req.Bundle, opts...)
+	if err != nil {
+		writeLibError(w, err)
+		return
+	}
+
+	state, err := container.State()
+	if err != nil {
+		writeLibError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, state)
+}
+
+func (s *Server) writeUploadedConfig(spec *specs.Spec) (string, error) {
+	f, err := os.CreateTemp(s.root, ".api-config-*.json")
+	if err != nil {
+		return "", fmt.Errorf("failed to store uploaded config: %w", err)
+	}
+	defer f.Close()
+
+	if err := json.NewEncoder(f).Encode(spec); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to store uploaded config: %w", err)
+	}
+	return f.Name(), nil
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	listed, err := s.factory.List()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Containers that can't be loaded are left out
+	entries := []libcontainer.ListEntry{}
+	for _, c := range listed {
+		if c.Err == nil {
+			entries = append(entries, c.Entry())
+		}
+	}
+
+	writeJSON(w, http.StatusOK, entries)
+}
+
+func (s *Server) handleStart(w http.ResponseWriter, r *http.Request) {
+	container, err := s.factory.Load(r.PathValue("id"), libcontainer.WithCaller(callerOf(r)))
+	if err != nil {
+		writeLibError(w, err)
+		return
+	}
+	if err := container.Start(); err != nil {
+		writeLibError(w, err)
+		return
+	}
+	s.writeState(w, container)
+}
+
+func (s *Server) handleState(w http.ResponseWriter, r *http.Request) {
+	container, err := s.factory.Load(r.PathValue("id"), libcontainer.WithCaller(callerOf(r)))
+	if err != nil {
+		writeLibError(w, err)
+		return
+	}
+	s.writeState(w, container)
+}
+
+func (s *Server) handleKill(w http.ResponseWriter, r *http.Request) {
+	var req KillRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+	}
+
+	sig, err := parseSignal(req.Signal)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	container, err := s.factory.Load(r.PathValue("id"), libcontainer.WithCaller(callerOf(r)))
+	if err != nil {
+		writeLibError(w, err)
+		return
+	}
+	if err := container.Signal(sig, req.All); err != nil {
+		writeLibError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
+	container, err := s.factory.Load(r.PathValue("id"), libcontainer.WithCaller(callerOf(r)))
+	if err != nil {
+		writeLibError(w, err)
+		return
+	}
+	if err := container.Delete(); err != nil {
+		writeLibError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleEvents streams lifecycle events as JSON lines until the client
+// goes away or the server shuts down. A stream that fails before then
+// ends with an error line.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming unsupported"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	encoder := json.NewEncoder(w)
+	err := libcontainer.FollowEvents(r.Context(), s.root, false, func(event libcontainer.Event) error {
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+	// The status is sent, so a stream that broke ends with what broke it
+	if err != nil && r.Context().Err() == nil {
+		encoder.Encode(types.Error{Error: fmt.Sprintf("events stream failed: %v", err)})
+		flusher.Flush()
+	}
+}
+
+func (s *Server) writeState(w http.ResponseWriter, container libcontainer.Container) {
+	state, err := container.State()
+	if err != nil {
+		writeLibError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, state)
+}
+
+func parseSignal(raw string) (syscall.Signal, error) {
+	if raw == "" {
+		return unix.SIGTERM, nil
+	}
+	return libcontainer.ParseSignal(raw)
+}
+
+// statusFor maps libcontainer error kinds to HTTP status codes.
+func statusFor(err error) int {
+	switch {
+	case errors.Is(err, libcontainer.ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, libcontainer.ErrExist),
+		errors.Is(err, libcontainer.ErrRunning),
+		errors.Is(err, libcontainer.ErrNotRunning),
+		errors.Is(err, libcontainer.ErrInvalidState),
+		errors.Is(err, libcontainer.ErrConfigModified),
+		errors.Is(err, libcontainer.ErrNamespaceMismatch):
+		return http.StatusConflict
+	case errors.Is(err, libcontainer.ErrInvalidID),
+		errors.Is(err, libcontainer.ErrInvalidConfig),
+		errors.Is(err, libcontainer.ErrHooksDisabled):
+		return http.StatusBadRequest
+	case errors.Is(err, libcontainer.ErrMissingKernelFeatures):
+		return http.StatusNotImplemented
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeLibError(w http.ResponseWriter, err error) {
+	writeError(w, statusFor(err), err)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, types.Error{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,463 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/zakarynichols/hackontainer/api/types"
+	"github.com/zakarynichols/hackontainer/libcontainer"
+	"golang.org/x/sys/unix"
+)
+
+// fakeContainer is a container as far as the API drives one. err, when
+// set, fails its operations.
+type fakeContainer struct {
+	libcontainer.Container
+	state *libcontainer.State
+	err   error
+
+	signal unix.Signal
+	all    bool
+}
+
+func (c *fakeContainer) State() (*libcontainer.State, error) {
+	state := *c.state
+	return &state, nil
+}
+
+func (c *fakeContainer) Labels() map[string]string {
+	return map[string]string{"app": c.state.ID}
+}
+
+func (c *fakeContainer) Start() error {
+	if c.err != nil {
+		return c.err
+	}
+	c.state.Status = libcontainer.Running
+	return nil
+}
+
+func (c *fakeContainer) Signal(sig unix.Signal, all bool) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.signal, c.all = sig, all
+	return nil
+}
+
+// fakeFactory holds its containers in memory, and deletes one from
+// there when it is deleted.
+type fakeFactory struct {
+	libcontainer.Factory
+	root string
+
+	mu         sync.Mutex
+	containers map[string]*fakeContainer
+	// uploaded is the config the API stored for the last create
+	uploaded string
+}
+
+func (f *fakeFactory) Create(id, bundle string, options ...libcontainer.CreateOption) (libcontainer.Container, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.containers[id]; ok {
+		return nil, fmt.Errorf("%w: %s", libcontainer.ErrExist, id)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(f.root, ".api-config-*.json")); len(matches) == 1 {
+		data, _ := os.ReadFile(matches[0])
+		f.uploaded = string(data)
+	}
+	c := &fakeContainer{state: &libcontainer.State{ID: id, Bundle: bundle, Status: libcontainer.Created}}
+	f.containers[id] = c
+	return c, nil
+}
+
+func (f *fakeFactory) Load(id string, options ...libcontainer.LoadOption) (libcontainer.Container, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.containers[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", libcontainer.ErrNotExist, id)
+	}
+	return &deletable{fakeContainer: c, factory: f}, nil
+}
+
+func (f *fakeFactory) List() ([]libcontainer.ListedContainer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	listed := []libcontainer.ListedContainer{{ID: "broken", Err: errors.New("corrupt state")}}
+	for id, c := range f.containers {
+		state, _ := c.State()
+		listed = append(listed, libcontainer.ListedContainer{ID: id, Container: c, State: state})
+	}
+	return listed, nil
+}
+
+// deletable is a loaded container, which deletes itself from the factory.
+type deletable struct {
+	*fakeContainer
+	factory *fakeFactory
+}
+
+func (c *deletable) Delete() error {
+	if c.err != nil {
+		return c.err
+	}
+	c.factory.mu.Lock()
+	defer c.factory.mu.Unlock()
+	delete(c.factory.containers, c.state.ID)
+	return nil
+}
+
+// serve serves s on a unix socket, as ListenAndServe does, and returns a
+// client for it.
+func serve(t *testing.T, s *Server) *http.Client {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "api.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(s.Handler())
+	srv.Listener = listener
+	srv.Config.ConnContext = withPeerCred
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+}
+
+// testServer is a server over a fake factory, with the test's own user
+// allowed to use it.
+func testServer(t *testing.T) (*Server, *fakeFactory) {
+	root := t.TempDir()
+	factory := &fakeFactory{root: root, containers: map[string]*fakeContainer{
+		"a": {state: &libcontainer.State{ID: "a", Bundle: "/bundles/a", Status: libcontainer.Created, Pid: 42}},
+	}}
+	allowed := -1
+	if uid := os.Getuid(); uid != 0 {
+		allowed = uid
+	}
+	return New(factory, root, allowed), factory
+}
+
+// do sends a request with body encoded as JSON, if not nil, and decodes
+// the response into out, if not nil. It returns the status.
+func do(t *testing.T, client *http.Client, method, path string, body, out interface{}) int {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, "http://api"+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestCreateAndList(t *testing.T) {
+	s, factory := testServer(t)
+	client := serve(t, s)
+
+	var state libcontainer.State
+	if status := do(t, client, "POST", "/containers", CreateRequest{ID: "b", Bundle: "/bundles/b"}, &state); status != http.StatusCreated {
+		t.Fatalf("got %d", status)
+	}
+	if state.ID != "b" || state.Status != libcontainer.Created {
+		t.Errorf("got %+v", state)
+	}
+
+	// An uploaded config is stored for create, and gone after
+	spec := &specs.Spec{Version: specs.Version, Hostname: "uploaded"}
+	if status := do(t, client, "POST", "/containers", CreateRequest{ID: "c", Bundle: "/bundles/c", Config: spec}, nil); status != http.StatusCreated {
+		t.Fatalf("got %d", status)
+	}
+	if !strings.Contains(factory.uploaded, `"hostname":"uploaded"`) {
+		t.Errorf("create got config %q", factory.uploaded)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(s.root, ".api-config-*.json")); len(matches) != 0 {
+		t.Errorf("left %q behind", matches)
+	}
+
+	var failed types.Error
+	tests := []struct {
+		name string
+		body interface{}
+		want int
+	}{
+		{"an existing id", CreateRequest{ID: "a", Bundle: "/bundles/a"}, http.StatusConflict},
+		{"a relative bundle", CreateRequest{ID: "d", Bundle: "bundles/d"}, http.StatusBadRequest},
+		{"no bundle", CreateRequest{ID: "d"}, http.StatusBadRequest},
+		{"not a request", []string{"d"}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if status := do(t, client, "POST", "/containers", tt.body, &failed); status != tt.want || failed.Error == "" {
+			t.Errorf("%s: got %d %q, want %d", tt.name, status, failed.Error, tt.want)
+		}
+	}
+
+	// Containers that can't be loaded are left out
+	var entries []types.ListEntry
+	if status := do(t, client, "GET", "/containers", nil, &entries); status != http.StatusOK {
+		t.Fatalf("got %d", status)
+	}
+	ids := map[string]bool{}
+	for _, entry := range entries {
+		ids[entry.ID] = true
+		if entry.Labels["app"] != entry.ID {
+			t.Errorf("%s: got labels %v", entry.ID, entry.Labels)
+		}
+	}
+	if len(entries) != 3 || !ids["a"] || !ids["b"] || !ids["c"] {
+		t.Errorf("got %+v", entries)
+	}
+}
+
+func TestContainerLifecycle(t *testing.T) {
+	s, factory := testServer(t)
+	client := serve(t, s)
+	var state libcontainer.State
+	var failed types.Error
+
+	if status := do(t, client, "GET", "/containers/a/state", nil, &state); status != http.StatusOK || state.Pid != 42 {
+		t.Fatalf("got %d %+v", status, state)
+	}
+	if status := do(t, client, "POST", "/containers/a/start", nil, &state); status != http.StatusOK || state.Status != libcontainer.Running {
+		t.Fatalf("got %d %+v", status, state)
+	}
+
+	// No body is SIGTERM to the init
+	if status := do(t, client, "POST", "/containers/a/kill", nil, nil); status != http.StatusNoContent {
+		t.Fatalf("got %d", status)
+	}
+	if c := factory.containers["a"]; c.signal != unix.SIGTERM || c.all {
+		t.Errorf("got %v all=%t, want SIGTERM to the init", c.signal, c.all)
+	}
+	if status := do(t, client, "POST", "/containers/a/kill", KillRequest{Signal: "KILL", All: true}, nil); status != http.StatusNoContent {
+		t.Fatalf("got %d", status)
+	}
+	if c := factory.containers["a"]; c.signal != unix.SIGKILL || !c.all {
+		t.Errorf("got %v all=%t, want SIGKILL to all", c.signal, c.all)
+	}
+	if status := do(t, client, "POST", "/containers/a/kill", KillRequest{Signal: "SIGNOPE"}, &failed); status != http.StatusBadRequest {
+		t.Errorf("got %d %q for an unknown signal", status, failed.Error)
+	}
+	if status := do(t, client, "POST", "/containers/a/kill", "KILL", &failed); status != http.StatusBadRequest {
+		t.Errorf("got %d %q for a body that isn't a request", status, failed.Error)
+	}
+
+	// What the container refuses comes back with its status
+	factory.containers["a"].err = fmt.Errorf("%w: it is running", libcontainer.ErrRunning)
+	for _, req := range []struct{ method, path string }{
+		{"POST", "/containers/a/start"},
+		{"POST", "/containers/a/kill"},
+		{"DELETE", "/containers/a"},
+	} {
+		if status := do(t, client, req.method, req.path, nil, &failed); status != http.StatusConflict || !strings.Contains(failed.Error, "it is running") {
+			t.Errorf("%s %s: got %d %q", req.method, req.path, status, failed.Error)
+		}
+	}
+	factory.containers["a"].err = nil
+
+	if status := do(t, client, "DELETE", "/containers/a", nil, nil); status != http.StatusNoContent {
+		t.Fatalf("got %d", status)
+	}
+	for _, req := range []struct{ method, path string }{
+		{"GET", "/containers/a/state"},
+		{"POST", "/containers/a/start"},
+		{"POST", "/containers/a/kill"},
+		{"DELETE", "/containers/a"},
+	} {
+		if status := do(t, client, req.method, req.path, nil, &failed); status != http.StatusNotFound {
+			t.Errorf("%s %s after delete: got %d %q", req.method, req.path, status, failed.Error)
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	s, _ := testServer(t)
+	client := serve(t, s)
+	log := filepath.Join(s.root, "events.log")
+	if err := os.WriteFile(log, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://api/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("got %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	// A follower starts at the end of the log, which it may not have
+	// found yet, so events are written until one arrives
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		data, _ := json.Marshal(libcontainer.Event{Type: libcontainer.EventStart, ID: "a"})
+		for ctx.Err() == nil {
+			f, err := os.OpenFile(log, os.O_WRONLY|os.O_APPEND, 0600)
+			if err != nil {
+				return
+			}
+			f.Write(append(data, '\n'))
+			f.Close()
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+	line, err := bufio.NewReader(resp.Body).ReadBytes('\n')
+	cancel()
+	<-written
+	if err != nil {
+		t.Fatal(err)
+	}
+	var event libcontainer.Event
+	if err := json.Unmarshal(line, &event); err != nil || event.Type != libcontainer.EventStart || event.ID != "a" {
+		t.Fatalf("got %q, %v", line, err)
+	}
+}
+
+func TestEventsStreamFails(t *testing.T) {
+	s, _ := testServer(t)
+	client := serve(t, s)
+	// A log that can't be read breaks the stream once it has started
+	if err := os.Mkdir(filepath.Join(s.root, "events.log"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := client.Get("http://api/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var failed types.Error
+	if err := json.Unmarshal(body, &failed); err != nil || !strings.Contains(failed.Error, "events stream failed") {
+		t.Fatalf("got %q, %v, want the stream to end with its error", body, err)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed int
+		// cred is the peer, or none if nil
+		cred *unix.Ucred
+		want int
+		// err is in the refusal
+		err string
+	}{
+		{"no peer credentials", -1, nil, http.StatusForbidden, "peer credentials unavailable"},
+		{"root", -1, &unix.Ucred{Uid: 0}, http.StatusOK, ""},
+		{"another user, with root only", -1, &unix.Ucred{Uid: 1000}, http.StatusForbidden, "uid 1000 is not allowed"},
+		{"the allowed user", 1000, &unix.Ucred{Uid: 1000}, http.StatusOK, ""},
+		{"another user than the allowed one", 1000, &unix.Ucred{Uid: 1001}, http.StatusForbidden, "uid 1001 is not allowed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			factory := &fakeFactory{root: t.TempDir(), containers: map[string]*fakeContainer{}}
+			handler := New(factory, factory.root, tt.allowed).Handler()
+			req := httptest.NewRequest("GET", "/containers", nil)
+			if tt.cred != nil {
+				req = req.WithContext(context.WithValue(req.Context(), peerCredKey{}, tt.cred))
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("got %d %s, want %d", rec.Code, rec.Body, tt.want)
+			}
+			var failed types.Error
+			if tt.err != "" && (json.Unmarshal(rec.Body.Bytes(), &failed) != nil || !strings.Contains(failed.Error, tt.err)) {
+				t.Errorf("got %s, want %q", rec.Body, tt.err)
+			}
+		})
+	}
+
+	// Over the socket the peer is whoever connected
+	if os.Getuid() == 0 {
+		return
+	}
+	s, _ := testServer(t)
+	s.allowedUID = -1
+	var failed types.Error
+	if status := do(t, serve(t, s), "GET", "/containers", nil, &failed); status != http.StatusForbidden {
+		t.Errorf("got %d %q for a user that isn't allowed", status, failed.Error)
+	}
+}
+
+func TestStatusFor(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{libcontainer.ErrNotExist, http.StatusNotFound},
+		{libcontainer.ErrExist, http.StatusConflict},
+		{libcontainer.ErrRunning, http.StatusConflict},
+		{libcontainer.ErrNotRunning, http.StatusConflict},
+		{libcontainer.ErrInvalidState, http.StatusConflict},
+		{libcontainer.ErrConfigModified, http.StatusConflict},
+		{libcontainer.ErrNamespaceMismatch, http.StatusConflict},
+		{libcontainer.ErrInvalidID, http.StatusBadRequest},
+		{libcontainer.ErrInvalidConfig, http.StatusBadRequest},
+		{libcontainer.ErrHooksDisabled, http.StatusBadRequest},
+		{libcontainer.ErrMissingKernelFeatures, http.StatusNotImplemented},
+		{libcontainer.ErrMissingPrivileges, http.StatusInternalServerError},
+		{errors.New("disk on fire"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		// The kinds come wrapped, as libcontainer returns them
+		err := fmt.Errorf("container a: %w", tt.err)
+		if got := statusFor(err); got != tt.want {
+			t.Errorf("%v: got %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+// Command schemagen writes the JSON Schema of every document in
+// api/types to api/types/schemas. It is run by go generate.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/zakarynichols/hackontainer/api/types"
+)
+
+func main() {
+	for _, name := range types.SchemaNames() {
+		data, err := types.GenerateSchema(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := os.WriteFile(filepath.Join("schemas", name+".schema.json"), data, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
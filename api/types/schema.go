@@ -0,0 +1,145 @@
+package types
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Documents names every document type that has a schema.
+var Documents = map[string]interface{}{
+	"state":       State{},
+	"list":        []ListEntry{},
+	"inspect":     InspectInfo{},
+	"event":       Event{},
+	"audit":       AuditRecord{},
+	"stats":       Stats{},
+	"stats-event": StatsEvent{},
+	"error":       Error{},
+	"footprint":   FootprintReport{},
+	"ps":          []int{},
+	"status":      Summary{},
+	"version":     VersionInfo{},
+}
+
+// enums lists the values of string types with a fixed set of values.
+var enums = map[reflect.Type][]string{
+	reflect.TypeOf(Status("")):      {string(Created), string(Running), string(Paused), string(Stopped)},
+	reflect.TypeOf(CreateMode("")):  {string(CreateModeStateOnly), string(CreateModeFull)},
+	reflect.TypeOf(AuditResult("")): {string(AuditSuccess), string(AuditFailure)},
+	reflect.TypeOf(AnomalyKind("")): {
+		string(AnomalyDeadPid), string(AnomalyMissingBundle), string(AnomalyCorruptState),
+		string(AnomalyIncomplete), string(AnomalyTimeout),
+	},
+}
+
+//go:embed schemas/*.schema.json
+var schemas embed.FS
+
+// Schema returns the JSON Schema of the named document as generated
+// when the runtime was built.
+func Schema(name string) ([]byte, error) {
+	if _, ok := Documents[name]; !ok {
+		return nil, fmt.Errorf("unknown document %q (want one of %s)", name, strings.Join(SchemaNames(), ", "))
+	}
+	return schemas.ReadFile("schemas/" + name + ".schema.json")
+}
+
+// SchemaNames returns the documents with a schema, sorted.
+func SchemaNames() []string {
+	names := make([]string, 0, len(Documents))
+	for name := range Documents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GenerateSchema derives the JSON Schema of the named document from its
+// Go type. Objects allow additional properties, since later versions
+// of a document may add fields.
+func GenerateSchema(name string) ([]byte, error) {
+	doc, ok := Documents[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown document %q", name)
+	}
+
+	schema := schemaFor(reflect.TypeOf(doc))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = name
+	schema["x-schemaVersion"] = SchemaVersion
+
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+type jsonSchema map[string]interface{}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func schemaFor(t reflect.Type) jsonSchema {
+	if values, ok := enums[t]; ok {
+		return jsonSchema{"type": "string", "enum": values}
+	}
+	if t == timeType {
+		return jsonSchema{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaFor(t.Elem())
+	case reflect.String:
+		return jsonSchema{"type": "string"}
+	case reflect.Bool:
+		return jsonSchema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return jsonSchema{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return jsonSchema{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return jsonSchema{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return jsonSchema{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.Map:
+		return jsonSchema{"type": "object", "additionalProperties": schemaFor(t.Elem())}
+	case reflect.Struct:
+		properties := jsonSchema{}
+		required := []string{}
+		addStructFields(t, properties, &required)
+		sort.Strings(required)
+		return jsonSchema{"type": "object", "properties": properties, "required": required}
+	}
+	return jsonSchema{}
+}
+
+// addStructFields adds the JSON fields of t, including those promoted
+// from embedded structs.
+func addStructFields(t reflect.Type, properties jsonSchema, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			addStructFields(field.Type, properties, required)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = schemaFor(field.Type)
+		if !strings.Contains(options, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -0,0 +1,552 @@
+// Package types defines every JSON document the runtime emits, on the
+// command line and over the HTTP API. The documents only ever gain
+// fields; SchemaVersion changes if one is removed or changes meaning.
+package types
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+//go:generate go run ./internal/schemagen
+
+// SchemaVersion is the version of the documents in this package.
+const SchemaVersion = 1
+
+type Status string
+
+const (
+	Created Status = "created"
+	Running Status = "running"
+	// Paused is a running container whose processes are frozen.
+	Paused  Status = "paused"
+	Stopped Status = "stopped"
+)
+
+// CreateMode is what create leaves behind for start to run.
+type CreateMode string
+
+const (
+	// CreateModeStateOnly records state and nothing else: no process
+	// runs until start, the state has pid 0 while created, and every
+	// hook runs at start.
+	CreateModeStateOnly CreateMode = "state-only"
+	// CreateModeFull sets the container process up at create, runs the
+	// prestart, createRuntime and createContainer hooks, and leaves it
+	// blocked on an exec fifo until start releases it.
+	CreateModeFull CreateMode = "full"
+)
+
+// State is the OCI state of a container with runtime-specific additions.
+// It is what the state command prints and what state.json holds.
+type State struct {
+	SchemaVersion        int               `json:"schemaVersion"`
+	ID                   string            `json:"id"`
+	Pid                  int               `json:"pid"`
+	Bundle               string            `json:"bundle"`
+	Status               Status            `json:"status"`
+	Created              time.Time         `json:"created"`
+	Annotations          map[string]string `json:"annotations,omitempty"`
+	OCIVersion           string            `json:"ociVersion"`
+	InitProcessStartTime uint64            `json:"initProcessStartTime,omitempty"`
+	ConfigPath           string            `json:"configPath,omitempty"`
+	ExitStatus           *int              `json:"exitStatus,omitempty"`
+	RestartPolicy        *RestartPolicy    `json:"restartPolicy,omitempty"`
+	RestartCount         int               `json:"restartCount,omitempty"`
+	RestartSuppressed    bool              `json:"restartSuppressed,omitempty"`
+	RootfsQuota          *RootfsQuota      `json:"rootfsQuota,omitempty"`
+	// Namespace is the tenant namespace the container was created in,
+	// not to be confused with its Linux namespaces.
+	Namespace string `json:"namespace,omitempty"`
+	// MonitorPid is the runtime process supervising the container
+	// process, set while it runs.
+	MonitorPid int `json:"monitorPid,omitempty"`
+	// CgroupPath is the container's cgroup, relative to the root of the
+	// cgroup filesystem. It is empty when the container runs without
+	// cgroups.
+	CgroupPath string `json:"cgroupPath,omitempty"`
+	// BootID is the kernel's boot_id when the container process started.
+	// Its pids mean nothing once the host has rebooted.
+	BootID string `json:"bootId,omitempty"`
+	// CreateMode is how the container was created, and so how start
+	// runs it. States from before it was recorded are state-only.
+	CreateMode CreateMode `json:"createMode,omitempty"`
+	// Checkpoint is the container's last checkpoint, for restore to
+	// find.
+	Checkpoint *Checkpoint `json:"checkpoint,omitempty"`
+	// Owner is the host that created a container on a shared root. Only
+	// it acts on the container's processes, which are its own.
+	Owner *Owner `json:"owner,omitempty"`
+	// StartedAt is when the container process last started.
+	StartedAt *time.Time `json:"startedAt,omitempty"`
+	// MaxRuntime bounds how long the container process may run.
+	MaxRuntime *MaxRuntime `json:"maxRuntime,omitempty"`
+	// KilledByTimeout is set when the monitor stopped the container
+	// process for running past MaxRuntime.
+	KilledByTimeout bool `json:"killedByTimeout,omitempty"`
+	// ConsoleSocket is the AF_UNIX socket the master of the container
+	// process's terminal is sent to on every start.
+	ConsoleSocket string `json:"consoleSocket,omitempty"`
+	// ConfigSHA256 is the hex SHA-256 of the config at ConfigPath as the
+	// container was created from it, for comparing with the config there
+	// now.
+	ConfigSHA256 string `json:"configSha256,omitempty"`
+	// FrozenConfigSHA256 is that of the container's own copy of its
+	// config, as create or update last wrote it. Starts refuse a copy
+	// that no longer matches.
+	FrozenConfigSHA256 string `json:"frozenConfigSha256,omitempty"`
+}
+
+// MaxRuntime is a wall-clock limit on the container process, counted
+// from its start, pauses included. When it runs out the monitor sends
+// StopSignal, and SIGKILL once GracePeriod has passed too.
+type MaxRuntime struct {
+	// Limit and GracePeriod are Go durations, such as "90s".
+	Limit       string `json:"limit"`
+	StopSignal  int    `json:"stopSignal"`
+	GracePeriod string `json:"gracePeriod"`
+	// Restart lets the restart policy start a container stopped this
+	// way again, which it doesn't by default.
+	Restart bool `json:"restart,omitempty"`
+}
+
+// Owner identifies the host, and its boot, that owns a container on a
+// root other hosts share.
+type Owner struct {
+	Hostname string `json:"hostname"`
+	BootID   string `json:"bootId,omitempty"`
+}
+
+// Checkpoint records a CRIU dump of a container.
+type Checkpoint struct {
+	// ImagePath is the directory holding the images.
+	ImagePath string    `json:"imagePath"`
+	Created   time.Time `json:"created"`
+	// LeaveRunning is set if the container kept running after the dump.
+	LeaveRunning bool `json:"leaveRunning,omitempty"`
+}
+
+// ListEntry is one container in a listing: its state, with the labels
+// the state leaves out.
+type ListEntry struct {
+	State
+
+	Labels map[string]string `json:"labels,omitempty"`
+	// Runtime is the OCI runtime the container was delegated to, empty
+	// for one the runtime runs itself.
+	Runtime string `json:"runtime,omitempty"`
+}
+
+// Summary is the status document: how many containers a root holds in
+// each status, and what about them looks wrong. Containers whose state
+// can't be read count as unknown.
+type Summary struct {
+	SchemaVersion int `json:"schemaVersion"`
+	Total         int `json:"total"`
+	Created       int `json:"created"`
+	Running       int `json:"running"`
+	Paused        int `json:"paused"`
+	Stopped       int `json:"stopped"`
+	Unknown       int `json:"unknown"`
+	// Anomalies are in ID order; a healthy root has none.
+	Anomalies []Anomaly `json:"anomalies"`
+}
+
+// AnomalyKind is what is wrong with a container in a Summary.
+type AnomalyKind string
+
+const (
+	// AnomalyDeadPid is a container recorded as alive whose process is
+	// gone without a monitor left to record its exit.
+	AnomalyDeadPid AnomalyKind = "dead-pid"
+	// AnomalyMissingBundle is a container whose bundle was removed.
+	AnomalyMissingBundle AnomalyKind = "missing-bundle"
+	// AnomalyCorruptState is a container whose state can't be read.
+	AnomalyCorruptState AnomalyKind = "corrupt-state"
+	// AnomalyIncomplete is a directory a create or delete left without
+	// state.
+	AnomalyIncomplete AnomalyKind = "incomplete"
+	// AnomalyTimeout is a container that wasn't checked in time.
+	AnomalyTimeout AnomalyKind = "timeout"
+)
+
+type Anomaly struct {
+	ID     string      `json:"id"`
+	Kind   AnomalyKind `json:"kind"`
+	Detail string      `json:"detail,omitempty"`
+}
+
+// Restart policy names.
+const (
+	RestartNo        = "no"
+	RestartAlways    = "always"
+	RestartOnFailure = "on-failure"
+)
+
+// RestartPolicy decides whether the monitor starts the container process
+// again after it exits. MaxRetries bounds on-failure restarts; zero means
+// unlimited.
+type RestartPolicy struct {
+	Name       string `json:"name"`
+	MaxRetries int    `json:"maxRetries,omitempty"`
+}
+
+func (p *RestartPolicy) String() string {
+	if p.Name == RestartOnFailure && p.MaxRetries > 0 {
+		return fmt.Sprintf("%s:%d", p.Name, p.MaxRetries)
+	}
+	return p.Name
+}
+
+// RootfsQuota describes the project quota bounding writes to the rootfs.
+type RootfsQuota struct {
+	ProjectID  uint32 `json:"projectId"`
+	LimitBytes uint64 `json:"limitBytes"`
+	UsedBytes  uint64 `json:"usedBytes,omitempty"`
+}
+
+// InspectInfo is the detailed view of a container printed by inspect. It
+// embeds the state and adds runtime-specific detail.
+type InspectInfo struct {
+	State
+
+	Namespaces map[specs.LinuxNamespaceType]NamespaceInfo `json:"namespaces"`
+
+	// Hostname is the hostname the running container sees, read from
+	// its UTS namespace, whatever the config set or it changed to since.
+	Hostname string `json:"hostname,omitempty"`
+
+	// Rootfs is the container's root filesystem on the host, as hooks
+	// are told it.
+	Rootfs string `json:"rootfs,omitempty"`
+
+	CPU *CPUInfo `json:"cpu,omitempty"`
+
+	// Mounts compares the mounts the running container has with those
+	// its spec asked for. Devices are the device nodes under its /dev.
+	// Both are read from the live container, so only set while it runs.
+	Mounts  []MountInfo  `json:"mounts,omitempty"`
+	Devices []DeviceInfo `json:"devices,omitempty"`
+
+	// Resources are the cgroup limits the container gets, as created or
+	// as last updated, without the device rules.
+	Resources *specs.LinuxResources `json:"resources,omitempty"`
+
+	// Process is the process the container runs, with the overrides
+	// given at create applied and the env as the process receives it.
+	Process *ProcessInfo `json:"process,omitempty"`
+
+	// FinalStats is the usage recorded when the container last exited.
+	FinalStats *Stats `json:"finalStats,omitempty"`
+
+	// Footprint is what the runtime itself costs for the container.
+	Footprint *Footprint `json:"footprint,omitempty"`
+
+	// Labels are the runtime labels set at create. Unlike annotations
+	// they come from whoever created the container, not the bundle.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// CreateOptions is how the container was created. Containers created
+	// before it was recorded have none.
+	CreateOptions *CreateOptions `json:"createOptions,omitempty"`
+}
+
+// VersionInfo is what version --format json prints. Commit and
+// BuildDate are empty for a build that wasn't stamped with them.
+// BuildDate is that of the commit for a build from a git checkout.
+type VersionInfo struct {
+	Version    string `json:"version"`
+	Commit     string `json:"commit,omitempty"`
+	BuildDate  string `json:"buildDate,omitempty"`
+	OCIVersion string `json:"ociVersion"`
+	GoVersion  string `json:"goVersion"`
+}
+
+// CreateOptions records how a container was created: the runtime, the
+// config and the overrides applied on top of it. The values of env
+// overrides read "<redacted>", in Env and in CommandLine, unless the
+// creator asked to keep them; those of SensitiveEnv always do.
+type CreateOptions struct {
+	RuntimeVersion string `json:"runtimeVersion"`
+	// CommandLine is the command that created the container, if it was
+	// created from the command line.
+	CommandLine  []string `json:"commandLine,omitempty"`
+	Bundle       string   `json:"bundle"`
+	ConfigPath   string   `json:"configPath"`
+	ConfigSHA256 string   `json:"configSha256"`
+	// CloneOf is the container whose config was copied, for a clone.
+	CloneOf   string `json:"cloneOf,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+
+	Args        []string `json:"args,omitempty"`
+	ReplaceArgs bool     `json:"replaceArgs,omitempty"`
+	Env         []string `json:"env,omitempty"`
+	// EnvFiles are the env files Env was read from in part.
+	EnvFiles []string `json:"envFiles,omitempty"`
+	// SensitiveEnv names the variables kept out of the frozen config.
+	SensitiveEnv []string          `json:"sensitiveEnv,omitempty"`
+	Cwd          string            `json:"cwd,omitempty"`
+	User         string            `json:"user,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+
+	CgroupParent string     `json:"cgroupParent,omitempty"`
+	CgroupPolicy string     `json:"cgroupPolicy,omitempty"`
+	Rootless     string     `json:"rootless,omitempty"`
+	CreateMode   CreateMode `json:"createMode,omitempty"`
+
+	RestartPolicy   *RestartPolicy `json:"restartPolicy,omitempty"`
+	RootfsSizeBytes uint64         `json:"rootfsSizeBytes,omitempty"`
+	// RootfsFD is set when the rootfs was a directory the creator opened.
+	RootfsFD bool `json:"rootfsFd,omitempty"`
+	// Security lists the confinement weakened for debugging.
+	Security        string      `json:"security,omitempty"`
+	OwnerFixupAllow []string    `json:"ownerFixupAllow,omitempty"`
+	MaxRuntime      *MaxRuntime `json:"maxRuntime,omitempty"`
+	StrictSpec      bool        `json:"strictSpec,omitempty"`
+	// NoPivotRoot is set when the root is made with chroot instead of
+	// pivot_root.
+	NoPivotRoot bool `json:"noPivotRoot,omitempty"`
+	// ConsoleSocket is where the terminal's master is sent.
+	ConsoleSocket string `json:"consoleSocket,omitempty"`
+	// SystemdCgroup is set when systemd created the container's cgroup.
+	SystemdCgroup bool `json:"systemdCgroup,omitempty"`
+}
+
+// Footprint is the runtime's own overhead for one container, on top of
+// the container's processes. The monitor fields are only set while a
+// monitor runs.
+type Footprint struct {
+	MonitorPid      int    `json:"monitorPid,omitempty"`
+	MonitorRSSBytes uint64 `json:"monitorRssBytes,omitempty"`
+	MonitorFDs      int    `json:"monitorFds,omitempty"`
+	MonitorCgroup   string `json:"monitorCgroup,omitempty"`
+	// PinnedNamespaceMounts counts the nsfs mounts on the host that keep
+	// a namespace the container created alive after it exits.
+	PinnedNamespaceMounts int `json:"pinnedNamespaceMounts"`
+	// LogBytes maps the container's log files to their sizes.
+	LogBytes map[string]int64 `json:"logBytes"`
+}
+
+// FootprintReport is the runtime's overhead across a root, as printed
+// by gc --report.
+type FootprintReport struct {
+	SchemaVersion int                  `json:"schemaVersion"`
+	Containers    []ContainerFootprint `json:"containers"`
+	Total         FootprintTotal       `json:"total"`
+}
+
+// ContainerFootprint is the footprint of one container in a report.
+type ContainerFootprint struct {
+	ID        string `json:"id"`
+	Namespace string `json:"namespace,omitempty"`
+	Footprint
+}
+
+// FootprintTotal sums the footprints of a report. EventsLogBytes is
+// kept apart, since events logs belong to a namespace rather than a
+// container.
+type FootprintTotal struct {
+	Containers            int    `json:"containers"`
+	Monitors              int    `json:"monitors"`
+	MonitorRSSBytes       uint64 `json:"monitorRssBytes"`
+	MonitorFDs            int    `json:"monitorFds"`
+	PinnedNamespaceMounts int    `json:"pinnedNamespaceMounts"`
+	LogBytes              int64  `json:"logBytes"`
+	EventsLogBytes        int64  `json:"eventsLogBytes"`
+}
+
+// ProcessInfo describes the container process.
+type ProcessInfo struct {
+	Args           []string `json:"args"`
+	Env            []string `json:"env"`
+	Cwd            string   `json:"cwd"`
+	UID            uint32   `json:"uid"`
+	GID            uint32   `json:"gid"`
+	AdditionalGids []uint32 `json:"additionalGids,omitempty"`
+}
+
+// CPU affinity modes.
+const (
+	CPUAffinityInherit = "inherit"
+	CPUAffinityReset   = "reset"
+)
+
+// CPUInfo describes the CPU settings of a container. BurstUsec and
+// Affinity are read from the live container and only set while it runs.
+type CPUInfo struct {
+	AffinityMode string  `json:"affinityMode"`
+	Affinity     string  `json:"affinity,omitempty"`
+	BurstUsec    *uint64 `json:"burstUsec,omitempty"`
+}
+
+// Namespace modes.
+const (
+	NamespaceCreated = "created"
+	NamespaceJoined  = "joined"
+)
+
+// NamespaceInfo describes one namespace of a container. Inode and Device
+// identify the live namespace and are only set while the container
+// process exists, so two containers sharing a namespace report the same
+// pair.
+type NamespaceInfo struct {
+	Mode   string `json:"mode"`
+	Path   string `json:"path,omitempty"`
+	Inode  uint64 `json:"inode,omitempty"`
+	Device uint64 `json:"device,omitempty"`
+	Known  bool   `json:"known"`
+}
+
+// MountStatus is how a mount compares with the spec.
+type MountStatus string
+
+const (
+	// MountApplied is a mount the spec asked for that the container has.
+	MountApplied MountStatus = "applied"
+	// MountMissing is a mount the spec asked for that the container
+	// doesn't have, or has had replaced with another filesystem type.
+	MountMissing MountStatus = "missing"
+	// MountExtra is a mount the container has that the spec didn't ask
+	// for, such as one a hook or the container itself added.
+	MountExtra MountStatus = "extra"
+)
+
+// MountInfo is a mount of a running container, or one its spec asked
+// for that it lacks. Applied and extra mounts are described as the
+// kernel reports them, with the per-mount and the filesystem options
+// merged; missing ones as the spec gave them. Root is the directory of
+// the source filesystem that is mounted, when that isn't its root, as
+// for a bind mount. Propagation is shared, slave, private or unbindable,
+// or "shared,slave" for a slave that propagates on.
+type MountInfo struct {
+	Destination string      `json:"destination"`
+	Source      string      `json:"source,omitempty"`
+	Root        string      `json:"root,omitempty"`
+	Type        string      `json:"type,omitempty"`
+	Options     []string    `json:"options,omitempty"`
+	Propagation string      `json:"propagation,omitempty"`
+	Status      MountStatus `json:"status"`
+}
+
+// DeviceInfo is a device node under a running container's /dev. Type is
+// "c" or "b", as in the spec's devices.
+type DeviceInfo struct {
+	Path     string      `json:"path"`
+	Type     string      `json:"type"`
+	Major    int64       `json:"major"`
+	Minor    int64       `json:"minor"`
+	FileMode os.FileMode `json:"fileMode"`
+	UID      uint32      `json:"uid"`
+	GID      uint32      `json:"gid"`
+}
+
+// Event is one lifecycle event, a line of the events log and of the
+// events stream.
+type Event struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	Type          string    `json:"type"`
+	ID            string    `json:"id"`
+	Timestamp     time.Time `json:"timestamp"`
+	// Labels are the container's, so consumers can route by them.
+	Labels map[string]string `json:"labels,omitempty"`
+	Data   map[string]string `json:"data,omitempty"`
+}
+
+// AuditResult is how an audited operation ended.
+type AuditResult string
+
+const (
+	AuditSuccess AuditResult = "success"
+	AuditFailure AuditResult = "failure"
+)
+
+// AuditRecord is one line of the audit log: an operation on a
+// container, who asked for it and how it ended.
+type AuditRecord struct {
+	SchemaVersion int         `json:"schemaVersion"`
+	Timestamp     time.Time   `json:"timestamp"`
+	Operation     string      `json:"operation"`
+	ID            string      `json:"id"`
+	Namespace     string      `json:"namespace,omitempty"`
+	Result        AuditResult `json:"result"`
+	// ErrorKind names the kind of error a failure matched, such as
+	// not-exist or invalid-state, and is "other" for the rest.
+	ErrorKind string `json:"errorKind,omitempty"`
+	Error     string `json:"error,omitempty"`
+	// UID, GID and PID are the caller's: the runtime's own for the CLI,
+	// the peer's for the API.
+	UID int `json:"uid"`
+	GID int `json:"gid"`
+	PID int `json:"pid"`
+	// Args are the operation's salient arguments, such as the bundle
+	// of a create or the signal of a kill.
+	Args map[string]string `json:"args,omitempty"`
+}
+
+// Stats is the resource usage of a container's cgroup. Counters the
+// host's cgroup setup doesn't provide are left out.
+type Stats struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	ID            string    `json:"id"`
+	Timestamp     time.Time `json:"timestamp"`
+	// Final is set for the usage recorded when the container process
+	// exited.
+	Final bool `json:"final,omitempty"`
+
+	CPUUsageUsec     *uint64 `json:"cpuUsageUsec,omitempty"`
+	MemoryUsageBytes *uint64 `json:"memoryUsageBytes,omitempty"`
+	MemoryPeakBytes  *uint64 `json:"memoryPeakBytes,omitempty"`
+	// MemoryLimitBytes is the cgroup's memory limit, the largest uint64
+	// if there is none.
+	MemoryLimitBytes *uint64 `json:"memoryLimitBytes,omitempty"`
+	OOMKills         *uint64 `json:"oomKills,omitempty"`
+	PidsCurrent      *uint64 `json:"pidsCurrent,omitempty"`
+	PidsPeak         *uint64 `json:"pidsPeak,omitempty"`
+}
+
+// StatsEvent is one line of the stats stream of events <id>. It is
+// shaped like runc's stats events, so consumers written for runc, such
+// as containerd, can parse it; unlike the other documents it carries no
+// schemaVersion.
+type StatsEvent struct {
+	Type string          `json:"type"`
+	ID   string          `json:"id"`
+	Data *StatsEventData `json:"data,omitempty"`
+}
+
+// StatsEventData is the usage in a StatsEvent, in runc's units: CPU time
+// in nanoseconds, memory in bytes.
+type StatsEventData struct {
+	CPU    StatsEventCPU    `json:"cpu"`
+	Memory StatsEventMemory `json:"memory"`
+	Pids   StatsEventPids   `json:"pids"`
+}
+
+type StatsEventCPU struct {
+	Usage struct {
+		Total uint64 `json:"total,omitempty"`
+	} `json:"usage"`
+}
+
+type StatsEventMemory struct {
+	Usage struct {
+		// Limit is the largest uint64 when there is none.
+		Limit uint64 `json:"limit"`
+		Usage uint64 `json:"usage,omitempty"`
+		// Max is the peak usage.
+		Max uint64 `json:"max,omitempty"`
+	} `json:"usage"`
+}
+
+type StatsEventPids struct {
+	Current uint64 `json:"current,omitempty"`
+}
+
+// Error is the body of every failed API request.
+type Error struct {
+	Error string `json:"error"`
+}
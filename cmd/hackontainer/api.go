@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"github.com/zakarynichols/hackontainer/api/server"
+)
+
+// runAPI serves the HTTP control API until SIGTERM or SIGINT.
+func runAPI() error {
+	root, err := stateRoot()
+	if err != nil {
+		return err
+	}
+
+	listen := findFlag("listen")
+	if listen == "" {
+		listen = "unix://" + filepath.Join(root, "api.sock")
+	}
+
+	allowedUID := -1
+	if uid := findFlag("allow-uid"); uid != "" {
+		n, err := strconv.Atoi(uid)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid --allow-uid %q", uid)
+		}
+		allowedUID = n
+	}
+
+	factory, err := newFactory()
+	if err != nil {
+		return fmt.Errorf("failed to create factory: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	fmt.Fprintf(os.Stderr, "serving API on %s\n", listen)
+	return server.New(factory, root, allowedUID).ListenAndServe(ctx, listen)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/zakarynichols/hackontainer/libcontainer"
+)
+
+// runCheckpoint dumps a running container with criu.
+func runCheckpoint() error {
+	args := getArgsAfter(0)
+	if len(args) != 1 {
+		return fmt.Errorf("need exactly 1 argument, got %d", len(args))
+	}
+	containerID := args[0]
+
+	imagePath, err := pathFlag("image-path")
+	if err != nil {
+		return err
+	}
+	workPath, err := pathFlag("work-path")
+	if err != nil {
+		return err
+	}
+	opts := libcontainer.CheckpointOptions{
+		ImagePath:      imagePath,
+		WorkPath:       workPath,
+		LeaveRunning:   hasFlag("leave-running"),
+		TCPEstablished: hasFlag("tcp-established"),
+		CriuPath:       criuPath,
+	}
+
+	factory, err := newFactory()
+	if err != nil {
+		return fmt.Errorf("failed to create factory: %w", err)
+	}
+
+	var flags []string
+	if opts.ImagePath != "" {
+		flags = append(flags, "--image-path", opts.ImagePath)
+	}
+	if opts.WorkPath != "" {
+		flags = append(flags, "--work-path", opts.WorkPath)
+	}
+	if opts.LeaveRunning {
+		flags = append(flags, "--leave-running")
+	}
+	if opts.TCPEstablished {
+		flags = append(flags, "--tcp-established")
+	}
+	if delegated, err := routeDelegated(factory, "checkpoint", containerID, append(flags, containerID)...); delegated || err != nil {
+		return err
+	}
+
+	container, err := factory.Load(containerID)
+	if err != nil {
+		return fmt.Errorf("failed to load container: %w", err)
+	}
+	if err := container.Checkpoint(opts); err != nil {
+		return fmt.Errorf("failed to checkpoint container: %w", err)
+	}
+	return nil
+}
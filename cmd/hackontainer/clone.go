@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/zakarynichols/hackontainer/libcontainer"
+)
+
+// runClone creates a container from the config frozen for an existing
+// one, with create's overrides applied on top, as create does with a
+// bundle's config. Without --bundle the clone uses the source's bundle.
+func runClone() error {
+	args := getArgsAfter(0)
+	if len(args) != 2 {
+		return fmt.Errorf("need a source container id and a new container id, got %d arguments", len(args))
+	}
+	preserveOpt, err := preservedFDsOption()
+	if err != nil {
+		return err
+	}
+	sourceID, containerID := args[0], args[1]
+	if findFlag("config") != "" {
+		return fmt.Errorf("--config can't be used with clone, which copies the config of %s", sourceID)
+	}
+
+	opts, err := createOptionsFromFlags(preserveOpt)
+	if err != nil {
+		return err
+	}
+	modeOpts, err := createModeOptions(preserveOpt)
+	if err != nil {
+		return err
+	}
+	opts = append([]libcontainer.CreateOption{libcontainer.WithCloneSource(sourceID)}, append(opts, modeOpts...)...)
+
+	pidFile, err := pathFlag("pid-file")
+	if err != nil {
+		return err
+	}
+
+	factory, err := newFactory()
+	if err != nil {
+		return fmt.Errorf("failed to create factory: %w", err)
+	}
+
+	ctx, cancel, err := timeoutContext()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	// The bundle is left to the source's unless given
+	bundle, err := pathFlag("bundle")
+	if err != nil {
+		return err
+	}
+	container, err := createContainer(ctx, factory, containerID, bundle, opts)
+	if err != nil {
+		return fmt.Errorf("failed to clone container %s: %w", sourceID, err)
+	}
+
+	if pidFile != "" {
+		state, err := container.State()
+		if err != nil {
+			return fmt.Errorf("failed to get container state: %w", err)
+		}
+		if err := os.WriteFile(pidFile, []byte(fmt.Sprintf("%d", state.Pid)), 0644); err != nil {
+			return fmt.Errorf("failed to write PID file: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zakarynichols/hackontainer/libcontainer"
+)
+
+// debugAnnotation marks containers created by the debug command.
+const debugAnnotation = "org.hackontainer.debug"
+
+// runDebug builds the container's namespaces and rootfs exactly as run
+// would, replaces the process with a shell, or the command after "--",
+// and removes the container once it exits. The target is an existing
+// container if one has that ID, and a bundle directory only otherwise.
+func runDebug() error {
+	args := getArgsAfter(0)
+	if len(args) != 1 {
+		return fmt.Errorf("need exactly 1 argument, got %d", len(args))
+	}
+
+	target := args[0]
+	bundle, err := bundleFlag()
+	if err != nil {
+		return err
+	}
+	ephemeral := hasFlag("ephemeral-id")
+
+	shell := []string{"/bin/sh"}
+	if end := argsEnd(); end < len(os.Args) {
+		shell = os.Args[end+1:]
+		if len(shell) == 0 {
+			return fmt.Errorf("no command after --")
+		}
+	}
+
+	opts := []libcontainer.CreateOption{
+		libcontainer.WithProcessArgs(shell...),
+		libcontainer.WithReplaceArgs(),
+		libcontainer.WithAnnotation(debugAnnotation, "true"),
+	}
+
+	factory, err := newFactory()
+	if err != nil {
+		return fmt.Errorf("failed to create factory: %w", err)
+	}
+
+	// A container is looked up first, so a directory in the working
+	// directory named like it can't stand in for it
+	containerID := target
+	if _, err := factory.Load(target); err == nil {
+		if !ephemeral {
+			return fmt.Errorf("container id '%s' already exists; pass --ephemeral-id to debug it under a new id", target)
+		}
+		// Debug an existing container from the config it was created
+		// with, as clone copies it: with the real values of its
+		// sensitive variables, and a cgroup of its own
+		bundle = ""
+		opts = append(opts, libcontainer.WithCloneSource(target))
+	} else if isBundleDir(target) {
+		// A bundle has no ID of its own to reuse
+		bundle = target
+		containerID = ""
+		ephemeral = true
+	}
+
+	if ephemeral {
+		suffix, err := randomSuffix()
+		if err != nil {
+			return err
+		}
+		if containerID == "" {
+			containerID = "debug-" + suffix
+		} else {
+			containerID = containerID + "-debug-" + suffix
+		}
+	}
+
+	container, err := factory.Create(containerID, bundle, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to create container: %w", err)
+	}
+	defer container.Delete()
+
+	fmt.Fprintf(os.Stderr, "debug container %s: running %s\n", containerID, strings.Join(shell, " "))
+	if err := container.Run(); err != nil {
+		return fmt.Errorf("failed to run container: %w", err)
+	}
+
+	return nil
+}
+
+func isBundleDir(path string) bool {
+	info, err := os.Stat(filepath.Join(path, "config.json"))
+	return err == nil && info.Mode().IsRegular()
+}
+
+func randomSuffix() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate container id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/zakarynichols/hackontainer/libcontainer"
+)
+
+// delegateFlags are the create and run flags that make sense for a
+// delegated container: --label stays with the record, the rest are
+// handed to the delegate. Anything else is the runtime's own and is
+// refused rather than dropped.
+var delegateFlags = map[string]bool{
+	"bundle": true, "b": true, "pid-file": true, "console-socket": true,
+	"label": true, "delegate-runtime": true,
+	// Global flags, which may follow the command
+	"root": true, "rootless": true, "cgroups": true, "namespace": true,
+}
+
+// delegateRuntime returns the runtime create or run hands the container
+// to: --delegate-runtime, or the bundle's annotation. A config that
+// can't be read delegates nothing; the runtime's own create reports it.
+func delegateRuntime(bundle string) string {
+	if runtime := findFlag("delegate-runtime"); runtime != "" {
+		return runtime
+	}
+	// An unusable --config is reported by the runtime's own create
+	configPath, _ := pathFlag("config")
+	if configPath == "" {
+		configPath = filepath.Join(bundle, "config.json")
+	}
+	runtime, _ := libcontainer.BundleDelegateRuntime(configPath)
+	return runtime
+}
+
+// checkDelegateFlags refuses the flags of command a delegate wouldn't
+// honour.
+func checkDelegateFlags(command, runtime string) error {
+	if noHooks {
+		return fmt.Errorf("--no-hooks can't be enforced on the delegate runtime %s", runtime)
+	}
+	if argsEnd() < len(os.Args) {
+		return fmt.Errorf("a command after -- can't be passed to the delegate runtime %s", runtime)
+	}
+	started := false
+	for _, arg := range os.Args[1:] {
+		if arg == command && !started {
+			started = true
+			continue
+		}
+		if !started || !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		// The runtime's own, not passed on
+		if _, global := globalFlags["--"+name]; global {
+			continue
+		}
+		if !delegateFlags[name] {
+			return fmt.Errorf("--%s is not supported with the delegate runtime %s", name, runtime)
+		}
+	}
+	return nil
+}
+
+// runDelegatedCreate is create or run, as command, for a container
+// runtime runs. The delegation is recorded first, so later commands find
+// the container, and given up again if the delegate no longer knows the
+// container afterwards: a create that failed, or a run the delegate
+// deleted the container after.
+func runDelegatedCreate(command, id, bundle, runtime string) error {
+	if err := checkDelegateFlags(command, runtime); err != nil {
+		return err
+	}
+	factory, err := newFactory()
+	if err != nil {
+		return fmt.Errorf("failed to create factory: %w", err)
+	}
+	d, err := factory.Delegate(id, bundle, runtime, labelOptions()...)
+	if err != nil {
+		return fmt.Errorf("failed to create container: %w", err)
+	}
+
+	// The delegate gets absolute paths, since it needn't share the
+	// runtime's working directory
+	args := []string{command, "--bundle", d.Bundle}
+	for _, flag := range []string{"pid-file", "console-socket"} {
+		path, err := pathFlag(flag)
+		if err != nil {
+			return err
+		}
+		if path != "" {
+			args = append(args, "--"+flag, path)
+		}
+	}
+	err = runDelegate(d, append(args, id)...)
+	if _, stateErr := d.State(); stateErr != nil {
+		if undoErr := factory.Undelegate(id); undoErr != nil && err == nil {
+			return fmt.Errorf("failed to remove the delegation: %w", undoErr)
+		}
+	}
+	return err
+}
+
+// runDelegate runs the delegate with args, on the runtime's own stdio.
+// Its exit code is passed through as it is, after whatever it printed.
+func runDelegate(d *libcontainer.Delegation, args ...string) error {
+	cmd := d.Command(args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+		return containerExit(exitErr.ExitCode())
+	}
+	if err != nil {
+		return fmt.Errorf("delegate runtime %s %s: %w", d.Runtime, args[0], err)
+	}
+	return nil
+}
+
+// routeDelegated runs command on the delegate when container id was
+// delegated, reporting whether it was. args follow the command, in the
+// delegate's syntax.
+func routeDelegated(factory libcontainer.Factory, command, id string, args ...string) (bool, error) {
+	d, err := factory.Delegation(id)
+	if err != nil || d == nil {
+		return false, err
+	}
+	err = runDelegate(d, append([]string{command}, args...)...)
+	// The delegate removed its container; so goes the record
+	if command == "delete" && (err == nil || hasFlag("force")) {
+		if undoErr := factory.Undelegate(id); undoErr != nil && err == nil {
+			err = fmt.Errorf("failed to remove the delegation: %w", undoErr)
+		}
+	}
+	return true, err
+}
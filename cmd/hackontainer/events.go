@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/zakarynichols/hackontainer/api/types"
+	"github.com/zakarynichols/hackontainer/libcontainer"
+)
+
+// defaultStatsInterval is how often events <id> reports usage.
+const defaultStatsInterval = 5 * time.Second
+
+// runEvents prints lifecycle events of every container under --root as
+// JSON lines, or given a container, its usage.
+func runEvents() error {
+	if !hasFlag("all") {
+		return runStatsEvents()
+	}
+	if args := getArgsAfter(0); len(args) != 0 {
+		return fmt.Errorf("events --all takes no arguments, got %d", len(args))
+	}
+
+	opts := libcontainer.EventsOptions{Follow: hasFlag("follow")}
+	if since := findFlag("since"); since != "" {
+		t, err := parseSince(since)
+		if err != nil {
+			return err
+		}
+		opts.Since = t
+	}
+	if filters := findFlags("filter"); len(filters) > 0 {
+		match, err := parseFilters(filters)
+		if err != nil {
+			return err
+		}
+		opts.Match = func(event libcontainer.Event) bool {
+			return match(event.ID, event.Labels)
+		}
+	}
+
+	root, err := stateRoot()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	encoder := json.NewEncoder(stdout)
+	err = libcontainer.StreamEvents(ctx, root, opts, func(event libcontainer.Event) error {
+		return encoder.Encode(event)
+	})
+	// A followed stream ends when its reader goes away, as it does on a
+	// signal; only a one-shot listing is cut short by it
+	if opts.Follow && errors.Is(err, errBrokenPipe) {
+		return nil
+	}
+	return err
+}
+
+// runStatsEvents prints a stats event for a container every --interval
+// until it stops, or with --stats one and exits.
+func runStatsEvents() error {
+	args := getArgsAfter(0)
+	if len(args) != 1 {
+		return fmt.Errorf("events needs a container ID or --all")
+	}
+	containerID := args[0]
+	if hasFlag("follow") || findFlag("since") != "" || len(findFlags("filter")) > 0 {
+		return fmt.Errorf("--follow, --since and --filter select lifecycle events and need --all")
+	}
+	interval := defaultStatsInterval
+	if value := findFlag("interval"); value != "" {
+		d, err := parseInterval(value)
+		if err != nil {
+			return err
+		}
+		interval = d
+	}
+	snapshot := hasFlag("stats")
+
+	factory, err := newFactory()
+	if err != nil {
+		return fmt.Errorf("failed to create factory: %w", err)
+	}
+
+	delegateArgs := []string{"--interval", interval.String(), containerID}
+	if snapshot {
+		delegateArgs = append([]string{"--stats"}, delegateArgs...)
+	}
+	if delegated, err := routeDelegated(factory, "events", containerID, delegateArgs...); delegated || err != nil {
+		return err
+	}
+
+	container, err := factory.Load(containerID)
+	if err != nil {
+		return fmt.Errorf("failed to load container: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	encoder := json.NewEncoder(stdout)
+	for {
+		stats, err := container.Stats()
+		if err != nil {
+			// The stream ends with the container, whether it stopped or
+			// was deleted and its cgroup with it
+			if !snapshot && (errors.Is(err, libcontainer.ErrNotRunning) || errors.Is(err, os.ErrNotExist)) {
+				return nil
+			}
+			return fmt.Errorf("failed to get stats: %w", err)
+		}
+		if err := encoder.Encode(statsEvent(stats)); err != nil {
+			if !snapshot && errors.Is(err, errBrokenPipe) {
+				return nil
+			}
+			return err
+		}
+		if snapshot {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// statsEvent puts stats in runc's stats event shape.
+func statsEvent(stats *libcontainer.Stats) types.StatsEvent {
+	value := func(n *uint64) uint64 {
+		if n == nil {
+			return 0
+		}
+		return *n
+	}
+
+	data := &types.StatsEventData{}
+	data.CPU.Usage.Total = value(stats.CPUUsageUsec) * 1000
+	data.Memory.Usage.Usage = value(stats.MemoryUsageBytes)
+	data.Memory.Usage.Max = value(stats.MemoryPeakBytes)
+	data.Memory.Usage.Limit = value(stats.MemoryLimitBytes)
+	data.Pids.Current = value(stats.PidsCurrent)
+	return types.StatsEvent{Type: "stats", ID: stats.ID, Data: data}
+}
+
+// parseInterval accepts a number of seconds or a duration.
+func parseInterval(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if seconds, atoiErr := strconv.Atoi(value); atoiErr == nil {
+		d, err = time.Duration(seconds)*time.Second, nil
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid --interval %q: want a number of seconds or a duration like 500ms", value)
+	}
+	return d, nil
+}
+
+// parseSince accepts an RFC 3339 time or a duration back from now.
+func parseSince(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("invalid --since %q: want an RFC 3339 time or a duration like 10m", value)
+	}
+	return time.Now().Add(-d), nil
+}
+
+// parseFilters parses the id=<glob> and label=<key>[=<value>] filters of
+// events and list, which a container must all match.
+func parseFilters(filters []string) (func(id string, labels map[string]string) bool, error) {
+	var patterns []string
+	var labels []libcontainer.LabelFilter
+	for _, filter := range filters {
+		key, value, ok := strings.Cut(filter, "=")
+		switch {
+		case ok && key == "id":
+			if _, err := path.Match(value, ""); err != nil {
+				return nil, fmt.Errorf("invalid --filter %q: %w", filter, err)
+			}
+			patterns = append(patterns, value)
+		case ok && key == "label":
+			label, err := libcontainer.ParseLabelFilter(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid --filter %q: %w", filter, err)
+			}
+			labels = append(labels, label)
+		default:
+			return nil, fmt.Errorf("invalid --filter %q: want id=<glob> or label=<key>[=<value>]", filter)
+		}
+	}
+	return func(id string, containerLabels map[string]string) bool {
+		for _, pattern := range patterns {
+			if matched, _ := path.Match(pattern, id); !matched {
+				return false
+			}
+		}
+		return libcontainer.MatchLabels(containerLabels, labels)
+	}, nil
+}
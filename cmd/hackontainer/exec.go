@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/zakarynichols/hackontainer/libcontainer"
+)
+
+// execFlags are the value flags of exec, which must come before the
+// container id: everything after it is the command and its arguments.
+var execFlags = map[string]bool{
+	"-e": true, "--env": true, "--workdir": true, "--user": true, "--preserve-fds": true,
+}
+
+// runExec runs a command in a running container and exits with its exit
+// code. Without --user it runs as the container's process.user.
+func runExec() error {
+	process := &specs.Process{}
+	var id, user string
+	var loadOpts []libcontainer.LoadOption
+	i := commandIndex()
+	for i++; i < len(os.Args); i++ {
+		arg := os.Args[i]
+		name, value, hasValue := strings.Cut(arg, "=")
+		if !strings.HasPrefix(arg, "-") {
+			id = arg
+			i++
+			break
+		}
+		// Global flags are parseGlobalFlags'
+		if flag, ok := globalFlags[name]; ok {
+			if flag.value != nil && !hasValue {
+				i++
+			}
+			continue
+		}
+		if !execFlags[name] {
+			return fmt.Errorf("unknown exec flag %q", arg)
+		}
+		if !hasValue {
+			if i+1 >= len(os.Args) {
+				return fmt.Errorf("%s needs a value", arg)
+			}
+			i++
+			value = os.Args[i]
+		}
+		switch name {
+		case "-e", "--env":
+			if !strings.Contains(value, "=") {
+				return fmt.Errorf("invalid env %q, want KEY=VALUE", value)
+			}
+			process.Env = append(process.Env, value)
+		case "--workdir":
+			process.Cwd = value
+		case "--user":
+			user = value
+		case "--preserve-fds":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid --preserve-fds %q", value)
+			}
+			// Taken at once, before exec opens an fd of its own
+			if n > 0 {
+				loadOpts = append(loadOpts, libcontainer.WithPreservedFDs(n))
+			}
+		}
+	}
+	if i < len(os.Args) && os.Args[i] == "--" {
+		i++
+	}
+	if id == "" {
+		return fmt.Errorf("need a container id and a command")
+	}
+	process.Args = os.Args[i:]
+	if len(process.Args) == 0 {
+		return fmt.Errorf("need a command to exec")
+	}
+
+	factory, err := newFactory()
+	if err != nil {
+		return fmt.Errorf("failed to create factory: %w", err)
+	}
+	container, err := factory.Load(id, loadOpts...)
+	if err != nil {
+		return fmt.Errorf("failed to load container: %w", err)
+	}
+
+	if user != "" {
+		if process.User, err = parseExecUser(user); err != nil {
+			return err
+		}
+	} else {
+		info, err := container.Inspect()
+		if err != nil {
+			return fmt.Errorf("failed to inspect container: %w", err)
+		}
+		if info.Process != nil {
+			process.User = specs.User{
+				UID:            info.Process.UID,
+				GID:            info.Process.GID,
+				AdditionalGids: info.Process.AdditionalGids,
+			}
+		}
+	}
+
+	err = container.Exec(process)
+	var exited *libcontainer.ExecExitError
+	if errors.As(err, &exited) {
+		return containerExit(exited.ExitCode)
+	}
+	return err
+}
+
+// parseExecUser parses --user as uid[:gid], where the gid defaults to
+// the uid's.
+func parseExecUser(user string) (specs.User, error) {
+	uidStr, gidStr, hasGID := strings.Cut(user, ":")
+	uid, err := strconv.ParseUint(uidStr, 10, 32)
+	if err != nil {
+		return specs.User{}, fmt.Errorf("invalid user %q, want a numeric uid[:gid]", user)
+	}
+	gid := uid
+	if hasGID {
+		if gid, err = strconv.ParseUint(gidStr, 10, 32); err != nil {
+			return specs.User{}, fmt.Errorf("invalid user %q, want a numeric uid[:gid]", user)
+		}
+	}
+	return specs.User{UID: uint32(uid), GID: uint32(gid)}, nil
+}
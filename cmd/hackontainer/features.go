@@ -0,0 +1,20 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/zakarynichols/hackontainer/libcontainer"
+)
+
+// runFeatures prints the OCI features document, describing what the
+// runtime supports on this host.
+func runFeatures() error {
+	if args := getArgsAfter(0); len(args) != 0 {
+		return fmt.Errorf("need no arguments, got %d", len(args))
+	}
+
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(libcontainer.Features())
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/zakarynichols/hackontainer/libcontainer"
+)
+
+// runGC deletes the containers left over from before the host rebooted,
+// printing each one, or with --report reports the runtime's own overhead.
+// Either covers the --namespace given, or the containers directly under
+// the root, or with --all-namespaces every namespace.
+func runGC() error {
+	root, err := stateRoot()
+	if err != nil {
+		return err
+	}
+	all, err := allNamespaces()
+	if err != nil {
+		return err
+	}
+
+	if !hasFlag("report") {
+		deleted, err := libcontainer.CollectGarbage(root, all)
+		for _, name := range deleted {
+			if _, err := fmt.Fprintln(stdout, name); err != nil {
+				return err
+			}
+		}
+		return err
+	}
+
+	report, err := libcontainer.ReportFootprint(root, all)
+	if err != nil {
+		return fmt.Errorf("failed to report footprint: %w", err)
+	}
+
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
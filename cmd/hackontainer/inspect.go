@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+func runInspect() error {
+	args := getArgsAfter(0)
+	if len(args) != 1 {
+		return fmt.Errorf("need exactly 1 argument, got %d", len(args))
+	}
+
+	containerID := args[0]
+
+	factory, err := newFactory()
+	if err != nil {
+		return fmt.Errorf("failed to create factory: %w", err)
+	}
+
+	container, err := factory.Load(containerID)
+	if err != nil {
+		return fmt.Errorf("failed to load container: %w", err)
+	}
+
+	info, err := container.Inspect()
+	if err != nil {
+		return fmt.Errorf("failed to inspect container: %w", err)
+	}
+
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(info)
+}
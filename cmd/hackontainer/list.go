@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zakarynichols/hackontainer/libcontainer"
+)
+
+// runList prints the containers under --root, or the --namespace given,
+// as a table, as the JSON list document with --format json, or as bare
+// IDs with --quiet. The table's RUNTIME is "-" for the containers the
+// runtime runs itself. A container that can't be loaded is reported on
+// stderr and left out. With --all-namespaces the containers of every
+// namespace are listed, the table gaining a NAMESPACE column and --quiet
+// printing <namespace>/<id> for those in one.
+func runList() error {
+	if args := getArgsAfter(0); len(args) != 0 {
+		return fmt.Errorf("list takes no arguments, got %d", len(args))
+	}
+	format := findFlag("format")
+	if format == "" {
+		format = "table"
+	}
+	if format != "table" && format != "json" {
+		return fmt.Errorf("invalid --format %q (want table or json)", format)
+	}
+	match, err := parseFilters(findFlags("filter"))
+	if err != nil {
+		return err
+	}
+
+	all, err := allNamespaces()
+	if err != nil {
+		return err
+	}
+
+	factory, err := newFactory()
+	if err != nil {
+		return fmt.Errorf("failed to create factory: %w", err)
+	}
+	list := factory.List
+	if all {
+		list = factory.ListAllNamespaces
+	}
+	listed, err := list()
+	if err != nil {
+		return fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	entries := []libcontainer.ListEntry{}
+	for _, c := range listed {
+		// Whatever the filters, since its labels can't be read
+		if c.Err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: %s: %v\n", path.Join(c.Namespace, c.ID), c.Err)
+			continue
+		}
+		if entry := c.Entry(); match(entry.ID, entry.Labels) {
+			entries = append(entries, entry)
+		}
+	}
+
+	switch {
+	case hasFlag("quiet"):
+		for _, entry := range entries {
+			id := entry.ID
+			if all {
+				id = path.Join(entry.Namespace, entry.ID)
+			}
+			if _, err := fmt.Fprintln(stdout, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	case format == "json":
+		return json.NewEncoder(stdout).Encode(entries)
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+	if all {
+		fmt.Fprint(w, "NAMESPACE\t")
+	}
+	fmt.Fprintln(w, "ID\tPID\tSTATUS\tBUNDLE\tCREATED\tRUNTIME")
+	for _, entry := range entries {
+		pid := entry.Pid
+		if entry.Status == libcontainer.Stopped {
+			pid = 0
+		}
+		runtime := entry.Runtime
+		if runtime == "" {
+			runtime = "-"
+		}
+		if all {
+			ns := entry.Namespace
+			if ns == "" {
+				ns = "-"
+			}
+			fmt.Fprintf(w, "%s\t", ns)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\n", entry.ID, pid, entry.Status, entry.Bundle, entry.Created.Local().Format(time.RFC3339), runtime)
+	}
+	return w.Flush()
+}
@@ -1,59 +1,89 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/zakarynichols/hackontainer/libcontainer"
+	"github.com/zakarynichols/hackontainer/libcontainer/audit"
+	"github.com/zakarynichols/hackontainer/libcontainer/selftest"
 )
 
 var (
-	rootDir     = "/run/hackontainer"
-	rootlessVal = "auto"
+	rootDir       = "/run/hackontainer"
+	rootlessVal   = "auto"
+	noHooks       = false
+	namespace     = ""
+	cgroupsVal    = ""
+	criuPath      = ""
+	sharedRoot    = false
+	systemdCgroup = false
+	auditVal      = "file"
 )
 
+// commands is the set of subcommands main dispatches on.
+var commands = map[string]bool{
+	"create": true, "delete": true, "run": true,
+	"start": true, "state": true, "kill": true,
+	"debug": true, "inspect": true, "monitor": true,
+	"api": true, "events": true, "schema": true,
+	"stats": true, "gc": true, "spec": true,
+	"self-test": true, "exec": true,
+	"pause": true, "resume": true, "list": true,
+	"ps": true, "status": true, "update": true,
+	"checkpoint": true, "restore": true,
+	"features": true, "clone": true,
+}
+
 func findCommand() string {
-	commands := map[string]bool{
-		"create": true, "delete": true, "run": true,
-		"start": true, "state": true, "kill": true,
-	}
-	for _, arg := range os.Args {
-		if commands[arg] {
-			return arg
-		}
+	if i := commandIndex(); i > 0 {
+		return os.Args[i]
 	}
 	return ""
 }
 
-func main() {
-	// Check for --child flag (used by forked child process)
-	childMode := false
-	bundlePath := ""
-	for i, arg := range os.Args {
-		if arg == "--child" {
-			childMode = true
-		}
-		if arg == "--bundle" && i+1 < len(os.Args) {
-			bundlePath = os.Args[i+1]
+// commandIndex returns the index of the command in os.Args, or -1. The
+// value of a global flag is never taken for it.
+func commandIndex() int {
+	for i := 1; i < len(os.Args); i++ {
+		if globalValueFlag(os.Args[i]) {
+			i++
+		} else if commands[os.Args[i]] {
+			return i
 		}
 	}
+	return -1
+}
 
-	if childMode {
-		// Child process: load config and run container setup/exec
-		parseGlobalFlags()
-
-		// Run child setup (this does pivot_root, hostname, exec)
-		err := libcontainer.RunAsChild(bundlePath)
-		if err != nil {
+func main() {
+	// A container's process starts as the runtime itself and becomes the
+	// container's once it's set up
+	if libcontainer.IsInit(os.Args) {
+		err := libcontainer.RunInit(os.Args)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(libcontainer.InitExitCode(err))
+	}
+	// RunInNamespaces runs its binary through the runtime, too
+	if libcontainer.IsNamespaceHelper(os.Args) {
+		err := libcontainer.RunNamespaceHelper(os.Args)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	// So does the self-test, copied into its container
+	if selftest.IsProbe(os.Args) {
+		if err := selftest.RunProbe(os.Args); err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
-		// Never returns - process is replaced by exec
-		return
+		os.Exit(0)
 	}
 
 	if len(os.Args) < 2 {
@@ -63,18 +93,44 @@ func main() {
 
 	// Parse global flags first
 	parseGlobalFlags()
+	resolveWorkDir()
+	root, err := absPath("--root", rootDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	rootDir = root
+
+	// A closed stdout fails writes with EPIPE instead of killing the
+	// runtime with SIGPIPE; see stdout
+	signal.Notify(make(chan os.Signal, 1), syscall.SIGPIPE)
 
 	cmd := findCommand()
 	if cmd == "" {
+		// --help and --version stand in for a command
+		for _, arg := range os.Args[1:] {
+			switch arg {
+			case "-h", "-help", "--help":
+				printUsage()
+				os.Exit(0)
+			case "-v", "-version", "--version":
+				if err := runVersion(); err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+					os.Exit(1)
+				}
+				os.Exit(0)
+			}
+		}
 		fmt.Fprintf(os.Stderr, "unknown command: %s\n", os.Args[1])
 		printUsage()
 		os.Exit(1)
 	}
 
-	var err error
 	switch cmd {
 	case "create":
 		err = runCreate()
+	case "clone":
+		err = runClone()
 	case "delete":
 		err = runDelete()
 	case "run":
@@ -83,14 +139,49 @@ func main() {
 		err = runStart()
 	case "state":
 		err = runState()
+	case "list":
+		err = runList()
+	case "ps":
+		err = runPs()
+	case "status":
+		err = runStatus()
+	case "update":
+		err = runUpdate()
+	case "checkpoint":
+		err = runCheckpoint()
+	case "restore":
+		err = runRestore()
 	case "kill":
 		err = runKill()
-	case "-h", "-help", "--help":
-		printUsage()
-		os.Exit(0)
-	case "-v", "-version", "--version":
-		fmt.Println("hackontainer version 1.0.0")
-		os.Exit(0)
+	case "exec":
+		err = runExec()
+	case "pause":
+		err = runPause()
+	case "resume":
+		err = runResume()
+	case "debug":
+		err = runDebug()
+	case "inspect":
+		err = runInspect()
+	case "api":
+		err = runAPI()
+	case "events":
+		err = runEvents()
+	case "schema":
+		err = runSchema()
+	case "features":
+		err = runFeatures()
+	case "stats":
+		err = runStats()
+	case "gc":
+		err = runGC()
+	case "spec":
+		err = runSpec()
+	case "self-test":
+		err = runSelfTest()
+	case "monitor":
+		// Hidden: started by create or start to supervise the container process
+		err = runMonitor()
 	default:
 		fmt.Fprintf(os.Stderr, "unknown command: %s\n", cmd)
 		printUsage()
@@ -98,47 +189,162 @@ func main() {
 	}
 
 	if err != nil {
+		var exited containerExit
+		if errors.As(err, &exited) {
+			os.Exit(int(exited))
+		}
+		if errors.Is(err, errBrokenPipe) {
+			os.Exit(exitBrokenPipe)
+		}
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		if errors.Is(err, context.DeadlineExceeded) {
+			os.Exit(exitTimeout)
+		}
+		var immediate *libcontainer.ExitedImmediatelyError
+		if errors.As(err, &immediate) {
+			os.Exit(immediate.ExitCode)
+		}
+		// The init stage's own code, as for a process that isn't there
+		var startErr *libcontainer.StartError
+		if errors.As(err, &startErr) && startErr.ExitCode != 0 {
+			os.Exit(startErr.ExitCode)
+		}
 		os.Exit(1)
 	}
 }
 
+// containerExit is returned by a command that exits with the container
+// process's exit code, or a delegate runtime's, which is no error of the
+// runtime's.
+type containerExit int
+
+func (e containerExit) Error() string {
+	return fmt.Sprintf("container exited with code %d", int(e))
+}
+
+// globalFlags are the flags of the runtime as a whole, which can come
+// before or after the command. value is where a flag taking a value
+// stores it, and set where a boolean one records that it was given.
+var globalFlags = map[string]struct {
+	value *string
+	set   *bool
+}{
+	"--root":              {value: &rootDir},
+	"--rootless":          {value: &rootlessVal},
+	"--namespace":         {value: &namespace},
+	"--cgroups":           {value: &cgroupsVal},
+	"--criu":              {value: &criuPath},
+	"--audit":             {value: &auditVal},
+	"--no-hooks":          {set: &noHooks},
+	"--allow-shared-root": {set: &sharedRoot},
+	"--systemd-cgroup":    {set: &systemdCgroup},
+}
+
+// globalValueFlag reports whether arg is a global flag whose value is
+// the next argument.
+func globalValueFlag(arg string) bool {
+	return globalFlags[arg].value != nil
+}
+
+// parseGlobalFlags sets the global flags from the command line, before
+// or after the command, up to a bare "--". Exec's command starts at the
+// first argument after the container id, so for exec only flags before
+// the id count.
 func parseGlobalFlags() {
-	// Parse global flags - can appear before OR after the subcommand
 	// os.Args format: [hackontainer [flags] command [flags] args]
-	// or for init: [hackontainer --root /path init id bundle]
-	i := 1
-	for i < len(os.Args) {
+	cmd := ""
+	for i := 1; i < argsEnd(); i++ {
 		arg := os.Args[i]
-
-		// Check if this is a known command (not a flag)
 		if !strings.HasPrefix(arg, "-") {
-			// If it's a known command, stop parsing global flags
-			if arg == "create" || arg == "delete" || arg == "run" ||
-				arg == "start" || arg == "state" || arg == "kill" || arg == "init" {
-				break
+			if cmd == "" && commands[arg] {
+				cmd = arg
+			} else if cmd == "exec" {
+				return
 			}
-			// If it's not a known command and not a flag, treat as unknown
-			// But keep going to find actual command
+			continue
 		}
-
-		// Parse global flags
-		if arg == "--root" && i+1 < len(os.Args) {
-			rootDir = os.Args[i+1]
-			i += 2
-		} else if strings.HasPrefix(arg, "--root=") {
-			rootDir = strings.TrimPrefix(arg, "--root=")
-			i++
-		} else if arg == "--rootless" && i+1 < len(os.Args) {
-			rootlessVal = os.Args[i+1]
-			i += 2
-		} else if strings.HasPrefix(arg, "--rootless=") {
-			rootlessVal = strings.TrimPrefix(arg, "--rootless=")
-			i++
-		} else {
+		name, value, hasValue := strings.Cut(arg, "=")
+		flag, ok := globalFlags[name]
+		switch {
+		case !ok:
+		case flag.set != nil:
+			*flag.set = true
+		case hasValue:
+			*flag.value = value
+		case i+1 < len(os.Args):
 			i++
+			*flag.value = os.Args[i]
+		}
+	}
+}
+
+// newFactory returns a factory configured from the global flags.
+func newFactory() (libcontainer.Factory, error) {
+	var opts []libcontainer.CreateOption
+	if noHooks {
+		opts = append(opts, libcontainer.WithHooksDisabled())
+	}
+	if sharedRoot {
+		opts = append(opts, libcontainer.WithSharedRoot())
+	}
+	if systemdCgroup {
+		opts = append(opts, libcontainer.WithSystemdCgroup())
+	}
+	if namespace != "" {
+		opts = append(opts, libcontainer.WithNamespace(namespace))
+	}
+	if cgroupsVal != "" {
+		policy, err := libcontainer.ParseCgroupPolicy(cgroupsVal)
+		if err != nil {
+			return nil, err
 		}
+		opts = append(opts, libcontainer.WithCgroupPolicy(policy))
+	}
+	switch auditVal {
+	case "file":
+	case "journald":
+		sink, err := audit.NewJournalSink()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: %v; auditing to the log under --root instead\n", err)
+			break
+		}
+		opts = append(opts, libcontainer.WithAuditSink(sink))
+	case "none":
+		opts = append(opts, libcontainer.WithAuditSink(audit.Discard()))
+	default:
+		return nil, fmt.Errorf("invalid --audit %q (want file, journald or none)", auditVal)
 	}
+	mode, err := libcontainer.ParseRootlessMode(rootlessVal)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, libcontainer.WithRootless(mode))
+	factory, err := libcontainer.New(rootDir, opts...)
+	if errors.Is(err, libcontainer.ErrMissingPrivileges) {
+		return nil, fmt.Errorf("%w; use --rootless=true to run without them", err)
+	}
+	return factory, err
+}
+
+// stateRoot returns the directory holding the containers selected by
+// the global flags: the --namespace directory under --root, if given.
+func stateRoot() (string, error) {
+	return libcontainer.NamespaceRoot(rootDir, namespace)
+}
+
+// allNamespaces reports whether --all-namespaces was given, which only
+// root may, as it crosses every tenant's namespace.
+func allNamespaces() (bool, error) {
+	if !hasFlag("all-namespaces") {
+		return false, nil
+	}
+	if namespace != "" {
+		return false, fmt.Errorf("--all-namespaces and --namespace cannot be combined")
+	}
+	if os.Geteuid() != 0 {
+		return false, fmt.Errorf("--all-namespaces needs root")
+	}
+	return true, nil
 }
 
 func printUsage() {
@@ -146,21 +352,132 @@ func printUsage() {
 	fmt.Println("")
 	fmt.Println("Commands:")
 	fmt.Println("  create <container-id>   create a container")
+	fmt.Println("  clone <container-id> <new-id> [--bundle <path>]")
+	fmt.Println("                          create a container from the config of another, taking create's options on")
+	fmt.Println("                          top of it except --config; the rootfs moves to --bundle, if given")
 	fmt.Println("  delete <container-id>   delete a container")
-	fmt.Println("  run <container-id>      create and run a container")
-	fmt.Println("  start <container-id>    start a created container")
-	fmt.Println("  state <container-id>    get container state")
+	fmt.Println("  run [--keep] [--all] <container-id>")
+	fmt.Println("                          create and run a container, exiting with its exit code; the container is")
+	fmt.Println("                          deleted once its process exits unless --keep. INT, TERM, QUIT, HUP, USR1 and")
+	fmt.Println("                          USR2 are forwarded to the container process, or with --all to all its processes")
+	fmt.Println("  start <container-id>    start a created container; fails with the exit code of one that exits immediately")
+	fmt.Println("  state [--watch] <container-id>")
+	fmt.Println("                          get container state; with --watch, again on every change until it is deleted")
+	fmt.Println("  list [--format table|json] [--quiet] [--all-namespaces] [--filter id=<glob>|label=<key>[=<value>]]...")
+	fmt.Println("                          list the containers; filters are ANDed. --all-namespaces (root only) lists")
+	fmt.Println("                          those of every namespace, with a NAMESPACE column")
+	fmt.Println("  ps [--format table|json] <container-id> [-- <ps options>]")
+	fmt.Println("                          list the processes of a running container, as ps shows them (default -ef) or as pids")
+	fmt.Println("  status                  summarise the containers for health checks as JSON, failing on anomalies")
+	fmt.Println("  update <container-id> [--memory <n>] [--cpu-quota <us>] [--cpu-period <us>] [--cpu-shares <n>]")
+	fmt.Println("         [--pids-limit <n>] [--resources <file|->]")
+	fmt.Println("                          change the limits of a container; --resources takes linux.resources JSON")
+	fmt.Println("  checkpoint <container-id> [--image-path <dir>] [--work-path <dir>] [--leave-running] [--tcp-established]")
+	fmt.Println("                          dump a running container with criu into --image-path (default: its checkpoint")
+	fmt.Println("                          directory), stopping it unless --leave-running")
+	fmt.Println("  restore <container-id> --image-path <dir> [--bundle <path>] [--config <path>] [--work-path <dir>]")
+	fmt.Println("          [--tcp-established] [--pid-file <path>] [--console-socket <path>]")
+	fmt.Println("                          create a container from the bundle and bring it back running from a checkpoint;")
+	fmt.Println("                          a process checkpointed with a terminal gets a new one sent to --console-socket")
 	fmt.Println("  kill <container-id> [signal]  send signal to container")
+	fmt.Println("  exec [-e KEY=VALUE] [--workdir <path>] [--user <uid[:gid]>] [--preserve-fds <n>] <container-id> <cmd> [args...]")
+	fmt.Println("                          run a command in a running container, exiting with its exit code")
+	fmt.Println("  pause <container-id>    freeze every process of a running container")
+	fmt.Println("  resume <container-id>   thaw a paused container")
+	fmt.Println("  debug [--ephemeral-id] <container-id|bundle> [-- <cmd> [args...]]")
+	fmt.Println("                          run a throwaway shell, or cmd, in the container's environment")
+	fmt.Println("  inspect <container-id>  show detailed container information")
+	fmt.Println("  api [--listen unix:///path] [--allow-uid uid]  serve the HTTP control API")
+	fmt.Println("  events --all [--follow] [--since <time|duration>] [--filter id=<glob>|label=<key>[=<value>]]...")
+	fmt.Println("                          print lifecycle events of all containers; filters are ANDed")
+	fmt.Println("  events <container-id> [--interval <seconds|duration>] [--stats]")
+	fmt.Println("                          print a stats event every interval (default 5s) until the container stops,")
+	fmt.Println("                          or with --stats one; the lines parse as runc's")
+	fmt.Println("  stats <container-id> [--final]  show cgroup resource usage, or the usage recorded at exit")
+	fmt.Println("  schema [document]       print the JSON Schema of a document the runtime emits")
+	fmt.Println("  features                print the OCI features document: what the runtime supports on this host")
+	fmt.Println("  spec [--bundle <path>]  write a default config.json, with hardware information masked")
+	fmt.Println("  gc [--all-namespaces]   delete containers left over from before the host rebooted")
+	fmt.Println("  gc --report [--all-namespaces]")
+	fmt.Println("                          report the runtime's own overhead (monitors, pinned namespaces, logs); either")
+	fmt.Println("                          covers --namespace, or with --all-namespaces (root only) every namespace")
+	fmt.Println("  self-test [--bundle-dir <dir>]  run a throwaway container through its lifecycle and check from inside it")
+	fmt.Println("                          that namespaces, mounts, devices and cgroup limits took effect; rootless runs fewer checks")
+	fmt.Println("  --version [--format text|json]")
+	fmt.Println("                          print the runtime's version and build, the OCI runtime spec version it")
+	fmt.Println("                          implements and the Go version it was built with")
+	fmt.Println("  --help                  print this help")
 	fmt.Println("")
 	fmt.Println("Options:")
 	fmt.Println("  --root <path>       root directory for container state (default: /run/hackontainer)")
-	fmt.Println("  --rootless <mode>   ignore cgroup permission errors (default: auto)")
+	fmt.Println("  --rootless <mode>   true runs without root privileges, false checks for them up front, auto probes (default: auto)")
+	fmt.Println("  --no-hooks          refuse to create containers whose config has hooks")
+	fmt.Println("  --namespace <name>  keep containers under <root>/<name>, apart from other namespaces")
+	fmt.Println("  --cgroups <policy>  where containers get cgroups: auto, root, nested (below the runtime's own) or none (default: auto)")
+	fmt.Println("  --systemd-cgroup    have systemd create each container's cgroup as a transient scope; linux.cgroupsPath")
+	fmt.Println("                      is then slice:prefix:name (default: system.slice:hackontainer:<id>)")
+	fmt.Println("  --allow-shared-root manage a root on a network filesystem, which is refused otherwise: containers")
+	fmt.Println("                      created there are locked with lock files and owned by the host that created them")
+	fmt.Println("  --criu <path>       the criu binary checkpoint and restore run (default: criu in PATH)")
+	fmt.Println("  --audit <sink>      where to record who created, started, killed or deleted which container:")
+	fmt.Println("                      file (audit.log under --root), journald or none (default: file)")
+	fmt.Println("")
+	fmt.Println("Create/run options:")
+	fmt.Println("  --bundle <path>     path to the bundle directory (default: .)")
+	fmt.Println("  --config <path>     use an alternate config.json; root.path stays relative to the bundle")
+	fmt.Println("  --pid-file <path>   write the container PID to this file")
+	fmt.Println("  --console-socket <path>  send the terminal of a process with process.terminal set to this AF_UNIX")
+	fmt.Println("                      socket, as the master of a pty; create needs it for such a process")
+	fmt.Println("  --restart <policy>  restart policy: no, always, on-failure[:max] (default: no)")
+	fmt.Println("  --rootfs-size <n>   limit rootfs writes with a project quota (e.g. 1G)")
+	fmt.Println("  --cgroup-parent <path>  put the cgroup below path unless the config sets linux.cgroupsPath (default: /hackontainer)")
+	fmt.Println("  --rootfs-fd <fd>    use the directory open as fd for the rootfs instead of resolving root.path again")
+	fmt.Println("  --strict-spec       reject unknown fields and duplicate keys in the config")
+	fmt.Println("  --no-arch-check     skip refusing a process binary built for another architecture than the host's")
+	fmt.Println("  --no-pivot          make the root with MS_MOVE and chroot instead of pivot_root, for a rootfs on a")
+	fmt.Println("                      ramdisk; a process with CAP_SYS_CHROOT can get out of a chroot")
+	fmt.Println("  --args <arg>        set process.args for a config without them (repeatable)")
+	fmt.Println("  -- <cmd> [args...]  same as --args, for the rest of the command line")
+	fmt.Println("  --replace-args      let --args or -- replace args the config already sets")
+	fmt.Println("  -e, --env KEY=VALUE set an env variable, replacing the config's value (repeatable)")
+	fmt.Println("  --env-file <path>   set the KEY=VALUE lines of a file like -e, before any -e (repeatable)")
+	fmt.Println("  --sensitive-env <keys>  keep the values of env variables, comma-separated, out of the frozen config,")
+	fmt.Println("                      inspect and the record of the create; only start reads them (repeatable)")
+	fmt.Println("  --workdir <path>    set process.cwd")
+	fmt.Println("  --user <uid[:gid]>  set process.user; without a gid the config's gid is kept")
+	fmt.Println("  --security-opt <o>  weaken confinement for debugging: seccomp=unconfined, apparmor=unconfined (repeatable)")
+	fmt.Println("  --cap-add <caps>    grant capabilities, comma-separated, or ALL (repeatable)")
+	fmt.Println("  --owner-fixup-allow <dir>  let owner-fixup bind mounts chown sources below dir (repeatable)")
+	fmt.Println("  --read-only-auto-tmpfs[=exec]  with root.readonly, mount a size-capped tmpfs at /tmp, /run and /var/tmp")
+	fmt.Println("                      unless the config mounts them, as the org.hackontainer.read-only-auto-tmpfs")
+	fmt.Println("                      annotation does; /tmp is noexec unless =exec")
+	fmt.Println("  --label <key=value> label the container for filtering, apart from the config's annotations (repeatable)")
+	fmt.Println("  --record-env-values keep the values of -e overrides in the record of the create inspect shows")
+	fmt.Println("  --timeout <duration>  give up on create, run or start after this long (e.g. 30s), exiting 124")
+	fmt.Println("  --max-runtime <duration>  stop the container once its process has run this long, paused or not,")
+	fmt.Println("                      as the org.hackontainer.max-runtime annotation does; the restart policy")
+	fmt.Println("                      doesn't bring it back unless --restart-on-timeout")
+	fmt.Println("  --stop-signal <sig> the signal --max-runtime stops the container with (default: SIGTERM)")
+	fmt.Println("  --stop-timeout <duration>  how long after the stop signal SIGKILL follows (default: 10s)")
+	fmt.Println("  --delegate-runtime <path>  hand the container to another OCI runtime, as the bundle's")
+	fmt.Println("                      org.hackontainer.delegate-runtime annotation does; create, run, start, kill,")
+	fmt.Println("                      delete and state then run it against a root of its own, passing its errors through")
+	fmt.Println("  --create-mode <m>   create only: state-only records state and runs nothing, with every hook at start;")
+	fmt.Println("                      full sets the process up and runs the create hooks, leaving it waiting for start")
+	fmt.Println("                      (default: state-only, or full with --preserve-fds)")
+	fmt.Println("  --preserve-fds <n>  pass fds 3 to 3+n-1, which must be open, on to the container process;")
+	fmt.Println("                      create then sets the process up in full mode")
+	fmt.Println("")
+	fmt.Println("Kill options:")
+	fmt.Println("  --skip-namespace-check  signal even if the process doesn't match the configured namespaces")
+	fmt.Println("  --all                   signal every process in the container's cgroup, not just its init; without a")
+	fmt.Println("                          cgroup, every process in the process group its init leads")
 }
 
 func findArgAfter(pos int) string {
 	// Skip command name and global flags
 	i := 2
-	for i < len(os.Args) {
+	for i < argsEnd() {
 		arg := os.Args[i]
 		if !strings.HasPrefix(arg, "-") {
 			pos--
@@ -188,11 +505,22 @@ func findArgAfter(pos int) string {
 	return ""
 }
 
+// hasFlag reports whether a boolean flag was given after the command.
+func hasFlag(flag string) bool {
+	for i := 2; i < argsEnd(); i++ {
+		if os.Args[i] == "-"+flag || os.Args[i] == "--"+flag {
+			return true
+		}
+	}
+	return false
+}
+
 func findFlag(flag string) string {
-	for i := 2; i < len(os.Args); i++ {
+	end := argsEnd()
+	for i := 2; i < end; i++ {
 		arg := os.Args[i]
 		if arg == "-"+flag || arg == "--"+flag {
-			if i+1 < len(os.Args) && !strings.HasPrefix(os.Args[i+1], "-") {
+			if i+1 < end && !strings.HasPrefix(os.Args[i+1], "-") {
 				return os.Args[i+1]
 			}
 		}
@@ -206,29 +534,348 @@ func findFlag(flag string) string {
 	return ""
 }
 
+// findFlags returns every value of a repeatable flag given under any of
+// its names, in command line order. The value is always the next
+// argument, so it may start with a dash.
+func findFlags(flags ...string) []string {
+	var values []string
+	end := argsEnd()
+	for i := 2; i < end; i++ {
+		arg := os.Args[i]
+		for _, flag := range flags {
+			if (arg == "-"+flag || arg == "--"+flag) && i+1 < end {
+				values = append(values, os.Args[i+1])
+				i++
+				break
+			} else if strings.HasPrefix(arg, "--"+flag+"=") {
+				values = append(values, strings.TrimPrefix(arg, "--"+flag+"="))
+				break
+			}
+		}
+	}
+	return values
+}
+
+// argsEnd returns the index of a bare "--", or len(os.Args) without one.
+// Everything after it belongs to the container command and is never
+// taken as a flag or argument of the runtime.
+func argsEnd() int {
+	for i := 2; i < len(os.Args); i++ {
+		if os.Args[i] == "--" {
+			return i
+		}
+	}
+	return len(os.Args)
+}
+
+// processArgsOptions turns --args and a trailing "-- <cmd...>" into
+// create options. The command line wins over the spec's args only with
+// --replace-args.
+func processArgsOptions() ([]libcontainer.CreateOption, error) {
+	args := findFlags("args")
+	if end := argsEnd(); end < len(os.Args) {
+		if len(args) > 0 {
+			return nil, fmt.Errorf("--args and a command after -- cannot be combined")
+		}
+		args = os.Args[end+1:]
+		if len(args) == 0 {
+			return nil, fmt.Errorf("no command after --")
+		}
+	}
+
+	if len(args) == 0 {
+		if hasFlag("replace-args") {
+			return nil, fmt.Errorf("--replace-args needs a command from --args or after --")
+		}
+		return nil, nil
+	}
+
+	opts := []libcontainer.CreateOption{libcontainer.WithProcessArgs(args...)}
+	if hasFlag("replace-args") {
+		opts = append(opts, libcontainer.WithReplaceArgs())
+	}
+	return opts, nil
+}
+
+// processOptions turns --env-file, -e/--env, --sensitive-env, --workdir
+// and --user into create options. Like --args they apply to the loaded
+// spec before validation and are frozen with it.
+func processOptions() []libcontainer.CreateOption {
+	var opts []libcontainer.CreateOption
+	for _, path := range findFlags("env-file") {
+		// A path that can't be resolved fails when the file is read
+		if abs, err := absPath("--env-file", path); err == nil {
+			path = abs
+		}
+		opts = append(opts, libcontainer.WithEnvFile(path))
+	}
+	if env := findFlags("e", "env"); len(env) > 0 {
+		opts = append(opts, libcontainer.WithEnv(env...))
+	}
+	for _, keys := range findFlags("sensitive-env") {
+		opts = append(opts, libcontainer.WithSensitiveEnv(strings.Split(keys, ",")...))
+	}
+	if workdir := findFlag("workdir"); workdir != "" {
+		opts = append(opts, libcontainer.WithCwd(workdir))
+	}
+	if user := findFlag("user"); user != "" {
+		opts = append(opts, libcontainer.WithUser(user))
+	}
+	return opts
+}
+
+// recordOptions records the command line with the container, keeping
+// the values of env overrides only with --record-env-values.
+func recordOptions() []libcontainer.CreateOption {
+	opts := []libcontainer.CreateOption{libcontainer.WithCommandLine(os.Args)}
+	if hasFlag("record-env-values") {
+		opts = append(opts, libcontainer.WithEnvValuesRecorded())
+	}
+	return opts
+}
+
+// maxRuntimeOptions turns --max-runtime, with --stop-signal,
+// --stop-timeout and --restart-on-timeout, into a create option.
+func maxRuntimeOptions() ([]libcontainer.CreateOption, error) {
+	value := findFlag("max-runtime")
+	if value == "" {
+		if findFlag("stop-signal") != "" || findFlag("stop-timeout") != "" || hasFlag("restart-on-timeout") {
+			return nil, fmt.Errorf("--stop-signal, --stop-timeout and --restart-on-timeout need --max-runtime")
+		}
+		return nil, nil
+	}
+	maxRuntime, err := libcontainer.ParseMaxRuntime(value)
+	if err != nil {
+		return nil, err
+	}
+	if value := findFlag("stop-signal"); value != "" {
+		sig, err := libcontainer.ParseSignal(value)
+		if err != nil {
+			return nil, err
+		}
+		maxRuntime.StopSignal = int(sig)
+	}
+	if value := findFlag("stop-timeout"); value != "" {
+		grace, err := time.ParseDuration(value)
+		if err != nil || grace < 0 {
+			return nil, fmt.Errorf("invalid --stop-timeout %q", value)
+		}
+		maxRuntime.GracePeriod = grace.String()
+	}
+	maxRuntime.Restart = hasFlag("restart-on-timeout")
+	return []libcontainer.CreateOption{libcontainer.WithMaxRuntime(maxRuntime)}, nil
+}
+
+// labelOptions turns --label into a create option.
+func labelOptions() []libcontainer.CreateOption {
+	labels := findFlags("label")
+	if len(labels) == 0 {
+		return nil
+	}
+	return []libcontainer.CreateOption{libcontainer.WithLabels(labels...)}
+}
+
+// mountOptions turns --owner-fixup-allow and --read-only-auto-tmpfs
+// into create options. The allow-list comes from whoever runs the
+// runtime, never from the bundle.
+func mountOptions() ([]libcontainer.CreateOption, error) {
+	var opts []libcontainer.CreateOption
+	if allow := findFlags("owner-fixup-allow"); len(allow) > 0 {
+		opts = append(opts, libcontainer.WithOwnerFixupAllow(allow...))
+	}
+	// A bare flag is "true"; only --read-only-auto-tmpfs=exec has a value
+	value := findFlag("read-only-auto-tmpfs")
+	if hasFlag("read-only-auto-tmpfs") {
+		value = "true"
+	}
+	if value != "" {
+		autoTmpfs, err := libcontainer.ParseReadOnlyAutoTmpfs(value)
+		if err != nil {
+			return nil, err
+		}
+		if autoTmpfs != nil {
+			opts = append(opts, libcontainer.WithReadOnlyAutoTmpfs(*autoTmpfs))
+		}
+	}
+	return opts, nil
+}
+
+// securityOptions turns --security-opt and --cap-add into create
+// options that weaken the spec's confinement for debugging.
+func securityOptions() []libcontainer.CreateOption {
+	var opts []libcontainer.CreateOption
+	for _, opt := range findFlags("security-opt") {
+		opts = append(opts, libcontainer.WithSecurityOpt(opt))
+	}
+	for _, caps := range findFlags("cap-add") {
+		opts = append(opts, libcontainer.WithCapAdd(strings.Split(caps, ",")...))
+	}
+	return opts
+}
+
+// preservedFDsOption is the option of --preserve-fds, nil without it. It
+// takes the fds at once, so create and run make it before they open any
+// fd of their own, which could take the number of one the caller left
+// closed.
+func preservedFDsOption() (libcontainer.CreateOption, error) {
+	value := findFlag("preserve-fds")
+	if value == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid --preserve-fds %q", value)
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	return libcontainer.WithCreatePreservedFDs(n), nil
+}
+
+// createOptionsFromFlags turns the flags create, run and clone share
+// into create options, preserveOpt among them. A clone refuses --config
+// before it gets here.
+func createOptionsFromFlags(preserveOpt libcontainer.CreateOption) ([]libcontainer.CreateOption, error) {
+	var opts []libcontainer.CreateOption
+	configPath, err := pathFlag("config")
+	if err != nil {
+		return nil, err
+	}
+	if configPath != "" {
+		opts = append(opts, libcontainer.WithConfigPath(configPath))
+	}
+	consoleSocket, err := pathFlag("console-socket")
+	if err != nil {
+		return nil, err
+	}
+	if consoleSocket != "" {
+		opts = append(opts, libcontainer.WithConsoleSocket(consoleSocket))
+	}
+	if restart := findFlag("restart"); restart != "" {
+		policy, err := libcontainer.ParseRestartPolicy(restart)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, libcontainer.WithRestartPolicy(policy))
+	}
+	if size := findFlag("rootfs-size"); size != "" {
+		bytes, err := libcontainer.ParseSize(size)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, libcontainer.WithRootfsSizeLimit(bytes))
+	}
+	if parent := findFlag("cgroup-parent"); parent != "" {
+		opts = append(opts, libcontainer.WithCgroupParent(parent))
+	}
+	if fd := findFlag("rootfs-fd"); fd != "" {
+		n, err := strconv.Atoi(fd)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --rootfs-fd %q", fd)
+		}
+		opts = append(opts, libcontainer.WithRootfsFD(n))
+	}
+	if hasFlag("strict-spec") {
+		opts = append(opts, libcontainer.WithStrictSpec())
+	}
+	if hasFlag("no-arch-check") {
+		opts = append(opts, libcontainer.WithoutArchCheck())
+	}
+	if hasFlag("no-pivot") {
+		opts = append(opts, libcontainer.WithNoPivotRoot())
+	}
+	if preserveOpt != nil {
+		opts = append(opts, preserveOpt)
+	}
+	argsOpts, err := processArgsOptions()
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, argsOpts...)
+	maxRuntimeOpts, err := maxRuntimeOptions()
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, maxRuntimeOpts...)
+	opts = append(opts, processOptions()...)
+	opts = append(opts, securityOptions()...)
+	mountOpts, err := mountOptions()
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, mountOpts...)
+	opts = append(opts, labelOptions()...)
+	opts = append(opts, recordOptions()...)
+	return opts, nil
+}
+
+// createModeOptions turns --create-mode into a create option for create
+// and clone. --preserve-fds implies full mode, and can't be combined
+// with state-only: that starts nothing the fds could be handed to, and
+// start is another process that doesn't have them.
+func createModeOptions(preserveOpt libcontainer.CreateOption) ([]libcontainer.CreateOption, error) {
+	mode := findFlag("create-mode")
+	if mode == "" {
+		if preserveOpt != nil {
+			return []libcontainer.CreateOption{libcontainer.WithCreateMode(libcontainer.CreateModeFull)}, nil
+		}
+		return nil, nil
+	}
+	createMode, err := libcontainer.ParseCreateMode(mode)
+	if err != nil {
+		return nil, err
+	}
+	if preserveOpt != nil && createMode == libcontainer.CreateModeStateOnly {
+		return nil, fmt.Errorf("--preserve-fds needs --create-mode full")
+	}
+	return []libcontainer.CreateOption{libcontainer.WithCreateMode(createMode)}, nil
+}
+
 func runCreate() error {
 	args := getArgsAfter(0)
 	if len(args) != 1 {
 		return fmt.Errorf("need exactly 1 argument, got %d", len(args))
 	}
+	preserveOpt, err := preservedFDsOption()
+	if err != nil {
+		return err
+	}
 
 	containerID := args[0]
-	bundle := findFlag("bundle")
-	if bundle == "" {
-		bundle = "."
+	bundle, err := bundleFlag()
+	if err != nil {
+		return err
+	}
+	pidFile, err := pathFlag("pid-file")
+	if err != nil {
+		return err
+	}
+	if runtime := delegateRuntime(bundle); runtime != "" {
+		return runDelegatedCreate("create", containerID, bundle, runtime)
 	}
-	pidFile := findFlag("pid-file")
 
-	if _, err := os.Stat(rootDir + "/" + containerID); err == nil {
-		return fmt.Errorf("container id '%s' already exists in directory %s/%s", containerID, rootDir, containerID)
+	opts, err := createOptionsFromFlags(preserveOpt)
+	if err != nil {
+		return err
+	}
+	modeOpts, err := createModeOptions(preserveOpt)
+	if err != nil {
+		return err
 	}
+	opts = append(opts, modeOpts...)
 
-	factory, err := libcontainer.New(rootDir)
+	factory, err := newFactory()
 	if err != nil {
 		return fmt.Errorf("failed to create factory: %w", err)
 	}
 
-	container, err := factory.Create(containerID, bundle)
+	ctx, cancel, err := timeoutContext()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	container, err := createContainer(ctx, factory, containerID, bundle, opts)
 	if err != nil {
 		return fmt.Errorf("failed to create container: %w", err)
 	}
@@ -254,11 +901,19 @@ func runDelete() error {
 
 	containerID := args[0]
 
-	factory, err := libcontainer.New(rootDir)
+	factory, err := newFactory()
 	if err != nil {
 		return fmt.Errorf("failed to create factory: %w", err)
 	}
 
+	var delegateArgs []string
+	if hasFlag("force") {
+		delegateArgs = append(delegateArgs, "--force")
+	}
+	if delegated, err := routeDelegated(factory, "delete", containerID, append(delegateArgs, containerID)...); delegated || err != nil {
+		return err
+	}
+
 	container, err := factory.Load(containerID)
 	if err != nil {
 		return fmt.Errorf("failed to load container: %w", err)
@@ -276,25 +931,58 @@ func runRun() error {
 	if len(args) != 1 {
 		return fmt.Errorf("need exactly 1 argument, got %d", len(args))
 	}
+	preserveOpt, err := preservedFDsOption()
+	if err != nil {
+		return err
+	}
 
 	containerID := args[0]
-	bundle := findFlag("bundle")
-	if bundle == "" {
-		bundle = "."
+	bundle, err := bundleFlag()
+	if err != nil {
+		return err
+	}
+	pidFile, err := pathFlag("pid-file")
+	if err != nil {
+		return err
+	}
+	if runtime := delegateRuntime(bundle); runtime != "" {
+		return runDelegatedCreate("run", containerID, bundle, runtime)
 	}
-	pidFile := findFlag("pid-file")
 
-	factory, err := libcontainer.New(rootDir)
+	opts, err := createOptionsFromFlags(preserveOpt)
+	if err != nil {
+		return err
+	}
+	if hasFlag("all") {
+		opts = append(opts, libcontainer.WithForwardToAll())
+	}
+
+	factory, err := newFactory()
 	if err != nil {
 		return fmt.Errorf("failed to create factory: %w", err)
 	}
 
-	container, err := factory.Create(containerID, bundle)
+	// The timeout covers getting the container process going, not how
+	// long it then runs
+	ctx, cancel, err := timeoutContext()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	container, err := createContainer(ctx, factory, containerID, bundle, opts)
 	if err != nil {
 		return fmt.Errorf("failed to create container: %w", err)
 	}
 
-	if err := container.Run(); err != nil {
+	// run owns the container it created, so once the process has exited,
+	// and its exit is recorded, the container goes too. A run that gave
+	// up doesn't leave it behind even with --keep.
+	result, err := container.RunWithResult(ctx)
+	if !hasFlag("keep") || errors.Is(err, context.DeadlineExceeded) {
+		defer removeRunContainer(container)
+	}
+	if err != nil {
 		return fmt.Errorf("failed to run container: %w", err)
 	}
 
@@ -308,9 +996,20 @@ func runRun() error {
 		}
 	}
 
+	if result.Exited && result.ExitCode != 0 {
+		return containerExit(result.ExitCode)
+	}
 	return nil
 }
 
+// removeRunContainer deletes the container run created. Failing to
+// doesn't change how run exits.
+func removeRunContainer(container libcontainer.Container) {
+	if err := container.Delete(); err != nil && !errors.Is(err, libcontainer.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "WARNING: failed to delete container %s: %v\n", container.ID(), err)
+	}
+}
+
 func runState() error {
 	args := getArgsAfter(0)
 	if len(args) != 1 {
@@ -319,22 +1018,61 @@ func runState() error {
 
 	containerID := args[0]
 
-	factory, err := libcontainer.New(rootDir)
+	factory, err := newFactory()
 	if err != nil {
 		return fmt.Errorf("failed to create factory: %w", err)
 	}
 
+	watch := hasFlag("watch")
+	if watch {
+		// A delegate's state can't be watched, only asked for
+		if d, err := factory.Delegation(containerID); err != nil {
+			return err
+		} else if d != nil {
+			return fmt.Errorf("cannot watch a container delegated to %s", d.Runtime)
+		}
+	}
+	if delegated, err := routeDelegated(factory, "state", containerID, containerID); delegated || err != nil {
+		return err
+	}
+
 	container, err := factory.Load(containerID)
 	if err != nil {
 		return fmt.Errorf("failed to load container: %w", err)
 	}
 
+	if watch {
+		return watchState(container)
+	}
+
 	state, err := container.State()
 	if err != nil {
 		return fmt.Errorf("failed to get container state: %w", err)
 	}
 
-	json.NewEncoder(os.Stdout).Encode(state)
+	return json.NewEncoder(stdout).Encode(state)
+}
+
+// watchState prints the container's state as a JSON line, and again on
+// every change until the container is deleted.
+func watchState(container libcontainer.Container) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	states, err := container.Subscribe(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to watch container state: %w", err)
+	}
+	encoder := json.NewEncoder(stdout)
+	for state := range states {
+		if err := encoder.Encode(state); err != nil {
+			// The watch ends when its reader goes away
+			if errors.Is(err, errBrokenPipe) {
+				return nil
+			}
+			return err
+		}
+	}
 	return nil
 }
 
@@ -346,11 +1084,21 @@ func runStart() error {
 
 	containerID := args[0]
 
-	factory, err := libcontainer.New(rootDir)
+	ctx, cancel, err := timeoutContext()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	factory, err := newFactory()
 	if err != nil {
 		return fmt.Errorf("failed to create factory: %w", err)
 	}
 
+	if delegated, err := routeDelegated(factory, "start", containerID, containerID); delegated || err != nil {
+		return err
+	}
+
 	container, err := factory.Load(containerID)
 	if err != nil {
 		return fmt.Errorf("failed to load container: %w", err)
@@ -363,7 +1111,7 @@ func runStart() error {
 
 	switch state.Status {
 	case libcontainer.Created:
-		if err := container.Start(); err != nil {
+		if err := container.StartContext(ctx); err != nil {
 			return fmt.Errorf("failed to start container: %w", err)
 		}
 		return nil
@@ -371,6 +1119,8 @@ func runStart() error {
 		return fmt.Errorf("cannot start a container that has stopped")
 	case libcontainer.Running:
 		return fmt.Errorf("cannot start an already running container")
+	case libcontainer.Paused:
+		return fmt.Errorf("cannot start a paused container; resume it instead")
 	default:
 		return fmt.Errorf("cannot start a container in the %s state", state.Status)
 	}
@@ -384,12 +1134,25 @@ func runKill() error {
 
 	containerID := args[0]
 
-	factory, err := libcontainer.New(rootDir)
+	factory, err := newFactory()
 	if err != nil {
 		return fmt.Errorf("failed to create factory: %w", err)
 	}
 
-	container, err := factory.Load(containerID)
+	delegateArgs := args
+	if hasFlag("all") {
+		delegateArgs = append([]string{"--all"}, args...)
+	}
+	if delegated, err := routeDelegated(factory, "kill", containerID, delegateArgs...); delegated || err != nil {
+		return err
+	}
+
+	var loadOpts []libcontainer.LoadOption
+	if hasFlag("skip-namespace-check") {
+		loadOpts = append(loadOpts, libcontainer.WithoutNamespaceCheck())
+	}
+
+	container, err := factory.Load(containerID, loadOpts...)
 	if err != nil {
 		return fmt.Errorf("failed to load container: %w", err)
 	}
@@ -399,12 +1162,12 @@ func runKill() error {
 		sigStr = args[1]
 	}
 
-	sig, err := parseSignal(sigStr)
+	sig, err := libcontainer.ParseSignal(sigStr)
 	if err != nil {
 		return err
 	}
 
-	err = container.Signal(sig)
+	err = container.Signal(sig, hasFlag("all"))
 	if err != nil {
 		return fmt.Errorf("failed to send signal: %w", err)
 	}
@@ -412,32 +1175,40 @@ func runKill() error {
 	return nil
 }
 
+// commandValueFlags are the flags of commands whose value is the next
+// argument, so getArgsAfter doesn't take it for an argument.
+var commandValueFlags = map[string]bool{
+	"--bundle": true, "--pid-file": true, "--console-socket": true,
+	"--config": true, "--restart": true,
+	"--container-root": true, "--rootfs-size": true, "--cgroup-parent": true,
+	"--rootfs-fd": true, "--listen": true, "--allow-uid": true,
+	"--since": true, "--filter": true, "--args": true,
+	"--security-opt": true, "--cap-add": true, "--env": true,
+	"--env-file": true, "--sensitive-env": true, "--workdir": true,
+	"--user": true, "--owner-fixup-allow": true, "--timeout": true,
+	"--deadline": true, "--create-mode": true, "--preserve-fds": true,
+	"--bundle-dir": true, "--label": true, "--format": true,
+	"--delegate-runtime": true, "--interval": true, "--resources": true,
+	"--memory": true, "--cpu-quota": true, "--cpu-period": true,
+	"--cpu-shares": true, "--pids-limit": true, "--image-path": true,
+	"--work-path": true, "--max-runtime": true, "--stop-signal": true,
+	"--stop-timeout": true,
+}
+
 func getArgsAfter(skip int) []string {
 	var args []string
-	commands := map[string]bool{
-		"create": true, "delete": true, "run": true,
-		"start": true, "state": true, "kill": true, "init": true,
-	}
-
-	// Find the command position
-	cmdPos := -1
-	for i, arg := range os.Args {
-		if commands[arg] {
-			cmdPos = i
-			break
-		}
-	}
 
+	cmdPos := commandIndex()
 	if cmdPos == -1 {
 		return args
 	}
 
 	// Collect args after the command, skipping flags
-	for i := cmdPos + 1; i < len(os.Args); i++ {
+	for i := cmdPos + 1; i < argsEnd(); i++ {
 		arg := os.Args[i]
 		if !strings.HasPrefix(arg, "-") {
 			args = append(args, arg)
-		} else if arg == "-b" || arg == "--bundle" || arg == "--pid-file" || arg == "--console-socket" {
+		} else if commandValueFlags[arg] || globalValueFlag(arg) {
 			// Skip flag value
 			i++
 		} else if strings.HasPrefix(arg, "--") && strings.Contains(arg, "=") {
@@ -449,45 +1220,3 @@ func getArgsAfter(skip int) []string {
 	}
 	return args
 }
-
-func parseSignal(rawSignal string) (syscall.Signal, error) {
-	s, err := strconv.Atoi(rawSignal)
-	if err == nil {
-		return syscall.Signal(s), nil
-	}
-
-	sig := strings.ToUpper(rawSignal)
-	if !strings.HasPrefix(sig, "SIG") {
-		sig = "SIG" + sig
-	}
-
-	signalNum := stringToSignal(sig)
-	if signalNum == 0 {
-		return 0, fmt.Errorf("unknown signal %q", rawSignal)
-	}
-
-	return signalNum, nil
-}
-
-func stringToSignal(sig string) syscall.Signal {
-	switch sig {
-	case "SIGTERM":
-		return syscall.SIGTERM
-	case "SIGKILL":
-		return syscall.SIGKILL
-	case "SIGINT":
-		return syscall.SIGINT
-	case "SIGQUIT":
-		return syscall.SIGQUIT
-	case "SIGHUP":
-		return syscall.SIGHUP
-	case "SIGUSR1":
-		return syscall.SIGUSR1
-	case "SIGUSR2":
-		return syscall.SIGUSR2
-	case "SIGWINCH":
-		return syscall.SIGWINCH
-	default:
-		return 0
-	}
-}
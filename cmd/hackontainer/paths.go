@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// workDir is the directory relative paths on the command line resolve
+// against, found once at startup so that nothing later depends on the
+// process's working directory. A supervisor may start the runtime in a
+// directory that has since been deleted or can't be read: workDirErr
+// then says why, relative paths are refused, and the runtime moves to
+// "/" so that nothing it starts inherits the dead directory.
+var (
+	workDir    string
+	workDirErr error
+)
+
+func resolveWorkDir() {
+	workDir, workDirErr = os.Getwd()
+	if workDirErr != nil {
+		os.Chdir("/")
+	}
+}
+
+// absPath resolves path, given with flag, against workDir.
+func absPath(flag, path string) (string, error) {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path), nil
+	}
+	if workDirErr != nil {
+		return "", fmt.Errorf("cannot resolve %s %q: the working directory is unusable (%v); give an absolute path", flag, path, workDirErr)
+	}
+	return filepath.Join(workDir, path), nil
+}
+
+// pathFlag returns the value of a path flag made absolute, or "" if the
+// flag wasn't given.
+func pathFlag(flag string) (string, error) {
+	path := findFlag(flag)
+	if path == "" {
+		return "", nil
+	}
+	return absPath("--"+flag, path)
+}
+
+// bundleFlag returns --bundle made absolute. It defaults to the working
+// directory, which must then be usable.
+func bundleFlag() (string, error) {
+	if findFlag("bundle") != "" {
+		return pathFlag("bundle")
+	}
+	if workDirErr != nil {
+		return "", fmt.Errorf("no --bundle given and the working directory is unusable (%v)", workDirErr)
+	}
+	return workDir, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/zakarynichols/hackontainer/libcontainer"
+)
+
+// runPause freezes every process of a running container.
+func runPause() error {
+	container, err := loadPauseTarget()
+	if err != nil {
+		return err
+	}
+	if err := container.Pause(); err != nil {
+		return fmt.Errorf("failed to pause container: %w", err)
+	}
+	return nil
+}
+
+// runResume thaws a paused container.
+func runResume() error {
+	container, err := loadPauseTarget()
+	if err != nil {
+		return err
+	}
+	if err := container.Resume(); err != nil {
+		return fmt.Errorf("failed to resume container: %w", err)
+	}
+	return nil
+}
+
+// loadPauseTarget loads the container named by the only argument of
+// pause or resume.
+func loadPauseTarget() (libcontainer.Container, error) {
+	args := getArgsAfter(0)
+	if len(args) != 1 {
+		return nil, fmt.Errorf("need exactly 1 argument, got %d", len(args))
+	}
+
+	factory, err := newFactory()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create factory: %w", err)
+	}
+	container, err := factory.Load(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to load container: %w", err)
+	}
+	return container, nil
+}
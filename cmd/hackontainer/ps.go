@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// runPs lists the processes of a running container: their pids with
+// --format json, or else the lines ps prints for them, with ps run on
+// the host with the options after -- (default -ef).
+func runPs() error {
+	args := getArgsAfter(0)
+	if len(args) != 1 {
+		return fmt.Errorf("need exactly 1 argument, got %d", len(args))
+	}
+	containerID := args[0]
+	format := findFlag("format")
+	if format == "" {
+		format = "table"
+	}
+	if format != "table" && format != "json" {
+		return fmt.Errorf("invalid --format %q (want table or json)", format)
+	}
+	psArgs := []string{"-ef"}
+	if end := argsEnd(); end < len(os.Args) {
+		psArgs = os.Args[end+1:]
+	}
+
+	factory, err := newFactory()
+	if err != nil {
+		return fmt.Errorf("failed to create factory: %w", err)
+	}
+
+	delegateArgs := []string{"--format", format, containerID}
+	if format == "table" {
+		delegateArgs = append(delegateArgs, psArgs...)
+	}
+	if delegated, err := routeDelegated(factory, "ps", containerID, delegateArgs...); delegated || err != nil {
+		return err
+	}
+
+	container, err := factory.Load(containerID)
+	if err != nil {
+		return fmt.Errorf("failed to load container: %w", err)
+	}
+	pids, err := container.Processes()
+	if err != nil {
+		return fmt.Errorf("failed to list processes: %w", err)
+	}
+
+	if format == "json" {
+		if pids == nil {
+			pids = []int{}
+		}
+		return json.NewEncoder(stdout).Encode(pids)
+	}
+
+	out, err := exec.Command("ps", psArgs...).Output()
+	if err != nil {
+		return fmt.Errorf("ps %s: %w", strings.Join(psArgs, " "), err)
+	}
+	lines, err := psLines(out, pids)
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(stdout, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// psLines keeps the header of ps output and the lines of pids, found by
+// the header's PID column.
+func psLines(out []byte, pids []int) ([]string, error) {
+	lines := strings.Split(string(bytes.TrimSpace(out)), "\n")
+	column := -1
+	for i, field := range strings.Fields(lines[0]) {
+		if field == "PID" {
+			column = i
+			break
+		}
+	}
+	if column < 0 {
+		return nil, fmt.Errorf("ps printed no PID column; pass options that include it")
+	}
+
+	wanted := make(map[int]bool, len(pids))
+	for _, pid := range pids {
+		wanted[pid] = true
+	}
+	kept := lines[:1]
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if column >= len(fields) {
+			continue
+		}
+		if pid, err := strconv.Atoi(fields[column]); err == nil && wanted[pid] {
+			kept = append(kept, line)
+		}
+	}
+	return kept, nil
+}
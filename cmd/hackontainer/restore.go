@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/zakarynichols/hackontainer/libcontainer"
+)
+
+// runRestore creates a container from a bundle and brings its process
+// back from a checkpoint with criu instead of starting it.
+func runRestore() error {
+	args := getArgsAfter(0)
+	if len(args) != 1 {
+		return fmt.Errorf("need exactly 1 argument, got %d", len(args))
+	}
+	containerID := args[0]
+
+	bundle, err := bundleFlag()
+	if err != nil {
+		return err
+	}
+	if runtime := delegateRuntime(bundle); runtime != "" {
+		return fmt.Errorf("cannot restore a container whose bundle is delegated to %s", runtime)
+	}
+
+	imagePath, err := pathFlag("image-path")
+	if err != nil {
+		return err
+	}
+	workPath, err := pathFlag("work-path")
+	if err != nil {
+		return err
+	}
+	restoreOpts := libcontainer.RestoreOptions{
+		ImagePath:      imagePath,
+		WorkPath:       workPath,
+		TCPEstablished: hasFlag("tcp-established"),
+		CriuPath:       criuPath,
+	}
+	if restoreOpts.ImagePath == "" {
+		return fmt.Errorf("--image-path is required")
+	}
+
+	// The container is created without a process, which the restore
+	// stands in for
+	opts := []libcontainer.CreateOption{libcontainer.WithCreateMode(libcontainer.CreateModeStateOnly)}
+	configPath, err := pathFlag("config")
+	if err != nil {
+		return err
+	}
+	if configPath != "" {
+		opts = append(opts, libcontainer.WithConfigPath(configPath))
+	}
+	consoleSocket, err := pathFlag("console-socket")
+	if err != nil {
+		return err
+	}
+	if consoleSocket != "" {
+		opts = append(opts, libcontainer.WithConsoleSocket(consoleSocket))
+	}
+
+	pidFile, err := pathFlag("pid-file")
+	if err != nil {
+		return err
+	}
+
+	factory, err := newFactory()
+	if err != nil {
+		return fmt.Errorf("failed to create factory: %w", err)
+	}
+
+	container, err := createContainer(context.Background(), factory, containerID, bundle, opts)
+	if err != nil {
+		return fmt.Errorf("failed to create container: %w", err)
+	}
+	if err := container.Restore(restoreOpts); err != nil {
+		_ = container.Delete()
+		return fmt.Errorf("failed to restore container: %w", err)
+	}
+
+	if pidFile != "" {
+		state, err := container.State()
+		if err != nil {
+			return fmt.Errorf("failed to get container state: %w", err)
+		}
+		if err := os.WriteFile(pidFile, []byte(fmt.Sprintf("%d", state.Pid)), 0644); err != nil {
+			return fmt.Errorf("failed to write PID file: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/zakarynichols/hackontainer/api/types"
+)
+
+// runSchema prints the JSON Schema of one of the documents the runtime
+// emits, or the names of all of them.
+func runSchema() error {
+	args := getArgsAfter(0)
+	if len(args) == 0 {
+		for _, name := range types.SchemaNames() {
+			if _, err := fmt.Fprintln(stdout, name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("need at most 1 argument, got %d", len(args))
+	}
+
+	schema, err := types.Schema(args[0])
+	if err != nil {
+		return err
+	}
+	_, err = stdout.Write(schema)
+	return err
+}
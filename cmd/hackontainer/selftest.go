@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/zakarynichols/hackontainer/libcontainer"
+	"github.com/zakarynichols/hackontainer/libcontainer/selftest"
+)
+
+// runSelfTest runs a throwaway container through its lifecycle on this
+// host and reports what did and didn't take effect, failing if anything
+// didn't. --root is ignored: the container gets a state root of its own.
+func runSelfTest() error {
+	mode, err := libcontainer.ParseRootlessMode(rootlessVal)
+	if err != nil {
+		return err
+	}
+	dir, err := pathFlag("bundle-dir")
+	if err != nil {
+		return err
+	}
+	summary, err := selftest.Run(selftest.Options{
+		Dir:      dir,
+		Rootless: mode,
+		Out:      stdout,
+	})
+	if err != nil {
+		return err
+	}
+	if summary.Fail > 0 {
+		return fmt.Errorf("%d of the self-test's checks failed", summary.Fail)
+	}
+	return nil
+}
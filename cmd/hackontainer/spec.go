@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/zakarynichols/hackontainer/config"
+)
+
+// runSpec writes a default config.json to the bundle, like runc spec. An
+// existing config is left alone.
+func runSpec() error {
+	bundle, err := bundleFlag()
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(bundle, "config.json")
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists; remove it first", path)
+	}
+
+	data, err := json.MarshalIndent(config.DefaultSpec(), "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0666)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/zakarynichols/hackontainer/libcontainer"
+)
+
+// runStats prints the live resource usage of a running container, or
+// with --final the usage recorded when it last exited.
+func runStats() error {
+	args := getArgsAfter(0)
+	if len(args) != 1 {
+		return fmt.Errorf("need exactly 1 argument, got %d", len(args))
+	}
+
+	factory, err := newFactory()
+	if err != nil {
+		return fmt.Errorf("failed to create factory: %w", err)
+	}
+
+	container, err := factory.Load(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to load container: %w", err)
+	}
+
+	var stats *libcontainer.Stats
+	if hasFlag("final") {
+		stats, err = container.FinalStats()
+	} else {
+		stats, err = container.Stats()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get stats: %w", err)
+	}
+
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(stats)
+}
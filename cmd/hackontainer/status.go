@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// runStatus prints a one-line summary of the containers under --root for
+// health checks, failing if any of them look wrong.
+func runStatus() error {
+	if args := getArgsAfter(0); len(args) != 0 {
+		return fmt.Errorf("status takes no arguments, got %d", len(args))
+	}
+
+	factory, err := newFactory()
+	if err != nil {
+		return fmt.Errorf("failed to create factory: %w", err)
+	}
+	summary, err := factory.Status()
+	if err != nil {
+		return fmt.Errorf("failed to check containers: %w", err)
+	}
+	if err := json.NewEncoder(stdout).Encode(summary); err != nil {
+		return err
+	}
+	if len(summary.Anomalies) == 0 {
+		return nil
+	}
+	// A container can have more than one
+	ids := map[string]bool{}
+	for _, anomaly := range summary.Anomalies {
+		ids[anomaly.ID] = true
+	}
+	return fmt.Errorf("%d of %d containers need attention", len(ids), summary.Total)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+// exitBrokenPipe is the exit status of a one-shot command whose reader
+// went away before it was done, the one a shell shows for a process
+// SIGPIPE killed. Nothing is printed for it.
+const exitBrokenPipe = 128 + int(syscall.SIGPIPE)
+
+// errBrokenPipe is returned by stdout once its reader has gone away.
+var errBrokenPipe = fmt.Errorf("stdout closed by its reader: %w", syscall.EPIPE)
+
+// pipeWriter stops writing after a write fails with EPIPE, failing that
+// and every later write with errBrokenPipe, so a command stops emitting
+// output nobody reads instead of carrying on after a partial line.
+type pipeWriter struct {
+	w      io.Writer
+	broken bool
+}
+
+func (p *pipeWriter) Write(b []byte) (int, error) {
+	if p.broken {
+		return 0, errBrokenPipe
+	}
+	n, err := p.w.Write(b)
+	if errors.Is(err, syscall.EPIPE) {
+		p.broken = true
+		return n, errBrokenPipe
+	}
+	return n, err
+}
+
+// stdout is what the commands write their output to. main asks for
+// SIGPIPE so that a closed pipe fails writes with EPIPE rather than
+// killing the runtime.
+var stdout io.Writer = &pipeWriter{w: os.Stdout}
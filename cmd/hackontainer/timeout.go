@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/zakarynichols/hackontainer/libcontainer"
+)
+
+// exitTimeout is the exit status of a create, run or start that ran past
+// --timeout, the one timeout(1) uses.
+const exitTimeout = 124
+
+// createGrace is how long a create past its deadline gets to finish the
+// step it is in and clean up before the command stops waiting for it.
+const createGrace = 10 * time.Second
+
+// timeoutContext returns the context --timeout puts an operation under.
+// Without the flag, or with zero, there is no deadline.
+func timeoutContext() (context.Context, context.CancelFunc, error) {
+	value := findFlag("timeout")
+	if value == "" {
+		return context.Background(), func() {}, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return nil, nil, fmt.Errorf("invalid --timeout %q", value)
+	}
+	if timeout == 0 {
+		return context.Background(), func() {}, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	return ctx, cancel, nil
+}
+
+// createContainer creates the container under ctx. The factory only
+// checks ctx between steps, so if a step is stuck in the kernel past the
+// deadline the command gives up on it rather than hang with it.
+func createContainer(ctx context.Context, factory libcontainer.Factory, id, bundle string, opts []libcontainer.CreateOption) (libcontainer.Container, error) {
+	type result struct {
+		container libcontainer.Container
+		err       error
+	}
+	done := make(chan result, 1)
+	go func() {
+		container, err := factory.CreateContext(ctx, id, bundle, opts...)
+		done <- result{container, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.container, r.err
+	case <-ctx.Done():
+	}
+	select {
+	case r := <-done:
+		return r.container, r.err
+	case <-time.After(createGrace):
+		return nil, fmt.Errorf("create is stuck and left its state behind: %w", ctx.Err())
+	}
+}
+
+// runMonitor runs the hidden monitor command, which start passes its
+// deadline on to.
+func runMonitor() error {
+	var deadline time.Time
+	if value := findFlag("deadline"); value != "" {
+		var err error
+		if deadline, err = time.Parse(time.RFC3339Nano, value); err != nil {
+			return fmt.Errorf("invalid --deadline %q", value)
+		}
+	}
+	// The fds preserved for the container come before the ready pipe
+	preserveFDs := 0
+	if value := findFlag("preserve-fds"); value != "" {
+		var err error
+		if preserveFDs, err = strconv.Atoi(value); err != nil {
+			return fmt.Errorf("invalid --preserve-fds %q", value)
+		}
+	}
+	return libcontainer.RunMonitor(findFlag("container-root"), deadline, os.NewFile(uintptr(3+preserveFDs), "ready"), preserveFDs)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+
+	"github.com/zakarynichols/hackontainer/libcontainer"
+)
+
+// runUpdate changes the limits of a container: those in the
+// linux.resources JSON of --resources (a file, or - for stdin), with the
+// flags on top.
+func runUpdate() error {
+	args := getArgsAfter(0)
+	if len(args) != 1 {
+		return fmt.Errorf("need exactly 1 argument, got %d", len(args))
+	}
+	containerID := args[0]
+
+	resources, err := updateResources()
+	if err != nil {
+		return err
+	}
+
+	factory, err := newFactory()
+	if err != nil {
+		return fmt.Errorf("failed to create factory: %w", err)
+	}
+
+	data, err := json.Marshal(resources)
+	if err != nil {
+		return err
+	}
+	if delegated, err := routeDelegatedUpdate(factory, containerID, data); delegated || err != nil {
+		return err
+	}
+
+	container, err := factory.Load(containerID)
+	if err != nil {
+		return fmt.Errorf("failed to load container: %w", err)
+	}
+	if err := container.Set(resources); err != nil {
+		return fmt.Errorf("failed to update container: %w", err)
+	}
+	return nil
+}
+
+// updateResources reads --resources and applies the flags over it.
+func updateResources() (specs.LinuxResources, error) {
+	var r specs.LinuxResources
+	if path := findFlag("resources"); path != "" || hasFlag("resources") {
+		var data []byte
+		var err error
+		switch path {
+		case "", "-":
+			data, err = io.ReadAll(os.Stdin)
+		default:
+			data, err = os.ReadFile(path)
+		}
+		if err != nil {
+			return r, fmt.Errorf("failed to read --resources: %w", err)
+		}
+		if err := json.Unmarshal(data, &r); err != nil {
+			return r, fmt.Errorf("invalid --resources: %w", err)
+		}
+	}
+
+	if value := findFlag("memory"); value != "" {
+		limit := int64(-1)
+		if value != "-1" {
+			bytes, err := libcontainer.ParseSize(value)
+			if err != nil {
+				return r, fmt.Errorf("invalid --memory: %w", err)
+			}
+			limit = int64(bytes)
+		}
+		if r.Memory == nil {
+			r.Memory = &specs.LinuxMemory{}
+		}
+		r.Memory.Limit = &limit
+	}
+
+	cpu := func() *specs.LinuxCPU {
+		if r.CPU == nil {
+			r.CPU = &specs.LinuxCPU{}
+		}
+		return r.CPU
+	}
+	if value := findFlag("cpu-quota"); value != "" {
+		quota, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return r, fmt.Errorf("invalid --cpu-quota %q", value)
+		}
+		cpu().Quota = &quota
+	}
+	if value := findFlag("cpu-period"); value != "" {
+		period, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return r, fmt.Errorf("invalid --cpu-period %q", value)
+		}
+		cpu().Period = &period
+	}
+	if value := findFlag("cpu-shares"); value != "" {
+		shares, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return r, fmt.Errorf("invalid --cpu-shares %q", value)
+		}
+		cpu().Shares = &shares
+	}
+	if value := findFlag("pids-limit"); value != "" {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return r, fmt.Errorf("invalid --pids-limit %q", value)
+		}
+		r.Pids = &specs.LinuxPids{Limit: &limit}
+	}
+
+	if r.Memory == nil && r.CPU == nil && r.Pids == nil && len(r.Devices) == 0 && r.BlockIO == nil &&
+		len(r.HugepageLimits) == 0 && r.Network == nil && len(r.Rdma) == 0 && len(r.Unified) == 0 {
+		return r, fmt.Errorf("nothing to update: pass --resources or a limit flag")
+	}
+	return r, nil
+}
+
+// routeDelegatedUpdate hands the update to the delegate of a delegated
+// container, in a file as runc's update --resources reads it.
+func routeDelegatedUpdate(factory libcontainer.Factory, id string, resources []byte) (bool, error) {
+	if d, err := factory.Delegation(id); err != nil || d == nil {
+		return false, err
+	}
+	f, err := os.CreateTemp("", "hackontainer-update-*.json")
+	if err != nil {
+		return true, err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(resources)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return true, err
+	}
+	return routeDelegated(factory, "update", id, "--resources", f.Name(), id)
+}
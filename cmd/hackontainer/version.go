@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/zakarynichols/hackontainer/version"
+)
+
+// runVersion prints the runtime's version, its build and the OCI runtime
+// spec version it implements: as lines laid out like runc's, whose first
+// is "hackontainer version <version>", or as the version document with
+// --format json.
+func runVersion() error {
+	format := findFlag("format")
+	if format == "" {
+		format = "text"
+	}
+	if format != "text" && format != "json" {
+		return fmt.Errorf("invalid --format %q (want text or json)", format)
+	}
+
+	info := version.Info()
+	if format == "json" {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(info)
+	}
+
+	fmt.Fprintf(stdout, "hackontainer version %s\n", info.Version)
+	if info.Commit != "" {
+		fmt.Fprintf(stdout, "commit: %s\n", info.Commit)
+	}
+	if info.BuildDate != "" {
+		fmt.Fprintf(stdout, "built: %s\n", info.BuildDate)
+	}
+	fmt.Fprintf(stdout, "spec: %s\n", info.OCIVersion)
+	_, err := fmt.Fprintf(stdout, "go: %s\n", info.GoVersion)
+	return err
+}
@@ -1,8 +1,10 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 
@@ -13,20 +15,123 @@ type Config struct {
 	*specs.Spec
 
 	Rootfs string
+
+	// Bundle is the directory a relative root.path is resolved against.
+	// It is the directory holding the config file unless the config was
+	// loaded from outside the bundle.
+	Bundle string
+
+	// Resolved is the spec as worked out at create time. It is nil
+	// until then, and for configs frozen before it existed.
+	Resolved *Resolved
+
+	// NoPivotRoot makes the container's root by moving the rootfs onto /
+	// and chrooting into it, instead of with pivot_root, for a rootfs
+	// pivot_root can't leave, such as a ramdisk. It is the runtime's
+	// choice, made at create time, not the spec's.
+	NoPivotRoot bool
+}
+
+// frozenConfig is how Save lays out a config: the spec's own fields, so
+// the file still reads as a spec, and the resolved form next to them.
+type frozenConfig struct {
+	*specs.Spec
+	Resolved    *Resolved `json:"resolved,omitempty"`
+	NoPivotRoot bool      `json:"noPivotRoot,omitempty"`
+}
+
+// DefaultMaxSize caps how much of a config file is read, so a hostile
+// or corrupt file can't exhaust memory before it is even parsed.
+const DefaultMaxSize = 16 << 20
+
+// Options control how a config file is read.
+type Options struct {
+	// MaxSize is the largest config accepted, in bytes. Zero means
+	// DefaultMaxSize and a negative value disables the limit.
+	MaxSize int64
+
+	// Strict rejects fields the spec doesn't define and keys that appear
+	// twice in the same object, instead of ignoring them or letting the
+	// last one win.
+	Strict bool
 }
 
 func Load(path string) (*Config, error) {
-	data, err := os.ReadFile(path)
+	return LoadWithBundle(path, filepath.Dir(path))
+}
+
+// LoadWithBundle loads the spec at path but anchors the root filesystem
+// to bundle, so an alternate config can be used against an existing bundle.
+func LoadWithBundle(path, bundle string) (*Config, error) {
+	return LoadWithOptions(path, bundle, Options{})
+}
+
+// LoadWithOptions is LoadWithBundle with control over how the file is
+// read.
+func LoadWithOptions(path, bundle string, opts Options) (*Config, error) {
+	f, err := os.Open(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
+	defer f.Close()
+
+	return DecodeWithOptions(f, bundle, opts)
+}
+
+// Decode reads a spec from r, anchoring a relative root filesystem to
+// bundle.
+func Decode(r io.Reader, bundle string) (*Config, error) {
+	return DecodeWithOptions(r, bundle, Options{})
+}
+
+// DecodeWithOptions is Decode with control over how the spec is read.
+func DecodeWithOptions(r io.Reader, bundle string, opts Options) (*Config, error) {
+	maxSize := opts.MaxSize
+	if maxSize == 0 {
+		maxSize = DefaultMaxSize
+	}
+	if maxSize > 0 {
+		// One byte over the limit is enough to tell it was exceeded
+		r = io.LimitReader(r, maxSize+1)
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if maxSize > 0 && int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("config file is larger than the limit of %d bytes", maxSize)
+	}
 
 	var spec specs.Spec
-	if err := json.Unmarshal(data, &spec); err != nil {
+	if opts.Strict {
+		if err := checkDuplicateKeys(data); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&spec); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+		}
+		if dec.More() {
+			return nil, fmt.Errorf("failed to unmarshal config: unexpected data after the spec")
+		}
+	} else if err := json.Unmarshal(data, &spec); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 
-	bundleDir := filepath.Dir(path)
+	// Only a frozen config has one, and strict mode refuses it as an
+	// unknown field
+	var frozen struct {
+		Resolved    *Resolved `json:"resolved"`
+		NoPivotRoot bool      `json:"noPivotRoot"`
+	}
+	if !opts.Strict {
+		if err := json.Unmarshal(data, &frozen); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+		}
+	}
+
 	rootPath := "."
 	if spec.Root != nil {
 		rootPath = spec.Root.Path
@@ -35,12 +140,35 @@ func Load(path string) (*Config, error) {
 		}
 	}
 
+	rootfs := rootPath
+	if !filepath.IsAbs(rootfs) {
+		rootfs = filepath.Join(bundle, rootPath)
+	}
+	if frozen.Resolved != nil {
+		rootfs = frozen.Resolved.Rootfs
+	}
+
 	return &Config{
-		Spec:   &spec,
-		Rootfs: filepath.Join(bundleDir, rootPath),
+		Spec:        &spec,
+		Rootfs:      rootfs,
+		Bundle:      bundle,
+		Resolved:    frozen.Resolved,
+		NoPivotRoot: frozen.NoPivotRoot,
 	}, nil
 }
 
+// Save writes the spec and its resolved form to path so later
+// operations see exactly the configuration the container was created
+// with.
+func (c *Config) Save(path string) error {
+	data, err := json.Marshal(frozenConfig{Spec: c.Spec, Resolved: c.Resolved, NoPivotRoot: c.NoPivotRoot})
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
 /*
 On POSIX platforms, path is either an absolute path or a relative
 path to the bundle. For example, with a bundle at /to/bundle and a
@@ -53,8 +181,7 @@ func (c *Config) NormalizeRoot() error {
 	}
 
 	if !filepath.IsAbs(c.Spec.Root.Path) {
-		bundleDir := filepath.Dir(c.Rootfs)
-		c.Spec.Root.Path = filepath.Join(bundleDir, c.Spec.Root.Path)
+		c.Spec.Root.Path = filepath.Join(c.Bundle, c.Spec.Root.Path)
 	}
 
 	c.Rootfs = c.Spec.Root.Path
@@ -64,3 +191,55 @@ func (c *Config) NormalizeRoot() error {
 func (c *Config) Validate() error {
 	return Validate(c.Spec)
 }
+
+func (c *Config) Warnings() []string {
+	return Warnings(c.Spec)
+}
+
+// checkDuplicateKeys fails if any JSON object in data repeats a key.
+func checkDuplicateKeys(data []byte) error {
+	return checkDuplicateKeysIn(json.NewDecoder(bytes.NewReader(data)), "")
+}
+
+// checkDuplicateKeysIn walks the value starting at the next token. path
+// names the value in errors.
+func checkDuplicateKeysIn(dec *json.Decoder, path string) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		seen := make(map[string]bool)
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			key, _ := keyTok.(string)
+			keyPath := key
+			if path != "" {
+				keyPath = path + "." + key
+			}
+			if seen[key] {
+				return fmt.Errorf("duplicate key %q", keyPath)
+			}
+			seen[key] = true
+			if err := checkDuplicateKeysIn(dec, keyPath); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+		return err
+	case json.Delim('['):
+		for i := 0; dec.More(); i++ {
+			if err := checkDuplicateKeysIn(dec, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+		return err
+	}
+	return nil
+}
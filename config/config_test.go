@@ -0,0 +1,139 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// The seed corpus under testdata/fuzz holds the specs of the fixtures of
+// the repository, as container engines and tools write them, besides
+// the seeds added here.
+
+// fuzzMaxSize keeps the fuzzer's configs small enough to run quickly;
+// the limit itself is what is checked.
+const fuzzMaxSize = 64 << 10
+
+func FuzzLoad(f *testing.F) {
+	for _, seed := range []string{
+		``,
+		`null`,
+		`{}`,
+		`{"process":null,"root":null,"linux":null}`,
+		`{"process":{"args":["sh"],"cwd":"/"},"root":{"path":"rootfs"},"root":{"path":"/"}}`,
+		`{"ociVersion":"1.2.0","resolved":{"rootfs":"/frozen"},"noPivotRoot":true}`,
+		`{"linux":{"namespaces":[{"type":"pid"}]}} trailing`,
+		`[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]`,
+	} {
+		f.Add([]byte(seed), false)
+		f.Add([]byte(seed), true)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte, strict bool) {
+		cfg, err := DecodeWithOptions(bytes.NewReader(data), "/bundle", Options{MaxSize: fuzzMaxSize, Strict: strict})
+		if len(data) > fuzzMaxSize {
+			if err == nil {
+				t.Fatalf("accepted a config of %d bytes, over the limit of %d", len(data), fuzzMaxSize)
+			}
+			return
+		}
+		if err != nil {
+			return
+		}
+		if cfg.Spec == nil {
+			t.Fatal("decoded a config without a spec")
+		}
+		if cfg.Resolved == nil && !filepath.IsAbs(cfg.Rootfs) {
+			t.Fatalf("rootfs %q is not anchored to the bundle", cfg.Rootfs)
+		}
+		if strict && cfg.Resolved != nil {
+			t.Fatal("strict mode accepted the resolved form of a frozen config")
+		}
+
+		// Whatever was decoded, however little of it, is checked without
+		// panicking
+		_ = cfg.Validate()
+		_ = cfg.Warnings()
+		_ = cfg.NormalizeRoot()
+	})
+}
+
+func FuzzValidate(f *testing.F) {
+	for _, seed := range []string{
+		`{}`,
+		`{"process":{"args":["sh"],"cwd":"/"},"root":{"path":"/"}}`,
+		`{"process":{"commandLine":"cmd /C dir","cwd":"/"},"root":{"path":"/"},"windows":{}}`,
+		`{"process":{"args":["sh"],"cwd":"/","capabilities":{}},"root":{"path":"/"}}`,
+		`{"process":{"args":["sh"],"cwd":"/","env":["=x","A"]},"root":{"path":"/"},"hostname":"h"}`,
+		`{"process":{"args":["sh"],"cwd":"/"},"root":{"path":"/"},"linux":{"namespaces":[{"type":"uts"},{"type":""}],"seccomp":{"defaultAction":"SCMP_ACT_ERRNO","syscalls":[{"names":["kill"],"action":"SCMP_ACT_KILL"}]}}}`,
+		`{"process":{"args":["sh"],"cwd":"/"},"root":{"path":"/"},"linux":{"seccomp":{"flags":["SECCOMP_FILTER_FLAG_LOG"],"syscalls":[{"args":[{"index":7}]}]}}}`,
+		`{"process":{"args":["sh"],"cwd":"/"},"root":{"path":"/"},"mounts":[{"destination":"rel"},{"destination":"/x","type":""}],"annotations":{"\u0001":"x"}}`,
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var spec specs.Spec
+		if err := json.Unmarshal(data, &spec); err != nil {
+			return
+		}
+
+		err := Validate(&spec)
+		_ = Warnings(&spec)
+		_ = UnsupportedFields(&spec)
+		_ = ValidateAnnotations(spec.Annotations, DefaultMaxAnnotationsSize)
+		if err != nil {
+			return
+		}
+
+		// What passes is what the rest of the runtime relies on
+		if spec.Process == nil || len(spec.Process.Args) == 0 || !filepath.IsAbs(spec.Process.Cwd) {
+			t.Fatalf("accepted a spec without a usable process: %+v", spec.Process)
+		}
+		if spec.Root == nil || spec.Root.Path == "" {
+			t.Fatal("accepted a spec without a root")
+		}
+		for _, mnt := range spec.Mounts {
+			if !filepath.IsAbs(mnt.Destination) || mnt.Type == "" {
+				t.Fatalf("accepted mount %+v", mnt)
+			}
+		}
+		if spec.Hostname != "" && !hasUTSNamespace(&spec) {
+			t.Fatal("accepted a hostname without a UTS namespace")
+		}
+		if spec.Linux == nil && spec.Process.Capabilities != nil {
+			t.Fatal("accepted capabilities without a linux section")
+		}
+	})
+}
+
+func TestValidateNilFields(t *testing.T) {
+	process := &specs.Process{Args: []string{"sh"}, Cwd: "/"}
+	root := &specs.Root{Path: "/"}
+	tests := []struct {
+		name string
+		spec *specs.Spec
+		ok   bool
+	}{
+		{"nil spec", nil, false},
+		{"empty spec", &specs.Spec{}, false},
+		{"nil process", &specs.Spec{Root: root}, false},
+		{"nil root", &specs.Spec{Process: process}, false},
+		{"nil linux", &specs.Spec{Process: process, Root: root}, true},
+		{"nil linux with hostname", &specs.Spec{Process: process, Root: root, Hostname: "h"}, false},
+		{"empty linux", &specs.Spec{Process: process, Root: root, Linux: &specs.Linux{}}, true},
+		{"empty seccomp", &specs.Spec{Process: process, Root: root, Linux: &specs.Linux{Seccomp: &specs.LinuxSeccomp{}}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.spec)
+			if (err == nil) != tt.ok {
+				t.Fatalf("got %v, want ok %v", err, tt.ok)
+			}
+			_ = Warnings(tt.spec)
+		})
+	}
+}
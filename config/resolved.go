@@ -0,0 +1,69 @@
+package config
+
+import (
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// Resolved is a spec worked out against its bundle and the runtime's
+// defaults, once, when the container is created. It is frozen with the
+// spec, and everything that sets the container up reads it instead of
+// deriving the same details from the spec again.
+type Resolved struct {
+	// Rootfs is the absolute path of the root filesystem.
+	Rootfs string `json:"rootfs"`
+
+	// Namespaces are every namespace the container is created in or
+	// joins, by type, including the mount namespace the rootfs is always
+	// prepared in.
+	Namespaces []specs.LinuxNamespace `json:"namespaces"`
+
+	// Mounts are performed in order before pivot_root.
+	Mounts []Mount `json:"mounts,omitempty"`
+
+	// Devices are the nodes to create in /dev, the runtime's defaults
+	// first when the container has a /dev of its own.
+	Devices []specs.LinuxDevice `json:"devices,omitempty"`
+	// PrivateDev is set when the container has a /dev of its own.
+	PrivateDev bool `json:"privateDev,omitempty"`
+	// DevTmpfs is set when the runtime mounts that /dev itself, because
+	// the spec has devices but mounts nothing at /dev.
+	DevTmpfs bool `json:"devTmpfs,omitempty"`
+
+	// CgroupsPath is the container's cgroup relative to the root of
+	// every hierarchy, or empty when it runs without cgroups.
+	CgroupsPath string `json:"cgroupsPath,omitempty"`
+	// CgroupUnit is the systemd scope owning that cgroup, when systemd
+	// manages it.
+	CgroupUnit string `json:"cgroupUnit,omitempty"`
+	// Resources are the limits set on the cgroup, the device rules for
+	// Devices included.
+	Resources *specs.LinuxResources `json:"resources,omitempty"`
+
+	// Hooks are the hooks of every kind the spec has any of, by their
+	// name in the spec.
+	Hooks map[string][]specs.Hook `json:"hooks,omitempty"`
+}
+
+// Mount is a spec mount with its options sorted out for mount(2).
+type Mount struct {
+	// Destination is clean and absolute inside the rootfs.
+	Destination string `json:"destination"`
+	// Source of a bind mount is absolute.
+	Source string `json:"source,omitempty"`
+	Type   string `json:"type,omitempty"`
+
+	// Bind is set for a bind mount, asked for by type or by option.
+	Bind bool `json:"bind,omitempty"`
+	// Flags are the mount flags the options set, and ClearedFlags those
+	// they clear.
+	Flags        uintptr `json:"flags,omitempty"`
+	ClearedFlags uintptr `json:"clearedFlags,omitempty"`
+	// Propagation changes are applied in order once mounted.
+	Propagation []uintptr `json:"propagation,omitempty"`
+	// Data is the options left for the filesystem.
+	Data string `json:"data,omitempty"`
+
+	// OwnerFixup and ReplaceSymlink are the runtime's own options.
+	OwnerFixup     bool `json:"ownerFixup,omitempty"`
+	ReplaceSymlink bool `json:"replaceSymlink,omitempty"`
+}
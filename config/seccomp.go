@@ -0,0 +1,105 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// LinuxSeccompFlagTsync synchronizes the filter across every thread of
+// the process. The spec leaves it out of its constants but runtimes
+// accept it.
+const LinuxSeccompFlagTsync specs.LinuxSeccompFlag = "SECCOMP_FILTER_FLAG_TSYNC"
+
+// SeccompFlags are the linux.seccomp.flags hackontainer recognizes, in
+// the order of their bits in seccomp(2).
+var SeccompFlags = []specs.LinuxSeccompFlag{
+	LinuxSeccompFlagTsync,
+	specs.LinuxSeccompFlagLog,
+	specs.LinuxSeccompFlagSpecAllow,
+	specs.LinuxSeccompFlagWaitKillableRecv,
+}
+
+var seccompActions = map[specs.LinuxSeccompAction]bool{
+	specs.ActKill:        true,
+	specs.ActKillProcess: true,
+	specs.ActKillThread:  true,
+	specs.ActTrap:        true,
+	specs.ActErrno:       true,
+	specs.ActTrace:       true,
+	specs.ActAllow:       true,
+	specs.ActLog:         true,
+	specs.ActNotify:      true,
+}
+
+var seccompOperators = map[specs.LinuxSeccompOperator]bool{
+	specs.OpNotEqual:     true,
+	specs.OpLessThan:     true,
+	specs.OpLessEqual:    true,
+	specs.OpEqualTo:      true,
+	specs.OpGreaterEqual: true,
+	specs.OpGreaterThan:  true,
+	specs.OpMaskedEqual:  true,
+}
+
+// validateSeccomp rejects a profile the kernel would refuse to load:
+// unknown actions, operators or flags, an errno on an action that
+// returns none, and TSYNC with a notify action, which seccomp(2) can't
+// combine.
+func validateSeccomp(seccomp *specs.LinuxSeccomp) error {
+	if seccomp == nil {
+		return nil
+	}
+
+	if err := validateSeccompAction(seccomp.DefaultAction, seccomp.DefaultErrnoRet); err != nil {
+		return fmt.Errorf("seccomp defaultAction: %w", err)
+	}
+	notify := seccomp.DefaultAction == specs.ActNotify
+	for i, syscall := range seccomp.Syscalls {
+		if len(syscall.Names) == 0 {
+			return fmt.Errorf("seccomp syscalls[%d] names no syscall", i)
+		}
+		if err := validateSeccompAction(syscall.Action, syscall.ErrnoRet); err != nil {
+			return fmt.Errorf("seccomp syscalls[%d]: %w", i, err)
+		}
+		for _, arg := range syscall.Args {
+			if !seccompOperators[arg.Op] {
+				return fmt.Errorf("seccomp syscalls[%d]: unknown operator %q", i, arg.Op)
+			}
+		}
+		notify = notify || syscall.Action == specs.ActNotify
+	}
+
+	tsync := false
+	for _, flag := range seccomp.Flags {
+		known := false
+		for _, f := range SeccompFlags {
+			known = known || f == flag
+		}
+		if !known {
+			return fmt.Errorf("unknown seccomp flag %q", flag)
+		}
+		tsync = tsync || flag == LinuxSeccompFlagTsync
+	}
+	if notify && tsync {
+		return fmt.Errorf("seccomp flag %s can't be combined with %s", LinuxSeccompFlagTsync, specs.ActNotify)
+	}
+	if notify && seccomp.ListenerPath == "" {
+		return fmt.Errorf("seccomp action %s needs a listenerPath", specs.ActNotify)
+	}
+
+	return nil
+}
+
+func validateSeccompAction(action specs.LinuxSeccompAction, errnoRet *uint) error {
+	if action == "" {
+		return fmt.Errorf("action cannot be empty")
+	}
+	if !seccompActions[action] {
+		return fmt.Errorf("unknown action %q", action)
+	}
+	if errnoRet != nil && action != specs.ActErrno && action != specs.ActTrace {
+		return fmt.Errorf("errnoRet is only valid with %s and %s, not %s", specs.ActErrno, specs.ActTrace, action)
+	}
+	return nil
+}
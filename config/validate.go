@@ -5,6 +5,8 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/opencontainers/runtime-spec/specs-go"
 )
@@ -14,6 +16,10 @@ func Validate(spec *specs.Spec) error {
 		return fmt.Errorf("spec cannot be nil")
 	}
 
+	if err := validatePlatform(spec); err != nil {
+		return fmt.Errorf("platform validation failed: %w", err)
+	}
+
 	if err := validateProcess(spec.Process); err != nil {
 		return fmt.Errorf("process validation failed: %w", err)
 	}
@@ -33,6 +39,104 @@ func Validate(spec *specs.Spec) error {
 	return nil
 }
 
+// unsupportedFields is the single table of spec fields that are valid OCI
+// but only meaningful on other platforms. hackontainer never applies them.
+var unsupportedFields = []struct {
+	name     string
+	platform bool
+	present  func(*specs.Spec) bool
+}{
+	{"windows", true, func(s *specs.Spec) bool { return s.Windows != nil }},
+	{"solaris", true, func(s *specs.Spec) bool { return s.Solaris != nil }},
+	{"vm", true, func(s *specs.Spec) bool { return s.VM != nil }},
+	{"zos", true, func(s *specs.Spec) bool { return s.ZOS != nil }},
+	{"freebsd", true, func(s *specs.Spec) bool { return s.FreeBSD != nil }},
+	{"process.commandLine", false, func(s *specs.Spec) bool {
+		return s.Process != nil && s.Process.CommandLine != ""
+	}},
+}
+
+// linuxOnlyFields are the fields outside the linux section that only
+// mean something on Linux. A spec setting any of them without a linux
+// section was meant for Linux and lost that section, most likely to a
+// tool that rewrote it; run as is, it would get no namespaces at all.
+var linuxOnlyFields = []struct {
+	name    string
+	present func(*specs.Process) bool
+}{
+	{"process.capabilities", func(p *specs.Process) bool { return p.Capabilities != nil }},
+	{"process.apparmorProfile", func(p *specs.Process) bool { return p.ApparmorProfile != "" }},
+	{"process.selinuxLabel", func(p *specs.Process) bool { return p.SelinuxLabel != "" }},
+	{"process.oomScoreAdj", func(p *specs.Process) bool { return p.OOMScoreAdj != nil }},
+	{"process.scheduler", func(p *specs.Process) bool { return p.Scheduler != nil }},
+	{"process.ioPriority", func(p *specs.Process) bool { return p.IOPriority != nil }},
+	{"process.execCPUAffinity", func(p *specs.Process) bool { return p.ExecCPUAffinity != nil }},
+}
+
+// UnsupportedFields returns the names of every non-Linux field set in spec.
+func UnsupportedFields(spec *specs.Spec) []string {
+	var found []string
+	for _, f := range unsupportedFields {
+		if f.present(spec) {
+			found = append(found, f.name)
+		}
+	}
+	return found
+}
+
+// Warnings describes spec content that is accepted but will be ignored.
+// Anything that makes the spec unusable is reported by Validate instead.
+func Warnings(spec *specs.Spec) []string {
+	if spec == nil {
+		return nil
+	}
+
+	var warnings []string
+	if found := UnsupportedFields(spec); len(found) > 0 {
+		warnings = append(warnings, fmt.Sprintf("ignoring fields not supported on linux: %s", strings.Join(found, ", ")))
+	}
+	if spec.Linux != nil && spec.Linux.Seccomp != nil {
+		warnings = append(warnings, "linux.seccomp is validated but not enforced: no filter is loaded")
+	}
+
+	return warnings
+}
+
+func validatePlatform(spec *specs.Spec) error {
+	if spec.Process != nil && spec.Process.CommandLine != "" && len(spec.Process.Args) == 0 {
+		return fmt.Errorf("process.commandLine is only supported on windows; process.args must be set instead")
+	}
+
+	if spec.Linux != nil {
+		return nil
+	}
+
+	var platforms []string
+	for _, f := range unsupportedFields {
+		if f.platform && f.present(spec) {
+			platforms = append(platforms, f.name)
+		}
+	}
+	if len(platforms) > 0 {
+		return fmt.Errorf("spec has no linux section and targets unsupported platform(s): %s", strings.Join(platforms, ", "))
+	}
+
+	if spec.Process == nil {
+		return nil
+	}
+	var linuxOnly []string
+	for _, f := range linuxOnlyFields {
+		if f.present(spec.Process) {
+			linuxOnly = append(linuxOnly, f.name)
+		}
+	}
+	if len(linuxOnly) > 0 {
+		return fmt.Errorf("spec has no linux section but sets linux-only field(s): %s; add a linux section, without which the container gets no namespaces", strings.Join(linuxOnly, ", "))
+	}
+
+	return nil
+}
+
 func validateProcess(process *specs.Process) error {
 	if process == nil {
 		return fmt.Errorf("process cannot be nil")
@@ -51,7 +155,7 @@ func validateProcess(process *specs.Process) error {
 	}
 
 	for _, env := range process.Env {
-		if !strings.Contains(env, "=") {
+		if strings.Index(env, "=") <= 0 {
 			return fmt.Errorf("invalid environment variable format: %s", env)
 		}
 	}
@@ -69,13 +173,18 @@ func validateRoot(root *specs.Root) error {
 	}
 
 	if _, err := os.Stat(root.Path); os.IsNotExist(err) {
-		return fmt.Errorf("root filesystem does not exist: %s", root.Path)
+		return fmt.Errorf("root filesystem does not exist: %q", root.Path)
 	}
 
 	return nil
 }
 
 func validateLinux(spec *specs.Spec) error {
+	// Setting it without a UTS namespace would rename the host
+	if spec.Hostname != "" && !hasUTSNamespace(spec) {
+		return fmt.Errorf("hostname %q requires a UTS namespace", spec.Hostname)
+	}
+
 	if spec.Linux == nil {
 		return nil
 	}
@@ -92,13 +201,37 @@ func validateLinux(spec *specs.Spec) error {
 			specs.UTSNamespace,
 			specs.IPCNamespace,
 			specs.UserNamespace,
-			specs.CgroupNamespace:
+			specs.CgroupNamespace,
+			specs.TimeNamespace:
 		default:
 			return fmt.Errorf("invalid namespace type: %s", ns.Type)
 		}
 	}
 
-	return nil
+	for _, path := range spec.Linux.MaskedPaths {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("masked path must be absolute: %q", path)
+		}
+	}
+	for _, path := range spec.Linux.ReadonlyPaths {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("readonly path must be absolute: %q", path)
+		}
+	}
+
+	return validateSeccomp(spec.Linux.Seccomp)
+}
+
+func hasUTSNamespace(spec *specs.Spec) bool {
+	if spec.Linux == nil {
+		return false
+	}
+	for _, ns := range spec.Linux.Namespaces {
+		if ns.Type == specs.UTSNamespace {
+			return true
+		}
+	}
+	return false
 }
 
 func validateMounts(mounts []specs.Mount) error {
@@ -112,9 +245,73 @@ func validateMounts(mounts []specs.Mount) error {
 		}
 
 		if !filepath.IsAbs(mount.Destination) {
-			return fmt.Errorf("mount destination must be absolute path: %s", mount.Destination)
+			return fmt.Errorf("mount destination must be absolute path: %q", mount.Destination)
+		}
+	}
+
+	return nil
+}
+
+// DefaultMaxAnnotationsSize caps the combined size of annotation keys and
+// values. Annotations end up in state.json, hook stdin and the events
+// log, so an oversized one is refused up front.
+const DefaultMaxAnnotationsSize = 1 << 20
+
+// ValidateAnnotations rejects annotation keys that are empty or contain
+// control characters, keys and values that aren't UTF-8, which JSON
+// can't carry unchanged, and annotations larger than maxSize bytes in
+// total.
+func ValidateAnnotations(annotations map[string]string, maxSize int) error {
+	return validateKeyValues("annotation", annotations, maxSize)
+}
+
+// ValidateLabels applies the rules of ValidateAnnotations to the labels
+// the runtime keeps for a container.
+func ValidateLabels(labels map[string]string, maxSize int) error {
+	return validateKeyValues("label", labels, maxSize)
+}
+
+func validateKeyValues(kind string, values map[string]string, maxSize int) error {
+	size := 0
+	for k, v := range values {
+		if k == "" {
+			return fmt.Errorf("%s key cannot be empty", kind)
+		}
+		if hasControlChars(k) {
+			return fmt.Errorf("%s key %q contains control characters", kind, k)
+		}
+		if !utf8.ValidString(k) || !utf8.ValidString(v) {
+			return fmt.Errorf("%s %q is not valid UTF-8", kind, k)
 		}
+		size += len(k) + len(v)
 	}
 
+	if size > maxSize {
+		return fmt.Errorf("%ss total %d bytes, more than the limit of %d", kind, size, maxSize)
+	}
 	return nil
 }
+
+// ValidateBundlePath requires a clean absolute bundle path that is safe
+// to record in state and logs.
+func ValidateBundlePath(bundle string) error {
+	if !filepath.IsAbs(bundle) || filepath.Clean(bundle) != bundle {
+		return fmt.Errorf("bundle path must be clean and absolute: %q", bundle)
+	}
+	if hasControlChars(bundle) {
+		return fmt.Errorf("bundle path %q contains control characters", bundle)
+	}
+	if !utf8.ValidString(bundle) {
+		return fmt.Errorf("bundle path %q is not valid UTF-8", bundle)
+	}
+	return nil
+}
+
+func hasControlChars(s string) bool {
+	for _, r := range s {
+		if unicode.IsControl(r) {
+			return true
+		}
+	}
+	return false
+}
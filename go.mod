@@ -4,10 +4,10 @@ go 1.24.0
 
 require (
 	github.com/opencontainers/runtime-spec v1.3.0
+	golang.org/x/sys v0.13.0
 )
 
 require (
 	github.com/cpuguy83/go-md2man/v2 v2.0.7 // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
-	golang.org/x/sys v0.13.0 // indirect
 )
@@ -0,0 +1,140 @@
+package libcontainer
+
+import (
+	"debug/elf"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/zakarynichols/hackontainer/libcontainer/rootfsfile"
+)
+
+// compatMachines are the architectures a host runs besides its own.
+var compatMachines = map[string][]elf.Machine{
+	"amd64": {elf.EM_386},
+	"arm64": {elf.EM_ARM},
+}
+
+// binfmtMiscDir lists the handlers the kernel runs foreign binaries
+// with.
+const binfmtMiscDir = "/proc/sys/fs/binfmt_misc"
+
+// checkRootfsArch fails with ErrArchMismatch when the binary process
+// would exec in rootfs is built for an architecture the host can't run,
+// which start would only report as ENOEXEC. A script is judged by its
+// interpreter, one level deep, and a binary that can't be found before
+// start by the rootfs's /bin/sh. Anything that can't be read or isn't
+// ELF passes: the check only ever turns a sure failure into a clearer
+// one.
+func checkRootfsArch(rootfs string, process *specs.Process) error {
+	host, known := hostMachines[runtime.GOARCH]
+	if !known || process == nil || emulatorRegistered() {
+		return nil
+	}
+
+	fs, err := rootfsfile.Open(rootfs)
+	if err != nil {
+		return nil
+	}
+	defer fs.Close()
+
+	binary, ok := resolveProcessBinary(fs, process)
+	if !ok {
+		binary = "/bin/sh"
+	}
+	machine, ok := rootfsMachine(fs, binary)
+	if !ok {
+		return nil
+	}
+	if machine == host {
+		return nil
+	}
+	for _, compat := range compatMachines[runtime.GOARCH] {
+		if machine == compat {
+			return nil
+		}
+	}
+	return newTypedError(ErrArchMismatch, "rootfs binary %s is %s but host is %s",
+		binary, strings.ToLower(machineName(machine)), strings.ToLower(machineName(host)))
+}
+
+// resolveProcessBinary finds the file process.args[0] names in fs the
+// way the init stage does: a relative name is tried from the root of
+// the rootfs, then along the container's PATH.
+func resolveProcessBinary(fs rootfsfile.FS, process *specs.Process) (string, bool) {
+	name := "/bin/sh"
+	if len(process.Args) > 0 {
+		name = process.Args[0]
+	}
+	candidates := []string{path.Join("/", name)}
+	if !filepath.IsAbs(name) && !strings.Contains(name, "/") {
+		pathValue, _ := lookupEnv(containerEnv(process.Env), "PATH")
+		for _, dir := range filepath.SplitList(pathValue) {
+			candidates = append(candidates, path.Join("/", dir, name))
+		}
+	}
+	for _, candidate := range candidates {
+		if isRegularFile(fs, candidate) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// rootfsMachine returns the architecture of the ELF binary at name in
+// fs, or for a script, of its interpreter.
+func rootfsMachine(fs rootfsfile.FS, name string) (elf.Machine, bool) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+	if machine, ok := elfMachine(rootfsfile.ProcPath(f)); ok {
+		return machine, true
+	}
+
+	interpreter, ok := readShebang(rootfsfile.ProcPath(f))
+	if !ok || !isRegularFile(fs, interpreter) {
+		return 0, false
+	}
+	g, err := fs.Open(interpreter)
+	if err != nil {
+		return 0, false
+	}
+	defer g.Close()
+	return elfMachine(rootfsfile.ProcPath(g))
+}
+
+// isRegularFile reports whether name in fs, following symlinks, is a
+// regular file.
+func isRegularFile(fs rootfsfile.FS, name string) bool {
+	f, err := fs.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	return err == nil && info.Mode().IsRegular()
+}
+
+// emulatorRegistered reports whether binfmt_misc has an enabled qemu
+// handler, which runs binaries of other architectures too.
+func emulatorRegistered() bool {
+	entries, err := os.ReadDir(binfmtMiscDir)
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), "qemu-") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(binfmtMiscDir, entry.Name()))
+		if err == nil && strings.HasPrefix(string(data), "enabled") {
+			return true
+		}
+	}
+	return false
+}
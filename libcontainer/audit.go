@@ -0,0 +1,140 @@
+package libcontainer
+
+import (
+	"errors"
+	"path/filepath"
+
+	"github.com/zakarynichols/hackontainer/libcontainer/audit"
+)
+
+// auditFilename is the audit log under the factory root, shared by
+// every namespace.
+const auditFilename = "audit.log"
+
+// Audited operations.
+const (
+	AuditCreate     = "create"
+	AuditStart      = "start"
+	AuditRun        = "run"
+	AuditKill       = "kill"
+	AuditPause      = "pause"
+	AuditResume     = "resume"
+	AuditDelete     = "delete"
+	AuditExec       = "exec"
+	AuditUpdate     = "update"
+	AuditCheckpoint = "checkpoint"
+	AuditRestore    = "restore"
+)
+
+// errorKinds name the error kinds in audit records, in the order they
+// are matched.
+var errorKinds = []struct {
+	kind error
+	name string
+}{
+	{ErrNotExist, "not-exist"},
+	{ErrExist, "exist"},
+	{ErrInvalidID, "invalid-id"},
+	{ErrInvalidConfig, "invalid-config"},
+	{ErrNotRunning, "not-running"},
+	{ErrRunning, "running"},
+	{ErrInvalidState, "invalid-state"},
+	{ErrNamespaceMismatch, "namespace-mismatch"},
+	{ErrHooksDisabled, "hooks-disabled"},
+	{ErrMissingKernelFeatures, "missing-kernel-features"},
+	{ErrMissingPrivileges, "missing-privileges"},
+	{ErrUnsupportedLayout, "unsupported-layout"},
+	{ErrCriuNotFound, "criu-not-found"},
+	{ErrSharedRoot, "shared-root"},
+	{ErrNotOwner, "not-owner"},
+	{ErrArchMismatch, "arch-mismatch"},
+}
+
+// errorKind names the kind of err for an audit record.
+func errorKind(err error) string {
+	for _, k := range errorKinds {
+		if errors.Is(err, k.kind) {
+			return k.name
+		}
+	}
+	var startErr *StartError
+	if errors.As(err, &startErr) {
+		return "start-failed"
+	}
+	return "other"
+}
+
+// WithAuditSink sends the audit records of the factory's containers to
+// sink instead of the audit log under the factory root.
+func WithAuditSink(sink audit.Sink) CreateOption {
+	return func(l *LinuxFactory) error {
+		l.auditSink = sink
+		return nil
+	}
+}
+
+// WithCreateCaller attributes a create, and what is done with the
+// container it returns, to caller instead of the runtime itself. A
+// server acting for its clients passes their credentials.
+func WithCreateCaller(caller audit.Caller) CreateOption {
+	return func(l *LinuxFactory) error {
+		l.caller = &caller
+		return nil
+	}
+}
+
+// WithCaller attributes what is done with a loaded container to caller
+// instead of the runtime itself.
+func WithCaller(caller audit.Caller) LoadOption {
+	return func(c *linuxContainer) error {
+		c.caller = &caller
+		return nil
+	}
+}
+
+// newAuditRecord describes op on the container id by caller, ending
+// with err.
+func newAuditRecord(op, id, namespace string, caller *audit.Caller, args map[string]string, err error) audit.Record {
+	if caller == nil {
+		self := audit.Self()
+		caller = &self
+	}
+	rec := audit.Record{
+		Operation: op,
+		ID:        id,
+		Namespace: namespace,
+		Result:    audit.Success,
+		UID:       caller.UID,
+		GID:       caller.GID,
+		PID:       caller.PID,
+		Args:      args,
+	}
+	if err != nil {
+		rec.Result = audit.Failure
+		rec.ErrorKind = errorKind(err)
+		rec.Error = err.Error()
+	}
+	return rec
+}
+
+// audit records op on the container, ending with err.
+func (c *linuxContainer) audit(op string, args map[string]string, err error) {
+	sink := c.auditSink
+	if sink == nil {
+		sink = audit.NewFileSink(filepath.Join(c.factoryRoot(), auditFilename))
+	}
+	rec := newAuditRecord(op, c.id, c.namespace, c.caller, args, err)
+	if err != nil {
+		rec.Error = c.redactSensitive(rec.Error)
+	}
+	audit.Log(sink, rec)
+}
+
+// auditCreate records a create of id from bundle, ending with err.
+func (l *LinuxFactory) auditCreate(id, bundle string, err error) {
+	sink := l.auditSink
+	if sink == nil {
+		sink = audit.NewFileSink(filepath.Join(l.root, auditFilename))
+	}
+	audit.Log(sink, newAuditRecord(AuditCreate, id, l.namespace, l.caller, map[string]string{"bundle": bundle}, err))
+}
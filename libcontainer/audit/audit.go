@@ -0,0 +1,143 @@
+// Package audit records who did what to which container. Unlike the
+// debug output and the events log, which say what happened to a
+// container, the audit log says who asked for it, and keeps the
+// operations that failed.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/zakarynichols/hackontainer/api/types"
+)
+
+// Record is a line of the audit log.
+type Record = types.AuditRecord
+
+// Results of an operation.
+const (
+	Success = types.AuditSuccess
+	Failure = types.AuditFailure
+)
+
+// Caller is who asked for an operation.
+type Caller struct {
+	UID int
+	GID int
+	PID int
+}
+
+// Self is the calling process.
+func Self() Caller {
+	return Caller{UID: os.Getuid(), GID: os.Getgid(), PID: os.Getpid()}
+}
+
+// Sink stores records.
+type Sink interface {
+	Write(rec Record) error
+}
+
+// Log writes rec to sink, stamped with the time. Auditing is best
+// effort: a failure is reported but never fails the operation.
+func Log(sink Sink, rec Record) {
+	if rec.Timestamp.IsZero() {
+		rec.Timestamp = time.Now()
+	}
+	rec.SchemaVersion = types.SchemaVersion
+	if err := sink.Write(rec); err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: failed to audit %s of %s: %v\n", rec.Operation, rec.ID, err)
+	}
+}
+
+// fileSink appends records as JSON lines to a file.
+type fileSink struct {
+	path string
+}
+
+// NewFileSink appends records to the file at path, creating it
+// readable by root only.
+func NewFileSink(path string) Sink {
+	return fileSink{path: path}
+}
+
+func (s fileSink) Write(rec Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	// One write per line with O_APPEND keeps concurrent writers from
+	// interleaving
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// journalSocket is where journald takes entries in its native protocol.
+const journalSocket = "/run/systemd/journal/socket"
+
+// journalSink sends records to journald, the record as the message and
+// its main fields as fields of their own to match on.
+type journalSink struct {
+	addr *net.UnixAddr
+}
+
+// NewJournalSink sends records to journald. It fails when journald
+// isn't listening.
+func NewJournalSink() (Sink, error) {
+	if _, err := os.Stat(journalSocket); err != nil {
+		return nil, fmt.Errorf("journald is not available: %w", err)
+	}
+	return journalSink{addr: &net.UnixAddr{Name: journalSocket, Net: "unixgram"}}, nil
+}
+
+func (s journalSink) Write(rec Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	// Values are single lines, so the plain KEY=value form does
+	fields := []string{
+		"MESSAGE=" + string(data),
+		"SYSLOG_IDENTIFIER=hackontainer-audit",
+		"PRIORITY=5",
+		"HACKONTAINER_OPERATION=" + rec.Operation,
+		"HACKONTAINER_CONTAINER_ID=" + rec.ID,
+		"HACKONTAINER_RESULT=" + string(rec.Result),
+		"HACKONTAINER_CALLER_UID=" + strconv.Itoa(rec.UID),
+	}
+	var msg []byte
+	for _, field := range fields {
+		msg = append(msg, field...)
+		msg = append(msg, '\n')
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, s.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write(msg)
+	return err
+}
+
+// discardSink drops every record.
+type discardSink struct{}
+
+// Discard is a Sink for when auditing is off.
+func Discard() Sink {
+	return discardSink{}
+}
+
+func (discardSink) Write(Record) error {
+	return nil
+}
@@ -0,0 +1,710 @@
+package libcontainer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/zakarynichols/hackontainer/api/types"
+	"github.com/zakarynichols/hackontainer/libcontainer/specconv"
+	"golang.org/x/sys/unix"
+)
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupDelegateAnnotation set to "true" hands the container's cgroup to
+// the container's root user so it can manage its own sub-cgroups. It
+// lets the container move its processes around and set limits below
+// its own, so it is opt-in.
+const cgroupDelegateAnnotation = "org.hackontainer.cgroup-delegate"
+
+// CgroupManager places the container process in its cgroup and applies
+// the spec's resource limits to it.
+type CgroupManager interface {
+	// Apply creates the cgroup and moves pid into it.
+	Apply(pid int) error
+	// AddProcess moves pid into the cgroup Apply created, such as the
+	// other processes of a restored tree.
+	AddProcess(pid int) error
+	// Set writes the resource limits.
+	Set(resources *specs.LinuxResources) error
+	// Paths returns the cgroup directory per controller. On cgroup v2
+	// there is a single entry under the empty key.
+	Paths() map[string]string
+	// Delegate hands the cgroup to uid and gid following the cgroup v2
+	// delegation model.
+	Delegate(uid, gid int) error
+	// Stats reads the cgroup's resource usage. It still works once the
+	// cgroup is empty, until Destroy.
+	Stats() (*types.Stats, error)
+	// Pids lists the processes in the cgroup.
+	Pids() ([]int, error)
+	// Freeze freezes or thaws every process in the cgroup. It returns
+	// errFreezerUnavailable when there is no freezer to use.
+	Freeze(frozen bool) error
+	// Kill sends SIGKILL to every process in the cgroup at once. It
+	// returns errCgroupKillUnavailable when the kernel can't.
+	Kill() error
+	// Destroy removes the cgroup. It succeeds if it is already gone.
+	Destroy() error
+}
+
+// WithCgroupParent places the cgroups of containers whose spec doesn't
+// set linux.cgroupsPath below parent instead of specconv.DefaultCgroupParent, so
+// a tenant's containers can share limits set on parent. Missing
+// directories on the way are created at start.
+func WithCgroupParent(parent string) CreateOption {
+	return func(l *LinuxFactory) error {
+		if !filepath.IsAbs(parent) {
+			return fmt.Errorf("cgroup parent %q must be an absolute path", parent)
+		}
+		l.cgroupParent = filepath.Clean(parent)
+		return nil
+	}
+}
+
+// applyCgroupParent freezes the cgroup path below parent into spec, so
+// later commands find the cgroup without the factory that created it. An
+// explicit linux.cgroupsPath wins.
+func applyCgroupParent(parent string, spec *specs.Spec, name string) {
+	if parent == "" || specconv.CgroupsDisabled(spec) {
+		return
+	}
+	if spec.Linux == nil {
+		spec.Linux = &specs.Linux{}
+	}
+	if spec.Linux.CgroupsPath == "" {
+		spec.Linux.CgroupsPath = filepath.Join(parent, name)
+	}
+}
+
+// newCgroupManager returns the manager for the host's cgroup layout, for
+// the cgroup at the resolved path, which is empty for a container without
+// cgroups. Destroy retries as retry says.
+func newCgroupManager(path string, retry RetryPolicy) CgroupManager {
+	if path == "" {
+		return noCgroupManager{}
+	}
+	return cgroupManagerAt(path, retry)
+}
+
+// cgroupManagerAt returns the manager of the cgroup at path, relative to
+// the root of every hierarchy.
+func cgroupManagerAt(path string, retry RetryPolicy) CgroupManager {
+	if isCgroup2UnifiedMode() {
+		return &cgroupV2Manager{path: filepath.Join(cgroupRoot, path), retry: retry}
+	}
+	return &cgroupV1Manager{path: path, retry: retry}
+}
+
+func (c *linuxContainer) cgroupManager() CgroupManager {
+	resolved := c.config.Resolved
+	manager := newCgroupManager(resolved.CgroupsPath, c.retry)
+	if v2, ok := manager.(*cgroupV2Manager); ok {
+		v2.threaded = cgroupThreaded(c.config.Spec)
+	}
+	if resolved.CgroupUnit != "" && resolved.CgroupsPath != "" {
+		return &systemdCgroupManager{CgroupManager: manager, unit: resolved.CgroupUnit, path: resolved.CgroupsPath}
+	}
+	return manager
+}
+
+func cgroupDelegated(spec *specs.Spec) bool {
+	return spec != nil && spec.Annotations[cgroupDelegateAnnotation] == "true"
+}
+
+// validateCgroupDelegation checks that a delegated cgroup has a user
+// namespace root to be handed to.
+func validateCgroupDelegation(spec *specs.Spec) error {
+	if !cgroupDelegated(spec) {
+		return nil
+	}
+	if specconv.CgroupsDisabled(spec) {
+		return fmt.Errorf("%s: the container runs without cgroups", cgroupDelegateAnnotation)
+	}
+	if _, _, err := hostRootIDs(spec); err != nil {
+		return fmt.Errorf("%s: %w", cgroupDelegateAnnotation, err)
+	}
+	// Without its own cgroup namespace the container would see, and
+	// mount, the host's whole hierarchy
+	cgroupns := false
+	for _, ns := range specconv.Namespaces(spec) {
+		if ns.Type == specs.CgroupNamespace && ns.Path == "" {
+			cgroupns = true
+		}
+	}
+	if !cgroupns {
+		return fmt.Errorf("%s: a new cgroup namespace is required", cgroupDelegateAnnotation)
+	}
+	if !isCgroup2UnifiedMode() {
+		return fmt.Errorf("%s: cgroup delegation requires cgroup v2", cgroupDelegateAnnotation)
+	}
+	return nil
+}
+
+// hostRootIDs returns the host uid and gid that container root maps to
+// in a newly created user namespace.
+func hostRootIDs(spec *specs.Spec) (int, int, error) {
+	if !newUserNamespace(spec) {
+		return 0, 0, fmt.Errorf("a new user namespace is required")
+	}
+
+	uid, ok := hostID(spec.Linux.UIDMappings, 0)
+	if !ok {
+		return 0, 0, fmt.Errorf("uid mappings do not map container root")
+	}
+	gid, ok := hostID(spec.Linux.GIDMappings, 0)
+	if !ok {
+		return 0, 0, fmt.Errorf("gid mappings do not map container root")
+	}
+	return uid, gid, nil
+}
+
+func hostID(mappings []specs.LinuxIDMapping, id uint32) (int, bool) {
+	for _, m := range mappings {
+		if id >= m.ContainerID && id-m.ContainerID < m.Size {
+			return int(m.HostID + id - m.ContainerID), true
+		}
+	}
+	return 0, false
+}
+
+// isCgroup2UnifiedMode reports whether cgroup v2 is mounted at the
+// cgroup root rather than alongside v1 hierarchies.
+func isCgroup2UnifiedMode() bool {
+	var st unix.Statfs_t
+	if err := unix.Statfs(cgroupRoot, &st); err != nil {
+		return false
+	}
+	return st.Type == unix.CGROUP2_SUPER_MAGIC
+}
+
+func writeCgroupFile(dir, file, value string) error {
+	if err := os.WriteFile(filepath.Join(dir, file), []byte(value), 0); err != nil {
+		return fmt.Errorf("failed to write %q to %s: %w", value, file, err)
+	}
+	return nil
+}
+
+func readCgroupFile(dir, file string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// readCgroupUint reads a file holding a single number. It returns nil
+// if the file doesn't exist on this kernel.
+func readCgroupUint(dir, file string) *uint64 {
+	value, err := readCgroupFile(dir, file)
+	if err != nil {
+		return nil
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &n
+}
+
+// readCgroupLimit reads a limit file, where "max" means no limit and is
+// returned as the largest uint64, which is what cgroup v1 reports in
+// effect.
+func readCgroupLimit(dir, file string) *uint64 {
+	if value, err := readCgroupFile(dir, file); err == nil && value == "max" {
+		n := uint64(math.MaxUint64)
+		return &n
+	}
+	return readCgroupUint(dir, file)
+}
+
+// readCgroupKey reads the value of key from a flat keyed file such as
+// cpu.stat or memory.events.
+func readCgroupKey(dir, file, key string) *uint64 {
+	data, err := readCgroupFile(dir, file)
+	if err != nil {
+		return nil
+	}
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != key {
+			continue
+		}
+		if n, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			return &n
+		}
+	}
+	return nil
+}
+
+// removeCgroupDir removes a cgroup directory. The kernel reports EBUSY
+// until the last exiting task has left the cgroup, so that is retried
+// as retry says. When it stays busy, the error names what is in it.
+func removeCgroupDir(s sysCalls, path string, retry RetryPolicy) error {
+	err := retryBusy(context.Background(), retry, "rmdir "+path, func() error {
+		return cleanupRmdir(s, path)
+	})
+	if err == nil || errors.Is(err, unix.ENOENT) {
+		return nil
+	}
+	return busyError(err, cgroupHolders(path))
+}
+
+// cgroupV1Subsystems are the v1 controllers a container joins. Missing
+// hierarchies are skipped.
+var cgroupV1Subsystems = []string{"pids", "memory", "cpu", "cpuacct", "cpuset", "blkio", "freezer", "devices"}
+
+type cgroupV1Manager struct {
+	// path is relative to every hierarchy's mount point
+	path    string
+	cgroups map[string]string
+	retry   RetryPolicy
+}
+
+func (m *cgroupV1Manager) Apply(pid int) error {
+	m.cgroups = make(map[string]string)
+	for _, subsystem := range cgroupV1Subsystems {
+		mountpoint := filepath.Join(cgroupRoot, subsystem)
+		if _, err := os.Stat(mountpoint); err != nil {
+			continue
+		}
+
+		dir := filepath.Join(mountpoint, m.path)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create %s cgroup: %w", subsystem, err)
+		}
+		m.cgroups[subsystem] = dir
+
+		if subsystem == "cpuset" {
+			if err := initCpuset(mountpoint, dir); err != nil {
+				return err
+			}
+		}
+	}
+	return m.AddProcess(pid)
+}
+
+func (m *cgroupV1Manager) AddProcess(pid int) error {
+	for subsystem, dir := range m.Paths() {
+		if err := writeCgroupFile(dir, "cgroup.procs", strconv.Itoa(pid)); err != nil {
+			return fmt.Errorf("failed to join %s cgroup: %w", subsystem, err)
+		}
+	}
+	return nil
+}
+
+// initCpuset copies cpuset.cpus and cpuset.mems down from the nearest
+// configured ancestor; a v1 cpuset cgroup rejects tasks until both are set.
+func initCpuset(mountpoint, dir string) error {
+	if dir == mountpoint {
+		return nil
+	}
+	if err := initCpuset(mountpoint, filepath.Dir(dir)); err != nil {
+		return err
+	}
+
+	for _, file := range []string{"cpuset.cpus", "cpuset.mems"} {
+		current, err := readCgroupFile(dir, file)
+		if err != nil {
+			return err
+		}
+		if current != "" {
+			continue
+		}
+		parent, err := readCgroupFile(filepath.Dir(dir), file)
+		if err != nil {
+			return err
+		}
+		if err := writeCgroupFile(dir, file, parent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *cgroupV1Manager) Set(r *specs.LinuxResources) error {
+	if r == nil {
+		return nil
+	}
+	// Paths works for a manager of an existing cgroup, not just one Apply
+	// created
+	paths := m.Paths()
+
+	if r.Pids != nil && paths["pids"] != "" {
+		limit := "max"
+		if r.Pids.Limit != nil && *r.Pids.Limit > 0 {
+			limit = strconv.FormatInt(*r.Pids.Limit, 10)
+		}
+		if err := writeCgroupFile(paths["pids"], "pids.max", limit); err != nil {
+			return err
+		}
+	}
+
+	if dir := paths["devices"]; dir != "" {
+		for _, rule := range r.Devices {
+			file := "devices.deny"
+			if rule.Allow {
+				file = "devices.allow"
+			}
+			if err := writeCgroupFile(dir, file, deviceRuleString(rule)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if mem := r.Memory; mem != nil && paths["memory"] != "" {
+		dir := paths["memory"]
+		writeSwap := func() error {
+			if mem.Swap == nil {
+				return nil
+			}
+			return writeCgroupFile(dir, "memory.memsw.limit_in_bytes", strconv.FormatInt(*mem.Swap, 10))
+		}
+		// memory+swap can never be below memory, so raising the memory
+		// limit past it needs memory+swap raised first
+		swapFirst := false
+		if mem.Limit != nil && mem.Swap != nil {
+			memsw := readCgroupUint(dir, "memory.memsw.limit_in_bytes")
+			swapFirst = memsw != nil && (*mem.Limit < 0 || uint64(*mem.Limit) > *memsw)
+		}
+		if swapFirst {
+			if err := writeSwap(); err != nil {
+				return err
+			}
+		}
+		if mem.Limit != nil {
+			if err := writeCgroupFile(dir, "memory.limit_in_bytes", strconv.FormatInt(*mem.Limit, 10)); err != nil {
+				return err
+			}
+		}
+		if mem.Reservation != nil {
+			if err := writeCgroupFile(dir, "memory.soft_limit_in_bytes", strconv.FormatInt(*mem.Reservation, 10)); err != nil {
+				return err
+			}
+		}
+		if !swapFirst {
+			if err := writeSwap(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if cpu := r.CPU; cpu != nil {
+		if dir := paths["cpu"]; dir != "" {
+			if cpu.Shares != nil {
+				if err := writeCgroupFile(dir, "cpu.shares", strconv.FormatUint(*cpu.Shares, 10)); err != nil {
+					return err
+				}
+			}
+			if cpu.Period != nil {
+				if err := writeCgroupFile(dir, "cpu.cfs_period_us", strconv.FormatUint(*cpu.Period, 10)); err != nil {
+					return err
+				}
+			}
+			if cpu.Quota != nil {
+				if err := writeCgroupFile(dir, "cpu.cfs_quota_us", strconv.FormatInt(*cpu.Quota, 10)); err != nil {
+					return err
+				}
+			}
+			if cpu.Burst != nil {
+				if err := writeCPUBurst(dir, "cpu.cfs_burst_us", *cpu.Burst); err != nil {
+					return err
+				}
+			}
+		} else if cpu.Burst != nil {
+			return fmt.Errorf("cpu burst needs the cpu cgroup controller")
+		}
+		if dir := paths["cpuset"]; dir != "" {
+			if cpu.Cpus != "" {
+				if err := writeCgroupFile(dir, "cpuset.cpus", cpu.Cpus); err != nil {
+					return err
+				}
+			}
+			if cpu.Mems != "" {
+				if err := writeCgroupFile(dir, "cpuset.mems", cpu.Mems); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// deviceRuleString formats a rule for devices.allow and devices.deny.
+func deviceRuleString(rule specs.LinuxDeviceCgroup) string {
+	devType := rule.Type
+	if devType == "" {
+		devType = "a"
+	}
+	major, minor := "*", "*"
+	if rule.Major != nil && *rule.Major >= 0 {
+		major = strconv.FormatInt(*rule.Major, 10)
+	}
+	if rule.Minor != nil && *rule.Minor >= 0 {
+		minor = strconv.FormatInt(*rule.Minor, 10)
+	}
+	access := rule.Access
+	if access == "" {
+		access = "rwm"
+	}
+	return fmt.Sprintf("%s %s:%s %s", devType, major, minor, access)
+}
+
+func (m *cgroupV1Manager) Paths() map[string]string {
+	if m.cgroups == nil {
+		paths := make(map[string]string)
+		for _, subsystem := range cgroupV1Subsystems {
+			mountpoint := filepath.Join(cgroupRoot, subsystem)
+			if _, err := os.Stat(mountpoint); err == nil {
+				paths[subsystem] = filepath.Join(mountpoint, m.path)
+			}
+		}
+		return paths
+	}
+	return m.cgroups
+}
+
+func (m *cgroupV1Manager) Delegate(uid, gid int) error {
+	return fmt.Errorf("cgroup delegation requires cgroup v2")
+}
+
+func (m *cgroupV1Manager) Stats() (*types.Stats, error) {
+	paths := m.Paths()
+	stats := &types.Stats{}
+	if dir := paths["cpuacct"]; dir != "" {
+		if ns := readCgroupUint(dir, "cpuacct.usage"); ns != nil {
+			usec := *ns / 1000
+			stats.CPUUsageUsec = &usec
+		}
+	}
+	if dir := paths["memory"]; dir != "" {
+		stats.MemoryUsageBytes = readCgroupUint(dir, "memory.usage_in_bytes")
+		stats.MemoryPeakBytes = readCgroupUint(dir, "memory.max_usage_in_bytes")
+		stats.MemoryLimitBytes = readCgroupUint(dir, "memory.limit_in_bytes")
+		stats.OOMKills = readCgroupKey(dir, "memory.oom_control", "oom_kill")
+	}
+	if dir := paths["pids"]; dir != "" {
+		stats.PidsCurrent = readCgroupUint(dir, "pids.current")
+		stats.PidsPeak = readCgroupUint(dir, "pids.peak")
+	}
+	return stats, nil
+}
+
+func (m *cgroupV1Manager) Destroy() error {
+	var errs []string
+	for _, dir := range m.Paths() {
+		if err := removeCgroupDir(hostSys, dir, m.retry); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to remove cgroups: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+type cgroupV2Manager struct {
+	path  string
+	retry RetryPolicy
+	// threaded lets Apply join a threaded cgroup through cgroup.threads.
+	threaded bool
+}
+
+func (m *cgroupV2Manager) Apply(pid int) error {
+	if err := os.MkdirAll(m.path, 0755); err != nil {
+		return fmt.Errorf("failed to create cgroup: %w", err)
+	}
+
+	// Controllers must be enabled in every ancestor's subtree_control for
+	// their files to show up in the container's cgroup
+	rel, err := filepath.Rel(cgroupRoot, m.path)
+	if err != nil {
+		return err
+	}
+	dir := cgroupRoot
+	for _, elem := range strings.Split(rel, string(filepath.Separator)) {
+		if err := enableControllers(dir); err != nil {
+			return err
+		}
+		dir = filepath.Join(dir, elem)
+	}
+
+	return m.AddProcess(pid)
+}
+
+func (m *cgroupV2Manager) AddProcess(pid int) error {
+	if err := m.joinCgroup(pid); err != nil {
+		return fmt.Errorf("failed to join cgroup: %w", err)
+	}
+	return nil
+}
+
+// enableControllers delegates every controller available in dir to its
+// children. Controllers already enabled are left alone, and so is a
+// cgroup the runtime can't write to: it belongs to whoever delegated a
+// subtree to the runtime, and limits needing a controller it withholds
+// fail when they are set.
+func enableControllers(dir string) error {
+	available, err := readCgroupFile(dir, "cgroup.controllers")
+	if err != nil {
+		return err
+	}
+	enabled, err := readCgroupFile(dir, "cgroup.subtree_control")
+	if err != nil {
+		return err
+	}
+	var enable []string
+	for _, controller := range strings.Fields(available) {
+		if !slices.Contains(strings.Fields(enabled), controller) {
+			enable = append(enable, "+"+controller)
+		}
+	}
+	if len(enable) == 0 {
+		return nil
+	}
+	if unix.Access(filepath.Join(dir, "cgroup.subtree_control"), unix.W_OK) != nil {
+		return nil
+	}
+	return writeCgroupFile(dir, "cgroup.subtree_control", strings.Join(enable, " "))
+}
+
+func (m *cgroupV2Manager) Set(r *specs.LinuxResources) error {
+	if r == nil {
+		return nil
+	}
+
+	if r.Pids != nil {
+		limit := "max"
+		if r.Pids.Limit != nil && *r.Pids.Limit > 0 {
+			limit = strconv.FormatInt(*r.Pids.Limit, 10)
+		}
+		if err := writeCgroupFile(m.path, "pids.max", limit); err != nil {
+			return err
+		}
+	}
+
+	if len(r.Devices) > 0 {
+		if err := attachDeviceFilter(m.path, r.Devices); err != nil {
+			return err
+		}
+	}
+
+	if mem := r.Memory; mem != nil {
+		if mem.Limit != nil {
+			if err := writeCgroupFile(m.path, "memory.max", memoryValue(*mem.Limit)); err != nil {
+				return err
+			}
+		}
+		if mem.Reservation != nil {
+			if err := writeCgroupFile(m.path, "memory.low", memoryValue(*mem.Reservation)); err != nil {
+				return err
+			}
+		}
+		// The spec's swap is memory+swap while v2 limits swap alone
+		if mem.Swap != nil && mem.Limit != nil {
+			swap := *mem.Swap
+			if swap > 0 && *mem.Limit > 0 {
+				swap -= *mem.Limit
+			}
+			if err := writeCgroupFile(m.path, "memory.swap.max", memoryValue(swap)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if cpu := r.CPU; cpu != nil {
+		if cpu.Shares != nil && *cpu.Shares > 0 {
+			weight := 1 + ((*cpu.Shares-2)*9999)/262142
+			if err := writeCgroupFile(m.path, "cpu.weight", strconv.FormatUint(weight, 10)); err != nil {
+				return err
+			}
+		}
+		if cpu.Quota != nil || cpu.Period != nil {
+			quota := "max"
+			if cpu.Quota != nil && *cpu.Quota > 0 {
+				quota = strconv.FormatInt(*cpu.Quota, 10)
+			}
+			period := uint64(100000)
+			if cpu.Period != nil && *cpu.Period > 0 {
+				period = *cpu.Period
+			}
+			if err := writeCgroupFile(m.path, "cpu.max", fmt.Sprintf("%s %d", quota, period)); err != nil {
+				return err
+			}
+		}
+		// The kernel checks the burst against the quota just written
+		if cpu.Burst != nil {
+			if err := writeCPUBurst(m.path, "cpu.max.burst", *cpu.Burst); err != nil {
+				return err
+			}
+		}
+		if cpu.Cpus != "" {
+			if err := writeCgroupFile(m.path, "cpuset.cpus", cpu.Cpus); err != nil {
+				return err
+			}
+		}
+		if cpu.Mems != "" {
+			if err := writeCgroupFile(m.path, "cpuset.mems", cpu.Mems); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// memoryValue formats a memory limit, where -1 means unlimited.
+func memoryValue(v int64) string {
+	if v < 0 {
+		return "max"
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+func (m *cgroupV2Manager) Paths() map[string]string {
+	return map[string]string{"": m.path}
+}
+
+// cgroupDelegateFiles are the files the delegatee must own besides the
+// directory itself, per the kernel's cgroup v2 delegation rules.
+var cgroupDelegateFiles = []string{"cgroup.procs", "cgroup.subtree_control", "cgroup.threads"}
+
+func (m *cgroupV2Manager) Delegate(uid, gid int) error {
+	if err := os.Chown(m.path, uid, gid); err != nil {
+		return fmt.Errorf("failed to delegate cgroup: %w", err)
+	}
+	for _, file := range cgroupDelegateFiles {
+		if err := os.Chown(filepath.Join(m.path, file), uid, gid); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delegate cgroup: %w", err)
+		}
+	}
+	return nil
+}
+
+func (m *cgroupV2Manager) Stats() (*types.Stats, error) {
+	if _, err := os.Stat(m.path); err != nil {
+		return nil, err
+	}
+	return &types.Stats{
+		CPUUsageUsec:     readCgroupKey(m.path, "cpu.stat", "usage_usec"),
+		MemoryUsageBytes: readCgroupUint(m.path, "memory.current"),
+		MemoryPeakBytes:  readCgroupUint(m.path, "memory.peak"),
+		MemoryLimitBytes: readCgroupLimit(m.path, "memory.max"),
+		OOMKills:         readCgroupKey(m.path, "memory.events", "oom_kill"),
+		PidsCurrent:      readCgroupUint(m.path, "pids.current"),
+		PidsPeak:         readCgroupUint(m.path, "pids.peak"),
+	}, nil
+}
+
+func (m *cgroupV2Manager) Destroy() error {
+	return removeCgroupDir(hostSys, m.path, m.retry)
+}
@@ -0,0 +1,270 @@
+package libcontainer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/zakarynichols/hackontainer/api/types"
+	"github.com/zakarynichols/hackontainer/libcontainer/specconv"
+	"golang.org/x/sys/unix"
+)
+
+// CgroupPolicy decides where containers get their cgroups. It matters
+// when the runtime itself runs in a container, such as a CI job, where
+// the cgroup filesystem is usually read-only or only shows the
+// job's own subtree.
+type CgroupPolicy string
+
+const (
+	// CgroupPolicyAuto uses the cgroup root when it is writable, falls
+	// back to CgroupPolicyNested when the runtime's own cgroup was
+	// delegated to it, and to CgroupPolicyNone otherwise.
+	CgroupPolicyAuto CgroupPolicy = "auto"
+	// CgroupPolicyRoot always places cgroups below the root of the
+	// cgroup filesystem. Start fails if it can't.
+	CgroupPolicyRoot CgroupPolicy = "root"
+	// CgroupPolicyNested places cgroups below the runtime's own cgroup,
+	// which must have been delegated to it.
+	CgroupPolicyNested CgroupPolicy = "nested"
+	// CgroupPolicyNone runs containers without cgroups, so the config's
+	// resource limits are not applied.
+	CgroupPolicyNone CgroupPolicy = "none"
+)
+
+// ParseCgroupPolicy parses auto, root, nested or none.
+func ParseCgroupPolicy(s string) (CgroupPolicy, error) {
+	switch policy := CgroupPolicy(s); policy {
+	case CgroupPolicyAuto, CgroupPolicyRoot, CgroupPolicyNested, CgroupPolicyNone:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid cgroup policy %q (want auto, root, nested or none)", s)
+}
+
+// WithCgroupPolicy sets where the factory's containers get their
+// cgroups. The default is CgroupPolicyAuto, or CgroupPolicyRoot when the
+// factory doesn't run rootless.
+func WithCgroupPolicy(policy CgroupPolicy) CreateOption {
+	return func(l *LinuxFactory) error {
+		if _, err := ParseCgroupPolicy(string(policy)); err != nil {
+			return err
+		}
+		l.cgroupPolicy = policy
+		return nil
+	}
+}
+
+// applyCgroupPolicy resolves policy for the container's cgroup, named
+// name below specconv.DefaultCgroupParent, and freezes the result into spec so
+// every later command finds the same cgroup. It returns a warning when
+// the container ends up without cgroups.
+func applyCgroupPolicy(policy CgroupPolicy, spec *specs.Spec, name string) (string, error) {
+	if specconv.CgroupsDisabled(spec) {
+		return noCgroupsWarning(spec, "the config disables them"), nil
+	}
+
+	switch policy {
+	case CgroupPolicyRoot:
+		return "", nil
+	case CgroupPolicyNone:
+		disableCgroups(spec)
+		return noCgroupsWarning(spec, "the cgroup policy is none"), nil
+	case CgroupPolicyNested:
+		current, err := currentCgroup()
+		if err != nil {
+			return "", err
+		}
+		if err := cgroupWritable(current); err != nil {
+			return "", fmt.Errorf("cgroup %s was not delegated to the runtime: %w", current, err)
+		}
+		nestCgroup(spec, current, name)
+		return "", nil
+	}
+
+	if cgroupWritable("/") == nil {
+		return "", nil
+	}
+	if current, err := currentCgroup(); err == nil && current != "/" && cgroupWritable(current) == nil {
+		nestCgroup(spec, current, name)
+		return "", nil
+	}
+	disableCgroups(spec)
+	return noCgroupsWarning(spec, "the cgroup filesystem is read-only and no cgroup was delegated to the runtime"), nil
+}
+
+// nestCgroup moves the container's cgroup below current. A configured
+// linux.cgroupsPath is taken as relative to it.
+func nestCgroup(spec *specs.Spec, current, name string) {
+	if spec.Linux == nil {
+		spec.Linux = &specs.Linux{}
+	}
+	path := filepath.Join(specconv.DefaultCgroupParent, name)
+	if spec.Linux.CgroupsPath != "" {
+		path = spec.Linux.CgroupsPath
+	}
+	spec.Linux.CgroupsPath = filepath.Join("/", current, path)
+}
+
+func disableCgroups(spec *specs.Spec) {
+	if spec.Annotations == nil {
+		spec.Annotations = make(map[string]string, 1)
+	}
+	spec.Annotations[specconv.CgroupsAnnotation] = "none"
+}
+
+func noCgroupsWarning(spec *specs.Spec, reason string) string {
+	warning := "running without cgroups: " + reason
+	if spec.Linux != nil && spec.Linux.Resources != nil {
+		warning += "; the config's resource limits are not applied"
+	}
+	return warning
+}
+
+// cgroupDirs returns the directory of the cgroup at path in every
+// mounted hierarchy.
+func cgroupDirs(path string) []string {
+	if isCgroup2UnifiedMode() {
+		return []string{filepath.Join(cgroupRoot, path)}
+	}
+	var dirs []string
+	for _, subsystem := range cgroupV1Subsystems {
+		mountpoint := filepath.Join(cgroupRoot, subsystem)
+		if _, err := os.Stat(mountpoint); err == nil {
+			dirs = append(dirs, filepath.Join(mountpoint, path))
+		}
+	}
+	return dirs
+}
+
+// cgroupWritable checks that cgroups can be created below path. A
+// read-only mount fails with EROFS, an undelegated cgroup with EACCES.
+func cgroupWritable(path string) error {
+	for _, dir := range cgroupDirs(path) {
+		if err := unix.Access(dir, unix.W_OK); err != nil {
+			return fmt.Errorf("%s: %w", dir, err)
+		}
+		if isCgroup2UnifiedMode() {
+			file := filepath.Join(dir, "cgroup.subtree_control")
+			if err := unix.Access(file, unix.W_OK); err != nil {
+				return fmt.Errorf("%s: %w", file, err)
+			}
+		}
+	}
+	return nil
+}
+
+// currentCgroup returns the runtime's own cgroup as a path below the
+// cgroup filesystem as mounted, which may show only a subtree. On
+// cgroup v1 every hierarchy must place the runtime in the same cgroup.
+func currentCgroup() (string, error) {
+	return processCgroup("self")
+}
+
+// processCgroup is currentCgroup for the process pid, a number or
+// "self".
+func processCgroup(pid string) (string, error) {
+	data, err := os.ReadFile(filepath.Join("/proc", pid, "cgroup"))
+	if err != nil {
+		return "", err
+	}
+	roots, err := cgroupMountRoots()
+	if err != nil {
+		return "", err
+	}
+	unified := isCgroup2UnifiedMode()
+
+	current := ""
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		// hierarchy-ID:controller-list:cgroup-path
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		var mountpoint string
+		if unified {
+			if fields[0] != "0" {
+				continue
+			}
+			mountpoint = cgroupRoot
+		} else {
+			subsystem := ""
+			for _, controller := range strings.Split(fields[1], ",") {
+				if slices.Contains(cgroupV1Subsystems, controller) {
+					subsystem = controller
+					break
+				}
+			}
+			if subsystem == "" {
+				continue
+			}
+			resolved, err := filepath.EvalSymlinks(filepath.Join(cgroupRoot, subsystem))
+			if err != nil {
+				continue
+			}
+			mountpoint = resolved
+		}
+
+		path := fields[2]
+		if root := roots[mountpoint]; root != "" && root != "/" {
+			// The mount only shows the subtree at root
+			rel, err := filepath.Rel(root, path)
+			if err != nil || strings.HasPrefix(rel, "..") {
+				return "", fmt.Errorf("cgroup %s is outside the subtree mounted at %s", path, mountpoint)
+			}
+			path = filepath.Join("/", rel)
+		}
+		if current != "" && current != path {
+			return "", fmt.Errorf("cgroup v1 hierarchies place the runtime in different cgroups: %s and %s", current, path)
+		}
+		current = path
+	}
+	if current == "" {
+		return "", fmt.Errorf("no cgroup found in /proc/%s/cgroup", pid)
+	}
+	return current, nil
+}
+
+// cgroupMountRoots maps cgroup mount points to the cgroup each mount
+// shows as its root.
+func cgroupMountRoots() (map[string]string, error) {
+	mounts, err := selfMountinfo()
+	if err != nil {
+		return nil, err
+	}
+	roots := make(map[string]string)
+	for _, m := range mounts {
+		if !strings.HasPrefix(m.fstype, "cgroup") {
+			continue
+		}
+		// Later entries are mounted on top of earlier ones
+		roots[m.mountpoint] = m.root
+	}
+	return roots, nil
+}
+
+// noCgroupManager stands in for the cgroup manager of a container
+// running without cgroups.
+type noCgroupManager struct{}
+
+func (noCgroupManager) Apply(pid int) error                       { return nil }
+func (noCgroupManager) AddProcess(pid int) error                  { return nil }
+func (noCgroupManager) Set(resources *specs.LinuxResources) error { return nil }
+func (noCgroupManager) Paths() map[string]string                  { return map[string]string{} }
+
+func (noCgroupManager) Delegate(uid, gid int) error {
+	return fmt.Errorf("cgroup delegation needs cgroups, which are disabled for this container")
+}
+
+// Stats reports no usage: none of the counters exist.
+func (noCgroupManager) Stats() (*types.Stats, error) { return &types.Stats{}, nil }
+func (noCgroupManager) Destroy() error               { return nil }
+
+func (noCgroupManager) Pids() ([]int, error) {
+	return nil, fmt.Errorf("the container runs without cgroups, so its processes can't be listed")
+}
+
+func (noCgroupManager) Freeze(frozen bool) error { return errFreezerUnavailable }
+func (noCgroupManager) Kill() error              { return errCgroupKillUnavailable }
@@ -0,0 +1,245 @@
+package libcontainer
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/zakarynichols/hackontainer/libcontainer/systemd"
+)
+
+const (
+	// defaultSystemdSlice holds the scopes of containers whose spec
+	// doesn't set linux.cgroupsPath.
+	defaultSystemdSlice = "system.slice"
+	// systemdUnitPrefix starts the name of those scopes.
+	systemdUnitPrefix = "hackontainer"
+)
+
+// unitStartTimeout bounds the wait for systemd to move the container
+// process into its new scope.
+const unitStartTimeout = 10 * time.Second
+
+// WithSystemdCgroup has systemd create the cgroups of the factory's
+// containers, as a transient scope unit each, instead of the runtime
+// writing below the cgroup root. A linux.cgroupsPath is then of the
+// form slice:prefix:name, for the scope prefix-name.scope in slice;
+// without one, the scope is hackontainer-<id>.scope in system.slice.
+// The choice is recorded with each container, so later commands don't
+// need the option.
+func WithSystemdCgroup() CreateOption {
+	return func(l *LinuxFactory) error {
+		l.systemdCgroup = true
+		return nil
+	}
+}
+
+// systemdCgroup returns the scope unit of the container named name and
+// its cgroup path, relative to the root of every hierarchy.
+func systemdCgroup(spec *specs.Spec, name string) (string, string, error) {
+	slice := defaultSystemdSlice
+	unit := systemdUnitPrefix + "-" + strings.ReplaceAll(name, "/", "-") + ".scope"
+	if spec.Linux != nil && spec.Linux.CgroupsPath != "" {
+		parts := strings.Split(spec.Linux.CgroupsPath, ":")
+		if len(parts) != 3 {
+			return "", "", fmt.Errorf("linux.cgroupsPath %q must be slice:prefix:name with the systemd cgroup driver", spec.Linux.CgroupsPath)
+		}
+		if parts[0] != "" {
+			slice = parts[0]
+		}
+		if strings.HasSuffix(parts[2], ".slice") {
+			return "", "", fmt.Errorf("linux.cgroupsPath %q names a slice; the container needs a scope", spec.Linux.CgroupsPath)
+		}
+		unit = parts[2] + ".scope"
+		if parts[1] != "" {
+			unit = parts[1] + "-" + unit
+		}
+	}
+	if !systemd.ValidUnitName(unit) {
+		return "", "", fmt.Errorf("invalid systemd unit name %q", unit)
+	}
+	slicePath, err := systemd.SlicePath(slice)
+	if err != nil {
+		return "", "", err
+	}
+	return unit, filepath.Join(slicePath, unit), nil
+}
+
+// systemdCgroupManager manages a cgroup systemd owns as the scope unit.
+// systemd creates and removes the scope and gets the limits first; the
+// cgroup files are then used as with any other cgroup.
+type systemdCgroupManager struct {
+	CgroupManager
+	unit string
+	// path is the scope's cgroup, relative to the root of every
+	// hierarchy.
+	path string
+}
+
+func (m *systemdCgroupManager) slice() string {
+	if dir := filepath.Dir(m.path); dir != "/" {
+		return filepath.Base(dir)
+	}
+	return "-.slice"
+}
+
+func (m *systemdCgroupManager) Apply(pid int) error {
+	conn, err := systemd.Dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	props := []systemd.Property{
+		{Name: "Description", Value: "hackontainer container " + strings.TrimSuffix(m.unit, ".scope")},
+		{Name: "Slice", Value: m.slice()},
+		{Name: "PIDs", Value: []uint32{uint32(pid)}},
+		// The container may make cgroups below its own
+		{Name: "Delegate", Value: true},
+		{Name: "DefaultDependencies", Value: false},
+		{Name: "MemoryAccounting", Value: true},
+		{Name: "CPUAccounting", Value: true},
+		{Name: "TasksAccounting", Value: true},
+	}
+	if _, err := conn.StartTransientUnit(m.unit, "replace", props); err != nil {
+		return fmt.Errorf("failed to start unit %s: %w", m.unit, err)
+	}
+	// The job that moves the process runs after the call returns
+	return waitForCgroup(pid, m.path, unitStartTimeout)
+}
+
+// waitForCgroup waits for pid to be in the cgroup at path, in any
+// hierarchy.
+func waitForCgroup(pid int, path string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		in, err := inCgroup(pid, path)
+		if err != nil {
+			return err
+		}
+		if in {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("process %d was not moved to cgroup %s within %s", pid, path, timeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func inCgroup(pid int, path string) (bool, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) == 3 && parts[2] == path {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// Set hands the limits systemd knows to the unit, so that it keeps them
+// when it rewrites the cgroup, and writes every limit to the cgroup.
+func (m *systemdCgroupManager) Set(r *specs.LinuxResources) error {
+	if props := unitResourceProperties(r, isCgroup2UnifiedMode()); len(props) > 0 {
+		conn, err := systemd.Dial()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if err := conn.SetUnitProperties(m.unit, true, props); err != nil {
+			return fmt.Errorf("failed to set the limits of unit %s: %w", m.unit, err)
+		}
+	}
+	return m.CgroupManager.Set(r)
+}
+
+// unitResourceProperties translates the memory, cpu and pids limits of r
+// to unit properties, those of cgroup v2 when v2 is set.
+func unitResourceProperties(r *specs.LinuxResources, v2 bool) []systemd.Property {
+	if r == nil {
+		return nil
+	}
+	var props []systemd.Property
+	if r.Memory != nil && r.Memory.Limit != nil {
+		limit := uint64(math.MaxUint64)
+		if *r.Memory.Limit >= 0 {
+			limit = uint64(*r.Memory.Limit)
+		}
+		name := "MemoryLimit"
+		if v2 {
+			name = "MemoryMax"
+		}
+		props = append(props, systemd.Property{Name: name, Value: limit})
+	}
+	if cpu := r.CPU; cpu != nil {
+		if cpu.Shares != nil && *cpu.Shares > 0 {
+			if v2 {
+				weight := 1 + ((*cpu.Shares-2)*9999)/262142
+				props = append(props, systemd.Property{Name: "CPUWeight", Value: weight})
+			} else {
+				props = append(props, systemd.Property{Name: "CPUShares", Value: *cpu.Shares})
+			}
+		}
+		period := uint64(100000)
+		if cpu.Period != nil && *cpu.Period > 0 {
+			period = *cpu.Period
+			props = append(props, systemd.Property{Name: "CPUQuotaPeriodUSec", Value: period})
+		}
+		if cpu.Quota != nil {
+			// systemd takes the quota per second, in steps of 1% of a CPU
+			perSec := uint64(math.MaxUint64)
+			if *cpu.Quota > 0 {
+				perSec = uint64(*cpu.Quota) * 1000000 / period
+				if perSec%10000 != 0 {
+					perSec = (perSec/10000 + 1) * 10000
+				}
+			}
+			props = append(props, systemd.Property{Name: "CPUQuotaPerSecUSec", Value: perSec})
+		}
+	}
+	if r.Pids != nil {
+		limit := uint64(math.MaxUint64)
+		if r.Pids.Limit != nil && *r.Pids.Limit > 0 {
+			limit = uint64(*r.Pids.Limit)
+		}
+		props = append(props, systemd.Property{Name: "TasksMax", Value: limit})
+	}
+	return props
+}
+
+// Destroy stops the unit, and removes the cgroup if systemd hasn't yet.
+// A unit systemd can't be asked to stop goes away by itself once its
+// processes are gone, so that only fails Destroy along with the cgroup.
+func (m *systemdCgroupManager) Destroy() error {
+	stopErr := m.stopUnit()
+	if err := m.CgroupManager.Destroy(); err != nil {
+		if stopErr != nil {
+			return fmt.Errorf("%w (%v)", err, stopErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func (m *systemdCgroupManager) stopUnit() error {
+	conn, err := systemd.Dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.StopUnit(m.unit, "replace"); err != nil && !systemd.IsNoSuchUnit(err) {
+		return fmt.Errorf("failed to stop unit %s: %w", m.unit, err)
+	}
+	return nil
+}
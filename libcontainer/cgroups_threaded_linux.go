@@ -0,0 +1,221 @@
+package libcontainer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/zakarynichols/hackontainer/libcontainer/specconv"
+)
+
+// cgroupThreadedAnnotation set to "true" lets a container whose cgroup
+// v2 cgroup is in a threaded subtree join it through cgroup.threads,
+// which the kernel allows only for the threaded controllers. The
+// process first joins the subtree's threaded domain through
+// cgroup.procs; then the thread that execs the container's process
+// moves to the container's cgroup, made threaded if it isn't. Threads
+// the runtime started before the exec stay in the threaded domain and
+// go away with the exec. Only cpu and cpuset limits may be set.
+const cgroupThreadedAnnotation = "org.hackontainer.cgroup-threaded"
+
+// The values of a cgroup v2 cgroup.type file.
+const (
+	cgroupTypeDomain         = "domain"
+	cgroupTypeDomainThreaded = "domain threaded"
+	cgroupTypeDomainInvalid  = "domain invalid"
+	cgroupTypeThreaded       = "threaded"
+)
+
+// CgroupJoin is the file a process is written to for it to join its
+// cgroup v2 cgroup.
+type CgroupJoin string
+
+const (
+	// CgroupJoinProcs moves the whole process, as for any domain cgroup.
+	CgroupJoinProcs CgroupJoin = "cgroup.procs"
+	// CgroupJoinThreads moves one thread, for a cgroup in a threaded
+	// subtree; see cgroupThreadedAnnotation.
+	CgroupJoinThreads CgroupJoin = "cgroup.threads"
+)
+
+func cgroupThreaded(spec *specs.Spec) bool {
+	return spec != nil && spec.Annotations[cgroupThreadedAnnotation] == "true"
+}
+
+// PlanCgroupJoin works out how the process of a container created from
+// spec joins the cgroup at path, relative to the cgroup v2 hierarchy
+// mounted at root. A cgroup that is threaded, or would be created below
+// a threaded one, can't take a process through cgroup.procs; that is an
+// error unless the spec opts into cgroupThreadedAnnotation and sets no
+// limit of a domain controller. The cgroup and its parents need not
+// exist: the nearest one that does decides.
+func PlanCgroupJoin(root, path string, spec *specs.Spec) (CgroupJoin, error) {
+	return planCgroupJoin(root, path, cgroupThreaded(spec), domainControllers(specconv.Resources(spec)))
+}
+
+// planCgroupJoin is PlanCgroupJoin for a container that may join
+// through cgroup.threads if threaded, and sets limits of the domain
+// controllers named in domain.
+func planCgroupJoin(root, path string, threaded bool, domain []string) (CgroupJoin, error) {
+	path = filepath.Join("/", path)
+	dir := path
+	for {
+		if _, err := os.Stat(filepath.Join(root, dir)); err == nil {
+			break
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		if dir == "/" {
+			return "", fmt.Errorf("cgroup hierarchy %s not found", root)
+		}
+		dir = filepath.Dir(dir)
+	}
+	cgType, err := readCgroupType(filepath.Join(root, dir))
+	if err != nil {
+		return "", err
+	}
+
+	// A new cgroup below a domain one is a domain itself, and the kernel
+	// makes one below a threaded one invalid until it is made threaded
+	switch {
+	case cgType == cgroupTypeThreaded:
+	case cgType == cgroupTypeDomainInvalid && dir == path:
+	case cgType == cgroupTypeDomainInvalid:
+		return "", fmt.Errorf("cgroup %s is %q: no cgroup below it can hold processes until it is made threaded or its threaded siblings are removed", dir, cgType)
+	default:
+		return CgroupJoinProcs, nil
+	}
+
+	subject := fmt.Sprintf("cgroup %s is in a threaded subtree", path)
+	if dir != path {
+		subject = fmt.Sprintf("cgroup %s would be created in a threaded subtree", path)
+	}
+	if len(domain) > 0 {
+		return "", fmt.Errorf("%s (%s is %q), where only threaded controllers work, but the config sets %s limits, which need a domain cgroup; use a cgroupsPath outside the subtree", subject, dir, cgType, strings.Join(domain, ", "))
+	}
+	if !threaded {
+		return "", fmt.Errorf("%s (%s is %q): processes can't join it through cgroup.procs; use a cgroupsPath outside the subtree, or set %s=true to join it through cgroup.threads with cpu and cpuset limits only", subject, dir, cgType, cgroupThreadedAnnotation)
+	}
+	if _, err := threadedDomain(root, dir); err != nil {
+		return "", err
+	}
+	return CgroupJoinThreads, nil
+}
+
+// readCgroupType reads the cgroup.type of dir. The root cgroup has none
+// and is a domain.
+func readCgroupType(dir string) (string, error) {
+	cgType, err := readCgroupFile(dir, "cgroup.type")
+	if errors.Is(err, os.ErrNotExist) {
+		return cgroupTypeDomain, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read cgroup type: %w", err)
+	}
+	return cgType, nil
+}
+
+// threadedDomain returns the threaded domain of the threaded subtree
+// holding the existing cgroup dir, relative to root: the nearest
+// ancestor that is "domain threaded".
+func threadedDomain(root, dir string) (string, error) {
+	for d := dir; ; d = filepath.Dir(d) {
+		cgType, err := readCgroupType(filepath.Join(root, d))
+		if err != nil {
+			return "", err
+		}
+		if cgType == cgroupTypeDomainThreaded {
+			return d, nil
+		}
+		if d == "/" {
+			return "", fmt.Errorf("cgroup %s is threaded but no ancestor is its threaded domain", dir)
+		}
+	}
+}
+
+// domainControllers names the controllers other than cpu and cpuset
+// whose limits resources sets.
+func domainControllers(r *specs.LinuxResources) []string {
+	if r == nil {
+		return nil
+	}
+	var domain []string
+	if r.Memory != nil {
+		domain = append(domain, "memory")
+	}
+	if r.Pids != nil {
+		domain = append(domain, "pids")
+	}
+	if r.BlockIO != nil {
+		domain = append(domain, "io")
+	}
+	if len(r.HugepageLimits) > 0 {
+		domain = append(domain, "hugetlb")
+	}
+	if len(r.Rdma) > 0 {
+		domain = append(domain, "rdma")
+	}
+	for key := range r.Unified {
+		controller, _, _ := strings.Cut(key, ".")
+		if controller != "cpu" && controller != "cpuset" && !slices.Contains(domain, controller) {
+			domain = append(domain, controller)
+		}
+	}
+	return domain
+}
+
+// validateCgroupJoin checks at create that the container's process will
+// be able to join its cgroup, which on cgroup v2 a threaded subtree can
+// prevent.
+func validateCgroupJoin(spec *specs.Spec, name string) error {
+	path := specconv.CgroupPath(spec, name)
+	if path == "" || !isCgroup2UnifiedMode() {
+		if cgroupThreaded(spec) && path != "" {
+			return fmt.Errorf("%s: threaded cgroups require cgroup v2", cgroupThreadedAnnotation)
+		}
+		return nil
+	}
+	_, err := PlanCgroupJoin(cgroupRoot, path, spec)
+	return err
+}
+
+// joinCgroup moves pid into the cgroup at m.path as planCgroupJoin says
+// it can: through cgroup.procs, or for a threaded one through the
+// threaded domain's cgroup.procs and then the cgroup's cgroup.threads.
+func (m *cgroupV2Manager) joinCgroup(pid int) error {
+	rel, err := filepath.Rel(cgroupRoot, m.path)
+	if err != nil {
+		return err
+	}
+	rel = filepath.Join("/", rel)
+	join, err := planCgroupJoin(cgroupRoot, rel, m.threaded, nil)
+	if err != nil {
+		return err
+	}
+	if join == CgroupJoinProcs {
+		return writeCgroupFile(m.path, string(CgroupJoinProcs), strconv.Itoa(pid))
+	}
+
+	cgType, err := readCgroupType(m.path)
+	if err != nil {
+		return err
+	}
+	if cgType == cgroupTypeDomainInvalid {
+		if err := writeCgroupFile(m.path, "cgroup.type", cgroupTypeThreaded); err != nil {
+			return fmt.Errorf("failed to make cgroup threaded: %w", err)
+		}
+	}
+	domain, err := threadedDomain(cgroupRoot, rel)
+	if err != nil {
+		return err
+	}
+	if err := writeCgroupFile(filepath.Join(cgroupRoot, domain), string(CgroupJoinProcs), strconv.Itoa(pid)); err != nil {
+		return fmt.Errorf("failed to join threaded domain %s: %w", domain, err)
+	}
+	return writeCgroupFile(m.path, string(CgroupJoinThreads), strconv.Itoa(pid))
+}
@@ -0,0 +1,260 @@
+package libcontainer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/zakarynichols/hackontainer/api/types"
+	"golang.org/x/sys/unix"
+)
+
+// Checkpoint records a CRIU dump of a container.
+type Checkpoint = types.Checkpoint
+
+// EventCheckpoint is recorded when Checkpoint dumps a container. Its data
+// has the image path.
+const EventCheckpoint = "checkpoint"
+
+// checkpointDirname is where the images go by default, in the container
+// root.
+const checkpointDirname = "checkpoint"
+
+// criuDumpLog is the log criu dump leaves in the work path.
+const criuDumpLog = "dump.log"
+
+// criuConsoleFilename is where Checkpoint records, next to the images,
+// the terminal of a container whose process has one.
+const criuConsoleFilename = "console.json"
+
+// criuConsole is the terminal of a checkpointed process. criu dumps the
+// pty as an external, which restore has to hand it in place of the one
+// it was dumped with.
+type criuConsole struct {
+	// External is the pty slave as criu names it, tty[rdev:dev].
+	External string `json:"external"`
+}
+
+// CheckpointOptions control Checkpoint.
+type CheckpointOptions struct {
+	// ImagePath is where the images are written, <container root>/checkpoint
+	// by default.
+	ImagePath string
+	// WorkPath holds criu's log and scratch files, ImagePath by default.
+	WorkPath string
+	// LeaveRunning keeps the container running after the dump.
+	LeaveRunning bool
+	// TCPEstablished dumps established TCP connections instead of
+	// refusing to.
+	TCPEstablished bool
+	// CriuPath is the criu binary, found in PATH by default.
+	CriuPath string
+}
+
+// Checkpoint dumps a running or paused container with criu. Unless
+// opts.LeaveRunning is set, criu kills the container once dumped, which
+// leaves it stopped without its restart policy bringing it back. The
+// checkpoint is recorded in the state for restore to find, and the
+// process's terminal, if it has one, next to the images.
+func (c *linuxContainer) Checkpoint(opts CheckpointOptions) (retErr error) {
+	defer func() { c.audit(AuditCheckpoint, map[string]string{"imagePath": opts.ImagePath}, retErr) }()
+
+	criu, err := criuBinary(opts.CriuPath)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := c.lock()
+	if os.IsNotExist(err) {
+		return newTypedError(ErrNotExist, "container %q does not exist", c.id)
+	}
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := c.State()
+	if err != nil {
+		return fmt.Errorf("failed to get container state: %w", err)
+	}
+	if state.Status != Running && state.Status != Paused {
+		return newTypedError(ErrNotRunning, "cannot checkpoint a container that is %s", state.Status)
+	}
+	if err := c.checkNamespaces("checkpoint"); err != nil {
+		return err
+	}
+
+	imagePath := opts.ImagePath
+	if imagePath == "" {
+		imagePath = filepath.Join(c.root, checkpointDirname)
+	}
+	if imagePath, err = filepath.Abs(imagePath); err != nil {
+		return err
+	}
+	workPath := opts.WorkPath
+	if workPath == "" {
+		workPath = imagePath
+	}
+	if workPath, err = filepath.Abs(workPath); err != nil {
+		return err
+	}
+	for _, dir := range []string{imagePath, workPath} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+	}
+
+	// A pty is outside the container, so criu only dumps which one it was
+	console, err := c.checkpointConsole(state.Pid)
+	if err != nil {
+		return err
+	}
+	if err := saveCriuConsole(imagePath, console); err != nil {
+		return err
+	}
+
+	// The dump kills the container, which is no exit for the restart
+	// policy to undo
+	if !opts.LeaveRunning && state.RestartPolicy != nil && !state.RestartSuppressed {
+		state.RestartSuppressed = true
+		if err := c.saveState(state); err != nil {
+			return fmt.Errorf("failed to save container state: %w", err)
+		}
+	}
+
+	args := c.criuDumpArgs(state, imagePath, workPath, console, opts)
+	if out, err := exec.Command(criu, args...).CombinedOutput(); err != nil {
+		msg := fmt.Sprintf("criu dump failed: %v", err)
+		if text := strings.TrimSpace(string(out)); text != "" {
+			msg += ": " + text
+		}
+		return fmt.Errorf("%s; see %s", msg, filepath.Join(workPath, criuDumpLog))
+	}
+
+	state.Checkpoint = &Checkpoint{ImagePath: imagePath, Created: time.Now().UTC(), LeaveRunning: opts.LeaveRunning}
+	if !opts.LeaveRunning {
+		state.Status = Stopped
+	}
+	if err := c.saveState(state); err != nil {
+		return fmt.Errorf("failed to record the checkpoint: %w", err)
+	}
+	c.emit(EventCheckpoint, map[string]string{"imagePath": imagePath})
+	return nil
+}
+
+// criuDumpArgs returns the arguments of criu dump for the container,
+// whose terminal is console if it has one.
+func (c *linuxContainer) criuDumpArgs(state *State, imagePath, workPath string, console *criuConsole, opts CheckpointOptions) []string {
+	args := []string{
+		"dump",
+		"--tree", fmt.Sprint(state.Pid),
+		"--images-dir", imagePath,
+		"--work-dir", workPath,
+		"--log-file", criuDumpLog,
+		"-v4",
+		"--root", c.config.Rootfs,
+		"--manage-cgroups",
+	}
+	// criu freezes the tree through the cgroup when it can, which a
+	// paused container already is
+	if state.CgroupPath != "" {
+		paths := c.cgroupManager().Paths()
+		if dir, ok := paths["freezer"]; ok {
+			args = append(args, "--freeze-cgroup", dir)
+		} else if dir, ok := paths[""]; ok {
+			args = append(args, "--freeze-cgroup", dir)
+		}
+	}
+	// Bind mounts come from outside the container, so criu is told
+	// where they go instead of dumping them
+	for _, mnt := range c.config.Resolved.Mounts {
+		if mnt.Bind {
+			args = append(args, "--ext-mount-map", mnt.Destination+":"+mnt.Destination)
+		}
+	}
+	if console != nil {
+		args = append(args, "--external", console.External)
+	}
+	if opts.LeaveRunning {
+		args = append(args, "--leave-running")
+	}
+	if opts.TCPEstablished {
+		args = append(args, "--tcp-established")
+	}
+	return args
+}
+
+// criuBinary resolves the criu binary to run: path, or criu in PATH.
+func criuBinary(path string) (string, error) {
+	where := path
+	if path == "" {
+		path, where = "criu", "in PATH"
+	}
+	resolved, err := exec.LookPath(path)
+	if err != nil {
+		return "", newTypedError(ErrCriuNotFound, "criu not found %s: checkpoint and restore need CRIU installed, or its path given with --criu", where)
+	}
+	return resolved, nil
+}
+
+// checkpointConsole returns the terminal of the container's process
+// pid, or nil if it wasn't given one.
+func (c *linuxContainer) checkpointConsole(pid int) (*criuConsole, error) {
+	if c.config.Process == nil || !c.config.Process.Terminal {
+		return nil, nil
+	}
+	for fd := 0; fd <= 2; fd++ {
+		var st unix.Stat_t
+		if err := unix.Stat(fmt.Sprintf("/proc/%d/fd/%d", pid, fd), &st); err != nil {
+			continue
+		}
+		// Unix98 pty slaves are majors 136 to 143
+		if st.Mode&unix.S_IFMT != unix.S_IFCHR || unix.Major(st.Rdev) < 136 || unix.Major(st.Rdev) > 143 {
+			continue
+		}
+		// criu restores every fd that was the pty from the one inherited
+		return &criuConsole{External: fmt.Sprintf("tty[%x:%x]", st.Rdev, st.Dev)}, nil
+	}
+	return nil, fmt.Errorf("process.terminal is set but none of the stdio of process %d is a pty", pid)
+}
+
+// saveCriuConsole records console in the image path, or that there is
+// none, replacing what an earlier checkpoint there recorded.
+func saveCriuConsole(imagePath string, console *criuConsole) error {
+	path := filepath.Join(imagePath, criuConsoleFilename)
+	if console == nil {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(console)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to record the console: %w", err)
+	}
+	return nil
+}
+
+// loadCriuConsole returns the terminal recorded with the checkpoint in
+// imagePath, or nil if its process had none.
+func loadCriuConsole(imagePath string) (*criuConsole, error) {
+	data, err := os.ReadFile(filepath.Join(imagePath, criuConsoleFilename))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var console criuConsole
+	if err := json.Unmarshal(data, &console); err != nil || console.External == "" {
+		return nil, fmt.Errorf("invalid %s in %s", criuConsoleFilename, imagePath)
+	}
+	return &console, nil
+}
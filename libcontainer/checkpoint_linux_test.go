@@ -0,0 +1,94 @@
+package libcontainer
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+func TestCheckpointConsole(t *testing.T) {
+	master, slave, err := openPty()
+	if err != nil {
+		t.Skipf("no pty: %v", err)
+	}
+	defer master.Close()
+	defer slave.Close()
+
+	// The process has the pty as stdio, as a started one does
+	cmd := exec.Command("sleep", "10")
+	attachTerminal(cmd, slave)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	c := testContainer(t)
+	c.config.Process = &specs.Process{Terminal: true}
+	console, err := c.checkpointConsole(cmd.Process.Pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st unix.Stat_t
+	if err := unix.Fstat(int(slave.Fd()), &st); err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("tty[%x:%x]", st.Rdev, st.Dev); console.External != want {
+		t.Errorf("got external %q, want %q", console.External, want)
+	}
+
+	// What the dump is told, restore inherits a new pty in place of
+	dump := c.criuDumpArgs(&State{}, "/images", "/work", console, CheckpointOptions{})
+	if i := slices.Index(dump, "--external"); i < 0 || dump[i+1] != console.External {
+		t.Errorf("dump not told the pty is external: %q", dump)
+	}
+	restore := c.criuRestoreArgs(&restoreRequest{Console: console.External}, "/pid")
+	if i := slices.Index(restore, "--inherit-fd"); i < 0 || restore[i+1] != "fd[3]:"+console.External {
+		t.Errorf("restore not given the new pty: %q", restore)
+	}
+
+	// A process that was given no terminal has none to record
+	c.config.Process.Terminal = false
+	if console, err := c.checkpointConsole(cmd.Process.Pid); console != nil || err != nil {
+		t.Errorf("got %+v, %v without a terminal", console, err)
+	}
+	// And one whose stdio isn't a pty can't have its terminal recorded
+	plain := exec.Command("sleep", "10")
+	if err := plain.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Wait()
+	defer plain.Process.Kill()
+	c.config.Process.Terminal = true
+	if console, err := c.checkpointConsole(plain.Process.Pid); err == nil {
+		t.Errorf("recorded %+v for a process without a pty", console)
+	}
+}
+
+func TestRestoreTerminalNeedsConsoleSocket(t *testing.T) {
+	imagePath := t.TempDir()
+	if err := saveCriuConsole(imagePath, &criuConsole{External: "tty[8800:17]"}); err != nil {
+		t.Fatal(err)
+	}
+	c := testContainer(t)
+	c.root = filepath.Join(t.TempDir(), "test")
+	err := c.Restore(RestoreOptions{ImagePath: imagePath, CriuPath: "/bin/true"})
+	if !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "no console socket") {
+		t.Fatalf("got %v, want a refusal for the missing console socket", err)
+	}
+
+	// A checkpoint without a terminal forgets the one recorded before
+	if err := saveCriuConsole(imagePath, nil); err != nil {
+		t.Fatal(err)
+	}
+	if console, err := loadCriuConsole(imagePath); console != nil || err != nil {
+		t.Fatalf("got %+v, %v after a checkpoint without a terminal", console, err)
+	}
+}
@@ -0,0 +1,137 @@
+package libcontainer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/zakarynichols/hackontainer/config"
+)
+
+// clonedFromAnnotation records the container a clone's config was
+// copied from.
+const clonedFromAnnotation = "org.hackontainer.cloned-from"
+
+// WithCloneSource creates the container from the config frozen for the
+// container source instead of a bundle's config.json. The other options
+// apply on top of it as they do on top of a bundle's config. Without a
+// bundle, the clone uses the source's; with another one, a rootfs inside
+// the source's bundle is found at the same place in the new one.
+//
+// What the runtime filled in for the source is left out: the resolved
+// form, a cgroup path named after the source, and the redacted values of
+// its sensitive variables, whose values and sensitivity the clone gets
+// instead. The config is read from the source's root afresh, so the
+// clone shares nothing with the source it could change.
+func WithCloneSource(source string) CreateOption {
+	return func(l *LinuxFactory) error {
+		if err := validateID(source); err != nil {
+			return err
+		}
+		l.cloneSource = source
+		return nil
+	}
+}
+
+// cloneSource is the container a clone's config comes from.
+type cloneSource struct {
+	id     string
+	root   string
+	bundle string
+	// cgroupName is the name the runtime gave the source's cgroup.
+	cgroupName   string
+	sensitiveEnv []string
+}
+
+// configPath is the source's frozen config.
+func (s *cloneSource) configPath() string {
+	return filepath.Join(s.root, configFilename)
+}
+
+// loadCloneSource loads the container l clones, which must have been
+// created to the end: its config isn't final before.
+func (l *LinuxFactory) loadCloneSource() (*cloneSource, error) {
+	if l.configPath != "" {
+		return nil, newTypedError(ErrInvalidConfig, "a clone of %q takes its config from it, not from %s", l.cloneSource, l.configPath)
+	}
+	root := filepath.Join(l.stateRoot(), l.cloneSource)
+	source, err := loadContainer(root)
+	// The root is made first and the state written last
+	if errors.Is(err, ErrNotExist) && fileExists(filepath.Join(root, configFilename)) {
+		return nil, newTypedError(ErrInvalidState, "cannot clone container %q while it is being created", l.cloneSource)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if source.markerExists(deletingFilename) {
+		return nil, newTypedError(ErrInvalidState, "cannot clone container %q while it is being deleted", l.cloneSource)
+	}
+	state, err := source.State()
+	if err != nil {
+		return nil, err
+	}
+	// A full create records the process once it waits for start
+	if state.Status == Created && createMode(state) == CreateModeFull && state.Pid == 0 {
+		return nil, newTypedError(ErrInvalidState, "cannot clone container %q while it is being created", l.cloneSource)
+	}
+
+	sensitiveEnv, err := source.loadSensitiveEnv()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the sensitive variables of %s: %w", l.cloneSource, err)
+	}
+	return &cloneSource{
+		id:           l.cloneSource,
+		root:         root,
+		bundle:       state.Bundle,
+		cgroupName:   filepath.Join(state.Namespace, l.cloneSource),
+		sensitiveEnv: sensitiveEnv,
+	}, nil
+}
+
+// apply turns cfg, the source's frozen config loaded against bundle,
+// back into a config as a bundle would give it, for l to create the
+// clone from.
+func (s *cloneSource) apply(l *LinuxFactory, cfg *config.Config, bundle string) error {
+	cfg.Resolved = nil
+
+	if cfg.Root != nil {
+		// A rootfs pinned from an fd lives in the source's root
+		if cfg.Root.Path == s.root || strings.HasPrefix(cfg.Root.Path, s.root+"/") {
+			if l.rootfsFD < 0 {
+				return newTypedError(ErrInvalidConfig, "the rootfs of container %q was pinned from an fd; the clone needs a rootfs fd of its own", s.id)
+			}
+		} else if bundle != s.bundle {
+			if rel, err := filepath.Rel(s.bundle, cfg.Root.Path); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+				cfg.Root.Path = filepath.Join(bundle, rel)
+			}
+		}
+	}
+
+	if cfg.Linux != nil && (cfg.Linux.CgroupsPath == s.cgroupName || strings.HasSuffix(cfg.Linux.CgroupsPath, "/"+s.cgroupName)) {
+		cfg.Linux.CgroupsPath = ""
+	}
+
+	if len(s.sensitiveEnv) > 0 && cfg.Process != nil {
+		cfg.Process.Env = mergeEnv(cfg.Process.Env, s.sensitiveEnv)
+		// Copied, since Create's copy of the factory shares the array
+		sensitive := append([]string(nil), l.sensitiveEnv...)
+		for _, kv := range s.sensitiveEnv {
+			if name, _, _ := strings.Cut(kv, "="); !slices.Contains(sensitive, name) {
+				sensitive = append(sensitive, name)
+			}
+		}
+		l.sensitiveEnv = sensitive
+	}
+
+	if weakened := cfg.Annotations[securityOverridesAnnotation]; weakened != "" {
+		fmt.Fprintf(os.Stderr, "WARNING: the config of %s was weakened (%s) and the clone keeps that\n", s.id, weakened)
+	}
+	if cfg.Annotations == nil {
+		cfg.Annotations = make(map[string]string, 1)
+	}
+	cfg.Annotations[clonedFromAnnotation] = s.id
+	return nil
+}
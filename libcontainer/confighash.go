@@ -0,0 +1,62 @@
+package libcontainer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// fileSHA256 returns the hex SHA-256 of the file at path.
+func fileSHA256(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// recordFrozenConfig records in state the hash of the frozen config as
+// just written, by create or update.
+func (c *linuxContainer) recordFrozenConfig(state *State) error {
+	sum, err := fileSHA256(filepath.Join(c.root, configFilename))
+	if err != nil {
+		return fmt.Errorf("failed to hash the frozen config: %w", err)
+	}
+	state.FrozenConfigSHA256 = sum
+	return nil
+}
+
+// verifyFrozenConfig fails if the frozen config isn't the one create or
+// update last wrote: the container would run with whatever replaced it,
+// which nobody asked for. States from before the hash was recorded
+// aren't checked.
+func (c *linuxContainer) verifyFrozenConfig(state *State) error {
+	if state.FrozenConfigSHA256 == "" {
+		return nil
+	}
+	sum, err := fileSHA256(filepath.Join(c.root, configFilename))
+	if err != nil {
+		return fmt.Errorf("failed to hash the frozen config: %w", err)
+	}
+	if sum != state.FrozenConfigSHA256 {
+		return newTypedError(ErrConfigModified, "the frozen config of container %s was modified after it was written (sha256 %s, recorded %s); delete the container and create it again", c.id, sum, state.FrozenConfigSHA256)
+	}
+	return nil
+}
+
+// warnBundleConfigChanged warns when the config the container was
+// created from has changed since. The change has no effect, which
+// surprises whoever edits a bundle between create and start.
+func (c *linuxContainer) warnBundleConfigChanged(state *State) {
+	if state.ConfigSHA256 == "" || state.ConfigPath == "" {
+		return
+	}
+	sum, err := fileSHA256(state.ConfigPath)
+	if err != nil || sum == state.ConfigSHA256 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "WARNING: %s has changed since container %s was created from it; the changes have no effect, the config as created applies\n", state.ConfigPath, c.id)
+}
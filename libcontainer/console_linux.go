@@ -0,0 +1,340 @@
+package libcontainer
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// isTerminal reports whether f refers to a terminal.
+func isTerminal(f *os.File) bool {
+	_, err := unix.IoctlGetTermios(int(f.Fd()), unix.TCGETS)
+	return err == nil
+}
+
+// openPty allocates a new pseudo-terminal pair. The master is left
+// non-blocking, so closing it interrupts a read pending on it.
+func openPty() (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open /dev/ptmx: %w", err)
+	}
+
+	if err := controlFd(master, func(fd int) error { return unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0) }); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("failed to unlock pty: %w", err)
+	}
+
+	var n uint32
+	err = controlFd(master, func(fd int) (err error) {
+		n, err = unix.IoctlGetUint32(fd, unix.TIOCGPTN)
+		return err
+	})
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("failed to get pty number: %w", err)
+	}
+
+	slave, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("failed to open pty slave: %w", err)
+	}
+
+	return master, slave, nil
+}
+
+// controlFd runs fn on the descriptor of f. Unlike f.Fd, it leaves f
+// non-blocking.
+func controlFd(f *os.File, fn func(fd int) error) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var fnErr error
+	if err := rc.Control(func(fd uintptr) { fnErr = fn(int(fd)) }); err != nil {
+		return err
+	}
+	return fnErr
+}
+
+// consoleDrainTimeout bounds how long Close waits for the last output
+// of the pty once the runtime's slave is closed. Processes the container
+// left in the background keep the slave open, and with it the output.
+const consoleDrainTimeout = 500 * time.Millisecond
+
+// localConsole is a pty allocated by the runtime itself for a foreground
+// container when no console socket was given. The host terminal is put
+// into raw mode and bytes are proxied both ways until Close.
+type localConsole struct {
+	master *os.File
+	slave  *os.File
+
+	host     *os.File
+	out      io.Writer
+	oldState *unix.Termios
+
+	winch chan os.Signal
+	// stop wakes the input copy when its write end is closed
+	stopRead, stopWrite *os.File
+	// proxies are the resize and input goroutines, and output is
+	// closed once the output copy is done
+	proxies sync.WaitGroup
+	output  chan struct{}
+}
+
+func newLocalConsole(size *specs.Box) (*localConsole, error) {
+	if !isTerminal(os.Stdin) {
+		return nil, fmt.Errorf("process.terminal is set but stdin is not a terminal and no --console-socket was given")
+	}
+	return openLocalConsole(os.Stdin, os.Stdout, size)
+}
+
+// openLocalConsole proxies a new pty to the terminal host, with what the
+// container writes going to out.
+func openLocalConsole(host *os.File, out io.Writer, size *specs.Box) (*localConsole, error) {
+	master, slave, err := openPty()
+	if err != nil {
+		return nil, err
+	}
+
+	console := &localConsole{master: master, slave: slave, host: host, out: out}
+
+	if size != nil {
+		setConsoleSize(master, size)
+	} else {
+		console.resize()
+	}
+
+	oldState, err := makeRaw(int(console.host.Fd()))
+	if err != nil {
+		console.Close()
+		return nil, fmt.Errorf("failed to put terminal into raw mode: %w", err)
+	}
+	console.oldState = oldState
+
+	console.stopRead, console.stopWrite, err = os.Pipe()
+	if err != nil {
+		console.Close()
+		return nil, err
+	}
+
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, unix.SIGWINCH)
+	console.winch = winch
+	console.proxies.Add(2)
+	go func() {
+		defer console.proxies.Done()
+		for range winch {
+			console.resize()
+		}
+	}()
+	go func() {
+		defer console.proxies.Done()
+		console.copyInput()
+	}()
+
+	console.output = make(chan struct{})
+	go func() {
+		defer close(console.output)
+		io.Copy(console.out, console.master)
+	}()
+
+	return console, nil
+}
+
+// copyInput copies what is typed at the host terminal to the pty until
+// stop is woken. It polls rather than blocking in a read, as a read of
+// the host terminal can't be interrupted without making it non-blocking
+// for every process that shares it.
+func (c *localConsole) copyInput() {
+	fds := []unix.PollFd{
+		{Fd: int32(c.host.Fd()), Events: unix.POLLIN},
+		{Fd: int32(c.stopRead.Fd()), Events: unix.POLLIN},
+	}
+	buf := make([]byte, 32*1024)
+	for {
+		if _, err := unix.Poll(fds, -1); err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			return
+		}
+		if fds[1].Revents != 0 || fds[0].Revents&unix.POLLIN == 0 {
+			return
+		}
+		n, err := unix.Read(int(fds[0].Fd), buf)
+		if err == unix.EINTR || err == unix.EAGAIN {
+			continue
+		}
+		if n <= 0 {
+			return
+		}
+		if _, err := c.master.Write(buf[:n]); err != nil {
+			return
+		}
+	}
+}
+
+// attach makes the pty slave the controlling terminal and stdio of cmd.
+func (c *localConsole) attach(cmd *exec.Cmd) {
+	attachTerminal(cmd, c.slave)
+}
+
+// attachTerminal makes the pty slave the stdio of cmd, and its
+// controlling terminal in a session of its own.
+func attachTerminal(cmd *exec.Cmd, slave *os.File) {
+	cmd.Stdin = slave
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Ctty = 0
+}
+
+// WithConsoleSocket has containers whose process asks for a terminal
+// send it to the AF_UNIX socket at path, an absolute path, instead of
+// using the caller's: the master of a new pty is sent on each start,
+// and the slave is the process's stdio and controlling terminal. It is
+// how a detached container gets a terminal at all.
+func WithConsoleSocket(path string) CreateOption {
+	return func(l *LinuxFactory) error {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("console socket %q is not an absolute path", path)
+		}
+		l.consoleSocket = path
+		return nil
+	}
+}
+
+// sendConsole allocates a pty of size, when given, and sends its master
+// to the console socket at path as runc does: as SCM_RIGHTS, with the
+// master's name as the message. It returns the slave.
+func sendConsole(path string, size *specs.Box) (*os.File, error) {
+	master, slave, err := openPty()
+	if err != nil {
+		return nil, err
+	}
+	defer master.Close()
+	if size != nil {
+		setConsoleSize(master, size)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		slave.Close()
+		return nil, fmt.Errorf("failed to connect to console socket: %w", err)
+	}
+	defer conn.Close()
+	rights := unix.UnixRights(int(master.Fd()))
+	if _, _, err := conn.(*net.UnixConn).WriteMsgUnix([]byte(master.Name()), rights, nil); err != nil {
+		slave.Close()
+		return nil, fmt.Errorf("failed to send terminal to console socket: %w", err)
+	}
+	return slave, nil
+}
+
+// setConsoleSize sets the size of the pty f is either end of.
+func setConsoleSize(f *os.File, size *specs.Box) {
+	controlFd(f, func(fd int) error {
+		return unix.IoctlSetWinsize(fd, unix.TIOCSWINSZ, &unix.Winsize{
+			Row: uint16(size.Height),
+			Col: uint16(size.Width),
+		})
+	})
+}
+
+// resize copies the host terminal size onto the pty.
+func (c *localConsole) resize() {
+	ws, err := unix.IoctlGetWinsize(int(c.host.Fd()), unix.TIOCGWINSZ)
+	if err != nil {
+		return
+	}
+	controlFd(c.master, func(fd int) error {
+		return unix.IoctlSetWinsize(fd, unix.TIOCSWINSZ, ws)
+	})
+}
+
+// Close restores the host terminal and releases the pty. The proxies
+// are stopped first, and what the container wrote before its process
+// exited is copied out before the master goes. It is safe to call more
+// than once.
+func (c *localConsole) Close() error {
+	if c.winch != nil {
+		signal.Stop(c.winch)
+		close(c.winch)
+		c.winch = nil
+	}
+	if c.stopWrite != nil {
+		c.stopWrite.Close()
+		c.stopWrite = nil
+	}
+	c.proxies.Wait()
+	if c.stopRead != nil {
+		c.stopRead.Close()
+		c.stopRead = nil
+	}
+
+	// With the runtime's slave closed, the output ends when the last
+	// process holding the pty is gone
+	if c.slave != nil {
+		c.slave.Close()
+		c.slave = nil
+	}
+	if c.output != nil {
+		select {
+		case <-c.output:
+		case <-time.After(consoleDrainTimeout):
+		}
+	}
+	if c.master != nil {
+		c.master.Close()
+		c.master = nil
+	}
+	if c.output != nil {
+		<-c.output
+		c.output = nil
+	}
+
+	if c.oldState != nil {
+		unix.IoctlSetTermios(int(c.host.Fd()), unix.TCSETS, c.oldState)
+		c.oldState = nil
+	}
+	return nil
+}
+
+// makeRaw puts the terminal into raw mode like cfmakeraw(3) and returns
+// the previous state.
+func makeRaw(fd int) (*unix.Termios, error) {
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, err
+	}
+	oldState := *termios
+
+	termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	termios.Oflag &^= unix.OPOST
+	termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	termios.Cflag &^= unix.CSIZE | unix.PARENB
+	termios.Cflag |= unix.CS8
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, termios); err != nil {
+		return nil, err
+	}
+	return &oldState, nil
+}
@@ -0,0 +1,196 @@
+package libcontainer
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// openFds counts the descriptors the test process has open.
+func openFds(t *testing.T) int {
+	t.Helper()
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(entries)
+}
+
+// settled waits for the goroutines still winding down to be gone, and
+// reports how many are left.
+func settled(want int) int {
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > want && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return runtime.NumGoroutine()
+}
+
+func TestLocalConsoleClose(t *testing.T) {
+	// The host terminal is the slave of a pty the test types into
+	typing, host, err := openPty()
+	if err != nil {
+		t.Skipf("no pty: %v", err)
+	}
+	defer typing.Close()
+	defer host.Close()
+	before, err := unix.IoctlGetTermios(int(host.Fd()), unix.TCGETS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The signal package's own goroutine starts with its first use
+	warm := make(chan os.Signal, 1)
+	signal.Notify(warm, unix.SIGWINCH)
+	signal.Stop(warm)
+
+	tests := []struct {
+		name string
+		// run runs a process on the console, and returns what it must
+		// have written
+		run func(t *testing.T, c *localConsole) string
+	}{
+		{
+			name: "a process that reads what is typed",
+			run: func(t *testing.T, c *localConsole) string {
+				cmd := exec.Command("sh", "-c", "read line; echo got $line; printf last")
+				c.attach(cmd)
+				if err := cmd.Start(); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := typing.Write([]byte("typed\r")); err != nil {
+					t.Fatal(err)
+				}
+				if err := cmd.Wait(); err != nil {
+					t.Fatal(err)
+				}
+				return "got typed\r\nlast"
+			},
+		},
+		{
+			name: "a process that fails",
+			run: func(t *testing.T, c *localConsole) string {
+				cmd := exec.Command("sh", "-c", "echo failing; exit 3")
+				c.attach(cmd)
+				if err := cmd.Run(); err == nil {
+					t.Fatal("exit 3 succeeded")
+				}
+				return "failing"
+			},
+		},
+		{
+			name: "a process that never starts",
+			run: func(t *testing.T, c *localConsole) string {
+				cmd := exec.Command("/nonexistent")
+				c.attach(cmd)
+				if err := cmd.Start(); err == nil {
+					cmd.Wait()
+					t.Fatal("started a missing binary")
+				}
+				return ""
+			},
+		},
+		{
+			name: "a process that leaves one in the background",
+			run: func(t *testing.T, c *localConsole) string {
+				cmd := exec.Command("sh", "-c", "sleep 2 & echo left")
+				c.attach(cmd)
+				if err := cmd.Run(); err != nil {
+					t.Fatal(err)
+				}
+				return "left"
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			goroutines, fds := runtime.NumGoroutine(), openFds(t)
+			var out bytes.Buffer
+			c, err := openLocalConsole(host, &out, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			raw, err := unix.IoctlGetTermios(int(host.Fd()), unix.TCGETS)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if raw.Lflag&unix.ICANON != 0 {
+				t.Error("host terminal not put into raw mode")
+			}
+
+			want := tt.run(t, c)
+			started := time.Now()
+			if err := c.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if took := time.Since(started); took > consoleDrainTimeout+time.Second {
+				t.Errorf("close took %s", took)
+			}
+			if err := c.Close(); err != nil {
+				t.Fatalf("second close: %v", err)
+			}
+
+			// Close waited on the output copy, so out is settled
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("got output %q, want %q", out.String(), want)
+			}
+			after, err := unix.IoctlGetTermios(int(host.Fd()), unix.TCGETS)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *after != *before {
+				t.Errorf("host terminal not restored: got %+v, want %+v", after, before)
+			}
+			if n := settled(goroutines); n > goroutines {
+				buf := make([]byte, 1<<16)
+				t.Errorf("%d goroutines left over:\n%s", n-goroutines, buf[:runtime.Stack(buf, true)])
+			}
+			if n := openFds(t); n != fds {
+				t.Errorf("got %d fds open after close, want %d", n, fds)
+			}
+		})
+	}
+}
+
+func TestLocalConsoleResize(t *testing.T) {
+	typing, host, err := openPty()
+	if err != nil {
+		t.Skipf("no pty: %v", err)
+	}
+	defer typing.Close()
+	defer host.Close()
+
+	c, err := openLocalConsole(host, &bytes.Buffer{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	want := unix.Winsize{Row: 33, Col: 111}
+	if err := unix.IoctlSetWinsize(int(typing.Fd()), unix.TIOCSWINSZ, &want); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Kill(os.Getpid(), unix.SIGWINCH); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		got, err := unix.IoctlGetWinsize(int(c.slave.Fd()), unix.TIOCGWINSZ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Row == want.Row && got.Col == want.Col {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %dx%d, want the host's %dx%d", got.Col, got.Row, want.Col, want.Row)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
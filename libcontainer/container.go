@@ -1,17 +1,24 @@
 package libcontainer
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
-	"syscall"
 	"time"
 
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/zakarynichols/hackontainer/api/types"
 	"github.com/zakarynichols/hackontainer/config"
+	"github.com/zakarynichols/hackontainer/libcontainer/audit"
+	"github.com/zakarynichols/hackontainer/version"
+	"golang.org/x/sys/unix"
 )
 
 type Container interface {
@@ -19,30 +26,76 @@ type Container interface {
 	Status() (Status, error)
 	State() (*State, error)
 	Start() error
+	// StartContext is Start, giving up once ctx is done: the container
+	// process is killed and the container stays created.
+	StartContext(ctx context.Context) error
+	// StartWithResult is StartContext, also reporting whether the
+	// container process exited immediately. Start and StartContext
+	// return the same error for a process that failed that way.
+	StartWithResult(ctx context.Context) (*StartResult, error)
 	Run() error
+	// RunContext is Run, with ctx bounding the start only.
+	RunContext(ctx context.Context) error
+	// RunWithResult is RunContext, also reporting how the container
+	// process exited. While it runs, the signals a foreground run
+	// forwards are the container's rather than the caller's.
+	RunWithResult(ctx context.Context) (*StartResult, error)
 	InitProcess() error
-	Signal(sig syscall.Signal) error
+	// Signal sends sig to the container process, or with all to every
+	// process in the container's cgroup.
+	Signal(sig unix.Signal, all bool) error
+	// Pause freezes every process of a running container and Resume
+	// thaws them again. See linuxContainer.Pause.
+	Pause() error
+	Resume() error
 	Delete() error
+	NamespacePaths() (map[specs.LinuxNamespaceType]string, error)
+	// RunInNamespaces runs a host binary in some of the namespaces of
+	// the container process. See linuxContainer.RunInNamespaces.
+	RunInNamespaces(nsTypes []NamespaceType, cmd *exec.Cmd) error
+	// Exec runs process in the running container, in the namespaces and
+	// cgroup of the container process, and waits for it. See
+	// linuxContainer.Exec.
+	Exec(process *specs.Process) error
+	// NetNSDo runs fn on a thread in the container's network
+	// namespace. See linuxContainer.NetNSDo for what fn may do.
+	NetNSDo(fn func() error) error
+	Inspect() (*InspectInfo, error)
+	// Labels returns the labels set when the container was created.
+	Labels() map[string]string
+	Stats() (*Stats, error)
+	FinalStats() (*Stats, error)
+	// Processes lists the pids of a running container. See
+	// linuxContainer.Processes.
+	Processes() ([]int, error)
+	// Set changes the limits of a container that hasn't stopped. See
+	// linuxContainer.Set.
+	Set(resources specs.LinuxResources) error
+	// Checkpoint dumps a running container with criu. See
+	// linuxContainer.Checkpoint.
+	Checkpoint(opts CheckpointOptions) error
+	// Restore brings a container created without a process back from a
+	// checkpoint. See linuxContainer.Restore.
+	Restore(opts RestoreOptions) error
+	// Subscribe sends the container's state on every change until it is
+	// deleted. See linuxContainer.Subscribe.
+	Subscribe(ctx context.Context) (<-chan State, error)
 }
 
-type Status string
+// NamespaceType is a kind of namespace, as named in the spec.
+type NamespaceType = specs.LinuxNamespaceType
+
+type Status = types.Status
 
 const (
-	Created Status = "created"
-	Running Status = "running"
-	Stopped Status = "stopped"
+	Created = types.Created
+	Running = types.Running
+	Paused  = types.Paused
+	Stopped = types.Stopped
 )
 
-type State struct {
-	ID                   string            `json:"id"`
-	Pid                  int               `json:"pid"`
-	Bundle               string            `json:"bundle"`
-	Status               Status            `json:"status"`
-	Created              time.Time         `json:"created"`
-	Annotations          map[string]string `json:"annotations,omitempty"`
-	OCIVersion           string            `json:"ociVersion"`
-	InitProcessStartTime uint64            `json:"initProcessStartTime,omitempty"`
-}
+// State is the container state as saved in state.json.
+type State = types.State
 
 type procState struct {
 	Pid   int
@@ -90,12 +143,14 @@ func getProcessStartTime(pid int) (uint64, error) {
 		return 0, fmt.Errorf("invalid /proc/stat format")
 	}
 
+	// Fields are counted from the state, the third; starttime is the
+	// 22nd. Later ones, such as rss, change as the process runs
 	parts := strings.Split(string(data[idx+2:]), " ")
-	if len(parts) < 22 {
+	if len(parts) < 20 {
 		return 0, fmt.Errorf("invalid /proc/stat format")
 	}
 
-	startTime, err := strconv.ParseUint(parts[21], 10, 64)
+	startTime, err := strconv.ParseUint(parts[19], 10, 64)
 	if err != nil {
 		return 0, err
 	}
@@ -106,9 +161,59 @@ func getProcessStartTime(pid int) (uint64, error) {
 type linuxContainer struct {
 	id          string
 	root        string
+	namespace   string
 	config      *config.Config
 	bundle      string
+	configPath  string
 	initProcess parentProcess
+
+	restartPolicy *RestartPolicy
+	maxRuntime    *MaxRuntime
+	rootfsQuota   *RootfsQuota
+	createMode    CreateMode
+
+	// labels are those set at create, which events carry.
+	labels map[string]string
+
+	// console is the runtime-allocated pty of a foreground run.
+	console *localConsole
+	// consoleSocket is where the pty of a process with a terminal is
+	// sent instead, when set.
+	consoleSocket string
+	// configSHA256 is the hash of the config created from, set by Create.
+	configSHA256 string
+	// foreground is set for a run acting as its own monitor. Its init
+	// stays in the runtime's process group, for the terminal's signals.
+	foreground bool
+	// forwardToAll has a foreground run forward the signals it gets to
+	// every process of the container.
+	forwardToAll bool
+
+	// exitedAt is when supervise last saw the container process exit.
+	exitedAt time.Time
+
+	// owner is the host stamped on a container created on a shared
+	// root.
+	owner *Owner
+
+	// ownerErr is set on Load for a container another host owns.
+	ownerErr error
+
+	// namespaceErr is the result of checking the live process against
+	// the configured namespaces on Load.
+	namespaceErr       error
+	skipNamespaceCheck bool
+
+	// retry is how cleanup retries what the kernel reports busy.
+	retry RetryPolicy
+
+	// auditSink and caller are the factory's; see LinuxFactory.
+	auditSink audit.Sink
+	caller    *audit.Caller
+
+	// preservedFiles are handed to the container process from fd 3 on;
+	// see WithCreatePreservedFDs.
+	preservedFiles []*os.File
 }
 
 func (c *linuxContainer) ID() string {
@@ -124,15 +229,58 @@ func (c *linuxContainer) Status() (Status, error) {
 	return state.Status, nil
 }
 
+// bootIDPath changes on every boot of the host.
+const bootIDPath = "/proc/sys/kernel/random/boot_id"
+
+// bootID returns the host's current boot_id, or "" if it can't be read.
+func bootID() string {
+	data, err := os.ReadFile(bootIDPath)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// fromPreviousBoot reports whether state was recorded before the host
+// last rebooted. States without a boot id are assumed current, and so
+// are those of another host's containers, which its reboots don't end.
+func fromPreviousBoot(state *State) bool {
+	if state.BootID == "" || foreignOwner(state) {
+		return false
+	}
+	current := bootID()
+	return current != "" && current != state.BootID
+}
+
 func (c *linuxContainer) State() (*State, error) {
 	state, err := c.loadState()
 	if err != nil {
 		return nil, err
 	}
+	c.checkState(state)
+	return state, nil
+}
+
+// checkState corrects a recorded state by what is left of its processes.
+func (c *linuxContainer) checkState(state *State) {
+	// Another host's pids say nothing about this one's processes
+	if foreignOwner(state) {
+		return
+	}
+
+	// A root on persistent storage outlives a reboot, and the pids it
+	// recorded belong to other processes by now
+	if fromPreviousBoot(state) {
+		if state.Status == Running || state.Status == Paused {
+			state.Status = Stopped
+		}
+		state.MonitorPid = 0
+		return
+	}
 
 	// Check if we have an in-memory initProcess (like runc does)
 	// This is more reliable than just reading from disk
-	if c.initProcess != nil && state.Status == Running {
+	if c.initProcess != nil && (state.Status == Running || state.Status == Paused) {
 		pid := c.initProcess.pid()
 		startTime, err := c.initProcess.startTime()
 		if err != nil {
@@ -147,7 +295,7 @@ func (c *linuxContainer) State() (*State, error) {
 				state.Status = Stopped
 			}
 		}
-	} else if state.Status == Running && state.Pid > 0 {
+	} else if (state.Status == Running || state.Status == Paused || state.Status == Created) && state.Pid > 0 {
 		// Fallback: check if process exists using /proc
 		// First check if /proc/[pid] exists - this is more reliable than Kill in some namespace scenarios
 		procPath := fmt.Sprintf("/proc/%d", state.Pid)
@@ -165,24 +313,45 @@ func (c *linuxContainer) State() (*State, error) {
 		}
 	}
 
-	return state, nil
+	// A monitor that was killed never got to clear itself
+	if state.MonitorPid > 0 {
+		if _, err := os.Stat(fmt.Sprintf("/proc/%d", state.MonitorPid)); err != nil {
+			state.MonitorPid = 0
+		}
+	}
 }
 
 func (c *linuxContainer) Start() error {
+	return c.StartContext(context.Background())
+}
+
+func (c *linuxContainer) StartContext(ctx context.Context) error {
+	_, err := c.StartWithResult(ctx)
+	return err
+}
+
+// start starts the container process under a monitor.
+func (c *linuxContainer) start(ctx context.Context) error {
 	state, err := c.State()
 	if err != nil {
 		return err
 	}
 
+	if c.markerExists(deletingFilename) {
+		return newTypedError(ErrInvalidState, "cannot start a container that is being deleted")
+	}
+
 	// OCI spec: start operation MUST only work on containers in 'created' state
 	if state.Status != Created {
 		switch state.Status {
 		case Running:
-			return fmt.Errorf("cannot start an already running container")
+			return newTypedError(ErrInvalidState, "cannot start an already running container")
+		case Paused:
+			return newTypedError(ErrInvalidState, "cannot start a paused container; resume it instead")
 		case Stopped:
-			return fmt.Errorf("cannot start a container that has stopped")
+			return newTypedError(ErrInvalidState, "cannot start a container that has stopped")
 		default:
-			return fmt.Errorf("cannot start a container in the %s state", state.Status)
+			return newTypedError(ErrInvalidState, "cannot start a container in the %s state", state.Status)
 		}
 	}
 
@@ -191,13 +360,58 @@ func (c *linuxContainer) Start() error {
 		return fmt.Errorf("container process not configured")
 	}
 
-	process, err := newInitProcess(c)
+	// The monitor is detached: only a console socket can get the terminal
+	if c.config.Process.Terminal && state.ConsoleSocket == "" {
+		return newTypedError(ErrInvalidConfig, "process.terminal is set but the container was created without a console socket to send the terminal to")
+	}
+
+	if err := c.verifyFrozenConfig(state); err != nil {
+		return err
+	}
+	c.warnVersionMismatch()
+	// A full create left the process waiting, under its monitor
+	if createMode(state) == CreateModeFull {
+		return c.startCreated(ctx)
+	}
+	c.warnBundleConfigChanged(state)
+	return c.startMonitor(ctx)
+}
+
+// startInit starts the container process and records it as running. The
+// caller becomes responsible for waiting on the returned process. The
+// first start of a container created in CreateModeFull leaves the
+// process waiting for start instead, and the container created.
+func (c *linuxContainer) startInit(ctx context.Context) (parentProcess, error) {
+	state, err := c.loadState()
 	if err != nil {
-		return fmt.Errorf("failed to create init process: %w", err)
+		return nil, err
+	}
+	waitsForStart := state.Status == Created && createMode(state) == CreateModeFull
+	c.consoleSocket = state.ConsoleSocket
+	// Restarts and runs read the frozen config too
+	if err := c.verifyFrozenConfig(state); err != nil {
+		return nil, err
+	}
+
+	// This start gets its own poststop
+	if err := os.Remove(filepath.Join(c.root, poststopFilename)); err != nil && !os.IsNotExist(err) {
+		return nil, err
 	}
 
-	if err := process.start(); err != nil {
-		return fmt.Errorf("failed to start init process: %w", err)
+	process, err := newInitProcess(c, waitsForStart)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create init process: %w", err)
+	}
+
+	if err := process.start(ctx); err != nil {
+		data := map[string]string{"error": err.Error()}
+		var startErr *StartError
+		if errors.As(err, &startErr) {
+			data["phase"] = string(startErr.Phase)
+			data["error"] = startErr.Err.Error()
+		}
+		c.emit(EventStartFailed, data)
+		return nil, err
 	}
 
 	// Store initProcess in memory for reliable state checking (like runc)
@@ -209,106 +423,299 @@ func (c *linuxContainer) Start() error {
 		startTime = 0
 	}
 
+	// A stopped container being started again is a restart
+	if state.Status == Stopped {
+		state.RestartCount++
+	}
+
 	// Update state atomically after successful process start
-	state.Status = Running
+	if !waitsForStart {
+		state.Status = Running
+		now := time.Now()
+		state.StartedAt = &now
+	}
+	state.KilledByTimeout = false
 	state.Pid = process.pid()
 	state.InitProcessStartTime = startTime
+	state.BootID = bootID()
+	state.ExitStatus = nil
+	// Whoever starts the process supervises it
+	state.MonitorPid = os.Getpid()
 	if err := c.saveState(state); err != nil {
 		_ = process.terminate()
-		return fmt.Errorf("failed to save container state after start: %w", err)
+		return nil, fmt.Errorf("failed to save container state after start: %w", err)
+	}
+	if waitsForStart {
+		return process, nil
 	}
 
-	return nil
+	c.emit(EventStart, map[string]string{"pid": strconv.Itoa(state.Pid)})
+
+	// A failing poststart hook doesn't stop the container
+	hookState := c.hookState(specs.StateRunning, state.Pid)
+	if err := runHooks(context.Background(), HookPoststart, c.config.Resolved.Hooks[HookPoststart], hookState, c.hookEnv(hookState)); err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: %v\n", err)
+	}
+
+	return process, nil
 }
 
 // InitProcess creates and starts the init process for container initialization
 func (c *linuxContainer) InitProcess() error {
-	process, err := newInitProcess(c)
+	process, err := newInitProcess(c, false)
 	if err != nil {
 		return fmt.Errorf("failed to create init process: %w", err)
 	}
 
-	if err := process.start(); err != nil {
-		return fmt.Errorf("failed to start init process: %w", err)
+	if err := process.start(context.Background()); err != nil {
+		return err
 	}
 
 	// This should not be reached in normal operation as the init process will exec
 	return nil
 }
 
+// Run starts the container in the foreground, acting as its own monitor
+// until the process exits and the restart policy is exhausted.
 func (c *linuxContainer) Run() error {
-	process, err := newInitProcess(c)
-	if err != nil {
-		return fmt.Errorf("failed to create init process: %w", err)
-	}
+	return c.RunContext(context.Background())
+}
 
-	if err := process.start(); err != nil {
-		return fmt.Errorf("failed to start init process: %w", err)
-	}
+func (c *linuxContainer) RunContext(ctx context.Context) error {
+	_, err := c.RunWithResult(ctx)
+	return err
+}
 
-	_, err = process.wait()
-	if err != nil {
-		return err
-	}
+// Delete removes the container. The steps run in a fixed order under the
+// container lock and each treats "already gone" as done, so a delete
+// that died halfway is finished by running it again. state.json goes
+// last: until then the container loads as usual, and the deleting
+// marker covers the gap between it and the directory itself.
+func (c *linuxContainer) Delete() (retErr error) {
+	defer func() { c.audit(AuditDelete, nil, retErr) }()
 
-	state, err := c.State()
+	unlock, err := c.lock()
+	if os.IsNotExist(err) {
+		return newTypedError(ErrNotExist, "container %q does not exist", c.id)
+	}
 	if err != nil {
 		return err
 	}
-	state.Status = Stopped
-	return c.saveState(state)
-}
+	defer unlock()
 
-func (c *linuxContainer) Delete() error {
 	// OCI spec: delete MUST generate an error if container is not stopped
 	state, err := c.State()
 	if err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("failed to get container state: %w", err)
 	}
+	if state == nil && !c.markerExists(deletingFilename) {
+		return newTypedError(ErrNotExist, "container %q does not exist", c.id)
+	}
 	if state != nil && state.Status == Running {
-		return fmt.Errorf("cannot delete a container that is running")
+		return newTypedError(ErrRunning, "cannot delete a container that is running")
+	}
+	if state != nil && state.Status == Paused {
+		return newTypedError(ErrInvalidState, "cannot delete a paused container; resume or kill it first")
+	}
+	// A full create leaves a process waiting for start, which goes with
+	// the container
+	if state != nil && state.Status == Created && state.Pid > 0 {
+		if err := killCreated(state.Pid); err != nil {
+			return err
+		}
 	}
 
-	statePath := filepath.Join(c.root, stateFilename)
-	if err := os.Remove(statePath); err != nil && !os.IsNotExist(err) {
+	if err := c.createMarker(deletingFilename); err != nil {
 		return err
 	}
+	deleteCrashPoint("marked")
 
-	return os.RemoveAll(c.root)
+	if state != nil && c.config != nil {
+		c.runPoststop(state, func(err error) {
+			fmt.Fprintf(os.Stderr, "WARNING: %v\n", err)
+		})
+	}
+	deleteCrashPoint("poststop")
+
+	if c.config != nil {
+		if err := c.cgroupManager().Destroy(); err != nil {
+			return err
+		}
+	}
+	deleteCrashPoint("cgroup")
+
+	if state != nil && state.RootfsQuota != nil && c.config != nil {
+		if err := releaseRootfsQuota(c.factoryRoot(), filepath.Join(c.namespace, c.id), c.config.Rootfs, state.RootfsQuota.ProjectID); err != nil {
+			return fmt.Errorf("failed to release rootfs quota: %w", err)
+		}
+	}
+	deleteCrashPoint("quota")
+
+	if err := unpinRootfs(c.root, c.retry); err != nil {
+		return fmt.Errorf("failed to unpin rootfs: %w", err)
+	}
+
+	entries, err := os.ReadDir(c.root)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range entries {
+		// What a repeated delete needs to redo nothing twice stays
+		if name := entry.Name(); !deleteKeeps[name] {
+			if err := os.RemoveAll(filepath.Join(c.root, name)); err != nil {
+				return err
+			}
+		}
+	}
+	deleteCrashPoint("files")
+
+	if err := os.Remove(filepath.Join(c.root, stateFilename)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	deleteCrashPoint("state")
+
+	if err := os.RemoveAll(c.root); err != nil {
+		return err
+	}
+
+	c.emit(EventDelete, nil)
+	return nil
 }
 
-func (c *linuxContainer) Signal(sig syscall.Signal) error {
+// deleteKeeps are the files Delete removes only with the directory.
+var deleteKeeps = map[string]bool{
+	stateFilename:    true,
+	configFilename:   true,
+	deletingFilename: true,
+	poststopFilename: true,
+	layoutFilename:   true,
+}
+
+// runPoststop runs the poststop hooks unless they already ran since the
+// container process last started, whether from the monitor or an
+// earlier delete. Failures don't stop anything and go to report.
+func (c *linuxContainer) runPoststop(state *State, report func(error)) {
+	if c.markerExists(poststopFilename) {
+		return
+	}
+	hookState := c.hookState(specs.StateStopped, state.Pid)
+	if err := runHooks(context.Background(), HookPoststop, c.config.Resolved.Hooks[HookPoststop], hookState, c.hookEnv(hookState)); err != nil {
+		report(err)
+	}
+	if err := c.createMarker(poststopFilename); err != nil {
+		report(err)
+	}
+}
+
+func (c *linuxContainer) Signal(sig unix.Signal, all bool) (retErr error) {
+	defer func() {
+		c.audit(AuditKill, map[string]string{"signal": strconv.Itoa(int(sig)), "all": strconv.FormatBool(all)}, retErr)
+	}()
+
 	state, err := c.State()
 	if err != nil {
 		return fmt.Errorf("failed to get container state: %w", err)
 	}
 
 	// OCI spec: kill MUST generate an error if container is neither created nor running
-	if state.Status != Running && state.Status != Created {
-		return fmt.Errorf("cannot signal a container that is not running or created")
+	if state.Status != Running && state.Status != Paused && state.Status != Created {
+		return newTypedError(ErrNotRunning, "cannot signal a container that is not running or created")
 	}
 
 	if state.Pid == 0 {
-		return fmt.Errorf("no process to signal")
+		return newTypedError(ErrNotRunning, "no process to signal")
 	}
 
-	err = syscall.Kill(state.Pid, sig)
-	if err != nil {
+	if err := c.checkNamespaces("signal"); err != nil {
+		return err
+	}
+
+	// An explicit kill must not be undone by the restart policy
+	if state.RestartPolicy != nil && !state.RestartSuppressed {
+		state.RestartSuppressed = true
+		if err := c.saveState(state); err != nil {
+			return fmt.Errorf("failed to save container state: %w", err)
+		}
+	}
+
+	if err := c.sendSignal(state, sig, all); err != nil {
 		return fmt.Errorf("failed to send signal: %w", err)
 	}
 
+	// Other signals wait for Resume, but a frozen process can't die of
+	// SIGKILL on cgroup v1 until thawed. The monitor records the exit
+	if state.Status == Paused && sig == unix.SIGKILL {
+		if err := c.cgroupManager().Freeze(false); err != nil {
+			return fmt.Errorf("failed to thaw killed container: %w", err)
+		}
+	}
+
 	return nil
 }
 
+// sendSignal sends sig to the container process of state, or with all,
+// to every process of the container, and records it.
+func (c *linuxContainer) sendSignal(state *State, sig unix.Signal, all bool) error {
+	var err error
+	switch {
+	case all && state.CgroupPath == "":
+		err = signalGroup(hostSys, state.Pid, sig)
+	case all && state.Status == Paused:
+		// Nothing forks in a frozen cgroup, and signalAll would thaw it
+		var pids []int
+		if pids, err = c.cgroupManager().Pids(); err == nil {
+			signalPids(hostSys, pids, sig)
+		}
+	case all:
+		err = c.signalAll(sig)
+	default:
+		err = unix.Kill(state.Pid, sig)
+	}
+	if err != nil {
+		return err
+	}
+
+	data := map[string]string{"signal": strconv.Itoa(int(sig))}
+	if all {
+		data["all"] = "true"
+	}
+	c.emit(EventKill, data)
+	return nil
+}
+
+// factoryRoot is the --root the container was created under, above its
+// namespace if it has one. Quota project IDs are allocated there since
+// they are shared by every namespace on the filesystem.
+func (c *linuxContainer) factoryRoot() string {
+	root := filepath.Dir(c.root)
+	if c.namespace != "" {
+		root = filepath.Dir(root)
+	}
+	return root
+}
+
 func (c *linuxContainer) createState() error {
 	state := &State{
-		ID:          c.id,
-		Pid:         0,
-		Bundle:      c.bundle,
-		Status:      Created,
-		Created:     time.Now(),
-		Annotations: make(map[string]string),
-		OCIVersion:  "1.3.0",
+		ID:            c.id,
+		Pid:           0,
+		Bundle:        c.bundle,
+		Status:        Created,
+		Created:       time.Now(),
+		Annotations:   make(map[string]string),
+		OCIVersion:    version.OCIVersion,
+		ConfigPath:    c.configPath,
+		RestartPolicy: c.restartPolicy,
+		MaxRuntime:    c.maxRuntime,
+		RootfsQuota:   c.rootfsQuota,
+		Namespace:     c.namespace,
+		CgroupPath:    c.config.Resolved.CgroupsPath,
+		CreateMode:    c.createMode,
+		Owner:         c.owner,
+		ConsoleSocket: c.consoleSocket,
+		ConfigSHA256:  c.configSHA256,
+	}
+	if err := c.recordFrozenConfig(state); err != nil {
+		return err
 	}
 
 	if c.config.Spec != nil && c.config.Spec.Annotations != nil {
@@ -318,14 +725,71 @@ func (c *linuxContainer) createState() error {
 	return c.saveState(state)
 }
 
+// lock takes an exclusive lock on the container directory. Delete and
+// the monitor recording an exit hold it, so neither sees the other
+// halfway. It fails with an os.IsNotExist error once the container is
+// gone, including when it was deleted while we waited. The container
+// is locked with flock, or with a lock file where it was created so; a
+// container on a shared root is locked with lockShared.
+func (c *linuxContainer) lock() (func(), error) {
+	if c.markerExists(sharedRootFilename) {
+		return c.lockShared()
+	}
+	unlock, err := lockerOf(c.root).lockDir(c.root)
+	if err != nil && !os.IsNotExist(err) && !errors.Is(err, ErrLocksUnsupported) {
+		return nil, fmt.Errorf("failed to lock container: %w", err)
+	}
+	return unlock, err
+}
+
+// Marker files in the container root.
+const (
+	// poststopFilename records that the poststop hooks ran for the
+	// current stop of the container.
+	poststopFilename = "poststop.done"
+	// deletingFilename records that a delete has begun.
+	deletingFilename = "deleting"
+)
+
+func (c *linuxContainer) markerExists(name string) bool {
+	_, err := os.Stat(filepath.Join(c.root, name))
+	return err == nil
+}
+
+func (c *linuxContainer) createMarker(name string) error {
+	f, err := os.OpenFile(filepath.Join(c.root, name), os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// deleteCrashEnv names a Delete step after which the process kills
+// itself. Tests use it to check that a later delete finishes the job.
+const deleteCrashEnv = "HACKONTAINER_TEST_DELETE_CRASH"
+
+func deleteCrashPoint(step string) {
+	if os.Getenv(deleteCrashEnv) == step {
+		unix.Kill(os.Getpid(), unix.SIGKILL)
+	}
+}
+
 func (c *linuxContainer) saveState(state *State) error {
 	statePath := filepath.Join(c.root, stateFilename)
+	state.SchemaVersion = types.SchemaVersion
 	data, err := json.MarshalIndent(state, "", "  ")
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(statePath, data, 0644)
+	// Write to a temporary file and rename so concurrent readers (the
+	// monitor and CLI invocations) never see a partial state
+	tmpPath := statePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, statePath)
 }
 
 func (c *linuxContainer) loadState() (*State, error) {
@@ -339,6 +803,8 @@ func (c *linuxContainer) loadState() (*State, error) {
 	if err := json.Unmarshal(data, &state); err != nil {
 		return nil, err
 	}
+	// Older state files predate the field but are the same document
+	state.SchemaVersion = types.SchemaVersion
 
 	return &state, nil
 }
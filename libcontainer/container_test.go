@@ -0,0 +1,53 @@
+package libcontainer
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zakarynichols/hackontainer/config"
+)
+
+// FuzzStateAnnotations writes the state of a container with hostile
+// annotations and reads it back. Annotations validation accepts come
+// back unchanged, and state.json stays a document readers can parse.
+func FuzzStateAnnotations(f *testing.F) {
+	f.Add("org.example.key", "value", "/bundle")
+	f.Add("", "", "/")
+	f.Add("key\x00with\nnul", "value", "/bundle")
+	f.Add("key", "\x00\x1b[2J  </script>", "/bundle")
+	f.Add("k\xff", "v\xfe\xc0\xaf", "/bundle")
+	f.Add("key", `{"status":"running"}`, "/bundle\n/../etc")
+	f.Add("‮evil", "\"}\n{\"id\":\"other\"", "relative/bundle")
+
+	f.Fuzz(func(t *testing.T, key, value, bundle string) {
+		annotations := map[string]string{key: value, "org.example.fixed": "x"}
+		if config.ValidateAnnotations(annotations, config.DefaultMaxAnnotationsSize) != nil {
+			return
+		}
+		if config.ValidateBundlePath(bundle) != nil {
+			return
+		}
+
+		c := &linuxContainer{id: "fuzz", root: t.TempDir()}
+		want := &State{}
+		want.ID = "fuzz"
+		want.Bundle = bundle
+		want.Annotations = annotations
+		if err := c.saveState(want); err != nil {
+			t.Fatalf("saving the state of accepted annotations: %v", err)
+		}
+		got, err := c.loadState()
+		if err != nil {
+			data, _ := os.ReadFile(filepath.Join(c.root, stateFilename))
+			t.Fatalf("loading the state: %v\n%s", err, data)
+		}
+		if !maps.Equal(got.Annotations, annotations) {
+			t.Fatalf("annotations changed on the way through state.json: got %q, want %q", got.Annotations, annotations)
+		}
+		if got.Bundle != bundle || got.ID != "fuzz" {
+			t.Fatalf("got id %q bundle %q, want fuzz %q", got.ID, got.Bundle, bundle)
+		}
+	})
+}
@@ -0,0 +1,162 @@
+package libcontainer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/zakarynichols/hackontainer/api/types"
+	"golang.org/x/sys/unix"
+)
+
+// cpuAffinityAnnotation decides the CPU affinity the container process
+// starts with. "inherit", the default, keeps the runtime's mask, so a
+// runtime started under taskset passes its restriction on. "reset"
+// widens it to every CPU of the container's cpuset first.
+const cpuAffinityAnnotation = "org.hackontainer.cpu-affinity"
+
+// CPU affinity modes.
+const (
+	CPUAffinityInherit = types.CPUAffinityInherit
+	CPUAffinityReset   = types.CPUAffinityReset
+)
+
+// CPUInfo describes the CPU settings of a container.
+type CPUInfo = types.CPUInfo
+
+func cpuAffinityMode(spec *specs.Spec) string {
+	if spec != nil {
+		if mode, ok := spec.Annotations[cpuAffinityAnnotation]; ok {
+			return mode
+		}
+	}
+	return CPUAffinityInherit
+}
+
+// validateCPU checks the CPU settings the kernel would reject only once
+// the container is half set up.
+func validateCPU(spec *specs.Spec) error {
+	switch mode := cpuAffinityMode(spec); mode {
+	case CPUAffinityInherit, CPUAffinityReset:
+	default:
+		return fmt.Errorf("%s must be %q or %q, got %q", cpuAffinityAnnotation, CPUAffinityInherit, CPUAffinityReset, mode)
+	}
+
+	if spec.Linux == nil || spec.Linux.Resources == nil || spec.Linux.Resources.CPU == nil {
+		return nil
+	}
+	cpu := spec.Linux.Resources.CPU
+	if cpu.Burst != nil && *cpu.Burst > 0 {
+		if cpu.Quota == nil || *cpu.Quota <= 0 {
+			return fmt.Errorf("linux.resources.cpu.burst needs a cpu quota to burst above")
+		}
+		if *cpu.Burst > uint64(*cpu.Quota) {
+			return fmt.Errorf("linux.resources.cpu.burst (%d) cannot exceed linux.resources.cpu.quota (%d)", *cpu.Burst, *cpu.Quota)
+		}
+	}
+	return nil
+}
+
+// writeCPUBurst sets the burst of a cgroup whose quota is already set.
+// file is cpu.max.burst on v2 and cpu.cfs_burst_us on v1.
+func writeCPUBurst(dir, file string, burst uint64) error {
+	if _, err := os.Stat(filepath.Join(dir, file)); os.IsNotExist(err) {
+		return fmt.Errorf("cpu burst is not supported by this kernel: %s is missing (needs Linux 5.14 or later)", file)
+	}
+	return writeCgroupFile(dir, file, strconv.FormatUint(burst, 10))
+}
+
+// cgroupCPUs returns the CPUs the cgroup may run on as a cpu list, falling
+// back to every online CPU when there is no cpuset to ask.
+func cgroupCPUs(m CgroupManager) (string, error) {
+	paths := m.Paths()
+	var candidates []string
+	if dir, ok := paths[""]; ok {
+		candidates = append(candidates, filepath.Join(dir, "cpuset.cpus.effective"))
+	}
+	if dir, ok := paths["cpuset"]; ok {
+		candidates = append(candidates, filepath.Join(dir, "cpuset.effective_cpus"))
+	}
+	for _, path := range candidates {
+		if data, err := os.ReadFile(path); err == nil && strings.TrimSpace(string(data)) != "" {
+			return strings.TrimSpace(string(data)), nil
+		}
+	}
+
+	data, err := os.ReadFile("/sys/devices/system/cpu/online")
+	if err != nil {
+		return "", fmt.Errorf("failed to read online cpus: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// parseCPUList parses the kernel's cpu list format, e.g. "0-3,8".
+func parseCPUList(list string) (*unix.CPUSet, error) {
+	var set unix.CPUSet
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last, isRange := strings.Cut(part, "-")
+		lo, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpu list %q", list)
+		}
+		hi := lo
+		if isRange {
+			if hi, err = strconv.Atoi(last); err != nil || hi < lo {
+				return nil, fmt.Errorf("invalid cpu list %q", list)
+			}
+		}
+		for cpu := lo; cpu <= hi; cpu++ {
+			set.Set(cpu)
+		}
+	}
+	if set.Count() == 0 {
+		return nil, fmt.Errorf("empty cpu list %q", list)
+	}
+	return &set, nil
+}
+
+// resetCPUAffinity sets the calling thread's affinity to cpus. The child
+// calls it on the thread that execs, which is the one that counts.
+func resetCPUAffinity(cpus string) error {
+	set, err := parseCPUList(cpus)
+	if err != nil {
+		return err
+	}
+	if err := unix.SchedSetaffinity(0, set); err != nil {
+		return fmt.Errorf("failed to reset cpu affinity to %s: %w", cpus, err)
+	}
+	return nil
+}
+
+// cpuInfo reports the container's CPU settings for inspect. The burst
+// and affinity are read back from the cgroup and the process, so they
+// are only known while the container runs.
+func (c *linuxContainer) cpuInfo(pid int) *CPUInfo {
+	info := &CPUInfo{AffinityMode: cpuAffinityMode(c.config.Spec)}
+	if pid <= 0 {
+		return info
+	}
+
+	paths := c.cgroupManager().Paths()
+	if burst := readCgroupUint(paths[""], "cpu.max.burst"); burst != nil {
+		info.BurstUsec = burst
+	} else if dir := paths["cpu"]; dir != "" {
+		info.BurstUsec = readCgroupUint(dir, "cpu.cfs_burst_us")
+	}
+
+	if data, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", pid)); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if value, ok := strings.CutPrefix(line, "Cpus_allowed_list:"); ok {
+				info.Affinity = strings.TrimSpace(value)
+			}
+		}
+	}
+	return info
+}
@@ -0,0 +1,296 @@
+package libcontainer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/zakarynichols/hackontainer/api/types"
+	"golang.org/x/sys/unix"
+)
+
+// CreateMode is what create leaves behind for start to run.
+type CreateMode = types.CreateMode
+
+const (
+	CreateModeStateOnly = types.CreateModeStateOnly
+	CreateModeFull      = types.CreateModeFull
+)
+
+// execFifoFilename is the fifo a container created in CreateModeFull
+// blocks on until start opens it.
+const execFifoFilename = "exec.fifo"
+
+// execFifoReady is what the container process writes to the exec fifo
+// once start has opened it. A procError message may follow if the exec
+// fails; otherwise the fifo is closed on exec.
+const execFifoReady = "0"
+
+// execFifoPollInterval is how often start checks that the process it is
+// waiting to release is still there.
+const execFifoPollInterval = 100 * time.Millisecond
+
+// ParseCreateMode parses state-only or full.
+func ParseCreateMode(s string) (CreateMode, error) {
+	switch mode := CreateMode(s); mode {
+	case CreateModeStateOnly, CreateModeFull:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid create mode %q (want state-only or full)", s)
+}
+
+// WithCreateMode sets what Create leaves behind. The default is
+// CreateModeStateOnly: state and nothing else, with pid 0 and every hook
+// deferred to start. CreateModeFull starts the container process under
+// a monitor, runs the hooks up to createContainer and leaves the process
+// blocked before exec until start. Run always starts a state-only
+// container.
+func WithCreateMode(mode CreateMode) CreateOption {
+	return func(l *LinuxFactory) error {
+		if _, err := ParseCreateMode(string(mode)); err != nil {
+			return err
+		}
+		l.createMode = mode
+		return nil
+	}
+}
+
+// createMode is how the container in state was created.
+func createMode(state *State) CreateMode {
+	if state.CreateMode == "" {
+		return CreateModeStateOnly
+	}
+	return state.CreateMode
+}
+
+// createExecFifo makes the fifo the container process of a full create
+// waits on. A fifo left by a create that crashed is removed first: it
+// may still have an opener blocked on it, which start would release in
+// place of the new process. Anything else at that path, or a fifo the
+// runtime didn't make, isn't this container's to remove.
+func (c *linuxContainer) createExecFifo() error {
+	path := filepath.Join(c.root, execFifoFilename)
+	var st unix.Stat_t
+	err := unix.Lstat(path, &st)
+	switch {
+	case errors.Is(err, unix.ENOENT):
+	case err != nil:
+		return fmt.Errorf("failed to check for a stale exec fifo: %w", err)
+	case !ownExecFifo(&st):
+		return fmt.Errorf("%s is in the way of the exec fifo and not one the runtime left", path)
+	default:
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove stale exec fifo: %w", err)
+		}
+	}
+	if err := unix.Mkfifo(path, 0600); err != nil {
+		return fmt.Errorf("failed to create exec fifo: %w", err)
+	}
+	return nil
+}
+
+// ownExecFifo reports whether st describes an exec fifo as
+// createExecFifo makes them: a fifo of the runtime's user.
+func ownExecFifo(st *unix.Stat_t) bool {
+	return st.Mode&unix.S_IFMT == unix.S_IFIFO && int(st.Uid) == os.Geteuid()
+}
+
+// openExecFifo opens the exec fifo as an O_PATH fd for the container
+// process, which reopens it for writing once it is set up. That can't
+// block, unlike opening it for writing here.
+func (c *linuxContainer) openExecFifo() (*os.File, error) {
+	path := filepath.Join(c.root, execFifoFilename)
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open exec fifo: %w", err)
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+// startCreated starts a container created in CreateModeFull: it releases
+// the process blocked on the exec fifo and records it as running once
+// it has exec'd. The lock keeps the monitor from recording an exit in
+// between. A start that runs out of time kills the process, which the
+// monitor then records as stopped.
+func (c *linuxContainer) startCreated(ctx context.Context) error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if unlock != nil {
+			unlock()
+		}
+	}()
+
+	state, err := c.State()
+	if err != nil {
+		return err
+	}
+	if state.Status != Created {
+		return newTypedError(ErrInvalidState, "cannot start a container in the %s state", state.Status)
+	}
+
+	if err := c.releaseExecFifo(ctx, state.Pid); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			_ = unix.Kill(state.Pid, unix.SIGKILL)
+		}
+		return err
+	}
+	_ = os.Remove(filepath.Join(c.root, execFifoFilename))
+
+	state.Status = Running
+	now := time.Now()
+	state.StartedAt = &now
+	if err := c.saveState(state); err != nil {
+		return fmt.Errorf("failed to save container state after start: %w", err)
+	}
+	unlock()
+	unlock = nil
+
+	c.emit(EventStart, map[string]string{"pid": strconv.Itoa(state.Pid)})
+
+	// A failing poststart hook doesn't stop the container
+	hookState := c.hookState(specs.StateRunning, state.Pid)
+	if err := runHooks(context.Background(), HookPoststart, c.config.Resolved.Hooks[HookPoststart], hookState, c.hookEnv(hookState)); err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: %v\n", err)
+	}
+	return nil
+}
+
+// releaseExecFifo opens the exec fifo, which lets the process pid
+// through to exec, and waits until it has. Opening blocks until the
+// process opens its end, so the process is checked on meanwhile: one
+// that is gone would never open it.
+func (c *linuxContainer) releaseExecFifo(ctx context.Context, pid int) error {
+	path := filepath.Join(c.root, execFifoFilename)
+	type opened struct {
+		f   *os.File
+		err error
+	}
+	result := make(chan opened, 1)
+	go func() {
+		f, err := os.OpenFile(path, os.O_RDONLY, 0)
+		result <- opened{f, err}
+	}()
+	// Giving up leaves the open blocked; a writer of our own unblocks it
+	abandon := func() {
+		if w, err := os.OpenFile(path, os.O_WRONLY|unix.O_NONBLOCK, 0); err == nil {
+			w.Close()
+		}
+		go func() {
+			if o := <-result; o.f != nil {
+				o.f.Close()
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(execFifoPollInterval)
+	defer ticker.Stop()
+	var fifo *os.File
+	for fifo == nil {
+		select {
+		case o := <-result:
+			if o.err != nil {
+				return fmt.Errorf("failed to open exec fifo: %w", o.err)
+			}
+			fifo = o.f
+		case <-ticker.C:
+			if _, err := os.Stat(fmt.Sprintf("/proc/%d", pid)); err != nil {
+				abandon()
+				return fmt.Errorf("container process %d exited before it was started", pid)
+			}
+		case <-ctx.Done():
+			abandon()
+			return &StartError{Phase: PhaseExec, Err: ctx.Err()}
+		}
+	}
+	defer fifo.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = fifo.SetReadDeadline(deadline)
+	}
+	data, err := io.ReadAll(fifo)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return &StartError{Phase: PhaseHooks, Err: context.DeadlineExceeded}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read exec fifo: %w", err)
+	}
+
+	rest, ok := bytes.CutPrefix(data, []byte(execFifoReady))
+	if !ok {
+		return fmt.Errorf("container process %d exited before it was started", pid)
+	}
+	if rest = bytes.TrimSpace(rest); len(rest) == 0 {
+		return nil
+	}
+	var msg syncT
+	if err := json.Unmarshal(rest, &msg); err != nil {
+		return fmt.Errorf("unexpected message on exec fifo: %q", rest)
+	}
+	return msg.startError()
+}
+
+// killCreatedTimeout bounds how long Delete waits for a killed process
+// that was waiting for start to go away.
+const killCreatedTimeout = 5 * time.Second
+
+// killCreated kills a process left waiting for start and waits until
+// its monitor has reaped it.
+func killCreated(pid int) error {
+	if err := unix.Kill(pid, unix.SIGKILL); err != nil && !errors.Is(err, unix.ESRCH) {
+		return fmt.Errorf("failed to kill container process %d: %w", pid, err)
+	}
+	deadline := time.Now().Add(killCreatedTimeout)
+	for {
+		procStat, err := getProcState(pid)
+		if err != nil || procStat.State == 'Z' || procStat.State == 'X' {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("container process %d still there %s after it was killed", pid, killCreatedTimeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitForStart is the container process's end of the exec fifo: it
+// blocks until start opens the fifo, then says it is going ahead. The
+// fifo is returned open and close-on-exec, for a failure to be reported
+// on until the exec closes it.
+func waitForStart(execFifo *os.File) (*os.File, error) {
+	defer execFifo.Close()
+
+	// Until start, a kill is meant for the process this one execs, which
+	// dies of what the Go runtime would ignore, USR1 and the real-time
+	// signals among them
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, catchableTerminatingSignals()...)
+	defer signal.Stop(signals)
+	go func() {
+		sig := <-signals
+		os.Exit(128 + int(sig.(unix.Signal)))
+	}()
+
+	// An O_PATH fd can only be reopened through /proc
+	path := fmt.Sprintf("/proc/self/fd/%d", execFifo.Fd())
+	fifo, err := os.OpenFile(path, os.O_WRONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open exec fifo (full create mode needs /proc in the container): %w", err)
+	}
+	if _, err := fifo.Write([]byte(execFifoReady)); err != nil {
+		fifo.Close()
+		return nil, fmt.Errorf("failed to write exec fifo: %w", err)
+	}
+	return fifo, nil
+}
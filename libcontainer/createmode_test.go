@@ -0,0 +1,132 @@
+package libcontainer
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestCreateExecFifoStale(t *testing.T) {
+	tests := []struct {
+		name string
+		// leave puts what a previous create left at path
+		leave func(t *testing.T, path string)
+		// want is in the error, or the fifo is made if empty
+		want string
+	}{
+		{"nothing left", func(t *testing.T, path string) {}, ""},
+		{
+			name: "a fifo left by a crashed create",
+			leave: func(t *testing.T, path string) {
+				if err := unix.Mkfifo(path, 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "a regular file",
+			leave: func(t *testing.T, path string) {
+				if err := os.WriteFile(path, []byte("0"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: "not one the runtime left",
+		},
+		{
+			name: "a symlink to a fifo",
+			leave: func(t *testing.T, path string) {
+				target := filepath.Join(t.TempDir(), "fifo")
+				if err := unix.Mkfifo(target, 0600); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Symlink(target, path); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: "not one the runtime left",
+		},
+		{
+			name: "a fifo of another user",
+			leave: func(t *testing.T, path string) {
+				if os.Geteuid() != 0 {
+					t.Skip("needs root to give the fifo away")
+				}
+				if err := unix.Mkfifo(path, 0600); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Lchown(path, 65534, 65534); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: "not one the runtime left",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &linuxContainer{id: "test", root: t.TempDir()}
+			path := filepath.Join(c.root, execFifoFilename)
+			tt.leave(t, path)
+			// Held open, what was there keeps its inode number
+			var before unix.Stat_t
+			beforeErr := unix.Lstat(path, &before)
+			if fd, err := unix.Open(path, unix.O_PATH|unix.O_NOFOLLOW, 0); err == nil {
+				defer unix.Close(fd)
+			}
+
+			err := c.createExecFifo()
+			if tt.want != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.want) {
+					t.Fatalf("got %v, want %q", err, tt.want)
+				}
+				var after unix.Stat_t
+				if err := unix.Lstat(path, &after); err != nil || after.Ino != before.Ino {
+					t.Error("removed what wasn't the runtime's")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var st unix.Stat_t
+			if err := unix.Lstat(path, &st); err != nil || !ownExecFifo(&st) {
+				t.Fatalf("no exec fifo made: %v", err)
+			}
+			if beforeErr == nil && st.Ino == before.Ino {
+				t.Error("the stale fifo was reused")
+			}
+		})
+	}
+}
+
+func TestReleaseExecFifoDeadInit(t *testing.T) {
+	c := &linuxContainer{id: "test", root: t.TempDir()}
+	if err := c.createExecFifo(); err != nil {
+		t.Fatal(err)
+	}
+
+	// An init that died before start never opens its end
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- c.releaseExecFifo(t.Context(), cmd.Process.Pid) }()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "exited before it was started") {
+			t.Fatalf("got %v, want the init reported gone", err)
+		}
+	case <-time.After(10 * execFifoPollInterval):
+		t.Fatal("start waited on an init that is gone")
+	}
+
+	// Another create over what that left behind gets a fifo of its own
+	if err := c.createExecFifo(); err != nil {
+		t.Fatal(err)
+	}
+}
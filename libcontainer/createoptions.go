@@ -0,0 +1,156 @@
+package libcontainer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/zakarynichols/hackontainer/api/types"
+	"github.com/zakarynichols/hackontainer/version"
+)
+
+// CreateOptions is how a container was created.
+type CreateOptions = types.CreateOptions
+
+// createOptionsFilename records how the container was created.
+const createOptionsFilename = "create-options.json"
+
+// redactedValue replaces the values of env overrides in CreateOptions.
+const redactedValue = "<redacted>"
+
+// WithCommandLine records the command line a container is created from.
+// Arguments carrying an env override have the value redacted, like the
+// overrides themselves.
+func WithCommandLine(args []string) CreateOption {
+	return func(l *LinuxFactory) error {
+		l.commandLine = append([]string(nil), args...)
+		return nil
+	}
+}
+
+// WithEnvValuesRecorded keeps the values of env overrides in the record
+// of how a container was created. They may be secrets, so by default
+// only the names are kept.
+func WithEnvValuesRecorded() CreateOption {
+	return func(l *LinuxFactory) error {
+		l.envValuesRecorded = true
+		return nil
+	}
+}
+
+// createOptions describes a create with the factory's options, of the
+// config at configPath in bundle.
+func (l *LinuxFactory) createOptions(bundle, configPath string) (*CreateOptions, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+
+	opts := &CreateOptions{
+		RuntimeVersion:  version.Version,
+		CommandLine:     l.redactEnv(l.commandLine),
+		Bundle:          bundle,
+		ConfigPath:      configPath,
+		ConfigSHA256:    hex.EncodeToString(sum[:]),
+		CloneOf:         l.cloneSource,
+		Namespace:       l.namespace,
+		Args:            l.processArgs,
+		ReplaceArgs:     l.replaceArgs,
+		Env:             l.redactEnv(l.env),
+		EnvFiles:        l.envFiles,
+		SensitiveEnv:    l.sensitiveEnv,
+		Cwd:             l.cwd,
+		Annotations:     l.annotations,
+		Labels:          l.labels,
+		CgroupParent:    l.cgroupParent,
+		CgroupPolicy:    string(l.cgroupPolicy),
+		SystemdCgroup:   l.systemdCgroup,
+		Rootless:        string(l.rootlessMode),
+		CreateMode:      l.createMode,
+		RestartPolicy:   l.restartPolicy,
+		MaxRuntime:      l.maxRuntime,
+		RootfsSizeBytes: l.rootfsSize,
+		RootfsFD:        l.rootfsFD >= 0,
+		Security:        l.security.String(),
+		OwnerFixupAllow: l.ownerFixupAllow,
+		StrictSpec:      l.configOptions.Strict,
+		NoPivotRoot:     l.noPivotRoot,
+		ConsoleSocket:   l.consoleSocket,
+	}
+	if l.user != nil {
+		opts.User = fmt.Sprint(l.user.uid)
+		if l.user.gid != nil {
+			opts.User += fmt.Sprintf(":%d", *l.user.gid)
+		}
+	}
+	return opts, nil
+}
+
+// redactEnv replaces the value of every env override found in args, on
+// its own or as the value of a flag, unless values are to be recorded.
+// Sensitive values are replaced regardless.
+func (l *LinuxFactory) redactEnv(args []string) []string {
+	if (l.envValuesRecorded && len(l.sensitiveEnv) == 0) || len(args) == 0 {
+		return args
+	}
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		redacted[i] = arg
+		for _, kv := range l.env {
+			if arg != kv && !strings.HasSuffix(arg, "="+kv) {
+				continue
+			}
+			name, _, _ := strings.Cut(kv, "=")
+			if l.envValuesRecorded && !slices.Contains(l.sensitiveEnv, name) {
+				continue
+			}
+			redacted[i] = strings.TrimSuffix(arg, kv) + name + "=" + redactedValue
+			break
+		}
+	}
+	return redacted
+}
+
+// saveCreateOptions writes opts to the container root.
+func (c *linuxContainer) saveCreateOptions(opts *CreateOptions) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	// Keep redacted values readable
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(opts); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(c.root, createOptionsFilename), buf.Bytes(), 0600)
+}
+
+// loadCreateOptions reads how the container was created. Containers
+// created before it was recorded have no record.
+func (c *linuxContainer) loadCreateOptions() (*CreateOptions, error) {
+	data, err := os.ReadFile(filepath.Join(c.root, createOptionsFilename))
+	if err != nil {
+		return nil, err
+	}
+	var opts CreateOptions
+	if err := json.Unmarshal(data, &opts); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", createOptionsFilename, err)
+	}
+	return &opts, nil
+}
+
+// warnVersionMismatch warns when the container was created by another
+// version of the runtime, which may have set it up differently.
+func (c *linuxContainer) warnVersionMismatch() {
+	opts, err := c.loadCreateOptions()
+	if err != nil || opts.RuntimeVersion == version.Version {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "WARNING: container %s was created by hackontainer %s but is started by %s\n", c.id, opts.RuntimeVersion, version.Version)
+}
@@ -0,0 +1,190 @@
+package libcontainer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DelegateRuntimeAnnotation names another OCI runtime, such as runsc, to
+// run a bundle's container instead of the runtime itself.
+const DelegateRuntimeAnnotation = "org.hackontainer.delegate-runtime"
+
+// delegateFilename records, in place of state.json, that another runtime
+// runs the container. That runtime keeps its own state in the
+// delegateRootDir of the container root, so deleting the container root
+// removes it too.
+const (
+	delegateFilename = "delegate.json"
+	delegateRootDir  = "delegate"
+)
+
+// Delegation is a container run by another OCI runtime. The runtime only
+// keeps the record; the delegate's own commands create, start, signal,
+// delete and report on the container, run against Root.
+type Delegation struct {
+	ID      string            `json:"id"`
+	Runtime string            `json:"runtime"`
+	Root    string            `json:"root"`
+	Bundle  string            `json:"bundle"`
+	Created time.Time         `json:"created"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// BundleDelegateRuntime returns the runtime the config at configPath
+// delegates its container to, or "" if it doesn't.
+func BundleDelegateRuntime(configPath string) (string, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return "", err
+	}
+	var spec struct {
+		Annotations map[string]string `json:"annotations"`
+	}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return "", newTypedError(ErrInvalidConfig, "failed to parse %s: %w", configPath, err)
+	}
+	return spec.Annotations[DelegateRuntimeAnnotation], nil
+}
+
+// Delegate records that runtime runs container id from bundle. A runtime
+// without a slash is looked up in PATH, and its path is recorded so every
+// later command reaches the same binary. Of the create options only the
+// labels apply. The record is made before the delegate's create runs, so
+// the ID is taken while it does; Undelegate gives it back if that fails.
+func (l *LinuxFactory) Delegate(id, bundle, runtime string, options ...CreateOption) (*Delegation, error) {
+	f := *l
+	for _, opt := range options {
+		if err := opt(&f); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateID(id); err != nil {
+		return nil, err
+	}
+	if err := f.validateLabels(); err != nil {
+		return nil, newTypedError(ErrInvalidConfig, "%w", err)
+	}
+	runtimePath, err := exec.LookPath(runtime)
+	if err != nil {
+		return nil, newTypedError(ErrInvalidConfig, "delegate runtime %q: %w", runtime, err)
+	}
+	if runtimePath, err = filepath.Abs(runtimePath); err != nil {
+		return nil, err
+	}
+	absBundle, err := filepath.Abs(bundle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path for bundle: %w", err)
+	}
+
+	containerRoot := filepath.Join(f.stateRoot(), id)
+	if err := os.Mkdir(containerRoot, 0711); err != nil {
+		if os.IsExist(err) {
+			return nil, newTypedError(ErrExist, "container id '%s' already exists in directory %s", id, containerRoot)
+		}
+		return nil, err
+	}
+	d := &Delegation{
+		ID:      id,
+		Runtime: runtimePath,
+		Root:    filepath.Join(containerRoot, delegateRootDir),
+		Bundle:  absBundle,
+		Created: time.Now().UTC(),
+		Labels:  f.labels,
+	}
+	if err := d.save(containerRoot); err != nil {
+		os.RemoveAll(containerRoot)
+		return nil, err
+	}
+	return d, nil
+}
+
+// save writes the record, along with the layout file every container
+// root gets first.
+func (d *Delegation) save(containerRoot string) error {
+	if err := writeLayout(containerRoot, len(containerMigrations)); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(d.Root, 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(containerRoot, delegateFilename), data, 0600)
+}
+
+// Delegation returns the record of container id if another runtime runs
+// it, and nil if the runtime runs it itself or there is no such
+// container.
+func (l *LinuxFactory) Delegation(id string) (*Delegation, error) {
+	if err := validateID(id); err != nil {
+		return nil, err
+	}
+	return loadDelegation(filepath.Join(l.stateRoot(), id))
+}
+
+// loadDelegation reads the record in containerRoot, if there is one.
+func loadDelegation(containerRoot string) (*Delegation, error) {
+	data, err := os.ReadFile(filepath.Join(containerRoot, delegateFilename))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var d Delegation
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", delegateFilename, err)
+	}
+	return &d, nil
+}
+
+// Undelegate removes the record of container id, and with it whatever
+// state the delegate kept. Call it once the delegate has deleted the
+// container, or failed to create it.
+func (l *LinuxFactory) Undelegate(id string) error {
+	if err := validateID(id); err != nil {
+		return err
+	}
+	containerRoot := filepath.Join(l.stateRoot(), id)
+	if !fileExists(filepath.Join(containerRoot, delegateFilename)) {
+		return newTypedError(ErrNotExist, "container %q is not delegated", id)
+	}
+	return os.RemoveAll(containerRoot)
+}
+
+// Command returns the delegate runtime run with args against the
+// delegation's root.
+func (d *Delegation) Command(args ...string) *exec.Cmd {
+	return exec.Command(d.Runtime, append([]string{"--root", d.Root}, args...)...)
+}
+
+// State asks the delegate for the container's state. The OCI fields are
+// kept, along with the creation time runtimes such as runc add; Created
+// falls back to the time of the delegation.
+func (d *Delegation) State() (*State, error) {
+	cmd := d.Command("state", d.ID)
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("%s state: %w: %s", d.Runtime, err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("%s state: %w", d.Runtime, err)
+	}
+	var state State
+	if err := json.Unmarshal(out, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse the state %s printed: %w", d.Runtime, err)
+	}
+	if state.Created.IsZero() {
+		state.Created = d.Created
+	}
+	return &state, nil
+}
@@ -0,0 +1,220 @@
+package libcontainer
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"unsafe"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// cgroup v2 has no devices.allow; device access is decided by an eBPF
+// program of type BPF_PROG_TYPE_CGROUP_DEVICE attached to the cgroup.
+// The program below is generated from the rules and checks them last to
+// first, so a later rule overrides an earlier one as with the v1 files.
+// Access not matched by any rule is denied.
+
+// bpfInsn is struct bpf_insn.
+type bpfInsn struct {
+	code uint8
+	regs uint8 // dst in the low nibble, src in the high one
+	off  int16
+	imm  int32
+}
+
+const (
+	bpfJmp = 0x05
+	bpfK   = 0x00
+	bpfMem = 0x60
+	bpfW   = 0x00
+
+	ldxW    = unix.BPF_LDX | bpfW | bpfMem
+	and64K  = unix.BPF_ALU64 | unix.BPF_AND | bpfK
+	rsh64K  = unix.BPF_ALU64 | unix.BPF_RSH | bpfK
+	mov64K  = unix.BPF_ALU64 | unix.BPF_MOV | bpfK
+	mov64X  = unix.BPF_ALU64 | unix.BPF_MOV | 0x08
+	jneK    = bpfJmp | unix.BPF_JNE | bpfK
+	exitIns = bpfJmp | unix.BPF_EXIT
+)
+
+func insn(code uint8, dst, src uint8, off int16, imm int32) bpfInsn {
+	return bpfInsn{code: code, regs: dst | src<<4, off: off, imm: imm}
+}
+
+// deviceFilterProgram compiles rules into an eBPF program. The context
+// is struct bpf_cgroup_dev_ctx {access_type, major, minor}, with the
+// access in the high 16 bits of access_type and the device type in the
+// low ones.
+func deviceFilterProgram(rules []specs.LinuxDeviceCgroup) ([]bpfInsn, error) {
+	prog := []bpfInsn{
+		insn(ldxW, 2, 1, 0, 0), // r2 = type
+		insn(and64K, 2, 0, 0, 0xffff),
+		insn(ldxW, 3, 1, 0, 0), // r3 = access
+		insn(rsh64K, 3, 0, 0, 16),
+		insn(ldxW, 4, 1, 4, 0), // r4 = major
+		insn(ldxW, 5, 1, 8, 0), // r5 = minor
+	}
+
+	for i := len(rules) - 1; i >= 0; i-- {
+		block, matchesAll, err := deviceRuleBlock(rules[i])
+		if err != nil {
+			return nil, err
+		}
+		prog = append(prog, block...)
+		// Nothing after a catch-all rule is reachable, and the verifier
+		// rejects unreachable instructions
+		if matchesAll {
+			return prog, nil
+		}
+	}
+
+	return append(prog, insn(mov64K, 0, 0, 0, 0), insn(exitIns, 0, 0, 0, 0)), nil
+}
+
+// deviceRuleBlock returns the checks of one rule, each jumping past the
+// end of the block on a mismatch, and whether the rule has no checks.
+func deviceRuleBlock(rule specs.LinuxDeviceCgroup) ([]bpfInsn, bool, error) {
+	var checks [][]bpfInsn
+
+	switch rule.Type {
+	case "", "a":
+	case "c":
+		checks = append(checks, []bpfInsn{insn(jneK, 2, 0, 0, unix.BPF_DEVCG_DEV_CHAR)})
+	case "b":
+		checks = append(checks, []bpfInsn{insn(jneK, 2, 0, 0, unix.BPF_DEVCG_DEV_BLOCK)})
+	default:
+		return nil, false, fmt.Errorf("invalid device rule type %q", rule.Type)
+	}
+
+	access := int32(0)
+	for _, c := range rule.Access {
+		switch c {
+		case 'r':
+			access |= unix.BPF_DEVCG_ACC_READ
+		case 'w':
+			access |= unix.BPF_DEVCG_ACC_WRITE
+		case 'm':
+			access |= unix.BPF_DEVCG_ACC_MKNOD
+		default:
+			return nil, false, fmt.Errorf("invalid device rule access %q", rule.Access)
+		}
+	}
+	if rule.Access == "" {
+		access = unix.BPF_DEVCG_ACC_READ | unix.BPF_DEVCG_ACC_WRITE | unix.BPF_DEVCG_ACC_MKNOD
+	}
+	if access != unix.BPF_DEVCG_ACC_READ|unix.BPF_DEVCG_ACC_WRITE|unix.BPF_DEVCG_ACC_MKNOD {
+		// Match only if no requested access bit is outside the rule's
+		checks = append(checks, []bpfInsn{
+			insn(mov64X, 1, 3, 0, 0),
+			insn(and64K, 1, 0, 0, ^access),
+			insn(jneK, 1, 0, 0, 0),
+		})
+	}
+
+	if rule.Major != nil && *rule.Major >= 0 {
+		checks = append(checks, []bpfInsn{insn(jneK, 4, 0, 0, int32(*rule.Major))})
+	}
+	if rule.Minor != nil && *rule.Minor >= 0 {
+		checks = append(checks, []bpfInsn{insn(jneK, 5, 0, 0, int32(*rule.Minor))})
+	}
+
+	allow := int32(0)
+	if rule.Allow {
+		allow = 1
+	}
+	verdict := []bpfInsn{insn(mov64K, 0, 0, 0, allow), insn(exitIns, 0, 0, 0, 0)}
+
+	length := len(verdict)
+	for _, c := range checks {
+		length += len(c)
+	}
+
+	var block []bpfInsn
+	for _, c := range checks {
+		for _, ins := range c {
+			block = append(block, ins)
+			if ins.code == jneK {
+				block[len(block)-1].off = int16(length - len(block))
+			}
+		}
+	}
+	return append(block, verdict...), len(checks) == 0, nil
+}
+
+// bpfProgLoadAttr is the BPF_PROG_LOAD part of union bpf_attr.
+type bpfProgLoadAttr struct {
+	progType    uint32
+	insnCnt     uint32
+	insns       uint64
+	license     uint64
+	logLevel    uint32
+	logSize     uint32
+	logBuf      uint64
+	kernVersion uint32
+	progFlags   uint32
+}
+
+// bpfProgAttachAttr is the BPF_PROG_ATTACH part of union bpf_attr.
+type bpfProgAttachAttr struct {
+	targetFd    uint32
+	attachBpfFd uint32
+	attachType  uint32
+	attachFlags uint32
+}
+
+// attachDeviceFilter loads the program for rules and attaches it to the
+// cgroup directory.
+func attachDeviceFilter(cgroupPath string, rules []specs.LinuxDeviceCgroup) error {
+	prog, err := deviceFilterProgram(rules)
+	if err != nil {
+		return err
+	}
+
+	license := []byte("Apache\x00")
+	logBuf := make([]byte, 4096)
+	load := bpfProgLoadAttr{
+		progType: unix.BPF_PROG_TYPE_CGROUP_DEVICE,
+		insnCnt:  uint32(len(prog)),
+		insns:    uint64(uintptr(unsafe.Pointer(&prog[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+		logLevel: 1,
+		logSize:  uint32(len(logBuf)),
+		logBuf:   uint64(uintptr(unsafe.Pointer(&logBuf[0]))),
+	}
+	progFd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_LOAD, uintptr(unsafe.Pointer(&load)), unsafe.Sizeof(load))
+	runtime.KeepAlive(prog)
+	runtime.KeepAlive(license)
+	if errno != 0 {
+		return fmt.Errorf("failed to load device filter: %w: %s", errno, string(logBuf[:clen(logBuf)]))
+	}
+	defer unix.Close(int(progFd))
+
+	dir, err := os.Open(cgroupPath)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	attach := bpfProgAttachAttr{
+		targetFd:    uint32(dir.Fd()),
+		attachBpfFd: uint32(progFd),
+		attachType:  unix.BPF_CGROUP_DEVICE,
+		attachFlags: unix.BPF_F_ALLOW_MULTI,
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_ATTACH, uintptr(unsafe.Pointer(&attach)), unsafe.Sizeof(attach)); errno != 0 {
+		return fmt.Errorf("failed to attach device filter: %w", errno)
+	}
+	return nil
+}
+
+// clen returns the length of a NUL-terminated byte string.
+func clen(b []byte) int {
+	for i, c := range b {
+		if c == 0 {
+			return i
+		}
+	}
+	return len(b)
+}
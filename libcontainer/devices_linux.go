@@ -0,0 +1,186 @@
+package libcontainer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/zakarynichols/hackontainer/config"
+	"github.com/zakarynichols/hackontainer/libcontainer/rootfsfile"
+	"golang.org/x/sys/unix"
+)
+
+// wellKnownDevice describes a device the runtime fills in and checks for
+// the spec, since it only works with the node, the cgroup rule and a
+// capability all in place.
+type wellKnownDevice struct {
+	major, minor int64
+	capability   string
+	purpose      string
+}
+
+var wellKnownDevices = map[string]wellKnownDevice{
+	"/dev/fuse":    {10, 229, "CAP_SYS_ADMIN", "mounting FUSE filesystems"},
+	"/dev/net/tun": {10, 200, "CAP_NET_ADMIN", "configuring tun/tap interfaces"},
+}
+
+// normalizeDevices fills in type and numbers the spec left out for
+// well-known devices.
+func normalizeDevices(spec *specs.Spec) {
+	if spec == nil || spec.Linux == nil {
+		return
+	}
+	for i := range spec.Linux.Devices {
+		dev := &spec.Linux.Devices[i]
+		known, ok := wellKnownDevices[filepath.Clean(dev.Path)]
+		if !ok {
+			continue
+		}
+		if dev.Type == "" {
+			dev.Type = "c"
+		}
+		if dev.Major == 0 && dev.Minor == 0 {
+			dev.Major, dev.Minor = known.major, known.minor
+		}
+	}
+}
+
+// validateDevices rejects device entries that can't be created.
+func validateDevices(spec *specs.Spec) error {
+	if spec == nil || spec.Linux == nil {
+		return nil
+	}
+	for _, dev := range spec.Linux.Devices {
+		if !filepath.IsAbs(dev.Path) || !strings.HasPrefix(filepath.Clean(dev.Path), "/dev/") {
+			return fmt.Errorf("device path must be under /dev: %q", dev.Path)
+		}
+		switch dev.Type {
+		case "c", "u", "b", "p":
+		default:
+			return fmt.Errorf("device %s: invalid type %q", dev.Path, dev.Type)
+		}
+	}
+	return nil
+}
+
+// deviceWarnings reports well-known devices the process lacks the
+// capability to use.
+func deviceWarnings(spec *specs.Spec) []string {
+	if spec == nil || spec.Linux == nil || spec.Process == nil || spec.Process.Capabilities == nil {
+		return nil
+	}
+	caps := spec.Process.Capabilities
+
+	var warnings []string
+	for _, dev := range spec.Linux.Devices {
+		known, ok := wellKnownDevices[filepath.Clean(dev.Path)]
+		if !ok {
+			continue
+		}
+		if !hasCapability(caps.Effective, known.capability) || !hasCapability(caps.Bounding, known.capability) {
+			warnings = append(warnings, fmt.Sprintf("%s is configured but process.capabilities lacks %s, which %s needs", dev.Path, known.capability, known.purpose))
+		}
+	}
+	return warnings
+}
+
+func hasCapability(caps []string, want string) bool {
+	for _, c := range caps {
+		if c == want {
+			return true
+		}
+	}
+	return false
+}
+
+// createDevices creates the resolved device nodes inside root. Inside a
+// user namespace mknod isn't permitted, so the host's node is bind
+// mounted instead.
+func createDevices(s sysCalls, root rootfsfile.FS, resolved *config.Resolved, userns bool) error {
+	if resolved.DevTmpfs {
+		// Nodes never go into the rootfs on disk, which other containers
+		// from the same bundle share
+		if err := mkdirAllIn(root, "/dev"); err != nil {
+			return err
+		}
+		if err := mountIn(s, root, "/dev", "tmpfs", "tmpfs", unix.MS_NOSUID|unix.MS_STRICTATIME, "mode=755,size=65536k"); err != nil {
+			return fmt.Errorf("failed to mount /dev: %w", err)
+		}
+	}
+
+	for _, dev := range resolved.Devices {
+		path := filepath.Clean(dev.Path)
+		if err := mkdirAllIn(root, filepath.Dir(path)); err != nil {
+			return err
+		}
+
+		if userns {
+			if err := createMountpoint(root, path, false); err != nil {
+				return err
+			}
+			if err := mountIn(s, root, path, dev.Path, "bind", unix.MS_BIND, ""); err != nil {
+				return fmt.Errorf("failed to bind device %s: %w", dev.Path, err)
+			}
+			continue
+		}
+
+		if err := mknodDevice(root, path, dev); err != nil {
+			return fmt.Errorf("failed to create device %s: %w", dev.Path, err)
+		}
+	}
+
+	if resolved.PrivateDev {
+		if err := createDevSymlinks(root); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mknodDevice(root rootfsfile.FS, path string, dev specs.LinuxDevice) error {
+	mode := uint32(0666)
+	if dev.FileMode != nil {
+		mode = uint32(dev.FileMode.Perm())
+	}
+	switch dev.Type {
+	case "c", "u":
+		mode |= unix.S_IFCHR
+	case "b":
+		mode |= unix.S_IFBLK
+	case "p":
+		mode |= unix.S_IFIFO
+	}
+
+	// An existing node (from the rootfs image) is replaced
+	if err := root.Mknod(path, mode, int(unix.Mkdev(uint32(dev.Major), uint32(dev.Minor)))); err != nil {
+		return err
+	}
+
+	uid, gid := 0, 0
+	if dev.UID != nil {
+		uid = int(*dev.UID)
+	}
+	if dev.GID != nil {
+		gid = int(*dev.GID)
+	}
+	return root.Lchown(path, uid, gid)
+}
+
+// createDevSymlinks adds the links a mounted /dev is expected to have.
+func createDevSymlinks(root rootfsfile.FS) error {
+	links := [][2]string{
+		{"/proc/self/fd", "/dev/fd"},
+		{"/proc/self/fd/0", "/dev/stdin"},
+		{"/proc/self/fd/1", "/dev/stdout"},
+		{"/proc/self/fd/2", "/dev/stderr"},
+		{"pts/ptmx", "/dev/ptmx"},
+	}
+	for _, link := range links {
+		if err := root.Symlink(link[0], link[1]); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("failed to create %s symlink: %w", link[1], err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package libcontainer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// internalEnvPrefix marks the variables the runtime reads from its own
+// environment. They are reserved and never reach a container, even when
+// a spec sets them.
+const internalEnvPrefix = "HACKONTAINER_"
+
+// containerEnv returns the environment of the container process: the
+// spec's env in spec order and nothing from the runtime's own
+// environment. Empty values are kept as they are. When a name is set
+// more than once, the last value wins at the place of the first. The
+// result is never nil, which exec.Cmd would take as the runtime's own
+// environment.
+func containerEnv(env []string) []string {
+	result := make([]string, 0, len(env))
+	index := make(map[string]int, len(env))
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(name, internalEnvPrefix) {
+			continue
+		}
+		if i, ok := index[name]; ok {
+			result[i] = kv
+			continue
+		}
+		index[name] = len(result)
+		result = append(result, kv)
+	}
+	return result
+}
+
+// internalEnv returns the reserved variables set in the runtime's own
+// environment. The init stage reads some of them itself, so they are
+// passed to it next to the container's environment, which it execs the
+// container process with.
+func internalEnv() []string {
+	var result []string
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, internalEnvPrefix) {
+			result = append(result, kv)
+		}
+	}
+	return result
+}
+
+// mergeEnv sets the variables of overrides in env. Every definition of
+// a name env already has takes the new value; other names are appended.
+func mergeEnv(env, overrides []string) []string {
+	result := append([]string(nil), env...)
+	for _, kv := range overrides {
+		name, _, _ := strings.Cut(kv, "=")
+		found := false
+		for i, existing := range result {
+			if k, _, _ := strings.Cut(existing, "="); k == name {
+				result[i] = kv
+				found = true
+			}
+		}
+		if !found {
+			result = append(result, kv)
+		}
+	}
+	return result
+}
+
+// lookupEnv returns the value of name in env.
+func lookupEnv(env []string, name string) (string, bool) {
+	for _, kv := range env {
+		if k, v, _ := strings.Cut(kv, "="); k == name {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// lookPath searches the container's PATH for file, leaving the
+// runtime's own environment alone.
+func lookPath(file, path string) (string, error) {
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			dir = "."
+		}
+		candidate := filepath.Join(dir, file)
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+			return candidate, nil
+		}
+	}
+	return "", os.ErrNotExist
+}
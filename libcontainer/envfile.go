@@ -0,0 +1,232 @@
+package libcontainer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/zakarynichols/hackontainer/config"
+)
+
+// sensitiveEnvFilename holds the values of sensitive variables, which
+// the frozen config only has redacted. Only start reads it.
+const sensitiveEnvFilename = "sensitive-env.json"
+
+// sensitiveEnvVar names the sensitive variables for the init stage,
+// which takes their values from its own environment instead of the
+// frozen config.
+const sensitiveEnvVar = internalEnvPrefix + "SENSITIVE_ENV"
+
+// WithEnvFile sets the variables of an env file in process.env, as
+// WithEnv does. The file is read when the option is applied. Each line
+// is KEY=VALUE, optionally preceded by "export"; blank lines and lines
+// starting with # are skipped. A value may be in single quotes, taken
+// literally, or in double quotes, where \n, \t, \", \\ and \$ are
+// escapes. An unquoted value runs to the end of the line, less the
+// trailing blanks.
+func WithEnvFile(path string) CreateOption {
+	return func(l *LinuxFactory) error {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(absPath)
+		if err != nil {
+			return fmt.Errorf("failed to open env file: %w", err)
+		}
+		defer f.Close()
+		env, err := parseEnvFile(f, path)
+		if err != nil {
+			return err
+		}
+		l.env = append(append([]string(nil), l.env...), env...)
+		l.envFiles = append(l.envFiles, absPath)
+		return nil
+	}
+}
+
+// WithSensitiveEnv marks variables of the process env as sensitive.
+// Their values are kept out of the frozen config, and so out of inspect
+// and everything else that shows it, and out of the record of how the
+// container was created even with WithEnvValuesRecorded. They are
+// stored in a file only root can read, which start passes them on from.
+func WithSensitiveEnv(keys ...string) CreateOption {
+	return func(l *LinuxFactory) error {
+		for _, key := range keys {
+			if !validEnvKey(key) {
+				return fmt.Errorf("invalid sensitive environment variable name %q", key)
+			}
+		}
+		l.sensitiveEnv = append(append([]string(nil), l.sensitiveEnv...), keys...)
+		return nil
+	}
+}
+
+func validEnvKey(key string) bool {
+	return key != "" && !strings.ContainsAny(key, "=") && strings.IndexFunc(key, unicode.IsSpace) < 0
+}
+
+// parseEnvFile parses the lines of an env file, named name in errors.
+func parseEnvFile(r io.Reader, name string) ([]string, error) {
+	var env []string
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !validEnvKey(key) {
+			return nil, fmt.Errorf("%s:%d: want KEY=VALUE", name, lineNo)
+		}
+		value, err := parseEnvValue(strings.TrimLeftFunc(value, unicode.IsSpace))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %w", name, lineNo, key, err)
+		}
+		env = append(env, key+"="+value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return env, nil
+}
+
+// parseEnvValue unquotes the value of an env file line.
+func parseEnvValue(raw string) (string, error) {
+	if raw == "" || (raw[0] != '"' && raw[0] != '\'') {
+		return strings.TrimRightFunc(raw, unicode.IsSpace), nil
+	}
+
+	quote := raw[0]
+	var value strings.Builder
+	for i := 1; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case c == quote:
+			if rest := strings.TrimSpace(raw[i+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+				return "", fmt.Errorf("unexpected %q after the closing quote", rest)
+			}
+			return value.String(), nil
+		case c == '\\' && quote == '"' && i+1 < len(raw):
+			i++
+			switch raw[i] {
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			case '"', '\\', '$':
+				value.WriteByte(raw[i])
+			default:
+				value.WriteByte('\\')
+				value.WriteByte(raw[i])
+			}
+		default:
+			value.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("missing closing %c", quote)
+}
+
+// redactSensitiveEnv replaces the values of the factory's sensitive
+// variables in cfg with redactedValue, and returns them as they were.
+func (l *LinuxFactory) redactSensitiveEnv(cfg *config.Config) ([]string, error) {
+	if len(l.sensitiveEnv) == 0 {
+		return nil, nil
+	}
+	var env []string
+	if cfg.Process != nil {
+		env = cfg.Process.Env
+	}
+	effective := containerEnv(env)
+
+	var values []string
+	for _, key := range l.sensitiveEnv {
+		value, ok := lookupEnv(effective, key)
+		if !ok {
+			return nil, newTypedError(ErrInvalidConfig, "sensitive environment variable %s is not set", key)
+		}
+		values = append(values, key+"="+value)
+	}
+	cfg.Process.Env = mergeEnv(env, l.redactedSensitiveEnv())
+	return values, nil
+}
+
+// redactedSensitiveEnv sets every sensitive variable to redactedValue.
+func (l *LinuxFactory) redactedSensitiveEnv() []string {
+	redacted := make([]string, len(l.sensitiveEnv))
+	for i, key := range l.sensitiveEnv {
+		redacted[i] = key + "=" + redactedValue
+	}
+	return redacted
+}
+
+// saveSensitiveEnv writes the values of the sensitive variables to the
+// container root, readable by root alone.
+func (c *linuxContainer) saveSensitiveEnv(env []string) error {
+	if len(env) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(c.root, sensitiveEnvFilename), data, 0600)
+}
+
+// loadSensitiveEnv reads the values saveSensitiveEnv wrote. A container
+// without sensitive variables has none.
+func (c *linuxContainer) loadSensitiveEnv() ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(c.root, sensitiveEnvFilename))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var env []string
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", sensitiveEnvFilename, err)
+	}
+	return env, nil
+}
+
+// redactSensitive replaces the values of the container's sensitive
+// variables in s, for text the runtime writes to its logs and events,
+// such as an error from the container process.
+func (c *linuxContainer) redactSensitive(s string) string {
+	env, _ := c.loadSensitiveEnv()
+	for _, kv := range env {
+		if _, value, _ := strings.Cut(kv, "="); value != "" {
+			s = strings.ReplaceAll(s, value, redactedValue)
+		}
+	}
+	return s
+}
+
+// sensitiveEnvNames lists the names of env as sensitiveEnvVar's value.
+func sensitiveEnvNames(env []string) string {
+	names := make([]string, len(env))
+	for i, kv := range env {
+		names[i], _, _ = strings.Cut(kv, "=")
+	}
+	return strings.Join(names, ",")
+}
+
+// inheritedSensitiveEnv returns the sensitive variables the parent
+// passed to the init stage in its environment.
+func inheritedSensitiveEnv() []string {
+	var env []string
+	for _, name := range strings.Split(os.Getenv(sensitiveEnvVar), ",") {
+		if value, ok := os.LookupEnv(name); ok && name != "" {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
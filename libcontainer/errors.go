@@ -0,0 +1,180 @@
+package libcontainer
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error kinds returned by factories and containers. Callers match them
+// with errors.Is; the message carries the specifics.
+var (
+	ErrNotExist      = errors.New("container does not exist")
+	ErrExist         = errors.New("container already exists")
+	ErrInvalidID     = errors.New("invalid container id")
+	ErrInvalidConfig = errors.New("invalid container config")
+	ErrNotRunning    = errors.New("container not running")
+	ErrRunning       = errors.New("container is running")
+	ErrInvalidState  = errors.New("operation not valid in the container's current state")
+
+	// ErrNamespaceMismatch means the live container process isn't in the
+	// namespaces its config describes, so it can't be trusted to be the
+	// container at all.
+	ErrNamespaceMismatch = errors.New("container process namespaces do not match its config")
+
+	// ErrHooksDisabled means the config requests hooks but the factory
+	// was told never to run any.
+	ErrHooksDisabled = errors.New("hooks are disabled")
+
+	// ErrMissingKernelFeatures means the host kernel lacks, or has
+	// switched off, something the config needs, such as a namespace type.
+	ErrMissingKernelFeatures = errors.New("missing kernel features")
+
+	// ErrMissingPrivileges means the runtime was told not to run rootless
+	// but lacks privileges that requires.
+	ErrMissingPrivileges = errors.New("missing privileges")
+
+	// ErrUnsupportedLayout means a newer runtime left state on disk in a
+	// layout this one doesn't understand.
+	ErrUnsupportedLayout = errors.New("unsupported state layout")
+
+	// ErrCriuNotFound means checkpoint or restore found no criu binary to
+	// run.
+	ErrCriuNotFound = errors.New("criu not found")
+
+	// ErrSharedRoot means the root is on a network filesystem the
+	// factory wasn't told it may share with other hosts.
+	ErrSharedRoot = errors.New("root is on a shared filesystem")
+
+	// ErrNotOwner means the container is on a shared root and owned by
+	// another host, whose processes this one can't act on.
+	ErrNotOwner = errors.New("container is owned by another host")
+
+	// ErrLocksUnsupported means a container, or the root, is locked with
+	// flock on a filesystem that doesn't support it.
+	ErrLocksUnsupported = errors.New("filesystem does not support locks")
+
+	// ErrConfigModified means the config frozen at create was changed by
+	// something other than the runtime.
+	ErrConfigModified = errors.New("frozen config was modified")
+
+	// ErrArchMismatch means the container process is a binary for an
+	// architecture the host can't run.
+	ErrArchMismatch = errors.New("rootfs is for another architecture")
+)
+
+// typedError keeps a specific message while matching one of the error
+// kinds above.
+type typedError struct {
+	kind error
+	err  error
+}
+
+func newTypedError(kind error, format string, args ...interface{}) error {
+	return &typedError{kind: kind, err: fmt.Errorf(format, args...)}
+}
+
+func (e *typedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *typedError) Unwrap() error {
+	return e.err
+}
+
+func (e *typedError) Is(target error) bool {
+	return target == e.kind
+}
+
+// Phase names a step of starting the container process.
+type Phase string
+
+// Start phases, in the order a start goes through them. PhaseInit covers
+// the runtime's own plumbing: the init stage loading its config and
+// talking to the parent.
+const (
+	PhaseClone      Phase = "clone"
+	PhaseUsermap    Phase = "usermap"
+	PhaseCgroups    Phase = "cgroups"
+	PhaseInit       Phase = "init"
+	PhaseNamespaces Phase = "namespaces"
+	PhaseMounts     Phase = "mounts"
+	PhaseDevices    Phase = "devices"
+	PhaseHooks      Phase = "hooks"
+	PhaseRootfs     Phase = "rootfs"
+	PhaseExec       Phase = "exec"
+)
+
+// Exit codes of the init stage when it fails before the container's
+// process runs, as shells and docker use them. The detail goes to the
+// parent over the sync socket; the code is what is left if it doesn't
+// get there.
+const (
+	// ExitSetupFailed is any failure to set the container up.
+	ExitSetupFailed = 125
+	// ExitCannotExec is a process that exists but can't be executed.
+	ExitCannotExec = 126
+	// ExitNotFound is a process that doesn't exist.
+	ExitNotFound = 127
+)
+
+// StartError is returned when the container process fails to start.
+// Phase is the step that failed, whether in the runtime or in the init
+// stage. ExitCode is the init stage's exit code for a failure within it,
+// and 0 for one in the runtime.
+type StartError struct {
+	Phase    Phase
+	Err      error
+	ExitCode int
+}
+
+func (e *StartError) Error() string {
+	return fmt.Sprintf("start failed during %s: %v", e.Phase, e.Err)
+}
+
+func (e *StartError) Unwrap() error {
+	return e.Err
+}
+
+// InitExitCode is the exit code of the init stage failing with err:
+// ExitNotFound or ExitCannotExec for a process that couldn't be run,
+// ExitSetupFailed for everything before that.
+func InitExitCode(err error) int {
+	var startErr *StartError
+	if errors.As(err, &startErr) && startErr.ExitCode != 0 {
+		return startErr.ExitCode
+	}
+	return ExitSetupFailed
+}
+
+// startPhase attributes err to phase, unless a step within it already
+// has been.
+func startPhase(phase Phase, err error) error {
+	var startErr *StartError
+	if errors.As(err, &startErr) {
+		return err
+	}
+	return &StartError{Phase: phase, Err: err}
+}
+
+// ExitedImmediatelyError is returned when the container process started
+// but exited with a non-zero code straight away, as from a misconfigured
+// entrypoint. What it wrote to stderr went to the stderr it was given,
+// which for the command line is the caller's, ahead of this error.
+type ExitedImmediatelyError struct {
+	ExitCode int
+}
+
+func (e *ExitedImmediatelyError) Error() string {
+	return fmt.Sprintf("container exited immediately with code %d", e.ExitCode)
+}
+
+// ExecExitError is returned by Exec when the process ran but exited
+// with a non-zero code. A process killed by a signal has 128 plus the
+// signal's number, as shells report it.
+type ExecExitError struct {
+	ExitCode int
+}
+
+func (e *ExecExitError) Error() string {
+	return fmt.Sprintf("exec'd process exited with code %d", e.ExitCode)
+}
@@ -0,0 +1,238 @@
+package libcontainer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zakarynichols/hackontainer/api/types"
+)
+
+const eventsFilename = "events.log"
+
+// Lifecycle event types written to the factory-wide events log. A
+// start-failed event has the StartError's phase in its data.
+const (
+	EventCreate      = "create"
+	EventStart       = "start"
+	EventStartFailed = "start-failed"
+	EventStop        = "stop"
+	EventKill        = "kill"
+	EventPause       = "pause"
+	EventResume      = "resume"
+	EventDelete      = "delete"
+)
+
+// eventsPollInterval is how often a follower checks for new events.
+const eventsPollInterval = 250 * time.Millisecond
+
+// Event is one line of the events log.
+type Event = types.Event
+
+// appendEvent appends event to the events log under factoryRoot. Events
+// are best effort: a failure is reported but never fails the operation.
+func appendEvent(factoryRoot string, event Event) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	event.SchemaVersion = types.SchemaVersion
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	// One write per line with O_APPEND keeps concurrent writers from
+	// interleaving
+	f, err := os.OpenFile(filepath.Join(factoryRoot, eventsFilename), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: failed to record %s event: %v\n", event.Type, err)
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: failed to record %s event: %v\n", event.Type, err)
+	}
+}
+
+// emit records a lifecycle event for the container.
+func (c *linuxContainer) emit(eventType string, data map[string]string) {
+	for key, value := range data {
+		data[key] = c.redactSensitive(value)
+	}
+	appendEvent(filepath.Dir(c.root), Event{Type: eventType, ID: c.id, Labels: c.labels, Data: data})
+}
+
+// EventsOptions select the events StreamEvents delivers.
+type EventsOptions struct {
+	// Since skips events recorded before it. Without it, a follower
+	// starts at the end of the log and anyone else reads all of it.
+	Since time.Time
+
+	// Follow keeps delivering new events until the context is done.
+	Follow bool
+
+	// Match drops the events it returns false for.
+	Match func(Event) bool
+}
+
+// FollowEvents calls fn for every event in the log under root, starting
+// at the end of the log unless fromStart is set, until ctx is done or fn
+// returns an error.
+func FollowEvents(ctx context.Context, root string, fromStart bool, fn func(Event) error) error {
+	return streamEvents(ctx, root, fromStart, true, fn)
+}
+
+// StreamEvents calls fn for the events in the log under root that opts
+// selects, in the order they were written.
+func StreamEvents(ctx context.Context, root string, opts EventsOptions, fn func(Event) error) error {
+	fromStart := !opts.Follow || !opts.Since.IsZero()
+	return streamEvents(ctx, root, fromStart, opts.Follow, func(event Event) error {
+		if event.Timestamp.Before(opts.Since) {
+			return nil
+		}
+		if opts.Match != nil && !opts.Match(event) {
+			return nil
+		}
+		return fn(event)
+	})
+}
+
+func streamEvents(ctx context.Context, root string, fromStart, follow bool, fn func(Event) error) error {
+	path := filepath.Join(root, eventsFilename)
+
+	// Watch before opening, so nothing written in between goes unnoticed
+	var watcher *eventsWatcher
+	if follow {
+		watcher = newEventsWatcher(root)
+		defer watcher.Close()
+	}
+
+	f, err := openEventsLog(path)
+	if err != nil {
+		return err
+	}
+	for f == nil {
+		if !follow {
+			return nil
+		}
+		// No events yet; anything written later is new
+		fromStart = true
+		if !watcher.wait(ctx) {
+			return nil
+		}
+		if f, err = openEventsLog(path); err != nil {
+			return err
+		}
+	}
+	defer func() { f.Close() }()
+
+	// The offset comes from the open file, so events appended after the
+	// open are read even if they land before the seek
+	var offset int64
+	if !fromStart {
+		if offset, err = f.Seek(0, io.SeekEnd); err != nil {
+			return err
+		}
+	}
+
+	reader := bufio.NewReader(f)
+	var partial []byte
+	drained := false
+	for {
+		line, err := reader.ReadBytes('\n')
+		offset += int64(len(line))
+		partial = append(partial, line...)
+		if err == io.EOF {
+			if !follow {
+				return nil
+			}
+
+			switch eventsLogRotation(f, path, offset) {
+			case logTruncated:
+				if offset, err = f.Seek(0, io.SeekStart); err != nil {
+					return err
+				}
+				reader.Reset(f)
+				partial = partial[:0]
+				continue
+			case logReplaced:
+				// A writer that opened the old file before the rename may
+				// still be finishing, so it gets one more wait
+				if !drained {
+					drained = true
+					break
+				}
+				next, err := openEventsLog(path)
+				if err != nil {
+					return err
+				}
+				if next != nil {
+					f.Close()
+					f, offset, drained = next, 0, false
+					reader.Reset(f)
+					partial = partial[:0]
+					continue
+				}
+			}
+
+			if !watcher.wait(ctx) {
+				return nil
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		var event Event
+		if jsonErr := json.Unmarshal(partial, &event); jsonErr == nil {
+			if err := fn(event); err != nil {
+				return err
+			}
+		}
+		partial = partial[:0]
+	}
+}
+
+// openEventsLog opens the events log, returning nil if there is none yet.
+func openEventsLog(path string) (*os.File, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return f, err
+}
+
+type logRotation int
+
+const (
+	logUnchanged logRotation = iota
+	// logTruncated means the open log was cut shorter than what was read.
+	logTruncated
+	// logReplaced means path now names another file, or none.
+	logReplaced
+)
+
+// eventsLogRotation reports how the log at path changed since f was
+// opened and read up to offset.
+func eventsLogRotation(f *os.File, path string, offset int64) logRotation {
+	current, err := f.Stat()
+	if err != nil {
+		return logUnchanged
+	}
+	latest, err := os.Stat(path)
+	if err != nil || !os.SameFile(current, latest) {
+		return logReplaced
+	}
+	if current.Size() < offset {
+		return logTruncated
+	}
+	return logUnchanged
+}
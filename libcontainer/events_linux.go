@@ -0,0 +1,60 @@
+package libcontainer
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// eventsWatcher wakes a follower when the events log changes. A nil
+// watcher, used when inotify isn't available, falls back to polling.
+type eventsWatcher struct {
+	fd int
+}
+
+// newEventsWatcher watches the directory holding the events log, so a
+// log that is created, replaced or removed is noticed as well.
+func newEventsWatcher(dir string) *eventsWatcher {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil
+	}
+	mask := uint32(unix.IN_MODIFY | unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO)
+	if _, err := unix.InotifyAddWatch(fd, dir, mask); err != nil {
+		unix.Close(fd)
+		return nil
+	}
+	return &eventsWatcher{fd: fd}
+}
+
+// wait blocks until the directory changes or the poll interval passes.
+// It returns false once ctx is done.
+func (w *eventsWatcher) wait(ctx context.Context) bool {
+	if w == nil {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(eventsPollInterval):
+			return true
+		}
+	}
+
+	// The timeout bounds how long a cancelled ctx goes unnoticed
+	fds := []unix.PollFd{{Fd: int32(w.fd), Events: unix.POLLIN}}
+	unix.Poll(fds, int(eventsPollInterval/time.Millisecond))
+
+	buf := make([]byte, 4096)
+	for {
+		if n, err := unix.Read(w.fd, buf); n <= 0 || err != nil {
+			break
+		}
+	}
+	return ctx.Err() == nil
+}
+
+func (w *eventsWatcher) Close() {
+	if w != nil {
+		unix.Close(w.fd)
+	}
+}
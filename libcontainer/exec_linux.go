@@ -0,0 +1,330 @@
+package libcontainer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// execNamespaceOrder is the order Exec joins the container's namespaces
+// in. The mount namespace comes last, as joining it changes the root
+// the paths of the others would resolve against.
+var execNamespaceOrder = []NamespaceType{
+	specs.CgroupNamespace,
+	specs.IPCNamespace,
+	specs.UTSNamespace,
+	specs.NetworkNamespace,
+	specs.PIDNamespace,
+	specs.MountNamespace,
+}
+
+// Exec runs process in the running container and waits for it to exit.
+// It joins the namespaces and cgroup of the container process, is
+// looked up on the container's PATH, and starts in process.Cwd with
+// the container's env, which process.Env adds to and overrides. The
+// process shares the caller's standard streams. A process that exits
+// non-zero, or is killed, is reported as an *ExecExitError.
+//
+// The runtime is multithreaded, so it can't join a user or time
+// namespace; a container with its own is refused, as is a process that
+// asks for a terminal.
+func (c *linuxContainer) Exec(process *specs.Process) (retErr error) {
+	if process == nil || len(process.Args) == 0 {
+		return fmt.Errorf("no command to exec")
+	}
+	// How the process exited is its own business, not the exec's
+	defer func() {
+		err := retErr
+		var exitErr *ExecExitError
+		if errors.As(err, &exitErr) {
+			err = nil
+		}
+		c.audit(AuditExec, map[string]string{"path": process.Args[0]}, err)
+	}()
+	if process.Terminal {
+		return fmt.Errorf("cannot exec with a terminal: only the caller's standard streams are supported")
+	}
+	var names []string
+	for _, nsType := range execNamespaceOrder {
+		if slices.ContainsFunc(c.config.Resolved.Namespaces, func(ns specs.LinuxNamespace) bool { return ns.Type == nsType }) {
+			names = append(names, string(nsType))
+		}
+	}
+	for _, ns := range c.config.Resolved.Namespaces {
+		if ns.Type == specs.UserNamespace || ns.Type == specs.TimeNamespace {
+			return fmt.Errorf("cannot exec into container %s: it has its own %s namespace, which the runtime can't join", c.id, ns.Type)
+		}
+	}
+
+	pid, startTime, err := c.liveInit("exec in it")
+	if err != nil {
+		return newTypedError(ErrNotRunning, "cannot exec into container %s: %w", c.id, err)
+	}
+	procs, threads, err := c.execCgroupFiles()
+	if err != nil {
+		return err
+	}
+	execPath, err := os.Executable()
+	if err != nil {
+		execPath = os.Args[0]
+	}
+
+	var env []string
+	cwd := process.Cwd
+	if c.config.Process != nil {
+		env = c.config.Process.Env
+		if cwd == "" {
+			cwd = c.config.Process.Cwd
+		}
+	}
+	env = containerEnv(mergeEnv(env, process.Env))
+
+	errRead, errWrite, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer errRead.Close()
+
+	// The preserved fds come first, where the process gets them
+	preserveFDs := len(c.preservedFiles)
+	args := []string{
+		execPath, nsHelperArg,
+		"--pid", strconv.Itoa(pid),
+		"--start-time", strconv.FormatUint(startTime, 10),
+		"--namespaces", strings.Join(names, ","),
+		"--error-fd", strconv.Itoa(3 + preserveFDs),
+		"--stderr-fd", strconv.Itoa(4 + preserveFDs),
+		"--exec", helperExecContainer,
+		"--user", formatExecUser(process.User),
+	}
+	if preserveFDs > 0 {
+		args = append(args, preserveFDsArg, strconv.Itoa(preserveFDs))
+	}
+	if cwd != "" {
+		args = append(args, "--dir", cwd)
+	}
+	for _, file := range procs {
+		args = append(args, "--cgroup-procs", file)
+	}
+	if threads != "" {
+		args = append(args, "--cgroup-threads", threads)
+	}
+	args = append(args, "--", process.Args[0])
+	args = append(args, process.Args...)
+
+	// The process gets the caller's stderr through its own fd, so that
+	// the helper's complaints don't reach it twice
+	helper := &exec.Cmd{
+		Path:       execPath,
+		Args:       args,
+		Env:        env,
+		Stdin:      os.Stdin,
+		Stdout:     os.Stdout,
+		ExtraFiles: append(append([]*os.File(nil), c.preservedFiles...), errWrite, os.Stderr),
+	}
+	err = helper.Start()
+	errWrite.Close()
+	if err != nil {
+		return err
+	}
+	// The helper holds the pipe until the process exits, and writes to
+	// it only if it couldn't start the process
+	helperErr, _ := io.ReadAll(errRead)
+	err = helper.Wait()
+	if len(helperErr) > 0 {
+		return fmt.Errorf("failed to exec %s in container %s: %s", process.Args[0], c.id, helperErr)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return &ExecExitError{ExitCode: exitErr.ExitCode()}
+	}
+	return err
+}
+
+// execCgroupFiles returns the cgroup files the exec helper writes itself
+// to, so that what it forks starts in the container's cgroup: the
+// cgroup.procs of each hierarchy and, for a cgroup v2 cgroup in a
+// threaded subtree, the cgroup.threads the forking thread goes to.
+func (c *linuxContainer) execCgroupFiles() ([]string, string, error) {
+	manager := c.cgroupManager()
+	// The helper joins a systemd scope's cgroup like any other
+	if m, ok := manager.(*systemdCgroupManager); ok {
+		manager = m.CgroupManager
+	}
+	switch m := manager.(type) {
+	case *cgroupV1Manager:
+		var procs []string
+		for _, dir := range m.Paths() {
+			procs = append(procs, filepath.Join(dir, string(CgroupJoinProcs)))
+		}
+		slices.Sort(procs)
+		return procs, "", nil
+	case *cgroupV2Manager:
+		rel, err := filepath.Rel(cgroupRoot, m.path)
+		if err != nil {
+			return nil, "", err
+		}
+		rel = filepath.Join("/", rel)
+		join, err := planCgroupJoin(cgroupRoot, rel, m.threaded, nil)
+		if err != nil {
+			return nil, "", err
+		}
+		if join == CgroupJoinProcs {
+			return []string{filepath.Join(m.path, string(CgroupJoinProcs))}, "", nil
+		}
+		domain, err := threadedDomain(cgroupRoot, rel)
+		if err != nil {
+			return nil, "", err
+		}
+		return []string{filepath.Join(cgroupRoot, domain, string(CgroupJoinProcs))}, filepath.Join(m.path, string(CgroupJoinThreads)), nil
+	}
+	return nil, "", nil
+}
+
+// formatExecUser and parseExecUser pass process.user to the helper as
+// uid:gid[:gid,...].
+func formatExecUser(user specs.User) string {
+	s := fmt.Sprintf("%d:%d", user.UID, user.GID)
+	if len(user.AdditionalGids) > 0 {
+		gids := make([]string, len(user.AdditionalGids))
+		for i, gid := range user.AdditionalGids {
+			gids[i] = strconv.FormatUint(uint64(gid), 10)
+		}
+		s += ":" + strings.Join(gids, ",")
+	}
+	return s
+}
+
+func parseExecUser(s string) (specs.User, error) {
+	var user specs.User
+	fields := strings.SplitN(s, ":", 3)
+	if len(fields) < 2 {
+		return user, fmt.Errorf("invalid user %q", s)
+	}
+	ids := []string{fields[0], fields[1]}
+	if len(fields) == 3 {
+		ids = append(ids, strings.Split(fields[2], ",")...)
+	}
+	for i, field := range ids {
+		id, err := strconv.ParseUint(field, 10, 32)
+		if err != nil {
+			return user, fmt.Errorf("invalid user %q", s)
+		}
+		switch i {
+		case 0:
+			user.UID = uint32(id)
+		case 1:
+			user.GID = uint32(id)
+		default:
+			user.AdditionalGids = append(user.AdditionalGids, uint32(id))
+		}
+	}
+	return user, nil
+}
+
+// forkInNamespaces is the helper's part of Exec. It joins the cgroup
+// and namespaces of pid, forks path from the thread that joined them,
+// which puts it in the pid namespace too, and exits with its exit code.
+// It only returns if the process couldn't be started.
+func forkInNamespaces(pid int, startTime uint64, nsTypes []NamespaceType, dir, user string, procs []string, threads string, stderr *os.File, preserved []*os.File, path string, argv []string) error {
+	cred, err := parseExecUser(user)
+	if err != nil {
+		return err
+	}
+	files, err := openNamespaces(pid, startTime, nsTypes)
+	if err != nil {
+		return err
+	}
+	// Writing 0 moves the writer, or for cgroup.threads its thread
+	for _, file := range procs {
+		if err := os.WriteFile(file, []byte("0"), 0); err != nil {
+			return fmt.Errorf("failed to join cgroup: %w", err)
+		}
+	}
+
+	// Never unlocked: the helper exits once the process does
+	runtime.LockOSThread()
+	if threads != "" {
+		if err := os.WriteFile(threads, []byte("0"), 0); err != nil {
+			return fmt.Errorf("failed to join threaded cgroup: %w", err)
+		}
+	}
+	for i, nsType := range nsTypes {
+		if nsType == specs.MountNamespace {
+			if err := unix.Unshare(unix.CLONE_FS); err != nil {
+				return fmt.Errorf("failed to unshare filesystem information: %w", err)
+			}
+		}
+		if err := unix.Setns(int(files[i].Fd()), int(nsCloneFlags[nsType])); err != nil {
+			return fmt.Errorf("failed to join %s namespace: %w", nsType, err)
+		}
+	}
+
+	// Paths from here on resolve in the container's mount namespace
+	env := os.Environ()
+	if !strings.Contains(path, "/") {
+		pathValue, _ := lookupEnv(env, "PATH")
+		if pathValue == "" {
+			return fmt.Errorf("executable %q not found: no PATH set", path)
+		}
+		found, err := lookPath(path, pathValue)
+		if err != nil {
+			return fmt.Errorf("executable %q not found in PATH %s", path, pathValue)
+		}
+		path = found
+	}
+
+	cmd := &exec.Cmd{
+		Path:   path,
+		Args:   argv,
+		Env:    env,
+		Dir:    dir,
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: stderr,
+		// At fds 3 on, where the helper got them
+		ExtraFiles: preserved,
+	}
+	if cred.UID != 0 || cred.GID != 0 || len(cred.AdditionalGids) > 0 {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{
+			Uid:    cred.UID,
+			Gid:    cred.GID,
+			Groups: cred.AdditionalGids,
+		}}
+	}
+	// Signals from the terminal reach the process directly; the helper
+	// outlives it to report how it exited
+	signal.Notify(make(chan os.Signal, 1), unix.SIGINT, unix.SIGQUIT, unix.SIGTERM, unix.SIGHUP)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to exec %s: %w", argv[0], explainExecError(path, err))
+	}
+	for _, f := range files {
+		f.Close()
+	}
+
+	err = cmd.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			os.Exit(128 + int(status.Signal()))
+		}
+		os.Exit(exitErr.ExitCode())
+	}
+	if err != nil {
+		return err
+	}
+	os.Exit(0)
+	return nil
+}
@@ -0,0 +1,135 @@
+package libcontainer
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// maxShebangLength is as much of a script's first line as the kernel
+// reads for its interpreter.
+const maxShebangLength = 256
+
+// hostMachines maps GOARCH to the ELF machine the host runs.
+var hostMachines = map[string]elf.Machine{
+	"386":     elf.EM_386,
+	"amd64":   elf.EM_X86_64,
+	"arm":     elf.EM_ARM,
+	"arm64":   elf.EM_AARCH64,
+	"loong64": elf.EM_LOONGARCH,
+	"mips":    elf.EM_MIPS,
+	"mipsle":  elf.EM_MIPS,
+	"mips64":  elf.EM_MIPS,
+	"ppc64":   elf.EM_PPC64,
+	"ppc64le": elf.EM_PPC64,
+	"riscv64": elf.EM_RISCV,
+	"s390x":   elf.EM_S390,
+}
+
+// explainExecError rewords an exec of path that failed with err when
+// the errno points away from the real problem: ENOENT for a file that
+// exists means its interpreter or dynamic loader is missing, and
+// ENOEXEC means it's neither a script nor a binary for this host. Other
+// errors are returned as they are.
+func explainExecError(path string, err error) error {
+	switch {
+	case errors.Is(err, unix.ENOENT):
+		if _, statErr := os.Stat(path); statErr != nil {
+			return err
+		}
+		if interpreter, ok := readShebang(path); ok {
+			if strings.HasSuffix(interpreter, "\r") {
+				return fmt.Errorf("interpreter %q (from shebang of %s) not found in container; the script has DOS line endings: %w", interpreter, path, err)
+			}
+			return fmt.Errorf("interpreter %s (from shebang of %s) not found in container: %w", interpreter, path, err)
+		}
+		if loader, ok := elfInterpreter(path); ok {
+			return fmt.Errorf("dynamic loader %s (from ELF header of %s) not found in container: %w", loader, path, err)
+		}
+	case errors.Is(err, unix.ENOEXEC):
+		if _, ok := readShebang(path); ok {
+			return err
+		}
+		machine, ok := elfMachine(path)
+		if !ok {
+			return fmt.Errorf("%s is neither a script starting with #! nor an ELF binary: %w", path, err)
+		}
+		if host, known := hostMachines[runtime.GOARCH]; known && machine != host {
+			return fmt.Errorf("%s is a binary for %s, which this %s host can't run; the image may be for a different architecture: %w", path, machineName(machine), machineName(host), err)
+		}
+	}
+	return err
+}
+
+// readShebang returns the interpreter the #! line of path names.
+func readShebang(path string) (string, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(io.LimitReader(f, maxShebangLength)).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return "", false
+	}
+	rest, ok := bytes.CutPrefix(line, []byte("#!"))
+	if !ok {
+		return "", false
+	}
+	// The kernel splits off one optional argument at the first blank;
+	// only a \n ends the line, so a \r stays in the name
+	rest = bytes.TrimRight(rest, "\n")
+	rest = bytes.TrimLeft(rest, " \t")
+	interpreter := rest
+	if i := bytes.IndexAny(rest, " \t"); i >= 0 {
+		interpreter = rest[:i]
+	}
+	if len(interpreter) == 0 {
+		return "", false
+	}
+	return string(interpreter), true
+}
+
+// elfInterpreter returns the dynamic loader an ELF binary asks for.
+func elfInterpreter(path string) (string, bool) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_INTERP {
+			continue
+		}
+		data, err := io.ReadAll(prog.Open())
+		if err != nil {
+			return "", false
+		}
+		return string(bytes.TrimRight(data, "\x00")), true
+	}
+	return "", false
+}
+
+// elfMachine returns the architecture an ELF binary is built for.
+func elfMachine(path string) (elf.Machine, bool) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+	return f.Machine, true
+}
+
+// machineName names machine without the EM_ prefix of its constant.
+func machineName(machine elf.Machine) string {
+	return strings.TrimPrefix(machine.String(), "EM_")
+}
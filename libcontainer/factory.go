@@ -1,11 +1,17 @@
 package libcontainer
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
+	specs "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/zakarynichols/hackontainer/config"
+	"github.com/zakarynichols/hackontainer/libcontainer/audit"
+	"github.com/zakarynichols/hackontainer/libcontainer/specconv"
 )
 
 const (
@@ -15,15 +21,409 @@ const (
 
 type Factory interface {
 	Create(id, bundle string, options ...CreateOption) (Container, error)
-	Load(id string) (Container, error)
+	// CreateContext is Create, giving up once ctx is done.
+	CreateContext(ctx context.Context, id, bundle string, options ...CreateOption) (Container, error)
+	Load(id string, options ...LoadOption) (Container, error)
+	// List loads every container under the root. See LinuxFactory.List.
+	List() ([]ListedContainer, error)
+	// ListAllNamespaces is List across every namespace under the root.
+	ListAllNamespaces() ([]ListedContainer, error)
+	// Status summarises the containers under the root for health
+	// checks. See LinuxFactory.Status.
+	Status() (*Summary, error)
+	// Delegate, Delegation and Undelegate keep the records of containers
+	// another OCI runtime runs. See LinuxFactory.Delegate.
+	Delegate(id, bundle, runtime string, options ...CreateOption) (*Delegation, error)
+	Delegation(id string) (*Delegation, error)
+	Undelegate(id string) error
 }
 
 type LinuxFactory struct {
 	root string
+
+	// namespace scopes the factory to the containers under
+	// <root>/<namespace>.
+	namespace string
+
+	// configPath overrides the bundle's config.json for a single Create.
+	configPath string
+
+	// cloneSource is the container whose frozen config a single Create
+	// copies instead; see WithCloneSource.
+	cloneSource string
+
+	// processArgs and annotations are applied on top of the loaded spec
+	// before validation.
+	processArgs []string
+	annotations map[string]string
+
+	// labels are kept with the container, apart from its spec.
+	labels map[string]string
+
+	// replaceArgs allows processArgs to replace args the spec already has.
+	replaceArgs bool
+
+	// env, cwd and user override the spec's process like processArgs.
+	// env includes the variables of envFiles.
+	env      []string
+	envFiles []string
+	cwd      string
+	user     *userOverride
+
+	// sensitiveEnv names variables whose values are kept out of the
+	// frozen config.
+	sensitiveEnv []string
+
+	// retry is how the factory's containers retry busy cleanup steps.
+	retry RetryPolicy
+
+	// security weakens the spec's confinement for debugging.
+	security securityOverrides
+
+	// ownerFixupAllow lists the directories below which owner-fixup
+	// mounts may chown their source.
+	ownerFixupAllow []string
+
+	// cgroupPolicy decides where containers get their cgroups.
+	cgroupPolicy CgroupPolicy
+
+	// rootlessMode decides whether New requires root privileges.
+	rootlessMode RootlessMode
+
+	// cgroupParent replaces defaultCgroupParent for containers whose
+	// spec doesn't set linux.cgroupsPath.
+	cgroupParent string
+
+	restartPolicy *RestartPolicy
+
+	// maxRuntime is the limit the monitor puts on the container process.
+	maxRuntime *MaxRuntime
+
+	// readOnlyAutoTmpfs adds scratch tmpfs mounts to a read-only root.
+	readOnlyAutoTmpfs *ReadOnlyAutoTmpfs
+
+	// createMode is what Create leaves behind for start.
+	createMode CreateMode
+
+	// rootfsSize limits writes to the rootfs via a project quota.
+	rootfsSize uint64
+
+	// rootfsFD is the rootfs directory when given by WithRootfsFD, or -1.
+	rootfsFD int
+
+	// hooksDisabled rejects configs with hooks instead of running them.
+	hooksDisabled bool
+
+	// archCheckDisabled skips checkRootfsArch.
+	archCheckDisabled bool
+
+	// noPivotRoot makes containers' roots without pivot_root.
+	noPivotRoot bool
+
+	// systemdCgroup has systemd create containers' cgroups.
+	systemdCgroup bool
+
+	// consoleSocket is where containers with a terminal send its master.
+	consoleSocket string
+
+	// forwardToAll has a foreground run forward signals to every process
+	// of the container.
+	forwardToAll bool
+
+	// auditSink gets the audit records of the factory's containers; nil
+	// means the audit log under root. caller is who they are attributed
+	// to; nil means the runtime itself.
+	auditSink audit.Sink
+	caller    *audit.Caller
+
+	// preservedFiles are handed to the container process from fd 3 on.
+	preservedFiles []*os.File
+
+	// configOptions control how the bundle's config is read.
+	configOptions config.Options
+
+	// sharedRoot allows a root on a network filesystem, whose
+	// containers are locked with lock files and owned by a host.
+	sharedRoot bool
+
+	// maxAnnotationsSize caps the annotations a config may carry; zero
+	// means config.DefaultMaxAnnotationsSize.
+	maxAnnotationsSize int
+
+	// commandLine is recorded with the container, env override values
+	// redacted unless envValuesRecorded.
+	commandLine       []string
+	envValuesRecorded bool
 }
 
 type CreateOption func(*LinuxFactory) error
 
+// LoadOption changes how Load treats an existing container.
+type LoadOption func(*linuxContainer) error
+
+// WithoutNamespaceCheck skips verifying the live process against the
+// configured namespaces on Load. It is meant for recovery tooling that
+// has to act on a container whose process no longer matches.
+func WithoutNamespaceCheck() LoadOption {
+	return func(c *linuxContainer) error {
+		c.skipNamespaceCheck = true
+		return nil
+	}
+}
+
+// WithConfigPath makes Create read the spec from path instead of the
+// bundle's config.json. The root filesystem is still resolved against
+// the bundle.
+func WithConfigPath(path string) CreateOption {
+	return func(l *LinuxFactory) error {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("failed to get absolute path for config: %w", err)
+		}
+		l.configPath = absPath
+		return nil
+	}
+}
+
+// WithProcessArgs sets process.args of the loaded spec, keeping the rest
+// of the process configuration (env, cwd, user) as written. It is meant
+// for bundles that lost their args; Create fails if the spec already has
+// some unless WithReplaceArgs is given as well.
+func WithProcessArgs(args ...string) CreateOption {
+	return func(l *LinuxFactory) error {
+		if len(args) == 0 {
+			return fmt.Errorf("process args override cannot be empty")
+		}
+		l.processArgs = args
+		return nil
+	}
+}
+
+// WithReplaceArgs lets WithProcessArgs replace the args the spec sets.
+func WithReplaceArgs() CreateOption {
+	return func(l *LinuxFactory) error {
+		l.replaceArgs = true
+		return nil
+	}
+}
+
+// WithEnv sets variables in process.env, each given as KEY=VALUE. A key
+// the spec already sets keeps its place and takes the new value; other
+// keys are appended in order.
+func WithEnv(env ...string) CreateOption {
+	return func(l *LinuxFactory) error {
+		for _, kv := range env {
+			if strings.Index(kv, "=") <= 0 {
+				return fmt.Errorf("invalid environment variable %q, want KEY=VALUE", kv)
+			}
+		}
+		l.env = append(append([]string(nil), l.env...), env...)
+		return nil
+	}
+}
+
+// WithCwd sets process.cwd. Validation still requires an absolute path.
+func WithCwd(cwd string) CreateOption {
+	return func(l *LinuxFactory) error {
+		l.cwd = cwd
+		return nil
+	}
+}
+
+// userOverride is a process.user given as uid[:gid].
+type userOverride struct {
+	uid uint32
+	gid *uint32
+}
+
+// WithUser sets process.user from "uid[:gid]", both numeric. Without a
+// gid the spec's gid is kept.
+func WithUser(user string) CreateOption {
+	return func(l *LinuxFactory) error {
+		uidStr, gidStr, hasGID := strings.Cut(user, ":")
+		uid, err := strconv.ParseUint(uidStr, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid user %q, want a numeric uid[:gid]", user)
+		}
+		override := &userOverride{uid: uint32(uid)}
+		if hasGID {
+			gid, err := strconv.ParseUint(gidStr, 10, 32)
+			if err != nil {
+				return fmt.Errorf("invalid user %q, want a numeric uid[:gid]", user)
+			}
+			g := uint32(gid)
+			override.gid = &g
+		}
+		l.user = override
+		return nil
+	}
+}
+
+// WithAnnotation adds an annotation to the spec, overriding any value the
+// bundle set for the same key.
+func WithAnnotation(key, value string) CreateOption {
+	return func(l *LinuxFactory) error {
+		annotations := make(map[string]string, len(l.annotations)+1)
+		for k, v := range l.annotations {
+			annotations[k] = v
+		}
+		annotations[key] = value
+		l.annotations = annotations
+		return nil
+	}
+}
+
+// WithRestartPolicy sets the policy the monitor applies when the
+// container process exits.
+func WithRestartPolicy(policy *RestartPolicy) CreateOption {
+	return func(l *LinuxFactory) error {
+		l.restartPolicy = policy
+		return nil
+	}
+}
+
+// WithRootfsSizeLimit bounds the bytes the container can write to its
+// rootfs. It overrides the org.hackontainer.rootfs-size annotation and
+// requires a filesystem with project quotas enabled.
+func WithRootfsSizeLimit(bytes uint64) CreateOption {
+	return func(l *LinuxFactory) error {
+		l.rootfsSize = bytes
+		return nil
+	}
+}
+
+// WithHooksDisabled makes Create fail for any config that has hooks, so
+// the runtime never executes host binaries named by a bundle. Skipping
+// them silently would leave part of the config unapplied.
+func WithHooksDisabled() CreateOption {
+	return func(l *LinuxFactory) error {
+		l.hooksDisabled = true
+		return nil
+	}
+}
+
+// WithMaxAnnotationsSize changes the limit on the combined size of a
+// config's annotation keys and values.
+func WithMaxAnnotationsSize(bytes int) CreateOption {
+	return func(l *LinuxFactory) error {
+		if bytes <= 0 {
+			return fmt.Errorf("annotations size limit must be positive, got %d", bytes)
+		}
+		l.maxAnnotationsSize = bytes
+		return nil
+	}
+}
+
+// WithStrictSpec makes Create reject configs with fields the runtime
+// spec doesn't define or with keys repeated in an object.
+func WithStrictSpec() CreateOption {
+	return func(l *LinuxFactory) error {
+		l.configOptions.Strict = true
+		return nil
+	}
+}
+
+// WithoutArchCheck skips comparing the architecture of the container
+// process's binary with the host's at create.
+func WithoutArchCheck() CreateOption {
+	return func(l *LinuxFactory) error {
+		l.archCheckDisabled = true
+		return nil
+	}
+}
+
+// WithMaxConfigSize changes the largest config file Create accepts.
+func WithMaxConfigSize(bytes int64) CreateOption {
+	return func(l *LinuxFactory) error {
+		if bytes <= 0 {
+			return fmt.Errorf("config size limit must be positive, got %d", bytes)
+		}
+		l.configOptions.MaxSize = bytes
+		return nil
+	}
+}
+
+// WithNamespace scopes the factory to a tenant namespace: its containers
+// live under <root>/<name>/<id>, their cgroups default to
+// <parent>/<name>/<id> and their events go to the namespace's own log.
+// Names follow the container ID rules, so no ID or name can reach outside
+// its namespace.
+func WithNamespace(name string) CreateOption {
+	return func(l *LinuxFactory) error {
+		if err := validateNamespace(name); err != nil {
+			return err
+		}
+		l.namespace = name
+		return nil
+	}
+}
+
+// NamespaceRoot returns the directory holding the containers of the
+// tenant namespace under root, or root itself for the empty namespace.
+func NamespaceRoot(root, namespace string) (string, error) {
+	if namespace == "" {
+		return root, nil
+	}
+	if err := validateNamespace(namespace); err != nil {
+		return "", err
+	}
+	return filepath.Join(root, namespace), nil
+}
+
+// namespaceRoots returns root and the root of every tenant namespace
+// under it. Given a namespace's root, there are none under it. A root
+// that doesn't exist yet has no roots.
+func namespaceRoots(root string) ([]string, error) {
+	entries, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	roots := []string{root}
+	for _, entry := range entries {
+		dir := filepath.Join(root, entry.Name())
+		if !entry.IsDir() || fileExists(filepath.Join(dir, stateFilename)) {
+			continue
+		}
+		if err := validateNamespace(entry.Name()); err != nil {
+			continue
+		}
+		roots = append(roots, dir)
+	}
+	return roots, nil
+}
+
+// scopeRoots returns the directories whose containers a command covering
+// root acts on: root alone, or with allNamespaces its namespaces' too. A
+// root that doesn't exist yet has none.
+func scopeRoots(root string, allNamespaces bool) ([]string, error) {
+	if allNamespaces {
+		return namespaceRoots(root)
+	}
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return nil, nil
+	}
+	return []string{root}, nil
+}
+
+// containerRoots returns the container directories directly under dir.
+func containerRoots(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var roots []string
+	for _, entry := range entries {
+		containerRoot := filepath.Join(dir, entry.Name())
+		if entry.IsDir() && fileExists(filepath.Join(containerRoot, stateFilename)) {
+			roots = append(roots, containerRoot)
+		}
+	}
+	return roots, nil
+}
+
 func New(root string, options ...CreateOption) (Factory, error) {
 	// Should this be defined globally and never be an empty string?
 	if root == "" {
@@ -31,7 +431,9 @@ func New(root string, options ...CreateOption) (Factory, error) {
 	}
 
 	l := &LinuxFactory{
-		root: root,
+		root:       root,
+		rootfsFD:   -1,
+		createMode: CreateModeStateOnly,
 	}
 
 	for _, opt := range options {
@@ -40,14 +442,95 @@ func New(root string, options ...CreateOption) (Factory, error) {
 		}
 	}
 
+	rootless, err := l.resolveRootless()
+	if err != nil {
+		return nil, err
+	}
+	if l.systemdCgroup {
+		if rootless {
+			return nil, fmt.Errorf("the systemd cgroup driver needs root")
+		}
+		if l.cgroupPolicy != "" {
+			return nil, fmt.Errorf("a cgroup policy can't be combined with the systemd cgroup driver, which leaves the cgroup tree to systemd")
+		}
+	}
+	// A rootful runtime doesn't quietly run containers without cgroups
+	if !rootless && !l.systemdCgroup && l.cgroupPolicy == "" {
+		l.cgroupPolicy = CgroupPolicyRoot
+	}
+
 	if err := os.MkdirAll(root, 0700); err != nil {
 		return nil, err
 	}
+	if err := l.checkSharedRoot(); err != nil {
+		return nil, err
+	}
+	if err := probeLocks(root); err != nil {
+		return nil, err
+	}
+	if err := migrateRoot(root); err != nil {
+		return nil, err
+	}
+
+	if l.namespace != "" {
+		// A container created without a namespace may have taken the name
+		if _, err := os.Stat(filepath.Join(root, l.namespace, stateFilename)); err == nil {
+			return nil, newTypedError(ErrInvalidID, "namespace %q is the id of a container under %s", l.namespace, root)
+		}
+		if err := os.MkdirAll(filepath.Join(root, l.namespace), 0700); err != nil {
+			return nil, err
+		}
+	}
 
 	return l, nil
 }
 
+// resolveRootless reports whether the factory runs rootless. Unless told
+// to, it probes the privileges, and without rootless mode it fails when
+// any is missing.
+func (l *LinuxFactory) resolveRootless() (bool, error) {
+	switch l.rootlessMode {
+	case RootlessTrue:
+		return true, nil
+	case RootlessFalse:
+		return false, checkPrivileges()
+	}
+	return len(MissingPrivileges()) > 0, nil
+}
+
+// stateRoot is the directory holding the factory's containers.
+func (l *LinuxFactory) stateRoot() string {
+	return filepath.Join(l.root, l.namespace)
+}
+
 func (l *LinuxFactory) Create(id, bundle string, options ...CreateOption) (Container, error) {
+	return l.CreateContext(context.Background(), id, bundle, options...)
+}
+
+// CreateContext checks ctx between the steps of a create, so a step
+// stuck in the kernel still has to return first. Whatever the create
+// had set up by then is removed.
+func (l *LinuxFactory) CreateContext(ctx context.Context, id, bundle string, options ...CreateOption) (_ Container, retErr error) {
+	// Options passed to Create only apply to this container
+	f := *l
+	for _, opt := range options {
+		if err := opt(&f); err != nil {
+			return nil, err
+		}
+	}
+
+	defer func() { f.auditCreate(id, bundle, retErr) }()
+
+	var clone *cloneSource
+	if f.cloneSource != "" {
+		var err error
+		if clone, err = f.loadCloneSource(); err != nil {
+			return nil, err
+		}
+		if bundle == "" {
+			bundle = clone.bundle
+		}
+	}
 	if bundle == "" {
 		bundle = "."
 	}
@@ -57,89 +540,519 @@ func (l *LinuxFactory) Create(id, bundle string, options ...CreateOption) (Conta
 	if err != nil {
 		return nil, fmt.Errorf("failed to get absolute path for bundle: %w", err)
 	}
+	if err := config.ValidateBundlePath(absBundle); err != nil {
+		return nil, newTypedError(ErrInvalidConfig, "%w", err)
+	}
 
 	if id == "" {
-		return nil, fmt.Errorf("container ID cannot be empty")
+		return nil, newTypedError(ErrInvalidID, "container ID cannot be empty")
 	}
 
-	containerRoot := filepath.Join(l.root, id)
-	if err := os.MkdirAll(containerRoot, 0711); err != nil {
+	if err := validateID(id); err != nil {
+		return nil, err
+	}
+
+	containerRoot := filepath.Join(f.stateRoot(), id)
+	if err := os.Mkdir(containerRoot, 0711); err != nil {
+		if os.IsExist(err) {
+			return nil, newTypedError(ErrExist, "container id '%s' already exists in directory %s", id, containerRoot)
+		}
+		return nil, err
+	}
+	// A failed create must not leave a directory that blocks the ID
+	defer func() {
+		// Removing a pinned rootfs that is still mounted would remove
+		// the caller's files
+		if retErr != nil && unpinRootfs(containerRoot, f.retry) == nil {
+			os.RemoveAll(containerRoot)
+		}
+	}()
+	if err := writeLayout(containerRoot, len(containerMigrations)); err != nil {
 		return nil, err
 	}
 
-	config, err := loadContainerConfig(absBundle)
+	configPath := f.configPath
+	if configPath == "" {
+		configPath = filepath.Join(absBundle, configFilename)
+	}
+	configOptions := f.configOptions
+	if clone != nil {
+		configPath = clone.configPath()
+		configOptions = frozenConfigOptions
+	}
+
+	config, err := config.LoadWithOptions(configPath, absBundle, configOptions)
 	if err != nil {
 		return nil, err
 	}
+	if clone != nil {
+		if err := clone.apply(&f, config, absBundle); err != nil {
+			return nil, err
+		}
+	}
+	if err := outOfTime(ctx, "loading the config"); err != nil {
+		return nil, err
+	}
 
-	if err := config.NormalizeRoot(); err != nil {
+	if err := f.applyOverrides(config); err != nil {
 		return nil, err
 	}
 
-	if err := validateID(id); err != nil {
+	if err := config.NormalizeRoot(); err != nil {
+		return nil, newTypedError(ErrInvalidConfig, "%w", err)
+	}
+	if err := outOfTime(ctx, "resolving the rootfs"); err != nil {
 		return nil, err
 	}
 
+	normalizeDevices(config.Spec)
+
+	autoTmpfs := f.readOnlyAutoTmpfs
+	if value, ok := config.Annotations[readOnlyAutoTmpfsAnnotation]; ok && autoTmpfs == nil {
+		if autoTmpfs, err = ParseReadOnlyAutoTmpfs(value); err != nil {
+			return nil, newTypedError(ErrInvalidConfig, "invalid %s annotation: %w", readOnlyAutoTmpfsAnnotation, err)
+		}
+	}
+	if autoTmpfs != nil {
+		addScratchTmpfs(config.Spec, autoTmpfs)
+	}
+
+	// systemd decides where the cgroup goes itself
+	var cgroupWarning, cgroupUnit, unitCgroupPath string
+	if f.systemdCgroup {
+		if f.cgroupParent != "" {
+			return nil, newTypedError(ErrInvalidConfig, "a cgroup parent can't be used with the systemd cgroup driver; give a slice in linux.cgroupsPath instead")
+		}
+		if specconv.CgroupsDisabled(config.Spec) {
+			cgroupWarning = noCgroupsWarning(config.Spec, "the config disables them")
+		} else if cgroupUnit, unitCgroupPath, err = systemdCgroup(config.Spec, filepath.Join(f.namespace, id)); err != nil {
+			return nil, newTypedError(ErrInvalidConfig, "%w", err)
+		}
+	} else {
+		applyCgroupParent(f.cgroupParent, config.Spec, filepath.Join(f.namespace, id))
+		if cgroupWarning, err = applyCgroupPolicy(f.cgroupPolicy, config.Spec, filepath.Join(f.namespace, id)); err != nil {
+			return nil, err
+		}
+	}
+
 	if err := config.Validate(); err != nil {
+		return nil, newTypedError(ErrInvalidConfig, "%w", err)
+	}
+
+	if err := f.validateAnnotations(config); err != nil {
+		return nil, newTypedError(ErrInvalidConfig, "%w", err)
+	}
+
+	if err := f.validateLabels(); err != nil {
+		return nil, newTypedError(ErrInvalidConfig, "%w", err)
+	}
+
+	if err := validateDevices(config.Spec); err != nil {
+		return nil, newTypedError(ErrInvalidConfig, "%w", err)
+	}
+
+	if err := validateNamespaces(config.Spec); err != nil {
+		return nil, newTypedError(ErrInvalidConfig, "%w", err)
+	}
+
+	if err := validateCgroupDelegation(config.Spec); err != nil {
+		return nil, newTypedError(ErrInvalidConfig, "%w", err)
+	}
+
+	if err := validateCgroupJoin(config.Spec, filepath.Join(f.namespace, id)); err != nil {
+		return nil, newTypedError(ErrInvalidConfig, "%w", err)
+	}
+
+	if err := validateCPU(config.Spec); err != nil {
+		return nil, newTypedError(ErrInvalidConfig, "%w", err)
+	}
+
+	fixups, err := ownerFixups(config.Spec, absBundle, f.ownerFixupAllow)
+	if err != nil {
+		return nil, newTypedError(ErrInvalidConfig, "%w", err)
+	}
+	if err := outOfTime(ctx, "checking owner fixups"); err != nil {
+		return nil, err
+	}
+
+	// Whatever the config file said, only the creator chooses this
+	config.NoPivotRoot = f.noPivotRoot
+	if f.noPivotRoot && f.rootfsFD >= 0 {
+		return nil, newTypedError(ErrInvalidConfig, "a rootfs pinned from an fd can't be used without pivot_root")
+	}
+
+	// Nothing is left for a full create's process to get a terminal from
+	if f.createMode == CreateModeFull && config.Process != nil && config.Process.Terminal && f.consoleSocket == "" {
+		return nil, newTypedError(ErrInvalidConfig, "process.terminal is set but no console socket was given to send the terminal to")
+	}
+
+	if f.hooksDisabled && len(specconv.Hooks(config.Spec)) > 0 {
+		return nil, newTypedError(ErrHooksDisabled, "config requests hooks but hooks are disabled")
+	}
+
+	if err := checkKernelFeatures(f.root, config.Spec, config.NoPivotRoot); err != nil {
+		return nil, err
+	}
+	if err := outOfTime(ctx, "probing kernel features"); err != nil {
+		return nil, err
+	}
+
+	if f.rootfsFD >= 0 {
+		pinned, err := pinRootfs(f.rootfsFD, containerRoot)
+		if err != nil {
+			return nil, fmt.Errorf("failed to pin rootfs: %w", err)
+		}
+		config.Spec.Root.Path = pinned
+		config.Rootfs = pinned
+		if err := outOfTime(ctx, "pinning the rootfs"); err != nil {
+			return nil, err
+		}
+	}
+
+	if !f.archCheckDisabled {
+		if err := checkRootfsArch(config.Rootfs, config.Spec.Process); err != nil {
+			return nil, err
+		}
+	}
+
+	warnings := append(config.Warnings(), deviceWarnings(config.Spec)...)
+	warnings = append(warnings, maskWarnings(config.Spec)...)
+	warnings = append(warnings, readOnlyWarnings(config.Spec)...)
+	if cgroupWarning != "" {
+		warnings = append(warnings, cgroupWarning)
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "WARNING: %s\n", warning)
+	}
+	if weakened := f.security.String(); weakened != "" {
+		fmt.Fprintf(os.Stderr, "WARNING: container %s runs with weakened confinement (%s); use this for debugging only\n", id, weakened)
+	}
+
+	rootfsSize := f.rootfsSize
+	if value, ok := config.Annotations[rootfsSizeAnnotation]; ok && rootfsSize == 0 {
+		if rootfsSize, err = ParseSize(value); err != nil {
+			return nil, newTypedError(ErrInvalidConfig, "invalid %s annotation: %w", rootfsSizeAnnotation, err)
+		}
+	}
+
+	maxRuntime := f.maxRuntime
+	if value, ok := config.Annotations[maxRuntimeAnnotation]; ok && maxRuntime == nil {
+		if maxRuntime, err = ParseMaxRuntime(value); err != nil {
+			return nil, newTypedError(ErrInvalidConfig, "invalid %s annotation: %w", maxRuntimeAnnotation, err)
+		}
+	}
+
+	var quota *RootfsQuota
+	if rootfsSize > 0 {
+		if quota, err = applyRootfsQuota(f.root, filepath.Join(f.namespace, id), config.Rootfs, rootfsSize); err != nil {
+			return nil, fmt.Errorf("failed to limit rootfs size: %w", err)
+		}
+		defer func() {
+			if retErr != nil {
+				releaseRootfsQuota(f.root, filepath.Join(f.namespace, id), config.Rootfs, quota.ProjectID)
+			}
+		}()
+		if err := outOfTime(ctx, "limiting the rootfs size"); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, fixup := range fixups {
+		if err := fixup.apply(); err != nil {
+			return nil, err
+		}
+	}
+	if err := outOfTime(ctx, "applying owner fixups"); err != nil {
 		return nil, err
 	}
 
+	// Freeze the config so later operations are unaffected by edits to
+	// the bundle or the override file. Sensitive values are kept apart
+	sensitiveEnv, err := f.redactSensitiveEnv(config)
+	if err != nil {
+		return nil, err
+	}
+	// Resolved once, here; starts and restarts set the container up
+	// from the result
+	config.Resolved, err = specconv.Convert(config.Spec, specconv.Options{
+		Bundle:     absBundle,
+		CgroupName: filepath.Join(f.namespace, id),
+	})
+	if err != nil {
+		return nil, newTypedError(ErrInvalidConfig, "%w", err)
+	}
+	if cgroupUnit != "" {
+		config.Resolved.CgroupsPath, config.Resolved.CgroupUnit = unitCgroupPath, cgroupUnit
+	}
+	if err := config.Save(filepath.Join(containerRoot, configFilename)); err != nil {
+		return nil, err
+	}
+	createOptions, err := f.createOptions(absBundle, configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record create options: %w", err)
+	}
+
 	container := &linuxContainer{
-		id:     id,
-		root:   containerRoot,
-		config: config,
-		bundle: absBundle,
+		id:             id,
+		root:           containerRoot,
+		config:         config,
+		bundle:         absBundle,
+		configPath:     configPath,
+		namespace:      f.namespace,
+		restartPolicy:  f.restartPolicy,
+		maxRuntime:     maxRuntime,
+		rootfsQuota:    quota,
+		createMode:     f.createMode,
+		retry:          f.retry,
+		labels:         f.labels,
+		auditSink:      f.auditSink,
+		caller:         f.caller,
+		preservedFiles: f.preservedFiles,
+		consoleSocket:  f.consoleSocket,
+		configSHA256:   createOptions.ConfigSHA256,
+		forwardToAll:   f.forwardToAll,
 	}
 
+	// Before there is a state to lock, so every lock is a lock file
+	if f.sharedRoot {
+		if err := container.createMarker(sharedRootFilename); err != nil {
+			return nil, err
+		}
+		container.owner = currentOwner()
+	} else if _, ok := lockerOf(f.root).(fileLocker); ok {
+		if err := container.createMarker(fileLocksFilename); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := container.saveSensitiveEnv(sensitiveEnv); err != nil {
+		return nil, err
+	}
+	if err := container.saveCreateOptions(createOptions); err != nil {
+		return nil, err
+	}
+	if err := container.saveLabels(); err != nil {
+		return nil, err
+	}
 	if err := container.createState(); err != nil {
 		return nil, err
 	}
 
+	// The process is set up now and waits for start under a monitor of
+	// its own, which also removes its cgroup if the setup fails
+	if f.createMode == CreateModeFull {
+		if err := container.createExecFifo(); err != nil {
+			return nil, err
+		}
+		if err := container.startMonitor(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	container.emit(EventCreate, map[string]string{"bundle": absBundle})
+
 	return container, nil
 }
 
-func (l *LinuxFactory) Load(id string) (Container, error) {
+// outOfTime fails a create whose ctx is done, naming the step it was in.
+func outOfTime(ctx context.Context, step string) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("create ran out of time %s: %w", step, err)
+	}
+	return nil
+}
+
+func (l *LinuxFactory) Load(id string, options ...LoadOption) (Container, error) {
 	if id == "" {
-		return nil, fmt.Errorf("container ID cannot be empty")
+		return nil, newTypedError(ErrInvalidID, "container ID cannot be empty")
 	}
 
-	containerRoot := filepath.Join(l.root, id)
+	if err := validateID(id); err != nil {
+		return nil, err
+	}
+
+	container, err := loadContainer(filepath.Join(l.stateRoot(), id), options...)
+	if err != nil {
+		return nil, err
+	}
+	container.retry = l.retry
+	container.auditSink = l.auditSink
+	return container, nil
+}
+
+// loadContainer loads the container whose state lives in containerRoot.
+func loadContainer(containerRoot string, options ...LoadOption) (*linuxContainer, error) {
 	container := &linuxContainer{
-		id:   id,
+		id:   filepath.Base(containerRoot),
 		root: containerRoot,
 	}
 
+	for _, opt := range options {
+		if err := opt(container); err != nil {
+			return nil, err
+		}
+	}
+
+	// Older runtimes may have left the root in an earlier layout
+	if err := container.migrateContainer(); err != nil {
+		return nil, err
+	}
+
 	// Load state first to get bundle path
 	state, err := container.State()
+	if os.IsNotExist(err) {
+		// A delete that died after removing state.json leaves the rest
+		// for the next delete to finish
+		if container.markerExists(deletingFilename) {
+			return container, nil
+		}
+		if d, _ := loadDelegation(containerRoot); d != nil {
+			return nil, newTypedError(ErrInvalidState, "container %q is delegated to %s; only create, run, start, kill, delete, state, ps, events, update, checkpoint and list reach it", container.id, d.Runtime)
+		}
+		return nil, newTypedError(ErrNotExist, "container %q does not exist", container.id)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	// Load configuration from bundle
-	config, err := loadContainerConfig(state.Bundle)
+	// Load the configuration frozen at create time
+	config, err := loadFrozenConfig(containerRoot, state.Bundle, filepath.Join(state.Namespace, container.id))
 	if err != nil {
 		return nil, err
 	}
 
+	if err := container.loadLabels(); err != nil {
+		return nil, err
+	}
+
 	container.config = config
+	container.namespace = state.Namespace
 	container.bundle = state.Bundle
+	container.configPath = state.ConfigPath
+	if foreignOwner(state) {
+		container.ownerErr = ownerError(state)
+	}
+
+	// A running container's process is checked once here so every
+	// operation on this object agrees on whether it can be trusted
+	if container.ownerErr == nil && !container.skipNamespaceCheck && (state.Status == Running || state.Status == Paused) && state.Pid > 0 {
+		container.namespaceErr = verifyNamespaces(procRoot, state.Pid, config.Resolved.Namespaces)
+	}
 
 	return container, nil
 }
 
+// applyOverrides applies the Create options that change the spec. The
+// result is validated and frozen like any other config, so start and
+// restarts see the same args.
+func (l *LinuxFactory) applyOverrides(cfg *config.Config) error {
+	if len(l.processArgs) > 0 {
+		if cfg.Process == nil {
+			cfg.Process = &specs.Process{Cwd: "/"}
+		}
+		if len(cfg.Process.Args) > 0 && !l.replaceArgs {
+			return newTypedError(ErrInvalidConfig, "config already sets process.args %q; replacing them must be requested explicitly", cfg.Process.Args)
+		}
+		cfg.Process.Args = l.processArgs
+	}
+
+	if len(l.env) > 0 || l.cwd != "" || l.user != nil {
+		if cfg.Process == nil {
+			cfg.Process = &specs.Process{Cwd: "/"}
+		}
+		cfg.Process.Env = mergeEnv(cfg.Process.Env, l.env)
+		if l.cwd != "" {
+			cfg.Process.Cwd = l.cwd
+		}
+		if l.user != nil {
+			cfg.Process.User.UID = l.user.uid
+			if l.user.gid != nil {
+				cfg.Process.User.GID = *l.user.gid
+			}
+		}
+	}
+
+	if len(l.annotations) > 0 {
+		if cfg.Annotations == nil {
+			cfg.Annotations = make(map[string]string, len(l.annotations))
+		}
+		for k, v := range l.annotations {
+			cfg.Annotations[k] = v
+		}
+	}
+
+	l.security.apply(cfg.Spec)
+	return nil
+}
+
+// validateAnnotations applies the factory's annotation limits.
+func (l *LinuxFactory) validateAnnotations(cfg *config.Config) error {
+	maxSize := l.maxAnnotationsSize
+	if maxSize == 0 {
+		maxSize = config.DefaultMaxAnnotationsSize
+	}
+	return config.ValidateAnnotations(cfg.Annotations, maxSize)
+}
+
 func validateID(id string) error {
 	if len(id) > 1024 {
-		return fmt.Errorf("container ID too long")
+		return newTypedError(ErrInvalidID, "container ID too long")
 	}
 
-	if filepath.Base(id) != id {
-		return fmt.Errorf("invalid container ID")
+	if filepath.Base(id) != id || id == "." || id == ".." {
+		return newTypedError(ErrInvalidID, "invalid container ID")
 	}
 
 	return nil
 }
 
+// validateNamespace applies the container ID rules to a namespace name.
+func validateNamespace(name string) error {
+	if name == "" {
+		return newTypedError(ErrInvalidID, "namespace cannot be empty")
+	}
+	if err := validateID(name); err != nil {
+		return newTypedError(ErrInvalidID, "invalid namespace %q", name)
+	}
+	return nil
+}
+
 func loadContainerConfig(bundle string) (*config.Config, error) {
 	configPath := filepath.Join(bundle, configFilename)
 	return config.Load(configPath)
 }
+
+// frozenConfigOptions read a config frozen by Create.
+var frozenConfigOptions = config.Options{MaxSize: -1}
+
+// loadFrozenConfig reads the config copied into the container root at
+// create time. Containers created before configs were frozen get one
+// when their root is migrated; name, the container's namespace and ID,
+// is for resolving configs frozen before they were resolved.
+func loadFrozenConfig(containerRoot, bundle, name string) (*config.Config, error) {
+	frozenPath := filepath.Join(containerRoot, configFilename)
+	// Its size was checked against the limit in force at create time
+	cfg, err := config.LoadWithOptions(frozenPath, bundle, frozenConfigOptions)
+	if err != nil {
+		return nil, err
+	}
+	if err := resolveConfig(cfg, name); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// resolveConfig converts the spec of a config frozen before the resolved
+// form was, as create would have.
+func resolveConfig(cfg *config.Config, name string) error {
+	if cfg.Resolved != nil {
+		return nil
+	}
+	resolved, err := specconv.Convert(cfg.Spec, specconv.Options{Bundle: cfg.Bundle, CgroupName: name})
+	if err != nil {
+		return fmt.Errorf("failed to resolve config: %w", err)
+	}
+	cfg.Resolved = resolved
+	cfg.Rootfs = resolved.Rootfs
+	return nil
+}
@@ -0,0 +1,110 @@
+package libcontainer
+
+import (
+	"os"
+	"sort"
+
+	"github.com/opencontainers/runtime-spec/specs-go/features"
+	"github.com/zakarynichols/hackontainer/libcontainer/specconv"
+	"github.com/zakarynichols/hackontainer/libcontainer/systemd"
+	"github.com/zakarynichols/hackontainer/version"
+	"golang.org/x/sys/unix"
+)
+
+// Annotations of the features document.
+const (
+	featuresVersionAnnotation       = "org.hackontainer.version"
+	featuresCgroupVersionAnnotation = "org.hackontainer.cgroup-version"
+)
+
+// hookKinds are the hooks the runtime runs, in the order it runs them.
+var hookKinds = []string{
+	HookPrestart,
+	HookCreateRuntime,
+	HookCreateContainer,
+	HookStartContainer,
+	HookPoststart,
+	HookPoststop,
+}
+
+// Features describes what the runtime supports on this host, as the
+// OCI features document. What depends on the host is probed: the
+// namespaces are those a clone can create for the calling user, the
+// cgroup support is that of the hierarchy mounted, and the systemd
+// driver is reported where systemd runs. Confinement the runtime
+// doesn't apply is reported disabled; seccomp profiles are still
+// validated, so the flags it knows and those the kernel takes are listed.
+func Features() *features.Features {
+	v2 := isCgroup2UnifiedMode()
+	cgroupVersion := "1"
+	if v2 {
+		cgroupVersion = "2"
+	}
+
+	return &features.Features{
+		OCIVersionMin: "1.0.0",
+		OCIVersionMax: version.OCIVersion,
+		Hooks:         hookKinds,
+		MountOptions:  specconv.MountOptions(),
+		Linux: &features.Linux{
+			Namespaces: availableNamespaces(),
+			Cgroup: &features.Cgroup{
+				V1:          boolPtr(!v2 && cgroupV1Mounted()),
+				V2:          boolPtr(v2),
+				Systemd:     boolPtr(systemd.Booted()),
+				SystemdUser: boolPtr(false),
+				Rdma:        boolPtr(false),
+			},
+			Seccomp: &features.Seccomp{
+				Enabled:        boolPtr(false),
+				KnownFlags:     knownSeccompFlags(),
+				SupportedFlags: supportedSeccompFlags(),
+			},
+			Apparmor: &features.Apparmor{Enabled: boolPtr(false)},
+			Selinux:  &features.Selinux{Enabled: boolPtr(false)},
+			IntelRdt: &features.IntelRdt{Enabled: boolPtr(false)},
+			MountExtensions: &features.MountExtensions{
+				IDMap: &features.IDMap{Enabled: boolPtr(false)},
+			},
+			NetDevices: &features.NetDevices{Enabled: boolPtr(false)},
+		},
+		Annotations: map[string]string{
+			featuresVersionAnnotation:       version.Version,
+			featuresCgroupVersionAnnotation: cgroupVersion,
+		},
+		PotentiallyUnsafeConfigAnnotations: []string{"org.hackontainer."},
+	}
+}
+
+// availableNamespaces lists, sorted, the namespace types a clone can
+// create. Without root they are probed inside a new user namespace, as
+// an unprivileged create makes them.
+func availableNamespaces() []string {
+	var userFlag uintptr
+	if os.Geteuid() != 0 {
+		userFlag = unix.CLONE_NEWUSER
+	}
+
+	var namespaces []string
+	for nsType, flags := range nsCloneFlags {
+		if probeNamespace(nsType, flags|userFlag) == nil {
+			namespaces = append(namespaces, string(nsType))
+		}
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// cgroupV1Mounted reports whether a v1 hierarchy is mounted under the
+// cgroup root.
+func cgroupV1Mounted() bool {
+	var st unix.Statfs_t
+	if err := unix.Statfs(cgroupRoot, &st); err != nil {
+		return false
+	}
+	return st.Type == unix.TMPFS_MAGIC
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
@@ -0,0 +1,209 @@
+package libcontainer
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/zakarynichols/hackontainer/api/types"
+)
+
+// runtimeCgroupName is the cgroup monitors move themselves into, so the
+// runtime's own usage is neither charged to a container nor lost in
+// whatever cgroup started it. It is shared by every monitor under it
+// and never removed, so it can be given limits of its own.
+const runtimeCgroupName = "hackontainer-runtime"
+
+// Footprint is the runtime's own overhead for one container.
+type Footprint = types.Footprint
+
+// FootprintReport is the runtime's overhead across a root.
+type FootprintReport = types.FootprintReport
+
+// joinRuntimeCgroup moves the calling monitor into the runtime cgroup:
+// below the cgroup root where that is writable, otherwise below the
+// monitor's own cgroup if that was delegated. A container without
+// cgroups leaves the monitor where it is.
+func (c *linuxContainer) joinRuntimeCgroup() error {
+	if c.config.Resolved.CgroupsPath == "" {
+		return nil
+	}
+
+	path := "/" + runtimeCgroupName
+	if cgroupWritable("/") != nil {
+		current, err := currentCgroup()
+		if err != nil {
+			return err
+		}
+		if err := cgroupWritable(current); err != nil {
+			return fmt.Errorf("no writable cgroup for the monitor: %w", err)
+		}
+		path = filepath.Join(current, runtimeCgroupName)
+	}
+
+	return cgroupManagerAt(path, RetryPolicy{}).Apply(os.Getpid())
+}
+
+// footprint measures what the runtime costs for the container. pid is
+// the running container process, or 0.
+func (c *linuxContainer) footprint(state *State, pid int) *Footprint {
+	fp := &Footprint{LogBytes: make(map[string]int64)}
+	if info, err := os.Stat(filepath.Join(c.root, monitorLogFilename)); err == nil {
+		fp.LogBytes[monitorLogFilename] = info.Size()
+	}
+
+	if state.MonitorPid > 0 {
+		if rss, err := processRSS(state.MonitorPid); err == nil {
+			fp.MonitorPid = state.MonitorPid
+			fp.MonitorRSSBytes = rss
+			if fds, err := os.ReadDir(fmt.Sprintf("/proc/%d/fd", state.MonitorPid)); err == nil {
+				fp.MonitorFDs = len(fds)
+			}
+			if cgroup, err := processCgroup(strconv.Itoa(state.MonitorPid)); err == nil {
+				fp.MonitorCgroup = cgroup
+			}
+		}
+	}
+
+	if pid > 0 {
+		inodes := make(map[uint64]bool)
+		for _, info := range c.namespaceInfo(pid) {
+			if info.Mode == NamespaceCreated && info.Known {
+				inodes[info.Inode] = true
+			}
+		}
+		fp.PinnedNamespaceMounts = pinnedNamespaceMounts(inodes)
+	}
+	return fp
+}
+
+// processRSS returns the resident set size of pid.
+func processRSS(pid int) (uint64, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// VmRSS:	    1234 kB
+		value, ok := strings.CutPrefix(scanner.Text(), "VmRSS:")
+		if !ok {
+			continue
+		}
+		kb, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimSpace(value), " kB"), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid VmRSS %q", value)
+		}
+		return kb * 1024, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	// Kernel threads and zombies have no VmRSS
+	return 0, fmt.Errorf("process %d has no resident set", pid)
+}
+
+// pinnedNamespaceMounts counts the nsfs mounts of the namespaces whose
+// inodes are given.
+func pinnedNamespaceMounts(inodes map[uint64]bool) int {
+	if len(inodes) == 0 {
+		return 0
+	}
+	mounts, err := selfMountinfo()
+	if err != nil {
+		return 0
+	}
+
+	count := 0
+	for _, m := range mounts {
+		if m.fstype != "nsfs" {
+			continue
+		}
+		// The root of an nsfs mount names the namespace: net:[4026531840]
+		_, ino, ok := strings.Cut(m.root, ":[")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.ParseUint(strings.TrimSuffix(ino, "]"), 10, 64); err == nil && inodes[n] {
+			count++
+		}
+	}
+	return count
+}
+
+// ReportFootprint measures the runtime's overhead for every container
+// directly under root, or with allNamespaces in every namespace under it
+// too.
+func ReportFootprint(root string, allNamespaces bool) (*FootprintReport, error) {
+	report := &FootprintReport{
+		SchemaVersion: types.SchemaVersion,
+		Containers:    []types.ContainerFootprint{},
+	}
+	roots, err := scopeRoots(root, allNamespaces)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range roots {
+		if err := addFootprints(report, dir); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// addFootprints adds the containers directly under dir, and the size of
+// its events log, to report.
+func addFootprints(report *FootprintReport, dir string) error {
+	if info, err := os.Stat(filepath.Join(dir, eventsFilename)); err == nil {
+		report.Total.EventsLogBytes += info.Size()
+	}
+
+	containers, err := containerRoots(dir)
+	if err != nil {
+		return err
+	}
+	for _, containerRoot := range containers {
+		c, err := loadContainer(containerRoot, WithoutNamespaceCheck())
+		if err != nil {
+			continue
+		}
+		state, err := c.State()
+		if err != nil {
+			continue
+		}
+		pid := 0
+		if state.Status == Running || state.Status == Paused {
+			pid = state.Pid
+		}
+
+		fp := c.footprint(state, pid)
+		report.Containers = append(report.Containers, types.ContainerFootprint{
+			ID:        state.ID,
+			Namespace: state.Namespace,
+			Footprint: *fp,
+		})
+
+		total := &report.Total
+		total.Containers++
+		if fp.MonitorPid > 0 {
+			total.Monitors++
+		}
+		total.MonitorRSSBytes += fp.MonitorRSSBytes
+		total.MonitorFDs += fp.MonitorFDs
+		total.PinnedNamespaceMounts += fp.PinnedNamespaceMounts
+		for _, size := range fp.LogBytes {
+			total.LogBytes += size
+		}
+	}
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
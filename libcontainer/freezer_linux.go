@@ -0,0 +1,293 @@
+package libcontainer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	// errFreezerUnavailable is returned by Freeze without a freezer: a
+	// cgroup v1 host without the freezer hierarchy, a kernel older than
+	// 5.2 on cgroup v2, or a container without cgroups.
+	errFreezerUnavailable = errors.New("cgroup freezer unavailable")
+	// errCgroupKillUnavailable is returned by Kill without cgroup.kill,
+	// which needs cgroup v2 and kernel 5.14.
+	errCgroupKillUnavailable = errors.New("cgroup.kill unavailable")
+)
+
+const (
+	// freezeTimeout bounds the wait for every task to stop. Tasks in
+	// uninterruptible sleep can hold a freeze up indefinitely.
+	freezeTimeout      = 5 * time.Second
+	freezePollInterval = 10 * time.Millisecond
+
+	// signalAllRounds bounds how often signalAll rereads the cgroup when
+	// it has to signal without freezing it.
+	signalAllRounds = 10
+)
+
+// Pause freezes every process of a running container through the cgroup
+// freezer: freezer.state on cgroup v1, cgroup.freeze on v2. They keep
+// their memory but get no CPU time, and take signals other than SIGKILL
+// only once resumed. A paused container can't be started, deleted or
+// exec'd into until Resume.
+func (c *linuxContainer) Pause() (retErr error) {
+	defer func() { c.audit(AuditPause, nil, retErr) }()
+
+	unlock, err := c.lock()
+	if os.IsNotExist(err) {
+		return newTypedError(ErrNotExist, "container %q does not exist", c.id)
+	}
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := c.State()
+	if err != nil {
+		return fmt.Errorf("failed to get container state: %w", err)
+	}
+	switch state.Status {
+	case Running:
+	case Paused:
+		return newTypedError(ErrInvalidState, "container is already paused")
+	default:
+		return newTypedError(ErrNotRunning, "cannot pause a container that is %s", state.Status)
+	}
+
+	m := c.cgroupManager()
+	if err := m.Freeze(true); err != nil {
+		return fmt.Errorf("failed to pause container: %w", err)
+	}
+	state.Status = Paused
+	if err := c.saveState(state); err != nil {
+		_ = m.Freeze(false)
+		return fmt.Errorf("failed to save container state: %w", err)
+	}
+	c.emit(EventPause, nil)
+	return nil
+}
+
+// Resume thaws a container Pause froze and marks it running again.
+func (c *linuxContainer) Resume() (retErr error) {
+	defer func() { c.audit(AuditResume, nil, retErr) }()
+
+	unlock, err := c.lock()
+	if os.IsNotExist(err) {
+		return newTypedError(ErrNotExist, "container %q does not exist", c.id)
+	}
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := c.State()
+	if err != nil {
+		return fmt.Errorf("failed to get container state: %w", err)
+	}
+	if state.Status != Paused {
+		return newTypedError(ErrInvalidState, "cannot resume a container that is %s, not paused", state.Status)
+	}
+
+	if err := c.cgroupManager().Freeze(false); err != nil {
+		return fmt.Errorf("failed to resume container: %w", err)
+	}
+	state.Status = Running
+	if err := c.saveState(state); err != nil {
+		return fmt.Errorf("failed to save container state: %w", err)
+	}
+	c.emit(EventResume, nil)
+	return nil
+}
+
+// signalAll delivers sig to every process in the container's cgroup. One
+// PID at a time, that races with a container forking faster than it is
+// signalled, so the cgroup is frozen while it is read and signalled.
+// SIGKILL goes through cgroup.kill instead where the kernel has it,
+// which kills the whole cgroup at once.
+//
+// Without a freezer the cgroup is reread and the new processes
+// signalled until a round finds none, at most signalAllRounds times. A
+// process forked between the last read and its signal escapes, so a
+// fork bomb can still outrun this; that is reported as an error.
+func (c *linuxContainer) signalAll(sig unix.Signal) error {
+	m := c.cgroupManager()
+
+	if sig == unix.SIGKILL {
+		err := m.Kill()
+		if err == nil || !errors.Is(err, errCgroupKillUnavailable) {
+			return err
+		}
+	}
+
+	err := m.Freeze(true)
+	if errors.Is(err, errFreezerUnavailable) {
+		return signalUntilSettled(hostSys, m, sig)
+	}
+	if err != nil {
+		return err
+	}
+
+	// A frozen cgroup can't fork, so this sees every process
+	pids, err := m.Pids()
+	if err == nil {
+		signalPids(hostSys, pids, sig)
+	}
+	// Frozen tasks act on their signals, SIGKILL included, once thawed
+	if thawErr := m.Freeze(false); thawErr != nil && err == nil {
+		err = thawErr
+	}
+	return err
+}
+
+// signalGroup is signalAll for a container without a cgroup: sig goes
+// to the process group its init, pid, leads. Processes that left the
+// group, such as daemons that called setsid, are out of its reach.
+func signalGroup(s sysCalls, pid int, sig unix.Signal) error {
+	err := s.Kill(-pid, sig)
+	if errors.Is(err, unix.ESRCH) && s.Kill(pid, 0) == nil {
+		return fmt.Errorf("the container runs without cgroups and its init leads no process group to signal")
+	}
+	return err
+}
+
+// signalUntilSettled is signalAll without a freezer.
+func signalUntilSettled(s sysCalls, m CgroupManager, sig unix.Signal) error {
+	signalled := make(map[int]bool)
+	for round := 0; round < signalAllRounds; round++ {
+		pids, err := m.Pids()
+		if err != nil {
+			return err
+		}
+		var fresh []int
+		for _, pid := range pids {
+			if !signalled[pid] {
+				signalled[pid] = true
+				fresh = append(fresh, pid)
+			}
+		}
+		if len(fresh) == 0 {
+			return nil
+		}
+		signalPids(s, fresh, sig)
+	}
+	return fmt.Errorf("new processes kept appearing after %d rounds of signalling; without a freezer a container forking this fast can't be signalled reliably", signalAllRounds)
+}
+
+// signalPids signals pids, skipping those that exited meanwhile.
+func signalPids(s sysCalls, pids []int, sig unix.Signal) {
+	for _, pid := range pids {
+		_ = s.Kill(pid, sig)
+	}
+}
+
+// readCgroupPids parses the cgroup.procs file of dir.
+func readCgroupPids(dir string) ([]int, error) {
+	data, err := readCgroupFile(dir, "cgroup.procs")
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, field := range strings.Fields(data) {
+		pid, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pid %q in %s/cgroup.procs", field, dir)
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+// waitFrozen polls until done reports that every task stopped.
+func waitFrozen(done func() (bool, error)) error {
+	deadline := time.Now().Add(freezeTimeout)
+	for {
+		frozen, err := done()
+		if err != nil || frozen {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("cgroup not frozen after %s", freezeTimeout)
+		}
+		time.Sleep(freezePollInterval)
+	}
+}
+
+func (m *cgroupV1Manager) Pids() ([]int, error) {
+	paths := m.Paths()
+	// Every hierarchy holds the same processes
+	for _, subsystem := range cgroupV1Subsystems {
+		if dir := paths[subsystem]; dir != "" {
+			return readCgroupPids(dir)
+		}
+	}
+	return nil, fmt.Errorf("no cgroup hierarchy mounted")
+}
+
+func (m *cgroupV1Manager) Freeze(frozen bool) error {
+	dir := m.Paths()["freezer"]
+	if dir == "" {
+		return errFreezerUnavailable
+	}
+	if !frozen {
+		return writeCgroupFile(dir, "freezer.state", "THAWED")
+	}
+
+	if err := writeCgroupFile(dir, "freezer.state", "FROZEN"); err != nil {
+		return err
+	}
+	err := waitFrozen(func() (bool, error) {
+		state, err := readCgroupFile(dir, "freezer.state")
+		return state == "FROZEN", err
+	})
+	if err != nil {
+		_ = writeCgroupFile(dir, "freezer.state", "THAWED")
+	}
+	return err
+}
+
+func (m *cgroupV1Manager) Kill() error {
+	return errCgroupKillUnavailable
+}
+
+func (m *cgroupV2Manager) Pids() ([]int, error) {
+	return readCgroupPids(m.path)
+}
+
+func (m *cgroupV2Manager) Freeze(frozen bool) error {
+	if !fileExists(filepath.Join(m.path, "cgroup.freeze")) {
+		return errFreezerUnavailable
+	}
+	if !frozen {
+		return writeCgroupFile(m.path, "cgroup.freeze", "0")
+	}
+
+	if err := writeCgroupFile(m.path, "cgroup.freeze", "1"); err != nil {
+		return err
+	}
+	err := waitFrozen(func() (bool, error) {
+		value := readCgroupKey(m.path, "cgroup.events", "frozen")
+		if value == nil {
+			return false, fmt.Errorf("no frozen key in %s/cgroup.events", m.path)
+		}
+		return *value == 1, nil
+	})
+	if err != nil {
+		_ = writeCgroupFile(m.path, "cgroup.freeze", "0")
+	}
+	return err
+}
+
+func (m *cgroupV2Manager) Kill() error {
+	if !fileExists(filepath.Join(m.path, "cgroup.kill")) {
+		return errCgroupKillUnavailable
+	}
+	return writeCgroupFile(m.path, "cgroup.kill", "1")
+}
@@ -0,0 +1,51 @@
+package libcontainer
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// CollectGarbage deletes the containers directly under root, or with
+// allNamespaces those in every namespace under it too, whose process was
+// started before the host last rebooted. Only a root on persistent
+// storage has any. It returns what was deleted, as IDs prefixed with
+// their namespace, and carries on past containers that can't be deleted.
+func CollectGarbage(root string, allNamespaces bool) ([]string, error) {
+	roots, err := scopeRoots(root, allNamespaces)
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []string
+	var errs []error
+	for _, dir := range roots {
+		containers, err := containerRoots(dir)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, containerRoot := range containers {
+			c, err := loadContainer(containerRoot, WithoutNamespaceCheck())
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			state, err := c.loadState()
+			if err != nil || !fromPreviousBoot(state) {
+				continue
+			}
+
+			name := state.ID
+			if state.Namespace != "" {
+				name = filepath.Join(state.Namespace, state.ID)
+			}
+			if err := c.Delete(); err != nil {
+				errs = append(errs, fmt.Errorf("failed to delete %s: %w", name, err))
+				continue
+			}
+			deleted = append(deleted, name)
+		}
+	}
+	return deleted, errors.Join(errs...)
+}
@@ -0,0 +1,134 @@
+package libcontainer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/zakarynichols/hackontainer/version"
+)
+
+// Hook names as they appear in the spec.
+const (
+	HookPrestart        = "prestart"
+	HookCreateRuntime   = "createRuntime"
+	HookCreateContainer = "createContainer"
+	HookStartContainer  = "startContainer"
+	HookPoststart       = "poststart"
+	HookPoststop        = "poststop"
+)
+
+// Variables describing the container that hooks and the monitor get
+// besides the state on stdin, for those that want its cgroup or rootfs
+// without parsing it.
+const (
+	HookEnvID     = internalEnvPrefix + "ID"
+	HookEnvPid    = internalEnvPrefix + "PID"
+	HookEnvCgroup = internalEnvPrefix + "CGROUP"
+	HookEnvRootfs = internalEnvPrefix + "ROOTFS"
+	HookEnvBundle = internalEnvPrefix + "BUNDLE"
+)
+
+// hookEnv returns the HookEnv variables for a container in state with
+// the given cgroup and rootfs, as state and inspect report them. The
+// cgroup is relative to the root of the cgroup filesystem, or empty
+// for a container without one.
+func hookEnv(state *specs.State, cgroup, rootfs string) []string {
+	return []string{
+		HookEnvID + "=" + state.ID,
+		HookEnvPid + "=" + strconv.Itoa(state.Pid),
+		HookEnvCgroup + "=" + cgroup,
+		HookEnvRootfs + "=" + rootfs,
+		HookEnvBundle + "=" + state.Bundle,
+	}
+}
+
+// hookEnv returns the HookEnv variables of the container in state.
+func (c *linuxContainer) hookEnv(state *specs.State) []string {
+	return hookEnv(state, c.config.Resolved.CgroupsPath, c.config.Rootfs)
+}
+
+// hookState is the state passed to hooks on stdin.
+func (c *linuxContainer) hookState(status specs.ContainerState, pid int) *specs.State {
+	return &specs.State{
+		Version:     version.OCIVersion,
+		ID:          c.id,
+		Status:      status,
+		Pid:         pid,
+		Bundle:      c.bundle,
+		Annotations: c.config.Annotations,
+	}
+}
+
+// runHooks runs the named hooks in order, stopping at the first failure.
+// Each gets state on stdin and its own env with env, the container's
+// HookEnv variables, set over it. A hook still running when ctx is done
+// is killed.
+func runHooks(ctx context.Context, name string, hooks []specs.Hook, state *specs.State, env []string) error {
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	for i, hook := range hooks {
+		if err := runHook(ctx, hook, data, env); err != nil {
+			return fmt.Errorf("%s hook #%d (%s): %w", name, i, hook.Path, err)
+		}
+	}
+	return nil
+}
+
+func runHook(ctx context.Context, hook specs.Hook, state []byte, env []string) error {
+	hookCtx := ctx
+	if hook.Timeout != nil {
+		if *hook.Timeout <= 0 {
+			return fmt.Errorf("timeout must be positive, got %d", *hook.Timeout)
+		}
+		var cancel context.CancelFunc
+		hookCtx, cancel = context.WithTimeout(ctx, time.Duration(*hook.Timeout)*time.Second)
+		defer cancel()
+	}
+
+	args := hook.Args
+	if len(args) == 0 {
+		args = []string{hook.Path}
+	}
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(hookCtx, hook.Path)
+	cmd.Args = args
+	// Hooks get exactly the environment the spec gives them, and the
+	// runtime's description of the container, never its own environment
+	cmd.Env = mergeEnv(hook.Env, env)
+	if cmd.Env == nil {
+		cmd.Env = []string{}
+	}
+	cmd.Stdin = bytes.NewReader(state)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if hookCtx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %ds", *hook.Timeout)
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(output.String()); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
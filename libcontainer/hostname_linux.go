@@ -0,0 +1,47 @@
+package libcontainer
+
+import (
+	"fmt"
+	"os"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// utsNamespace identifies the UTS namespace of the calling process by
+// its /proc/self/ns link, such as "uts:[4026531838]".
+func utsNamespace() (string, error) {
+	return os.Readlink("/proc/self/ns/uts")
+}
+
+// setHostname sets the container's hostname through s from the child,
+// whose UTS namespace own must not be runtimeUTS, the one the runtime
+// runs in: whatever the config says, a child that is still there would
+// rename the host.
+func setHostname(s sysCalls, name, own, runtimeUTS string) error {
+	if own == runtimeUTS {
+		return fmt.Errorf("refusing to set the hostname: the container shares the runtime's UTS namespace %s", runtimeUTS)
+	}
+	if err := s.Sethostname([]byte(name)); err != nil {
+		return fmt.Errorf("failed to set hostname: %w", err)
+	}
+	return nil
+}
+
+// hostname returns the hostname the process pid sees, read from inside
+// its UTS namespace; the hostname file of its /proc would give the
+// reader's own. It returns "" if the namespace can't be entered.
+func hostname(pid int) string {
+	startTime, err := getProcessStartTime(pid)
+	if err != nil {
+		return ""
+	}
+	var uts unix.Utsname
+	err = threadNSDo(pid, startTime, specs.UTSNamespace, func() error {
+		return unix.Uname(&uts)
+	})
+	if err != nil {
+		return ""
+	}
+	return unix.ByteSliceToString(uts.Nodename[:])
+}
@@ -1,48 +1,62 @@
 package libcontainer
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 	"syscall"
 
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/zakarynichols/hackontainer/config"
+	"github.com/zakarynichols/hackontainer/libcontainer/rootfsfile"
 	"golang.org/x/sys/unix"
 )
 
-func mount(source, target, fstype string, flags uintptr, data string) error {
-	if err := unix.Mount(source, target, fstype, flags, data); err != nil {
+func mount(s sysCalls, source, target, fstype string, flags uintptr, data string) error {
+	if err := s.Mount(source, target, fstype, flags, data); err != nil {
 		return &os.PathError{Op: "mount", Path: target, Err: err}
 	}
 	return nil
 }
 
-func unmount(target string, flags int) error {
-	if err := unix.Unmount(target, flags); err != nil {
+func unmount(s sysCalls, target string, flags int) error {
+	if err := s.Unmount(target, flags); err != nil {
 		return &os.PathError{Op: "unmount", Path: target, Err: err}
 	}
 	return nil
 }
 
-func prepareRoot(rootfs string) error {
-	if err := mount("", "/", "", unix.MS_PRIVATE|unix.MS_REC, ""); err != nil {
+// prepareRoot makes rootfs a mount point pivotRoot can use. A pinned
+// rootfs arrives as a mount tree instead, which is attached in its place.
+func prepareRoot(s sysCalls, rootfs string, tree *os.File) error {
+	if err := mount(s, "", "/", "", unix.MS_PRIVATE|unix.MS_REC, ""); err != nil {
 		return fmt.Errorf("failed to make root mount private: %w", err)
 	}
 
 	flag := unix.MS_SLAVE | unix.MS_REC
-	if err := mount("", "/", "", uintptr(flag), ""); err != nil {
+	if err := mount(s, "", "/", "", uintptr(flag), ""); err != nil {
 		return fmt.Errorf("failed to make root mount slave: %w", err)
 	}
 
-	if err := mount(rootfs, rootfs, "bind", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+	if tree != nil {
+		return attachRootfs(tree)
+	}
+
+	if err := mount(s, rootfs, rootfs, "bind", unix.MS_BIND|unix.MS_REC, ""); err != nil {
 		return fmt.Errorf("failed to bind mount rootfs: %w", err)
 	}
 
 	return nil
 }
 
-func pivotRoot(rootfs string) error {
+func pivotRoot(s sysCalls, rootfs string) error {
 	oldroot, err := unix.Open("/", unix.O_DIRECTORY|unix.O_RDONLY, 0)
 	if err != nil {
 		return fmt.Errorf("failed to open old root: %w", err)
@@ -55,186 +69,629 @@ func pivotRoot(rootfs string) error {
 	}
 	defer unix.Close(newroot)
 
-	if err := unix.Fchdir(newroot); err != nil {
+	if err := s.Fchdir(newroot); err != nil {
 		return fmt.Errorf("failed to fchdir to new root: %w", err)
 	}
 
-	if err := unix.PivotRoot(".", "."); err != nil {
-		return fmt.Errorf("failed to pivot_root: %w", err)
+	if err := s.PivotRoot(".", "."); err != nil {
+		// What the preconditions don't explain is a root that can't be
+		// left, such as the initramfs
+		if errors.Is(err, unix.EINVAL) {
+			return fmt.Errorf("%w (try --no-pivot)", err)
+		}
+		return err
 	}
 
-	if err := unix.Fchdir(oldroot); err != nil {
+	if err := s.Fchdir(oldroot); err != nil {
 		return fmt.Errorf("failed to fchdir to old root: %w", err)
 	}
 
-	if err := mount("", ".", "", unix.MS_SLAVE|unix.MS_REC, ""); err != nil {
+	if err := mount(s, "", ".", "", unix.MS_SLAVE|unix.MS_REC, ""); err != nil {
 		return fmt.Errorf("failed to make old root slave: %w", err)
 	}
 
-	if err := unmount(".", unix.MNT_DETACH); err != nil {
+	if err := unmount(s, ".", unix.MNT_DETACH); err != nil {
 		return fmt.Errorf("failed to unmount old root: %w", err)
 	}
 
-	if err := unix.Chdir("/"); err != nil {
+	if err := s.Chdir("/"); err != nil {
 		return fmt.Errorf("failed to chdir to new root: %w", err)
 	}
 
 	return nil
 }
 
-func setupRootfs(container *linuxContainer) error {
-	if err := prepareRoot(container.config.Rootfs); err != nil {
-		return fmt.Errorf("failed to prepare root: %w", err)
+// setupRootfs prepares the container's root and pivots into it, making
+// its system calls through s. tree is the pinned rootfs, if any. enter is told of each phase as it starts,
+// and beforePivot runs once the mounts and devices are in place.
+func setupRootfs(container *linuxContainer, s sysCalls, tree *os.File, enter func(Phase), beforePivot func() error) error {
+	enter(PhaseRootfs)
+	if err := prepareRoot(s, container.config.Rootfs, tree); err != nil {
+		return &StartError{Phase: PhaseRootfs, Err: fmt.Errorf("failed to prepare root: %w", err)}
+	}
+
+	// Opened once the rootfs is a mount, so paths resolve inside that
+	root, err := rootfsfile.Open(container.config.Rootfs)
+	if err != nil {
+		return &StartError{Phase: PhaseRootfs, Err: fmt.Errorf("failed to open rootfs: %w", err)}
+	}
+	defer root.Close()
+	fmt.Fprintf(os.Stderr, ">>> [CHILD] Resolving paths in the rootfs with %s\n", root.Mechanism())
+
+	enter(PhaseMounts)
+	mounts := newMountManager(container, root, s)
+	if err := mounts.Setup(); err != nil {
+		return &StartError{Phase: PhaseMounts, Err: err}
 	}
 
-	if err := unix.Chdir(container.config.Rootfs); err != nil {
-		return fmt.Errorf("failed to chdir to rootfs: %w", err)
+	enter(PhaseDevices)
+	if err := createDevices(s, root, container.config.Resolved, inUserNamespace(container.config.Resolved.Namespaces)); err != nil {
+		return &StartError{Phase: PhaseDevices, Err: err}
+	}
+
+	if err := beforePivot(); err != nil {
+		return startPhase(PhaseHooks, err)
+	}
+
+	enter(PhaseRootfs)
+	// A pinned rootfs is a mount over / by construction
+	if tree == nil && !container.config.NoPivotRoot {
+		if err := ensurePivotable(s, container.config.Rootfs); err != nil {
+			return &StartError{Phase: PhaseRootfs, Err: err}
+		}
+	}
+	if err := s.Chdir(container.config.Rootfs); err != nil {
+		return &StartError{Phase: PhaseRootfs, Err: fmt.Errorf("failed to chdir to rootfs: %w", err)}
+	}
+
+	if container.config.NoPivotRoot {
+		fmt.Fprintf(os.Stderr, ">>> [CHILD] Moving the rootfs onto / instead of pivoting\n")
+		if err := moveRoot(s, container.config.Rootfs); err != nil {
+			return &StartError{Phase: PhaseRootfs, Err: err}
+		}
+	} else if err := pivotRoot(s, container.config.Rootfs); err != nil {
+		return &StartError{Phase: PhaseRootfs, Err: fmt.Errorf("failed to pivot_root: %w", err)}
 	}
 
-	if err := pivotRoot(container.config.Rootfs); err != nil {
-		return fmt.Errorf("failed to pivot_root: %w", err)
+	// Specs without a /proc mount still get one
+	if !mounts.hasMount("/proc") {
+		if err := os.MkdirAll("/proc", 0755); err != nil {
+			return &StartError{Phase: PhaseMounts, Err: fmt.Errorf("failed to create /proc directory: %w", err)}
+		}
+		if err := mount(s, "proc", "/proc", "proc", unix.MS_NOSUID|unix.MS_NOEXEC|unix.MS_NODEV, ""); err != nil {
+			return &StartError{Phase: PhaseMounts, Err: fmt.Errorf("failed to mount /proc: %w", err)}
+		}
 	}
 
-	if err := os.MkdirAll("/proc", 0755); err != nil {
-		return fmt.Errorf("failed to create /proc directory: %w", err)
+	// Most of these paths are in /proc and /sys, so this waits for them
+	if linux := container.config.Linux; linux != nil {
+		newRoot, err := rootfsfile.Open("/")
+		if err != nil {
+			return &StartError{Phase: PhaseRootfs, Err: err}
+		}
+		defer newRoot.Close()
+		if err := readonlyPaths(s, newRoot, linux.ReadonlyPaths); err != nil {
+			return &StartError{Phase: PhaseRootfs, Err: err}
+		}
+		if err := maskPaths(s, newRoot, linux.MaskedPaths); err != nil {
+			return &StartError{Phase: PhaseRootfs, Err: err}
+		}
 	}
 
-	if err := unix.Mount("proc", "/proc", "proc", unix.MS_NOSUID|unix.MS_NOEXEC|unix.MS_NODEV, ""); err != nil {
-		return fmt.Errorf("failed to mount /proc: %w", err)
+	// Last, since the steps above may create mountpoints on it
+	if root := container.config.Root; root != nil && root.Readonly {
+		if err := readonlyRoot(s); err != nil {
+			return &StartError{Phase: PhaseRootfs, Err: err}
+		}
 	}
 
 	return nil
 }
 
-// RunAsChild is called by main() when --child flag is detected
-// This runs in the forked child process to set up and exec the container
-func RunAsChild(bundle string) error {
-	cfg, err := loadContainerConfig(bundle)
-	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
+// initArg is the argument newInitProcess re-executes the runtime with.
+// The init stage is part of the runtime binary, so running a container
+// needs nothing installed besides it.
+const initArg = "--child"
+
+// The sync socket and the frozen config are handed to the container
+// process as its first extra files, followed by a pinned rootfs and the
+// exec fifo, in that order, each only if there is one. Preserved fds go
+// before all of them, moving each up by their number.
+const (
+	initSyncFd   = 3
+	initConfigFd = 4
+	initRootfsFd = 5
+)
+
+// initArgs is the command line newInitProcess starts the container
+// process with, execFifoFd being -1 without an exec fifo. RunInit
+// parses it.
+func initArgs(execPath, bundle, configPath string, preserveFDs int, pinnedRootfs bool, execFifoFd int) []string {
+	args := []string{
+		execPath, initArg,
+		"--bundle", bundle,
+		"--config", configPath,
+		"--sync-fd", strconv.Itoa(initSyncFd + preserveFDs),
+		"--config-fd", strconv.Itoa(initConfigFd + preserveFDs),
 	}
+	if preserveFDs > 0 {
+		args = append(args, preserveFDsArg, strconv.Itoa(preserveFDs))
+	}
+	if pinnedRootfs {
+		args = append(args, "--rootfs-fd", strconv.Itoa(initRootfsFd+preserveFDs))
+	}
+	if execFifoFd >= 0 {
+		args = append(args, "--exec-fifo-fd", strconv.Itoa(execFifoFd))
+	}
+	return args
+}
 
-	if err := cfg.NormalizeRoot(); err != nil {
-		return err
+// IsInit reports whether args, as in os.Args, are those of a container
+// process started by newInitProcess.
+func IsInit(args []string) bool {
+	return len(args) > 1 && args[1] == initArg
+}
+
+// RunInit runs the init stage in the container process: it sets up the
+// container from inside its namespaces and execs the container's
+// process, so it only returns on failure. args are the process's
+// os.Args. The frozen config is opened by the parent, since the child
+// may run as a user namespace root that can't read it; setup failures
+// are reported to the parent over the sync socket.
+func RunInit(args []string) error {
+	var bundle, configPath string
+	syncFd, configFd, rootfsFd, execFifoFd := -1, -1, -1, -1
+	preserveFDs := 0
+	for i := 2; i+1 < len(args); i += 2 {
+		value := args[i+1]
+		var err error
+		switch args[i] {
+		case "--bundle":
+			bundle = value
+		case "--config":
+			configPath = value
+		case "--sync-fd":
+			syncFd, err = strconv.Atoi(value)
+		case "--config-fd":
+			configFd, err = strconv.Atoi(value)
+		case "--rootfs-fd":
+			rootfsFd, err = strconv.Atoi(value)
+		case "--exec-fifo-fd":
+			execFifoFd, err = strconv.Atoi(value)
+		case preserveFDsArg:
+			preserveFDs, err = strconv.Atoi(value)
+		default:
+			return fmt.Errorf("unknown init argument %q", args[i])
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s %q", args[i], value)
+		}
+	}
+	if configFd < 0 || configPath == "" {
+		return fmt.Errorf("init needs --config and --config-fd")
+	}
+
+	var sync *os.File
+	if syncFd >= 0 {
+		sync = os.NewFile(uintptr(syncFd), "sync")
+	}
+	// The file keeps the path as its name for error messages
+	configFile := os.NewFile(uintptr(configFd), configPath)
+	var rootfs *os.File
+	if rootfsFd >= 0 {
+		rootfs = os.NewFile(uintptr(rootfsFd), "rootfs")
+	}
+	var execFifo *os.File
+	if execFifoFd >= 0 {
+		execFifo = os.NewFile(uintptr(execFifoFd), "exec-fifo")
+	}
+
+	// Kept from the hooks and whatever else the init stage runs until
+	// the container process itself
+	preserved, err := inheritedFiles(preserveFDs)
+	if err == nil {
+		err = runChild(bundle, configFile, rootfs, execFifo, sync, preserved)
+	}
+	if sync != nil {
+		_ = writeSync(sync, errorSync(err))
+	}
+	return err
+}
+
+// runChild sets the container up and execs its process, which inherits
+// preserved. With execFifo, it first waits for start; failures from then
+// on are reported on the fifo, since no one reads the sync socket any
+// more.
+func runChild(bundle string, configFile, rootfs, execFifo, sync *os.File, preserved []*os.File) (retErr error) {
+	// Nothing runs until the parent has put us in the container's cgroup
+	var dec *json.Decoder
+	var hookState *specs.State
+	var cpus, runtimeUTS string
+	if sync != nil {
+		// Inherited fds lose close-on-exec; the parent relies on it to
+		// see the exec
+		unix.CloseOnExec(int(sync.Fd()))
+		dec = json.NewDecoder(sync)
+		msg, err := expectSync(dec, procRun)
+		if err != nil {
+			return &StartError{Phase: PhaseInit, Err: fmt.Errorf("failed to wait for parent: %w", err)}
+		}
+		hookState = msg.State
+		cpus = msg.CPUs
+		runtimeUTS = msg.UTSNamespace
+	}
+
+	cfg, err := config.DecodeWithOptions(configFile, bundle, frozenConfigOptions)
+	configFile.Close()
+	if err != nil {
+		return &StartError{Phase: PhaseInit, Err: fmt.Errorf("failed to load config: %w", err)}
+	}
+	if cfg.Process == nil {
+		return &StartError{Phase: PhaseInit, Err: fmt.Errorf("config has no process")}
 	}
 
 	container := &linuxContainer{
+		id:     filepath.Base(filepath.Dir(configFile.Name())),
+		root:   filepath.Dir(configFile.Name()),
 		config: cfg,
 		bundle: bundle,
 	}
+	if cfg.Resolved == nil {
+		// Frozen before configs were resolved at create; the state has
+		// the namespace the cgroup is named after
+		state, err := container.loadState()
+		if err != nil {
+			return &StartError{Phase: PhaseInit, Err: err}
+		}
+		if err := resolveConfig(cfg, filepath.Join(state.Namespace, container.id)); err != nil {
+			return &StartError{Phase: PhaseInit, Err: err}
+		}
+	}
+	// Hooks are told the rootfs state and inspect report, not the fd
+	// only this process can open it by
+	frozenRootfs := cfg.Rootfs
+	if rootfs != nil {
+		// The container would otherwise inherit the pinned rootfs
+		unix.CloseOnExec(int(rootfs.Fd()))
+		cfg.Rootfs = pinnedRootfsPath(rootfs)
+	}
+	if execFifo != nil {
+		unix.CloseOnExec(int(execFifo.Fd()))
+	}
+	if hookState == nil {
+		hookState = container.hookState(specs.StateCreating, os.Getpid())
+	}
 
 	fmt.Printf(">>> [CHILD] Running in new namespaces, setting up container...\n")
 
+	enter := func(phase Phase) {
+		if sync != nil {
+			_ = writeSync(sync, syncT{Type: procPhase, Phase: phase})
+		}
+	}
+
+	// Joined namespaces are per-thread, so stay on the thread that execs
+	runtime.LockOSThread()
+	if cpus != "" {
+		if err := resetCPUAffinity(cpus); err != nil {
+			return &StartError{Phase: PhaseCgroups, Err: err}
+		}
+	}
+	enter(PhaseNamespaces)
+	if err := joinNamespaces(container.config.Resolved.Namespaces); err != nil {
+		return &StartError{Phase: PhaseNamespaces, Err: err}
+	}
+	if err := unshareCgroupNamespace(container.config.Resolved.Namespaces); err != nil {
+		return &StartError{Phase: PhaseNamespaces, Err: err}
+	}
+	// Read while the host's /proc is still there to read it from
+	var ownUTS string
+	if container.config.Hostname != "" {
+		if ownUTS, err = utsNamespace(); err != nil {
+			return &StartError{Phase: PhaseNamespaces, Err: fmt.Errorf("failed to identify the UTS namespace: %w", err)}
+		}
+	}
+
 	// Step 1: pivot_root
 	fmt.Printf(">>> [CHILD] Calling setupRootfs (pivot_root)...\n")
-	if err := setupRootfs(container); err != nil {
-		return fmt.Errorf("failed to setup rootfs: %w", err)
+	beforePivot := func() error {
+		// The parent runs prestart and createRuntime hooks meanwhile
+		if sync != nil {
+			if err := writeSync(sync, syncT{Type: procHooks}); err != nil {
+				return &StartError{Phase: PhaseInit, Err: err}
+			}
+			if _, err := expectSync(dec, procResume); err != nil {
+				return &StartError{Phase: PhaseInit, Err: err}
+			}
+		}
+		// Paths still resolve on the host until pivot_root
+		if err := runHooks(context.Background(), HookCreateContainer, cfg.Resolved.Hooks[HookCreateContainer], hookState, hookEnv(hookState, cfg.Resolved.CgroupsPath, frozenRootfs)); err != nil {
+			return &StartError{Phase: PhaseHooks, Err: err}
+		}
+		return nil
 	}
-	fmt.Printf(">>> [CHILD] pivot_root completed.\n")
+	if err := setupRootfs(container, hostSys, rootfs, enter, beforePivot); err != nil {
+		return err
+	}
+	fmt.Printf(">>> [CHILD] Root changed to the rootfs.\n")
 
 	// Step 2: Set hostname
 	if container.config.Hostname != "" {
 		fmt.Printf(">>> [CHILD] Setting hostname to: %s\n", container.config.Hostname)
-		if err := unix.Sethostname([]byte(container.config.Hostname)); err != nil {
-			return fmt.Errorf("failed to set hostname: %w", err)
+		if err := setHostname(hostSys, container.config.Hostname, ownUTS, runtimeUTS); err != nil {
+			return &StartError{Phase: PhaseNamespaces, Err: err}
 		}
 	}
 
-	// Step 3: Resolve and exec
+	// Step 3: Working directory, relative to the new root
+	enter(PhaseExec)
+	if err := chdirInRoot(container.config.Process.Cwd); err != nil {
+		return &StartError{Phase: PhaseExec, Err: fmt.Errorf("failed to chdir to process.cwd %q: %w", container.config.Process.Cwd, err)}
+	}
+
+	// Step 4: Resolve and exec
 	args := container.config.Process.Args
 	if len(args) == 0 {
 		args = []string{"/bin/sh"}
 	}
 
+	env := containerEnv(mergeEnv(container.config.Process.Env, inheritedSensitiveEnv()))
 	execPath := args[0]
-	fmt.Printf(">>> [CHILD] Resolving executable: %s\n", execPath)
+	fmt.Printf(">>> [CHILD] Resolving executable: %q\n", execPath)
 	if !filepath.IsAbs(execPath) {
 		containerExecPath := filepath.Join(container.config.Rootfs, execPath)
 		if _, err := os.Stat(containerExecPath); err == nil {
 			execPath = containerExecPath
 		} else {
-			pathValue := ""
-			for _, env := range container.config.Process.Env {
-				if strings.HasPrefix(env, "PATH=") {
-					pathValue = strings.TrimPrefix(env, "PATH=")
-					break
-				}
+			pathValue, _ := lookupEnv(env, "PATH")
+			if pathValue == "" {
+				return &StartError{Phase: PhaseExec, ExitCode: ExitNotFound, Err: fmt.Errorf("no PATH set")}
 			}
-			if pathValue != "" {
-				oldPath := os.Getenv("PATH")
-				os.Setenv("PATH", pathValue)
-				path, err := exec.LookPath(execPath)
-				os.Setenv("PATH", oldPath)
-				if err != nil {
-					return fmt.Errorf("executable %q not found: %w", execPath, err)
-				}
-				execPath = path
-			} else {
-				return fmt.Errorf("no PATH set")
+			path, err := lookPath(execPath, pathValue)
+			if err != nil {
+				return &StartError{Phase: PhaseExec, ExitCode: ExitNotFound, Err: fmt.Errorf("executable %q not found: %w", execPath, err)}
 			}
+			execPath = path
+		}
+	}
+
+	if execFifo != nil {
+		// Set up as far as create goes; the parent's part ends here
+		if err := writeSync(sync, syncT{Type: procReady}); err != nil {
+			return &StartError{Phase: PhaseInit, Err: err}
 		}
+		sync.Close()
+		sync = nil
+		fmt.Printf(">>> [CHILD] Waiting for start...\n")
+		fifo, err := waitForStart(execFifo)
+		if err != nil {
+			return &StartError{Phase: PhaseExec, Err: err}
+		}
+		defer func() {
+			_ = writeSync(fifo, errorSync(retErr))
+			fifo.Close()
+		}()
+	}
+
+	hookState.Status = specs.StateCreated
+	enter(PhaseHooks)
+	if err := runHooks(context.Background(), HookStartContainer, cfg.Resolved.Hooks[HookStartContainer], hookState, hookEnv(hookState, cfg.Resolved.CgroupsPath, frozenRootfs)); err != nil {
+		return &StartError{Phase: PhaseHooks, Err: err}
+	}
+
+	enter(PhaseExec)
+	if err := setupUser(container.config.Process.User); err != nil {
+		return &StartError{Phase: PhaseExec, Err: err}
 	}
 
-	fmt.Printf(">>> [CHILD] Executing: %s %v\n", execPath, args)
-	err = syscall.Exec(execPath, args, container.config.Process.Env)
-	return fmt.Errorf("exec failed: %w", err)
+	if err := closeExecFrom(3 + len(preserved)); err != nil {
+		return &StartError{Phase: PhaseExec, Err: err}
+	}
+	if err := inheritOnExec(preserved); err != nil {
+		return &StartError{Phase: PhaseExec, Err: err}
+	}
+
+	fmt.Printf(">>> [CHILD] Executing: %q %q\n", execPath, args)
+	err = unix.Exec(execPath, args, env)
+	// The files would close the fds once collected
+	runtime.KeepAlive(preserved)
+	return &StartError{Phase: PhaseExec, ExitCode: execExitCode(err), Err: fmt.Errorf("exec failed: %w", explainExecError(execPath, err))}
 }
 
-/*
- * SINGLE-PROCESS PATTERN:
- *
- * PARENT: Creates exec.Cmd with --child flag, calls Start()
- * CHILD:  Detected by --child flag, runs RunAsChild() which does pivot_root + exec
- */
-func newInitProcess(container *linuxContainer) (*initProcess, error) {
-	// Check if we're the child after fork
-	isChild := false
-	for _, arg := range os.Args {
-		if arg == "--child" {
-			isChild = true
-			break
-		}
+// execExitCode is the init exit code for an exec that failed with err.
+func execExitCode(err error) int {
+	switch {
+	case errors.Is(err, unix.ENOENT), errors.Is(err, unix.ENOTDIR):
+		return ExitNotFound
+	case errors.Is(err, unix.EACCES), errors.Is(err, unix.EPERM), errors.Is(err, unix.ENOEXEC), errors.Is(err, unix.EISDIR):
+		return ExitCannotExec
 	}
+	return ExitSetupFailed
+}
 
-	if isChild {
-		// This path is actually handled in main() now
-		// This function won't be called in child because main() handles --child
-		return nil, fmt.Errorf("child mode should be handled in main()")
+// chdirInRoot changes to path without following magic links: without a
+// pid namespace, /proc/1/root is the host's root.
+func chdirInRoot(path string) error {
+	root, err := rootfsfile.Open("/")
+	if err != nil {
+		return err
 	}
+	defer root.Close()
 
-	// Parent path: create exec.Cmd
+	dir, err := root.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return unix.Fchdir(int(dir.Fd()))
+}
+
+// setupUser switches to process.user. The default root user is left
+// alone, so a user namespace that denies setgroups still works.
+func setupUser(user specs.User) error {
+	if user.UID == 0 && user.GID == 0 && len(user.AdditionalGids) == 0 {
+		return nil
+	}
+	groups := make([]int, len(user.AdditionalGids))
+	for i, gid := range user.AdditionalGids {
+		groups[i] = int(gid)
+	}
+	// x/sys's setgroups changes the calling thread alone; syscall's
+	// changes every thread of the runtime, as Setgid and Setuid do
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("failed to set additional gids %v: %w", user.AdditionalGids, err)
+	}
+	if err := unix.Setgid(int(user.GID)); err != nil {
+		return fmt.Errorf("failed to set gid %d: %w", user.GID, err)
+	}
+	if err := unix.Setuid(int(user.UID)); err != nil {
+		return fmt.Errorf("failed to set uid %d: %w", user.UID, err)
+	}
+	return nil
+}
+
+// newInitProcess prepares the container process: the runtime itself,
+// re-executed into the container's namespaces, where RunInit takes over.
+// With waitsForStart it stops short of exec until start releases it
+// through the exec fifo.
+func newInitProcess(container *linuxContainer, waitsForStart bool) (*initProcess, error) {
 	fmt.Printf(">>> [PARENT] Creating container process with namespaces...\n")
-	fmt.Printf(">>> [PARENT] Namespaces: pid, net, ipc, uts, cgroup, time, mount\n")
+	var created []string
+	for _, ns := range container.config.Resolved.Namespaces {
+		if ns.Path == "" {
+			created = append(created, string(ns.Type))
+		}
+	}
+	fmt.Printf(">>> [PARENT] Namespaces: %s\n", strings.Join(created, ", "))
 
 	execPath, err := os.Executable()
 	if err != nil {
 		execPath = os.Args[0]
 	}
 
+	if container.config.Process == nil {
+		return nil, fmt.Errorf("container process not configured")
+	}
+
 	absBundle, _ := filepath.Abs(container.bundle)
+	configPath := filepath.Join(container.root, configFilename)
+
+	// The frozen config has sensitive values redacted. The init stage
+	// takes them from its environment, where the container gets them
+	// anyway
+	sensitiveEnv, err := container.loadSensitiveEnv()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sensitive environment: %w", err)
+	}
+	env := containerEnv(mergeEnv(container.config.Process.Env, sensitiveEnv))
+	if len(sensitiveEnv) > 0 {
+		env = append(env, sensitiveEnvVar+"="+sensitiveEnvNames(sensitiveEnv))
+	}
+
+	configFile, err := os.Open(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open frozen config: %w", err)
+	}
+
+	var rootfs *os.File
+	if container.rootfsPinned() {
+		if rootfs, err = container.openPinnedRootfs(); err != nil {
+			configFile.Close()
+			return nil, err
+		}
+	}
+
+	var execFifo *os.File
+	if waitsForStart {
+		if execFifo, err = container.openExecFifo(); err != nil {
+			configFile.Close()
+			if rootfs != nil {
+				rootfs.Close()
+			}
+			return nil, err
+		}
+	}
+
+	parentPipe, childPipe, err := newSyncSockpair()
+	if err != nil {
+		configFile.Close()
+		if rootfs != nil {
+			rootfs.Close()
+		}
+		if execFifo != nil {
+			execFifo.Close()
+		}
+		return nil, err
+	}
+
+	extraFiles := append(append([]*os.File(nil), container.preservedFiles...), childPipe, configFile) // initSyncFd, initConfigFd
+	if rootfs != nil {
+		extraFiles = append(extraFiles, rootfs) // initRootfsFd
+	}
+	execFifoFd := -1
+	if execFifo != nil {
+		execFifoFd = initSyncFd + len(extraFiles)
+		extraFiles = append(extraFiles, execFifo)
+	}
+
 	cmd := &exec.Cmd{
-		Path:   execPath,
-		Args:   []string{execPath, "--child", "--bundle", absBundle},
-		Stdout: os.Stdout,
-		Stderr: os.Stderr,
-		Stdin:  os.Stdin,
-		Dir:    "/",
-		Env:    container.config.Process.Env,
+		Path:       execPath,
+		Args:       initArgs(execPath, absBundle, configPath, len(container.preservedFiles), rootfs != nil, execFifoFd),
+		ExtraFiles: extraFiles,
+		Stdout:     os.Stdout,
+		Stderr:     os.Stderr,
+		Stdin:      os.Stdin,
+		Dir:        "/",
+		Env:        append(env, internalEnv()...),
 		SysProcAttr: &syscall.SysProcAttr{
-			Cloneflags: syscall.CLONE_NEWNS |
-				syscall.CLONE_NEWPID |
-				syscall.CLONE_NEWUTS |
-				syscall.CLONE_NEWNET |
-				syscall.CLONE_NEWIPC |
-				syscall.CLONE_NEWCGROUP |
-				syscall.CLONE_NEWTIME,
+			Cloneflags: cloneFlags(container.config.Resolved.Namespaces),
 		},
 	}
 
+	if linux := container.config.Linux; linux != nil && cmd.SysProcAttr.Cloneflags&unix.CLONE_NEWUSER != 0 {
+		cmd.SysProcAttr.UidMappings = idMappings(linux.UIDMappings)
+		cmd.SysProcAttr.GidMappings = idMappings(linux.GIDMappings)
+		// setgroups may only stay allowed when a privileged runtime writes the maps
+		cmd.SysProcAttr.GidMappingsEnableSetgroups = os.Geteuid() == 0
+		// Become the namespace's root before exec; the runtime's own ids
+		// are usually unmapped and would lose every capability at exec
+		cmd.SysProcAttr.Credential = &syscall.Credential{
+			Uid:         0,
+			Gid:         0,
+			NoSetGroups: !cmd.SysProcAttr.GidMappingsEnableSetgroups,
+		}
+	}
+
+	var console *os.File
+	if container.console != nil {
+		container.console.attach(cmd)
+	} else if container.config.Process.Terminal && container.consoleSocket != "" {
+		if console, err = sendConsole(container.consoleSocket, container.config.Process.ConsoleSize); err != nil {
+			for _, f := range append(extraFiles[len(container.preservedFiles):], parentPipe) {
+				f.Close()
+			}
+			return nil, err
+		}
+		attachTerminal(cmd, console)
+	}
+
+	// Without a cgroup, kill --all finds the container's processes by
+	// the process group the init leads; a console's session is one
+	if container.config.Resolved.CgroupsPath == "" && !cmd.SysProcAttr.Setsid && !container.foreground {
+		cmd.SysProcAttr.Setpgid = true
+	}
+
 	fmt.Printf(">>> [PARENT] Returning cmd. Parent will call cmd.Start() to fork child.\n")
 
 	return &initProcess{
-		cmd:       cmd,
-		container: container,
+		cmd:        cmd,
+		container:  container,
+		syncPipe:   parentPipe,
+		childPipe:  childPipe,
+		configFile: configFile,
+		rootfs:     rootfs,
+		execFifo:   execFifo,
+		console:    console,
+		manager:    container.cgroupManager(),
 	}, nil
 }
@@ -0,0 +1,242 @@
+package libcontainer
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/zakarynichols/hackontainer/config"
+	"golang.org/x/sys/unix"
+)
+
+// ops returns the names of the calls f recorded, in order.
+func ops(f *faultSys) []string {
+	var names []string
+	for _, call := range f.called() {
+		name, _, _ := strings.Cut(call, " ")
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestPrepareRootErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		// mounts are the scripted errors of the mounts, in order
+		mounts []error
+		want   string
+		calls  int
+	}{
+		{"private", []error{unix.EPERM}, "failed to make root mount private", 1},
+		{"slave", []error{nil, unix.EINVAL}, "failed to make root mount slave", 2},
+		{"bind", []error{nil, nil, unix.ENOENT}, "failed to bind mount rootfs", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &faultSys{}
+			s.fail("Mount", tt.mounts...)
+			err := prepareRoot(s, "/rootfs", nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got %v, want %q", err, tt.want)
+			}
+			if !errors.Is(err, tt.mounts[len(tt.mounts)-1]) {
+				t.Errorf("%v does not wrap the mount's error", err)
+			}
+			if calls := s.called(); len(calls) != tt.calls {
+				t.Errorf("went on after the failure: %q", calls)
+			}
+		})
+	}
+
+	s := &faultSys{}
+	if err := prepareRoot(s, "/rootfs", nil); err != nil {
+		t.Fatal(err)
+	}
+	if calls := s.called(); len(calls) != 3 || !strings.HasPrefix(calls[2], "Mount [/rootfs /rootfs bind") {
+		t.Errorf("got calls %q", calls)
+	}
+}
+
+func TestPivotRootErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		op   string
+		errs []error
+		want string
+		// calls are the calls expected, the failing one last
+		calls []string
+	}{
+		{
+			name:  "fchdir to the new root",
+			op:    "Fchdir",
+			errs:  []error{unix.EACCES},
+			want:  "failed to fchdir to new root",
+			calls: []string{"Fchdir"},
+		},
+		{
+			name:  "pivot_root",
+			op:    "PivotRoot",
+			errs:  []error{unix.EBUSY},
+			want:  "device or resource busy",
+			calls: []string{"Fchdir", "PivotRoot"},
+		},
+		{
+			name:  "pivot_root out of the initramfs",
+			op:    "PivotRoot",
+			errs:  []error{unix.EINVAL},
+			want:  "invalid argument (try --no-pivot)",
+			calls: []string{"Fchdir", "PivotRoot"},
+		},
+		{
+			name:  "fchdir to the old root",
+			op:    "Fchdir",
+			errs:  []error{nil, unix.EBADF},
+			want:  "failed to fchdir to old root",
+			calls: []string{"Fchdir", "PivotRoot", "Fchdir"},
+		},
+		{
+			name:  "old root slave",
+			op:    "Mount",
+			errs:  []error{unix.EPERM},
+			want:  "failed to make old root slave",
+			calls: []string{"Fchdir", "PivotRoot", "Fchdir", "Mount"},
+		},
+		{
+			name:  "unmount the old root",
+			op:    "Unmount",
+			errs:  []error{unix.EBUSY},
+			want:  "failed to unmount old root",
+			calls: []string{"Fchdir", "PivotRoot", "Fchdir", "Mount", "Unmount"},
+		},
+		{
+			name:  "chdir to the new root",
+			op:    "Chdir",
+			errs:  []error{unix.ENOENT},
+			want:  "failed to chdir to new root",
+			calls: []string{"Fchdir", "PivotRoot", "Fchdir", "Mount", "Unmount", "Chdir"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &faultSys{}
+			s.fail(tt.op, tt.errs...)
+			err := pivotRoot(s, t.TempDir())
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got %v, want %q", err, tt.want)
+			}
+			if !errors.Is(err, tt.errs[len(tt.errs)-1]) {
+				t.Errorf("%v does not wrap the %s error", err, tt.op)
+			}
+			if got := ops(s); !slices.Equal(got, tt.calls) {
+				t.Errorf("got calls %q, want %q", got, tt.calls)
+			}
+		})
+	}
+
+	// Nothing is called when the new root can't even be opened
+	s := &faultSys{}
+	if err := pivotRoot(s, "/nonexistent/rootfs"); err == nil || !strings.Contains(err.Error(), "failed to open new root") {
+		t.Fatalf("got %v", err)
+	}
+	if calls := s.called(); len(calls) != 0 {
+		t.Errorf("got calls %q", calls)
+	}
+}
+
+// testContainer is a container whose rootfs is an empty directory, with
+// no cgroup and the mounts given.
+func testContainer(t *testing.T, mounts ...config.Mount) *linuxContainer {
+	rootfs := t.TempDir()
+	spec := &specs.Spec{Root: &specs.Root{Path: rootfs}}
+	return &linuxContainer{
+		id: "test",
+		config: &config.Config{
+			Spec:     spec,
+			Rootfs:   rootfs,
+			Resolved: &config.Resolved{Rootfs: rootfs, Mounts: mounts},
+		},
+	}
+}
+
+func TestSetupRootfsErrors(t *testing.T) {
+	errHook := errors.New("hook failed")
+	tests := []struct {
+		name      string
+		container func(t *testing.T) *linuxContainer
+		script    func(s *faultSys)
+		hook      error
+		phase     Phase
+		want      string
+		// phases are the phases entered, in order
+		phases []Phase
+	}{
+		{
+			name:      "preparing the root",
+			container: func(t *testing.T) *linuxContainer { return testContainer(t) },
+			script:    func(s *faultSys) { s.fail("Mount", unix.EPERM) },
+			phase:     PhaseRootfs,
+			want:      "failed to prepare root: failed to make root mount private",
+			phases:    []Phase{PhaseRootfs},
+		},
+		{
+			name: "a spec mount",
+			container: func(t *testing.T) *linuxContainer {
+				return testContainer(t,
+					config.Mount{Destination: "/run", Type: "tmpfs", Source: "tmpfs"},
+					config.Mount{Destination: "/tmp", Type: "tmpfs", Source: "tmpfs"})
+			},
+			// The three of prepareRoot, then /run
+			script: func(s *faultSys) { s.fail("Mount", nil, nil, nil, nil, unix.ENODEV) },
+			phase:  PhaseMounts,
+			want:   "failed to mount mounts[1] /tmp",
+			phases: []Phase{PhaseRootfs, PhaseMounts},
+		},
+		{
+			name:      "a hook before pivoting",
+			container: func(t *testing.T) *linuxContainer { return testContainer(t) },
+			script:    func(s *faultSys) {},
+			hook:      errHook,
+			phase:     PhaseHooks,
+			want:      "hook failed",
+			phases:    []Phase{PhaseRootfs, PhaseMounts, PhaseDevices},
+		},
+		{
+			name: "chdir to the rootfs",
+			container: func(t *testing.T) *linuxContainer {
+				c := testContainer(t)
+				c.config.NoPivotRoot = true
+				return c
+			},
+			script: func(s *faultSys) { s.fail("Chdir", unix.EACCES) },
+			phase:  PhaseRootfs,
+			want:   "failed to chdir to rootfs",
+			phases: []Phase{PhaseRootfs, PhaseMounts, PhaseDevices, PhaseRootfs},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &faultSys{}
+			tt.script(s)
+			var phases []Phase
+			enter := func(p Phase) { phases = append(phases, p) }
+			beforePivot := func() error { return tt.hook }
+
+			err := setupRootfs(tt.container(t), s, nil, enter, beforePivot)
+			var startErr *StartError
+			if !errors.As(err, &startErr) {
+				t.Fatalf("got %v, want a StartError", err)
+			}
+			if startErr.Phase != tt.phase || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got %v in phase %s, want %q in phase %s", err, startErr.Phase, tt.want, tt.phase)
+			}
+			if !slices.Equal(phases, tt.phases) {
+				t.Errorf("entered phases %v, want %v", phases, tt.phases)
+			}
+			if slices.Contains(ops(s), "PivotRoot") {
+				t.Errorf("pivoted after the failure: %q", s.called())
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package libcontainer
+
+import "github.com/zakarynichols/hackontainer/api/types"
+
+// InspectInfo is the detailed view of a container printed by inspect.
+type InspectInfo = types.InspectInfo
+
+func (c *linuxContainer) Inspect() (*InspectInfo, error) {
+	state, err := c.State()
+	if err != nil {
+		return nil, err
+	}
+
+	pid := 0
+	if state.Status == Running || state.Status == Paused {
+		pid = state.Pid
+	}
+
+	if state.RootfsQuota != nil && c.config != nil {
+		quota := *state.RootfsQuota
+		if used, err := rootfsQuotaUsage(c.config.Rootfs, quota.ProjectID); err == nil {
+			quota.UsedBytes = used
+		}
+		state.RootfsQuota = &quota
+	}
+
+	info := &InspectInfo{
+		State:      *state,
+		Namespaces: c.namespaceInfo(pid),
+		CPU:        c.cpuInfo(pid),
+	}
+	if pid != 0 {
+		info.Hostname = hostname(pid)
+		info.Mounts, info.Devices = c.liveMounts(pid)
+	}
+	if c.config != nil {
+		info.Rootfs = c.config.Rootfs
+	}
+	if c.config != nil && c.config.Resolved != nil && c.config.Resolved.Resources != nil {
+		resources := *c.config.Resolved.Resources
+		resources.Devices = nil
+		info.Resources = &resources
+	}
+	if c.config != nil && c.config.Process != nil {
+		process := c.config.Process
+		info.Process = &types.ProcessInfo{
+			Args:           process.Args,
+			Env:            containerEnv(process.Env),
+			Cwd:            process.Cwd,
+			UID:            process.User.UID,
+			GID:            process.User.GID,
+			AdditionalGids: process.User.AdditionalGids,
+		}
+	}
+	if stats, err := c.FinalStats(); err == nil {
+		info.FinalStats = stats
+	}
+	info.Footprint = c.footprint(state, pid)
+	info.Labels = c.Labels()
+	if opts, err := c.loadCreateOptions(); err == nil {
+		info.CreateOptions = opts
+	}
+	return info, nil
+}
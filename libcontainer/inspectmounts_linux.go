@@ -0,0 +1,268 @@
+package libcontainer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/zakarynichols/hackontainer/api/types"
+	"github.com/zakarynichols/hackontainer/libcontainer/rootfsfile"
+	"golang.org/x/sys/unix"
+)
+
+// MountInfo is a mount of a running container, compared with its spec.
+type MountInfo = types.MountInfo
+
+// DeviceInfo is a device node under a running container's /dev.
+type DeviceInfo = types.DeviceInfo
+
+const (
+	// maxDevDepth bounds how deep below /dev devices are looked for.
+	maxDevDepth = 4
+	// maxDevEntries bounds the entries looked at, which the container
+	// controls.
+	maxDevEntries = 4096
+)
+
+// liveMounts reads the mounts and device nodes of the container process
+// pid for inspect, and compares the mounts with the config. Both come
+// from a handle on /proc/<pid> checked to be the container's, so a pid
+// reused meanwhile can't substitute another process's. Devices are only
+// listed where openat2 keeps every lookup inside the container's root:
+// the container controls its /dev, symlinks included, and a lookup that
+// could leave it would have the runtime read the host.
+func (c *linuxContainer) liveMounts(pid int) ([]MountInfo, []DeviceInfo) {
+	startTime, err := getProcessStartTime(pid)
+	if err != nil {
+		return nil, nil
+	}
+	proc, err := os.OpenFile(fmt.Sprintf("/proc/%d", pid), unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil
+	}
+	defer proc.Close()
+	if now, err := getProcessStartTime(pid); err != nil || now != startTime {
+		return nil, nil
+	}
+
+	// Files under the handle fail to open once the process is gone,
+	// rather than open those of whatever process has the pid next
+	var mounts []MountInfo
+	if fd, err := unix.Openat(int(proc.Fd()), "mountinfo", unix.O_RDONLY|unix.O_CLOEXEC, 0); err == nil {
+		f := os.NewFile(uintptr(fd), "mountinfo")
+		entries, err := parseMountinfo(f)
+		f.Close()
+		if err == nil {
+			mounts = compareMounts(entries, c.requestedMounts())
+		}
+	}
+
+	var devices []DeviceInfo
+	if rootfsfile.Mechanism() == rootfsfile.MechanismOpenat2 {
+		// The root link is the kernel's, not the container's, so it is
+		// followed; nothing below it is resolved outside the root
+		if fd, err := unix.Openat(int(proc.Fd()), "root", unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0); err == nil {
+			devices = listDevices(fd)
+			unix.Close(fd)
+		}
+	}
+	return mounts, devices
+}
+
+// requestedMount is a mount the container should have.
+type requestedMount struct {
+	destination string
+	source      string
+	fstype      string
+	options     []string
+	// optional is set for mounts the runtime makes only where the path
+	// exists, which are never missing
+	optional bool
+	found    bool
+}
+
+// requestedMounts lists the mounts the config asks for, as created:
+// the rootfs, the spec's mounts, the /dev the runtime may mount, and
+// the mounts masking and making read-only paths.
+func (c *linuxContainer) requestedMounts() []*requestedMount {
+	requested := []*requestedMount{{destination: "/", source: c.config.Rootfs, optional: true}}
+	resolved := c.config.Resolved
+	if resolved == nil {
+		return requested
+	}
+	if resolved.DevTmpfs {
+		requested = append(requested, &requestedMount{destination: "/dev", source: "tmpfs", fstype: "tmpfs"})
+	}
+	var specMounts map[string]string
+	if c.config.Spec != nil {
+		specMounts = make(map[string]string, len(c.config.Mounts))
+		for _, m := range c.config.Mounts {
+			specMounts[filepath.Clean(m.Destination)] = strings.Join(m.Options, ",")
+		}
+	}
+	for _, m := range resolved.Mounts {
+		r := &requestedMount{destination: m.Destination, source: m.Source}
+		// Binds are of the source's type, and the cgroup mount is
+		// whatever this host's hierarchy needs
+		if !m.Bind && m.Type != "cgroup" && m.Type != "cgroup2" {
+			r.fstype = m.Type
+		}
+		if options := specMounts[m.Destination]; options != "" {
+			r.options = strings.Split(options, ",")
+		}
+		requested = append(requested, r)
+	}
+	if c.config.Spec != nil && c.config.Linux != nil {
+		for _, paths := range [][]string{c.config.Linux.MaskedPaths, c.config.Linux.ReadonlyPaths} {
+			for _, path := range paths {
+				requested = append(requested, &requestedMount{destination: filepath.Clean(path), optional: true})
+			}
+		}
+	}
+	for _, dev := range resolved.Devices {
+		// Bound from the host when the nodes can't be created
+		requested = append(requested, &requestedMount{destination: filepath.Clean(dev.Path), source: dev.Path, optional: true})
+	}
+	return requested
+}
+
+// compareMounts lists the container's mounts in mountinfo order, each
+// applied if the config asked for a mount there and extra otherwise,
+// followed by the mounts the config asked for that it lacks. A mount of
+// another type than asked for doesn't count as the one asked for.
+func compareMounts(entries []mountEntry, requested []*requestedMount) []MountInfo {
+	mounts := make([]MountInfo, 0, len(entries))
+	for _, entry := range entries {
+		info := MountInfo{
+			Destination: entry.mountpoint,
+			Source:      entry.source,
+			Type:        entry.fstype,
+			Options:     dedupOptions(entry.options),
+			Propagation: propagationName(entry),
+			Status:      types.MountExtra,
+		}
+		if entry.root != "/" {
+			info.Root = entry.root
+		}
+		for _, r := range requested {
+			if !r.found && r.destination == entry.mountpoint && (r.fstype == "" || r.fstype == entry.fstype) {
+				r.found = true
+				info.Status = types.MountApplied
+				break
+			}
+		}
+		mounts = append(mounts, info)
+	}
+	for _, r := range requested {
+		if !r.found && !r.optional {
+			mounts = append(mounts, MountInfo{
+				Destination: r.destination,
+				Source:      r.source,
+				Type:        r.fstype,
+				Options:     r.options,
+				Status:      types.MountMissing,
+			})
+		}
+	}
+	return mounts
+}
+
+// dedupOptions drops the options the superblock repeats, such as rw.
+func dedupOptions(options []string) []string {
+	seen := make(map[string]bool, len(options))
+	kept := options[:0:0]
+	for _, o := range options {
+		if o != "" && !seen[o] {
+			seen[o] = true
+			kept = append(kept, o)
+		}
+	}
+	return kept
+}
+
+func propagationName(entry mountEntry) string {
+	switch {
+	case entry.shared && entry.slave:
+		return "shared,slave"
+	case entry.shared:
+		return "shared"
+	case entry.slave:
+		return "slave"
+	case entry.unbindable:
+		return "unbindable"
+	}
+	return "private"
+}
+
+// listDevices lists the device nodes under /dev of the root dirfd
+// refers to. /dev itself is resolved as the container would, inside
+// the root; below it nothing is followed: each entry is looked at in
+// the directory already open, without following a symlink there, and
+// directories are entered only if they aren't one.
+func listDevices(root int) []DeviceInfo {
+	dev, err := unix.Openat2(root, "/dev", &unix.OpenHow{
+		Flags:   unix.O_RDONLY | unix.O_DIRECTORY | unix.O_CLOEXEC,
+		Resolve: unix.RESOLVE_IN_ROOT | unix.RESOLVE_NO_MAGICLINKS,
+	})
+	if err != nil {
+		return nil
+	}
+	var devices []DeviceInfo
+	budget := maxDevEntries
+	walkDevices(dev, "/dev", 0, &budget, &devices)
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Path < devices[j].Path })
+	return devices
+}
+
+// walkDevices adds the device nodes in the directory dir, at path, and
+// below it, to devices. It closes dir.
+func walkDevices(dir int, path string, depth int, budget *int, devices *[]DeviceInfo) {
+	f := os.NewFile(uintptr(dir), path)
+	defer f.Close()
+	if *budget <= 0 {
+		return
+	}
+	names, err := f.Readdirnames(*budget)
+	if err != nil && err != io.EOF {
+		return
+	}
+	*budget -= len(names)
+	for _, name := range names {
+		var st unix.Stat_t
+		if err := unix.Fstatat(dir, name, &st, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+			continue
+		}
+		full := filepath.Join(path, name)
+		switch st.Mode & unix.S_IFMT {
+		case unix.S_IFCHR, unix.S_IFBLK:
+			devType := "c"
+			if st.Mode&unix.S_IFMT == unix.S_IFBLK {
+				devType = "b"
+			}
+			*devices = append(*devices, DeviceInfo{
+				Path:     full,
+				Type:     devType,
+				Major:    int64(unix.Major(st.Rdev)),
+				Minor:    int64(unix.Minor(st.Rdev)),
+				FileMode: os.FileMode(st.Mode & 0o777),
+				UID:      st.Uid,
+				GID:      st.Gid,
+			})
+		case unix.S_IFDIR:
+			if depth+1 >= maxDevDepth || *budget <= 0 {
+				continue
+			}
+			sub, err := unix.Openat2(dir, name, &unix.OpenHow{
+				Flags:   unix.O_RDONLY | unix.O_DIRECTORY | unix.O_NOFOLLOW | unix.O_CLOEXEC,
+				Resolve: unix.RESOLVE_BENEATH | unix.RESOLVE_NO_SYMLINKS,
+			})
+			if err != nil {
+				continue
+			}
+			walkDevices(sub, full, depth+1, budget, devices)
+		}
+	}
+}
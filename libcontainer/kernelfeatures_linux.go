@@ -0,0 +1,233 @@
+package libcontainer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/zakarynichols/hackontainer/libcontainer/specconv"
+	"golang.org/x/sys/unix"
+)
+
+// kernelFeaturesFilename caches, under the factory root, the probes that
+// found their feature available. The kernel's answer doesn't change
+// until it reboots; a missing feature is probed again every time, so
+// enabling it takes effect without a reboot.
+const kernelFeaturesFilename = "kernel-features.json"
+
+// missingFeaturesEnv lists kernel features, comma-separated by name, to
+// report as missing without probing them. Tests use it to simulate hosts
+// that lack them.
+const missingFeaturesEnv = "HACKONTAINER_TEST_MISSING_FEATURES"
+
+// kernelFeature is something the host must provide for a container to
+// start. Create probes for it so a host without it fails there, with
+// every missing feature listed, instead of in the child at start.
+type kernelFeature struct {
+	// name identifies the feature in reports and in missingFeaturesEnv.
+	name string
+	// key names the probe in the cache. Probes without one depend on
+	// more than the kernel and always run.
+	key   string
+	probe func() error
+}
+
+// requiredKernelFeatures derives the kernel features spec needs, with
+// pivot_root unless noPivotRoot.
+func requiredKernelFeatures(spec *specs.Spec, noPivotRoot bool) []kernelFeature {
+	// Other namespaces are created owned by a new user namespace, which
+	// is what lets an unprivileged runtime create them at all
+	var userFlag uintptr
+	if newUserNamespace(spec) {
+		userFlag = unix.CLONE_NEWUSER
+	}
+
+	var features []kernelFeature
+	for _, ns := range specconv.Namespaces(spec) {
+		if ns.Path != "" {
+			continue
+		}
+		flags := nsCloneFlags[ns.Type] | userFlag
+		features = append(features, kernelFeature{
+			name:  nsFiles[ns.Type] + " namespace",
+			key:   fmt.Sprintf("clone %#x", flags),
+			probe: func() error { return probeNamespace(ns.Type, flags) },
+		})
+	}
+	if !noPivotRoot {
+		features = append(features, kernelFeature{name: "pivot_root", probe: probePivotRoot})
+	}
+	return features
+}
+
+// checkKernelFeatures fails with ErrMissingKernelFeatures, naming every
+// feature spec needs that the host lacks.
+func checkKernelFeatures(root string, spec *specs.Spec, noPivotRoot bool) error {
+	cache := loadKernelFeatureCache(root)
+	simulated := strings.Split(os.Getenv(missingFeaturesEnv), ",")
+
+	var missing []string
+	cached := false
+	for _, feature := range requiredKernelFeatures(spec, noPivotRoot) {
+		var err error
+		switch {
+		case slices.Contains(simulated, feature.name):
+			err = fmt.Errorf("simulated by %s", missingFeaturesEnv)
+		case feature.key != "" && slices.Contains(cache.Available, feature.key):
+			continue
+		default:
+			err = feature.probe()
+			if err == nil && feature.key != "" {
+				cache.Available = append(cache.Available, feature.key)
+				cached = true
+			}
+		}
+		if err != nil {
+			missing = append(missing, fmt.Sprintf("%s: %v", feature.name, err))
+		}
+	}
+
+	if cached {
+		// A cache that can't be written only costs probing again
+		_ = cache.save(root)
+	}
+	if len(missing) > 0 {
+		return newTypedError(ErrMissingKernelFeatures, "the host lacks kernel features the config needs: %s", strings.Join(missing, "; "))
+	}
+	return nil
+}
+
+// kernelFeatureCache is the content of kernelFeaturesFilename. Probe
+// results depend on the privileges they ran with as well as the boot.
+type kernelFeatureCache struct {
+	BootID    string   `json:"bootId"`
+	Euid      int      `json:"euid"`
+	Available []string `json:"available"`
+}
+
+func loadKernelFeatureCache(root string) *kernelFeatureCache {
+	current := &kernelFeatureCache{BootID: bootID(), Euid: os.Geteuid()}
+	data, err := os.ReadFile(filepath.Join(root, kernelFeaturesFilename))
+	if err != nil || current.BootID == "" {
+		return current
+	}
+	var cache kernelFeatureCache
+	if json.Unmarshal(data, &cache) != nil || cache.BootID != current.BootID || cache.Euid != current.Euid {
+		return current
+	}
+	return &cache
+}
+
+// save replaces the cache file at once, since creates run concurrently.
+func (c *kernelFeatureCache) save(root string) error {
+	if c.BootID == "" {
+		return nil
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(root, kernelFeaturesFilename+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(root, kernelFeaturesFilename))
+}
+
+// probeNamespace clones a scratch child with flags, as newInitProcess
+// will for a namespace of type nsType.
+func probeNamespace(nsType specs.LinuxNamespaceType, flags uintptr) error {
+	name := nsFiles[nsType]
+	if _, err := os.Stat(filepath.Join("/proc/self/ns", name)); err != nil {
+		return errors.New("not supported by the kernel")
+	}
+
+	err := probeClone(flags)
+	if err == nil {
+		return nil
+	}
+	if reason := namespaceSysctlReason(name); reason != "" {
+		return fmt.Errorf("%w (%s)", err, reason)
+	}
+	return err
+}
+
+// probeClone clones a scratch child with flags. The child execs a
+// directory, which fails with EACCES once the clone has succeeded, so
+// nothing runs in it.
+func probeClone(flags uintptr) error {
+	pid, err := syscall.ForkExec("/", []string{"/"}, &syscall.ProcAttr{
+		Sys: &syscall.SysProcAttr{Cloneflags: flags},
+	})
+	if err == nil {
+		var status unix.WaitStatus
+		_, _ = unix.Wait4(pid, &status, 0, nil)
+		return nil
+	}
+	if err == unix.EACCES {
+		return nil
+	}
+	return err
+}
+
+// namespaceSysctlReason names the sysctl that keeps namespaces of the
+// given /proc/<pid>/ns name from being created, if any.
+func namespaceSysctlReason(name string) string {
+	if readSysctl("user/max_"+name+"_namespaces") == "0" {
+		return "user.max_" + name + "_namespaces is 0"
+	}
+	if name == "user" && os.Geteuid() != 0 {
+		// Debian's switch, and Ubuntu's AppArmor restriction
+		if readSysctl("kernel/unprivileged_userns_clone") == "0" {
+			return "kernel.unprivileged_userns_clone is 0"
+		}
+		if readSysctl("kernel/apparmor_restrict_unprivileged_userns") == "1" {
+			return "kernel.apparmor_restrict_unprivileged_userns is 1"
+		}
+	}
+	return ""
+}
+
+// readSysctl returns the value of the sysctl at path below /proc/sys, or
+// "" if it doesn't exist.
+func readSysctl(path string) string {
+	data, err := os.ReadFile(filepath.Join("/proc/sys", path))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// probePivotRoot fails when the runtime's root is the initramfs. rootfs
+// can never be unmounted, so pivot_root refuses to move it away.
+func probePivotRoot() error {
+	mounts, err := selfMountinfo()
+	if err != nil {
+		return err
+	}
+	fstype := ""
+	for _, m := range mounts {
+		// Later entries are mounted on top of earlier ones
+		if m.mountpoint == "/" {
+			fstype = m.fstype
+		}
+	}
+	if fstype == "rootfs" {
+		return errors.New("the runtime runs from the initramfs, which can't be pivoted away from; use --no-pivot")
+	}
+	return nil
+}
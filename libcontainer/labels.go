@@ -0,0 +1,116 @@
+package libcontainer
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zakarynichols/hackontainer/config"
+)
+
+// labelsFilename holds the container's labels. They belong to whoever
+// runs the runtime, not the bundle author, so they live apart from the
+// config's annotations and stay out of the OCI state.
+const labelsFilename = "labels.json"
+
+// WithLabels sets labels on the container, each given as key=value. A
+// key given twice keeps its last value. Keys follow the rules of
+// annotation keys and count against the same size limit.
+func WithLabels(labels ...string) CreateOption {
+	return func(l *LinuxFactory) error {
+		merged := maps.Clone(l.labels)
+		if merged == nil {
+			merged = make(map[string]string, len(labels))
+		}
+		for _, label := range labels {
+			key, value, ok := strings.Cut(label, "=")
+			if !ok {
+				return fmt.Errorf("invalid label %q, want key=value", label)
+			}
+			merged[key] = value
+		}
+		l.labels = merged
+		return nil
+	}
+}
+
+// validateLabels applies the factory's annotation size limit to its
+// labels.
+func (l *LinuxFactory) validateLabels() error {
+	maxSize := l.maxAnnotationsSize
+	if maxSize == 0 {
+		maxSize = config.DefaultMaxAnnotationsSize
+	}
+	return config.ValidateLabels(l.labels, maxSize)
+}
+
+// saveLabels writes the container's labels to its root. A container
+// without labels has no file.
+func (c *linuxContainer) saveLabels() error {
+	if len(c.labels) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(c.labels)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(c.root, labelsFilename), data, 0600)
+}
+
+// loadLabels reads the container's labels. Containers created without
+// any, or before labels existed, have none.
+func (c *linuxContainer) loadLabels() error {
+	data, err := os.ReadFile(filepath.Join(c.root, labelsFilename))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &c.labels); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", labelsFilename, err)
+	}
+	return nil
+}
+
+// Labels returns the labels the container was created with.
+func (c *linuxContainer) Labels() map[string]string {
+	return maps.Clone(c.labels)
+}
+
+// LabelFilter selects containers by one of their labels: by the key
+// alone, whatever its value, or by key and value.
+type LabelFilter struct {
+	Key      string
+	Value    string
+	HasValue bool
+}
+
+// ParseLabelFilter parses key or key=value. A value may contain "=".
+func ParseLabelFilter(expr string) (LabelFilter, error) {
+	key, value, hasValue := strings.Cut(expr, "=")
+	if key == "" {
+		return LabelFilter{}, fmt.Errorf("invalid label filter %q, want key or key=value", expr)
+	}
+	return LabelFilter{Key: key, Value: value, HasValue: hasValue}, nil
+}
+
+// Match reports whether labels has the filter's key, and its value if
+// it names one.
+func (f LabelFilter) Match(labels map[string]string) bool {
+	value, ok := labels[f.Key]
+	return ok && (!f.HasValue || value == f.Value)
+}
+
+// MatchLabels reports whether labels match every one of filters.
+func MatchLabels(labels map[string]string, filters []LabelFilter) bool {
+	for _, f := range filters {
+		if !f.Match(labels) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,175 @@
+package libcontainer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// layoutFilename records the version of the on-disk layout of the
+// directory it is in, a container root or the factory root. Version 0 is
+// the layout from before the file existed.
+const layoutFilename = "layout"
+
+// migration upgrades a directory from one layout version to the next. It
+// may be interrupted at any point and run again, so each step must
+// notice what an earlier attempt already did.
+type migration struct {
+	description string
+	apply       func(dir string) error
+}
+
+// containerMigrations upgrade container roots: entry i takes a root from
+// version i to i+1, so the current version is their number. Roots are
+// migrated when loaded, which lets a node be upgraded under live
+// containers. Append to the list; never change an entry once released.
+var containerMigrations = []migration{
+	{"freeze the config and record the cgroup path", migrateContainerV1},
+}
+
+// rootMigrations upgrade the factory root the same way.
+var rootMigrations = []migration{
+	// Version 1 only adds the layout file
+	{"record the layout version", func(string) error { return nil }},
+}
+
+type layoutFile struct {
+	Version int `json:"version"`
+}
+
+// readLayout returns the layout version of dir.
+func readLayout(dir string) (int, error) {
+	data, err := os.ReadFile(filepath.Join(dir, layoutFilename))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var layout layoutFile
+	if err := json.Unmarshal(data, &layout); err != nil || layout.Version < 1 {
+		return 0, fmt.Errorf("invalid layout file in %s", dir)
+	}
+	return layout.Version, nil
+}
+
+// writeLayout records version as the layout of dir. The file is replaced
+// atomically, so a reader sees the old version or the new one.
+func writeLayout(dir string, version int) error {
+	data, err := json.Marshal(layoutFile{Version: version})
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, layoutFilename+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, layoutFilename))
+}
+
+// migrate brings dir, described as what in errors, up to the version the
+// migrations lead to. It refuses a layout newer than that rather than
+// guess at it. Callers keep others from migrating dir at the same time.
+func migrate(dir, what string, migrations []migration) error {
+	version, err := readLayout(dir)
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return newTypedError(ErrUnsupportedLayout, "%s has state layout version %d, but this hackontainer only understands up to %d; use a newer hackontainer", what, version, len(migrations))
+	}
+	for ; version < len(migrations); version++ {
+		m := migrations[version]
+		if err := m.apply(dir); err != nil {
+			return fmt.Errorf("failed to migrate %s to state layout version %d (%s): %w", what, version+1, m.description, err)
+		}
+		if err := writeLayout(dir, version+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateRoot brings the factory root up to date.
+func migrateRoot(root string) error {
+	return migrate(root, fmt.Sprintf("root %s", root), rootMigrations)
+}
+
+// migrateContainer brings the container root up to date. Roots that are
+// current are only read, so loading stays lock-free for them.
+func (c *linuxContainer) migrateContainer() error {
+	what := fmt.Sprintf("container %q", c.id)
+	version, err := readLayout(c.root)
+	if err != nil {
+		return err
+	}
+	if version == len(containerMigrations) {
+		return nil
+	}
+	if version > len(containerMigrations) {
+		return migrate(c.root, what, containerMigrations)
+	}
+
+	unlock, err := c.lock()
+	if os.IsNotExist(err) {
+		// Loading reports the missing container
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return migrate(c.root, what, containerMigrations)
+}
+
+// migrateContainerV1 freezes the config of a container created before
+// configs were frozen. Runtimes of the time read the bundle's config.json
+// on every operation, so that is what gets frozen. It also records the
+// cgroup path in the state.
+func migrateContainerV1(dir string) error {
+	c := &linuxContainer{id: filepath.Base(dir), root: dir}
+	state, err := c.loadState()
+	if os.IsNotExist(err) {
+		// A delete got as far as removing it and will finish the rest
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	frozenPath := filepath.Join(dir, configFilename)
+	if !fileExists(frozenPath) {
+		cfg, err := loadContainerConfig(state.Bundle)
+		if err != nil {
+			return fmt.Errorf("failed to read the config from the bundle: %w", err)
+		}
+		if err := cfg.Save(frozenPath + ".tmp"); err != nil {
+			return err
+		}
+		if err := os.Rename(frozenPath+".tmp", frozenPath); err != nil {
+			return err
+		}
+	}
+
+	if state.CgroupPath == "" {
+		cfg, err := loadFrozenConfig(dir, state.Bundle, filepath.Join(state.Namespace, state.ID))
+		if err != nil {
+			return err
+		}
+		state.CgroupPath = cfg.Resolved.CgroupsPath
+	}
+	return c.saveState(state)
+}
@@ -0,0 +1,130 @@
+package libcontainer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/zakarynichols/hackontainer/api/types"
+)
+
+// ListedContainer is a container directory List found, loaded along with
+// its state. Container and State are nil and Err says why for one that
+// couldn't be loaded. A delegated container has Delegation and the state
+// its delegate reported instead of Container.
+type ListedContainer struct {
+	ID string
+	// Namespace is the tenant namespace the container is in.
+	Namespace  string
+	Container  Container
+	Delegation *Delegation
+	State      *State
+	Err        error
+}
+
+// ListEntry is a container as the list command and the API print it.
+type ListEntry = types.ListEntry
+
+// Entry returns the loaded container as listings print it.
+func (c ListedContainer) Entry() ListEntry {
+	entry := ListEntry{State: *c.State}
+	if c.Delegation != nil {
+		entry.Labels, entry.Runtime = c.Delegation.Labels, c.Delegation.Runtime
+	} else {
+		entry.Labels = c.Container.Labels()
+	}
+	// A delegate's state doesn't know the namespace
+	entry.Namespace = c.Namespace
+	return entry
+}
+
+// List loads every container under the factory's root, in ID order. A
+// directory that can't be loaded, such as one with a corrupt state.json
+// or one a create or delete left half done, is listed with its error
+// instead of failing the listing; only an unreadable root fails it. A
+// root that doesn't exist yet has no containers. Delegated containers
+// are listed with the state their delegate reports.
+func (l *LinuxFactory) List() ([]ListedContainer, error) {
+	dir := l.stateRoot()
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var listed []ListedContainer
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		id := entry.Name()
+		containerRoot := filepath.Join(dir, id)
+		delegation, err := loadDelegation(containerRoot)
+		if err != nil {
+			listed = append(listed, ListedContainer{ID: id, Namespace: l.namespace, Err: err})
+			continue
+		}
+		if delegation != nil {
+			state, err := delegation.State()
+			if err != nil {
+				listed = append(listed, ListedContainer{ID: id, Namespace: l.namespace, Delegation: delegation, Err: err})
+				continue
+			}
+			listed = append(listed, ListedContainer{ID: id, Namespace: l.namespace, Delegation: delegation, State: state})
+			continue
+		}
+		// Tenant namespaces share the root with containers, whose
+		// directories get their layout file before anything else
+		if !fileExists(filepath.Join(containerRoot, stateFilename)) {
+			if !fileExists(filepath.Join(containerRoot, layoutFilename)) {
+				continue
+			}
+			op := "create"
+			if fileExists(filepath.Join(containerRoot, deletingFilename)) {
+				op = "delete"
+			}
+			listed = append(listed, ListedContainer{ID: id, Namespace: l.namespace, Err: fmt.Errorf("no state: its %s is unfinished", op)})
+			continue
+		}
+
+		container, err := l.Load(id)
+		if err != nil {
+			listed = append(listed, ListedContainer{ID: id, Namespace: l.namespace, Err: err})
+			continue
+		}
+		state, err := container.State()
+		if err != nil {
+			listed = append(listed, ListedContainer{ID: id, Namespace: l.namespace, Err: err})
+			continue
+		}
+		listed = append(listed, ListedContainer{ID: id, Namespace: l.namespace, Container: container, State: state})
+	}
+	return listed, nil
+}
+
+// ListAllNamespaces lists the containers directly under the root and
+// then those of every namespace under it, in name order, each as List
+// would from a factory scoped to its namespace. A namespace that can't
+// be read fails the listing, as the root does for List.
+func (l *LinuxFactory) ListAllNamespaces() ([]ListedContainer, error) {
+	roots, err := namespaceRoots(l.root)
+	if err != nil {
+		return nil, err
+	}
+	var all []ListedContainer
+	for _, dir := range roots {
+		f := *l
+		f.namespace = ""
+		if dir != l.root {
+			f.namespace = filepath.Base(dir)
+		}
+		listed, err := f.List()
+		if err != nil {
+			return nil, fmt.Errorf("namespace %q: %w", f.namespace, err)
+		}
+		all = append(all, listed...)
+	}
+	return all, nil
+}
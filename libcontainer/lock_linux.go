@@ -0,0 +1,269 @@
+package libcontainer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Files in the factory root and in container roots.
+const (
+	// fileLocksFilename marks a directory whose locks are lock files. A
+	// root found not to support flock is marked, and keeps using them;
+	// so is every container created there, whatever later runtimes find.
+	fileLocksFilename = "file-locks"
+	// lockFilename is created exclusively in a directory by whoever
+	// holds its lock, and names them.
+	lockFilename = "lock"
+)
+
+// lockFileTimeout bounds the wait for a lock file. One a process of this
+// host left behind is broken once that process is gone, but one left by
+// another host is never removed by anyone else.
+const lockFileTimeout = 30 * time.Second
+
+// noFlockEnv makes factories find that their root doesn't support flock,
+// so tests can run everything with lock files on any filesystem.
+const noFlockEnv = "HACKONTAINER_TEST_NO_FLOCK"
+
+// A locker takes the exclusive locks that serialise the runtime
+// processes changing something on disk. Every process must lock a given
+// directory or file the same way, so the choice is recorded there: see
+// lockerOf.
+type locker interface {
+	// lockDir takes the lock on the directory dir, waiting for it. It
+	// fails with an os.IsNotExist error once dir is gone, including when
+	// it was removed while waiting.
+	lockDir(dir string) (func(), error)
+
+	// lockFile takes the lock named by path, waiting for it.
+	lockFile(path string) (func(), error)
+}
+
+// lockerOf returns the locker for dir and the files in it.
+func lockerOf(dir string) locker {
+	if _, err := os.Stat(filepath.Join(dir, fileLocksFilename)); err == nil {
+		return fileLocker{}
+	}
+	return flockLocker{}
+}
+
+// flockLocker locks with flock(2), which the kernel releases when the
+// holder exits, however it exits.
+type flockLocker struct{}
+
+func (flockLocker) lockDir(dir string) (func(), error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := flock(f, dir); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	var st unix.Stat_t
+	if err := unix.Fstat(int(f.Fd()), &st); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if st.Nlink == 0 {
+		f.Close()
+		return nil, &os.PathError{Op: "lock", Path: dir, Err: unix.ENOENT}
+	}
+	return func() { f.Close() }, nil
+}
+
+func (flockLocker) lockFile(path string) (func(), error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := flock(f, path); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() { f.Close() }, nil
+}
+
+func flock(f *os.File, path string) error {
+	err := unix.Flock(int(f.Fd()), unix.LOCK_EX)
+	if flockUnsupported(err) {
+		return newTypedError(ErrLocksUnsupported, "cannot lock %s: its filesystem doesn't support flock (%v), and it was set up by a runtime that didn't check", path, err)
+	}
+	return err
+}
+
+// flockUnsupported reports whether err is flock's for a filesystem
+// without locks.
+func flockUnsupported(err error) bool {
+	return errors.Is(err, unix.ENOLCK) || errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.ENOSYS)
+}
+
+// fileLocker locks by creating a lock file exclusively, which works on
+// any filesystem that has O_EXCL. The file names its holder's host, pid
+// and boot, so that a lock left by a process that died holding it is
+// broken by the next process of the same host to want it.
+type fileLocker struct{}
+
+func (fileLocker) lockDir(dir string) (func(), error) {
+	d, err := os.OpenFile(dir, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := acquireLockFile(d, lockFilename); err != nil {
+		d.Close()
+		return nil, err
+	}
+	unlock := func() {
+		unix.Unlinkat(int(d.Fd()), lockFilename, 0)
+		d.Close()
+	}
+
+	var st unix.Stat_t
+	if err := unix.Fstat(int(d.Fd()), &st); err != nil {
+		unlock()
+		return nil, err
+	}
+	if st.Nlink == 0 {
+		unlock()
+		return nil, &os.PathError{Op: "lock", Path: dir, Err: unix.ENOENT}
+	}
+	return unlock, nil
+}
+
+func (fileLocker) lockFile(path string) (func(), error) {
+	d, err := os.OpenFile(filepath.Dir(path), unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Base(path)
+	if err := acquireLockFile(d, name); err != nil {
+		d.Close()
+		return nil, err
+	}
+	return func() {
+		unix.Unlinkat(int(d.Fd()), name, 0)
+		d.Close()
+	}, nil
+}
+
+// acquireLockFile creates the lock file name in dir, waiting for its
+// holder to remove it.
+func acquireLockFile(dir *os.File, name string) error {
+	path := filepath.Join(dir.Name(), name)
+	hostname, _ := os.Hostname()
+	holder := fmt.Sprintf("%s %d %s", hostname, os.Getpid(), bootID())
+
+	deadline := time.Now().Add(lockFileTimeout)
+	delay := 10 * time.Millisecond
+	for {
+		fd, err := unix.Openat(int(dir.Fd()), name, unix.O_WRONLY|unix.O_CREAT|unix.O_EXCL|unix.O_CLOEXEC, 0600)
+		if err == nil {
+			f := os.NewFile(uintptr(fd), path)
+			_, err = f.WriteString(holder + "\n")
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				unix.Unlinkat(int(dir.Fd()), name, 0)
+				return fmt.Errorf("failed to lock %s: %w", path, err)
+			}
+			return nil
+		}
+		if err != unix.EEXIST {
+			return &os.PathError{Op: "lock", Path: path, Err: err}
+		}
+		held, _ := os.ReadFile(path)
+		if breakStaleLock(path, string(held), hostname) {
+			continue
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("failed to lock: %s is held by %s; remove it if that process is gone", path, strings.TrimSpace(string(held)))
+		}
+		time.Sleep(delay)
+		if delay < 200*time.Millisecond {
+			delay *= 2
+		}
+	}
+}
+
+// breakStaleLock removes the lock file at path if held, what it
+// contains, names a process of this host that is gone: one of an
+// earlier boot, or one that has exited. Those of other hosts can't be
+// checked from here. Lock files from before the boot was recorded name
+// only the host and pid.
+func breakStaleLock(path, held, hostname string) bool {
+	fields := strings.Fields(held)
+	if len(fields) < 2 || len(fields) > 3 || fields[0] != hostname {
+		return false
+	}
+	pid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return false
+	}
+	current := bootID()
+	earlierBoot := len(fields) == 3 && current != "" && fields[2] != current
+	if !earlierBoot && unix.Kill(pid, 0) != unix.ESRCH {
+		return false
+	}
+	// Someone else may have broken it and locked again since
+	if current, _ := os.ReadFile(path); string(current) != held {
+		return false
+	}
+	return os.Remove(path) == nil
+}
+
+// probeLocks marks root as using lock files if it doesn't support flock,
+// which is found by taking and releasing a lock on it. The root is only
+// marked once, and the switch is reported then.
+func probeLocks(root string) error {
+	if _, ok := lockerOf(root).(fileLocker); ok {
+		return nil
+	}
+	supported, err := flockSupported(root)
+	if err != nil || supported {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(root, fileLocksFilename), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	f.Close()
+	// The one flock left, which would now be taken for a held lock file
+	os.Remove(filepath.Join(root, quotaLockFilename))
+	fmt.Fprintf(os.Stderr, "WARNING: %s doesn't support flock; containers created there are locked with lock files from now on\n", root)
+	return nil
+}
+
+func flockSupported(root string) (bool, error) {
+	if os.Getenv(noFlockEnv) != "" {
+		return false, nil
+	}
+	f, err := os.Open(root)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	err = unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	switch {
+	case err == nil:
+		return true, unix.Flock(int(f.Fd()), unix.LOCK_UN)
+	case err == unix.EWOULDBLOCK:
+		// Held by someone else, so locks work
+		return true, nil
+	case flockUnsupported(err):
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to probe locking on %s: %w", root, err)
+}
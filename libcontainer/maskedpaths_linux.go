@@ -0,0 +1,157 @@
+package libcontainer
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/zakarynichols/hackontainer/libcontainer/rootfsfile"
+	"github.com/zakarynichols/hackontainer/libcontainer/specconv"
+	"golang.org/x/sys/unix"
+)
+
+// statfsMountFlags maps the statfs flags of a mount to the mount flags a
+// read-only remount must keep. In a user namespace the kernel refuses a
+// remount that would clear them.
+var statfsMountFlags = map[int64]uintptr{
+	unix.ST_NOSUID:     unix.MS_NOSUID,
+	unix.ST_NODEV:      unix.MS_NODEV,
+	unix.ST_NOEXEC:     unix.MS_NOEXEC,
+	unix.ST_NOATIME:    unix.MS_NOATIME,
+	unix.ST_NODIRATIME: unix.MS_NODIRATIME,
+	unix.ST_RELATIME:   unix.MS_RELATIME,
+}
+
+// maskPaths makes the spec's maskedPaths inaccessible. It runs after
+// pivot_root, with root at the container's /.
+func maskPaths(s sysCalls, root rootfsfile.FS, paths []string) error {
+	for _, path := range paths {
+		if err := maskPath(s, root, path); err != nil {
+			return fmt.Errorf("failed to mask %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// maskPath mounts a read-only empty tmpfs over a directory and
+// /dev/null over anything else. Paths that don't exist are skipped.
+func maskPath(s sysCalls, root rootfsfile.FS, path string) error {
+	target, ok, err := openMaskTarget(root, path)
+	if !ok {
+		return err
+	}
+	defer target.Close()
+
+	var st unix.Stat_t
+	if err := unix.Fstat(int(target.Fd()), &st); err != nil {
+		return &os.PathError{Op: "stat", Path: path, Err: err}
+	}
+	if st.Mode&unix.S_IFMT == unix.S_IFDIR {
+		return mountIn(s, root, path, "tmpfs", "tmpfs", unix.MS_RDONLY|unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, "mode=755")
+	}
+	return mountIn(s, root, path, "/dev/null", "", unix.MS_BIND, "")
+}
+
+// maskWarnings reports masks the process can get around. CAP_SYS_ADMIN
+// lets it unmount them, or mount a fresh proc or sysfs that shows what
+// they cover. In a user namespace the kernel stops that only for mounts
+// locked in place by a more privileged namespace, and the masks are
+// mounted inside the container's own, so they are never locked.
+func maskWarnings(spec *specs.Spec) []string {
+	if spec == nil || spec.Linux == nil || len(spec.Linux.MaskedPaths) == 0 {
+		return nil
+	}
+	if spec.Process == nil || spec.Process.Capabilities == nil || !hasCapability(spec.Process.Capabilities.Bounding, "CAP_SYS_ADMIN") {
+		return nil
+	}
+	where := ""
+	if inUserNamespace(specconv.Namespaces(spec)) {
+		where = " in its user namespace"
+	}
+	return []string{fmt.Sprintf("linux.maskedPaths do not confine a process with CAP_SYS_ADMIN%s: it can unmount them, or mount a fresh proc or sysfs and read what they hide; drop CAP_SYS_ADMIN to rely on them", where)}
+}
+
+// readonlyPaths makes the spec's readonlyPaths read-only, submounts
+// included. Paths that don't exist are skipped.
+func readonlyPaths(s sysCalls, root rootfsfile.FS, paths []string) error {
+	for _, path := range paths {
+		if err := readonlyPath(s, root, path); err != nil {
+			return fmt.Errorf("failed to make %s read-only: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func readonlyPath(s sysCalls, root rootfsfile.FS, path string) error {
+	target, ok, err := openMaskTarget(root, path)
+	if !ok {
+		return err
+	}
+	err = mountIn(s, root, path, rootfsfile.ProcPath(target), "", unix.MS_BIND|unix.MS_REC, "")
+	target.Close()
+	if err != nil {
+		return err
+	}
+
+	// Opened again, to get the bind mount instead of what it covers
+	target, err = root.Open(path)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+
+	kept, err := keptMountFlags(target, path)
+	if err != nil {
+		return err
+	}
+	return mountIn(s, root, path, "", "", unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY|kept, "")
+}
+
+// readonlyRoot remounts the container's root read-only, as
+// root.readonly asks. The mounts on it keep their own flags.
+func readonlyRoot(s sysCalls) error {
+	root, err := os.Open("/")
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	kept, err := keptMountFlags(root, "/")
+	if err != nil {
+		return err
+	}
+	if err := mount(s, "", "/", "", unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY|kept, ""); err != nil {
+		return fmt.Errorf("failed to make the root read-only: %w", err)
+	}
+	return nil
+}
+
+// keptMountFlags returns the flags of the mount target is on that a
+// read-only remount must keep. path names target in errors.
+func keptMountFlags(target *os.File, path string) (uintptr, error) {
+	var st unix.Statfs_t
+	if err := unix.Fstatfs(int(target.Fd()), &st); err != nil {
+		return 0, &os.PathError{Op: "statfs", Path: path, Err: err}
+	}
+	var flags uintptr
+	for statfsFlag, mountFlag := range statfsMountFlags {
+		if st.Flags&statfsFlag != 0 {
+			flags |= mountFlag
+		}
+	}
+	return flags, nil
+}
+
+// openMaskTarget opens what path leads to, following the symlinks sysfs
+// is full of: /sys/class/dmi/id, for one, links to
+// /sys/devices/virtual/dmi/id. The mount type is chosen by what the
+// link points to. ok is false when there is nothing to mount over.
+func openMaskTarget(root rootfsfile.FS, path string) (target *os.File, ok bool, err error) {
+	target, err = root.Open(path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return target, true, nil
+}
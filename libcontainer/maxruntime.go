@@ -0,0 +1,153 @@
+package libcontainer
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/zakarynichols/hackontainer/api/types"
+	"golang.org/x/sys/unix"
+)
+
+// MaxRuntime is a wall-clock limit on the container process.
+type MaxRuntime = types.MaxRuntime
+
+// EventTimeout is recorded when the monitor stops a container for
+// running past its MaxRuntime. Its data has the limit and the signal
+// sent.
+const EventTimeout = "timeout"
+
+// maxRuntimeAnnotation requests a MaxRuntime with the default signal and
+// grace period.
+const maxRuntimeAnnotation = "org.hackontainer.max-runtime"
+
+// defaultStopGracePeriod is how long a container that ran out of time
+// gets to exit after the stop signal.
+const defaultStopGracePeriod = 10 * time.Second
+
+// ParseMaxRuntime parses a limit such as "90s" or "2h" into a MaxRuntime
+// sending SIGTERM, then SIGKILL after defaultStopGracePeriod.
+func ParseMaxRuntime(value string) (*MaxRuntime, error) {
+	limit, err := time.ParseDuration(value)
+	if err != nil || limit <= 0 {
+		return nil, fmt.Errorf("invalid max runtime %q (want a positive duration such as 90s)", value)
+	}
+	return &MaxRuntime{
+		Limit:       limit.String(),
+		StopSignal:  int(unix.SIGTERM),
+		GracePeriod: defaultStopGracePeriod.String(),
+	}, nil
+}
+
+// WithMaxRuntime has the monitor stop the container process once it has
+// run for m.Limit. It overrides the org.hackontainer.max-runtime
+// annotation.
+func WithMaxRuntime(m *MaxRuntime) CreateOption {
+	return func(l *LinuxFactory) error {
+		if _, _, err := maxRuntimeDurations(m); err != nil {
+			return err
+		}
+		if m.StopSignal <= 0 || m.StopSignal > 64 {
+			return fmt.Errorf("invalid max runtime stop signal %d", m.StopSignal)
+		}
+		l.maxRuntime = m
+		return nil
+	}
+}
+
+// maxRuntimeDurations parses the limit and grace period of m.
+func maxRuntimeDurations(m *MaxRuntime) (time.Duration, time.Duration, error) {
+	limit, err := time.ParseDuration(m.Limit)
+	if err != nil || limit <= 0 {
+		return 0, 0, fmt.Errorf("invalid max runtime %q (want a positive duration such as 90s)", m.Limit)
+	}
+	grace, err := time.ParseDuration(m.GracePeriod)
+	if err != nil || grace < 0 {
+		return 0, 0, fmt.Errorf("invalid stop grace period %q", m.GracePeriod)
+	}
+	return limit, grace, nil
+}
+
+// enforceMaxRuntime stops process once it has run past the container's
+// MaxRuntime: the stop signal first, then SIGKILL for everything in the
+// container after the grace period. A paused container's clock keeps
+// running, and as its processes only act on signals once thawed, it
+// ends with the SIGKILL, which thaws it. supervise calls the returned
+// function once process exits.
+func (c *linuxContainer) enforceMaxRuntime(process parentProcess) func() {
+	state, err := c.loadState()
+	if err != nil || state.MaxRuntime == nil {
+		return func() {}
+	}
+	limit, grace, err := maxRuntimeDurations(state.MaxRuntime)
+	if err != nil {
+		c.monitorLog("WARNING: %v", err)
+		return func() {}
+	}
+	sig := unix.Signal(state.MaxRuntime.StopSignal)
+
+	done := make(chan struct{})
+	go func() {
+		// A full create's process is only started, and on the clock,
+		// once start has run
+		for {
+			state, err := c.loadState()
+			if err != nil {
+				return
+			}
+			remaining := limit
+			if state.StartedAt != nil {
+				remaining = time.Until(state.StartedAt.Add(limit))
+				if remaining <= 0 {
+					break
+				}
+			}
+			timer := time.NewTimer(remaining)
+			select {
+			case <-done:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+
+		if !c.recordTimeout(process.pid(), limit, sig) {
+			return
+		}
+		_ = unix.Kill(process.pid(), sig)
+
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+		select {
+		case <-done:
+			return
+		case <-timer.C:
+		}
+		if err := c.signalAll(unix.SIGKILL); err != nil {
+			_ = process.terminate()
+		}
+	}()
+	return func() { close(done) }
+}
+
+// recordTimeout marks the container as stopped for running too long,
+// unless pid is no longer its process.
+func (c *linuxContainer) recordTimeout(pid int, limit time.Duration, sig unix.Signal) bool {
+	unlock, err := c.lock()
+	if err != nil {
+		return false
+	}
+	defer unlock()
+
+	state, err := c.loadState()
+	if err != nil || state.Pid != pid || (state.Status != Running && state.Status != Paused) {
+		return false
+	}
+	state.KilledByTimeout = true
+	if err := c.saveState(state); err != nil {
+		c.monitorLog("WARNING: %v", err)
+	}
+	c.monitorLog("container ran past its max runtime of %s; sending signal %d", limit, int(sig))
+	c.emit(EventTimeout, map[string]string{"maxRuntime": limit.String(), "signal": strconv.Itoa(int(sig))})
+	return true
+}
@@ -0,0 +1,356 @@
+package libcontainer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// monitorReadyOK is written to the ready pipe once the first start of the
+// container process has been recorded in state.
+const monitorReadyOK = "ok"
+
+// monitorLogFilename is the monitor's own log in the container root.
+const monitorLogFilename = "monitor.log"
+
+// startMonitor re-execs the runtime as a detached monitor that starts the
+// container process and outlives this invocation, so exits are recorded
+// and restart policies applied. It returns once the container is running,
+// or after a full create, once the process waits on the exec fifo.
+//
+// A state-only create doesn't leave a process blocked on an exec fifo;
+// nothing runs until the monitor starts it here. A full create does, and
+// one that crashed can leave its fifo behind, which createExecFifo
+// replaces. The ready pipe is new on every start, and a monitor that
+// dies before reporting closes it, which start reports as an error
+// instead of waiting.
+//
+// A deadline on ctx is handed to the monitor, which gives up on the start
+// by then. A monitor that hasn't reported monitorUnwindGrace later is
+// killed along with the container process.
+func (c *linuxContainer) startMonitor(ctx context.Context) error {
+	execPath, err := os.Executable()
+	if err != nil {
+		execPath = os.Args[0]
+	}
+	args := []string{execPath, "monitor", "--container-root", c.root}
+	if len(c.preservedFiles) > 0 {
+		args = append(args, preserveFDsArg, strconv.Itoa(len(c.preservedFiles)))
+	}
+	deadline, hasDeadline := ctx.Deadline()
+	if hasDeadline {
+		args = append(args, "--deadline", deadline.Format(time.RFC3339Nano))
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create monitor pipe: %w", err)
+	}
+	defer readyR.Close()
+
+	// The monitor gets the runtime's reserved variables and the
+	// container's HookEnv ones, with no pid yet since it starts the
+	// process, and nothing else of the runtime's environment
+	env := mergeEnv(internalEnv(), c.hookEnv(c.hookState(specs.StateCreating, 0)))
+
+	cmd := &exec.Cmd{
+		Path:   execPath,
+		Args:   args,
+		Env:    env,
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		Dir:    "/",
+		// The preserved fds first, where the monitor takes them from
+		ExtraFiles: append(append([]*os.File(nil), c.preservedFiles...), readyW),
+		SysProcAttr: &syscall.SysProcAttr{
+			Setsid: true,
+		},
+	}
+
+	err = cmd.Start()
+	readyW.Close()
+	if err != nil {
+		return fmt.Errorf("failed to start monitor: %w", err)
+	}
+	// The monitor is reparented once we exit; don't wait for it
+	defer cmd.Process.Release()
+
+	if hasDeadline {
+		_ = readyR.SetReadDeadline(deadline.Add(monitorUnwindGrace))
+	}
+	msg, err := bufio.NewReader(readyR).ReadString('\n')
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		// Unless it has a terminal of its own, the container process is in
+		// the monitor's process group
+		_ = unix.Kill(-cmd.Process.Pid, unix.SIGKILL)
+		return fmt.Errorf("monitor did not give up on the start within %s of the deadline: %w", monitorUnwindGrace, context.DeadlineExceeded)
+	}
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read monitor status: %w", err)
+	}
+
+	switch status := strings.TrimSpace(msg); status {
+	case monitorReadyOK:
+		return nil
+	case "":
+		return fmt.Errorf("monitor exited before the container started")
+	default:
+		var failure monitorFailure
+		if err := json.Unmarshal([]byte(status), &failure); err != nil {
+			return fmt.Errorf("%s", status)
+		}
+		err := errors.New(failure.Error)
+		if failure.Timeout {
+			err = context.DeadlineExceeded
+		}
+		if failure.Phase != "" {
+			return &StartError{Phase: failure.Phase, Err: err, ExitCode: failure.ExitCode}
+		}
+		return err
+	}
+}
+
+// monitorUnwindGrace is how long after the deadline a monitor has to
+// kill the container process and report.
+const monitorUnwindGrace = 5 * time.Second
+
+// monitorFailure is what the monitor writes to the ready pipe instead of
+// monitorReadyOK, keeping the phase and exit code of a StartError and
+// whether the start ran out of time.
+type monitorFailure struct {
+	Phase    Phase  `json:"phase,omitempty"`
+	Error    string `json:"error"`
+	Timeout  bool   `json:"timeout,omitempty"`
+	ExitCode int    `json:"exitCode,omitempty"`
+}
+
+// reportFailure writes err to the ready pipe and closes it.
+func reportFailure(ready *os.File, err error) {
+	failure := monitorFailure{Error: err.Error(), Timeout: errors.Is(err, context.DeadlineExceeded)}
+	var startErr *StartError
+	if errors.As(err, &startErr) {
+		failure.Phase = startErr.Phase
+		failure.Error = startErr.Err.Error()
+		failure.ExitCode = startErr.ExitCode
+	}
+	_ = json.NewEncoder(ready).Encode(failure)
+	ready.Close()
+}
+
+// RunMonitor is called by main() for the hidden monitor command. It starts
+// the container in containerRoot, or sets it up to wait for start after
+// a full create, giving up at deadline unless it is zero, reports the
+// outcome on ready, then supervises the container process until it
+// exits for good. The monitor holds the preserveFDs fds from 3 on for
+// the container process, and for every restart of it.
+func RunMonitor(containerRoot string, deadline time.Time, ready *os.File, preserveFDs int) error {
+	// Inherited fds aren't close-on-exec; keep the container from holding
+	// the pipe open after we close it
+	unix.CloseOnExec(int(ready.Fd()))
+
+	preserved, err := inheritedFiles(preserveFDs)
+	if err != nil {
+		reportFailure(ready, err)
+		return err
+	}
+	c, err := loadContainer(containerRoot)
+	if err != nil {
+		err = fmt.Errorf("failed to load container: %w", err)
+		reportFailure(ready, err)
+		return err
+	}
+	c.preservedFiles = preserved
+
+	// The container process is forked from here, but moved to the
+	// container's cgroup before it runs anything
+	if err := c.joinRuntimeCgroup(); err != nil {
+		c.monitorLog("WARNING: monitor stays in its cgroup: %v", err)
+	}
+
+	ctx := context.Background()
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	// Restore left a checkpoint to bring back instead of a process to start
+	req, err := c.loadRestoreRequest()
+	var process parentProcess
+	if err == nil && req != nil {
+		process, err = c.restoreInit(req)
+	} else if err == nil {
+		process, err = c.startInit(ctx)
+	}
+	if err != nil {
+		reportFailure(ready, err)
+		return err
+	}
+
+	fmt.Fprintln(ready, monitorReadyOK)
+	ready.Close()
+
+	return c.supervise(process)
+}
+
+// supervise waits for the container process, records how it exited and
+// starts it again while the restart policy asks for it.
+func (c *linuxContainer) supervise(process parentProcess) error {
+	defer c.clearMonitor()
+
+	for {
+		stopClock := c.enforceMaxRuntime(process)
+		exitCode := waitExitCode(process)
+		stopClock()
+		c.exitedAt = time.Now()
+
+		state, err := c.recordExit(exitCode)
+		if err != nil {
+			return err
+		}
+		if state == nil || !shouldRestart(state, exitCode) {
+			return nil
+		}
+
+		time.Sleep(restartBackoff(state.RestartCount))
+
+		if process, err = c.restart(exitCode); process == nil || err != nil {
+			return err
+		}
+	}
+}
+
+// recordExit snapshots the final stats, removes the cgroup, records the
+// exit and runs the poststop hooks, all under the container lock so a
+// concurrent delete waits for it. It returns a nil state if the
+// container was deleted meanwhile.
+func (c *linuxContainer) recordExit(exitCode int) (*State, error) {
+	unlock, err := c.lock()
+	if err != nil {
+		// Deleted while running: nothing left to record
+		return nil, nil
+	}
+	defer unlock()
+
+	state, err := c.loadState()
+	if err != nil {
+		return nil, nil
+	}
+
+	// The process is reaped but its cgroup still holds the counters
+	if err := c.saveFinalStats(); err != nil {
+		c.monitorLog("WARNING: %v", err)
+	}
+
+	// A restart gets a fresh cgroup from startInit
+	if err := c.cgroupManager().Destroy(); err != nil {
+		c.monitorLog("WARNING: %v", err)
+	}
+
+	// A process killed before start leaves the fifo it waited on
+	_ = os.Remove(filepath.Join(c.root, execFifoFilename))
+
+	state.Status = Stopped
+	state.ExitStatus = &exitCode
+	if err := c.saveState(state); err != nil {
+		return nil, fmt.Errorf("failed to record container exit: %w", err)
+	}
+	c.emit(EventStop, map[string]string{"exitStatus": strconv.Itoa(exitCode)})
+
+	// The exit is already recorded; a failing hook only gets logged
+	c.runPoststop(state, func(err error) {
+		c.monitorLog("WARNING: %v", err)
+	})
+
+	return state, nil
+}
+
+// clearMonitor removes the calling monitor from state as it stops
+// supervising the container.
+func (c *linuxContainer) clearMonitor() {
+	unlock, err := c.lock()
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	state, err := c.loadState()
+	if err != nil || state.MonitorPid != os.Getpid() {
+		return
+	}
+	state.MonitorPid = 0
+	if err := c.saveState(state); err != nil {
+		c.monitorLog("WARNING: %v", err)
+	}
+}
+
+// restart starts the container process again after the backoff. It
+// returns a nil process if a kill or delete in the meantime means the
+// container should stay stopped.
+func (c *linuxContainer) restart(exitCode int) (parentProcess, error) {
+	unlock, err := c.lock()
+	if err != nil {
+		return nil, nil
+	}
+	defer unlock()
+
+	state, err := c.loadState()
+	if err != nil || !shouldRestart(state, exitCode) {
+		return nil, nil
+	}
+
+	// Set may have changed the limits since the monitor loaded them
+	cfg, err := loadFrozenConfig(c.root, state.Bundle, filepath.Join(state.Namespace, c.id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to restart container: %w", err)
+	}
+	c.config = cfg
+
+	process, err := c.startInit(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to restart container: %w", err)
+	}
+	return process, nil
+}
+
+// waitExitCode waits for process and converts its status to a shell-style
+// exit code, 128+signal for signaled processes.
+func waitExitCode(process parentProcess) int {
+	ps, err := process.wait()
+	if err != nil || ps == nil {
+		return 255
+	}
+	if status, ok := ps.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return ps.ExitCode()
+}
+
+// monitorLog appends a line to the monitor's log. The monitor outlives
+// the invocation that started it, so there may be no one reading its
+// stderr by the time it has something to report.
+func (c *linuxContainer) monitorLog(format string, args ...interface{}) {
+	line := c.redactSensitive(fmt.Sprintf(format, args...))
+	f, err := os.OpenFile(filepath.Join(c.root, monitorLogFilename), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, line)
+		return
+	}
+	defer f.Close()
+
+	log.New(f, "", log.LstdFlags).Print(line)
+}
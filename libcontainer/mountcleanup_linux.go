@@ -0,0 +1,72 @@
+package libcontainer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// mountsUnder returns the mount points at or below dir in the runtime's
+// mount namespace, deepest first. A path mounted on more than once is
+// listed once per mount.
+func mountsUnder(dir string) ([]string, error) {
+	entries, err := selfMountinfo()
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	var mounts []string
+	for _, m := range entries {
+		if m.mountpoint == dir || strings.HasPrefix(m.mountpoint, prefix) {
+			mounts = append(mounts, m.mountpoint)
+		}
+	}
+	// Later mounts stack on earlier ones at the same path, so among
+	// equals the order of mountinfo is kept, reversed
+	for i, j := 0, len(mounts)-1; i < j; i, j = i+1, j-1 {
+		mounts[i], mounts[j] = mounts[j], mounts[i]
+	}
+	sort.SliceStable(mounts, func(i, j int) bool {
+		return strings.Count(mounts[i], "/") > strings.Count(mounts[j], "/")
+	})
+	return mounts, nil
+}
+
+// unmountUnder unmounts everything mounted at or below dir, deepest
+// first. Each unmount retries while busy as retry says, then falls back
+// to a lazy MNT_DETACH; a mount already gone with its parent is skipped.
+// It fails naming every mount still there afterwards, and what holds it.
+func unmountUnder(dir string, retry RetryPolicy) error {
+	mounts, err := mountsUnder(dir)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, target := range mounts {
+		err := retryBusy(context.Background(), retry, "unmount "+target, func() error {
+			return cleanupUnmount(hostSys, target, 0)
+		})
+		if errors.Is(err, unix.EBUSY) {
+			fmt.Fprintf(os.Stderr, ">>> [CLEANUP] unmount %s still busy, detaching it\n", target)
+			err = cleanupUnmount(hostSys, target, unix.MNT_DETACH)
+		}
+		// EINVAL and ENOENT: nothing is mounted there any more
+		if err != nil && !errors.Is(err, unix.EINVAL) && !errors.Is(err, unix.ENOENT) {
+			errs = append(errs, busyError(err, mountHolders(target)))
+		}
+	}
+
+	left, err := mountsUnder(dir)
+	if err != nil {
+		return err
+	}
+	if len(left) > 0 {
+		return fmt.Errorf("failed to unmount %s: %w", strings.Join(left, ", "), errors.Join(errs...))
+	}
+	return nil
+}
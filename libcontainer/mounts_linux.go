@@ -0,0 +1,303 @@
+package libcontainer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zakarynichols/hackontainer/config"
+	"github.com/zakarynichols/hackontainer/libcontainer/rootfsfile"
+	"golang.org/x/sys/unix"
+)
+
+// lockableMountFlags are the flags a bind mount gets from its source
+// that a remount keeps unless the options clear them. In a user
+// namespace the kernel refuses to clear them when they're locked.
+const lockableMountFlags = unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC
+
+// MountManager applies the resolved mounts inside the container rootfs.
+// It runs in the child before pivot_root, so bind sources are still
+// resolved against the host; destinations are resolved inside root.
+type MountManager struct {
+	sys    sysCalls
+	root   rootfsfile.FS
+	mounts []config.Mount
+
+	// cgroupPaths are the container's v1 cgroup directories, bound into
+	// a cgroup mount. Unused on cgroup v2.
+	cgroupPaths map[string]string
+	// cgroupWritable mounts the cgroup filesystem read-write, for a
+	// delegated cgroup.
+	cgroupWritable bool
+}
+
+func newMountManager(container *linuxContainer, root rootfsfile.FS, s sysCalls) *MountManager {
+	return &MountManager{
+		sys:            s,
+		root:           root,
+		mounts:         container.config.Resolved.Mounts,
+		cgroupPaths:    container.cgroupManager().Paths(),
+		cgroupWritable: cgroupDelegated(container.config.Spec),
+	}
+}
+
+// hasMount reports whether the spec mounts something at destination.
+func (m *MountManager) hasMount(destination string) bool {
+	for _, mnt := range m.mounts {
+		if mnt.Destination == destination {
+			return true
+		}
+	}
+	return false
+}
+
+// Setup performs every mount in spec order. An error names the mount by
+// its index in the spec as well as its destination, as a pod's hundred
+// volumes can share a handful of destinations' worth of prefixes.
+func (m *MountManager) Setup() error {
+	started := time.Now()
+	for i, mnt := range m.mounts {
+		if err := m.mount(mnt); err != nil {
+			return fmt.Errorf("failed to mount mounts[%d] %s: %w", i, mnt.Destination, err)
+		}
+	}
+	// Stdout is the container's by now
+	fmt.Fprintf(os.Stderr, ">>> [CHILD] Applied %d mounts in %s\n", len(m.mounts), time.Since(started).Round(time.Microsecond))
+	return nil
+}
+
+func (m *MountManager) mount(mnt config.Mount) error {
+	dest := mnt.Destination
+
+	switch {
+	case mnt.Bind:
+		if err := m.bindMount(mnt); err != nil {
+			return err
+		}
+	case mnt.Type == "cgroup" || mnt.Type == "cgroup2":
+		if err := m.cgroupMount(dest, mnt.Flags); err != nil {
+			return err
+		}
+	default:
+		if err := mkdirAllIn(m.root, dest); err != nil {
+			return err
+		}
+		if err := mountIn(m.sys, m.root, dest, mnt.Source, mnt.Type, mnt.Flags, mnt.Data); err != nil {
+			return err
+		}
+	}
+
+	changes := propagationChanges(mnt.Propagation)
+	if len(changes) == 0 {
+		return nil
+	}
+	target, err := m.root.Open(dest)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+	for _, p := range changes {
+		if err := mountAt(m.sys, target, dest, "", "", p, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// propagationChanges reduces changes, applied in order, to those that
+// decide the outcome: the last recursive change, which sets the mounts
+// below as well, and the last change, which sets the mount itself. The
+// rest would each cost a mount call to be undone by a later one.
+func propagationChanges(changes []uintptr) []uintptr {
+	if len(changes) == 0 {
+		return nil
+	}
+	last := changes[len(changes)-1]
+	var recursive uintptr
+	for _, p := range changes {
+		if p&unix.MS_REC != 0 {
+			recursive = p
+		}
+	}
+	switch {
+	case recursive == 0:
+		return []uintptr{last}
+	case last&^unix.MS_REC == recursive&^unix.MS_REC:
+		return []uintptr{recursive}
+	default:
+		return []uintptr{recursive, last}
+	}
+}
+
+func (m *MountManager) bindMount(mnt config.Mount) error {
+	dest, source, flags := mnt.Destination, mnt.Source, mnt.Flags
+
+	// Sockets and fifos are bound like any other file
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	if mnt.ReplaceSymlink {
+		if err := removeSymlink(m.root, dest); err != nil {
+			return err
+		}
+	}
+	mountpoint, err := openMountpoint(m.root, dest, info.IsDir())
+	if err != nil {
+		if errors.Is(err, unix.EROFS) {
+			return fmt.Errorf("the mountpoint is missing and can't be created on a read-only mount; create it in the image or in the source of the mount above it: %w", err)
+		}
+		return err
+	}
+	// The mountpoint as created is what gets checked and mounted over,
+	// without resolving dest again for each
+	err = checkMountpoint(mountpoint, dest, source, info.IsDir())
+	if err == nil {
+		// type bind is enough to ask for a bind, without the option
+		err = mountAt(m.sys, mountpoint, dest, source, "bind", unix.MS_BIND|flags&unix.MS_REC, "")
+	}
+	mountpoint.Close()
+	if err != nil {
+		return err
+	}
+
+	// Flags other than bind/rec only take effect on a remount, which
+	// sets them all
+	if flags&^(unix.MS_BIND|unix.MS_REC) != 0 {
+		target, err := m.root.Open(dest)
+		if err != nil {
+			return err
+		}
+		defer target.Close()
+		kept, err := keptMountFlags(target, dest)
+		if err != nil {
+			return err
+		}
+		flags |= kept & lockableMountFlags &^ mnt.ClearedFlags
+		if err := mountAt(m.sys, target, dest, "", "", flags|unix.MS_BIND|unix.MS_REMOUNT, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeSymlink removes a symlink at path inside root, leaving anything
+// else there alone.
+func removeSymlink(root rootfsfile.FS, path string) error {
+	st, err := root.Lstat(path)
+	if errors.Is(err, unix.ENOENT) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFLNK {
+		return nil
+	}
+	return root.Remove(path)
+}
+
+// checkMountpoint fails unless target, the mountpoint at path, is a
+// directory exactly when the source is, which the kernel requires.
+func checkMountpoint(target *os.File, path, source string, dir bool) error {
+	var st unix.Stat_t
+	if err := unix.Fstat(int(target.Fd()), &st); err != nil {
+		return &os.PathError{Op: "stat", Path: path, Err: err}
+	}
+	switch isDir := st.Mode&unix.S_IFMT == unix.S_IFDIR; {
+	case dir && !isDir:
+		return fmt.Errorf("cannot bind directory %s over %s, which is not a directory", source, path)
+	case !dir && isDir:
+		return fmt.Errorf("cannot bind %s, which is not a directory, over directory %s", source, path)
+	}
+	return nil
+}
+
+// cgroupMount mounts the container's own cgroups at dest. It is read-only
+// unless the cgroup was delegated to the container.
+func (m *MountManager) cgroupMount(dest string, flags uintptr) error {
+	if m.cgroupWritable {
+		flags &^= unix.MS_RDONLY
+	}
+
+	if err := mkdirAllIn(m.root, dest); err != nil {
+		return err
+	}
+
+	if isCgroup2UnifiedMode() {
+		return mountIn(m.sys, m.root, dest, "cgroup2", "cgroup2", flags, "")
+	}
+
+	// On v1 there is one hierarchy per controller, so bind each of the
+	// container's cgroups under a tmpfs
+	if err := mountIn(m.sys, m.root, dest, "tmpfs", "tmpfs", flags&^unix.MS_RDONLY, "mode=755"); err != nil {
+		return err
+	}
+	for subsystem, path := range m.cgroupPaths {
+		target := filepath.Join(dest, subsystem)
+		if err := mkdirAllIn(m.root, target); err != nil {
+			return err
+		}
+		if err := mountIn(m.sys, m.root, target, path, "bind", unix.MS_BIND, ""); err != nil {
+			return err
+		}
+		if err := mountIn(m.sys, m.root, target, "", "", flags|unix.MS_BIND|unix.MS_REMOUNT, ""); err != nil {
+			return err
+		}
+	}
+	if flags&unix.MS_RDONLY != 0 {
+		return mountIn(m.sys, m.root, dest, "", "", flags|unix.MS_REMOUNT, "mode=755")
+	}
+	return nil
+}
+
+// mountIn mounts over path, resolved inside root, through s. path is resolved
+// again on every call, so a remount finds the mount an earlier call put
+// there.
+func mountIn(s sysCalls, root rootfsfile.FS, path, source, fstype string, flags uintptr, data string) error {
+	target, err := root.Open(path)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+	return mountAt(s, target, path, source, fstype, flags, data)
+}
+
+// mountAt mounts over target, the file at path opened earlier. A mount
+// made meanwhile at path is not what target refers to, so a remount
+// needs path opened again.
+func mountAt(s sysCalls, target *os.File, path, source, fstype string, flags uintptr, data string) error {
+	if err := s.Mount(source, rootfsfile.ProcPath(target), fstype, flags, data); err != nil {
+		return &os.PathError{Op: "mount", Path: path, Err: err}
+	}
+	return nil
+}
+
+// mkdirAllIn creates the directory path inside root.
+func mkdirAllIn(root rootfsfile.FS, path string) error {
+	dir, err := root.MkdirAll(path, 0755)
+	if err != nil {
+		return err
+	}
+	return dir.Close()
+}
+
+// createMountpoint makes an empty directory or file at path inside root
+// for a mount to cover.
+func createMountpoint(root rootfsfile.FS, path string, dir bool) error {
+	f, err := openMountpoint(root, path, dir)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// openMountpoint is createMountpoint, returning the mountpoint open.
+func openMountpoint(root rootfsfile.FS, path string, dir bool) (*os.File, error) {
+	if dir {
+		return root.MkdirAll(path, 0755)
+	}
+	return root.CreateFile(path, 0644)
+}
@@ -0,0 +1,89 @@
+package libcontainer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zakarynichols/hackontainer/config"
+	"github.com/zakarynichols/hackontainer/libcontainer/rootfsfile"
+	"golang.org/x/sys/unix"
+)
+
+// podMounts is a spec of n mounts like a pod's secrets and config maps:
+// bind mounts of volumes below a few shared prefixes, each made private
+// and then slave, with a tmpfs every tenth.
+func podMounts(b *testing.B, n int) []config.Mount {
+	source := b.TempDir()
+	mounts := make([]config.Mount, 0, n)
+	for i := range n {
+		dest := fmt.Sprintf("/var/run/secrets/pod-%d/volume-%d", i%8, i)
+		if i%10 == 0 {
+			mounts = append(mounts, config.Mount{Destination: dest, Type: "tmpfs", Source: "tmpfs", Flags: unix.MS_NOSUID})
+			continue
+		}
+		mounts = append(mounts, config.Mount{
+			Destination: dest,
+			Source:      source,
+			Bind:        true,
+			Flags:       unix.MS_BIND | unix.MS_REC,
+			Propagation: []uintptr{unix.MS_PRIVATE | unix.MS_REC, unix.MS_SLAVE | unix.MS_REC, unix.MS_SLAVE},
+		})
+	}
+	return mounts
+}
+
+// BenchmarkMountSetup applies a 200-mount spec to a fresh rootfs, with
+// the mount calls themselves faked. The naive loop is what Setup
+// replaced: a mkdir from the rootfs down and a lookup of the destination
+// for every call, and every propagation change applied.
+func BenchmarkMountSetup(b *testing.B) {
+	mounts := podMounts(b, 200)
+	stderr := os.Stderr
+	os.Stderr, _ = os.Open(os.DevNull)
+	b.Cleanup(func() { os.Stderr = stderr })
+
+	run := func(b *testing.B, setup func(rootfs string, root rootfsfile.FS, s sysCalls) error) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			rootfs := b.TempDir()
+			root, err := rootfsfile.Open(rootfs)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.StartTimer()
+			if err := setup(rootfs, root, &faultSys{}); err != nil {
+				b.Fatal(err)
+			}
+			b.StopTimer()
+			root.Close()
+			b.StartTimer()
+		}
+	}
+
+	b.Run("setup", func(b *testing.B) {
+		run(b, func(rootfs string, root rootfsfile.FS, s sysCalls) error {
+			m := &MountManager{sys: s, root: root, mounts: mounts}
+			return m.Setup()
+		})
+	})
+	b.Run("naive", func(b *testing.B) {
+		run(b, func(rootfs string, root rootfsfile.FS, s sysCalls) error {
+			for _, mnt := range mounts {
+				if err := os.MkdirAll(filepath.Join(rootfs, mnt.Destination), 0755); err != nil {
+					return err
+				}
+				if err := mountIn(s, root, mnt.Destination, mnt.Source, mnt.Type, mnt.Flags, mnt.Data); err != nil {
+					return err
+				}
+				for _, p := range mnt.Propagation {
+					if err := mountIn(s, root, mnt.Destination, "", "", p, ""); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+	})
+}
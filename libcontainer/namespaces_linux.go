@@ -0,0 +1,277 @@
+package libcontainer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/zakarynichols/hackontainer/api/types"
+	"github.com/zakarynichols/hackontainer/libcontainer/specconv"
+	"golang.org/x/sys/unix"
+)
+
+// Namespace modes reported by inspect.
+const (
+	NamespaceCreated = types.NamespaceCreated
+	NamespaceJoined  = types.NamespaceJoined
+)
+
+// nsFiles maps spec namespace types to their entry under /proc/<pid>/ns.
+var nsFiles = map[specs.LinuxNamespaceType]string{
+	specs.PIDNamespace:     "pid",
+	specs.NetworkNamespace: "net",
+	specs.MountNamespace:   "mnt",
+	specs.IPCNamespace:     "ipc",
+	specs.UTSNamespace:     "uts",
+	specs.UserNamespace:    "user",
+	specs.CgroupNamespace:  "cgroup",
+	specs.TimeNamespace:    "time",
+}
+
+var nsCloneFlags = map[specs.LinuxNamespaceType]uintptr{
+	specs.PIDNamespace:     unix.CLONE_NEWPID,
+	specs.NetworkNamespace: unix.CLONE_NEWNET,
+	specs.MountNamespace:   unix.CLONE_NEWNS,
+	specs.IPCNamespace:     unix.CLONE_NEWIPC,
+	specs.UTSNamespace:     unix.CLONE_NEWUTS,
+	specs.UserNamespace:    unix.CLONE_NEWUSER,
+	specs.CgroupNamespace:  unix.CLONE_NEWCGROUP,
+	specs.TimeNamespace:    unix.CLONE_NEWTIME,
+}
+
+// joinableNamespaces can be entered with setns from the child on the
+// thread that execs the container process. Mount and user namespaces
+// can't be joined by a multithreaded process, and pid and time only
+// apply to children of the caller.
+var joinableNamespaces = map[specs.LinuxNamespaceType]bool{
+	specs.NetworkNamespace: true,
+	specs.IPCNamespace:     true,
+	specs.UTSNamespace:     true,
+	specs.CgroupNamespace:  true,
+}
+
+// NamespaceInfo describes one namespace of a container.
+type NamespaceInfo = types.NamespaceInfo
+
+// cloneFlags returns the clone flags for every namespace to be created
+// at clone time. A new cgroup namespace is left to the child, which
+// unshares it once it has been moved into the container's cgroup so
+// that cgroup becomes the namespace root.
+func cloneFlags(namespaces []specs.LinuxNamespace) uintptr {
+	var flags uintptr
+	for _, ns := range namespaces {
+		if ns.Path == "" && ns.Type != specs.CgroupNamespace {
+			flags |= nsCloneFlags[ns.Type]
+		}
+	}
+	return flags
+}
+
+// unshareCgroupNamespace creates the container's cgroup namespace if
+// namespaces has a new one.
+func unshareCgroupNamespace(namespaces []specs.LinuxNamespace) error {
+	for _, ns := range namespaces {
+		if ns.Type == specs.CgroupNamespace && ns.Path == "" {
+			if err := unix.Unshare(unix.CLONE_NEWCGROUP); err != nil {
+				return fmt.Errorf("failed to create cgroup namespace: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// inUserNamespace reports whether the container runs in a user
+// namespace, new or joined.
+func inUserNamespace(namespaces []specs.LinuxNamespace) bool {
+	for _, ns := range namespaces {
+		if ns.Type == specs.UserNamespace {
+			return true
+		}
+	}
+	return false
+}
+
+// idMappings converts spec id mappings for SysProcAttr.
+func idMappings(mappings []specs.LinuxIDMapping) []syscall.SysProcIDMap {
+	var ids []syscall.SysProcIDMap
+	for _, m := range mappings {
+		ids = append(ids, syscall.SysProcIDMap{
+			ContainerID: int(m.ContainerID),
+			HostID:      int(m.HostID),
+			Size:        int(m.Size),
+		})
+	}
+	return ids
+}
+
+// validateNamespaces rejects namespace configurations the runtime can't
+// apply, before any state is written.
+func validateNamespaces(spec *specs.Spec) error {
+	seen := make(map[specs.LinuxNamespaceType]bool)
+	for _, ns := range specconv.Namespaces(spec) {
+		if seen[ns.Type] {
+			return fmt.Errorf("duplicate %s namespace", ns.Type)
+		}
+		seen[ns.Type] = true
+
+		if ns.Path == "" {
+			continue
+		}
+		if !joinableNamespaces[ns.Type] {
+			return fmt.Errorf("joining an existing %s namespace is not supported", ns.Type)
+		}
+		if _, err := os.Stat(ns.Path); err != nil {
+			return fmt.Errorf("%s namespace path: %w", ns.Type, err)
+		}
+	}
+	return nil
+}
+
+// joinNamespaces enters the namespaces given a path. It must run on the
+// locked thread that later execs the container process.
+func joinNamespaces(namespaces []specs.LinuxNamespace) error {
+	for _, ns := range namespaces {
+		if ns.Path == "" {
+			continue
+		}
+		fd, err := unix.Open(ns.Path, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return fmt.Errorf("failed to open %s namespace %s: %w", ns.Type, ns.Path, err)
+		}
+		err = unix.Setns(fd, int(nsCloneFlags[ns.Type]))
+		unix.Close(fd)
+		if err != nil {
+			return fmt.Errorf("failed to join %s namespace %s: %w", ns.Type, ns.Path, err)
+		}
+	}
+	return nil
+}
+
+// namespaceInode returns the inode and device of a namespace file.
+func namespaceInode(path string) (uint64, uint64, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return 0, 0, err
+	}
+	return st.Ino, st.Dev, nil
+}
+
+// procRoot is where procfs is read from when verifying namespaces.
+var procRoot = "/proc"
+
+func procNamespacePath(pid int, nsType specs.LinuxNamespaceType) string {
+	return filepath.Join("/proc", fmt.Sprint(pid), "ns", nsFiles[nsType])
+}
+
+// verifyNamespaces checks that the process pid is in exactly the
+// namespaces configured: a namespace to be created must differ from the
+// runtime's own, a joined one must be the one at its path, and any other
+// must be shared with the runtime. Namespace types the kernel doesn't
+// expose are skipped. proc is the procfs root, so the check can be
+// pointed at a fake layout.
+func verifyNamespaces(proc string, pid int, namespaces []specs.LinuxNamespace) error {
+	configured := make(map[specs.LinuxNamespaceType]specs.LinuxNamespace)
+	for _, ns := range namespaces {
+		configured[ns.Type] = ns
+	}
+
+	types := make([]specs.LinuxNamespaceType, 0, len(nsFiles))
+	for nsType := range nsFiles {
+		types = append(types, nsType)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+
+	for _, nsType := range types {
+		liveIno, liveDev, err := namespaceInode(filepath.Join(proc, fmt.Sprint(pid), "ns", nsFiles[nsType]))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return newTypedError(ErrNamespaceMismatch, "failed to read %s namespace of pid %d: %v", nsType, pid, err)
+		}
+
+		ns, ok := configured[nsType]
+		if ok && ns.Path != "" {
+			ino, dev, err := namespaceInode(ns.Path)
+			if err != nil {
+				return newTypedError(ErrNamespaceMismatch, "failed to read joined %s namespace %s: %v", nsType, ns.Path, err)
+			}
+			if ino != liveIno || dev != liveDev {
+				return newTypedError(ErrNamespaceMismatch, "container process is not in the %s namespace at %s", nsType, ns.Path)
+			}
+			continue
+		}
+
+		hostIno, hostDev, err := namespaceInode(filepath.Join(proc, "self", "ns", nsFiles[nsType]))
+		if err != nil {
+			continue
+		}
+		shared := hostIno == liveIno && hostDev == liveDev
+		if ok && shared {
+			return newTypedError(ErrNamespaceMismatch, "container process lacks its own %s namespace", nsType)
+		}
+		if !ok && !shared {
+			return newTypedError(ErrNamespaceMismatch, "container process is in a %s namespace its config does not create or join", nsType)
+		}
+	}
+	return nil
+}
+
+// checkNamespaces refuses op when Load found the live process doesn't
+// match the configured namespaces, or that another host owns it.
+func (c *linuxContainer) checkNamespaces(op string) error {
+	if c.ownerErr != nil {
+		return fmt.Errorf("%w; refusing to %s", c.ownerErr, op)
+	}
+	if c.namespaceErr == nil {
+		return nil
+	}
+	return fmt.Errorf("%w; refusing to %s", c.namespaceErr, op)
+}
+
+// NamespacePaths returns the /proc/<pid>/ns path of every configured
+// namespace of a running container.
+func (c *linuxContainer) NamespacePaths() (map[specs.LinuxNamespaceType]string, error) {
+	state, err := c.State()
+	if err != nil {
+		return nil, err
+	}
+	if (state.Status != Running && state.Status != Paused) || state.Pid == 0 {
+		return nil, fmt.Errorf("container is not running")
+	}
+	if err := c.checkNamespaces("join its namespaces"); err != nil {
+		return nil, err
+	}
+
+	paths := make(map[specs.LinuxNamespaceType]string)
+	for _, ns := range c.config.Resolved.Namespaces {
+		paths[ns.Type] = procNamespacePath(state.Pid, ns.Type)
+	}
+	return paths, nil
+}
+
+// namespaceInfo reports every configured namespace. pid is 0 when the
+// container process isn't running, in which case the live identity is
+// left unknown.
+func (c *linuxContainer) namespaceInfo(pid int) map[specs.LinuxNamespaceType]NamespaceInfo {
+	infos := make(map[specs.LinuxNamespaceType]NamespaceInfo)
+	for _, ns := range c.config.Resolved.Namespaces {
+		info := NamespaceInfo{Mode: NamespaceCreated}
+		if ns.Path != "" {
+			info.Mode = NamespaceJoined
+			info.Path = ns.Path
+		}
+		if pid > 0 {
+			if ino, dev, err := namespaceInode(procNamespacePath(pid, ns.Type)); err == nil {
+				info.Inode = ino
+				info.Device = dev
+				info.Known = true
+			}
+		}
+		infos[ns.Type] = info
+	}
+	return infos
+}
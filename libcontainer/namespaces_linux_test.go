@@ -0,0 +1,138 @@
+package libcontainer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// fakeProc lays out a procfs with the runtime at self and the container
+// process at 42. The container shares the files named in shared with
+// self, by hard link so they have the same inode, and has its own of the
+// rest of present.
+func fakeProc(t *testing.T, present, shared []string) string {
+	t.Helper()
+	proc := t.TempDir()
+	for _, dir := range []string{"self/ns", "42/ns"} {
+		if err := os.MkdirAll(filepath.Join(proc, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range present {
+		if err := os.WriteFile(filepath.Join(proc, "self/ns", file), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(proc, "42/ns", file), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range shared {
+		live := filepath.Join(proc, "42/ns", file)
+		if err := os.Remove(live); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Link(filepath.Join(proc, "self/ns", file), live); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return proc
+}
+
+func TestVerifyNamespaces(t *testing.T) {
+	proc := fakeProc(t, []string{"pid", "net", "mnt"}, []string{"net"})
+	// A namespace at a path, hard linked to the process's own
+	joined := filepath.Join(t.TempDir(), "pidns")
+	if err := os.Link(filepath.Join(proc, "42/ns/pid"), joined); err != nil {
+		t.Fatal(err)
+	}
+	other := filepath.Join(t.TempDir(), "otherns")
+	if err := os.WriteFile(other, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		pid        int
+		namespaces []specs.LinuxNamespace
+		// want is in the error, or it verifies if empty
+		want string
+	}{
+		{
+			name:       "created namespaces have their own inode",
+			pid:        42,
+			namespaces: []specs.LinuxNamespace{{Type: specs.PIDNamespace}, {Type: specs.MountNamespace}},
+		},
+		{
+			name:       "a joined namespace matches its path",
+			pid:        42,
+			namespaces: []specs.LinuxNamespace{{Type: specs.PIDNamespace, Path: joined}, {Type: specs.MountNamespace}},
+		},
+		{
+			name:       "a joined namespace at another path",
+			pid:        42,
+			namespaces: []specs.LinuxNamespace{{Type: specs.PIDNamespace, Path: other}, {Type: specs.MountNamespace}},
+			want:       "not in the pid namespace at " + other,
+		},
+		{
+			name:       "a created namespace shared with the runtime",
+			pid:        42,
+			namespaces: []specs.LinuxNamespace{{Type: specs.PIDNamespace}, {Type: specs.MountNamespace}, {Type: specs.NetworkNamespace}},
+			want:       "lacks its own network namespace",
+		},
+		{
+			name:       "a namespace the config doesn't have",
+			pid:        42,
+			namespaces: []specs.LinuxNamespace{{Type: specs.PIDNamespace}},
+			want:       "in a mount namespace its config does not create or join",
+		},
+		{
+			name:       "a joined namespace that is gone",
+			pid:        42,
+			namespaces: []specs.LinuxNamespace{{Type: specs.PIDNamespace, Path: filepath.Join(proc, "gone")}, {Type: specs.MountNamespace}},
+			want:       "failed to read joined pid namespace",
+		},
+		{
+			// The kernel exposes no uts, ipc, user, cgroup or time here
+			name:       "ns files the kernel lacks are skipped",
+			pid:        42,
+			namespaces: []specs.LinuxNamespace{{Type: specs.PIDNamespace}, {Type: specs.MountNamespace}, {Type: specs.UTSNamespace}, {Type: specs.TimeNamespace}},
+		},
+		{
+			// Nothing is left to mismatch; state reports it stopped
+			name:       "a pid that has exited",
+			pid:        43,
+			namespaces: []specs.LinuxNamespace{{Type: specs.PIDNamespace}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyNamespaces(proc, tt.pid, tt.namespaces)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("got %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrNamespaceMismatch) || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessesRefusesNamespaceMismatch(t *testing.T) {
+	c := testContainer(t)
+	c.root = t.TempDir()
+	if err := c.saveState(&State{ID: c.id, Status: Running, Pid: os.Getpid()}); err != nil {
+		t.Fatal(err)
+	}
+	c.namespaceErr = newTypedError(ErrNamespaceMismatch, "container process lacks its own pid namespace")
+	_, err := c.Processes()
+	if !errors.Is(err, ErrNamespaceMismatch) || !strings.Contains(err.Error(), "refusing to list its processes") {
+		t.Fatalf("got %v, want ps refused", err)
+	}
+}
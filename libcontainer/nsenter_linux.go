@@ -0,0 +1,369 @@
+package libcontainer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// nsHelperArg is the argument RunInNamespaces re-executes the runtime
+// with. Like the init stage, the helper is part of whatever binary
+// embeds libcontainer.
+const nsHelperArg = "--nsenter"
+
+// helperNamespaces can be joined by the helper before it execs. A
+// multithreaded process can't join a user namespace, and pid and time
+// namespaces only apply to children of the caller, which the helper
+// doesn't fork.
+var helperNamespaces = map[NamespaceType]bool{
+	specs.NetworkNamespace: true,
+	specs.MountNamespace:   true,
+	specs.IPCNamespace:     true,
+	specs.UTSNamespace:     true,
+	specs.CgroupNamespace:  true,
+}
+
+// IsNamespaceHelper reports whether args, as in os.Args, are those of a
+// helper started by RunInNamespaces. The binary's main must hand such a
+// process to RunNamespaceHelper, as it does with IsInit and RunInit.
+func IsNamespaceHelper(args []string) bool {
+	return len(args) > 1 && args[1] == nsHelperArg
+}
+
+// RunInNamespaces runs cmd in the given namespaces of the container
+// process. cmd.Path is opened before any namespace is joined, so it is
+// a host binary even in the container's mount namespace; it should be
+// static, as the libraries of a dynamic one are looked up in the
+// container's rootfs.
+//
+// cmd is run to completion as by cmd.Run, with its Args, Env, standard
+// streams and ExtraFiles. cmd.Dir is taken inside the namespaces. When
+// cmd.Stderr is nil, what the binary wrote there is kept in the
+// returned *exec.ExitError, as cmd.Output does.
+func (c *linuxContainer) RunInNamespaces(nsTypes []NamespaceType, cmd *exec.Cmd) error {
+	if len(nsTypes) == 0 {
+		return fmt.Errorf("no namespaces to run in")
+	}
+	names := make([]string, 0, len(nsTypes))
+	for _, nsType := range nsTypes {
+		if !helperNamespaces[nsType] {
+			return fmt.Errorf("cannot run in the %s namespace: only network, mount, ipc, uts and cgroup namespaces can be joined", nsType)
+		}
+		names = append(names, string(nsType))
+	}
+
+	pid, startTime, err := c.liveInit("run in its namespaces")
+	if err != nil {
+		return err
+	}
+	execPath, err := os.Executable()
+	if err != nil {
+		execPath = os.Args[0]
+	}
+
+	// The helper reports a failure to get as far as the exec on a pipe
+	// that the exec closes
+	errRead, errWrite, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer errRead.Close()
+
+	args := []string{
+		execPath, nsHelperArg,
+		"--pid", strconv.Itoa(pid),
+		"--start-time", strconv.FormatUint(startTime, 10),
+		"--namespaces", strings.Join(names, ","),
+		"--error-fd", strconv.Itoa(3 + len(cmd.ExtraFiles)),
+	}
+	if cmd.Dir != "" {
+		args = append(args, "--dir", cmd.Dir)
+	}
+	args = append(args, "--", cmd.Path)
+	if len(cmd.Args) > 0 {
+		args = append(args, cmd.Args...)
+	} else {
+		args = append(args, cmd.Path)
+	}
+
+	helper := &exec.Cmd{
+		Path:       execPath,
+		Args:       args,
+		Env:        cmd.Env,
+		Stdin:      cmd.Stdin,
+		Stdout:     cmd.Stdout,
+		Stderr:     cmd.Stderr,
+		ExtraFiles: append(append([]*os.File(nil), cmd.ExtraFiles...), errWrite),
+	}
+	var stderr bytes.Buffer
+	if helper.Stderr == nil {
+		helper.Stderr = &stderr
+	}
+	err = helper.Start()
+	errWrite.Close()
+	if err != nil {
+		return err
+	}
+	helperErr, _ := io.ReadAll(errRead)
+	err = helper.Wait()
+	cmd.Process, cmd.ProcessState = helper.Process, helper.ProcessState
+
+	if len(helperErr) > 0 {
+		return fmt.Errorf("failed to run %s in the namespaces of container %s: %s", cmd.Path, c.id, helperErr)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && cmd.Stderr == nil {
+		exitErr.Stderr = stderr.Bytes()
+	}
+	return err
+}
+
+// The values of the helper's --exec argument: the binary is a host one,
+// opened first and exec'd in place of the helper, or one looked up in
+// the container, forked and waited for as Exec does.
+const (
+	helperExecHost      = "host"
+	helperExecContainer = "container"
+)
+
+// RunNamespaceHelper is called by main() for a process IsNamespaceHelper
+// recognizes. It only returns if the binary couldn't be executed.
+func RunNamespaceHelper(args []string) error {
+	var pid, errorFd, stderrFd, preserveFDs int
+	var startTime uint64
+	var namespaces, dir, user, threads string
+	var procs []string
+	mode := helperExecHost
+	i := 2
+	for ; i+1 < len(args) && args[i] != "--"; i += 2 {
+		value := args[i+1]
+		var err error
+		switch args[i] {
+		case "--pid":
+			pid, err = strconv.Atoi(value)
+		case "--start-time":
+			startTime, err = strconv.ParseUint(value, 10, 64)
+		case "--namespaces":
+			namespaces = value
+		case "--error-fd":
+			errorFd, err = strconv.Atoi(value)
+		case "--dir":
+			dir = value
+		case "--exec":
+			mode = value
+			if mode != helperExecHost && mode != helperExecContainer {
+				err = fmt.Errorf("unknown mode")
+			}
+		case "--stderr-fd":
+			stderrFd, err = strconv.Atoi(value)
+		case "--user":
+			user = value
+		case "--cgroup-procs":
+			procs = append(procs, value)
+		case "--cgroup-threads":
+			threads = value
+		case preserveFDsArg:
+			preserveFDs, err = strconv.Atoi(value)
+		default:
+			return fmt.Errorf("unknown namespace helper argument %q", args[i])
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s %q", args[i], value)
+		}
+	}
+	if i+2 >= len(args) || args[i] != "--" || pid == 0 || namespaces == "" || errorFd < 3 {
+		return fmt.Errorf("namespace helper needs --pid, --namespaces, --error-fd and a command")
+	}
+
+	unix.CloseOnExec(errorFd)
+	errFile := os.NewFile(uintptr(errorFd), "error")
+	var nsTypes []NamespaceType
+	for _, name := range strings.Split(namespaces, ",") {
+		nsTypes = append(nsTypes, NamespaceType(name))
+	}
+	var err error
+	if mode == helperExecContainer {
+		stderr := os.Stderr
+		if stderrFd >= 3 {
+			unix.CloseOnExec(stderrFd)
+			stderr = os.NewFile(uintptr(stderrFd), "stderr")
+		}
+		var preserved []*os.File
+		if preserved, err = inheritedFiles(preserveFDs); err == nil {
+			err = closeExecFrom(3 + preserveFDs)
+		}
+		if err == nil {
+			err = forkInNamespaces(pid, startTime, nsTypes, dir, user, procs, threads, stderr, preserved, args[i+1], args[i+2:])
+		}
+	} else {
+		err = execInNamespaces(pid, startTime, nsTypes, dir, args[i+1], args[i+2:])
+	}
+	fmt.Fprint(errFile, err)
+	return err
+}
+
+// execInNamespaces joins the namespaces of pid and execs path there.
+func execInNamespaces(pid int, startTime uint64, nsTypes []NamespaceType, dir, path string, argv []string) error {
+	binary, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	files, err := openNamespaces(pid, startTime, nsTypes)
+	if err != nil {
+		return err
+	}
+
+	// Never unlocked: the thread either execs or the helper exits
+	runtime.LockOSThread()
+	for i, nsType := range nsTypes {
+		if nsType == specs.MountNamespace {
+			// A mount namespace can't be joined while the filesystem
+			// information is shared with the other threads
+			if err := unix.Unshare(unix.CLONE_FS); err != nil {
+				return fmt.Errorf("failed to unshare filesystem information: %w", err)
+			}
+		}
+		if err := unix.Setns(int(files[i].Fd()), int(nsCloneFlags[nsType])); err != nil {
+			return fmt.Errorf("failed to join %s namespace: %w", nsType, err)
+		}
+	}
+	if dir != "" {
+		if err := unix.Chdir(dir); err != nil {
+			return &os.PathError{Op: "chdir", Path: dir, Err: err}
+		}
+	}
+	return execveat(binary, argv, os.Environ())
+}
+
+// execveat execs the file open at fd. x/sys/unix has no wrapper for it.
+func execveat(fd int, argv, env []string) error {
+	argvp, err := syscall.SlicePtrFromStrings(argv)
+	if err != nil {
+		return err
+	}
+	envp, err := syscall.SlicePtrFromStrings(env)
+	if err != nil {
+		return err
+	}
+	empty, err := unix.BytePtrFromString("")
+	if err != nil {
+		return err
+	}
+	_, _, errno := unix.Syscall6(unix.SYS_EXECVEAT, uintptr(fd), uintptr(unsafe.Pointer(empty)),
+		uintptr(unsafe.Pointer(&argvp[0])), uintptr(unsafe.Pointer(&envp[0])), unix.AT_EMPTY_PATH, 0)
+	return fmt.Errorf("failed to exec %s: %w", argv[0], errno)
+}
+
+// NetNSDo runs fn in the container's network namespace, in process. The
+// calling goroutine is locked to its OS thread, which joins the
+// namespace for the duration of fn and then returns to its own.
+//
+// Only that thread is in the namespace. fn must not hand work to other
+// goroutines, or start ones that expect the namespace, as they may run
+// on other threads; the net package's cgo resolver is one such case.
+// Sockets fn opens stay in the container's namespace after it returns.
+// Should the thread fail to return to its own namespace, the goroutine
+// is left locked to it, so the thread is thrown away when the goroutine
+// exits rather than reused.
+func (c *linuxContainer) NetNSDo(fn func() error) error {
+	pid, startTime, err := c.liveInit("join its network namespace")
+	if err != nil {
+		return err
+	}
+	return threadNSDo(pid, startTime, specs.NetworkNamespace, fn)
+}
+
+// threadNSDo runs fn on the calling goroutine's thread while it is in the
+// nsType namespace of pid, which must be one a thread can enter on its
+// own. See NetNSDo for what fn may do.
+func threadNSDo(pid int, startTime uint64, nsType NamespaceType, fn func() error) error {
+	files, err := openNamespaces(pid, startTime, []NamespaceType{nsType})
+	if err != nil {
+		return err
+	}
+	defer files[0].Close()
+
+	flag := int(nsCloneFlags[nsType])
+	runtime.LockOSThread()
+	own, err := os.Open("/proc/thread-self/ns/" + nsFiles[nsType])
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	defer own.Close()
+	if err := unix.Setns(int(files[0].Fd()), flag); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("failed to join %s namespace: %w", nsType, err)
+	}
+
+	fnErr := fn()
+	if err := unix.Setns(int(own.Fd()), flag); err != nil {
+		return fmt.Errorf("failed to return to the runtime's %s namespace: %w", nsType, err)
+	}
+	runtime.UnlockOSThread()
+	return fnErr
+}
+
+// liveInit returns the pid and start time of the running container
+// process, refusing op when the process doesn't match the container's
+// config.
+func (c *linuxContainer) liveInit(op string) (int, uint64, error) {
+	state, err := c.State()
+	if err != nil {
+		return 0, 0, err
+	}
+	if state.Status == Paused {
+		return 0, 0, fmt.Errorf("container is paused")
+	}
+	if state.Status != Running || state.Pid == 0 {
+		return 0, 0, fmt.Errorf("container is not running")
+	}
+	if err := c.checkNamespaces(op); err != nil {
+		return 0, 0, err
+	}
+	startTime, err := getProcessStartTime(state.Pid)
+	if err != nil || (state.InitProcessStartTime != 0 && startTime != state.InitProcessStartTime) {
+		return 0, 0, fmt.Errorf("container process %d has exited", state.Pid)
+	}
+	return state.Pid, startTime, nil
+}
+
+// openNamespaces opens the nsTypes namespaces of pid. The start time is
+// checked once they're open, so a pid reused since can't hand out some
+// other process's namespaces.
+func openNamespaces(pid int, startTime uint64, nsTypes []NamespaceType) ([]*os.File, error) {
+	files := make([]*os.File, 0, len(nsTypes))
+	closeAll := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	for _, nsType := range nsTypes {
+		if _, ok := nsFiles[nsType]; !ok {
+			closeAll()
+			return nil, fmt.Errorf("unknown namespace type %q", nsType)
+		}
+		f, err := os.Open(procNamespacePath(pid, nsType))
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	if now, err := getProcessStartTime(pid); err != nil || now != startTime {
+		closeAll()
+		return nil, fmt.Errorf("container process %d has exited", pid)
+	}
+	return files, nil
+}
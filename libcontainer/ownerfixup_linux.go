@@ -0,0 +1,186 @@
+package libcontainer
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/zakarynichols/hackontainer/libcontainer/specconv"
+)
+
+// ownerFixupMarker is written to the top of a fixed-up source and
+// records the host uid:gid it was chowned to. A later container running
+// as the same user leaves the source alone.
+const ownerFixupMarker = ".hackontainer-owner"
+
+// ownerFixupDenied can never be allowed for owner fixup, nor can
+// anything that contains them. Chowning them would break the host.
+var ownerFixupDenied = []string{
+	"/", "/bin", "/boot", "/dev", "/etc", "/home", "/lib", "/lib32", "/lib64",
+	"/proc", "/root", "/run", "/sbin", "/sys", "/usr", "/var",
+}
+
+// WithOwnerFixupAllow allows owner-fixup mounts whose source lies below
+// one of dirs. Without it, configs using owner-fixup are rejected. The
+// allow-list is the operator's, not the bundle's: a config can't widen
+// it.
+func WithOwnerFixupAllow(dirs ...string) CreateOption {
+	return func(l *LinuxFactory) error {
+		// Copied, since Create's copy of the factory shares the array
+		allow := append([]string(nil), l.ownerFixupAllow...)
+		for _, dir := range dirs {
+			if !filepath.IsAbs(dir) {
+				return fmt.Errorf("owner fixup directory %q must be absolute", dir)
+			}
+			resolved, err := filepath.EvalSymlinks(dir)
+			if err != nil {
+				return fmt.Errorf("owner fixup directory: %w", err)
+			}
+			if denied := ownerFixupDeniedDir(resolved); denied != "" {
+				return fmt.Errorf("owner fixup directory %s can't be allowed: it would cover %s", dir, denied)
+			}
+			allow = append(allow, resolved)
+		}
+		l.ownerFixupAllow = allow
+		return nil
+	}
+}
+
+// ownerFixupDeniedDir returns the denied directory that dir is or
+// contains, or "".
+func ownerFixupDeniedDir(dir string) string {
+	for _, denied := range ownerFixupDenied {
+		if pathWithin(denied, dir) {
+			return denied
+		}
+	}
+	return ""
+}
+
+// pathWithin reports whether path is dir or lies below it. Both must be
+// clean and absolute.
+func pathWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// ownerFixup is the chown an owner-fixup mount asks for.
+type ownerFixup struct {
+	source   string
+	uid, gid int
+}
+
+// ownerFixups checks the spec's owner-fixup mounts against allow and
+// returns the chowns they need. The ids are the host ids of the
+// process's user, which in a new user namespace go through its
+// mappings.
+func ownerFixups(spec *specs.Spec, bundle string, allow []string) ([]ownerFixup, error) {
+	var fixups []ownerFixup
+	for _, m := range spec.Mounts {
+		mnt := specconv.Mount(m, bundle)
+		if !mnt.OwnerFixup {
+			continue
+		}
+		if !mnt.Bind {
+			return nil, fmt.Errorf("mount %s: %s only applies to bind mounts", mnt.Destination, specconv.OwnerFixupOption)
+		}
+		if len(allow) == 0 {
+			return nil, fmt.Errorf("mount %s: %s is used but no directory was allowed for owner fixup (--owner-fixup-allow)", mnt.Destination, specconv.OwnerFixupOption)
+		}
+
+		// Resolved so a symlink can't lead out of the allowed directories
+		resolved, err := filepath.EvalSymlinks(mnt.Source)
+		if err != nil {
+			return nil, fmt.Errorf("mount %s: %w", mnt.Destination, err)
+		}
+		if !ownerFixupAllowed(resolved, allow) {
+			return nil, fmt.Errorf("mount %s: %s is not below a directory allowed for owner fixup", mnt.Destination, resolved)
+		}
+
+		uid, gid, err := processHostIDs(spec)
+		if err != nil {
+			return nil, fmt.Errorf("mount %s: %w", mnt.Destination, err)
+		}
+		fixups = append(fixups, ownerFixup{source: resolved, uid: uid, gid: gid})
+	}
+	return fixups, nil
+}
+
+// ownerFixupAllowed reports whether source lies strictly below one of
+// the allowed directories. An allowed directory itself is shared by
+// every volume below it, so it is never chowned for one container.
+func ownerFixupAllowed(source string, allow []string) bool {
+	if ownerFixupDeniedDir(source) != "" {
+		return false
+	}
+	for _, dir := range allow {
+		if source != dir && pathWithin(source, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// processHostIDs returns the host uid and gid of the process's user.
+func processHostIDs(spec *specs.Spec) (int, int, error) {
+	var user specs.User
+	if spec.Process != nil {
+		user = spec.Process.User
+	}
+	if !newUserNamespace(spec) {
+		return int(user.UID), int(user.GID), nil
+	}
+
+	uid, ok := hostID(spec.Linux.UIDMappings, user.UID)
+	if !ok {
+		return 0, 0, fmt.Errorf("uid mappings do not map uid %d", user.UID)
+	}
+	gid, ok := hostID(spec.Linux.GIDMappings, user.GID)
+	if !ok {
+		return 0, 0, fmt.Errorf("gid mappings do not map gid %d", user.GID)
+	}
+	return uid, gid, nil
+}
+
+func newUserNamespace(spec *specs.Spec) bool {
+	for _, ns := range specconv.Namespaces(spec) {
+		if ns.Type == specs.UserNamespace && ns.Path == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// apply chowns the source tree unless the marker shows it already
+// belongs to the same ids. Symlinks are chowned themselves, never
+// followed.
+func (f ownerFixup) apply() error {
+	owner := strconv.Itoa(f.uid) + ":" + strconv.Itoa(f.gid)
+	marker := filepath.Join(f.source, ownerFixupMarker)
+	if data, err := os.ReadFile(marker); err == nil && strings.TrimSpace(string(data)) == owner {
+		return nil
+	}
+
+	err := filepath.WalkDir(f.source, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, f.uid, f.gid)
+	})
+	if err != nil {
+		return fmt.Errorf("owner fixup of %s: %w", f.source, err)
+	}
+
+	if info, err := os.Stat(f.source); err != nil || !info.IsDir() {
+		// A file source has nowhere to keep the marker
+		return err
+	}
+	if err := os.WriteFile(marker, []byte(owner+"\n"), 0644); err != nil {
+		return fmt.Errorf("owner fixup of %s: %w", f.source, err)
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package libcontainer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// WithNoPivotRoot makes the container's root by moving the rootfs onto
+// / and chrooting into it, as runc's --no-pivot does, for a rootfs that
+// pivot_root can't leave, such as the initramfs. chroot is weaker: a
+// process with CAP_SYS_CHROOT can get out of it, so it is only for hosts
+// where pivot_root can't work.
+func WithNoPivotRoot() CreateOption {
+	return func(l *LinuxFactory) error {
+		l.noPivotRoot = true
+		return nil
+	}
+}
+
+// mountEntry is a line of /proc/self/mountinfo, as far as pivot_root's
+// preconditions, what moving the root has to hide, and inspect need it.
+type mountEntry struct {
+	id         int
+	parent     int
+	root       string
+	mountpoint string
+	fstype     string
+	source     string
+	// options are the per-mount options followed by the superblock's
+	options []string
+	// shared is set by a "shared:N" optional field, and slave by a
+	// "master:N" one
+	shared     bool
+	slave      bool
+	unbindable bool
+}
+
+// parseMountinfo parses a mountinfo file read from r, in order.
+// Malformed lines are skipped.
+func parseMountinfo(r io.Reader) ([]mountEntry, error) {
+	var entries []mountEntry
+	scanner := bufio.NewScanner(r)
+	// Escaped paths make lines longer than the default limit possible
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		// id parent major:minor root mountpoint options [optional...] - type source superoptions
+		pre, post, ok := strings.Cut(line, " - ")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(pre)
+		if len(fields) < 6 {
+			continue
+		}
+		id, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		parent, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		entry := mountEntry{
+			id:         id,
+			parent:     parent,
+			root:       unescapeMountinfo(fields[3]),
+			mountpoint: unescapeMountinfo(fields[4]),
+			options:    strings.Split(fields[5], ","),
+		}
+		postFields := strings.Fields(post)
+		if len(postFields) > 0 {
+			entry.fstype = postFields[0]
+		}
+		if len(postFields) > 1 {
+			entry.source = unescapeMountinfo(postFields[1])
+		}
+		if len(postFields) > 2 {
+			entry.options = append(entry.options, strings.Split(postFields[2], ",")...)
+		}
+		for _, optional := range fields[6:] {
+			switch {
+			case strings.HasPrefix(optional, "shared:"):
+				entry.shared = true
+			case strings.HasPrefix(optional, "master:"):
+				entry.slave = true
+			case optional == "unbindable":
+				entry.unbindable = true
+			}
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read mountinfo: %w", err)
+	}
+	return entries, nil
+}
+
+// selfMountinfo parses the mountinfo of the calling process.
+func selfMountinfo() ([]mountEntry, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mountinfo: %w", err)
+	}
+	defer f.Close()
+	return parseMountinfo(f)
+}
+
+// pivotProblem is a precondition of pivot_root that doesn't hold.
+type pivotProblem struct {
+	// mountpoint is where the problem is, and what a fix acts on
+	mountpoint string
+	notMount   bool
+	reason     string
+}
+
+// checkPivotRoot finds the preconditions of pivot_root(rootfs, rootfs)
+// that mounts break, each of which would fail it with EINVAL: rootfs
+// must be a mount point, and neither it, the mount it is on nor the
+// current root may propagate as shared.
+func checkPivotRoot(mounts []mountEntry, rootfs string) []pivotProblem {
+	// Later entries are mounted on top of earlier ones, and only the
+	// last one at a path is visible
+	byID := make(map[int]mountEntry, len(mounts))
+	var root, target *mountEntry
+	for i := range mounts {
+		byID[mounts[i].id] = mounts[i]
+		switch mounts[i].mountpoint {
+		case "/":
+			root = &mounts[i]
+		case rootfs:
+			target = &mounts[i]
+		}
+	}
+
+	var problems []pivotProblem
+	if target == nil {
+		return append(problems, pivotProblem{
+			mountpoint: rootfs,
+			notMount:   true,
+			reason:     fmt.Sprintf("rootfs %s is not a mount point", rootfs),
+		})
+	}
+	if target.shared {
+		problems = append(problems, pivotProblem{
+			mountpoint: rootfs,
+			reason:     fmt.Sprintf("rootfs %s has shared propagation", rootfs),
+		})
+	}
+	if parent, ok := byID[target.parent]; ok && parent.shared && parent.mountpoint != rootfs {
+		problems = append(problems, pivotProblem{
+			mountpoint: parent.mountpoint,
+			reason:     fmt.Sprintf("%s, the mount rootfs %s is on, has shared propagation", parent.mountpoint, rootfs),
+		})
+	}
+	if root != nil && root.shared && root.mountpoint != rootfs {
+		problems = append(problems, pivotProblem{
+			mountpoint: "/",
+			reason:     "the current root has shared propagation",
+		})
+	}
+	return problems
+}
+
+// ensurePivotable checks pivot_root's preconditions for rootfs and fixes
+// the ones it can: rootfs is bound onto itself again, and shared mounts
+// are made private. Whatever still fails is explained, rather than left
+// to pivot_root's bare EINVAL.
+func ensurePivotable(s sysCalls, rootfs string) error {
+	resolved, err := filepath.EvalSymlinks(rootfs)
+	if err != nil {
+		return fmt.Errorf("failed to resolve rootfs: %w", err)
+	}
+
+	problems, err := pivotProblems(resolved)
+	if err != nil || len(problems) == 0 {
+		return err
+	}
+	for _, p := range problems {
+		fmt.Fprintf(os.Stderr, ">>> [CHILD] Fixing pivot_root precondition: %s\n", p.reason)
+		if p.notMount {
+			err = mount(s, resolved, resolved, "bind", unix.MS_BIND|unix.MS_REC, "")
+		} else {
+			err = mount(s, "", p.mountpoint, "", unix.MS_PRIVATE, "")
+		}
+		if err != nil {
+			return fmt.Errorf("%s, and fixing that failed: %w (try --no-pivot)", p.reason, err)
+		}
+	}
+
+	problems, err = pivotProblems(resolved)
+	if err != nil || len(problems) == 0 {
+		return err
+	}
+	reasons := make([]string, len(problems))
+	for i, p := range problems {
+		reasons[i] = p.reason
+	}
+	return fmt.Errorf("pivot_root would fail: %s (try --no-pivot)", strings.Join(reasons, "; "))
+}
+
+// pivotProblems checks the current mounts for rootfs.
+func pivotProblems(rootfs string) ([]pivotProblem, error) {
+	mounts, err := selfMountinfo()
+	if err != nil {
+		return nil, err
+	}
+	return checkPivotRoot(mounts, rootfs), nil
+}
+
+// moveRoot makes rootfs, the current directory, the root without
+// pivot_root: it is moved onto / and chrooted into. The old root stays
+// mounted below, out of reach of paths. The kernel decides whether a
+// new proc or sysfs may be mounted from the mount namespace alone,
+// ignoring the chroot, so the host's are unmounted first, or covered
+// where they can't be, as runc does.
+func moveRoot(s sysCalls, rootfs string) error {
+	mounts, err := selfMountinfo()
+	if err != nil {
+		return err
+	}
+	// The innermost first, so none is unmounted along with its parent
+	for i := len(mounts) - 1; i >= 0; i-- {
+		m := mounts[i]
+		if (m.fstype != "proc" && m.fstype != "sysfs") || m.mountpoint == rootfs || strings.HasPrefix(m.mountpoint, rootfs+"/") {
+			continue
+		}
+		// So that unmounting doesn't reach the host
+		if err := mount(s, "", m.mountpoint, "", unix.MS_SLAVE|unix.MS_REC, ""); err != nil {
+			if errors.Is(err, unix.ENOENT) {
+				continue
+			}
+			return err
+		}
+		err := unmount(s, m.mountpoint, unix.MNT_DETACH)
+		if err == nil {
+			continue
+		}
+		// Mounts locked by a user namespace can only be covered
+		if !errors.Is(err, unix.EINVAL) && !errors.Is(err, unix.EPERM) {
+			return err
+		}
+		if err := mount(s, "tmpfs", m.mountpoint, "tmpfs", 0, ""); err != nil {
+			return err
+		}
+	}
+
+	if err := mount(s, rootfs, "/", "", unix.MS_MOVE, ""); err != nil {
+		return fmt.Errorf("failed to move rootfs onto /: %w", err)
+	}
+	if err := s.Chroot("."); err != nil {
+		return fmt.Errorf("failed to chroot: %w", err)
+	}
+	if err := s.Chdir("/"); err != nil {
+		return fmt.Errorf("failed to chdir to new root: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package libcontainer
+
+import (
+	"strings"
+	"testing"
+)
+
+// The mounts below all come from a host where / is mount 1, and the
+// bundle sits on /var, mount 2.
+const (
+	rootPrivate = "1 0 8:1 / / rw,relatime - ext4 /dev/sda1 rw\n"
+	rootShared  = "1 0 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw\n"
+	varPrivate  = "2 1 8:2 / /var rw,relatime - ext4 /dev/sda2 rw\n"
+	varShared   = "2 1 8:2 / /var rw,relatime shared:2 - ext4 /dev/sda2 rw\n"
+	varSlave    = "2 1 8:2 / /var rw,relatime master:2 - ext4 /dev/sda2 rw\n"
+	bindPrivate = "3 2 8:2 /bundle/rootfs /var/bundle/rootfs rw,relatime - ext4 /dev/sda2 rw\n"
+	bindShared  = "3 2 8:2 /bundle/rootfs /var/bundle/rootfs rw,relatime shared:3 - ext4 /dev/sda2 rw\n"
+)
+
+func TestCheckPivotRoot(t *testing.T) {
+	const rootfs = "/var/bundle/rootfs"
+	tests := []struct {
+		name      string
+		mountinfo string
+		// reasons are the problems expected, in order
+		reasons  []string
+		notMount bool
+	}{
+		{
+			name:      "private bind",
+			mountinfo: rootPrivate + varPrivate + bindPrivate,
+		},
+		{
+			name:      "slave parent",
+			mountinfo: rootPrivate + varSlave + bindPrivate,
+		},
+		{
+			name:      "not a mount point",
+			mountinfo: rootPrivate + varPrivate,
+			reasons:   []string{"rootfs /var/bundle/rootfs is not a mount point"},
+			notMount:  true,
+		},
+		{
+			name:      "shared rootfs",
+			mountinfo: rootPrivate + varPrivate + bindShared,
+			reasons:   []string{"rootfs /var/bundle/rootfs has shared propagation"},
+		},
+		{
+			name:      "shared parent",
+			mountinfo: rootPrivate + varShared + bindPrivate,
+			reasons:   []string{"/var, the mount rootfs /var/bundle/rootfs is on, has shared propagation"},
+		},
+		{
+			name:      "shared root",
+			mountinfo: rootShared + varPrivate + bindPrivate,
+			reasons:   []string{"the current root has shared propagation"},
+		},
+		{
+			name:      "everything shared",
+			mountinfo: rootShared + varShared + bindShared,
+			reasons: []string{
+				"rootfs /var/bundle/rootfs has shared propagation",
+				"/var, the mount rootfs /var/bundle/rootfs is on, has shared propagation",
+				"the current root has shared propagation",
+			},
+		},
+		{
+			// Only the last mount at a path is visible
+			name:      "private bind covered by a shared one",
+			mountinfo: rootPrivate + varPrivate + bindPrivate + "4 3 8:2 /bundle/rootfs /var/bundle/rootfs rw shared:4 - ext4 /dev/sda2 rw\n",
+			reasons:   []string{"rootfs /var/bundle/rootfs has shared propagation"},
+		},
+		{
+			name:      "malformed lines",
+			mountinfo: "garbage\n" + rootPrivate + "x 1 8:2 / /var rw - ext4 /dev/sda2 rw\n" + varPrivate + bindPrivate,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mounts, err := parseMountinfo(strings.NewReader(tt.mountinfo))
+			if err != nil {
+				t.Fatalf("parseMountinfo: %v", err)
+			}
+			problems := checkPivotRoot(mounts, rootfs)
+			if len(problems) != len(tt.reasons) {
+				t.Fatalf("got %d problems %v, want %q", len(problems), problems, tt.reasons)
+			}
+			for i, p := range problems {
+				if p.reason != tt.reasons[i] {
+					t.Errorf("problem %d: got %q, want %q", i, p.reason, tt.reasons[i])
+				}
+				if p.notMount != tt.notMount {
+					t.Errorf("problem %d: got notMount %v, want %v", i, p.notMount, tt.notMount)
+				}
+			}
+		})
+	}
+}
+
+func TestParseMountinfoEscapes(t *testing.T) {
+	mounts, err := parseMountinfo(strings.NewReader(rootPrivate +
+		`2 1 8:2 /a\040b /mnt/a\040b rw,relatime shared:2 master:1 - ext4 /dev/sd\011x rw,errors=remount-ro` + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mounts) != 2 {
+		t.Fatalf("got %d mounts, want 2", len(mounts))
+	}
+	m := mounts[1]
+	if m.root != "/a b" || m.mountpoint != "/mnt/a b" || m.source != "/dev/sd\tx" {
+		t.Errorf("paths not unescaped: %+v", m)
+	}
+	if !m.shared || !m.slave || m.unbindable {
+		t.Errorf("got shared %v slave %v unbindable %v, want shared and slave", m.shared, m.slave, m.unbindable)
+	}
+	if m.fstype != "ext4" || strings.Join(m.options, ",") != "rw,relatime,rw,errors=remount-ro" {
+		t.Errorf("got type %q options %q", m.fstype, m.options)
+	}
+}
@@ -0,0 +1,109 @@
+package libcontainer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// preserveFDsArg tells the monitor, the init stage and the exec helper
+// how many fds they hold for the container process. The fds come first,
+// from 3 on, so the process finds them where the caller had them; the
+// runtime's own fds follow.
+const preserveFDsArg = "--preserve-fds"
+
+// WithCreatePreservedFDs hands fds 3 to 3+n-1 of the calling process to
+// the container process, at the same numbers, as runc's --preserve-fds
+// does. They must have been inherited by the caller: the fds are taken
+// when the option is made, and an fd that isn't open, or that the
+// runtime opened itself, is an error. A full create hands them over at
+// once; a state-only create holds them for Start or Run of the returned
+// Container.
+func WithCreatePreservedFDs(n int) CreateOption {
+	files, err := inheritedFiles(n)
+	return func(l *LinuxFactory) error {
+		if err != nil {
+			return err
+		}
+		l.preservedFiles = files
+		return nil
+	}
+}
+
+// WithPreservedFDs hands fds 3 to 3+n-1 of the calling process to what
+// is started in a loaded container, as WithCreatePreservedFDs does.
+func WithPreservedFDs(n int) LoadOption {
+	files, err := inheritedFiles(n)
+	return func(c *linuxContainer) error {
+		if err != nil {
+			return err
+		}
+		c.preservedFiles = files
+		return nil
+	}
+}
+
+// inheritedFiles takes fds 3 to 3+n-1, which this process must have
+// inherited, and marks them close-on-exec so that only the processes
+// they are passed to get them. Every fd the runtime opens is
+// close-on-exec and an inherited one never is, so the flag tells apart
+// an fd the caller meant from one the runtime opened in a gap.
+func inheritedFiles(n int) ([]*os.File, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("invalid number of fds to preserve: %d", n)
+	}
+	for fd := 3; fd < 3+n; fd++ {
+		flags, err := unix.FcntlInt(uintptr(fd), unix.F_GETFD, 0)
+		if errors.Is(err, unix.EBADF) {
+			return nil, fmt.Errorf("cannot preserve fd %d: it is not open", fd)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot preserve fd %d: %w", fd, err)
+		}
+		if flags&unix.FD_CLOEXEC != 0 {
+			return nil, fmt.Errorf("cannot preserve fd %d: it was not inherited from the caller", fd)
+		}
+	}
+
+	files := make([]*os.File, 0, n)
+	for fd := 3; fd < 3+n; fd++ {
+		unix.CloseOnExec(fd)
+		files = append(files, os.NewFile(uintptr(fd), "fd "+strconv.Itoa(fd)))
+	}
+	return files, nil
+}
+
+// closeExecFrom marks every fd from first on close-on-exec, so that the
+// container process gets no fd the runtime inherited but wasn't asked to
+// preserve. Kernels without close_range(2) have the fds listed from
+// /proc instead.
+func closeExecFrom(first int) error {
+	err := unix.CloseRange(uint(first), ^uint(0), unix.CLOSE_RANGE_CLOEXEC)
+	if !errors.Is(err, unix.ENOSYS) && !errors.Is(err, unix.EINVAL) {
+		return err
+	}
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return fmt.Errorf("failed to list open fds: %w", err)
+	}
+	for _, entry := range entries {
+		if fd, err := strconv.Atoi(entry.Name()); err == nil && fd >= first {
+			unix.CloseOnExec(fd)
+		}
+	}
+	return nil
+}
+
+// inheritOnExec clears close-on-exec on files, for the exec that hands
+// them to the container process.
+func inheritOnExec(files []*os.File) error {
+	for _, f := range files {
+		if _, err := unix.FcntlInt(f.Fd(), unix.F_SETFD, 0); err != nil {
+			return fmt.Errorf("failed to preserve %s: %w", f.Name(), err)
+		}
+	}
+	return nil
+}
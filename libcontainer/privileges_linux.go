@@ -0,0 +1,126 @@
+package libcontainer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// RootlessMode says whether the runtime may run without the privileges
+// of root, giving up what needs them.
+type RootlessMode string
+
+const (
+	// RootlessAuto probes the privileges and runs rootless without them.
+	RootlessAuto RootlessMode = "auto"
+	// RootlessTrue never relies on the privileges.
+	RootlessTrue RootlessMode = "true"
+	// RootlessFalse requires the privileges, failing up front without
+	// them rather than halfway through some later operation.
+	RootlessFalse RootlessMode = "false"
+)
+
+// ParseRootlessMode parses auto, true or false.
+func ParseRootlessMode(s string) (RootlessMode, error) {
+	switch mode := RootlessMode(s); mode {
+	case RootlessAuto, RootlessTrue, RootlessFalse:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid rootless mode %q (want auto, true or false)", s)
+}
+
+// WithRootless sets the factory's rootless mode. The default is
+// RootlessAuto. Without rootless, cgroups are required: the cgroup
+// policy defaults to CgroupPolicyRoot instead of falling back.
+func WithRootless(mode RootlessMode) CreateOption {
+	return func(l *LinuxFactory) error {
+		if _, err := ParseRootlessMode(string(mode)); err != nil {
+			return err
+		}
+		l.rootlessMode = mode
+		return nil
+	}
+}
+
+// privilege is something the runtime needs the privileges of root for.
+type privilege struct {
+	name  string
+	probe func() error
+}
+
+var privileges = []privilege{
+	{"CAP_SYS_ADMIN", probeSysAdmin},
+	{"writable cgroup hierarchy", func() error { return cgroupWritable("/") }},
+	{"device node creation", probeMknod},
+	{"mount propagation control", probeMountPropagation},
+}
+
+// MissingPrivileges probes every privilege a rootful runtime needs and
+// describes each one the calling process lacks.
+func MissingPrivileges() []string {
+	var missing []string
+	for _, p := range privileges {
+		if err := p.probe(); err != nil {
+			missing = append(missing, fmt.Sprintf("%s: %v", p.name, err))
+		}
+	}
+	return missing
+}
+
+// checkPrivileges fails with ErrMissingPrivileges, naming every missing
+// privilege.
+func checkPrivileges() error {
+	if missing := MissingPrivileges(); len(missing) > 0 {
+		return newTypedError(ErrMissingPrivileges, "the runtime lacks privileges it needs without rootless mode: %s", strings.Join(missing, "; "))
+	}
+	return nil
+}
+
+func probeSysAdmin() error {
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capget(&hdr, &data[0]); err != nil {
+		return err
+	}
+	if data[unix.CAP_SYS_ADMIN/32].Effective&(1<<(unix.CAP_SYS_ADMIN%32)) == 0 {
+		return fmt.Errorf("not in the effective capability set")
+	}
+	return nil
+}
+
+// probeMknod creates a device node like the one containers get for
+// /dev/null, in a directory of its own.
+func probeMknod() error {
+	dir, err := os.MkdirTemp("", "hackontainer-mknod")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	return unix.Mknod(filepath.Join(dir, "null"), unix.S_IFCHR|0666, int(unix.Mkdev(1, 3)))
+}
+
+// probeMountPropagation makes the mounts of a new mount namespace
+// private, as the init stage does. The namespace is entered by a
+// thread of its own, which exits with it.
+func probeMountPropagation() error {
+	done := make(chan error, 1)
+	go func() {
+		// Never unlocked, so the thread is thrown away rather than
+		// reused in the namespace
+		runtime.LockOSThread()
+		if err := unix.Unshare(unix.CLONE_NEWNS); err != nil {
+			done <- fmt.Errorf("unshare: %w", err)
+			return
+		}
+		if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+			done <- fmt.Errorf("make mounts private: %w", err)
+			return
+		}
+		done <- nil
+	}()
+	return <-done
+}
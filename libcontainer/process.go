@@ -1,14 +1,19 @@
 package libcontainer
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
 )
 
 type parentProcess interface {
 	pid() int
-	start() error
+	start(ctx context.Context) error
 	terminate() error
 	wait() (*os.ProcessState, error)
 	startTime() (uint64, error)
@@ -17,19 +22,198 @@ type parentProcess interface {
 type initProcess struct {
 	cmd       *exec.Cmd
 	container *linuxContainer
+
+	// syncPipe is the parent's end of the sync socket; childPipe,
+	// configFile, rootfs if the rootfs is pinned, execFifo if the child
+	// is to wait for start and console if its terminal was sent to a
+	// console socket are handed to the child and closed here once it
+	// has started.
+	syncPipe   *os.File
+	childPipe  *os.File
+	configFile *os.File
+	rootfs     *os.File
+	execFifo   *os.File
+	console    *os.File
+
+	manager CgroupManager
+
+	// phase is the step the start is in, as far as the parent knows.
+	phase Phase
 }
 
 func (p *initProcess) pid() int {
 	return p.cmd.Process.Pid
 }
 
-func (p *initProcess) start() error {
+// start clones the child, which blocks until it is told to run. Limits
+// are in place before that, so nothing the container runs escapes them.
+// Once ctx is done the child is killed and start fails in the phase it
+// was in.
+func (p *initProcess) start(ctx context.Context) (retErr error) {
+	defer p.syncPipe.Close()
+
+	p.phase = PhaseClone
 	err := p.cmd.Start()
+	p.childPipe.Close()
+	p.configFile.Close()
+	if p.rootfs != nil {
+		p.rootfs.Close()
+	}
+	if p.execFifo != nil {
+		p.execFifo.Close()
+	}
+	if p.console != nil {
+		p.console.Close()
+	}
+	if err != nil {
+		return &StartError{Phase: p.startFailurePhase(), Err: err}
+	}
+
+	// Killing the child unblocks whatever waits on it here, and its
+	// mounts go with its mount namespace
+	stop := context.AfterFunc(ctx, func() { _ = p.terminate() })
+	defer stop()
+	defer func() {
+		if retErr != nil && ctx.Err() != nil {
+			retErr = &StartError{Phase: p.phase, Err: ctx.Err()}
+		}
+	}()
+
+	p.phase = PhaseCgroups
+	if err := p.manager.Apply(p.pid()); err != nil {
+		p.abort()
+		return &StartError{Phase: PhaseCgroups, Err: fmt.Errorf("failed to apply cgroup: %w", err)}
+	}
+
+	if err := p.manager.Set(p.container.config.Resolved.Resources); err != nil {
+		p.abort()
+		return &StartError{Phase: PhaseCgroups, Err: fmt.Errorf("failed to set cgroup resources: %w", err)}
+	}
+
+	if spec := p.container.config.Spec; cgroupDelegated(spec) {
+		uid, gid, err := hostRootIDs(spec)
+		if err == nil {
+			err = p.manager.Delegate(uid, gid)
+		}
+		if err != nil {
+			p.abort()
+			return &StartError{Phase: PhaseCgroups, Err: err}
+		}
+	}
+
+	spec := p.container.config.Spec
+	run := syncT{Type: procRun, State: p.container.hookState(specs.StateCreating, p.pid())}
+	if run.UTSNamespace, err = utsNamespace(); err != nil {
+		p.abort()
+		return &StartError{Phase: PhaseInit, Err: fmt.Errorf("failed to identify the runtime's UTS namespace: %w", err)}
+	}
+	if cpuAffinityMode(spec) == CPUAffinityReset {
+		cpus, err := cgroupCPUs(p.manager)
+		if err != nil {
+			p.abort()
+			return &StartError{Phase: PhaseCgroups, Err: err}
+		}
+		run.CPUs = cpus
+	}
+	state := run.State
+	if err := writeSync(p.syncPipe, run); err != nil {
+		p.abort()
+		return p.lostError(err)
+	}
+
+	dec := json.NewDecoder(p.syncPipe)
+	msg, err := p.readSync(dec)
+	if _, checkErr := checkSync(msg, err, procHooks); checkErr != nil {
+		p.abort()
+		if err == io.EOF {
+			return p.lostError(checkErr)
+		}
+		return startPhase(PhaseInit, checkErr)
+	}
+	p.phase = PhaseHooks
+	for _, name := range []string{HookPrestart, HookCreateRuntime} {
+		if err := runHooks(ctx, name, p.container.config.Resolved.Hooks[name], state, p.container.hookEnv(state)); err != nil {
+			p.abort()
+			return &StartError{Phase: PhaseHooks, Err: err}
+		}
+	}
+	if err := writeSync(p.syncPipe, syncT{Type: procResume}); err != nil {
+		p.abort()
+		return p.lostError(err)
+	}
+
+	// The child's end is close-on-exec, so EOF means it exec'd. One
+	// that waits for start reports it got that far instead
+	msg, err = p.readSync(dec)
+	if err == io.EOF && p.execFifo == nil && stop() {
+		return nil
+	}
+	if err == nil && msg.Type == procReady && p.execFifo != nil && stop() {
+		return nil
+	}
+	p.abort()
+	if err == io.EOF {
+		return p.lostError(fmt.Errorf("sync socket closed while waiting for %s", procReady))
+	}
 	if err != nil {
-		return fmt.Errorf("failed to start init process: %w", err)
+		return &StartError{Phase: PhaseInit, Err: err}
+	}
+	if msg.Type == procError {
+		return msg.startError()
+	}
+	return &StartError{Phase: PhaseInit, Err: fmt.Errorf("unexpected sync message %q from init", msg.Type)}
+}
+
+// readSync reads the child's next message, noting the phases it reports
+// on the way.
+func (p *initProcess) readSync(dec *json.Decoder) (syncT, error) {
+	for {
+		msg, err := readSync(dec)
+		if err != nil || msg.Type != procPhase {
+			return msg, err
+		}
+		p.phase = msg.Phase
+	}
+}
+
+// startFailurePhase tells a failed clone from failed id mappings, which
+// exec.Cmd reports alike: if the same clone succeeds without mappings,
+// they were what failed.
+func (p *initProcess) startFailurePhase() Phase {
+	attr := p.cmd.SysProcAttr
+	if attr.UidMappings == nil && attr.GidMappings == nil {
+		return PhaseClone
+	}
+	if probeClone(attr.Cloneflags) != nil {
+		return PhaseClone
 	}
+	return PhaseUsermap
+}
+
+// lostError is the error of a child that exited without reporting one,
+// which its exit code stands in for; err is how the parent noticed, and
+// the error in the init phase unless the child exited with an init exit
+// code. Only call it once abort has reaped the child.
+func (p *initProcess) lostError(err error) error {
+	if p.cmd.ProcessState == nil {
+		return &StartError{Phase: PhaseInit, Err: err}
+	}
+	switch code := p.cmd.ProcessState.ExitCode(); code {
+	case ExitNotFound:
+		return &StartError{Phase: PhaseExec, ExitCode: code, Err: fmt.Errorf("init exited with code %d: the executable was not found", code)}
+	case ExitCannotExec:
+		return &StartError{Phase: PhaseExec, ExitCode: code, Err: fmt.Errorf("init exited with code %d: the executable could not be run", code)}
+	case ExitSetupFailed:
+		return &StartError{Phase: p.phase, ExitCode: code, Err: fmt.Errorf("init exited with code %d: setup failed without reporting why (%v)", code, err)}
+	}
+	return &StartError{Phase: PhaseInit, Err: err}
+}
 
-	return nil
+// abort kills a child that failed to start and removes its cgroup.
+func (p *initProcess) abort() {
+	_ = p.terminate()
+	_ = p.cmd.Wait()
+	_ = p.manager.Destroy()
 }
 
 func (p *initProcess) terminate() error {
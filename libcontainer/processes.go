@@ -0,0 +1,34 @@
+package libcontainer
+
+import (
+	"fmt"
+	"os"
+)
+
+// Processes lists the processes of a running or paused container: those
+// in the cgroup recorded in its state. A container without a cgroup, or
+// whose cgroup is gone, has only its init process to show.
+func (c *linuxContainer) Processes() ([]int, error) {
+	state, err := c.State()
+	if err != nil {
+		return nil, err
+	}
+	if state.Status != Running && state.Status != Paused {
+		return nil, newTypedError(ErrNotRunning, "container not running: it is %s", state.Status)
+	}
+	if err := c.checkNamespaces("list its processes"); err != nil {
+		return nil, err
+	}
+
+	if state.CgroupPath == "" {
+		return []int{state.Pid}, nil
+	}
+	pids, err := cgroupManagerAt(state.CgroupPath, c.retry).Pids()
+	if os.IsNotExist(err) {
+		return []int{state.Pid}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the container's cgroup: %w", err)
+	}
+	return pids, nil
+}
@@ -0,0 +1,399 @@
+package libcontainer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/zakarynichols/hackontainer/api/types"
+	"golang.org/x/sys/unix"
+)
+
+// rootfsSizeAnnotation requests a write limit on the container rootfs.
+const rootfsSizeAnnotation = "org.hackontainer.rootfs-size"
+
+const (
+	quotaProjectsFilename = "quota-projects.json"
+	quotaLockFilename     = "quota-projects.lock"
+
+	// firstProjectID leaves low project IDs to administrator-managed
+	// /etc/projid entries.
+	firstProjectID = 1000000
+)
+
+// Not exported by x/sys/unix.
+const (
+	fsIocFsgetxattr    = 0x801c581f
+	fsIocFssetxattr    = 0x401c5820
+	fsXflagProjinherit = 0x00000200
+
+	qSetQuota  = 0x800008
+	qGetQuota  = 0x800007
+	prjQuota   = 2
+	qifBlimits = 1
+	qifSpace   = 4
+	quotaBlock = 1024
+)
+
+type fsxattr struct {
+	Xflags     uint32
+	Extsize    uint32
+	Nextents   uint32
+	Projid     uint32
+	Cowextsize uint32
+	Pad        [8]byte
+}
+
+type ifDqblk struct {
+	BHardlimit uint64
+	BSoftlimit uint64
+	CurSpace   uint64
+	IHardlimit uint64
+	ISoftlimit uint64
+	CurInodes  uint64
+	BTime      uint64
+	ITime      uint64
+	Valid      uint32
+	_          uint32
+}
+
+// RootfsQuota describes the project quota bounding writes to the rootfs.
+type RootfsQuota = types.RootfsQuota
+
+// ParseSize parses a byte count with an optional K, M, G or T suffix
+// (powers of 1024).
+func ParseSize(value string) (uint64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	s = strings.TrimSuffix(s, "B")
+	multiplier := uint64(1)
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			s = s[:n-1]
+		}
+	}
+
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	if n > math.MaxUint64/multiplier {
+		return 0, fmt.Errorf("invalid size %q: too large", value)
+	}
+	return n * multiplier, nil
+}
+
+// projectAllocator hands out project IDs and persists the assignments
+// under the factory root so they survive across invocations.
+type projectAllocator struct {
+	root string
+}
+
+func (a *projectAllocator) lock() (func(), error) {
+	return lockerOf(a.root).lockFile(filepath.Join(a.root, quotaLockFilename))
+}
+
+func (a *projectAllocator) load() (map[string]uint32, error) {
+	projects := make(map[string]uint32)
+	data, err := os.ReadFile(filepath.Join(a.root, quotaProjectsFilename))
+	if os.IsNotExist(err) {
+		return projects, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &projects); err != nil {
+		return nil, fmt.Errorf("corrupt %s: %w", quotaProjectsFilename, err)
+	}
+	return projects, nil
+}
+
+func (a *projectAllocator) save(projects map[string]uint32) error {
+	data, err := json.Marshal(projects)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(a.root, quotaProjectsFilename)
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// allocate returns the project ID of id, assigning the lowest free one.
+// current is the project the rootfs is already tagged with; it must not
+// belong to another container.
+func (a *projectAllocator) allocate(id string, current uint32) (uint32, error) {
+	unlock, err := a.lock()
+	if err != nil {
+		return 0, fmt.Errorf("failed to lock project allocator: %w", err)
+	}
+	defer unlock()
+
+	projects, err := a.load()
+	if err != nil {
+		return 0, err
+	}
+	if projID, ok := projects[id]; ok {
+		return projID, nil
+	}
+
+	used := make(map[uint32]bool, len(projects))
+	for owner, projID := range projects {
+		if projID == current && current != 0 {
+			return 0, fmt.Errorf("rootfs is already limited by the quota of container %s", owner)
+		}
+		used[projID] = true
+	}
+	projID := uint32(firstProjectID)
+	for used[projID] {
+		projID++
+	}
+
+	projects[id] = projID
+	if err := a.save(projects); err != nil {
+		return 0, fmt.Errorf("failed to persist project allocation: %w", err)
+	}
+	return projID, nil
+}
+
+// owns reports whether projID is still allocated to id.
+func (a *projectAllocator) owns(id string, projID uint32) (bool, error) {
+	unlock, err := a.lock()
+	if err != nil {
+		return false, fmt.Errorf("failed to lock project allocator: %w", err)
+	}
+	defer unlock()
+
+	projects, err := a.load()
+	if err != nil {
+		return false, err
+	}
+	owner, ok := projects[id]
+	return ok && owner == projID, nil
+}
+
+func (a *projectAllocator) release(id string) error {
+	unlock, err := a.lock()
+	if err != nil {
+		return fmt.Errorf("failed to lock project allocator: %w", err)
+	}
+	defer unlock()
+
+	projects, err := a.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := projects[id]; !ok {
+		return nil
+	}
+	delete(projects, id)
+	return a.save(projects)
+}
+
+// setProjectID tags every file under rootfs with projID. Directories get
+// PROJINHERIT so files created later are charged to the same project.
+func setProjectID(rootfs string, projID uint32) error {
+	return filepath.Walk(rootfs, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// Device nodes, sockets and symlinks can't carry a project ID
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil
+		}
+
+		fd, err := unix.Open(path, unix.O_RDONLY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", path, err)
+		}
+		defer unix.Close(fd)
+
+		var attr fsxattr
+		if err := ioctlPtr(fd, fsIocFsgetxattr, unsafe.Pointer(&attr)); err != nil {
+			return fmt.Errorf("project quotas not supported on %s: %w", path, err)
+		}
+		attr.Projid = projID
+		if info.IsDir() {
+			attr.Xflags |= fsXflagProjinherit
+		}
+		if err := ioctlPtr(fd, fsIocFssetxattr, unsafe.Pointer(&attr)); err != nil {
+			return fmt.Errorf("failed to set project id on %s: %w", path, err)
+		}
+		return nil
+	})
+}
+
+// rootfsProjectID returns the project ID rootfs is tagged with.
+func rootfsProjectID(rootfs string) (uint32, error) {
+	fd, err := unix.Open(rootfs, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open %s: %w", rootfs, err)
+	}
+	defer unix.Close(fd)
+
+	var attr fsxattr
+	if err := ioctlPtr(fd, fsIocFsgetxattr, unsafe.Pointer(&attr)); err != nil {
+		return 0, fmt.Errorf("project quotas not supported on %s: %w", rootfs, err)
+	}
+	return attr.Projid, nil
+}
+
+func ioctlPtr(fd int, req uintptr, arg unsafe.Pointer) error {
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), req, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// quotactl issues a project quota command against the filesystem holding
+// path, preferring quotactl_fd and falling back to the block device.
+func quotactl(path string, cmd int, projID uint32, dq *ifDqblk) error {
+	qcmd := uintptr(cmd<<8 | prjQuota)
+
+	fd, err := unix.Open(path, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	_, _, errno := unix.Syscall6(unix.SYS_QUOTACTL_FD, uintptr(fd), qcmd, uintptr(projID), uintptr(unsafe.Pointer(dq)), 0, 0)
+	if errno != unix.ENOSYS {
+		if errno != 0 {
+			return errno
+		}
+		return nil
+	}
+
+	mounts, err := selfMountinfo()
+	if err != nil {
+		return err
+	}
+	device, err := mountSource(mounts, path)
+	if err != nil {
+		return err
+	}
+	devicePtr, err := unix.BytePtrFromString(device)
+	if err != nil {
+		return err
+	}
+	_, _, errno = unix.Syscall6(unix.SYS_QUOTACTL, qcmd, uintptr(unsafe.Pointer(devicePtr)), uintptr(projID), uintptr(unsafe.Pointer(dq)), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// mountSource returns the source device of the mount in mounts that
+// contains path.
+func mountSource(mounts []mountEntry, path string) (string, error) {
+	best, source := "", ""
+	for _, m := range mounts {
+		if path != m.mountpoint && !strings.HasPrefix(path, strings.TrimSuffix(m.mountpoint, "/")+"/") {
+			continue
+		}
+		if len(m.mountpoint) >= len(best) {
+			best, source = m.mountpoint, m.source
+		}
+	}
+	if source == "" {
+		return "", fmt.Errorf("no mount found for %q", path)
+	}
+	return source, nil
+}
+
+// unescapeMountinfo undoes the octal escapes mountinfo uses for spaces,
+// tabs, newlines and backslashes in paths.
+func unescapeMountinfo(field string) string {
+	if !strings.Contains(field, "\\") {
+		return field
+	}
+	var b strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] == '\\' && i+3 < len(field) {
+			if n, err := strconv.ParseUint(field[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(field[i])
+	}
+	return b.String()
+}
+
+// applyRootfsQuota assigns a project to rootfs and limits it to limit
+// bytes. id is qualified by the container's namespace, if any, since
+// one allocator serves every namespace under factoryRoot.
+func applyRootfsQuota(factoryRoot, id, rootfs string, limit uint64) (*RootfsQuota, error) {
+	// Containers sharing a rootfs can't each have a quota on it
+	current, err := rootfsProjectID(rootfs)
+	if err != nil {
+		return nil, err
+	}
+
+	allocator := &projectAllocator{root: factoryRoot}
+	projID, err := allocator.allocate(id, current)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setProjectID(rootfs, projID); err != nil {
+		allocator.release(id)
+		return nil, err
+	}
+
+	dq := ifDqblk{
+		BHardlimit: (limit + quotaBlock - 1) / quotaBlock,
+		BSoftlimit: (limit + quotaBlock - 1) / quotaBlock,
+		Valid:      qifBlimits,
+	}
+	if err := quotactl(rootfs, qSetQuota, projID, &dq); err != nil {
+		allocator.release(id)
+		return nil, fmt.Errorf("failed to set project quota (is the filesystem mounted with prjquota?): %w", err)
+	}
+
+	return &RootfsQuota{ProjectID: projID, LimitBytes: limit}, nil
+}
+
+// rootfsQuotaUsage returns the bytes currently charged to projID.
+func rootfsQuotaUsage(rootfs string, projID uint32) (uint64, error) {
+	var dq ifDqblk
+	if err := quotactl(rootfs, qGetQuota, projID, &dq); err != nil {
+		return 0, err
+	}
+	if dq.Valid&qifSpace == 0 {
+		return 0, fmt.Errorf("quota usage unavailable")
+	}
+	return dq.CurSpace, nil
+}
+
+// releaseRootfsQuota clears the limit and returns the project ID. Once
+// the ID is released it may belong to another container, so a repeated
+// release leaves the quota alone.
+func releaseRootfsQuota(factoryRoot, id, rootfs string, projID uint32) error {
+	allocator := &projectAllocator{root: factoryRoot}
+	owned, err := allocator.owns(id, projID)
+	if err != nil || !owned {
+		return err
+	}
+
+	dq := ifDqblk{Valid: qifBlimits}
+	_ = quotactl(rootfs, qSetQuota, projID, &dq)
+	return allocator.release(id)
+}
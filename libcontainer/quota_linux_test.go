@@ -0,0 +1,258 @@
+package libcontainer
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+var xfsLoopback = flag.Bool("xfs-loopback", false, "run rootfs quota tests on a loop-mounted XFS image (needs root and mkfs.xfs)")
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  uint64
+		// err is in the error, if any
+		err string
+	}{
+		{"4096", 4096, ""},
+		{"10k", 10 << 10, ""},
+		{"512MB", 512 << 20, ""},
+		{" 2G ", 2 << 30, ""},
+		{"1T", 1 << 40, ""},
+		{"18446744073709551615", 1<<64 - 1, ""},
+		{"16777216T", 0, "too large"},
+		{"17179869184G", 0, "too large"},
+		{"18446744073709551615K", 0, "too large"},
+		{"18446744073709551616", 0, "invalid size"},
+		{"0", 0, "invalid size"},
+		{"-1M", 0, "invalid size"},
+		{"M", 0, "invalid size"},
+		{"1.5G", 0, "invalid size"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseSize(tt.value)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got %d, %v, want %q", got, err, tt.err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("got %d, %v, want %d", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestProjectAllocatorPersists(t *testing.T) {
+	root := t.TempDir()
+	a := &projectAllocator{root: root}
+	first, err := a.allocate("a", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != firstProjectID {
+		t.Errorf("got project %d, want %d", first, firstProjectID)
+	}
+	second, err := a.allocate("b", 0)
+	if err != nil || second != first+1 {
+		t.Fatalf("got project %d, %v, want %d", second, err, first+1)
+	}
+
+	// Another invocation sees what this one assigned
+	b := &projectAllocator{root: root}
+	if again, err := b.allocate("a", 0); err != nil || again != first {
+		t.Fatalf("got project %d, %v for a again, want %d", again, err, first)
+	}
+	if owned, err := b.owns("b", second); err != nil || !owned {
+		t.Fatalf("got %t, %v, want b to own %d", owned, err, second)
+	}
+	if owned, _ := b.owns("b", first); owned {
+		t.Error("b owns a's project")
+	}
+
+	// A released ID is the lowest free one, and goes to the next container
+	if err := b.release("a"); err != nil {
+		t.Fatal(err)
+	}
+	if owned, _ := a.owns("a", first); owned {
+		t.Error("a still owns its project after release")
+	}
+	if reused, err := a.allocate("c", 0); err != nil || reused != first {
+		t.Fatalf("got project %d, %v for c, want %d reused", reused, err, first)
+	}
+
+	// Releasing one that has nothing is a no-op
+	if err := a.release("unknown"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, quotaProjectsFilename+".tmp")); !os.IsNotExist(err) {
+		t.Errorf("left the temporary file behind: %v", err)
+	}
+}
+
+func TestProjectAllocatorErrors(t *testing.T) {
+	t.Run("rootfs of another container", func(t *testing.T) {
+		a := &projectAllocator{root: t.TempDir()}
+		projID, err := a.allocate("a", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := a.allocate("b", projID); err == nil || !strings.Contains(err.Error(), "quota of container a") {
+			t.Fatalf("got %v, want the rootfs refused", err)
+		}
+		// A project nobody was given is one the rootfs may keep
+		if _, err := a.allocate("b", projID+100); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("corrupt assignments", func(t *testing.T) {
+		root := t.TempDir()
+		if err := os.WriteFile(filepath.Join(root, quotaProjectsFilename), []byte("{"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		a := &projectAllocator{root: root}
+		if _, err := a.allocate("a", 0); err == nil || !strings.Contains(err.Error(), "corrupt") {
+			t.Errorf("allocate got %v", err)
+		}
+		if _, err := a.owns("a", firstProjectID); err == nil || !strings.Contains(err.Error(), "corrupt") {
+			t.Errorf("owns got %v", err)
+		}
+		if err := a.release("a"); err == nil || !strings.Contains(err.Error(), "corrupt") {
+			t.Errorf("release got %v", err)
+		}
+	})
+	t.Run("root missing", func(t *testing.T) {
+		a := &projectAllocator{root: filepath.Join(t.TempDir(), "missing")}
+		if _, err := a.allocate("a", 0); err == nil || !strings.Contains(err.Error(), "failed to lock") {
+			t.Errorf("allocate got %v", err)
+		}
+		if _, err := a.owns("a", firstProjectID); err == nil || !strings.Contains(err.Error(), "failed to lock") {
+			t.Errorf("owns got %v", err)
+		}
+		if err := a.release("a"); err == nil || !strings.Contains(err.Error(), "failed to lock") {
+			t.Errorf("release got %v", err)
+		}
+	})
+	t.Run("assignments can't be written", func(t *testing.T) {
+		root := t.TempDir()
+		// A directory where the temporary file goes fails the write
+		if err := os.Mkdir(filepath.Join(root, quotaProjectsFilename+".tmp"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		a := &projectAllocator{root: root}
+		if _, err := a.allocate("a", 0); err == nil || !strings.Contains(err.Error(), "failed to persist") {
+			t.Fatalf("got %v", err)
+		}
+		if owned, _ := a.owns("a", firstProjectID); owned {
+			t.Error("an allocation that wasn't saved is owned")
+		}
+	})
+}
+
+func TestMountSource(t *testing.T) {
+	mounts, err := parseMountinfo(strings.NewReader(rootPrivate +
+		`2 1 8:2 / /var/lib rw - xfs /dev/sda2 rw,prjquota` + "\n" +
+		`3 2 8:3 / /var/lib/hackontainer\040root rw - xfs /dev/disk\040b rw,prjquota` + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path, want string
+	}{
+		{"/var/lib", "/dev/sda2"},
+		{"/var/lib/containers/a", "/dev/sda2"},
+		{"/var/lib/hackontainer root/a/rootfs", "/dev/disk b"},
+		{"/var/lib/hackontainer rootfs", "/dev/sda2"},
+	}
+	for _, tt := range tests {
+		if got, err := mountSource(mounts, tt.path); err != nil || got != tt.want {
+			t.Errorf("%s: got %q, %v, want %q", tt.path, got, err, tt.want)
+		}
+	}
+	if got, err := mountSource(mounts[1:], "/etc"); err == nil {
+		t.Errorf("got %q for a path under no mount", got)
+	}
+}
+
+// TestRootfsQuotaXFS limits a rootfs on a loop-mounted XFS image and
+// checks writes past the limit fail, and succeed once it is released.
+func TestRootfsQuotaXFS(t *testing.T) {
+	if !*xfsLoopback {
+		t.Skip("pass -xfs-loopback to run")
+	}
+	if os.Geteuid() != 0 {
+		t.Skip("needs root to mount the image")
+	}
+	if _, err := exec.LookPath("mkfs.xfs"); err != nil {
+		t.Skip("needs mkfs.xfs")
+	}
+
+	dir := t.TempDir()
+	image := filepath.Join(dir, "xfs.img")
+	// The smallest filesystem mkfs.xfs makes
+	if err := os.WriteFile(image, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(image, 300<<20); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("mkfs.xfs", "-q", image).CombinedOutput(); err != nil {
+		t.Fatalf("mkfs.xfs: %v: %s", err, out)
+	}
+	mnt := filepath.Join(dir, "mnt")
+	if err := os.Mkdir(mnt, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("mount", "-o", "loop,prjquota", image, mnt).CombinedOutput(); err != nil {
+		t.Fatalf("mount: %v: %s", err, out)
+	}
+	t.Cleanup(func() { unix.Unmount(mnt, unix.MNT_DETACH) })
+
+	rootfs := filepath.Join(mnt, "rootfs")
+	if err := os.MkdirAll(filepath.Join(rootfs, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	factoryRoot := t.TempDir()
+	quota, err := applyRootfsQuota(factoryRoot, "test", rootfs, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if projID, err := rootfsProjectID(filepath.Join(rootfs, "etc")); err != nil || projID != quota.ProjectID {
+		t.Fatalf("etc got project %d, %v, want %d", projID, err, quota.ProjectID)
+	}
+
+	// A file created after the limit inherits the project
+	big := filepath.Join(rootfs, "etc", "big")
+	err = os.WriteFile(big, make([]byte, 2<<20), 0644)
+	if !errors.Is(err, unix.EDQUOT) {
+		t.Fatalf("got %v writing 2MiB under a 1MiB limit, want EDQUOT", err)
+	}
+	used, err := rootfsQuotaUsage(rootfs, quota.ProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used == 0 || used > 1<<20 {
+		t.Errorf("got %d bytes charged, want up to the 1MiB limit", used)
+	}
+
+	// Another container can't take a quota on the same rootfs
+	if _, err := applyRootfsQuota(factoryRoot, "other", rootfs, 1<<20); err == nil {
+		t.Error("two containers limited the same rootfs")
+	}
+
+	if err := releaseRootfsQuota(factoryRoot, "test", rootfs, quota.ProjectID); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(big, make([]byte, 2<<20), 0644); err != nil {
+		t.Fatalf("got %v writing once the limit is released", err)
+	}
+}
@@ -0,0 +1,138 @@
+package libcontainer
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// readOnlyAutoTmpfsAnnotation requests the scratch tmpfs mounts of a
+// read-only root: "true" for them with /tmp noexec, "exec" for /tmp
+// allowing exec, "false" for none.
+const readOnlyAutoTmpfsAnnotation = "org.hackontainer.read-only-auto-tmpfs"
+
+// scratchTmpfs are the scratch areas applications expect to write to,
+// with the tmpfs mounted at each on a read-only root. /tmp's noexec is
+// the one an application may need lifted.
+var scratchTmpfs = []specs.Mount{
+	{Destination: "/tmp", Type: "tmpfs", Source: "tmpfs", Options: []string{"nosuid", "nodev", "noexec", "mode=1777", "size=64m"}},
+	{Destination: "/run", Type: "tmpfs", Source: "tmpfs", Options: []string{"nosuid", "nodev", "noexec", "mode=755", "size=16m"}},
+	{Destination: "/var/tmp", Type: "tmpfs", Source: "tmpfs", Options: []string{"nosuid", "nodev", "noexec", "mode=1777", "size=64m"}},
+}
+
+// ReadOnlyAutoTmpfs is how scratch tmpfs mounts are added to a config
+// with a read-only root.
+type ReadOnlyAutoTmpfs struct {
+	// TmpExec lets /tmp hold executables.
+	TmpExec bool
+}
+
+// ParseReadOnlyAutoTmpfs parses the value of the
+// org.hackontainer.read-only-auto-tmpfs annotation. It returns nil for
+// "false".
+func ParseReadOnlyAutoTmpfs(value string) (*ReadOnlyAutoTmpfs, error) {
+	switch value {
+	case "true":
+		return &ReadOnlyAutoTmpfs{}, nil
+	case "exec":
+		return &ReadOnlyAutoTmpfs{TmpExec: true}, nil
+	case "false":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("invalid read-only auto tmpfs %q (want true, exec or false)", value)
+}
+
+// WithReadOnlyAutoTmpfs mounts a size-capped tmpfs at /tmp, /run and
+// /var/tmp of a container whose config sets root.readonly, unless the
+// config mounts something there already. It overrides the
+// org.hackontainer.read-only-auto-tmpfs annotation; a config with a
+// writable root is left as it is.
+func WithReadOnlyAutoTmpfs(a ReadOnlyAutoTmpfs) CreateOption {
+	return func(l *LinuxFactory) error {
+		l.readOnlyAutoTmpfs = &a
+		return nil
+	}
+}
+
+// addScratchTmpfs adds the scratch tmpfs mounts a read-only root is
+// missing to spec, which is frozen with them. Each goes before the
+// first mount below it, which it would hide otherwise.
+func addScratchTmpfs(spec *specs.Spec, a *ReadOnlyAutoTmpfs) {
+	if spec.Root == nil || !spec.Root.Readonly {
+		return
+	}
+	for _, scratch := range scratchTmpfs {
+		if mountedAt(spec.Mounts, scratch.Destination) {
+			continue
+		}
+		mnt := scratch
+		mnt.Options = nil
+		for _, opt := range scratch.Options {
+			if opt == "noexec" && mnt.Destination == "/tmp" && a.TmpExec {
+				continue
+			}
+			mnt.Options = append(mnt.Options, opt)
+		}
+		at := len(spec.Mounts)
+		for i, m := range spec.Mounts {
+			if pathBelow(filepath.Clean(m.Destination), mnt.Destination) {
+				at = i
+				break
+			}
+		}
+		spec.Mounts = append(spec.Mounts[:at], append([]specs.Mount{mnt}, spec.Mounts[at:]...)...)
+	}
+}
+
+// mountedAt reports whether a mount covers dest: one at dest or at a
+// directory above it, which supplies whatever is there.
+func mountedAt(mounts []specs.Mount, dest string) bool {
+	for _, m := range mounts {
+		d := filepath.Clean(m.Destination)
+		if d != "/" && (d == dest || pathBelow(dest, d)) {
+			return true
+		}
+	}
+	return false
+}
+
+// pathBelow reports whether path lies strictly below dir.
+func pathBelow(path, dir string) bool {
+	return strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// readOnlyWarnings warns about a read-only root with nothing writable
+// outside /dev, /proc and /sys: an application that needs scratch space
+// fails there, often confusingly.
+func readOnlyWarnings(spec *specs.Spec) []string {
+	if spec == nil || spec.Root == nil || !spec.Root.Readonly {
+		return nil
+	}
+	for _, m := range spec.Mounts {
+		d := filepath.Clean(m.Destination)
+		if d == "/dev" || d == "/proc" || d == "/sys" || pathBelow(d, "/dev") || pathBelow(d, "/proc") || pathBelow(d, "/sys") {
+			continue
+		}
+		if mountWritable(m) {
+			return nil
+		}
+	}
+	return []string{"root.readonly is set and nothing outside /dev, /proc and /sys is writable; an application needing scratch space will fail (--read-only-auto-tmpfs mounts tmpfs at /tmp, /run and /var/tmp)"}
+}
+
+// mountWritable reports whether m is mounted read-write, the last of ro
+// and rw deciding.
+func mountWritable(m specs.Mount) bool {
+	writable := true
+	for _, opt := range m.Options {
+		switch opt {
+		case "ro":
+			writable = false
+		case "rw":
+			writable = true
+		}
+	}
+	return writable
+}
@@ -0,0 +1,90 @@
+package libcontainer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zakarynichols/hackontainer/api/types"
+)
+
+// Restart policy names accepted by --restart.
+const (
+	RestartNo        = types.RestartNo
+	RestartAlways    = types.RestartAlways
+	RestartOnFailure = types.RestartOnFailure
+)
+
+const (
+	restartBackoffInitial = 100 * time.Millisecond
+	restartBackoffMax     = time.Minute
+)
+
+// RestartPolicy decides whether the monitor starts the container process
+// again after it exits.
+type RestartPolicy = types.RestartPolicy
+
+// ParseRestartPolicy parses "no", "always", "on-failure" or
+// "on-failure:<max>".
+func ParseRestartPolicy(value string) (*RestartPolicy, error) {
+	name, max, hasMax := strings.Cut(value, ":")
+	policy := &RestartPolicy{Name: name}
+
+	switch name {
+	case RestartNo, RestartAlways:
+		if hasMax {
+			return nil, fmt.Errorf("restart policy %q does not take a retry count", name)
+		}
+	case RestartOnFailure:
+		if hasMax {
+			n, err := strconv.Atoi(max)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid retry count %q for restart policy on-failure", max)
+			}
+			policy.MaxRetries = n
+		}
+	default:
+		return nil, fmt.Errorf("unknown restart policy %q (want no, always or on-failure[:max])", value)
+	}
+
+	return policy, nil
+}
+
+// shouldRestart reports whether a container that exited with exitCode
+// gets started again. An explicit kill or delete always wins, and so
+// does running past the max runtime unless it lets the policy restart.
+func shouldRestart(state *State, exitCode int) bool {
+	policy := state.RestartPolicy
+	if policy == nil || state.RestartSuppressed {
+		return false
+	}
+	if state.KilledByTimeout && (state.MaxRuntime == nil || !state.MaxRuntime.Restart) {
+		return false
+	}
+
+	switch policy.Name {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		if exitCode == 0 {
+			return false
+		}
+		return policy.MaxRetries == 0 || state.RestartCount < policy.MaxRetries
+	default:
+		return false
+	}
+}
+
+// restartBackoff doubles the delay with every restart, capped at
+// restartBackoffMax.
+func restartBackoff(restartCount int) time.Duration {
+	delay := restartBackoffInitial
+	for i := 0; i < restartCount && delay < restartBackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > restartBackoffMax {
+		delay = restartBackoffMax
+	}
+	return delay
+}
@@ -0,0 +1,339 @@
+package libcontainer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// EventRestore is recorded when a container is restored from a
+// checkpoint. Its data has the restored pid and the image path.
+const EventRestore = "restore"
+
+// restoreFilename is where Restore leaves the checkpoint for the monitor
+// to restore instead of starting the container process.
+const restoreFilename = "restore.json"
+
+// Files criu restore leaves in the work path.
+const (
+	criuRestoreLog     = "restore.log"
+	criuRestorePidfile = "restore.pid"
+)
+
+// criuConsoleFd is the fd criu restore is given the new pty on, the
+// first past its stdio.
+const criuConsoleFd = 3
+
+// RestoreOptions control Restore.
+type RestoreOptions struct {
+	// ImagePath holds the images of the checkpoint to restore.
+	ImagePath string
+	// WorkPath holds criu's log and scratch files, ImagePath by default.
+	WorkPath string
+	// TCPEstablished restores established TCP connections the checkpoint
+	// dumped.
+	TCPEstablished bool
+	// CriuPath is the criu binary, found in PATH by default.
+	CriuPath string
+}
+
+// restoreRequest is what Restore leaves in restoreFilename.
+type restoreRequest struct {
+	ImagePath      string `json:"imagePath"`
+	WorkPath       string `json:"workPath"`
+	TCPEstablished bool   `json:"tcpEstablished,omitempty"`
+	Criu           string `json:"criu"`
+	// Console is the pty the checkpointed process had, as criu named it,
+	// for a new one to be restored in its place.
+	Console string `json:"console,omitempty"`
+}
+
+// Restore brings a newly created container to life from a checkpoint
+// instead of starting its process: its monitor has criu restore the
+// dumped process tree, moves the tree into a new cgroup and then
+// supervises it like a started process, so the container stops when it
+// exits. A process checkpointed with a terminal is given a new pty in
+// place of the old, sent to the container's console socket.
+func (c *linuxContainer) Restore(opts RestoreOptions) (retErr error) {
+	defer func() { c.audit(AuditRestore, map[string]string{"imagePath": opts.ImagePath}, retErr) }()
+
+	criu, err := criuBinary(opts.CriuPath)
+	if err != nil {
+		return err
+	}
+	if opts.ImagePath == "" {
+		return newTypedError(ErrInvalidConfig, "restore needs the image path of a checkpoint")
+	}
+	imagePath, err := filepath.Abs(opts.ImagePath)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(imagePath); err != nil || !info.IsDir() {
+		return newTypedError(ErrInvalidConfig, "no checkpoint at %s", imagePath)
+	}
+	// The restored process gets its new terminal the way a started one
+	// does, so without a console socket there is nowhere to send it
+	console, err := loadCriuConsole(imagePath)
+	if err != nil {
+		return err
+	}
+	if console != nil && c.consoleSocket == "" {
+		return newTypedError(ErrInvalidConfig, "the checkpoint at %s is of a process with a terminal, and no console socket was given to send its new one to", imagePath)
+	}
+	workPath := opts.WorkPath
+	if workPath == "" {
+		workPath = imagePath
+	}
+	if workPath, err = filepath.Abs(workPath); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(workPath, 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", workPath, err)
+	}
+
+	state, err := c.State()
+	if err != nil {
+		return err
+	}
+	if state.Status != Created || state.Pid != 0 || createMode(state) == CreateModeFull {
+		return newTypedError(ErrInvalidState, "only a container created without a process can be restored, and %s is %s", c.id, state.Status)
+	}
+
+	req := restoreRequest{ImagePath: imagePath, WorkPath: workPath, TCPEstablished: opts.TCPEstablished, Criu: criu}
+	if console != nil {
+		req.Console = console.External
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(c.root, restoreFilename)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to record the restore: %w", err)
+	}
+	defer os.Remove(path)
+
+	c.warnVersionMismatch()
+	return c.startMonitor(context.Background())
+}
+
+// loadRestoreRequest returns the restore Restore left for the monitor,
+// or nil if it is to start the container process.
+func (c *linuxContainer) loadRestoreRequest() (*restoreRequest, error) {
+	data, err := os.ReadFile(filepath.Join(c.root, restoreFilename))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var req restoreRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", restoreFilename, err)
+	}
+	return &req, nil
+}
+
+// restoreInit has criu restore the checkpoint in req and records the
+// restored process as running, like startInit does a started one. The
+// caller becomes responsible for waiting on the returned process, which
+// it can as a subreaper once criu has exited.
+func (c *linuxContainer) restoreInit(req *restoreRequest) (parentProcess, error) {
+	state, err := c.loadState()
+	if err != nil {
+		return nil, err
+	}
+
+	// criu forks the tree and leaves it behind
+	if err := hostSys.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0); err != nil {
+		return nil, fmt.Errorf("failed to become a subreaper: %w", err)
+	}
+
+	pidFile := filepath.Join(req.WorkPath, criuRestorePidfile)
+	os.Remove(pidFile)
+	cmd := exec.Command(req.Criu, c.criuRestoreArgs(req, pidFile)...)
+
+	// The new pty is sent to the console socket like a started
+	// process's, and its slave inherited in place of the dumped one
+	var console *os.File
+	if req.Console != "" {
+		if console, err = sendConsole(c.consoleSocket, c.config.Process.ConsoleSize); err != nil {
+			return nil, err
+		}
+		defer console.Close()
+		cmd.ExtraFiles = []*os.File{console}
+	}
+
+	// criu restores the container's mounts on top of its root, which
+	// has to be a mount point for that
+	rootfs := c.config.Rootfs
+	if err := hostSys.Mount(rootfs, rootfs, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return nil, fmt.Errorf("failed to bind mount the rootfs for criu: %w", err)
+	}
+	out, err := cmd.CombinedOutput()
+	_ = hostSys.Unmount(rootfs, unix.MNT_DETACH)
+	if err != nil {
+		msg := fmt.Sprintf("criu restore failed: %v", err)
+		if text := strings.TrimSpace(string(out)); text != "" {
+			msg += ": " + text
+		}
+		return nil, fmt.Errorf("%s; see %s", msg, filepath.Join(req.WorkPath, criuRestoreLog))
+	}
+
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		return nil, fmt.Errorf("criu restore left no pid: %w", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return nil, fmt.Errorf("criu restore left an invalid pid %q", strings.TrimSpace(string(data)))
+	}
+
+	// The tree is stopped until it is in its cgroup, so nothing it runs
+	// escapes the limits
+	tree := processTree(pid)
+	if err := c.joinRestoredCgroup(tree); err != nil {
+		for _, p := range tree {
+			_ = hostSys.Kill(p, unix.SIGKILL)
+		}
+		return nil, err
+	}
+	// Whatever size criu left the pty at, the spec's is set again
+	// before the process can look
+	if size := c.config.Process.ConsoleSize; console != nil && size != nil {
+		setConsoleSize(console, size)
+	}
+	for _, p := range tree {
+		_ = hostSys.Kill(p, unix.SIGCONT)
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return nil, err
+	}
+	restored := &restoredProcess{process: process}
+	c.initProcess = restored
+
+	startTime, err := restored.startTime()
+	if err != nil {
+		startTime = 0
+	}
+	now := time.Now()
+	state.Status = Running
+	state.StartedAt = &now
+	state.Pid = pid
+	state.InitProcessStartTime = startTime
+	state.BootID = bootID()
+	state.ExitStatus = nil
+	state.MonitorPid = os.Getpid()
+	if err := c.saveState(state); err != nil {
+		_ = restored.terminate()
+		return nil, fmt.Errorf("failed to save container state after restore: %w", err)
+	}
+	c.emit(EventRestore, map[string]string{"pid": strconv.Itoa(pid), "imagePath": req.ImagePath})
+	return restored, nil
+}
+
+// joinRestoredCgroup creates the container's cgroup with its first
+// process, the restored init, sets the limits and adds the rest of the
+// tree.
+func (c *linuxContainer) joinRestoredCgroup(tree []int) error {
+	m := c.cgroupManager()
+	if err := m.Apply(tree[0]); err != nil {
+		return fmt.Errorf("failed to apply cgroup: %w", err)
+	}
+	if err := m.Set(c.config.Resolved.Resources); err != nil {
+		return fmt.Errorf("failed to set cgroup resources: %w", err)
+	}
+	for _, pid := range tree[1:] {
+		if err := m.AddProcess(pid); err != nil {
+			return fmt.Errorf("failed to move restored process %d into the cgroup: %w", pid, err)
+		}
+	}
+	return nil
+}
+
+// criuRestoreArgs returns the arguments of criu restore for req. The
+// tree is left stopped for the monitor to move into the cgroup.
+func (c *linuxContainer) criuRestoreArgs(req *restoreRequest, pidFile string) []string {
+	args := []string{
+		"restore",
+		"--images-dir", req.ImagePath,
+		"--work-dir", req.WorkPath,
+		"--log-file", criuRestoreLog,
+		"-v4",
+		"--root", c.config.Rootfs,
+		"--restore-detached",
+		"--leave-stopped",
+		"--pidfile", pidFile,
+		"--manage-cgroups=ignore",
+	}
+	// Checkpoint named each bind mount by its destination
+	for _, mnt := range c.config.Resolved.Mounts {
+		if mnt.Bind {
+			args = append(args, "--ext-mount-map", mnt.Destination+":"+mnt.Source)
+		}
+	}
+	if req.Console != "" {
+		args = append(args, "--inherit-fd", fmt.Sprintf("fd[%d]:%s", criuConsoleFd, req.Console))
+	}
+	if req.TCPEstablished {
+		args = append(args, "--tcp-established")
+	}
+	return args
+}
+
+// processTree returns pid and its descendants, parents first.
+func processTree(pid int) []int {
+	tree := []int{pid}
+	for i := 0; i < len(tree); i++ {
+		tasks, _ := os.ReadDir(fmt.Sprintf("/proc/%d/task", tree[i]))
+		for _, task := range tasks {
+			data, err := os.ReadFile(fmt.Sprintf("/proc/%d/task/%s/children", tree[i], task.Name()))
+			if err != nil {
+				continue
+			}
+			for _, field := range strings.Fields(string(data)) {
+				if child, err := strconv.Atoi(field); err == nil {
+					tree = append(tree, child)
+				}
+			}
+		}
+	}
+	return tree
+}
+
+// restoredProcess is a container process criu restored. It isn't the
+// monitor's child until criu exits, but is from then on, so it is
+// waited for like a started one.
+type restoredProcess struct {
+	process *os.Process
+}
+
+func (p *restoredProcess) pid() int {
+	return p.process.Pid
+}
+
+func (p *restoredProcess) start(ctx context.Context) error {
+	return fmt.Errorf("a restored process is already running")
+}
+
+func (p *restoredProcess) terminate() error {
+	return p.process.Signal(unix.SIGKILL)
+}
+
+func (p *restoredProcess) wait() (*os.ProcessState, error) {
+	return p.process.Wait()
+}
+
+func (p *restoredProcess) startTime() (uint64, error) {
+	return getProcessStartTime(p.process.Pid)
+}
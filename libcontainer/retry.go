@@ -0,0 +1,159 @@
+package libcontainer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// RetryPolicy bounds how cleanup steps the kernel refuses with EBUSY are
+// retried. Removing a cgroup or a mount point fails that way for a few
+// milliseconds after the last process using it exits.
+type RetryPolicy struct {
+	// Attempts is how many times a step is tried in all.
+	Attempts int
+	// Delay is the wait before the first retry. It doubles with every
+	// retry after that, up to MaxDelay.
+	Delay    time.Duration
+	MaxDelay time.Duration
+}
+
+// defaultRetryPolicy gives up about 300ms after the first attempt.
+var defaultRetryPolicy = RetryPolicy{
+	Attempts: 6,
+	Delay:    10 * time.Millisecond,
+	MaxDelay: 160 * time.Millisecond,
+}
+
+// WithRetryPolicy sets how the containers of the factory retry removing
+// their cgroups and mount points while the kernel reports them busy. A
+// monitor supervising a container uses the default policy.
+func WithRetryPolicy(policy RetryPolicy) CreateOption {
+	return func(l *LinuxFactory) error {
+		if policy.Attempts < 1 || policy.Delay < 0 || policy.MaxDelay < policy.Delay {
+			return fmt.Errorf("invalid retry policy: need at least 1 attempt and 0 <= delay <= max delay")
+		}
+		l.retry = policy
+		return nil
+	}
+}
+
+// retryBusy runs fn, which does op, until it succeeds, fails with
+// something other than EBUSY, policy runs out or ctx is done. The zero
+// policy is defaultRetryPolicy. Retries are reported on stderr, as stdout
+// belongs to the caller's output.
+func retryBusy(ctx context.Context, policy RetryPolicy, op string, fn func() error) error {
+	if policy.Attempts == 0 {
+		policy = defaultRetryPolicy
+	}
+	delay := policy.Delay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if !errors.Is(err, unix.EBUSY) || attempt >= policy.Attempts {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, ">>> [CLEANUP] %s busy, retrying in %s (attempt %d of %d)\n", op, delay, attempt+1, policy.Attempts)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, policy.MaxDelay)
+	}
+}
+
+// cleanupRmdir and cleanupUnmount are the syscalls retryBusy retries.
+func cleanupRmdir(s sysCalls, path string) error {
+	if err := s.Rmdir(path); err != nil {
+		return &os.PathError{Op: "rmdir", Path: path, Err: err}
+	}
+	return nil
+}
+
+func cleanupUnmount(s sysCalls, target string, flags int) error {
+	return unmount(s, target, flags)
+}
+
+// cgroupHolders names what keeps the cgroup at dir busy: the processes
+// still in it and its child cgroups.
+func cgroupHolders(dir string) []string {
+	var holders []string
+	if data, err := os.ReadFile(filepath.Join(dir, "cgroup.procs")); err == nil {
+		for _, pid := range strings.Fields(string(data)) {
+			holders = append(holders, processName(pid))
+		}
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			holders = append(holders, "child cgroup "+entry.Name())
+		}
+	}
+	return holders
+}
+
+// mountHolders names a process in every other mount namespace that
+// still has something mounted at path, which keeps path busy here.
+func mountHolders(path string) []string {
+	own, _ := os.Readlink("/proc/self/ns/mnt")
+	seen := map[string]bool{own: true}
+	mountinfos, _ := filepath.Glob("/proc/[0-9]*/mountinfo")
+
+	var holders []string
+	for _, mountinfo := range mountinfos {
+		dir := filepath.Dir(mountinfo)
+		ns, err := os.Readlink(filepath.Join(dir, "ns/mnt"))
+		if err != nil || seen[ns] {
+			continue
+		}
+		seen[ns] = true
+		if hasMountAt(mountinfo, path) {
+			holders = append(holders, processName(filepath.Base(dir))+" in "+ns)
+		}
+	}
+	return holders
+}
+
+// hasMountAt reports whether the mountinfo file at mountinfo has a mount
+// at path.
+func hasMountAt(mountinfo, path string) bool {
+	f, err := os.Open(mountinfo)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	mounts, err := parseMountinfo(f)
+	if err != nil {
+		return false
+	}
+	for _, m := range mounts {
+		if m.mountpoint == path {
+			return true
+		}
+	}
+	return false
+}
+
+// processName describes pid by its command name, if it can be read.
+func processName(pid string) string {
+	comm, err := os.ReadFile(filepath.Join("/proc", pid, "comm"))
+	if err != nil {
+		return "pid " + pid
+	}
+	return fmt.Sprintf("pid %s (%s)", pid, strings.TrimSpace(string(comm)))
+}
+
+// busyError adds the holders of a resource to the EBUSY err that
+// retryBusy gave up on.
+func busyError(err error, holders []string) error {
+	if !errors.Is(err, unix.EBUSY) || len(holders) == 0 {
+		return err
+	}
+	return fmt.Errorf("%w (held by %s)", err, strings.Join(holders, ", "))
+}
@@ -0,0 +1,90 @@
+package libcontainer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// fastRetry is defaultRetryPolicy's number of attempts without the wait.
+var fastRetry = RetryPolicy{Attempts: 6, Delay: time.Microsecond, MaxDelay: time.Microsecond}
+
+func TestRemoveCgroupDirRetries(t *testing.T) {
+	tests := []struct {
+		name  string
+		errs  []error
+		calls int
+		// want is in the error, or nothing is returned if empty
+		want string
+	}{
+		{"not busy", nil, 1, ""},
+		{"busy for a moment", []error{unix.EBUSY, unix.EBUSY}, 3, ""},
+		{"already gone", []error{unix.ENOENT}, 1, ""},
+		{"busy throughout", slices.Repeat([]error{unix.EBUSY}, 6), 6, "held by child cgroup leftover"},
+		{"not retried", []error{unix.EACCES}, 1, "permission denied"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.Mkdir(filepath.Join(dir, "leftover"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			s := &faultSys{}
+			s.fail("Rmdir", tt.errs...)
+			err := removeCgroupDir(s, dir, fastRetry)
+			if tt.want == "" && err != nil {
+				t.Fatalf("got %v", err)
+			}
+			if tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+				t.Fatalf("got %v, want %q", err, tt.want)
+			}
+			if calls := s.called(); len(calls) != tt.calls {
+				t.Errorf("got %d calls %q, want %d", len(calls), calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestCleanupUnmountBusy(t *testing.T) {
+	s := &faultSys{}
+	s.fail("Unmount", unix.EBUSY)
+	err := retryBusy(t.Context(), fastRetry, "unmount /mnt", func() error {
+		return cleanupUnmount(s, "/mnt", 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls := s.called(); len(calls) != 2 {
+		t.Errorf("got calls %q, want one retry", calls)
+	}
+
+	s = &faultSys{}
+	s.fail("Unmount", unix.EBUSY, unix.EBUSY)
+	err = retryBusy(t.Context(), RetryPolicy{Attempts: 2}, "unmount /mnt", func() error {
+		return cleanupUnmount(s, "/mnt", 0)
+	})
+	var pathErr *os.PathError
+	if !errors.As(err, &pathErr) || pathErr.Path != "/mnt" || !errors.Is(err, unix.EBUSY) {
+		t.Fatalf("got %v, want EBUSY unmounting /mnt", err)
+	}
+}
+
+func TestHasMountAtUnescapes(t *testing.T) {
+	mountinfo := filepath.Join(t.TempDir(), "mountinfo")
+	data := rootPrivate + `2 1 8:2 / /run/a\040b rw - ext4 /dev/sda2 rw` + "\n"
+	if err := os.WriteFile(mountinfo, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !hasMountAt(mountinfo, "/run/a b") {
+		t.Error("missed the mount at an escaped path")
+	}
+	if hasMountAt(mountinfo, `/run/a\040b`) || hasMountAt(mountinfo, "/run") {
+		t.Error("found a mount that isn't there")
+	}
+}
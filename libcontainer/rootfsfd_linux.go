@@ -0,0 +1,107 @@
+package libcontainer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// pinnedRootfsDirname is where, in the container root, Create mounts a
+// rootfs given as a file descriptor.
+const pinnedRootfsDirname = "rootfs"
+
+// WithRootfsFD makes Create use the directory open as fd for the rootfs
+// instead of resolving root.path, which must still name one. The caller
+// resolves and checks the rootfs once; swapping root.path, or any of its
+// parents, for a symlink afterwards has no effect on the container. fd
+// stays the caller's to close once Create returns.
+func WithRootfsFD(fd int) CreateOption {
+	return func(l *LinuxFactory) error {
+		var st unix.Stat_t
+		if fd < 0 || unix.Fstat(fd, &st) != nil {
+			return fmt.Errorf("rootfs fd %d is not open", fd)
+		}
+		if st.Mode&unix.S_IFMT != unix.S_IFDIR {
+			return fmt.Errorf("rootfs fd %d is not a directory", fd)
+		}
+		l.rootfsFD = fd
+		return nil
+	}
+}
+
+// pinRootfs mounts a copy of the mount tree at fd in containerRoot, so
+// the container process started later finds the directory fd refers to
+// without resolving the caller's path again. It returns the path the
+// rootfs is pinned at.
+func pinRootfs(fd int, containerRoot string) (string, error) {
+	tree, err := unix.OpenTree(fd, "", unix.OPEN_TREE_CLONE|unix.OPEN_TREE_CLOEXEC|unix.AT_RECURSIVE|unix.AT_EMPTY_PATH)
+	if err != nil {
+		return "", fmt.Errorf("failed to clone rootfs mount: %w", err)
+	}
+	defer unix.Close(tree)
+
+	target := filepath.Join(containerRoot, pinnedRootfsDirname)
+	if err := os.Mkdir(target, 0700); err != nil {
+		return "", err
+	}
+	if err := unix.MoveMount(tree, "", unix.AT_FDCWD, target, unix.MOVE_MOUNT_F_EMPTY_PATH); err != nil {
+		return "", &os.PathError{Op: "move_mount", Path: target, Err: err}
+	}
+	return target, nil
+}
+
+// unpinRootfs undoes pinRootfs, along with anything since mounted under
+// the pinned rootfs. The mount point is removed only once empty, so a
+// mount that is still there is never mistaken for files to delete. Both
+// steps retry while busy as retry says; the mount point stays busy while
+// another mount namespace has the rootfs mounted there.
+func unpinRootfs(containerRoot string, retry RetryPolicy) error {
+	target := filepath.Join(containerRoot, pinnedRootfsDirname)
+	if err := unmountUnder(target, retry); err != nil {
+		return err
+	}
+	err := retryBusy(context.Background(), retry, "rmdir "+target, func() error {
+		return cleanupRmdir(hostSys, target)
+	})
+	if err != nil && !errors.Is(err, unix.ENOENT) {
+		return busyError(err, mountHolders(target))
+	}
+	return nil
+}
+
+// rootfsPinned reports whether the container's rootfs was given to
+// Create as a file descriptor.
+func (c *linuxContainer) rootfsPinned() bool {
+	return c.config.Rootfs == filepath.Join(c.root, pinnedRootfsDirname)
+}
+
+// openPinnedRootfs clones the pinned rootfs for the container process.
+// The clone is reachable without a path, which the container root's
+// permissions keep from a process in a new user namespace.
+func (c *linuxContainer) openPinnedRootfs() (*os.File, error) {
+	tree, err := unix.OpenTree(unix.AT_FDCWD, c.config.Rootfs, unix.OPEN_TREE_CLONE|unix.OPEN_TREE_CLOEXEC|unix.AT_RECURSIVE)
+	if err != nil {
+		return nil, &os.PathError{Op: "open_tree", Path: c.config.Rootfs, Err: err}
+	}
+	return os.NewFile(uintptr(tree), c.config.Rootfs), nil
+}
+
+// pinnedRootfsPath is the path the container process reaches the clone
+// from openPinnedRootfs by, open as tree, until it pivots into it.
+func pinnedRootfsPath(tree *os.File) string {
+	return fmt.Sprintf("/proc/self/fd/%d", tree.Fd())
+}
+
+// attachRootfs mounts the clone from openPinnedRootfs over the current
+// root, where pivotRoot picks it up. Lookups from / don't enter a mount
+// stacked on it, so the host stays visible for bind sources.
+func attachRootfs(tree *os.File) error {
+	if err := unix.MoveMount(int(tree.Fd()), "", unix.AT_FDCWD, "/", unix.MOVE_MOUNT_F_EMPTY_PATH); err != nil {
+		return fmt.Errorf("failed to attach pinned rootfs: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,349 @@
+// Package rootfsfile resolves and creates paths inside a container's
+// rootfs the way they would resolve with the rootfs as /, so a symlink
+// in a hostile image can't redirect the runtime's work to the host.
+//
+// Paths are resolved with openat2(2) where the kernel has it (5.6 and
+// later), which refuses to leave the rootfs by any route. Elsewhere
+// they are resolved component by component in userspace first, which
+// a rootfs being changed concurrently can still race.
+package rootfsfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// Mechanisms a FS resolves paths with.
+const (
+	MechanismOpenat2    = "openat2"
+	MechanismSecureJoin = "securejoin"
+)
+
+// noOpenat2Env makes FS resolve paths without openat2 even where the
+// kernel has it, so tests can exercise the fallback.
+const noOpenat2Env = "HACKONTAINER_TEST_NO_OPENAT2"
+
+// FS is a rootfs that paths are resolved inside. Paths are taken as
+// seen from inside the container: absolute symlinks and .. stop at the
+// rootfs, and magic links such as /proc/self/root are refused.
+type FS interface {
+	// Mechanism names how paths are resolved.
+	Mechanism() string
+
+	// Open returns an O_PATH handle to path.
+	Open(path string) (*os.File, error)
+
+	// MkdirAll creates path and any missing parents, and returns an
+	// O_PATH handle to it.
+	MkdirAll(path string, perm os.FileMode) (*os.File, error)
+
+	// CreateFile creates an empty file at path, and any missing
+	// parents, unless something is there already. It returns an O_PATH
+	// handle to what is at path.
+	CreateFile(path string, perm os.FileMode) (*os.File, error)
+
+	// Mknod replaces whatever is at path with a node of the given mode
+	// and device number. Unlike mknod(2), the mode isn't subject to
+	// the umask.
+	Mknod(path string, mode uint32, dev int) error
+
+	// Lchown changes the owner of path without following a symlink
+	// there.
+	Lchown(path string, uid, gid int) error
+
+	// Symlink creates a symlink at path pointing to target.
+	Symlink(target, path string) error
+
+	// Lstat describes path without following a symlink there.
+	Lstat(path string) (*unix.Stat_t, error)
+
+	// Remove removes the file or symlink at path, without following a
+	// symlink there.
+	Remove(path string) error
+
+	Close() error
+}
+
+// ProcPath is a path naming the file f refers to. Unlike the path f was
+// opened by, it can't be changed to name something else, so mount(2)
+// can be given it as a target.
+func ProcPath(f *os.File) string {
+	return fmt.Sprintf("/proc/self/fd/%d", f.Fd())
+}
+
+// Mechanism names what Open will resolve paths with on this host.
+func Mechanism() string {
+	if openat2Supported() {
+		return MechanismOpenat2
+	}
+	return MechanismSecureJoin
+}
+
+var openat2Supported = sync.OnceValue(func() bool {
+	if os.Getenv(noOpenat2Env) != "" {
+		return false
+	}
+	fd, err := unix.Openat2(unix.AT_FDCWD, "/", &unix.OpenHow{
+		Flags:   unix.O_PATH | unix.O_CLOEXEC,
+		Resolve: unix.RESOLVE_IN_ROOT,
+	})
+	if err != nil {
+		return false
+	}
+	unix.Close(fd)
+	return true
+})
+
+// Open returns the FS rooted at the directory root.
+func Open(root string) (FS, error) {
+	dir, err := os.OpenFile(root, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &rootFS{dir: dir, path: root, openat2: openat2Supported()}, nil
+}
+
+type rootFS struct {
+	dir     *os.File
+	path    string
+	openat2 bool
+}
+
+func (r *rootFS) Mechanism() string {
+	if r.openat2 {
+		return MechanismOpenat2
+	}
+	return MechanismSecureJoin
+}
+
+func (r *rootFS) Close() error {
+	return r.dir.Close()
+}
+
+func (r *rootFS) Open(path string) (*os.File, error) {
+	return r.open(path, unix.O_PATH)
+}
+
+// open opens path, resolved inside the rootfs, with flags.
+func (r *rootFS) open(path string, flags int) (*os.File, error) {
+	flags |= unix.O_CLOEXEC
+	if r.openat2 {
+		how := &unix.OpenHow{
+			Flags:   uint64(flags),
+			Resolve: unix.RESOLVE_IN_ROOT | unix.RESOLVE_NO_MAGICLINKS,
+		}
+		for {
+			fd, err := unix.Openat2(int(r.dir.Fd()), path, how)
+			// The kernel gives up on lookups that raced a rename
+			if err == unix.EAGAIN {
+				continue
+			}
+			if err != nil {
+				return nil, &os.PathError{Op: "openat2", Path: path, Err: err}
+			}
+			return os.NewFile(uintptr(fd), path), nil
+		}
+	}
+
+	full, err := secureJoin(r.path, path)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	fd, err := unix.Open(full, flags|unix.O_NOFOLLOW, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	// O_PATH opens a symlink itself instead of failing; one here was
+	// swapped in after it was resolved
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil || st.Mode&unix.S_IFMT == unix.S_IFLNK {
+		unix.Close(fd)
+		return nil, &os.PathError{Op: "open", Path: path, Err: unix.ELOOP}
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+// openParent opens the directory holding path, which must exist, and
+// returns it with the name path has in it.
+func (r *rootFS) openParent(path string) (*os.File, string, error) {
+	path = filepath.Join("/", path)
+	if path == "/" {
+		return nil, "", &os.PathError{Op: "open", Path: path, Err: unix.EINVAL}
+	}
+	parent, err := r.open(filepath.Dir(path), unix.O_PATH|unix.O_DIRECTORY)
+	if err != nil {
+		return nil, "", err
+	}
+	return parent, filepath.Base(path), nil
+}
+
+func (r *rootFS) MkdirAll(path string, perm os.FileMode) (*os.File, error) {
+	return r.mkdirAll(path, perm, 0)
+}
+
+// mkdirAll is MkdirAll, with links dangling symlinks followed so far.
+func (r *rootFS) mkdirAll(path string, perm os.FileMode, links int) (*os.File, error) {
+	dir, err := r.open(path, unix.O_PATH|unix.O_DIRECTORY)
+	if !errors.Is(err, unix.ENOENT) {
+		return dir, err
+	}
+
+	path = filepath.Join("/", path)
+	if path == "/" {
+		return nil, err
+	}
+	parent, err := r.mkdirAll(filepath.Dir(path), perm, links)
+	if err != nil {
+		return nil, err
+	}
+	err = unix.Mkdirat(int(parent.Fd()), filepath.Base(path), uint32(perm.Perm()))
+	if err == unix.EEXIST {
+		// A dangling symlink; the directory is made where it points
+		target, ok := danglingTarget(parent, path)
+		parent.Close()
+		if !ok {
+			return r.open(path, unix.O_PATH|unix.O_DIRECTORY)
+		}
+		if links++; links > maxSymlinks {
+			return nil, &os.PathError{Op: "mkdir", Path: path, Err: unix.ELOOP}
+		}
+		return r.mkdirAll(target, perm, links)
+	}
+	parent.Close()
+	if err != nil {
+		return nil, &os.PathError{Op: "mkdir", Path: path, Err: err}
+	}
+	return r.open(path, unix.O_PATH|unix.O_DIRECTORY)
+}
+
+func (r *rootFS) CreateFile(path string, perm os.FileMode) (*os.File, error) {
+	return r.createFile(path, perm, 0)
+}
+
+// createFile is CreateFile, with links dangling symlinks followed so
+// far.
+func (r *rootFS) createFile(path string, perm os.FileMode, links int) (*os.File, error) {
+	f, err := r.Open(path)
+	if !errors.Is(err, unix.ENOENT) {
+		return f, err
+	}
+
+	path = filepath.Join("/", path)
+	parent, err := r.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := unix.Openat(int(parent.Fd()), filepath.Base(path), unix.O_CREAT|unix.O_EXCL|unix.O_WRONLY|unix.O_NOFOLLOW|unix.O_CLOEXEC, uint32(perm.Perm()))
+	if err == unix.EEXIST {
+		target, ok := danglingTarget(parent, path)
+		parent.Close()
+		if !ok {
+			return r.Open(path)
+		}
+		if links++; links > maxSymlinks {
+			return nil, &os.PathError{Op: "create", Path: path, Err: unix.ELOOP}
+		}
+		return r.createFile(target, perm, links)
+	}
+	parent.Close()
+	if err != nil {
+		return nil, &os.PathError{Op: "create", Path: path, Err: err}
+	}
+	unix.Close(fd)
+	return r.Open(path)
+}
+
+// danglingTarget returns where the symlink at path, found in parent,
+// points, as a path in the rootfs. A relative target is taken from the
+// directory path names, which is where the kernel would take it from
+// unless that directory was itself reached through a symlink. ok is
+// false if path isn't a symlink.
+func danglingTarget(parent *os.File, path string) (target string, ok bool) {
+	buf := make([]byte, unix.PathMax)
+	n, err := unix.Readlinkat(int(parent.Fd()), filepath.Base(path), buf)
+	if err != nil {
+		return "", false
+	}
+	target = string(buf[:n])
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(path), target)
+	}
+	return target, true
+}
+
+func (r *rootFS) Mknod(path string, mode uint32, dev int) error {
+	parent, name, err := r.openParent(path)
+	if err != nil {
+		return err
+	}
+	defer parent.Close()
+
+	if err := unix.Unlinkat(int(parent.Fd()), name, 0); err != nil && err != unix.ENOENT {
+		return &os.PathError{Op: "unlink", Path: path, Err: err}
+	}
+	if err := unix.Mknodat(int(parent.Fd()), name, mode, dev); err != nil {
+		return &os.PathError{Op: "mknod", Path: path, Err: err}
+	}
+	if err := unix.Fchmodat(int(parent.Fd()), name, mode&07777, 0); err != nil {
+		return &os.PathError{Op: "chmod", Path: path, Err: err}
+	}
+	return nil
+}
+
+func (r *rootFS) Lchown(path string, uid, gid int) error {
+	parent, name, err := r.openParent(path)
+	if err != nil {
+		return err
+	}
+	defer parent.Close()
+
+	if err := unix.Fchownat(int(parent.Fd()), name, uid, gid, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return &os.PathError{Op: "lchown", Path: path, Err: err}
+	}
+	return nil
+}
+
+func (r *rootFS) Symlink(target, path string) error {
+	parent, name, err := r.openParent(path)
+	if err != nil {
+		return err
+	}
+	defer parent.Close()
+
+	if err := unix.Symlinkat(target, int(parent.Fd()), name); err != nil {
+		return &os.PathError{Op: "symlink", Path: path, Err: err}
+	}
+	return nil
+}
+
+func (r *rootFS) Lstat(path string) (*unix.Stat_t, error) {
+	parent, name, err := r.openParent(path)
+	if err != nil {
+		return nil, err
+	}
+	defer parent.Close()
+
+	var st unix.Stat_t
+	if err := unix.Fstatat(int(parent.Fd()), name, &st, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return nil, &os.PathError{Op: "lstat", Path: path, Err: err}
+	}
+	return &st, nil
+}
+
+func (r *rootFS) Remove(path string) error {
+	parent, name, err := r.openParent(path)
+	if err != nil {
+		return err
+	}
+	defer parent.Close()
+
+	if err := unix.Unlinkat(int(parent.Fd()), name, 0); err != nil {
+		return &os.PathError{Op: "unlink", Path: path, Err: err}
+	}
+	return nil
+}
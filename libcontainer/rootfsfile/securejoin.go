@@ -0,0 +1,53 @@
+package rootfsfile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// maxSymlinks is how many symlinks secureJoin follows in one path, as
+// the kernel's limit on nested links.
+const maxSymlinks = 255
+
+// secureJoin resolves path inside root as the kernel would with root as
+// /, and returns the result as a path on the host: .. stops at root and
+// symlinks, absolute ones included, are followed inside it. Magic links
+// aren't followed, since only their text is read. Components that don't
+// exist are taken as they are.
+func secureJoin(root, path string) (string, error) {
+	// resolved never contains a symlink, so .. can be applied to it
+	// lexically
+	resolved := "/"
+	links := 0
+	for path != "" {
+		var name string
+		name, path, _ = strings.Cut(path, "/")
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, name)
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			// Not a symlink, or not there at all
+			resolved = next
+			continue
+		}
+		links++
+		if links > maxSymlinks {
+			return "", unix.ELOOP
+		}
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+		path = target + "/" + path
+	}
+	return filepath.Join(root, resolved), nil
+}
@@ -0,0 +1,54 @@
+package libcontainer
+
+import (
+	"errors"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/zakarynichols/hackontainer/config"
+	"golang.org/x/sys/unix"
+)
+
+// seccomp(2) operations and filter flags, which x/sys doesn't define.
+const (
+	seccompSetModeFilter = 1
+
+	seccompFilterFlagTsync            = 1 << 0
+	seccompFilterFlagLog              = 1 << 1
+	seccompFilterFlagSpecAllow        = 1 << 2
+	seccompFilterFlagNewListener      = 1 << 3
+	seccompFilterFlagWaitKillableRecv = 1 << 5
+)
+
+// seccompFilterFlags maps each flag of config.SeccompFlags to the bits
+// it sets for seccomp(2). Waiting killably applies to notifications
+// only, so the kernel takes it only with a listener.
+var seccompFilterFlags = map[specs.LinuxSeccompFlag]uintptr{
+	config.LinuxSeccompFlagTsync:           seccompFilterFlagTsync,
+	specs.LinuxSeccompFlagLog:              seccompFilterFlagLog,
+	specs.LinuxSeccompFlagSpecAllow:        seccompFilterFlagSpecAllow,
+	specs.LinuxSeccompFlagWaitKillableRecv: seccompFilterFlagWaitKillableRecv | seccompFilterFlagNewListener,
+}
+
+// supportedSeccompFlags lists the flags of config.SeccompFlags this
+// kernel accepts. Each is passed with no filter: a kernel that knows the
+// flag fails to read the filter with EFAULT, before any permission
+// check, and one that doesn't rejects the flag with EINVAL.
+func supportedSeccompFlags() []string {
+	var supported []string
+	for _, flag := range config.SeccompFlags {
+		_, _, errno := unix.Syscall(unix.SYS_SECCOMP, seccompSetModeFilter, seccompFilterFlags[flag], 0)
+		if errors.Is(errno, unix.EFAULT) {
+			supported = append(supported, string(flag))
+		}
+	}
+	return supported
+}
+
+// knownSeccompFlags lists config.SeccompFlags as strings.
+func knownSeccompFlags() []string {
+	known := make([]string, 0, len(config.SeccompFlags))
+	for _, flag := range config.SeccompFlags {
+		known = append(known, string(flag))
+	}
+	return known
+}
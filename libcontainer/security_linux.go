@@ -0,0 +1,153 @@
+package libcontainer
+
+import (
+	"fmt"
+	"strings"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// securityOverridesAnnotation records the confinement a container was
+// created without, so inspect shows that it ran weakened.
+const securityOverridesAnnotation = "org.hackontainer.security-overrides"
+
+// allCapabilities lists every capability the kernel knows, in the order
+// of their numbers.
+var allCapabilities = []string{
+	"CAP_CHOWN", "CAP_DAC_OVERRIDE", "CAP_DAC_READ_SEARCH", "CAP_FOWNER",
+	"CAP_FSETID", "CAP_KILL", "CAP_SETGID", "CAP_SETUID", "CAP_SETPCAP",
+	"CAP_LINUX_IMMUTABLE", "CAP_NET_BIND_SERVICE", "CAP_NET_BROADCAST",
+	"CAP_NET_ADMIN", "CAP_NET_RAW", "CAP_IPC_LOCK", "CAP_IPC_OWNER",
+	"CAP_SYS_MODULE", "CAP_SYS_RAWIO", "CAP_SYS_CHROOT", "CAP_SYS_PTRACE",
+	"CAP_SYS_PACCT", "CAP_SYS_ADMIN", "CAP_SYS_BOOT", "CAP_SYS_NICE",
+	"CAP_SYS_RESOURCE", "CAP_SYS_TIME", "CAP_SYS_TTY_CONFIG", "CAP_MKNOD",
+	"CAP_LEASE", "CAP_AUDIT_WRITE", "CAP_AUDIT_CONTROL", "CAP_SETFCAP",
+	"CAP_MAC_OVERRIDE", "CAP_MAC_ADMIN", "CAP_SYSLOG", "CAP_WAKE_ALARM",
+	"CAP_BLOCK_SUSPEND", "CAP_AUDIT_READ", "CAP_PERFMON", "CAP_BPF",
+	"CAP_CHECKPOINT_RESTORE",
+}
+
+// securityOverrides weakens the confinement the spec asks for. It is a
+// debugging aid for telling whether confinement causes a failure.
+type securityOverrides struct {
+	seccompUnconfined  bool
+	apparmorUnconfined bool
+	// capAdd holds capability names, or "ALL".
+	capAdd []string
+}
+
+// WithSecurityOpt applies a docker-style security option to the loaded
+// spec. seccomp=unconfined drops linux.seccomp and apparmor=unconfined
+// sets the AppArmor profile to unconfined.
+func WithSecurityOpt(opt string) CreateOption {
+	return func(l *LinuxFactory) error {
+		key, value, ok := strings.Cut(opt, "=")
+		if !ok {
+			return fmt.Errorf("invalid security option %q, want key=value", opt)
+		}
+		switch key {
+		case "seccomp", "apparmor":
+		default:
+			return fmt.Errorf("unknown security option %q (want seccomp or apparmor)", key)
+		}
+		if value != "unconfined" {
+			return fmt.Errorf("security option %s only supports unconfined, got %q", key, value)
+		}
+
+		if key == "seccomp" {
+			l.security.seccompUnconfined = true
+		} else {
+			l.security.apparmorUnconfined = true
+		}
+		return nil
+	}
+}
+
+// WithCapAdd grants capabilities on top of those the spec lists, in the
+// bounding, effective and permitted sets. Names may omit the CAP_ prefix
+// and ALL grants every capability.
+func WithCapAdd(caps ...string) CreateOption {
+	return func(l *LinuxFactory) error {
+		// Copied, since Create's copy of the factory shares the array
+		capAdd := append([]string(nil), l.security.capAdd...)
+		for _, name := range caps {
+			name = strings.ToUpper(name)
+			if name != "ALL" && !strings.HasPrefix(name, "CAP_") {
+				name = "CAP_" + name
+			}
+			if name != "ALL" && !hasCapability(allCapabilities, name) {
+				return fmt.Errorf("unknown capability %q", name)
+			}
+			capAdd = append(capAdd, name)
+		}
+		l.security.capAdd = capAdd
+		return nil
+	}
+}
+
+// String describes the overrides in the form they were given, or ""
+// when there are none.
+func (o securityOverrides) String() string {
+	var parts []string
+	if o.seccompUnconfined {
+		parts = append(parts, "seccomp=unconfined")
+	}
+	if o.apparmorUnconfined {
+		parts = append(parts, "apparmor=unconfined")
+	}
+	for _, name := range o.capAdd {
+		parts = append(parts, "cap-add="+name)
+	}
+	return strings.Join(parts, ",")
+}
+
+// apply weakens spec and records what it did in its annotations.
+func (o securityOverrides) apply(spec *specs.Spec) {
+	summary := o.String()
+	if summary == "" {
+		return
+	}
+
+	if o.seccompUnconfined && spec.Linux != nil {
+		spec.Linux.Seccomp = nil
+	}
+	if o.apparmorUnconfined {
+		if spec.Process == nil {
+			spec.Process = &specs.Process{Cwd: "/"}
+		}
+		spec.Process.ApparmorProfile = "unconfined"
+	}
+	if len(o.capAdd) > 0 {
+		if spec.Process == nil {
+			spec.Process = &specs.Process{Cwd: "/"}
+		}
+		if spec.Process.Capabilities == nil {
+			spec.Process.Capabilities = &specs.LinuxCapabilities{}
+		}
+		caps := spec.Process.Capabilities
+		for _, name := range o.capAdd {
+			added := []string{name}
+			if name == "ALL" {
+				added = allCapabilities
+			}
+			caps.Bounding = addCapabilities(caps.Bounding, added)
+			caps.Effective = addCapabilities(caps.Effective, added)
+			caps.Permitted = addCapabilities(caps.Permitted, added)
+		}
+	}
+
+	if spec.Annotations == nil {
+		spec.Annotations = make(map[string]string, 1)
+	}
+	spec.Annotations[securityOverridesAnnotation] = summary
+}
+
+// addCapabilities appends the capabilities of added that set lacks.
+func addCapabilities(set, added []string) []string {
+	for _, name := range added {
+		if !hasCapability(set, name) {
+			set = append(set, name)
+		}
+	}
+	return set
+}
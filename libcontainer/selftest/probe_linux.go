@@ -0,0 +1,283 @@
+package selftest
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// probeArg is the argument the runtime binary is run with inside the
+// self-test container, where it is the container's process.
+const probeArg = "--self-test-probe"
+
+// probeExitSignaled is how the init probe exits on SIGTERM, as the
+// shell reports a process killed by it.
+const probeExitSignaled = 128 + int(unix.SIGTERM)
+
+// probeLifetime bounds how long the init probe waits for the kill that
+// ends the self-test, should the runtime under test lose track of it.
+const probeLifetime = 5 * time.Minute
+
+// namespaceLinks are the namespaces the probe reports, by the name of
+// their link in /proc/self/ns.
+var namespaceLinks = []string{"pid", "net", "ipc", "uts", "mnt", "user", "cgroup"}
+
+// probeReport is what the probe saw from inside the container.
+type probeReport struct {
+	Pid      int    `json:"pid"`
+	UID      int    `json:"uid"`
+	Hostname string `json:"hostname"`
+	// Namespaces maps each of namespaceLinks to its link target. A
+	// process outside the pid namespace of the container's /proc can't
+	// read them.
+	Namespaces map[string]string `json:"namespaces"`
+	// Interfaces are the network interfaces, read over netlink.
+	Interfaces []string `json:"interfaces"`
+	// Mounts maps mount points to their filesystem type, the last one
+	// mounted at each.
+	Mounts  map[string]string `json:"mounts"`
+	Devices []probeDevice     `json:"devices"`
+	// NullWritable is set when /dev/null could be opened for writing.
+	NullWritable bool `json:"nullWritable"`
+	// Masked maps each masked path that exists to what is visible
+	// through it, "" when nothing is.
+	Masked map[string]string `json:"masked"`
+	// Readonly maps each read-only path that exists to whether it is.
+	Readonly map[string]bool `json:"readonly"`
+	Cgroup   probeCgroup     `json:"cgroup"`
+}
+
+// probeDevice is a device node directly in /dev.
+type probeDevice struct {
+	Path  string `json:"path"`
+	Type  string `json:"type"`
+	Major uint32 `json:"major"`
+	Minor uint32 `json:"minor"`
+}
+
+// probeCgroup holds the limits the container reads in its own cgroup.
+// Values are as the kernel writes them; a missing file leaves "".
+type probeCgroup struct {
+	Version     int      `json:"version"`
+	MemoryLimit string   `json:"memoryLimit"`
+	PidsMax     string   `json:"pidsMax"`
+	DevicesList []string `json:"devicesList,omitempty"`
+}
+
+// IsProbe reports whether args, as in os.Args, are those of the process
+// the self-test runs in its container.
+func IsProbe(args []string) bool {
+	return len(args) > 1 && args[1] == probeArg
+}
+
+// RunProbe is called by main() for a process IsProbe recognizes. It
+// writes what it sees to the report file it is given. Run as the
+// container's init it then waits for SIGTERM, and exits with
+// probeExitSignaled once it gets it.
+func RunProbe(args []string) error {
+	flags := flag.NewFlagSet("self-test probe", flag.ContinueOnError)
+	report := flags.String("report", "", "file to write the report to")
+	masked := flags.String("masked", "", "masked paths, colon-separated")
+	readonly := flags.String("readonly", "", "read-only paths, colon-separated")
+	wait := flags.Bool("wait", false, "wait for SIGTERM after reporting")
+	if err := flags.Parse(args[2:]); err != nil {
+		return err
+	}
+	if *report == "" {
+		return fmt.Errorf("probe needs -report")
+	}
+
+	// Subscribed first, so a kill right after the report is caught
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, unix.SIGTERM)
+
+	data, err := json.Marshal(probe(splitPaths(*masked), splitPaths(*readonly)))
+	if err != nil {
+		return err
+	}
+	// Renamed into place so the self-test never reads half a report
+	tmp := *report + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, *report); err != nil {
+		return err
+	}
+
+	if *wait {
+		select {
+		case <-term:
+			os.Exit(probeExitSignaled)
+		case <-time.After(probeLifetime):
+			return fmt.Errorf("not killed within %s", probeLifetime)
+		}
+	}
+	return nil
+}
+
+func splitPaths(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ":")
+}
+
+// probe looks around the container.
+func probe(masked, readonly []string) *probeReport {
+	r := &probeReport{
+		Pid:        os.Getpid(),
+		UID:        os.Getuid(),
+		Namespaces: make(map[string]string),
+		Mounts:     probeMounts(),
+		Masked:     make(map[string]string),
+		Readonly:   make(map[string]bool),
+		Cgroup:     probeCgroups(),
+	}
+	r.Hostname, _ = os.Hostname()
+	if ifaces, err := net.Interfaces(); err == nil {
+		for _, iface := range ifaces {
+			r.Interfaces = append(r.Interfaces, iface.Name)
+		}
+	}
+	for _, name := range namespaceLinks {
+		if link, err := os.Readlink(filepath.Join("/proc/self/ns", name)); err == nil {
+			r.Namespaces[name] = link
+		}
+	}
+
+	entries, _ := os.ReadDir("/dev")
+	for _, entry := range entries {
+		path := filepath.Join("/dev", entry.Name())
+		var st unix.Stat_t
+		if err := unix.Lstat(path, &st); err != nil {
+			continue
+		}
+		var devType string
+		switch st.Mode & unix.S_IFMT {
+		case unix.S_IFCHR:
+			devType = "c"
+		case unix.S_IFBLK:
+			devType = "b"
+		default:
+			continue
+		}
+		r.Devices = append(r.Devices, probeDevice{
+			Path:  path,
+			Type:  devType,
+			Major: unix.Major(uint64(st.Rdev)),
+			Minor: unix.Minor(uint64(st.Rdev)),
+		})
+	}
+	if f, err := os.OpenFile("/dev/null", os.O_WRONLY, 0); err == nil {
+		r.NullWritable = true
+		f.Close()
+	}
+
+	for _, path := range masked {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if info.IsDir() {
+			entries, _ := os.ReadDir(path)
+			if len(entries) > 0 {
+				r.Masked[path] = fmt.Sprintf("lists %d entries", len(entries))
+			} else {
+				r.Masked[path] = ""
+			}
+			continue
+		}
+		buf := make([]byte, 1)
+		f, err := os.Open(path)
+		if err != nil {
+			r.Masked[path] = ""
+			continue
+		}
+		if n, _ := f.Read(buf); n > 0 {
+			r.Masked[path] = "is readable"
+		} else {
+			r.Masked[path] = ""
+		}
+		f.Close()
+	}
+
+	for _, path := range readonly {
+		var st unix.Statfs_t
+		if err := unix.Statfs(path, &st); err != nil {
+			continue
+		}
+		r.Readonly[path] = st.Flags&unix.ST_RDONLY != 0
+	}
+	return r
+}
+
+// probeMounts reads the mount points of the probe's mount namespace.
+func probeMounts() map[string]string {
+	mounts := make(map[string]string)
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return mounts
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		before, after, ok := strings.Cut(scanner.Text(), " - ")
+		fields, fsFields := strings.Fields(before), strings.Fields(after)
+		if !ok || len(fields) < 5 || len(fsFields) < 1 {
+			continue
+		}
+		mounts[fields[4]] = fsFields[0]
+	}
+	return mounts
+}
+
+// probeCgroups reads the limits of the probe's cgroup as it is mounted
+// in the container: on v1 a hierarchy per controller, each with the
+// container's cgroup at its root, on v2 the unified hierarchy.
+func probeCgroups() probeCgroup {
+	read := func(path string) string {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(data))
+	}
+
+	if _, err := os.Stat("/sys/fs/cgroup/memory"); err == nil {
+		c := probeCgroup{
+			Version:     1,
+			MemoryLimit: read("/sys/fs/cgroup/memory/memory.limit_in_bytes"),
+			PidsMax:     read("/sys/fs/cgroup/pids/pids.max"),
+		}
+		if list := read("/sys/fs/cgroup/devices/devices.list"); list != "" {
+			c.DevicesList = strings.Split(list, "\n")
+		}
+		return c
+	}
+
+	// Without a cgroup namespace the mount shows the whole hierarchy
+	dir := "/sys/fs/cgroup"
+	if data, err := os.ReadFile("/proc/self/cgroup"); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if path, ok := strings.CutPrefix(line, "0::"); ok {
+				if _, err := os.Stat(filepath.Join(dir, path, "memory.max")); err == nil {
+					dir = filepath.Join(dir, path)
+				}
+			}
+		}
+	}
+	return probeCgroup{
+		Version:     2,
+		MemoryLimit: read(filepath.Join(dir, "memory.max")),
+		PidsMax:     read(filepath.Join(dir, "pids.max")),
+	}
+}
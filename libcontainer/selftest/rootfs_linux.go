@@ -0,0 +1,144 @@
+package selftest
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// probePath is where the runtime binary is copied in the rootfs.
+const probePath = "/hackontainer"
+
+// libraryDirs are searched, in order, for the shared libraries of a
+// dynamically linked runtime binary.
+var libraryDirs = []string{
+	"/lib64", "/usr/lib64", "/lib", "/usr/lib",
+	"/lib/x86_64-linux-gnu", "/usr/lib/x86_64-linux-gnu",
+	"/lib/aarch64-linux-gnu", "/usr/lib/aarch64-linux-gnu",
+}
+
+// buildRootfs fills rootfs with the runtime binary and, when it is
+// dynamically linked, its loader and libraries at the paths they have
+// on the host. It returns the directories the libraries went to, for
+// LD_LIBRARY_PATH.
+func buildRootfs(rootfs string) ([]string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the runtime binary: %w", err)
+	}
+	for _, dir := range []string{"proc", "dev", "sys", "tmp", "selftest"} {
+		if err := os.MkdirAll(filepath.Join(rootfs, dir), 0755); err != nil {
+			return nil, err
+		}
+	}
+	if err := copyFile(exe, filepath.Join(rootfs, probePath)); err != nil {
+		return nil, fmt.Errorf("failed to copy the runtime binary: %w", err)
+	}
+
+	interp, needed, err := dynamicDeps(exe)
+	if err != nil {
+		return nil, err
+	}
+	if interp == "" {
+		return nil, nil
+	}
+	if err := copyFile(interp, filepath.Join(rootfs, interp)); err != nil {
+		return nil, fmt.Errorf("failed to copy the dynamic loader: %w", err)
+	}
+
+	var dirs []string
+	seen := make(map[string]bool)
+	for len(needed) > 0 {
+		lib := needed[0]
+		needed = needed[1:]
+		if seen[lib] {
+			continue
+		}
+		seen[lib] = true
+
+		path, err := findLibrary(lib)
+		if err != nil {
+			return nil, err
+		}
+		if err := copyFile(path, filepath.Join(rootfs, path)); err != nil {
+			return nil, fmt.Errorf("failed to copy %s: %w", lib, err)
+		}
+		if dir := filepath.Dir(path); !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+		_, more, err := dynamicDeps(path)
+		if err != nil {
+			return nil, err
+		}
+		needed = append(needed, more...)
+	}
+	return dirs, nil
+}
+
+// dynamicDeps returns the loader and the libraries an ELF file needs.
+func dynamicDeps(path string) (string, []string, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var interp string
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_INTERP {
+			continue
+		}
+		data, err := io.ReadAll(prog.Open())
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read the loader of %s: %w", path, err)
+		}
+		interp = string(bytes.TrimRight(data, "\x00"))
+	}
+	needed, err := f.ImportedLibraries()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read the libraries of %s: %w", path, err)
+	}
+	return interp, needed, nil
+}
+
+// findLibrary looks a shared library up in libraryDirs.
+func findLibrary(name string) (string, error) {
+	for _, dir := range libraryDirs {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("library %s not found in %s", name, strings.Join(libraryDirs, ", "))
+}
+
+// copyFile copies the file at src, following symlinks, to dst with
+// its permissions, making the directories above dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,620 @@
+// Package selftest checks that the runtime works on the current host: it
+// runs a container through its whole lifecycle and has the runtime
+// binary itself, copied into the container's rootfs, report from inside
+// whether the namespaces, mounts, devices and cgroup limits took effect.
+package selftest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/zakarynichols/hackontainer/config"
+	"github.com/zakarynichols/hackontainer/libcontainer"
+	"github.com/zakarynichols/hackontainer/libcontainer/specconv"
+	"golang.org/x/sys/unix"
+)
+
+// Verdicts of a check.
+const (
+	Pass = "PASS"
+	Fail = "FAIL"
+	Skip = "SKIP"
+)
+
+const (
+	// hostname is the container's hostname, which the probe reports back.
+	hostname = "hackontainer-self-test"
+	// memoryLimit and pidsLimit are the cgroup limits the probe reads back.
+	memoryLimit = 64 << 20
+	pidsLimit   = 64
+	// reportDir is where the out directory is mounted in the container.
+	reportDir = "/selftest"
+)
+
+// stepTimeout bounds each wait of the self-test: for start, for the
+// probe's report and for the container to stop.
+const stepTimeout = 30 * time.Second
+
+// Options configure a self-test.
+type Options struct {
+	// Dir is where the self-test makes its temporary directory, holding
+	// the bundle and the state root. Empty means os.TempDir().
+	Dir string
+	// Rootless is the runtime's rootless mode. A rootless self-test runs
+	// the container without cgroups, in a user namespace unless run as
+	// root, and skips the checks that need either.
+	Rootless libcontainer.RootlessMode
+	// Out receives the report, one line per check.
+	Out io.Writer
+}
+
+// Summary counts the checks of a self-test by verdict.
+type Summary struct {
+	Pass int
+	Fail int
+	Skip int
+}
+
+// Run runs the self-test, writing a PASS, FAIL or SKIP line per check to
+// opts.Out and a summary at the end. Everything it set up is removed
+// before it returns, whatever failed. It returns an error only when it
+// couldn't run at all; failed checks are counted in the summary.
+func Run(opts Options) (*Summary, error) {
+	if opts.Out == nil {
+		opts.Out = io.Discard
+	}
+	if opts.Rootless == "" {
+		opts.Rootless = libcontainer.RootlessAuto
+	}
+	rootless := opts.Rootless == libcontainer.RootlessTrue
+	if opts.Rootless == libcontainer.RootlessAuto {
+		rootless = len(libcontainer.MissingPrivileges()) > 0
+	}
+
+	dir, err := os.MkdirTemp(opts.Dir, "hackontainer-self-test-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to make the self-test directory: %w", err)
+	}
+	t := &selfTest{
+		out:      opts.Out,
+		rootless: rootless,
+		userns:   rootless && os.Geteuid() != 0,
+		dir:      dir,
+		root:     filepath.Join(dir, "root"),
+		bundle:   filepath.Join(dir, "bundle"),
+		reports:  filepath.Join(dir, "reports"),
+	}
+	mode := "rootful"
+	if rootless {
+		mode = "rootless, with reduced checks"
+	}
+	fmt.Fprintf(t.out, "self-test in %s (%s)\n", dir, mode)
+
+	t.run(opts.Rootless)
+
+	fmt.Fprintf(t.out, "%d PASS, %d FAIL, %d SKIP\n", t.summary.Pass, t.summary.Fail, t.summary.Skip)
+	return &t.summary, nil
+}
+
+// selfTest is the state of one run.
+type selfTest struct {
+	out      io.Writer
+	summary  Summary
+	rootless bool
+	// userns runs the container in a user namespace.
+	userns bool
+
+	dir     string
+	root    string
+	bundle  string
+	reports string
+
+	id        string
+	spec      *specs.Spec
+	libDirs   []string
+	factory   libcontainer.Factory
+	container libcontainer.Container
+	// cgroupPath is the container's cgroup, to check it is removed.
+	cgroupPath string
+
+	hostNS map[string]string
+	init   *probeReport
+}
+
+// run runs the checks in order, each after the ones it depends on.
+func (t *selfTest) run(rootlessMode libcontainer.RootlessMode) {
+	// Cleanup is a check of its own, so it goes last whatever happens
+	defer t.check("cleanup", true, "", t.cleanup)
+
+	ok := t.check("setup", true, "", func() error { return t.setup(rootlessMode) })
+	ok = t.check("create", ok, "setup failed", t.create)
+	started := t.check("start", ok, "create failed", t.start)
+
+	t.check("namespaces", started, "start failed", t.checkNamespaces)
+	t.check("hostname", started, "start failed", t.checkHostname)
+	t.check("mounts", started, "start failed", t.checkMounts)
+	t.check("devices", started, "start failed", t.checkDevices)
+	t.check("masked and read-only paths", started, "start failed", t.checkPaths)
+	if t.rootless {
+		t.check("cgroup limits", false, "rootless: the container has no cgroup", nil)
+	} else {
+		t.check("cgroup limits", started, "start failed", t.checkCgroup)
+	}
+	if t.userns {
+		t.check("exec", false, "rootless: joining the container's namespaces needs root", nil)
+	} else {
+		t.check("exec", started, "start failed", t.checkExec)
+	}
+
+	stopped := t.check("kill", started, "start failed", t.kill)
+	t.check("delete", stopped, "kill failed", t.delete)
+}
+
+// check runs fn as the check name and reports its verdict, or skips it
+// with why when ok is false. It reports whether the check passed.
+func (t *selfTest) check(name string, ok bool, why string, fn func() error) bool {
+	if !ok {
+		t.summary.Skip++
+		fmt.Fprintf(t.out, "%-5s %s\n      %s\n", Skip, name, why)
+		return false
+	}
+	if err := fn(); err != nil {
+		t.summary.Fail++
+		fmt.Fprintf(t.out, "%-5s %s\n      error: %v\n", Fail, name, err)
+		return false
+	}
+	t.summary.Pass++
+	fmt.Fprintf(t.out, "%-5s %s\n", Pass, name)
+	return true
+}
+
+// setup writes the bundle and opens a factory on a state root of the
+// self-test's own.
+func (t *selfTest) setup(rootlessMode libcontainer.RootlessMode) error {
+	for _, dir := range []string{t.root, t.reports} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+	libDirs, err := buildRootfs(filepath.Join(t.bundle, "rootfs"))
+	if err != nil {
+		return err
+	}
+	t.libDirs = libDirs
+
+	t.spec = t.newSpec()
+	data, err := json.MarshalIndent(t.spec, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(t.bundle, "config.json"), data, 0644); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		return err
+	}
+	t.id = "self-test-" + hex.EncodeToString(buf)
+
+	t.hostNS = make(map[string]string)
+	for _, name := range namespaceLinks {
+		if link, err := os.Readlink(filepath.Join("/proc/self/ns", name)); err == nil {
+			t.hostNS[name] = link
+		}
+	}
+
+	t.factory, err = libcontainer.New(t.root, libcontainer.WithRootless(rootlessMode))
+	return err
+}
+
+// newSpec returns the spec of the self-test container: the default one
+// running the probe, with the reports directory bound in, cgroup limits
+// to read back and, rootless, no cgroups at all.
+func (t *selfTest) newSpec() *specs.Spec {
+	spec := config.DefaultSpec()
+	spec.Hostname = hostname
+	// The runtime doesn't make the rootfs read-only, so none is asked for
+	spec.Root.Readonly = false
+
+	args := []string{probePath, probeArg,
+		"-report", filepath.Join(reportDir, "init.json"),
+		"-masked", strings.Join(spec.Linux.MaskedPaths, ":"),
+		"-readonly", strings.Join(spec.Linux.ReadonlyPaths, ":"),
+		"-wait",
+	}
+	spec.Process.Terminal = false
+	spec.Process.Args = args
+	spec.Process.Env = append(spec.Process.Env, t.env()...)
+
+	spec.Mounts = append(spec.Mounts, specs.Mount{
+		Destination: reportDir,
+		Type:        "bind",
+		Source:      t.reports,
+		Options:     []string{"rbind", "rw"},
+	})
+
+	if t.rootless {
+		spec.Linux.Resources = nil
+	} else {
+		spec.Mounts = append(spec.Mounts, specs.Mount{
+			Destination: "/sys/fs/cgroup",
+			Type:        "cgroup",
+			Source:      "cgroup",
+			Options:     []string{"nosuid", "noexec", "nodev", "relatime", "ro"},
+		})
+		memory, pids := int64(memoryLimit), int64(pidsLimit)
+		spec.Linux.Resources.Memory = &specs.LinuxMemory{Limit: &memory}
+		spec.Linux.Resources.Pids = &specs.LinuxPids{Limit: &pids}
+	}
+
+	if t.userns {
+		spec.Linux.Namespaces = append(spec.Linux.Namespaces, specs.LinuxNamespace{Type: specs.UserNamespace})
+		spec.Linux.UIDMappings = []specs.LinuxIDMapping{{ContainerID: 0, HostID: uint32(os.Geteuid()), Size: 1}}
+		spec.Linux.GIDMappings = []specs.LinuxIDMapping{{ContainerID: 0, HostID: uint32(os.Getegid()), Size: 1}}
+		// The tty group isn't mapped
+		for i, mnt := range spec.Mounts {
+			if mnt.Type == "devpts" {
+				spec.Mounts[i].Options = slices.DeleteFunc(slices.Clone(mnt.Options), func(o string) bool {
+					return o == "gid=5"
+				})
+			}
+		}
+	}
+	return spec
+}
+
+// env is what the probe needs in its environment to run in the rootfs.
+func (t *selfTest) env() []string {
+	if len(t.libDirs) == 0 {
+		return nil
+	}
+	return []string{"LD_LIBRARY_PATH=" + strings.Join(t.libDirs, ":")}
+}
+
+func (t *selfTest) create() error {
+	var err error
+	t.container, err = t.factory.Create(t.id, t.bundle)
+	if err != nil {
+		return err
+	}
+	status, err := t.container.Status()
+	if err != nil {
+		return err
+	}
+	if status != libcontainer.Created {
+		return fmt.Errorf("container is %s after create, not created", status)
+	}
+	return nil
+}
+
+// start starts the container and waits for the probe's report.
+func (t *selfTest) start() error {
+	ctx, cancel := context.WithTimeout(context.Background(), stepTimeout)
+	defer cancel()
+	if err := t.container.StartContext(ctx); err != nil {
+		return err
+	}
+	state, err := t.container.State()
+	if err != nil {
+		return err
+	}
+	t.cgroupPath = state.CgroupPath
+	if state.Status != libcontainer.Running || state.Pid <= 0 {
+		return fmt.Errorf("container is %s with pid %d after start", state.Status, state.Pid)
+	}
+
+	report, err := t.waitReport(filepath.Join(t.reports, "init.json"))
+	if err != nil {
+		return err
+	}
+	t.init = report
+	return nil
+}
+
+// waitReport waits for the probe to write the report at path, as long
+// as the container is running.
+func (t *selfTest) waitReport(path string) (*probeReport, error) {
+	deadline := time.Now().Add(stepTimeout)
+	for {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			var report probeReport
+			if err := json.Unmarshal(data, &report); err != nil {
+				return nil, fmt.Errorf("bad report from the container: %w", err)
+			}
+			return &report, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if status, err := t.container.Status(); err != nil || status != libcontainer.Running {
+			return nil, fmt.Errorf("container is %s without having reported", status)
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("no report from the container within %s", stepTimeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// checkNamespaces checks the container has its own namespace for each
+// one in the spec, and is pid 1 in its pid namespace.
+func (t *selfTest) checkNamespaces() error {
+	var problems []string
+	for _, ns := range t.spec.Linux.Namespaces {
+		name := nsLinkName(ns.Type)
+		got, host := t.init.Namespaces[name], t.hostNS[name]
+		switch {
+		case got == "":
+			problems = append(problems, fmt.Sprintf("%s namespace not reported", name))
+		case got == host:
+			problems = append(problems, fmt.Sprintf("%s namespace is the host's (%s)", name, got))
+		}
+	}
+	if t.init.Pid != 1 {
+		problems = append(problems, fmt.Sprintf("process is pid %d in its pid namespace, not 1", t.init.Pid))
+	}
+	if t.init.UID != 0 {
+		problems = append(problems, fmt.Sprintf("process runs as uid %d, not 0", t.init.UID))
+	}
+	return joinProblems(problems)
+}
+
+// nsLinkName is the name in /proc/<pid>/ns of a namespace type.
+func nsLinkName(nsType specs.LinuxNamespaceType) string {
+	switch nsType {
+	case specs.NetworkNamespace:
+		return "net"
+	case specs.MountNamespace:
+		return "mnt"
+	}
+	return string(nsType)
+}
+
+func (t *selfTest) checkHostname() error {
+	if t.init.Hostname != hostname {
+		return fmt.Errorf("hostname is %q, not %q", t.init.Hostname, hostname)
+	}
+	return nil
+}
+
+// checkMounts checks the kernel filesystems are mounted where the spec
+// says.
+func (t *selfTest) checkMounts() error {
+	var problems []string
+	for _, mnt := range t.spec.Mounts {
+		want := mnt.Type
+		if want == "bind" || want == "cgroup" {
+			continue
+		}
+		if got := t.init.Mounts[mnt.Destination]; got != want {
+			problems = append(problems, fmt.Sprintf("%s is %q, not %s", mnt.Destination, got, want))
+		}
+	}
+	if _, ok := t.init.Mounts[reportDir]; !ok {
+		problems = append(problems, fmt.Sprintf("bind mount %s missing", reportDir))
+	}
+	return joinProblems(problems)
+}
+
+// checkDevices checks /dev holds the spec's device nodes and nothing
+// else, and that the device cgroup doesn't allow everything.
+func (t *selfTest) checkDevices() error {
+	var problems []string
+	want := make(map[string]specs.LinuxDevice)
+	for _, dev := range specconv.Devices(t.spec) {
+		want[dev.Path] = dev
+	}
+	found := make(map[string]bool)
+	for _, dev := range t.init.Devices {
+		found[dev.Path] = true
+		spec, ok := want[dev.Path]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("unexpected device %s (%s %d:%d)", dev.Path, dev.Type, dev.Major, dev.Minor))
+		case spec.Type != dev.Type || uint32(spec.Major) != dev.Major || uint32(spec.Minor) != dev.Minor:
+			problems = append(problems, fmt.Sprintf("%s is %s %d:%d, not %s %d:%d", dev.Path, dev.Type, dev.Major, dev.Minor, spec.Type, spec.Major, spec.Minor))
+		}
+	}
+	for path := range want {
+		if !found[path] {
+			problems = append(problems, fmt.Sprintf("device %s missing", path))
+		}
+	}
+	if !t.init.NullWritable {
+		problems = append(problems, "/dev/null can't be opened for writing")
+	}
+	if slices.Contains(t.init.Cgroup.DevicesList, "a *:* rwm") {
+		problems = append(problems, "the device cgroup allows every device")
+	}
+	return joinProblems(problems)
+}
+
+// checkPaths checks the masked paths show nothing and the read-only
+// paths are read-only. Paths the host kernel doesn't have are ignored.
+func (t *selfTest) checkPaths() error {
+	var problems []string
+	for path, seen := range t.init.Masked {
+		if seen != "" {
+			problems = append(problems, fmt.Sprintf("masked path %s %s", path, seen))
+		}
+	}
+	for path, readonly := range t.init.Readonly {
+		if !readonly {
+			problems = append(problems, fmt.Sprintf("read-only path %s is writable", path))
+		}
+	}
+	if len(t.init.Masked)+len(t.init.Readonly) == 0 {
+		problems = append(problems, "none of the masked and read-only paths exist")
+	}
+	return joinProblems(problems)
+}
+
+// checkCgroup checks the container reads its limits back from its own
+// cgroup.
+func (t *selfTest) checkCgroup() error {
+	var problems []string
+	cg := t.init.Cgroup
+	if cg.MemoryLimit != strconv.Itoa(memoryLimit) {
+		problems = append(problems, fmt.Sprintf("memory limit is %q, not %d", cg.MemoryLimit, memoryLimit))
+	}
+	if cg.PidsMax != strconv.Itoa(pidsLimit) {
+		problems = append(problems, fmt.Sprintf("pids limit is %q, not %d", cg.PidsMax, pidsLimit))
+	}
+	return joinProblems(problems)
+}
+
+// checkExec runs the probe again in the container's namespaces and
+// checks it lands in the same ones as the container process. The probe
+// isn't in the container's pid namespace, so where it can't read its
+// namespace links it is checked by what they hold: the hostname, the
+// network interfaces and the mount it writes its report to.
+func (t *selfTest) checkExec() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, probeArg, "-report", filepath.Join(reportDir, "exec.json"))
+	cmd.Env = t.env()
+	nsTypes := []libcontainer.NamespaceType{specs.MountNamespace, specs.UTSNamespace, specs.IPCNamespace, specs.NetworkNamespace}
+	if err := t.container.RunInNamespaces(nsTypes, cmd); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return err
+	}
+	got, err := t.waitReport(filepath.Join(t.reports, "exec.json"))
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	for _, nsType := range nsTypes {
+		name := nsLinkName(nsType)
+		if link := got.Namespaces[name]; link != "" && link != t.init.Namespaces[name] {
+			problems = append(problems, fmt.Sprintf("%s namespace is %s, not the container's %s", name, link, t.init.Namespaces[name]))
+		}
+	}
+	if got.Hostname != hostname {
+		problems = append(problems, fmt.Sprintf("hostname is %q, not %q", got.Hostname, hostname))
+	}
+	if !slices.Equal(got.Interfaces, t.init.Interfaces) {
+		problems = append(problems, fmt.Sprintf("network interfaces are %v, not the container's %v", got.Interfaces, t.init.Interfaces))
+	}
+	return joinProblems(problems)
+}
+
+// kill sends the container SIGTERM and waits for it to stop with the
+// status the probe exits with.
+func (t *selfTest) kill() error {
+	if err := t.container.Signal(unix.SIGTERM, false); err != nil {
+		return err
+	}
+	state, err := t.waitStopped()
+	if err != nil {
+		return err
+	}
+	if state.ExitStatus == nil {
+		return fmt.Errorf("no exit status recorded")
+	}
+	if *state.ExitStatus != probeExitSignaled {
+		return fmt.Errorf("exit status is %d, not %d", *state.ExitStatus, probeExitSignaled)
+	}
+	return nil
+}
+
+// waitStopped waits for the container to stop.
+func (t *selfTest) waitStopped() (*libcontainer.State, error) {
+	deadline := time.Now().Add(stepTimeout)
+	for {
+		state, err := t.container.State()
+		if err != nil {
+			return nil, err
+		}
+		if state.Status == libcontainer.Stopped {
+			return state, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("container still %s %s after it was killed", state.Status, stepTimeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// delete deletes the container and checks nothing of it is left in the
+// state root.
+func (t *selfTest) delete() error {
+	if err := t.container.Delete(); err != nil {
+		return err
+	}
+	t.container = nil
+	if _, err := t.factory.Load(t.id); err == nil {
+		return fmt.Errorf("container can still be loaded after delete")
+	}
+	entries, err := os.ReadDir(t.root)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), t.id) {
+			return fmt.Errorf("delete left %s in the state root", entry.Name())
+		}
+	}
+	return nil
+}
+
+// cleanup removes whatever is left of the container and the self-test
+// directory, and checks the container's cgroup went with it.
+func (t *selfTest) cleanup() error {
+	var problems []string
+	if t.container != nil {
+		// Delete takes a created container as it is
+		if status, err := t.container.Status(); err == nil && status != libcontainer.Stopped && status != libcontainer.Created {
+			_ = t.container.Signal(unix.SIGKILL, false)
+			if _, err := t.waitStopped(); err != nil {
+				problems = append(problems, err.Error())
+			}
+		}
+		if err := t.container.Delete(); err != nil {
+			problems = append(problems, fmt.Sprintf("failed to delete the container: %v", err))
+		}
+	}
+	if err := os.RemoveAll(t.dir); err != nil {
+		problems = append(problems, fmt.Sprintf("failed to remove %s: %v", t.dir, err))
+	}
+	if _, err := os.Stat(t.dir); err == nil {
+		problems = append(problems, fmt.Sprintf("%s is still there", t.dir))
+	}
+	if t.cgroupPath != "" {
+		leftover, _ := filepath.Glob(filepath.Join("/sys/fs/cgroup", "*", t.cgroupPath))
+		if _, err := os.Stat(filepath.Join("/sys/fs/cgroup", t.cgroupPath)); err == nil {
+			leftover = append(leftover, filepath.Join("/sys/fs/cgroup", t.cgroupPath))
+		}
+		for _, path := range leftover {
+			problems = append(problems, fmt.Sprintf("cgroup %s is still there", path))
+		}
+	}
+	return joinProblems(problems)
+}
+
+func joinProblems(problems []string) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(problems, "; "))
+}
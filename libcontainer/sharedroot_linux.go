@@ -0,0 +1,106 @@
+package libcontainer
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/zakarynichols/hackontainer/api/types"
+	"golang.org/x/sys/unix"
+)
+
+// Owner is the host a container on a shared root belongs to.
+type Owner = types.Owner
+
+// Files in the container root of a container on a shared root.
+const (
+	// sharedRootFilename marks a container created with a shared root,
+	// which every runtime touching it locks with a lock file.
+	sharedRootFilename = "shared-root"
+)
+
+// networkFilesystems names the filesystems, by statfs magic, whose locks
+// can't be trusted and whose files other hosts may share.
+var networkFilesystems = map[int64]string{
+	unix.NFS_SUPER_MAGIC:  "nfs",
+	unix.SMB_SUPER_MAGIC:  "smb",
+	unix.SMB2_SUPER_MAGIC: "smb2",
+	unix.CIFS_SUPER_MAGIC: "cifs",
+	unix.CEPH_SUPER_MAGIC: "ceph",
+	unix.AFS_SUPER_MAGIC:  "afs",
+	unix.CODA_SUPER_MAGIC: "coda",
+	unix.V9FS_MAGIC:       "9p",
+}
+
+// WithSharedRoot lets the factory use a root on a network filesystem,
+// which New refuses otherwise, and marks the containers it creates as
+// on a shared root: they are locked with lock files instead of flock,
+// and stamped with the host that owns them, which alone may act on
+// their processes. It may be given for a root New can't tell is shared.
+func WithSharedRoot() CreateOption {
+	return func(l *LinuxFactory) error {
+		l.sharedRoot = true
+		return nil
+	}
+}
+
+// networkFilesystem returns the name of the network filesystem path is
+// on, or "" if it is on none.
+func networkFilesystem(path string) (string, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return "", fmt.Errorf("failed to statfs %s: %w", path, err)
+	}
+	return networkFilesystems[int64(st.Type)], nil
+}
+
+// checkSharedRoot refuses a root on a network filesystem unless the
+// factory was told it is shared.
+func (l *LinuxFactory) checkSharedRoot() error {
+	fs, err := networkFilesystem(l.root)
+	if err != nil || fs == "" || l.sharedRoot {
+		return err
+	}
+	return newTypedError(ErrSharedRoot, "root %s is on %s, where locks may not hold and other hosts' pids would be signalled; use --allow-shared-root to manage containers there with lock files and host ownership", l.root, fs)
+}
+
+// currentOwner is the host the runtime runs on.
+func currentOwner() *Owner {
+	hostname, _ := os.Hostname()
+	return &Owner{Hostname: hostname, BootID: bootID()}
+}
+
+// foreignOwner reports whether state belongs to a container owned by
+// another host. A container owned by an earlier boot of this one is
+// still ours, for checkState to find stopped.
+func foreignOwner(state *State) bool {
+	if state.Owner == nil {
+		return false
+	}
+	hostname, _ := os.Hostname()
+	return state.Owner.Hostname != hostname
+}
+
+// ownerError is the error for acting on a container another host owns.
+func ownerError(state *State) error {
+	return newTypedError(ErrNotOwner, "container %q is owned by host %s; manage it from there", state.ID, state.Owner.Hostname)
+}
+
+// lockShared is lock for a container on a shared root: the lock is a
+// lock file, which holds where flock may not. It fails once the
+// container is gone, like lock, and for a container another host owns.
+func (c *linuxContainer) lockShared() (func(), error) {
+	unlock, err := fileLocker{}.lockDir(c.root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to lock container: %w", err)
+	}
+
+	state, err := c.loadState()
+	if err == nil && foreignOwner(state) {
+		unlock()
+		return nil, ownerError(state)
+	}
+	return unlock, nil
+}
@@ -0,0 +1,159 @@
+package libcontainer
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// The real-time signals, numbered as the C library numbers them: it
+// keeps the kernel's first two for itself.
+const (
+	sigRTMin = 34
+	sigRTMax = 64
+)
+
+// signalAliases are the other names of signals, which unix.SignalNum
+// only knows one of.
+var signalAliases = map[string]unix.Signal{
+	"SIGIOT":    unix.SIGABRT,
+	"SIGCLD":    unix.SIGCHLD,
+	"SIGPOLL":   unix.SIGIO,
+	"SIGUNUSED": unix.SIGSYS,
+}
+
+// harmlessSignals are those whose default action leaves a process
+// alive: they are ignored, or stop or continue it.
+var harmlessSignals = map[unix.Signal]bool{
+	unix.SIGCHLD:  true,
+	unix.SIGCONT:  true,
+	unix.SIGURG:   true,
+	unix.SIGWINCH: true,
+	unix.SIGSTOP:  true,
+	unix.SIGTSTP:  true,
+	unix.SIGTTIN:  true,
+	unix.SIGTTOU:  true,
+}
+
+// catchableTerminatingSignals are the signals that end a process by
+// default and can be handled: all but SIGKILL and the harmless ones,
+// skipping the two the C library keeps.
+func catchableTerminatingSignals() []os.Signal {
+	var signals []os.Signal
+	for n := 1; n <= sigRTMax; n++ {
+		sig := unix.Signal(n)
+		if sig == unix.SIGKILL || (n > 31 && n < sigRTMin) || harmlessSignals[sig] {
+			continue
+		}
+		signals = append(signals, sig)
+	}
+	return signals
+}
+
+// ParseSignal parses a signal as kill(1) takes it: a name with or
+// without SIG, in any case, SIGRTMIN+n or SIGRTMAX-n for a real-time
+// signal, or a number from 1 to 64.
+func ParseSignal(raw string) (unix.Signal, error) {
+	if n, err := strconv.Atoi(raw); err == nil {
+		if n < 1 || n > sigRTMax {
+			return 0, fmt.Errorf("invalid signal %d: signals are numbered 1 to %d", n, sigRTMax)
+		}
+		return unix.Signal(n), nil
+	}
+
+	name := strings.ToUpper(raw)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	if sig, ok := parseRTSignal(name); ok {
+		return sig, nil
+	}
+	if sig, ok := signalAliases[name]; ok {
+		return sig, nil
+	}
+	if sig := unix.SignalNum(name); sig != 0 {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("unknown signal %q: want a name such as TERM or SIGTERM, SIGRTMIN+n or SIGRTMAX-n, or a number from 1 to %d", raw, sigRTMax)
+}
+
+// parseRTSignal parses SIGRTMIN, SIGRTMAX, SIGRTMIN+n and SIGRTMAX-n,
+// with n up to the number of real-time signals less one.
+func parseRTSignal(name string) (unix.Signal, bool) {
+	base, sign, rest := 0, 1, ""
+	switch {
+	case strings.HasPrefix(name, "SIGRTMIN"):
+		base, rest = sigRTMin, strings.TrimPrefix(name, "SIGRTMIN")
+		if rest != "" && rest[0] != '+' {
+			return 0, false
+		}
+	case strings.HasPrefix(name, "SIGRTMAX"):
+		base, sign, rest = sigRTMax, -1, strings.TrimPrefix(name, "SIGRTMAX")
+		if rest != "" && rest[0] != '-' {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+	if rest == "" {
+		return unix.Signal(base), true
+	}
+	offset, err := strconv.ParseUint(rest[1:], 10, 8)
+	if err != nil || offset > sigRTMax-sigRTMin {
+		return 0, false
+	}
+	return unix.Signal(base + sign*int(offset)), true
+}
+
+// forwardedSignals are the signals a foreground run passes on to the
+// container instead of dying of them: those a terminal, a supervisor or
+// a user sends to stop or poke the process in front of them. SIGWINCH
+// is the console's, which resizes the pty the container has.
+var forwardedSignals = []os.Signal{unix.SIGINT, unix.SIGTERM, unix.SIGQUIT, unix.SIGHUP, unix.SIGUSR1, unix.SIGUSR2}
+
+// WithForwardToAll has a foreground Run forward the signals it gets to
+// every process of the container, as kill --all sends them, rather than
+// to the container process alone.
+func WithForwardToAll() CreateOption {
+	return func(l *LinuxFactory) error {
+		l.forwardToAll = true
+		return nil
+	}
+}
+
+// forwardSignals passes forwardedSignals on to the container until the
+// returned function is called. They go to the container process as
+// state records it, so to each restart of it in turn, and only while it
+// runs: one that has exited leaves its exit status to be reported.
+func (c *linuxContainer) forwardSignals() func() {
+	signals := make(chan os.Signal, 16)
+	signal.Notify(signals, forwardedSignals...)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case sig := <-signals:
+				state, err := c.loadState()
+				if err != nil || state.Pid == 0 || (state.Status != Running && state.Status != Paused) {
+					continue
+				}
+				if err := c.sendSignal(state, sig.(unix.Signal), c.forwardToAll); err != nil && err != unix.ESRCH {
+					fmt.Fprintf(os.Stderr, "WARNING: failed to forward %v to container %s: %v\n", sig, c.id, err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+		<-stopped
+	}
+}
@@ -0,0 +1,104 @@
+package specconv
+
+import (
+	"os"
+	"path/filepath"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func int64Ptr(v int64) *int64 { return &v }
+
+func fileMode(m os.FileMode) *os.FileMode { return &m }
+
+// DefaultDevices are created whenever the container has a /dev of its
+// own.
+var DefaultDevices = []specs.LinuxDevice{
+	{Path: "/dev/null", Type: "c", Major: 1, Minor: 3, FileMode: fileMode(0666)},
+	{Path: "/dev/zero", Type: "c", Major: 1, Minor: 5, FileMode: fileMode(0666)},
+	{Path: "/dev/full", Type: "c", Major: 1, Minor: 7, FileMode: fileMode(0666)},
+	{Path: "/dev/random", Type: "c", Major: 1, Minor: 8, FileMode: fileMode(0666)},
+	{Path: "/dev/urandom", Type: "c", Major: 1, Minor: 9, FileMode: fileMode(0666)},
+	{Path: "/dev/tty", Type: "c", Major: 5, Minor: 0, FileMode: fileMode(0666)},
+}
+
+// defaultDeviceRules allow the pty devices next to the default devices.
+var defaultDeviceRules = []specs.LinuxDeviceCgroup{
+	{Allow: true, Type: "c", Major: int64Ptr(5), Minor: int64Ptr(2), Access: "rwm"},
+	{Allow: true, Type: "c", Major: int64Ptr(136), Minor: nil, Access: "rwm"},
+}
+
+// MountsDev reports whether the spec mounts its own /dev.
+func MountsDev(spec *specs.Spec) bool {
+	if spec == nil {
+		return false
+	}
+	for _, m := range spec.Mounts {
+		if filepath.Clean(m.Destination) == "/dev" {
+			return true
+		}
+	}
+	return false
+}
+
+// PrivateDev reports whether the container gets a /dev of its own: one
+// the spec mounts, or a tmpfs the runtime mounts to hold the spec's
+// devices. Either way the default devices are created in it.
+func PrivateDev(spec *specs.Spec) bool {
+	return MountsDev(spec) || (spec != nil && spec.Linux != nil && len(spec.Linux.Devices) > 0)
+}
+
+// Devices returns every device node to create in the rootfs.
+func Devices(spec *specs.Spec) []specs.LinuxDevice {
+	var devices []specs.LinuxDevice
+	if PrivateDev(spec) {
+		devices = append(devices, DefaultDevices...)
+	}
+	if spec != nil && spec.Linux != nil {
+		devices = append(devices, spec.Linux.Devices...)
+	}
+	return devices
+}
+
+// DeviceRules returns the device cgroup rules to apply. A spec without
+// rules leaves device access alone; otherwise every device the runtime
+// creates is allowed after the spec's own rules, so a deny-all default
+// doesn't make the nodes unusable.
+func DeviceRules(spec *specs.Spec) []specs.LinuxDeviceCgroup {
+	if spec == nil || spec.Linux == nil || spec.Linux.Resources == nil || len(spec.Linux.Resources.Devices) == 0 {
+		return nil
+	}
+
+	rules := append([]specs.LinuxDeviceCgroup{}, spec.Linux.Resources.Devices...)
+	if PrivateDev(spec) {
+		rules = append(rules, defaultDeviceRules...)
+	}
+	for _, dev := range Devices(spec) {
+		if dev.Type == "p" {
+			continue
+		}
+		devType := dev.Type
+		if devType == "u" {
+			devType = "c"
+		}
+		rules = append(rules, specs.LinuxDeviceCgroup{
+			Allow:  true,
+			Type:   devType,
+			Major:  int64Ptr(dev.Major),
+			Minor:  int64Ptr(dev.Minor),
+			Access: "rwm",
+		})
+	}
+	return rules
+}
+
+// Resources returns the spec's resources with the device rules the
+// runtime adds.
+func Resources(spec *specs.Spec) *specs.LinuxResources {
+	if spec == nil || spec.Linux == nil || spec.Linux.Resources == nil {
+		return nil
+	}
+	resources := *spec.Linux.Resources
+	resources.Devices = DeviceRules(spec)
+	return &resources
+}
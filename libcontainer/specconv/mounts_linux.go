@@ -0,0 +1,134 @@
+package specconv
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/zakarynichols/hackontainer/config"
+	"golang.org/x/sys/unix"
+)
+
+// OwnerFixupOption is a bind mount option of the runtime's own. It
+// chowns the mount's source to the container's user the first time it
+// is mounted, so that a root-owned host volume is usable by a non-root
+// process. It is never passed to the kernel.
+const OwnerFixupOption = "owner-fixup"
+
+// ReplaceSymlinkOption is a bind mount option of the runtime's own. A
+// symlink the image has at the destination is replaced by the
+// mountpoint, instead of the mountpoint being made where it points:
+// /etc/resolv.conf often links into a /run the image never populates.
+// It is never passed to the kernel.
+const ReplaceSymlinkOption = "replace-symlink"
+
+// mountFlags maps spec mount options to the flags they set or clear.
+var mountFlags = map[string]struct {
+	clear bool
+	flag  uintptr
+}{
+	"async":         {true, unix.MS_SYNCHRONOUS},
+	"atime":         {true, unix.MS_NOATIME},
+	"bind":          {false, unix.MS_BIND},
+	"defaults":      {false, 0},
+	"dev":           {true, unix.MS_NODEV},
+	"diratime":      {true, unix.MS_NODIRATIME},
+	"dirsync":       {false, unix.MS_DIRSYNC},
+	"exec":          {true, unix.MS_NOEXEC},
+	"mand":          {false, unix.MS_MANDLOCK},
+	"noatime":       {false, unix.MS_NOATIME},
+	"nodev":         {false, unix.MS_NODEV},
+	"nodiratime":    {false, unix.MS_NODIRATIME},
+	"noexec":        {false, unix.MS_NOEXEC},
+	"nomand":        {true, unix.MS_MANDLOCK},
+	"norelatime":    {true, unix.MS_RELATIME},
+	"nostrictatime": {true, unix.MS_STRICTATIME},
+	"nosuid":        {false, unix.MS_NOSUID},
+	"rbind":         {false, unix.MS_BIND | unix.MS_REC},
+	"relatime":      {false, unix.MS_RELATIME},
+	"ro":            {false, unix.MS_RDONLY},
+	"rw":            {true, unix.MS_RDONLY},
+	"strictatime":   {false, unix.MS_STRICTATIME},
+	"suid":          {true, unix.MS_NOSUID},
+	"sync":          {false, unix.MS_SYNCHRONOUS},
+}
+
+// mountPropagation maps spec mount options to propagation types, which
+// need a mount call of their own.
+var mountPropagation = map[string]uintptr{
+	"private":     unix.MS_PRIVATE,
+	"rprivate":    unix.MS_PRIVATE | unix.MS_REC,
+	"shared":      unix.MS_SHARED,
+	"rshared":     unix.MS_SHARED | unix.MS_REC,
+	"slave":       unix.MS_SLAVE,
+	"rslave":      unix.MS_SLAVE | unix.MS_REC,
+	"unbindable":  unix.MS_UNBINDABLE,
+	"runbindable": unix.MS_UNBINDABLE | unix.MS_REC,
+}
+
+// MountOptions lists the mount options the runtime understands, sorted.
+func MountOptions() []string {
+	options := []string{OwnerFixupOption, ReplaceSymlinkOption}
+	for option := range mountFlags {
+		options = append(options, option)
+	}
+	for option := range mountPropagation {
+		options = append(options, option)
+	}
+	sort.Strings(options)
+	return options
+}
+
+// Mounts resolves the spec's mounts, in spec order. Relative bind
+// sources are resolved against bundle.
+func Mounts(spec *specs.Spec, bundle string) []config.Mount {
+	if spec == nil {
+		return nil
+	}
+	var mounts []config.Mount
+	for _, mnt := range spec.Mounts {
+		mounts = append(mounts, Mount(mnt, bundle))
+	}
+	return mounts
+}
+
+// Mount resolves one spec mount.
+func Mount(mnt specs.Mount, bundle string) config.Mount {
+	m := config.Mount{
+		Destination: filepath.Clean(mnt.Destination),
+		Source:      mnt.Source,
+		Type:        mnt.Type,
+	}
+
+	var data []string
+	for _, opt := range mnt.Options {
+		switch opt {
+		case OwnerFixupOption:
+			m.OwnerFixup = true
+			continue
+		case ReplaceSymlinkOption:
+			m.ReplaceSymlink = true
+			continue
+		}
+		if f, ok := mountFlags[opt]; ok {
+			if f.clear {
+				m.Flags &^= f.flag
+				m.ClearedFlags |= f.flag
+			} else {
+				m.Flags |= f.flag
+			}
+		} else if p, ok := mountPropagation[opt]; ok {
+			m.Propagation = append(m.Propagation, p)
+		} else {
+			data = append(data, opt)
+		}
+	}
+	m.Data = strings.Join(data, ",")
+
+	m.Bind = m.Flags&unix.MS_BIND != 0 || mnt.Type == "bind"
+	if m.Bind && !filepath.IsAbs(m.Source) {
+		m.Source = filepath.Join(bundle, m.Source)
+	}
+	return m
+}
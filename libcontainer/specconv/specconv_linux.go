@@ -0,0 +1,149 @@
+// Package specconv converts an OCI runtime spec into the resolved form
+// the runtime sets a container up from. The conversion is done once, at
+// create, and frozen with the spec: the rootfs is made absolute, the
+// mounts are sorted out into mount(2) calls, the namespaces, devices and
+// cgroup settings are completed with the runtime's defaults and the
+// hooks are collected by kind.
+//
+// Convert doesn't touch the host, so it gives the same result for the
+// same spec and bundle wherever it runs.
+package specconv
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/zakarynichols/hackontainer/config"
+)
+
+// DefaultCgroupParent holds the cgroups of containers whose spec doesn't
+// set linux.cgroupsPath.
+const DefaultCgroupParent = "/hackontainer"
+
+// CgroupsAnnotation set to "none" runs the container without cgroups.
+const CgroupsAnnotation = "org.hackontainer.cgroups"
+
+// Options are what Convert needs besides the spec.
+type Options struct {
+	// Bundle is the absolute path relative paths in the spec are
+	// resolved against.
+	Bundle string
+	// CgroupName places the container's cgroup below DefaultCgroupParent
+	// when the spec doesn't set linux.cgroupsPath.
+	CgroupName string
+}
+
+// Convert resolves spec, which should have passed config.Validate.
+func Convert(spec *specs.Spec, opts Options) (*config.Resolved, error) {
+	if spec == nil || spec.Root == nil {
+		return nil, fmt.Errorf("root specification required")
+	}
+	if !filepath.IsAbs(opts.Bundle) {
+		return nil, fmt.Errorf("bundle path %q is not absolute", opts.Bundle)
+	}
+
+	rootfs := spec.Root.Path
+	if !filepath.IsAbs(rootfs) {
+		rootfs = filepath.Join(opts.Bundle, rootfs)
+	}
+
+	return &config.Resolved{
+		Rootfs:      filepath.Clean(rootfs),
+		Namespaces:  Namespaces(spec),
+		Mounts:      Mounts(spec, opts.Bundle),
+		Devices:     Devices(spec),
+		PrivateDev:  PrivateDev(spec),
+		DevTmpfs:    PrivateDev(spec) && !MountsDev(spec),
+		CgroupsPath: CgroupPath(spec, opts.CgroupName),
+		Resources:   Resources(spec),
+		Hooks:       Hooks(spec),
+	}, nil
+}
+
+// defaultNamespaces are created for a spec that lists none, so leaving
+// linux.namespaces out doesn't share the host's.
+var defaultNamespaces = []specs.LinuxNamespaceType{
+	specs.MountNamespace,
+	specs.PIDNamespace,
+	specs.UTSNamespace,
+	specs.NetworkNamespace,
+	specs.IPCNamespace,
+	specs.CgroupNamespace,
+	specs.TimeNamespace,
+}
+
+// Namespaces returns the namespaces the container is set up with, by
+// type: defaultNamespaces when the spec lists none. The rootfs is always
+// prepared in a fresh mount namespace, so one is created even when the
+// spec leaves it out.
+func Namespaces(spec *specs.Spec) []specs.LinuxNamespace {
+	var namespaces []specs.LinuxNamespace
+	if spec == nil || spec.Linux == nil || len(spec.Linux.Namespaces) == 0 {
+		for _, nsType := range defaultNamespaces {
+			namespaces = append(namespaces, specs.LinuxNamespace{Type: nsType})
+		}
+	}
+	hasMount := len(namespaces) > 0
+	if spec != nil && spec.Linux != nil {
+		for _, ns := range spec.Linux.Namespaces {
+			if ns.Type == specs.MountNamespace {
+				hasMount = true
+			}
+			namespaces = append(namespaces, ns)
+		}
+	}
+	if !hasMount {
+		namespaces = append(namespaces, specs.LinuxNamespace{Type: specs.MountNamespace})
+	}
+
+	sort.SliceStable(namespaces, func(i, j int) bool {
+		return namespaces[i].Type < namespaces[j].Type
+	})
+	return namespaces
+}
+
+// CgroupsDisabled reports whether the container runs without cgroups.
+func CgroupsDisabled(spec *specs.Spec) bool {
+	return spec != nil && spec.Annotations[CgroupsAnnotation] == "none"
+}
+
+// CgroupPath returns the path of the container's cgroup relative to the
+// root of every hierarchy, or "" if it runs without cgroups. name places
+// the cgroup below DefaultCgroupParent unless the spec sets
+// linux.cgroupsPath.
+func CgroupPath(spec *specs.Spec, name string) string {
+	if CgroupsDisabled(spec) {
+		return ""
+	}
+	if spec != nil && spec.Linux != nil && spec.Linux.CgroupsPath != "" {
+		return filepath.Join("/", spec.Linux.CgroupsPath)
+	}
+	return filepath.Join(DefaultCgroupParent, name)
+}
+
+// Hooks returns the spec's hooks by the name of their kind in the
+// spec, leaving out kinds it has none of.
+func Hooks(spec *specs.Spec) map[string][]specs.Hook {
+	if spec == nil || spec.Hooks == nil {
+		return nil
+	}
+	hooks := make(map[string][]specs.Hook)
+	for kind, list := range map[string][]specs.Hook{
+		"prestart":        spec.Hooks.Prestart,
+		"createRuntime":   spec.Hooks.CreateRuntime,
+		"createContainer": spec.Hooks.CreateContainer,
+		"startContainer":  spec.Hooks.StartContainer,
+		"poststart":       spec.Hooks.Poststart,
+		"poststop":        spec.Hooks.Poststop,
+	} {
+		if len(list) > 0 {
+			hooks[kind] = list
+		}
+	}
+	if len(hooks) == 0 {
+		return nil
+	}
+	return hooks
+}
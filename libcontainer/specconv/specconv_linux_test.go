@@ -0,0 +1,44 @@
+package specconv
+
+import (
+	"slices"
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestNamespaces(t *testing.T) {
+	all := []specs.LinuxNamespaceType{
+		specs.CgroupNamespace, specs.IPCNamespace, specs.MountNamespace, specs.NetworkNamespace,
+		specs.PIDNamespace, specs.TimeNamespace, specs.UTSNamespace,
+	}
+	tests := []struct {
+		name string
+		spec *specs.Spec
+		want []specs.LinuxNamespaceType
+	}{
+		{"nil spec", nil, all},
+		{"no linux section", &specs.Spec{}, all},
+		{"no namespaces", &specs.Spec{Linux: &specs.Linux{}}, all},
+		{"empty namespaces", &specs.Spec{Linux: &specs.Linux{Namespaces: []specs.LinuxNamespace{}}}, all},
+		{
+			name: "only those listed, and a mount namespace",
+			spec: &specs.Spec{Linux: &specs.Linux{Namespaces: []specs.LinuxNamespace{{Type: specs.PIDNamespace}}}},
+			want: []specs.LinuxNamespaceType{specs.MountNamespace, specs.PIDNamespace},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []specs.LinuxNamespaceType
+			for _, ns := range Namespaces(tt.spec) {
+				if ns.Path != "" {
+					t.Errorf("%s joins %s", ns.Type, ns.Path)
+				}
+				got = append(got, ns.Type)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package libcontainer
+
+import (
+	"context"
+	"time"
+)
+
+// shortLivedWindow is how soon after it starts a container process must
+// exit to count as exiting immediately. Start waits this long for it.
+const shortLivedWindow = 100 * time.Millisecond
+
+// StartResult is how the container process fared by the time Start or
+// Run returned.
+type StartResult struct {
+	// Pid is the container process.
+	Pid int
+	// Exited is set when the process had exited, with ExitCode. Run sets
+	// it unless the container was deleted while running.
+	Exited   bool
+	ExitCode int
+	// ShortLived is set when the process exited within shortLivedWindow
+	// of starting. Run only sets it if the process was never restarted.
+	ShortLived bool
+}
+
+// err is the error Start and Run return along with r: a short-lived
+// process that failed is an error of its own.
+func (r *StartResult) err() error {
+	if r.ShortLived && r.ExitCode != 0 {
+		return &ExitedImmediatelyError{ExitCode: r.ExitCode}
+	}
+	return nil
+}
+
+// StartWithResult is StartContext, also reporting a process that exits
+// immediately. That takes shortLivedWindow, which every start waits.
+func (c *linuxContainer) StartWithResult(ctx context.Context) (*StartResult, error) {
+	err := c.start(ctx)
+	c.audit(AuditStart, nil, err)
+	if err != nil {
+		return nil, err
+	}
+	started := time.Now()
+
+	// The monitor records the exit; a state that failed to load was
+	// deleted meanwhile
+	result := &StartResult{}
+	for {
+		state, err := c.loadState()
+		if err != nil {
+			return result, nil
+		}
+		result.Pid = state.Pid
+		if state.Status == Stopped && state.ExitStatus != nil {
+			result.Exited, result.ExitCode = true, *state.ExitStatus
+			result.ShortLived = true
+			return result, result.err()
+		}
+		if time.Since(started) >= shortLivedWindow {
+			return result, nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// RunWithResult is RunContext, also reporting how the process exited.
+func (c *linuxContainer) RunWithResult(ctx context.Context) (*StartResult, error) {
+	// A full create already has a process waiting for start
+	if state, err := c.loadState(); err == nil && createMode(state) == CreateModeFull {
+		err := newTypedError(ErrInvalidState, "cannot run a container created in %s mode; start it instead", CreateModeFull)
+		c.audit(AuditRun, nil, err)
+		return nil, err
+	}
+	if c.config.Process != nil && c.config.Process.Terminal && c.consoleSocket == "" {
+		console, err := newLocalConsole(c.config.Process.ConsoleSize)
+		if err != nil {
+			c.audit(AuditRun, nil, err)
+			return nil, err
+		}
+		// Deferred so the terminal is restored on panics too
+		defer console.Close()
+		c.console = console
+	}
+
+	c.foreground = true
+	process, err := c.startInit(ctx)
+	// Recorded once started, as the run lasts as long as the process
+	c.audit(AuditRun, nil, err)
+	if err != nil {
+		return nil, err
+	}
+	result := &StartResult{Pid: process.pid()}
+	started := time.Now()
+
+	// Signals for the run are meant for the container, which would
+	// otherwise be left running without the run
+	stopForwarding := c.forwardSignals()
+	err = c.supervise(process)
+	stopForwarding()
+	if err != nil {
+		return result, err
+	}
+	state, err := c.loadState()
+	if err != nil || state.ExitStatus == nil {
+		return result, nil
+	}
+	result.Exited, result.ExitCode = true, *state.ExitStatus
+	result.ShortLived = state.RestartCount == 0 && c.exitedAt.Sub(started) < shortLivedWindow
+	return result, result.err()
+}
@@ -0,0 +1,82 @@
+package libcontainer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zakarynichols/hackontainer/api/types"
+)
+
+// Stats is the resource usage of a container's cgroup.
+type Stats = types.Stats
+
+// finalStatsFilename holds the usage recorded when the container process
+// last exited, since the cgroup it was read from is gone by then.
+const finalStatsFilename = "final-stats.json"
+
+// Stats reads the live resource usage of a running container.
+func (c *linuxContainer) Stats() (*Stats, error) {
+	state, err := c.State()
+	if err != nil {
+		return nil, err
+	}
+	if state.Status != Running && state.Status != Paused {
+		return nil, newTypedError(ErrNotRunning, "container is %s; use the final stats of a stopped container", state.Status)
+	}
+
+	stats, err := c.cgroupManager().Stats()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cgroup stats: %w", err)
+	}
+	stats.SchemaVersion = types.SchemaVersion
+	stats.ID = c.id
+	stats.Timestamp = time.Now().UTC()
+	return stats, nil
+}
+
+// FinalStats returns the usage recorded when the container process last
+// exited.
+func (c *linuxContainer) FinalStats() (*Stats, error) {
+	data, err := os.ReadFile(filepath.Join(c.root, finalStatsFilename))
+	if os.IsNotExist(err) {
+		return nil, newTypedError(ErrNotExist, "container %s has no final stats; it has not exited yet", c.id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stats Stats
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", finalStatsFilename, err)
+	}
+	return &stats, nil
+}
+
+// saveFinalStats snapshots the cgroup's usage once the container process
+// has exited. It must run before the cgroup is destroyed: the counters
+// outlive the processes in it, but not the cgroup itself.
+func (c *linuxContainer) saveFinalStats() error {
+	stats, err := c.cgroupManager().Stats()
+	if err != nil {
+		return fmt.Errorf("failed to read final cgroup stats: %w", err)
+	}
+	stats.SchemaVersion = types.SchemaVersion
+	stats.ID = c.id
+	stats.Timestamp = time.Now().UTC()
+	stats.Final = true
+
+	data, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(c.root, finalStatsFilename)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,187 @@
+package libcontainer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zakarynichols/hackontainer/api/types"
+)
+
+// Summary is the status document Status returns.
+type Summary = types.Summary
+
+// Anomaly is something wrong with a container in a Summary.
+type Anomaly = types.Anomaly
+
+// statusWorkers is how many container directories Status checks at once.
+const statusWorkers = 16
+
+// statusDirTimeout bounds the check of one container directory, which
+// can hang on a stuck filesystem or a delegate that doesn't answer.
+const statusDirTimeout = 2 * time.Second
+
+// statusDeadline bounds a whole scan: directories not reached by then are
+// reported as timed out, unchecked.
+const statusDeadline = 10 * time.Second
+
+// dirStatus is what the check of one container directory found. A
+// directory that isn't a container has no status.
+type dirStatus struct {
+	status    Status
+	anomalies []Anomaly
+}
+
+// Status summarises the containers under the factory's root for health
+// checks. It reads their state files and nothing else, checks them in
+// parallel, and takes no longer than statusDeadline plus
+// statusDirTimeout however many there are or however they hang.
+func (l *LinuxFactory) Status() (*Summary, error) {
+	dir := l.stateRoot()
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var roots []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			roots = append(roots, filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	results := make([]*dirStatus, len(roots))
+	deadline := time.Now().Add(statusDeadline)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < statusWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if time.Now().After(deadline) {
+					results[i] = timedOut(roots[i], "not checked before the scan's deadline")
+					continue
+				}
+				results[i] = checkDirWithin(roots[i], statusDirTimeout)
+			}
+		}()
+	}
+	for i := range roots {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	summary := &Summary{SchemaVersion: types.SchemaVersion, Anomalies: []Anomaly{}}
+	for _, result := range results {
+		if result == nil {
+			continue
+		}
+		summary.Total++
+		switch result.status {
+		case Created:
+			summary.Created++
+		case Running:
+			summary.Running++
+		case Paused:
+			summary.Paused++
+		case Stopped:
+			summary.Stopped++
+		default:
+			summary.Unknown++
+		}
+		summary.Anomalies = append(summary.Anomalies, result.anomalies...)
+	}
+	sort.SliceStable(summary.Anomalies, func(i, j int) bool {
+		return summary.Anomalies[i].ID < summary.Anomalies[j].ID
+	})
+	return summary, nil
+}
+
+// checkDirWithin checks containerRoot, giving up after timeout. A check
+// that is given up on is left to finish on its own.
+func checkDirWithin(containerRoot string, timeout time.Duration) *dirStatus {
+	done := make(chan *dirStatus, 1)
+	go func() { done <- checkDir(containerRoot) }()
+	select {
+	case result := <-done:
+		return result
+	case <-time.After(timeout):
+		return timedOut(containerRoot, fmt.Sprintf("not checked within %s", timeout))
+	}
+}
+
+func timedOut(containerRoot, detail string) *dirStatus {
+	return &dirStatus{anomalies: []Anomaly{{
+		ID: filepath.Base(containerRoot), Kind: types.AnomalyTimeout, Detail: detail,
+	}}}
+}
+
+// checkDir reads the state of the container in containerRoot and
+// compares it with what is left of its processes, returning nil if the
+// directory holds no container.
+func checkDir(containerRoot string) *dirStatus {
+	id := filepath.Base(containerRoot)
+	anomaly := func(kind types.AnomalyKind, format string, args ...interface{}) *dirStatus {
+		return &dirStatus{anomalies: []Anomaly{{ID: id, Kind: kind, Detail: fmt.Sprintf(format, args...)}}}
+	}
+
+	delegation, err := loadDelegation(containerRoot)
+	if err != nil {
+		return anomaly(types.AnomalyCorruptState, "%v", err)
+	}
+	if delegation != nil {
+		state, err := delegation.State()
+		if err != nil {
+			return anomaly(types.AnomalyCorruptState, "%v", err)
+		}
+		return withBundleCheck(&dirStatus{status: state.Status}, id, state.Bundle)
+	}
+
+	// The same tell List uses for tenant namespaces
+	if !fileExists(filepath.Join(containerRoot, stateFilename)) {
+		if !fileExists(filepath.Join(containerRoot, layoutFilename)) {
+			return nil
+		}
+		op := "create"
+		if fileExists(filepath.Join(containerRoot, deletingFilename)) {
+			op = "delete"
+		}
+		return anomaly(types.AnomalyIncomplete, "no state: its %s is unfinished", op)
+	}
+
+	c := &linuxContainer{id: id, root: containerRoot}
+	recorded, err := c.loadState()
+	if err != nil {
+		return anomaly(types.AnomalyCorruptState, "%s: %v", stateFilename, err)
+	}
+	live := *recorded
+	c.checkState(&live)
+
+	result := &dirStatus{status: live.Status}
+	alive := recorded.Status == Created || recorded.Status == Running || recorded.Status == Paused
+	if alive && recorded.Pid > 0 && live.Status == Stopped && live.MonitorPid == 0 {
+		detail := fmt.Sprintf("recorded as %s, but pid %d is gone", recorded.Status, recorded.Pid)
+		if fromPreviousBoot(recorded) {
+			detail += " with the boot it ran in; gc deletes it"
+		}
+		result.anomalies = append(result.anomalies, Anomaly{ID: id, Kind: types.AnomalyDeadPid, Detail: detail})
+	}
+	return withBundleCheck(result, id, live.Bundle)
+}
+
+// withBundleCheck adds an anomaly to result if bundle is gone.
+func withBundleCheck(result *dirStatus, id, bundle string) *dirStatus {
+	if bundle == "" {
+		return result
+	}
+	if _, err := os.Stat(bundle); os.IsNotExist(err) {
+		result.anomalies = append(result.anomalies, Anomaly{
+			ID: id, Kind: types.AnomalyMissingBundle, Detail: fmt.Sprintf("bundle %s is gone", bundle),
+		})
+	}
+	return result
+}
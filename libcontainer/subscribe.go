@@ -0,0 +1,57 @@
+package libcontainer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+// Subscribe sends the state of the container on the returned channel:
+// the current one first, then one for every change, until the container
+// is deleted or ctx is done, when the channel is closed.
+//
+// state.json is only ever replaced whole, by a rename, so a watcher
+// notices every write by watching the container's directory rather than
+// the file, which a rename swaps out from under it. A watcher never sees
+// a partial state, but states written closer together than it reads
+// them may reach it as the last one only. A process exiting without a
+// write still shows, as State notices it within eventsPollInterval.
+func (c *linuxContainer) Subscribe(ctx context.Context) (<-chan State, error) {
+	state, err := c.State()
+	if err != nil {
+		return nil, err
+	}
+
+	watcher := newEventsWatcher(c.root)
+	states := make(chan State)
+	go func() {
+		defer close(states)
+		defer watcher.Close()
+
+		var last []byte
+		for {
+			data, err := json.Marshal(state)
+			if err == nil && string(data) != string(last) {
+				last = data
+				select {
+				case states <- *state:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			if !watcher.wait(ctx) {
+				return
+			}
+			next, err := c.State()
+			if errors.Is(err, os.ErrNotExist) {
+				return
+			}
+			if err == nil {
+				state = next
+			}
+		}
+	}()
+	return states, nil
+}
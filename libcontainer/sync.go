@@ -0,0 +1,132 @@
+package libcontainer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+type syncType string
+
+// Messages exchanged between the runtime and the container's init over
+// the sync socket. The child blocks on procRun before doing any setup,
+// so the parent can put it in its cgroup first. Once its mounts are in
+// place it sends procHooks and waits for procResume while the parent
+// runs the hooks that belong in the runtime namespace. The socket is
+// close-on-exec, so the parent sees EOF once the container process is
+// running and procError if setup failed before that. In full create
+// mode the child stops short of exec: it sends procReady instead and
+// waits on the exec fifo for start. On the way the child sends
+// procPhase as it enters each phase, so a start that times out can say
+// where it was stuck.
+const (
+	procRun    syncType = "procRun"
+	procHooks  syncType = "procHooks"
+	procResume syncType = "procResume"
+	procError  syncType = "procError"
+	procPhase  syncType = "procPhase"
+	procReady  syncType = "procReady"
+)
+
+type syncT struct {
+	Type    syncType `json:"type"`
+	Message string   `json:"message,omitempty"`
+
+	// Phase is sent with procPhase, the step the init stage enters, and
+	// with procError, the one that failed.
+	Phase Phase `json:"phase,omitempty"`
+
+	// ExitCode is sent with procError, the code the init stage exits
+	// with for it.
+	ExitCode int `json:"exitCode,omitempty"`
+
+	// State is sent with procRun for the hooks the child runs itself.
+	State *specs.State `json:"state,omitempty"`
+
+	// UTSNamespace is sent with procRun, the runtime's own UTS namespace
+	// as /proc/self/ns/uts reads, which the child must have left before
+	// it sets the hostname.
+	UTSNamespace string `json:"utsNamespace,omitempty"`
+
+	// CPUs is sent with procRun when the child is to reset its CPU
+	// affinity to them before exec.
+	CPUs string `json:"cpus,omitempty"`
+}
+
+// newSyncSockpair returns the parent and child ends of a sync socket.
+func newSyncSockpair() (parent, child *os.File, err error) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create sync socket: %w", err)
+	}
+	return os.NewFile(uintptr(fds[0]), "sync-parent"), os.NewFile(uintptr(fds[1]), "sync-child"), nil
+}
+
+func writeSync(w io.Writer, msg syncT) error {
+	if err := json.NewEncoder(w).Encode(msg); err != nil {
+		return fmt.Errorf("failed to write %s sync message: %w", msg.Type, err)
+	}
+	return nil
+}
+
+// readSync reads the next message. It returns io.EOF when the other end
+// was closed without sending one.
+func readSync(dec *json.Decoder) (syncT, error) {
+	var msg syncT
+	if err := dec.Decode(&msg); err != nil {
+		if err == io.EOF {
+			return msg, err
+		}
+		return msg, fmt.Errorf("failed to read sync message: %w", err)
+	}
+	return msg, nil
+}
+
+// expectSync reads the next message and fails unless it is of type t.
+// The other end exiting without a message is reported as an error too.
+func expectSync(dec *json.Decoder, t syncType) (syncT, error) {
+	msg, err := readSync(dec)
+	return checkSync(msg, err, t)
+}
+
+// checkSync is expectSync for a message already read with err.
+func checkSync(msg syncT, err error, t syncType) (syncT, error) {
+	if err == io.EOF {
+		return msg, fmt.Errorf("sync socket closed while waiting for %s", t)
+	}
+	if err != nil {
+		return msg, err
+	}
+	if msg.Type == procError {
+		return msg, msg.startError()
+	}
+	if msg.Type != t {
+		return msg, fmt.Errorf("unexpected sync message %q, expected %q", msg.Type, t)
+	}
+	return msg, nil
+}
+
+// errorSync is the procError message the init stage reports err with.
+func errorSync(err error) syncT {
+	msg := syncT{Type: procError, Message: err.Error(), Phase: PhaseInit, ExitCode: InitExitCode(err)}
+	var startErr *StartError
+	if errors.As(err, &startErr) {
+		msg.Message = startErr.Err.Error()
+		msg.Phase = startErr.Phase
+	}
+	return msg
+}
+
+// startError is the error a procError message reports.
+func (m syncT) startError() error {
+	phase := m.Phase
+	if phase == "" {
+		phase = PhaseInit
+	}
+	return &StartError{Phase: phase, Err: errors.New(m.Message), ExitCode: m.ExitCode}
+}
@@ -0,0 +1,76 @@
+package libcontainer
+
+import "golang.org/x/sys/unix"
+
+// sysCalls are the system calls that set up, signal and clean up
+// containers. The setup functions, MountManager, the signalling of
+// cgroup processes and the cleanup retried while busy make them through
+// it, so their error paths can be driven by faultSys without root.
+type sysCalls interface {
+	Mount(source, target, fstype string, flags uintptr, data string) error
+	Unmount(target string, flags int) error
+	Mknod(path string, mode uint32, dev int) error
+	Sethostname(name []byte) error
+	PivotRoot(newroot, putold string) error
+	Chroot(path string) error
+	Setrlimit(resource int, rlim *unix.Rlimit) error
+	Prctl(option int, arg2, arg3, arg4, arg5 uintptr) error
+	Kill(pid int, sig unix.Signal) error
+	Chdir(path string) error
+	Fchdir(fd int) error
+	Rmdir(path string) error
+}
+
+// hostSys makes the system calls for real.
+var hostSys sysCalls = realSys{}
+
+// realSys is sysCalls on x/sys/unix.
+type realSys struct{}
+
+func (realSys) Mount(source, target, fstype string, flags uintptr, data string) error {
+	return unix.Mount(source, target, fstype, flags, data)
+}
+
+func (realSys) Unmount(target string, flags int) error {
+	return unix.Unmount(target, flags)
+}
+
+func (realSys) Mknod(path string, mode uint32, dev int) error {
+	return unix.Mknod(path, mode, dev)
+}
+
+func (realSys) Sethostname(name []byte) error {
+	return unix.Sethostname(name)
+}
+
+func (realSys) PivotRoot(newroot, putold string) error {
+	return unix.PivotRoot(newroot, putold)
+}
+
+func (realSys) Chroot(path string) error {
+	return unix.Chroot(path)
+}
+
+func (realSys) Setrlimit(resource int, rlim *unix.Rlimit) error {
+	return unix.Setrlimit(resource, rlim)
+}
+
+func (realSys) Prctl(option int, arg2, arg3, arg4, arg5 uintptr) error {
+	return unix.Prctl(option, arg2, arg3, arg4, arg5)
+}
+
+func (realSys) Kill(pid int, sig unix.Signal) error {
+	return unix.Kill(pid, sig)
+}
+
+func (realSys) Chdir(path string) error {
+	return unix.Chdir(path)
+}
+
+func (realSys) Fchdir(fd int) error {
+	return unix.Fchdir(fd)
+}
+
+func (realSys) Rmdir(path string) error {
+	return unix.Rmdir(path)
+}
@@ -0,0 +1,95 @@
+package libcontainer
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// faultSys is a sysCalls that changes nothing: every call is recorded
+// and succeeds, unless an error was scripted for it with fail.
+type faultSys struct {
+	mu       sync.Mutex
+	calls    []string
+	failures map[string][]error
+}
+
+// fail scripts the errors of the next calls named op, such as "Mount",
+// one per call in order. A nil error lets that call succeed.
+func (f *faultSys) fail(op string, errs ...error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures == nil {
+		f.failures = make(map[string][]error)
+	}
+	f.failures[op] = append(f.failures[op], errs...)
+}
+
+// called returns the calls made so far, as "op [args]".
+func (f *faultSys) called() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+// call records a call and returns its scripted error, if any.
+func (f *faultSys) call(op string, args ...interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, fmt.Sprintf("%s %v", op, args))
+	errs := f.failures[op]
+	if len(errs) == 0 {
+		return nil
+	}
+	f.failures[op] = errs[1:]
+	return errs[0]
+}
+
+func (f *faultSys) Mount(source, target, fstype string, flags uintptr, data string) error {
+	return f.call("Mount", source, target, fstype, flags, data)
+}
+
+func (f *faultSys) Unmount(target string, flags int) error {
+	return f.call("Unmount", target, flags)
+}
+
+func (f *faultSys) Mknod(path string, mode uint32, dev int) error {
+	return f.call("Mknod", path, mode, dev)
+}
+
+func (f *faultSys) Sethostname(name []byte) error {
+	return f.call("Sethostname", string(name))
+}
+
+func (f *faultSys) PivotRoot(newroot, putold string) error {
+	return f.call("PivotRoot", newroot, putold)
+}
+
+func (f *faultSys) Chroot(path string) error {
+	return f.call("Chroot", path)
+}
+
+func (f *faultSys) Setrlimit(resource int, rlim *unix.Rlimit) error {
+	return f.call("Setrlimit", resource, rlim.Cur, rlim.Max)
+}
+
+func (f *faultSys) Prctl(option int, arg2, arg3, arg4, arg5 uintptr) error {
+	return f.call("Prctl", option, arg2, arg3, arg4, arg5)
+}
+
+func (f *faultSys) Kill(pid int, sig unix.Signal) error {
+	return f.call("Kill", pid, int(sig))
+}
+
+func (f *faultSys) Chdir(path string) error {
+	return f.call("Chdir", path)
+}
+
+func (f *faultSys) Fchdir(fd int) error {
+	return f.call("Fchdir", fd)
+}
+
+func (f *faultSys) Rmdir(path string) error {
+	return f.call("Rmdir", path)
+}
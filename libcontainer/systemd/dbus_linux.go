@@ -0,0 +1,404 @@
+package systemd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The wire protocol is the small part of the D-Bus specification a
+// method call to systemd needs: EXTERNAL authentication, and messages
+// with string, boolean, integer, array and variant values.
+
+// Message types.
+const (
+	typeMethodCall   = 1
+	typeMethodReturn = 2
+	typeError        = 3
+)
+
+// Header fields.
+const (
+	fieldPath        = 1
+	fieldInterface   = 2
+	fieldMember      = 3
+	fieldErrorName   = 4
+	fieldReplySerial = 5
+	fieldDestination = 6
+	fieldSignature   = 8
+)
+
+// callTimeout bounds a call, reply included.
+const callTimeout = 30 * time.Second
+
+// objectPath is a value marshalled as an object path rather than a
+// string.
+type objectPath string
+
+// Error is an error reply to a call.
+type Error struct {
+	Name    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return e.Name
+	}
+	return e.Name + ": " + e.Message
+}
+
+// bus is an authenticated connection that calls are made on.
+type bus struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	serial uint32
+}
+
+// dialBus connects to the D-Bus socket at path and authenticates as the
+// calling user.
+func dialBus(path string) (*bus, error) {
+	conn, err := net.DialTimeout("unix", path, callTimeout)
+	if err != nil {
+		return nil, err
+	}
+	b := &bus{conn: conn, r: bufio.NewReader(conn)}
+	if err := b.auth(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to authenticate to %s: %w", path, err)
+	}
+	return b, nil
+}
+
+func (b *bus) auth() error {
+	b.conn.SetDeadline(time.Now().Add(callTimeout))
+	defer b.conn.SetDeadline(time.Time{})
+
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Geteuid())))
+	if _, err := io.WriteString(b.conn, "\x00AUTH EXTERNAL "+uid+"\r\n"); err != nil {
+		return err
+	}
+	line, err := b.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "OK ") {
+		return fmt.Errorf("rejected: %s", strings.TrimSpace(line))
+	}
+	_, err = io.WriteString(b.conn, "BEGIN\r\n")
+	return err
+}
+
+func (b *bus) close() error {
+	return b.conn.Close()
+}
+
+// call calls member of iface on the object at path of destination, with
+// a body of the given signature, and returns the reply. An error reply
+// is returned as an *Error.
+func (b *bus) call(destination string, path objectPath, iface, member, signature string, body []byte) (*message, error) {
+	b.serial++
+	serial := b.serial
+
+	var e encoder
+	e.byte('l')
+	e.byte(typeMethodCall)
+	e.byte(0)
+	e.byte(1)
+	e.uint32(uint32(len(body)))
+	e.uint32(serial)
+	e.array(8, func() {
+		e.field(fieldPath, objectPath(path))
+		if destination != "" {
+			e.field(fieldDestination, destination)
+		}
+		e.field(fieldInterface, iface)
+		e.field(fieldMember, member)
+		if signature != "" {
+			e.field(fieldSignature, signatureValue(signature))
+		}
+	})
+	e.align(8)
+	msg := append(e.buf, body...)
+
+	b.conn.SetDeadline(time.Now().Add(callTimeout))
+	defer b.conn.SetDeadline(time.Time{})
+	if _, err := b.conn.Write(msg); err != nil {
+		return nil, err
+	}
+
+	// Signals and replies to others may come first
+	for {
+		reply, err := readMessage(b.r)
+		if err != nil {
+			return nil, err
+		}
+		if reply.replySerial != serial {
+			continue
+		}
+		switch reply.typ {
+		case typeMethodReturn:
+			return reply, nil
+		case typeError:
+			callErr := &Error{Name: reply.errorName}
+			if strings.HasPrefix(reply.signature, "s") {
+				d := decoder{buf: reply.body, order: reply.order}
+				callErr.Message, _ = d.string()
+			}
+			return nil, callErr
+		}
+	}
+}
+
+// message is a message read from the bus.
+type message struct {
+	typ         byte
+	order       binary.ByteOrder
+	replySerial uint32
+	errorName   string
+	signature   string
+	body        []byte
+}
+
+func readMessage(r io.Reader) (*message, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder
+	switch fixed[0] {
+	case 'l':
+		order = binary.LittleEndian
+	case 'B':
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("invalid message endianness %q", fixed[0])
+	}
+	bodyLen := order.Uint32(fixed[4:])
+	fieldsLen := order.Uint32(fixed[12:])
+	if bodyLen > 1<<27 || fieldsLen > 1<<26 {
+		return nil, fmt.Errorf("message too large")
+	}
+	headerLen := 16 + int(fieldsLen)
+	headerLen += (8 - headerLen%8) % 8
+
+	buf := make([]byte, headerLen+int(bodyLen))
+	copy(buf, fixed)
+	if _, err := io.ReadFull(r, buf[16:]); err != nil {
+		return nil, err
+	}
+
+	msg := &message{typ: fixed[1], order: order, body: buf[headerLen:]}
+	d := decoder{buf: buf[:16+fieldsLen], pos: 16, order: order}
+	for d.pos < len(d.buf) {
+		d.align(8)
+		code, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+		sig, err := d.signature()
+		if err != nil {
+			return nil, err
+		}
+		switch sig {
+		case "s", "o":
+			value, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			if code == fieldErrorName {
+				msg.errorName = value
+			}
+		case "g":
+			value, err := d.signature()
+			if err != nil {
+				return nil, err
+			}
+			if code == fieldSignature {
+				msg.signature = value
+			}
+		case "u":
+			value, err := d.uint32()
+			if err != nil {
+				return nil, err
+			}
+			if code == fieldReplySerial {
+				msg.replySerial = value
+			}
+		default:
+			return nil, fmt.Errorf("unexpected header field type %q", sig)
+		}
+	}
+	return msg, nil
+}
+
+// signatureValue is a value marshalled as a signature.
+type signatureValue string
+
+// encoder marshals values little-endian. Alignment is relative to the
+// start of buf, which must start at an 8-byte boundary of the message,
+// as the header and the body do.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) align(n int) {
+	for len(e.buf)%n != 0 {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *encoder) byte(b byte) {
+	e.buf = append(e.buf, b)
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.align(4)
+	e.buf = binary.LittleEndian.AppendUint32(e.buf, v)
+}
+
+func (e *encoder) uint64(v uint64) {
+	e.align(8)
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, v)
+}
+
+func (e *encoder) string(s string) {
+	e.uint32(uint32(len(s)))
+	e.buf = append(e.buf, s...)
+	e.buf = append(e.buf, 0)
+}
+
+func (e *encoder) signature(s string) {
+	e.buf = append(e.buf, byte(len(s)))
+	e.buf = append(e.buf, s...)
+	e.buf = append(e.buf, 0)
+}
+
+// array marshals the elements elems writes, which are aligned to
+// elemAlign. The length excludes the padding before the first one.
+func (e *encoder) array(elemAlign int, elems func()) {
+	e.align(4)
+	at := len(e.buf)
+	e.buf = append(e.buf, 0, 0, 0, 0)
+	e.align(elemAlign)
+	start := len(e.buf)
+	elems()
+	binary.LittleEndian.PutUint32(e.buf[at:], uint32(len(e.buf)-start))
+}
+
+// field marshals a header field, a (yv) struct.
+func (e *encoder) field(code byte, value interface{}) {
+	e.align(8)
+	e.byte(code)
+	e.variant(value)
+}
+
+// variant marshals value with its signature. It panics on a type it
+// doesn't know, which is a bug in the caller.
+func (e *encoder) variant(value interface{}) {
+	switch v := value.(type) {
+	case string:
+		e.signature("s")
+		e.string(v)
+	case objectPath:
+		e.signature("o")
+		e.string(string(v))
+	case signatureValue:
+		e.signature("g")
+		e.signature(string(v))
+	case bool:
+		e.signature("b")
+		b := uint32(0)
+		if v {
+			b = 1
+		}
+		e.uint32(b)
+	case uint32:
+		e.signature("u")
+		e.uint32(v)
+	case uint64:
+		e.signature("t")
+		e.uint64(v)
+	case []uint32:
+		e.signature("au")
+		e.array(4, func() {
+			for _, n := range v {
+				e.uint32(n)
+			}
+		})
+	case []string:
+		e.signature("as")
+		e.array(4, func() {
+			for _, s := range v {
+				e.string(s)
+			}
+		})
+	default:
+		panic(fmt.Sprintf("systemd: cannot marshal %T", value))
+	}
+}
+
+// decoder unmarshals values. pos is relative to the start of the
+// message, or of the body, which are both 8-byte aligned.
+type decoder struct {
+	buf   []byte
+	pos   int
+	order binary.ByteOrder
+}
+
+var errShort = errors.New("message truncated")
+
+func (d *decoder) align(n int) {
+	d.pos += (n - d.pos%n) % n
+}
+
+func (d *decoder) byte() (byte, error) {
+	if d.pos >= len(d.buf) {
+		return 0, errShort
+	}
+	d.pos++
+	return d.buf[d.pos-1], nil
+}
+
+func (d *decoder) uint32() (uint32, error) {
+	d.align(4)
+	if d.pos+4 > len(d.buf) {
+		return 0, errShort
+	}
+	d.pos += 4
+	return d.order.Uint32(d.buf[d.pos-4:]), nil
+}
+
+func (d *decoder) string() (string, error) {
+	n, err := d.uint32()
+	if err != nil {
+		return "", err
+	}
+	if d.pos+int(n)+1 > len(d.buf) {
+		return "", errShort
+	}
+	s := string(d.buf[d.pos : d.pos+int(n)])
+	d.pos += int(n) + 1
+	return s, nil
+}
+
+func (d *decoder) signature() (string, error) {
+	n, err := d.byte()
+	if err != nil {
+		return "", err
+	}
+	if d.pos+int(n)+1 > len(d.buf) {
+		return "", errShort
+	}
+	s := string(d.buf[d.pos : d.pos+int(n)])
+	d.pos += int(n) + 1
+	return s, nil
+}
@@ -0,0 +1,223 @@
+// Package systemd asks systemd's service manager, over D-Bus, to create
+// and manage the units a container's cgroup belongs to, so that the
+// runtime doesn't write into a tree systemd considers its own.
+//
+// Only the calls the runtime makes are implemented, on a connection to
+// systemd's private socket when running as root, and to the system bus
+// otherwise.
+package systemd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	// privateSocket is systemd's own socket, which root can call the
+	// manager on without a bus daemon.
+	privateSocket = "/run/systemd/private"
+	// systemBusSocket is used without DBUS_SYSTEM_BUS_ADDRESS.
+	systemBusSocket = "/run/dbus/system_bus_socket"
+
+	managerDestination = "org.freedesktop.systemd1"
+	managerPath        = objectPath("/org/freedesktop/systemd1")
+	managerInterface   = "org.freedesktop.systemd1.Manager"
+)
+
+// NoSuchUnit is the name of the error systemd replies with for a unit
+// it doesn't know.
+const NoSuchUnit = "org.freedesktop.systemd1.NoSuchUnit"
+
+// Property is a unit property, such as MemoryMax. Values are strings,
+// booleans, uint32 and uint64 numbers, and slices of uint32 or strings.
+type Property struct {
+	Name  string
+	Value interface{}
+}
+
+// Conn is a connection to systemd's service manager.
+type Conn struct {
+	bus *bus
+}
+
+// Dial connects to the service manager: on its private socket when it
+// is there and the caller is root, and on the system bus otherwise,
+// which DBUS_SYSTEM_BUS_ADDRESS may name as unix:path=<socket>.
+func Dial() (*Conn, error) {
+	if os.Geteuid() == 0 {
+		if _, err := os.Stat(privateSocket); err == nil {
+			b, err := dialBus(privateSocket)
+			if err == nil {
+				return &Conn{bus: b}, nil
+			}
+		}
+	}
+
+	path := systemBusSocket
+	if addr := os.Getenv("DBUS_SYSTEM_BUS_ADDRESS"); addr != "" {
+		var err error
+		if path, err = unixPath(addr); err != nil {
+			return nil, err
+		}
+	}
+	b, err := dialBus(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to systemd: %w", err)
+	}
+	// A bus wants to be greeted before anything else
+	if _, err := b.call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "Hello", "", nil); err != nil {
+		b.close()
+		return nil, fmt.Errorf("failed to connect to systemd: %w", err)
+	}
+	return &Conn{bus: b}, nil
+}
+
+// unixPath returns the socket of the first unix:path= address of a bus
+// address list.
+func unixPath(addr string) (string, error) {
+	for _, a := range strings.Split(addr, ";") {
+		transport, params, _ := strings.Cut(a, ":")
+		if transport != "unix" {
+			continue
+		}
+		for _, param := range strings.Split(params, ",") {
+			if path, ok := strings.CutPrefix(param, "path="); ok {
+				return path, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no unix:path= address in %q", addr)
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	return c.bus.close()
+}
+
+// StartTransientUnit creates the unit name with props and starts it,
+// with mode as in systemctl's --job-mode. It returns the path of the
+// job starting it, which may still be running.
+func (c *Conn) StartTransientUnit(name, mode string, props []Property) (string, error) {
+	var e encoder
+	e.string(name)
+	e.string(mode)
+	e.properties(props)
+	// No auxiliary units
+	e.array(8, func() {})
+
+	reply, err := c.call("StartTransientUnit", "ssa(sv)a(sa(sv))", e.buf)
+	if err != nil {
+		return "", err
+	}
+	return replyString(reply)
+}
+
+// SetUnitProperties changes props of the running unit name. With
+// runtime, the change is lost when the unit is stopped, as any change
+// to a transient unit is.
+func (c *Conn) SetUnitProperties(name string, runtime bool, props []Property) error {
+	var e encoder
+	e.string(name)
+	b := uint32(0)
+	if runtime {
+		b = 1
+	}
+	e.uint32(b)
+	e.properties(props)
+	_, err := c.call("SetUnitProperties", "sba(sv)", e.buf)
+	return err
+}
+
+// StopUnit stops the unit name, with mode as StartTransientUnit's.
+func (c *Conn) StopUnit(name, mode string) error {
+	var e encoder
+	e.string(name)
+	e.string(mode)
+	_, err := c.call("StopUnit", "ss", e.buf)
+	return err
+}
+
+func (c *Conn) call(member, signature string, body []byte) (*message, error) {
+	reply, err := c.bus.call(managerDestination, managerPath, managerInterface, member, signature, body)
+	if err != nil {
+		return nil, fmt.Errorf("systemd %s: %w", member, err)
+	}
+	return reply, nil
+}
+
+// properties marshals props as an a(sv) array.
+func (e *encoder) properties(props []Property) {
+	e.array(8, func() {
+		for _, p := range props {
+			e.align(8)
+			e.string(p.Name)
+			e.variant(p.Value)
+		}
+	})
+}
+
+// replyString returns the string or object path a reply consists of.
+func replyString(reply *message) (string, error) {
+	if reply.signature != "s" && reply.signature != "o" {
+		return "", fmt.Errorf("unexpected reply of type %q", reply.signature)
+	}
+	d := decoder{buf: reply.body, order: reply.order}
+	return d.string()
+}
+
+// Booted reports whether the host was booted with systemd, as
+// sd_booted(3) tells.
+func Booted() bool {
+	info, err := os.Lstat("/run/systemd/system")
+	return err == nil && info.IsDir()
+}
+
+// IsNoSuchUnit reports whether err is systemd's reply for a unit it
+// doesn't know.
+func IsNoSuchUnit(err error) bool {
+	var callErr *Error
+	return errors.As(err, &callErr) && callErr.Name == NoSuchUnit
+}
+
+// SlicePath returns the cgroup path, relative to the root of the
+// hierarchy, of slice: a-b.slice is a.slice/a-b.slice, as systemd nests
+// slices by the dashes in their names.
+func SlicePath(slice string) (string, error) {
+	name, ok := strings.CutSuffix(slice, ".slice")
+	if !ok || name == "" || strings.Contains(name, "/") {
+		return "", fmt.Errorf("invalid slice name %q", slice)
+	}
+	if name == "-" {
+		return "/", nil
+	}
+	if strings.HasPrefix(name, "-") || strings.HasSuffix(name, "-") || strings.Contains(name, "--") {
+		return "", fmt.Errorf("invalid slice name %q", slice)
+	}
+	path := ""
+	prefix := ""
+	for _, part := range strings.Split(name, "-") {
+		prefix += part
+		path += "/" + prefix + ".slice"
+		prefix += "-"
+	}
+	return path, nil
+}
+
+// ValidUnitName reports whether name only has the characters systemd
+// allows in a unit name.
+func ValidUnitName(name string) bool {
+	if name == "" || len(name) > 255 {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune(":-_.\\@", r):
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,276 @@
+package libcontainer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// EventUpdate is recorded when Set changes a container's limits. Its
+// data has the new value of each field set, by its path under
+// linux.resources.
+const EventUpdate = "update"
+
+// Set changes the memory, CPU and pids limits of a container that hasn't
+// stopped. Fields r leaves nil keep their current value. The new limits
+// are checked as a whole before any cgroup file is written, and put back
+// as they were if the kernel refuses one of them, so a failed Set leaves
+// the old limits in force. They are recorded in the frozen config, which
+// later starts and restarts apply, and reported by Inspect.
+func (c *linuxContainer) Set(r specs.LinuxResources) (retErr error) {
+	defer func() { c.audit(AuditUpdate, nil, retErr) }()
+
+	if !reflect.DeepEqual(updatableResources(&r), &r) {
+		return newTypedError(ErrInvalidConfig, "only the memory limit, reservation and swap, the cpu shares, quota, period and burst, and the pids limit can be updated")
+	}
+
+	unlock, err := c.lock()
+	if os.IsNotExist(err) {
+		return newTypedError(ErrNotExist, "container %q does not exist", c.id)
+	}
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := c.State()
+	if err != nil {
+		return fmt.Errorf("failed to get container state: %w", err)
+	}
+	if state.Status == Stopped {
+		return newTypedError(ErrNotRunning, "cannot update a stopped container")
+	}
+
+	current := c.config.Resolved.Resources
+	merged := mergeResources(current, &r)
+	if err := validateUpdate(&r, merged); err != nil {
+		return newTypedError(ErrInvalidConfig, "%v", err)
+	}
+
+	// A state-only container has no cgroup until it starts, which
+	// applies what is recorded
+	if state.Pid > 0 && state.CgroupPath != "" {
+		m := c.cgroupManager()
+		if err := m.Set(updatableResources(merged)); err != nil {
+			if undoErr := m.Set(undoResources(current, &r)); undoErr != nil {
+				return fmt.Errorf("failed to update resources: %w; restoring the previous limits also failed: %v", err, undoErr)
+			}
+			return fmt.Errorf("failed to update resources: %w", err)
+		}
+	}
+
+	c.config.Resolved.Resources = merged
+	if c.config.Linux != nil {
+		c.config.Linux.Resources = mergeResources(c.config.Linux.Resources, &r)
+	}
+	path := filepath.Join(c.root, configFilename)
+	if err := c.config.Save(path + ".tmp"); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to record the new limits: %w", err)
+	}
+	// Starts check the config against its hash
+	recorded, err := c.loadState()
+	if err == nil {
+		err = c.recordFrozenConfig(recorded)
+	}
+	if err == nil {
+		err = c.saveState(recorded)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record the new limits: %w", err)
+	}
+
+	c.emit(EventUpdate, resourceChanges(&r))
+	return nil
+}
+
+// updatableResources returns the fields of r Set can change.
+func updatableResources(r *specs.LinuxResources) *specs.LinuxResources {
+	updatable := &specs.LinuxResources{}
+	if r == nil {
+		return updatable
+	}
+	if mem := r.Memory; mem != nil {
+		updatable.Memory = &specs.LinuxMemory{Limit: mem.Limit, Reservation: mem.Reservation, Swap: mem.Swap}
+	}
+	if cpu := r.CPU; cpu != nil {
+		updatable.CPU = &specs.LinuxCPU{Shares: cpu.Shares, Quota: cpu.Quota, Period: cpu.Period, Burst: cpu.Burst}
+	}
+	if r.Pids != nil {
+		updatable.Pids = &specs.LinuxPids{Limit: r.Pids.Limit}
+	}
+	return updatable
+}
+
+// undoResources returns the limits that put back what update changes:
+// the values in current, or the kernel's defaults for those it has none
+// of.
+func undoResources(current, update *specs.LinuxResources) *specs.LinuxResources {
+	none := int64(-1)
+	defaults := &specs.LinuxResources{}
+	if mem := update.Memory; mem != nil {
+		defaults.Memory = &specs.LinuxMemory{}
+		if mem.Limit != nil {
+			defaults.Memory.Limit = &none
+		}
+		if mem.Reservation != nil {
+			defaults.Memory.Reservation = &none
+		}
+		if mem.Swap != nil {
+			defaults.Memory.Swap = &none
+		}
+	}
+	if cpu := update.CPU; cpu != nil {
+		shares, period, burst := uint64(1024), uint64(100000), uint64(0)
+		defaults.CPU = &specs.LinuxCPU{}
+		if cpu.Shares != nil {
+			defaults.CPU.Shares = &shares
+		}
+		if cpu.Quota != nil {
+			defaults.CPU.Quota = &none
+		}
+		if cpu.Period != nil {
+			defaults.CPU.Period = &period
+		}
+		if cpu.Burst != nil {
+			defaults.CPU.Burst = &burst
+		}
+	}
+	if update.Pids != nil {
+		defaults.Pids = &specs.LinuxPids{Limit: &none}
+	}
+	return updatableResources(mergeResources(defaults, updatableResources(current)))
+}
+
+// mergeResources returns a copy of current with the fields update sets
+// replaced.
+func mergeResources(current, update *specs.LinuxResources) *specs.LinuxResources {
+	merged := &specs.LinuxResources{}
+	if current != nil {
+		*merged = *current
+	}
+	if mem := update.Memory; mem != nil {
+		m := specs.LinuxMemory{}
+		if merged.Memory != nil {
+			m = *merged.Memory
+		}
+		if mem.Limit != nil {
+			m.Limit = mem.Limit
+		}
+		if mem.Reservation != nil {
+			m.Reservation = mem.Reservation
+		}
+		if mem.Swap != nil {
+			m.Swap = mem.Swap
+		}
+		merged.Memory = &m
+	}
+	if cpu := update.CPU; cpu != nil {
+		c := specs.LinuxCPU{}
+		if merged.CPU != nil {
+			c = *merged.CPU
+		}
+		if cpu.Shares != nil {
+			c.Shares = cpu.Shares
+		}
+		if cpu.Quota != nil {
+			c.Quota = cpu.Quota
+		}
+		if cpu.Period != nil {
+			c.Period = cpu.Period
+		}
+		if cpu.Burst != nil {
+			c.Burst = cpu.Burst
+		}
+		merged.CPU = &c
+	}
+	if update.Pids != nil && update.Pids.Limit != nil {
+		merged.Pids = &specs.LinuxPids{Limit: update.Pids.Limit}
+	}
+	return merged
+}
+
+// validateUpdate checks the limits merged would leave in force, as far
+// as the fields update changes are concerned, so a limit the container
+// was created with is never what fails an unrelated update.
+func validateUpdate(update, merged *specs.LinuxResources) error {
+	if cpu := update.CPU; cpu != nil {
+		if cpu.Shares != nil && (*cpu.Shares < 2 || *cpu.Shares > 262144) {
+			return fmt.Errorf("cpu shares must be between 2 and 262144, got %d", *cpu.Shares)
+		}
+		if cpu.Period != nil && (*cpu.Period < 1000 || *cpu.Period > 1000000) {
+			return fmt.Errorf("cpu period must be between 1000 and 1000000 microseconds, got %d", *cpu.Period)
+		}
+		if cpu.Quota != nil && *cpu.Quota > 0 {
+			if *cpu.Quota < 1000 {
+				return fmt.Errorf("cpu quota must be at least 1000 microseconds, or -1 for none, got %d", *cpu.Quota)
+			}
+			if merged.CPU.Period == nil {
+				return fmt.Errorf("a cpu quota needs a cpu period, and the container has none")
+			}
+		}
+		if cpu.Quota != nil || cpu.Burst != nil {
+			if err := validateCPU(&specs.Spec{Linux: &specs.Linux{Resources: merged}}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if mem := update.Memory; mem != nil {
+		m := merged.Memory
+		for name, value := range map[string]*int64{"limit": mem.Limit, "reservation": mem.Reservation, "swap": mem.Swap} {
+			if value != nil && *value < -1 {
+				return fmt.Errorf("memory %s must be a number of bytes, or -1 for none, got %d", name, *value)
+			}
+		}
+		limited := m.Limit != nil && *m.Limit > 0
+		if limited && m.Reservation != nil && *m.Reservation > *m.Limit {
+			return fmt.Errorf("memory reservation (%d) cannot exceed the memory limit (%d)", *m.Reservation, *m.Limit)
+		}
+		// swap is memory+swap, so it can't be below the memory limit
+		if m.Swap != nil && *m.Swap > 0 && (!limited || *m.Swap < *m.Limit) {
+			return fmt.Errorf("memory swap (%d) is memory plus swap and cannot be below the memory limit", *m.Swap)
+		}
+	}
+
+	if pids := update.Pids; pids != nil && pids.Limit != nil && *pids.Limit < -1 {
+		return fmt.Errorf("pids limit must be positive, or 0 or -1 for none, got %d", *pids.Limit)
+	}
+	return nil
+}
+
+// resourceChanges lists the fields r sets for an update event.
+func resourceChanges(r *specs.LinuxResources) map[string]string {
+	changes := map[string]string{}
+	signed := func(name string, value *int64) {
+		if value != nil {
+			changes[name] = strconv.FormatInt(*value, 10)
+		}
+	}
+	unsigned := func(name string, value *uint64) {
+		if value != nil {
+			changes[name] = strconv.FormatUint(*value, 10)
+		}
+	}
+	if mem := r.Memory; mem != nil {
+		signed("memory.limit", mem.Limit)
+		signed("memory.reservation", mem.Reservation)
+		signed("memory.swap", mem.Swap)
+	}
+	if cpu := r.CPU; cpu != nil {
+		unsigned("cpu.shares", cpu.Shares)
+		signed("cpu.quota", cpu.Quota)
+		unsigned("cpu.period", cpu.Period)
+		unsigned("cpu.burst", cpu.Burst)
+	}
+	if r.Pids != nil {
+		signed("pids.limit", r.Pids.Limit)
+	}
+	return changes
+}
@@ -0,0 +1,102 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myarchcheck"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false' ${BUNDLE}/config.json > ${BUNDLE}/config.json.orig
+OUT_FILE=$(mktemp)
+trap 'sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true; rm -f ${OUT_FILE}' EXIT
+
+# The shell with the ELF machine field set to an architecture the host isn't
+APP=${BUNDLE}/rootfs/app
+mkdir -p ${APP} ${BUNDLE}/rootfs/usr/local/bin
+cp -L ${BUNDLE}/rootfs/bin/sh ${APP}/foreign
+if [ "$(uname -m)" = "aarch64" ]; then
+    MACHINE='\x3e\x00'; FOREIGN=x86_64; HOST=aarch64
+else
+    MACHINE='\xb7\x00'; FOREIGN=aarch64; HOST=x86_64
+fi
+printf "${MACHINE}" | dd of=${APP}/foreign bs=1 seek=18 conv=notrunc status=none
+cp ${APP}/foreign ${BUNDLE}/rootfs/usr/local/bin/foreign-tool
+printf '#!/app/foreign\necho hello\n' > ${APP}/foreign.sh
+printf '#!/bin/sh\necho hello\n' > ${APP}/native.sh
+printf 'not a binary\n' > ${APP}/junk
+chmod +x ${APP}/*
+ln -s /app/foreign ${APP}/link
+
+# create_with <path> [create flags...] creates the container running
+# path, leaving what create printed in OUT; it returns create's status
+create_with() {
+    jq --arg path "$1" '.process.args = [$path]' ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+    sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true
+    local status=0
+    sudo ./hackontainer create "${@:2}" --bundle ${BUNDLE} ${CONTAINER} > ${OUT_FILE} 2>&1 || status=$?
+    OUT=$(grep -v "^>>>" ${OUT_FILE} || true)
+    return ${status}
+}
+
+# refused <path> <binary> <what> expects create to name binary as foreign
+refused() {
+    if create_with "$1"; then
+        echo "FAIL: $3: create accepted $1"
+        exit 1
+    fi
+    if ! echo "${OUT}" | grep -qF "rootfs binary $2 is ${FOREIGN} but host is ${HOST}"; then
+        echo "FAIL: $3: unexpected error: ${OUT}"
+        exit 1
+    fi
+    echo "PASS: $3"
+}
+
+# accepted <path> <what> expects create to succeed
+accepted() {
+    if ! create_with "$1" "${@:3}"; then
+        echo "FAIL: $2: ${OUT}"
+        exit 1
+    fi
+    echo "PASS: $2"
+}
+
+echo "=== A binary for another architecture ==="
+refused /app/foreign /app/foreign "refused at create"
+if [ -e /run/hackontainer/${CONTAINER} ]; then
+    echo "FAIL: refused create left the container behind"
+    exit 1
+fi
+echo "PASS: nothing left behind"
+
+echo "=== Reached through a symlink, along PATH and from a script ==="
+refused /app/link /app/link "symlink followed"
+refused foreign-tool /usr/local/bin/foreign-tool "found along PATH"
+refused /app/foreign.sh /app/foreign.sh "script judged by its interpreter"
+
+echo "=== Binaries the host runs, and files the check can't judge ==="
+accepted /bin/sh "native binary"
+accepted /app/native.sh "native script"
+accepted /app/junk "not ELF"
+accepted /app/missing "missing binary falls back to /bin/sh"
+
+echo "=== --no-arch-check ==="
+accepted /app/foreign "check skipped" --no-arch-check
+
+echo "=== All arch check tests passed ==="
@@ -0,0 +1,89 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myargs"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+echo "=== Dropping process.args, as some image conversions do ==="
+jq '.process.terminal = false | del(.process.args)' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+
+echo "=== Running without a command (expect a failure) ==="
+if sudo ./hackontainer run --bundle ${BUNDLE} ${CONTAINER}; then
+    echo "FAIL: ran a config without process.args"
+    exit 1
+fi
+echo "PASS: config without args rejected"
+
+echo "=== Running with the command after -- ==="
+# Flags after -- belong to the container command, not the runtime. The
+# runtime logs its progress to stdout as well, hence the grep
+OUTPUT=$(sudo ./hackontainer run --bundle ${BUNDLE} ${CONTAINER} -- echo --bundle is an arg 2>/dev/null | grep -v "^>>>")
+if [ "${OUTPUT}" != "--bundle is an arg" ]; then
+    echo "FAIL: unexpected output '${OUTPUT}'"
+    exit 1
+fi
+echo "PASS: -- command ran"
+
+echo "=== Running with repeated --args ==="
+OUTPUT=$(sudo ./hackontainer run --bundle ${BUNDLE} --args echo --args -n --args "two words" ${CONTAINER} 2>/dev/null | grep -v "^>>>")
+if [ "${OUTPUT}" != "two words" ]; then
+    echo "FAIL: unexpected output '${OUTPUT}'"
+    exit 1
+fi
+echo "PASS: --args ran"
+
+echo "=== Combining --args with -- (expect a failure) ==="
+if sudo ./hackontainer run --bundle ${BUNDLE} --args echo ${CONTAINER} -- echo; then
+    echo "FAIL: accepted both --args and --"
+    exit 1
+fi
+echo "PASS: combination rejected"
+
+echo "=== Restoring process.args in the config ==="
+jq '.process.args = ["echo", "from the spec"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+
+echo "=== Overriding existing args without --replace-args (expect a failure) ==="
+if sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} -- echo override; then
+    echo "FAIL: replaced the spec's args silently"
+    sudo ./hackontainer delete ${CONTAINER}
+    exit 1
+fi
+echo "PASS: override rejected"
+
+echo "=== Overriding with --replace-args ==="
+sudo ./hackontainer create --bundle ${BUNDLE} --replace-args ${CONTAINER} -- echo override
+# start runs from the frozen config, not the bundle
+if [ "$(sudo jq -c .process.args /run/hackontainer/${CONTAINER}/config.json)" != '["echo","override"]' ]; then
+    echo "FAIL: override not recorded in the frozen config"
+    sudo ./hackontainer delete ${CONTAINER}
+    exit 1
+fi
+sudo ./hackontainer start ${CONTAINER}
+echo "PASS: override recorded"
+
+echo "=== Cleaning up ==="
+sleep 1
+sudo ./hackontainer delete ${CONTAINER}
@@ -0,0 +1,139 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myaudit"
+BUNDLE="test-bundles/busybox"
+ROOT="/run/hackontainer-audit"
+LOG="${ROOT}/audit.log"
+SOCK="${ROOT}/api.sock"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf ${ROOT}
+
+hk() {
+    sudo ./hackontainer --root ${ROOT} "$@"
+}
+
+API=""
+cleanup() {
+    [ -n "${API}" ] && sudo kill ${API} 2>/dev/null || true
+    for id in ${CONTAINER} ${CONTAINER}-api ${CONTAINER}-none; do
+        hk kill ${id} SIGKILL >/dev/null 2>&1 || true
+    done
+    sleep 1
+    for id in ${CONTAINER} ${CONTAINER}-api ${CONTAINER}-none; do
+        hk delete --force ${id} >/dev/null 2>&1 || true
+    done
+    sudo rm -rf ${ROOT}
+}
+trap cleanup EXIT
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false | .process.args = ["sleep", "100"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+ABS_BUNDLE=$(cd ${BUNDLE} && pwd)
+
+# records <id> prints the audit records of a container, one per line
+records() {
+    sudo jq -c --arg id "$1" 'select(.id == $id)' ${LOG}
+}
+
+# check <jq filter> <what> checks every record of the container
+check() {
+    if ! records ${CONTAINER} | jq -e -s "$1" >/dev/null; then
+        records ${CONTAINER}
+        echo "FAIL: $2"
+        exit 1
+    fi
+    echo "PASS: $2"
+}
+
+echo "=== A full lifecycle, with operations that fail ==="
+hk create --bundle ${BUNDLE} ${CONTAINER}
+if hk create --bundle ${BUNDLE} ${CONTAINER} 2>/dev/null; then
+    echo "FAIL: created the same container twice"
+    exit 1
+fi
+hk start ${CONTAINER}
+hk kill ${CONTAINER} KILL
+for i in $(seq 1 25); do
+    [ "$(hk state ${CONTAINER} | jq -r .status)" = "stopped" ] && break
+    sleep 0.2
+done
+if hk kill ${CONTAINER} KILL 2>/dev/null; then
+    echo "FAIL: killed a stopped container"
+    exit 1
+fi
+hk delete ${CONTAINER}
+
+check '[.[] | .operation] == ["create", "create", "start", "kill", "kill", "delete"]' "one record per operation, in order"
+check '[.[] | .result] == ["success", "failure", "success", "success", "failure", "success"]' "results recorded"
+check '.[1].errorKind == "exist" and .[4].errorKind == "not-running"' "failures name their error kind"
+check '.[1].error != null and .[0].error == null' "failures carry the error"
+check "all(.[]; .uid == $(id -u) and .gid == $(id -g) and .pid > 0)" "caller recorded"
+check ".[0].args.bundle == \"${ABS_BUNDLE}\"" "create records the bundle"
+check '.[3].args.signal == "9" and .[3].args.all == "false"' "kill records the signal"
+check 'all(.[]; .schemaVersion > 0 and (.timestamp | length) > 0)' "records are stamped"
+
+echo "=== Auditing never blocks an operation ==="
+sudo mv ${LOG} ${LOG}.saved
+sudo mkdir ${LOG}
+OUTPUT=$(hk create --bundle ${BUNDLE} ${CONTAINER} 2>&1)
+sudo rmdir ${LOG}
+sudo mv ${LOG}.saved ${LOG}
+if ! echo "${OUTPUT}" | grep -q "WARNING: failed to audit create of ${CONTAINER}"; then
+    echo "${OUTPUT}"
+    echo "FAIL: no warning for the record that couldn't be written"
+    exit 1
+fi
+if [ "$(hk state ${CONTAINER} | jq -r .status)" != "created" ]; then
+    echo "FAIL: create failed along with its record"
+    exit 1
+fi
+hk delete ${CONTAINER}
+echo "PASS: created with a warning"
+
+echo "=== --audit none ==="
+sudo ./hackontainer --root ${ROOT} --audit none create --bundle ${BUNDLE} ${CONTAINER}-none
+sudo ./hackontainer --root ${ROOT} --audit none delete ${CONTAINER}-none
+if [ -n "$(records ${CONTAINER}-none)" ]; then
+    echo "FAIL: --audit none still recorded"
+    exit 1
+fi
+echo "PASS: nothing recorded"
+
+echo "=== The API attributes operations to its clients ==="
+sudo ./hackontainer --root ${ROOT} api --listen unix://${SOCK} 2>/dev/null &
+API=$!
+for i in $(seq 1 25); do
+    sudo test -S ${SOCK} && break
+    sleep 0.2
+done
+sudo curl -sf --unix-socket ${SOCK} -X POST http://localhost/containers \
+    -d "{\"id\": \"${CONTAINER}-api\", \"bundle\": \"${ABS_BUNDLE}\"}" >/dev/null
+sudo curl -sf --unix-socket ${SOCK} -X DELETE http://localhost/containers/${CONTAINER}-api
+API_RECORDS=$(records ${CONTAINER}-api)
+if ! echo "${API_RECORDS}" | jq -e -s "[.[] | .operation] == [\"create\", \"delete\"] and all(.[]; .uid == 0 and .pid > 0)" >/dev/null; then
+    echo "${API_RECORDS}"
+    echo "FAIL: API operations not recorded for the peer"
+    exit 1
+fi
+echo "PASS: recorded with the peer's credentials"
+
+echo "=== All audit tests passed ==="
@@ -0,0 +1,100 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mybootid"
+CURRENT="mybootid-current"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER} /run/hackontainer/${CURRENT}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.args = ["true"] | .process.terminal = false' ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+
+state() {
+    sudo ./hackontainer state $1 2>/dev/null | grep -v "^>>>" | jq -r "$2"
+}
+
+echo "=== Starting records the boot id ==="
+sudo ./hackontainer run --keep --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+sudo ./hackontainer run --keep --bundle ${BUNDLE} ${CURRENT} >/dev/null 2>&1
+if [ "$(state ${CONTAINER} .bootId)" != "$(cat /proc/sys/kernel/random/boot_id)" ]; then
+    echo "FAIL: state has boot id '$(state ${CONTAINER} .bootId)'"
+    exit 1
+fi
+echo "PASS: boot id recorded"
+
+# Make the state look like it was left by a container that was running
+# when the host went down: its pids now belong to some other process
+sleep 1000 &
+HOST_PID=$!
+trap 'kill ${HOST_PID} 2>/dev/null || true' EXIT
+STATE_FILE=/run/hackontainer/${CONTAINER}/state.json
+sudo jq --argjson pid ${HOST_PID} \
+    '.status = "running" | .pid = $pid | .monitorPid = $pid | .bootId = "00000000-0000-0000-0000-000000000000"' \
+    ${STATE_FILE} > state.json.tmp
+sudo mv state.json.tmp ${STATE_FILE}
+
+echo "=== A container from a previous boot is stopped ==="
+if [ "$(state ${CONTAINER} .status)" != "stopped" ]; then
+    echo "FAIL: status is $(state ${CONTAINER} .status)"
+    exit 1
+fi
+echo "PASS: status is stopped"
+if [ "$(state ${CONTAINER} .monitorPid)" != "null" ]; then
+    echo "FAIL: stale monitor pid still reported"
+    exit 1
+fi
+echo "PASS: stale monitor pid dropped"
+
+echo "=== Kill leaves the process that reused the pid alone ==="
+if sudo ./hackontainer kill ${CONTAINER} KILL 2>/dev/null; then
+    echo "FAIL: kill signalled a container from a previous boot"
+    exit 1
+fi
+if ! kill -0 ${HOST_PID}; then
+    echo "FAIL: the host process was killed"
+    exit 1
+fi
+echo "PASS: kill refused and the host process survived"
+
+echo "=== gc deletes only containers from a previous boot ==="
+DELETED=$(sudo ./hackontainer gc 2>/dev/null | grep -v "^>>>")
+if [ "${DELETED}" != "${CONTAINER}" ]; then
+    echo "FAIL: gc deleted '${DELETED}'"
+    exit 1
+fi
+if sudo ./hackontainer state ${CONTAINER} >/dev/null 2>&1; then
+    echo "FAIL: ${CONTAINER} still exists"
+    exit 1
+fi
+if [ "$(state ${CURRENT} .status)" != "stopped" ]; then
+    echo "FAIL: gc touched ${CURRENT}"
+    exit 1
+fi
+echo "PASS: gc deleted ${CONTAINER} and kept ${CURRENT}"
+if ! kill -0 ${HOST_PID}; then
+    echo "FAIL: gc killed the host process"
+    exit 1
+fi
+echo "PASS: the host process survived gc"
+
+sudo ./hackontainer delete ${CURRENT}
+
+echo "=== All boot id tests passed ==="
@@ -0,0 +1,103 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mybrokenpipe"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false | .process.args = ["sleep", "30"]' ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+ERR_FILE=$(mktemp)
+trap 'sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true; sudo ./hackontainer delete ${CONTAINER}-2 >/dev/null 2>&1 || true; rm -f ${ERR_FILE}' EXIT
+
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+
+# expect_quiet <what> fails if anything but the debug output went to stderr
+expect_quiet() {
+    if grep -v "^>>>" ${ERR_FILE} | grep -q .; then
+        echo "FAIL: $1 printed to stderr:"
+        cat ${ERR_FILE}
+        exit 1
+    fi
+    echo "PASS: $1 printed nothing to stderr"
+}
+
+# closed_pipe <args...> runs a one-shot command with its stdout a pipe
+# whose reader has already gone away, leaving its exit status in STATUS
+closed_pipe() {
+    exec 3> >(true)
+    sleep 0.2
+    set +e
+    sudo ./hackontainer "$@" >&3 2>${ERR_FILE}
+    STATUS=$?
+    set -e
+    exec 3>&-
+}
+
+# after_first_byte <args...> runs a command whose reader closes the pipe
+# after reading one byte, leaving its exit status in STATUS
+after_first_byte() {
+    set +e
+    set -o pipefail
+    sudo ./hackontainer "$@" 2>${ERR_FILE} | head -c 1 >/dev/null
+    STATUS=$?
+    set +o pipefail
+    set -e
+}
+
+for args in "state ${CONTAINER}" "inspect ${CONTAINER}" "events --all" "schema state"; do
+    echo "=== ${args}: stdout closed ==="
+    closed_pipe ${args}
+    if [ "${STATUS}" != "141" ]; then
+        echo "FAIL: ${args} exited ${STATUS}, not 141"
+        cat ${ERR_FILE}
+        exit 1
+    fi
+    echo "PASS: ${args} exited 141"
+    expect_quiet "${args}"
+done
+
+echo "=== state: reader gone after the first byte ==="
+after_first_byte state ${CONTAINER}
+# The state is one write, which may land before the reader goes away
+if [ "${STATUS}" != "0" ] && [ "${STATUS}" != "141" ]; then
+    echo "FAIL: state exited ${STATUS}"
+    exit 1
+fi
+echo "PASS: state exited ${STATUS}"
+expect_quiet "state"
+
+echo "=== events --follow: reader gone after the first byte ==="
+# The reader goes away after a byte of the create event; the delete
+# event's write then fails
+( sleep 1; sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER}-2 >/dev/null 2>&1
+  sleep 1; sudo ./hackontainer delete ${CONTAINER}-2 >/dev/null 2>&1 ) &
+after_first_byte events --all --follow
+wait
+if [ "${STATUS}" != "0" ]; then
+    echo "FAIL: events --follow exited ${STATUS}, not 0"
+    cat ${ERR_FILE}
+    exit 1
+fi
+echo "PASS: events --follow exited 0"
+expect_quiet "events --follow"
+
+echo "=== All broken pipe tests passed ==="
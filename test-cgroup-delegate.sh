@@ -0,0 +1,52 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mydelegate"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Checking for cgroup v2 ==="
+if [ "$(stat -fc %T /sys/fs/cgroup)" != "cgroup2fs" ]; then
+    echo "SKIP: cgroup delegation requires cgroup v2"
+    exit 0
+fi
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+echo "=== Adding user and cgroup namespaces with delegation ==="
+jq '.process.terminal = false
+    | .process.args = ["mkdir", "/sys/fs/cgroup/sub"]
+    | .linux.namespaces += [{"type": "user"}, {"type": "cgroup"}]
+    | .linux.uidMappings = [{"containerID": 0, "hostID": 100000, "size": 65536}]
+    | .linux.gidMappings = [{"containerID": 0, "hostID": 100000, "size": 65536}]
+    | .annotations["org.hackontainer.cgroup-delegate"] = "true"' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+
+echo "=== Running container (mkdir /sys/fs/cgroup/sub must succeed) ==="
+if sudo ./hackontainer run --bundle ${BUNDLE} ${CONTAINER}; then
+    echo "PASS: container created a sub-cgroup"
+else
+    echo "FAIL: container could not create a sub-cgroup"
+    sudo ./hackontainer delete ${CONTAINER}
+    exit 1
+fi
+
+echo "=== Deleting container ==="
+sudo ./hackontainer delete ${CONTAINER} || true
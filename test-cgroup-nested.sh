@@ -0,0 +1,148 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mynested"
+BUNDLE="test-bundles/busybox"
+# JOB is the cgroup a CI system would run a job in
+JOB="/sys/fs/cgroup/hackontainer-test-job"
+
+if [ "$(stat -fc %T /sys/fs/cgroup)" != "cgroup2fs" ]; then
+    echo "SKIP: the nested cgroup test requires cgroup v2"
+    exit 0
+fi
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false | .process.args = ["sleep", "30"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+
+# in_job runs a script the way a CI job inside a container would: in the
+# job's cgroup, in a cgroup namespace rooted there, with the cgroup
+# filesystem mounted from inside that namespace. The first argument
+# picks how the job's cgroup filesystem looks:
+#   readonly   read-only, nothing delegated
+#   delegated  read-only, but the job's own leaf cgroup "runner" is writable
+in_job() {
+    local layout=$1
+    shift
+    sudo mkdir -p ${JOB}
+    sudo LAYOUT=${layout} RUNTIME="$(pwd)/hackontainer" BUNDLE="${BUNDLE}" CONTAINER="${CONTAINER}" \
+        sh -c 'echo $$ > '${JOB}'/cgroup.procs && exec unshare --cgroup --mount --propagation private bash -ec "$0"' '
+            umount -l /sys/fs/cgroup
+            mount -t cgroup2 none /sys/fs/cgroup
+            if [ "${LAYOUT}" = delegated ]; then
+                mkdir -p /sys/fs/cgroup/runner
+                echo $$ > /sys/fs/cgroup/runner/cgroup.procs
+                mount --bind /sys/fs/cgroup/runner /sys/fs/cgroup/runner
+            fi
+            mount -o remount,bind,ro /sys/fs/cgroup
+            '"$*"
+}
+
+# job_lifecycle runs a container through create, start, kill and delete
+# within one job, in the background, passing create the flags in the
+# second argument. Once it started, the job waits for /tmp/nested.checked
+# so the host can look at it meanwhile.
+job_lifecycle() {
+    rm -f /tmp/nested.started /tmp/nested.checked
+    in_job $1 '
+        ${RUNTIME} create '"$2"' --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>/tmp/nested.err
+        ${RUNTIME} start ${CONTAINER} >/dev/null
+        touch /tmp/nested.started
+        while [ ! -e /tmp/nested.checked ]; do sleep 0.1; done
+        ${RUNTIME} kill ${CONTAINER} KILL
+        while ${RUNTIME} state ${CONTAINER} | grep -q running; do sleep 0.1; done
+        ${RUNTIME} delete ${CONTAINER}' &
+    for i in $(seq 50); do
+        [ -e /tmp/nested.started ] && return 0
+        sleep 0.1
+    done
+    echo "FAIL: container did not start"
+    cat /tmp/nested.err
+    exit 1
+}
+
+# checked lets the job finish
+checked() {
+    touch /tmp/nested.checked
+    wait
+}
+
+fail() {
+    echo "FAIL: $1"
+    checked
+    exit 1
+}
+
+echo "=== Read-only cgroupfs: the container runs without cgroups ==="
+job_lifecycle readonly
+MODE=$(sudo jq -r '.annotations["org.hackontainer.cgroups"]' /run/hackontainer/${CONTAINER}/config.json)
+[ "${MODE}" = "none" ] || fail "container was not created without cgroups (annotation '${MODE}')"
+echo "PASS: recorded as running without cgroups"
+[ "$(grep -c "WARNING: running without cgroups" /tmp/nested.err)" = "1" ] || fail "expected a single warning"
+echo "PASS: warned once"
+checked
+echo "PASS: killed and deleted"
+
+echo "=== Read-only cgroupfs: --cgroups nested and root fail ==="
+if in_job readonly '${RUNTIME} --cgroups nested create --bundle ${BUNDLE} ${CONTAINER}' >/dev/null 2>&1; then
+    echo "FAIL: --cgroups nested created a container without a delegated cgroup"
+    exit 1
+fi
+echo "PASS: --cgroups nested refused"
+if in_job readonly '${RUNTIME} --cgroups root run --bundle ${BUNDLE} ${CONTAINER}' >/dev/null 2>&1; then
+    echo "FAIL: --cgroups root ran on a read-only cgroupfs"
+    exit 1
+fi
+in_job readonly '${RUNTIME} delete ${CONTAINER}' >/dev/null 2>&1 || true
+echo "PASS: --cgroups root failed"
+
+echo "=== Delegated subtree: the cgroup goes below the job's own ==="
+job_lifecycle delegated
+PATH_IN_JOB=$(sudo jq -r .linux.cgroupsPath /run/hackontainer/${CONTAINER}/config.json)
+[ "${PATH_IN_JOB}" = "/runner/hackontainer/${CONTAINER}" ] || fail "cgroupsPath is '${PATH_IN_JOB}'"
+echo "PASS: cgroupsPath is below the job's cgroup"
+PID=$(sudo ./hackontainer state ${CONTAINER} | grep -v "^>>>" | jq .pid)
+grep -qx "${PID}" ${JOB}/runner/hackontainer/${CONTAINER}/cgroup.procs ||
+    fail "container pid ${PID} is not in ${JOB}/runner/hackontainer/${CONTAINER}"
+echo "PASS: container runs in ${JOB}/runner/hackontainer/${CONTAINER}"
+checked
+if [ -e ${JOB}/runner/hackontainer/${CONTAINER} ]; then
+    echo "FAIL: cgroup left behind"
+    exit 1
+fi
+echo "PASS: cgroup removed on delete"
+
+echo "=== Delegated subtree: --cgroup-parent goes below the job's cgroup too ==="
+job_lifecycle delegated "--cgroup-parent /tenant"
+PATH_IN_JOB=$(sudo jq -r .linux.cgroupsPath /run/hackontainer/${CONTAINER}/config.json)
+[ "${PATH_IN_JOB}" = "/runner/tenant/${CONTAINER}" ] || fail "cgroupsPath is '${PATH_IN_JOB}'"
+echo "PASS: cgroupsPath is the parent below the job's cgroup"
+PID=$(sudo ./hackontainer state ${CONTAINER} | grep -v "^>>>" | jq .pid)
+grep -qx "${PID}" ${JOB}/runner/tenant/${CONTAINER}/cgroup.procs ||
+    fail "container pid ${PID} is not in ${JOB}/runner/tenant/${CONTAINER}"
+echo "PASS: container runs in ${JOB}/runner/tenant/${CONTAINER}"
+checked
+
+echo "=== Cleaning up ==="
+rm -f /tmp/nested.err /tmp/nested.started /tmp/nested.checked
+sudo rmdir ${JOB}/runner/hackontainer ${JOB}/runner/tenant ${JOB}/runner ${JOB} 2>/dev/null || true
+echo "=== All nested cgroup tests passed ==="
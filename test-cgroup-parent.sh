@@ -0,0 +1,106 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mycgparent"
+BUNDLE="test-bundles/busybox"
+PARENT="/hackontainer-test-tenant/team"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false | .process.args = ["sleep", "30"] |
+    .linux.resources.pids.limit = 42' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.orig
+cp ${BUNDLE}/config.json.orig ${BUNDLE}/config.json
+
+# pids_dir is where a cgroup's pids controller files are on this host
+if [ "$(stat -fc %T /sys/fs/cgroup)" = "cgroup2fs" ]; then
+    pids_dir() { echo /sys/fs/cgroup$1; }
+else
+    pids_dir() { echo /sys/fs/cgroup/pids$1; }
+fi
+
+state() {
+    sudo ./hackontainer state ${CONTAINER} | grep -v "^>>>" | jq -r "$1"
+}
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1: got '$2', want '$3'"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+stop() {
+    sudo ./hackontainer kill ${CONTAINER} KILL
+    while [ "$(state .status)" = "running" ]; do sleep 0.1; done
+    sudo ./hackontainer delete ${CONTAINER}
+}
+
+echo "=== Without --cgroup-parent the cgroup is below /hackontainer ==="
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} >/dev/null
+check "default cgroup path" "$(state .cgroupPath)" "/hackontainer/${CONTAINER}"
+sudo ./hackontainer delete ${CONTAINER}
+
+echo "=== --cgroup-parent creates the parent's missing directories ==="
+sudo ./hackontainer create --cgroup-parent ${PARENT} --bundle ${BUNDLE} ${CONTAINER} >/dev/null
+sudo ./hackontainer start ${CONTAINER} >/dev/null
+check "state has the cgroup below the parent" "$(state .cgroupPath)" "${PARENT}/${CONTAINER}"
+check "inspect reports it too" \
+    "$(sudo ./hackontainer inspect ${CONTAINER} | grep -v "^>>>" | jq -r .cgroupPath)" "${PARENT}/${CONTAINER}"
+PID=$(state .pid)
+if ! grep -q ":${PARENT}/${CONTAINER}\$" /proc/${PID}/cgroup; then
+    echo "FAIL: container process is not in ${PARENT}/${CONTAINER}"
+    exit 1
+fi
+echo "PASS: the container process is in it"
+check "limits apply below the parent" "$(cat $(pids_dir ${PARENT}/${CONTAINER})/pids.max)" "42"
+stop
+if [ -e $(pids_dir ${PARENT}/${CONTAINER}) ]; then
+    echo "FAIL: container cgroup left behind"
+    exit 1
+fi
+if [ ! -d $(pids_dir ${PARENT}) ]; then
+    echo "FAIL: delete removed the shared parent"
+    exit 1
+fi
+echo "PASS: delete removes the container's cgroup and keeps the parent"
+
+echo "=== An explicit linux.cgroupsPath wins over --cgroup-parent ==="
+jq '.linux.cgroupsPath = "/hackontainer-test-explicit"' \
+    ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+sudo ./hackontainer create --cgroup-parent ${PARENT} --bundle ${BUNDLE} ${CONTAINER} >/dev/null
+check "spec cgroupsPath is kept" "$(state .cgroupPath)" "/hackontainer-test-explicit"
+sudo ./hackontainer delete ${CONTAINER}
+cp ${BUNDLE}/config.json.orig ${BUNDLE}/config.json
+
+echo "=== A relative --cgroup-parent is refused ==="
+if sudo ./hackontainer create --cgroup-parent relative/parent --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1; then
+    sudo ./hackontainer delete ${CONTAINER}
+    echo "FAIL: accepted a relative cgroup parent"
+    exit 1
+fi
+echo "PASS: relative parent refused"
+
+echo "=== Cleaning up ==="
+for dir in /sys/fs/cgroup/hackontainer-test-tenant /sys/fs/cgroup/*/hackontainer-test-tenant; do
+    [ -d ${dir} ] && sudo find ${dir} -depth -type d -exec rmdir {} \;
+done
+echo "=== All cgroup parent tests passed ==="
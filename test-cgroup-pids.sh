@@ -0,0 +1,50 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mypids"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+echo "=== Limiting pids to 2 with an entrypoint that forks right away ==="
+# The shell is one pid and the first sleep the second; every fork after
+# that must fail even though it happens before anything else runs
+jq '.process.terminal = false
+    | .process.args = ["sh", "-c", "sleep 1 & sleep 1 & sleep 1 & wait"]
+    | .linux.resources.pids.limit = 2' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+
+echo "=== Running container (expect a fork failure) ==="
+OUTPUT=$(sudo ./hackontainer run --bundle ${BUNDLE} ${CONTAINER} 2>&1 || true)
+echo "${OUTPUT}"
+if echo "${OUTPUT}" | grep -qi "can't fork\|cannot fork\|resource temporarily unavailable"; then
+    echo "PASS: pids.max held from the first fork"
+else
+    echo "FAIL: entrypoint forked past pids.max"
+    exit 1
+fi
+
+echo "=== Container cgroup must be gone ==="
+if [ -e /sys/fs/cgroup/hackontainer/${CONTAINER} ] || [ -e /sys/fs/cgroup/pids/hackontainer/${CONTAINER} ]; then
+    echo "FAIL: cgroup left behind"
+    exit 1
+fi
+echo "PASS: cgroup removed"
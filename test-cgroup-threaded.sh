@@ -0,0 +1,111 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mythreaded"
+BUNDLE="test-bundles/busybox"
+ANNOTATION="org.hackontainer.cgroup-threaded"
+
+echo "=== Planning the cgroup join for each cgroup.type ==="
+go run ./test/cgroup-threaded
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false | .process.args = ["sleep", "30"]' ${BUNDLE}/config.json > ${BUNDLE}/config.json.orig
+OUT_FILE=$(mktemp)
+SUBTREE=/sys/fs/cgroup/hackontainer-threaded-test
+trap 'sudo ./hackontainer kill ${CONTAINER} KILL >/dev/null 2>&1 || true; sleep 1
+      sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true
+      sudo rmdir ${SUBTREE}/workers/c1 ${SUBTREE}/workers ${SUBTREE} 2>/dev/null || true; rm -f ${OUT_FILE}' EXIT
+
+# configure <jq filter> writes the config from the original
+configure() {
+    jq "$1" ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+}
+
+# expect_create_error <substring> <what> checks create fails saying so
+expect_create_error() {
+    if sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} > ${OUT_FILE} 2>&1; then
+        echo "FAIL: $2: create succeeded"
+        exit 1
+    fi
+    if ! grep -qF -- "$1" ${OUT_FILE}; then
+        echo "FAIL: $2: expected '$1', got: $(grep -v '^>>>' ${OUT_FILE})"
+        exit 1
+    fi
+    if sudo test -e /run/hackontainer/${CONTAINER}; then
+        echo "FAIL: $2: create left the container behind"
+        exit 1
+    fi
+    echo "PASS: $2"
+}
+
+if [ "$(stat -fc %T /sys/fs/cgroup)" != "cgroup2fs" ]; then
+    echo "=== cgroup v1: the annotation is rejected at create ==="
+    configure ".annotations[\"${ANNOTATION}\"] = \"true\""
+    expect_create_error "threaded cgroups require cgroup v2" "threaded join refused without cgroup v2"
+    echo "=== All threaded cgroup tests passed (cgroup v1 host) ==="
+    exit 0
+fi
+
+echo "=== Setting up a threaded subtree ==="
+sudo mkdir -p ${SUBTREE}/workers
+echo threaded | sudo tee ${SUBTREE}/workers/cgroup.type >/dev/null
+if [ "$(cat ${SUBTREE}/cgroup.type)" != "domain threaded" ]; then
+    echo "FAIL: the subtree root didn't become a threaded domain"
+    exit 1
+fi
+echo "PASS: ${SUBTREE} is a threaded domain"
+
+echo "=== A cgroup in the threaded subtree fails at create ==="
+configure '.linux.cgroupsPath = "/hackontainer-threaded-test/workers/c1"'
+expect_create_error "would be created in a threaded subtree" "the threaded subtree is named"
+expect_create_error "${ANNOTATION}=true" "the fallback is offered"
+
+echo "=== Domain limits can't use the fallback ==="
+configure ".linux.cgroupsPath = \"/hackontainer-threaded-test/workers/c1\"
+    | .annotations[\"${ANNOTATION}\"] = \"true\" | .linux.resources.memory = {\"limit\": 67108864}"
+expect_create_error "sets memory limits, which need a domain cgroup" "memory limits refused"
+
+echo "=== cpu limits join through cgroup.threads ==="
+configure ".linux.cgroupsPath = \"/hackontainer-threaded-test/workers/c1\"
+    | .annotations[\"${ANNOTATION}\"] = \"true\" | .linux.resources.cpu = {\"quota\": 50000, \"period\": 100000}"
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+sudo ./hackontainer start ${CONTAINER} >/dev/null 2>&1
+PID=$(sudo ./hackontainer state ${CONTAINER} | jq -r .pid)
+if ! grep -qx "${PID}" ${SUBTREE}/workers/c1/cgroup.threads; then
+    echo "FAIL: the container's thread isn't in the threaded cgroup"
+    exit 1
+fi
+echo "PASS: the container's thread joined the threaded cgroup"
+if [ "$(cat ${SUBTREE}/workers/c1/cgroup.type)" != "threaded" ]; then
+    echo "FAIL: the container's cgroup wasn't made threaded"
+    exit 1
+fi
+echo "PASS: the container's cgroup is threaded"
+if [ "$(cat ${SUBTREE}/workers/c1/cpu.max)" != "50000 100000" ]; then
+    echo "FAIL: the cpu limit wasn't set"
+    exit 1
+fi
+echo "PASS: the cpu limit is set"
+sudo ./hackontainer kill ${CONTAINER} KILL
+sleep 1
+sudo ./hackontainer delete ${CONTAINER}
+
+echo "=== All threaded cgroup tests passed ==="
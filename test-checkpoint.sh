@@ -0,0 +1,132 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mycheckpoint"
+BUNDLE="test-bundles/busybox"
+FAKE=$(mktemp -d)
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false | .process.args = ["sleep", "100"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+trap 'sudo ./hackontainer kill ${CONTAINER} SIGKILL >/dev/null 2>&1 && sleep 1; sudo ./hackontainer delete --force ${CONTAINER} >/dev/null 2>&1; rm -rf ${FAKE}' EXIT
+
+# A stand-in for criu: it records its arguments and an image in the
+# images dir and, unless told to leave it running, kills the tree as a
+# dump does
+cat > ${FAKE}/criu <<'CRIU'
+#!/bin/sh
+pid= images= leave=
+prev=
+for arg in "$@"; do
+    case "$prev" in
+    --tree) pid=$arg ;;
+    --images-dir) images=$arg ;;
+    esac
+    [ "$arg" = "--leave-running" ] && leave=1
+    prev=$arg
+done
+echo "$@" > "$images/args"
+touch "$images/inventory.img"
+[ -n "$leave" ] || kill -9 "$pid"
+CRIU
+chmod +x ${FAKE}/criu
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1: got '$2', want '$3'"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+# refused <what> <want> <command...> runs a command that must fail and
+# name why
+refused() {
+    local desc=$1 want=$2
+    shift 2
+    local out
+    if out=$(sudo "$@" 2>&1); then
+        echo "FAIL: $desc succeeded"
+        exit 1
+    fi
+    if ! echo "$out" | grep -q -- "$want"; then
+        echo "FAIL: $desc: expected '$want' in: $out"
+        exit 1
+    fi
+    echo "PASS: $desc is refused"
+}
+
+state() {
+    sudo ./hackontainer state ${CONTAINER} | grep -v "^>>>" | jq -r "$1"
+}
+
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+
+echo "=== Only a running container can be checkpointed ==="
+refused "checkpointing a created container" "cannot checkpoint a container that is created" \
+    ./hackontainer --criu ${FAKE}/criu checkpoint ${CONTAINER}
+sudo ./hackontainer start ${CONTAINER} >/dev/null 2>&1
+
+echo "=== A missing criu is named ==="
+refused "checkpointing without criu" "criu not found" \
+    ./hackontainer --criu /nonexistent/criu checkpoint ${CONTAINER}
+check "the container still runs" "$(state .status)" "running"
+
+echo "=== --leave-running dumps and keeps the container ==="
+sudo ./hackontainer --criu ${FAKE}/criu checkpoint --leave-running --image-path ${FAKE}/images ${CONTAINER}
+check "the container still runs" "$(state .status)" "running"
+check "the checkpoint is recorded" "$(state .checkpoint.imagePath)" "${FAKE}/images"
+check "the checkpoint left it running" "$(state .checkpoint.leaveRunning)" "true"
+PID=$(state .pid)
+ARGS=$(cat ${FAKE}/images/args)
+for want in "dump" "--tree ${PID}" "--images-dir ${FAKE}/images" "--work-dir ${FAKE}/images" "--leave-running"; do
+    if ! echo " ${ARGS} " | grep -q -- " ${want} "; then
+        echo "FAIL: criu was not given '${want}': ${ARGS}"
+        exit 1
+    fi
+done
+echo "PASS: criu dumps the container's init into the image path"
+
+echo "=== A checkpoint stops the container ==="
+sudo ./hackontainer --criu ${FAKE}/criu checkpoint --work-path ${FAKE}/work --tcp-established ${CONTAINER}
+check "the container stopped" "$(state .status)" "stopped"
+check "the images went to the container's checkpoint directory" "$(state .checkpoint.imagePath)" \
+    "/run/hackontainer/${CONTAINER}/checkpoint"
+ARGS=$(sudo cat /run/hackontainer/${CONTAINER}/checkpoint/args)
+for want in "--work-dir ${FAKE}/work" "--tcp-established"; do
+    if ! echo " ${ARGS} " | grep -q -- " ${want} "; then
+        echo "FAIL: criu was not given '${want}': ${ARGS}"
+        exit 1
+    fi
+done
+if echo " ${ARGS} " | grep -q -- " --leave-running "; then
+    echo "FAIL: criu was told to leave the container running: ${ARGS}"
+    exit 1
+fi
+echo "PASS: criu gets the work path and --tcp-established"
+
+echo "=== A stopped container can't be checkpointed again ==="
+refused "checkpointing a stopped container" "cannot checkpoint a container that is stopped" \
+    ./hackontainer --criu ${FAKE}/criu checkpoint ${CONTAINER}
+
+echo ""
+echo "=== All checkpoint tests passed ==="
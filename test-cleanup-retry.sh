@@ -0,0 +1,63 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mycleanupretry"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+# Without a pid namespace a background process outlives the container
+# process, and keeps the cgroup busy
+jq '.process.terminal = false
+    | .process.args = ["sh", "-c", "exit 0"]
+    | .linux.namespaces |= map(select(.type != "pid"))' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+trap 'sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true' EXIT
+
+# The retries themselves are covered by the unit tests, which make the
+# kernel report EBUSY through faultSys; this checks what a real busy
+# cgroup looks like
+
+echo "=== A process left in the cgroup is named ==="
+jq '.process.args = ["sh", "-c", "sleep 1000 & exit 0"]' ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} >/dev/null
+sudo ./hackontainer start ${CONTAINER} >/dev/null 2>&1
+for i in $(seq 1 50); do
+    [ "$(sudo ./hackontainer state ${CONTAINER} | jq -r .status)" = "stopped" ] && break
+    sleep 0.1
+done
+if OUT=$(sudo ./hackontainer delete ${CONTAINER} 2>&1); then
+    echo "FAIL: delete succeeded with a process in the cgroup"
+    exit 1
+fi
+HOLDER=$(echo "${OUT}" | grep -o "held by pid [0-9]* (sleep)" | head -1 || true)
+if [ -z "${HOLDER}" ]; then
+    echo "FAIL: expected the sleep to be named, got: ${OUT}"
+    exit 1
+fi
+echo "PASS: ${HOLDER}"
+sudo kill -9 $(echo "${HOLDER}" | awk '{print $4}')
+sleep 0.5
+sudo ./hackontainer delete ${CONTAINER} >/dev/null
+echo "PASS: delete succeeded once the holder was gone"
+
+echo "=== All cleanup retry tests passed ==="
@@ -0,0 +1,166 @@
+#!/bin/bash
+set -e
+
+SOURCE="myclonesrc"
+CLONE="myclone"
+BUNDLE="test-bundles/busybox"
+NEW_BUNDLE="test-bundles/clone"
+ROOT="/run/hackontainer"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf ${ROOT}/${SOURCE} ${ROOT}/${CLONE}
+
+cleanup() {
+    for id in ${SOURCE} ${CLONE}; do
+        sudo ./hackontainer kill ${id} SIGKILL >/dev/null 2>&1 || true
+    done
+    sleep 1
+    for id in ${SOURCE} ${CLONE}; do
+        sudo ./hackontainer delete --force ${id} >/dev/null 2>&1 || true
+    done
+    sudo rm -rf ${ROOT}/${CLONE} || true
+    rm -r ${NEW_BUNDLE} ${NEW_BUNDLE}.out 2>/dev/null || true
+}
+trap cleanup EXIT
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false
+    | .process.args = ["sh", "-c", "echo FOO=$FOO SECRET=$SECRET; sleep 30"]
+    | .process.env += ["SECRET=hunter2"]
+    | .annotations = {"example.owner": "me"}' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1: expected '$3', got '$2'"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+# frozen <id> <filter> reads the config frozen for container id
+frozen() {
+    sudo jq -r "$2" ${ROOT}/$1/config.json
+}
+
+env_of() {
+    frozen $1 '.process.env[] | select(startswith("'$2'="))'
+}
+
+sudo ./hackontainer create --bundle ${BUNDLE} -e FOO=source --sensitive-env SECRET \
+    --cgroup-parent /hackontainer-clone ${SOURCE} >/dev/null 2>&1
+
+echo "=== clone copies the config ==="
+sudo ./hackontainer clone ${SOURCE} ${CLONE} >/dev/null 2>&1
+check "the clone is created" "$(sudo ./hackontainer state ${CLONE} | jq -r .status)" "created"
+check "the source's annotations are copied" "$(frozen ${CLONE} '.annotations["example.owner"]')" "me"
+check "the source is recorded" "$(frozen ${CLONE} '.annotations["org.hackontainer.cloned-from"]')" "${SOURCE}"
+check "the source is recorded with the create" \
+    "$(sudo ./hackontainer inspect ${CLONE} | jq -r .createOptions.cloneOf)" "${SOURCE}"
+check "the source's overrides are copied" "$(env_of ${CLONE} FOO)" "FOO=source"
+check "the source's bundle is used" "$(sudo ./hackontainer state ${CLONE} | jq -r .bundle)" "$(sudo ./hackontainer state ${SOURCE} | jq -r .bundle)"
+check "the source's resolved form is not copied" "$(frozen ${CLONE} .resolved.cgroupsPath)" "/hackontainer/${CLONE}"
+check "the cgroup named after the source is not copied" "$(frozen ${CLONE} '.linux.cgroupsPath // ""')" ""
+check "sensitive values stay redacted" "$(env_of ${CLONE} SECRET)" "SECRET=<redacted>"
+check "sensitive names are kept" "$(sudo ./hackontainer inspect ${CLONE} | jq -r '.createOptions.sensitiveEnv | join(",")')" "SECRET"
+
+# The process keeps start's stdout open, so it goes to a file
+sudo sh -c "./hackontainer start ${CLONE} > ${NEW_BUNDLE}.out 2>&1"
+for _ in $(seq 50); do
+    grep -q "^FOO=" ${NEW_BUNDLE}.out && break
+    sleep 0.1
+done
+check "the clone gets the sensitive value" "$(grep "^FOO=" ${NEW_BUNDLE}.out)" "FOO=source SECRET=hunter2"
+sudo ./hackontainer kill ${CLONE} KILL
+sleep 1
+sudo ./hackontainer delete ${CLONE}
+
+echo "=== Overrides apply on top of the source's config ==="
+sudo ./hackontainer clone -e FOO=clone --workdir /tmp --label tier=test ${SOURCE} ${CLONE} >/dev/null 2>&1
+check "-e wins over the source's value" "$(env_of ${CLONE} FOO)" "FOO=clone"
+check "--workdir wins over the source's cwd" "$(frozen ${CLONE} .process.cwd)" "/tmp"
+check "labels are the clone's own" "$(sudo ./hackontainer inspect ${CLONE} | jq -r .labels.tier)" "test"
+check "the source keeps its value" "$(env_of ${SOURCE} FOO)" "FOO=source"
+check "the source keeps its cwd" "$(frozen ${SOURCE} .process.cwd)" "/"
+sudo ./hackontainer delete ${CLONE}
+
+if sudo ./hackontainer clone --args true ${SOURCE} ${CLONE} >/dev/null 2>&1; then
+    echo "FAIL: --args replaced the source's args without --replace-args"
+    exit 1
+fi
+echo "PASS: --args needs --replace-args, as with create"
+sudo ./hackontainer clone --args true --replace-args ${SOURCE} ${CLONE} >/dev/null 2>&1
+check "--replace-args replaces the source's args" "$(frozen ${CLONE} '.process.args | join(" ")')" "true"
+sudo ./hackontainer delete ${CLONE}
+
+echo "=== The clone shares nothing with the source ==="
+BEFORE=$(sudo sha256sum ${ROOT}/${SOURCE}/config.json | cut -d' ' -f1)
+sudo ./hackontainer clone -e FOO=changed --cap-add NET_ADMIN ${SOURCE} ${CLONE} >/dev/null 2>&1
+check "the source's config is untouched by a clone" "$(sudo sha256sum ${ROOT}/${SOURCE}/config.json | cut -d' ' -f1)" "${BEFORE}"
+sudo ./hackontainer delete ${SOURCE}
+check "the clone outlives its source" "$(sudo ./hackontainer state ${CLONE} | jq -r .status)" "created"
+check "the clone keeps its config" "$(env_of ${CLONE} FOO)" "FOO=changed"
+sudo ./hackontainer delete ${CLONE}
+sudo ./hackontainer create --bundle ${BUNDLE} -e FOO=source --sensitive-env SECRET ${SOURCE} >/dev/null 2>&1
+
+echo "=== --bundle moves the rootfs to another bundle ==="
+mkdir -p ${NEW_BUNDLE}
+cp -a ${BUNDLE}/rootfs ${NEW_BUNDLE}/rootfs
+sudo ./hackontainer clone --bundle ${NEW_BUNDLE} ${SOURCE} ${CLONE} >/dev/null 2>&1
+check "the rootfs is found in the new bundle" "$(frozen ${CLONE} .root.path)" "$(realpath ${NEW_BUNDLE})/rootfs"
+check "the new bundle is recorded" "$(sudo ./hackontainer state ${CLONE} | jq -r .bundle)" "$(realpath ${NEW_BUNDLE})"
+sudo ./hackontainer delete ${CLONE}
+
+echo "=== Refusals ==="
+if sudo ./hackontainer clone nosuchcontainer ${CLONE} >/dev/null 2>&1; then
+    echo "FAIL: cloned a container that doesn't exist"
+    exit 1
+fi
+echo "PASS: a missing source is refused"
+
+if sudo ./hackontainer clone ${SOURCE} ${SOURCE} >/dev/null 2>&1; then
+    echo "FAIL: the clone took the source's id"
+    exit 1
+fi
+echo "PASS: the clone can't take an id in use"
+
+if sudo ./hackontainer clone --config ${BUNDLE}/config.json ${SOURCE} ${CLONE} >/dev/null 2>&1; then
+    echo "FAIL: clone accepted --config"
+    exit 1
+fi
+echo "PASS: --config is refused"
+
+# A create writes the frozen config before the state
+sudo mkdir ${ROOT}/${CLONE}-creating
+sudo cp ${ROOT}/${SOURCE}/config.json ${ROOT}/${CLONE}-creating/config.json
+ERR=$(sudo ./hackontainer clone ${CLONE}-creating ${CLONE} 2>&1 || true)
+sudo rm -rf ${ROOT}/${CLONE}-creating
+if ! echo "${ERR}" | grep -q "while it is being created"; then
+    echo "${ERR}"
+    echo "FAIL: a source being created was cloned"
+    exit 1
+fi
+echo "PASS: a source being created is refused"
+if sudo ./hackontainer state ${CLONE} >/dev/null 2>&1; then
+    echo "FAIL: a refused clone left a container"
+    exit 1
+fi
+echo "PASS: nothing was created"
+
+echo "=== All clone tests passed ==="
@@ -0,0 +1,120 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myconfighash"
+BUNDLE="test-bundles/busybox"
+STATE_DIR="/run/hackontainer/${CONTAINER}"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf ${STATE_DIR}
+
+cleanup() {
+    sudo ./hackontainer kill ${CONTAINER} SIGKILL >/dev/null 2>&1 && sleep 1 || true
+    sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true
+}
+trap cleanup EXIT
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+# configure <word> has the container write word to /marker
+configure() {
+    jq --arg word "$1" '.process.terminal = false | .root.readonly = false | .process.args = ["sh", "-c", "echo \($word) > /marker"]' \
+        ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+    mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+}
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1: expected '$3', got '$2'"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+# marker waits for the container's process and prints what it wrote
+marker() {
+    sleep 1
+    sudo cat ${BUNDLE}/rootfs/marker
+}
+
+configure original
+
+echo "=== create records both hashes ==="
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER}
+BUNDLE_SUM=$(sha256sum ${BUNDLE}/config.json | cut -d' ' -f1)
+FROZEN_SUM=$(sudo sha256sum ${STATE_DIR}/config.json | cut -d' ' -f1)
+check "bundle config hash" "$(sudo jq -r .configSha256 ${STATE_DIR}/state.json)" "${BUNDLE_SUM}"
+check "frozen config hash" "$(sudo jq -r .frozenConfigSha256 ${STATE_DIR}/state.json)" "${FROZEN_SUM}"
+check "inspect shows the hash" "$(sudo ./hackontainer inspect ${CONTAINER} | jq -r .configSha256)" "${BUNDLE_SUM}"
+
+echo "=== An edited bundle config warns and has no effect ==="
+configure edited
+WARNING=$(sudo ./hackontainer start ${CONTAINER} 2>&1 >/dev/null)
+if ! echo "${WARNING}" | grep -q "has changed since container ${CONTAINER} was created"; then
+    echo "FAIL: no warning: ${WARNING}"
+    exit 1
+fi
+echo "PASS: ${WARNING}"
+check "original config applies" "$(marker)" "original"
+sudo ./hackontainer delete ${CONTAINER}
+
+echo "=== An unchanged bundle config doesn't warn ==="
+configure original
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER}
+WARNING=$(sudo ./hackontainer start ${CONTAINER} 2>&1 >/dev/null | grep WARNING || true)
+check "no warning" "${WARNING}" ""
+sleep 1
+sudo ./hackontainer delete ${CONTAINER}
+
+echo "=== An update rewrites the frozen config and its hash ==="
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER}
+sudo ./hackontainer update --pids-limit 100 ${CONTAINER}
+FROZEN_SUM=$(sudo sha256sum ${STATE_DIR}/config.json | cut -d' ' -f1)
+check "hash after update" "$(sudo jq -r .frozenConfigSha256 ${STATE_DIR}/state.json)" "${FROZEN_SUM}"
+sudo ./hackontainer start ${CONTAINER}
+check "updated container starts" "$(marker)" "original"
+sudo ./hackontainer delete ${CONTAINER}
+
+# tamper replaces the frozen config with one running something else
+tamper() {
+    sudo jq '.process.args = ["sh", "-c", "echo tampered > /marker"]' ${STATE_DIR}/config.json > /tmp/${CONTAINER}-config.json
+    sudo cp /tmp/${CONTAINER}-config.json ${STATE_DIR}/config.json
+    rm -f /tmp/${CONTAINER}-config.json
+}
+
+for MODE in state-only full; do
+    echo "=== An edited frozen config is refused ($MODE create) ==="
+    sudo rm -f ${BUNDLE}/rootfs/marker
+    sudo ./hackontainer create --create-mode ${MODE} --bundle ${BUNDLE} ${CONTAINER}
+    tamper
+    if OUT=$(sudo ./hackontainer start ${CONTAINER} 2>&1); then
+        echo "FAIL: started with a tampered config"
+        exit 1
+    fi
+    if ! echo "${OUT}" | grep -q "frozen config of container ${CONTAINER} was modified"; then
+        echo "FAIL: unexpected error: ${OUT}"
+        exit 1
+    fi
+    check "state after refusal ($MODE)" "$(sudo ./hackontainer state ${CONTAINER} | jq -r .status)" "created"
+    if sudo test -e ${BUNDLE}/rootfs/marker; then
+        echo "FAIL: tampered process ran: $(sudo cat ${BUNDLE}/rootfs/marker)"
+        exit 1
+    fi
+    echo "PASS: ${OUT}"
+    sudo ./hackontainer kill ${CONTAINER} SIGKILL >/dev/null 2>&1 && sleep 1 || true
+    sudo ./hackontainer delete ${CONTAINER}
+done
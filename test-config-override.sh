@@ -0,0 +1,46 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myconfig"
+BUNDLE="test-bundles/busybox"
+ALT_DIR="test-bundles/alt-config"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE} ${ALT_DIR}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs ${ALT_DIR}
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+echo "=== Writing alternate config outside the bundle (root.path stays relative) ==="
+jq '.process.args = ["echo", "from-alt-config"] | .process.terminal = false' \
+    ${BUNDLE}/config.json > ${ALT_DIR}/config.json
+
+echo "=== Creating container with --config override ==="
+sudo ./hackontainer create --bundle ${BUNDLE} --config ${ALT_DIR}/config.json ${CONTAINER}
+
+echo "=== State should record the override path ==="
+sudo ./hackontainer state ${CONTAINER} | grep -q '"configPath"' && echo "configPath recorded"
+
+echo "=== Editing the override after create must have no effect ==="
+jq '.process.args = ["echo", "edited"]' ${ALT_DIR}/config.json > ${ALT_DIR}/config.json.tmp
+mv ${ALT_DIR}/config.json.tmp ${ALT_DIR}/config.json
+
+echo "=== Starting container (expect from-alt-config) ==="
+sudo ./hackontainer start ${CONTAINER}
+sleep 1
+
+echo "=== Deleting container ==="
+sudo ./hackontainer delete ${CONTAINER}
@@ -0,0 +1,150 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myconsole"
+BUNDLE="test-bundles/busybox"
+WORK=$(mktemp -d)
+SOCK=${WORK}/console.sock
+RECVTTY=${WORK}/recvtty
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+RECEIVER=""
+cleanup() {
+    [ -n "${RECEIVER}" ] && sudo kill ${RECEIVER} 2>/dev/null || true
+    sudo ./hackontainer kill ${CONTAINER} SIGKILL >/dev/null 2>&1 && sleep 1 || true
+    sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true
+    sudo rm -rf ${WORK} || true
+}
+trap cleanup EXIT
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+go build -o ${RECVTTY} ./test/recvtty
+
+# The process reports whether its stdio is a terminal, and from its
+# stat, its session and controlling terminal
+SCRIPT='[ -t 0 ] && [ -t 1 ] && [ -t 2 ] && echo stdio=tty; set -- $(cat /proc/self/stat); echo "session=$6 tty=$7"'
+jq --arg script "${SCRIPT}" \
+    '.process.terminal = true | .process.args = ["sh", "-c", $script]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.terminal
+jq '.process.terminal = false | .process.args = ["true"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.plain
+cp ${BUNDLE}/config.json.terminal ${BUNDLE}/config.json
+
+# receive starts a console socket receiver, writing what it gets to
+# ${WORK}/out
+receive() {
+    sudo rm -f ${SOCK} ${WORK}/out
+    sudo ${RECVTTY} ${SOCK} > ${WORK}/out 2>${WORK}/recvtty.log &
+    RECEIVER=$!
+    for i in $(seq 50); do
+        sudo test -S ${SOCK} && return
+        sleep 0.1
+    done
+    echo "FAIL: receiver didn't listen"
+    exit 1
+}
+
+# check_terminal <what> waits for the receiver and checks the process
+# had the terminal as stdio and controlling terminal
+check_terminal() {
+    for i in $(seq 50); do
+        sudo kill -0 ${RECEIVER} 2>/dev/null || break
+        sleep 0.1
+    done
+    OUT=$(tr -d '\r' < ${WORK}/out)
+    RECEIVER=""
+    if ! echo "${OUT}" | grep -q "stdio=tty"; then
+        echo "FAIL: $1: stdio is not the terminal: ${OUT}"
+        exit 1
+    fi
+    if ! echo "${OUT}" | grep -q "^session=1 tty=[1-9]"; then
+        echo "FAIL: $1: not the controlling terminal of its own session: ${OUT}"
+        exit 1
+    fi
+    if ! grep -q "got /dev/ptmx" ${WORK}/recvtty.log; then
+        echo "FAIL: $1: master not sent with its name: $(cat ${WORK}/recvtty.log)"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+# remove deletes the container once its process has exited
+remove() {
+    sleep 1
+    sudo ./hackontainer delete ${CONTAINER}
+}
+
+echo "=== create and start send the terminal at start ==="
+receive
+sudo ./hackontainer create --console-socket ${SOCK} --bundle ${BUNDLE} ${CONTAINER}
+sudo ./hackontainer start ${CONTAINER}
+check_terminal "state-only create"
+CONSOLE=$(sudo jq -r .consoleSocket /run/hackontainer/${CONTAINER}/state.json)
+if [ "${CONSOLE}" != "${SOCK}" ]; then
+    echo "FAIL: state records console socket '${CONSOLE}'"
+    exit 1
+fi
+remove
+
+echo "=== A full create sends it at create ==="
+receive
+sudo ./hackontainer create --create-mode full --console-socket ${SOCK} --bundle ${BUNDLE} ${CONTAINER}
+sudo ./hackontainer start ${CONTAINER}
+check_terminal "full create"
+remove
+
+echo "=== run sends it too ==="
+receive
+sudo ./hackontainer run --console-socket ${SOCK} --bundle ${BUNDLE} ${CONTAINER}
+check_terminal "run"
+
+echo "=== A detached terminal without a console socket is refused ==="
+if sudo ./hackontainer create --create-mode full --bundle ${BUNDLE} ${CONTAINER} 2>/dev/null; then
+    echo "FAIL: full create without a console socket accepted"
+    exit 1
+fi
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER}
+if sudo ./hackontainer start ${CONTAINER} 2>/dev/null; then
+    echo "FAIL: start without a console socket accepted"
+    exit 1
+fi
+sudo ./hackontainer delete ${CONTAINER}
+echo "PASS: refused without a console socket"
+
+echo "=== Without a terminal the socket isn't used ==="
+cp ${BUNDLE}/config.json.plain ${BUNDLE}/config.json
+sudo ./hackontainer create --console-socket ${WORK}/nobody.sock --bundle ${BUNDLE} ${CONTAINER}
+sudo ./hackontainer start ${CONTAINER}
+remove
+echo "PASS: socket unused without a terminal"
+
+echo "=== A socket nobody listens on fails the start ==="
+cp ${BUNDLE}/config.json.terminal ${BUNDLE}/config.json
+sudo ./hackontainer create --console-socket ${WORK}/nobody.sock --bundle ${BUNDLE} ${CONTAINER}
+if OUT=$(sudo ./hackontainer start ${CONTAINER} 2>&1); then
+    echo "FAIL: started without a receiver"
+    exit 1
+fi
+if ! echo "${OUT}" | grep -q "console socket"; then
+    echo "FAIL: unexpected error: ${OUT}"
+    exit 1
+fi
+sudo ./hackontainer delete ${CONTAINER}
+echo "PASS: ${OUT}"
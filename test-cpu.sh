@@ -0,0 +1,63 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mycpu"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+cp ${BUNDLE}/config.json ${BUNDLE}/config.json.orig
+
+set_cpu() {
+    jq "$1" ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+}
+
+echo "=== Burst above the quota (expect a failure) ==="
+set_cpu '.process.terminal = false | .linux.resources.cpu = {"quota": 50000, "period": 100000, "burst": 60000}'
+if sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER}; then
+    echo "FAIL: accepted a burst above the quota"
+    sudo ./hackontainer delete ${CONTAINER}
+    exit 1
+fi
+echo "PASS: burst above the quota rejected"
+
+echo "=== Burst within the quota, affinity reset ==="
+set_cpu '.process.terminal = false | .process.args = ["sleep", "5"]
+    | .linux.resources.cpu = {"quota": 50000, "period": 100000, "burst": 20000}
+    | .annotations["org.hackontainer.cpu-affinity"] = "reset"'
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER}
+# The runtime runs pinned to one CPU; the container must not inherit that
+sudo taskset -c 0 ./hackontainer start ${CONTAINER}
+CPU=$(sudo ./hackontainer inspect ${CONTAINER} | jq -c .cpu)
+echo "${CPU}"
+sudo ./hackontainer kill ${CONTAINER} KILL
+sleep 1
+sudo ./hackontainer delete ${CONTAINER}
+
+if [ "$(echo "${CPU}" | jq .burstUsec)" != "20000" ]; then
+    echo "FAIL: burst not applied"
+    exit 1
+fi
+echo "PASS: burst applied"
+
+if [ "$(nproc --all)" -gt 1 ] && [ "$(echo "${CPU}" | jq -r .affinity)" = "0" ]; then
+    echo "FAIL: container inherited the runtime's affinity"
+    exit 1
+fi
+echo "PASS: affinity reset"
@@ -0,0 +1,157 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mycreatemode"
+BUNDLE="test-bundles/busybox"
+ROOT="/run/hackontainer"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf ${ROOT}/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+# Hooks that log when they run, on the host
+HOOK_LOG=$(mktemp)
+OUT_FILE=$(mktemp)
+jq --arg log "${HOOK_LOG}" '.process.terminal = false
+    | .process.args = ["sh", "-c", "echo started; sleep 30"]
+    | .hooks.createRuntime = [{"path": "/bin/sh", "args": ["sh", "-c", "echo createRuntime >> \($log)"]}]
+    | .hooks.poststart = [{"path": "/bin/sh", "args": ["sh", "-c", "echo poststart >> \($log)"]}]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+trap 'sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true; sudo rm -f ${HOOK_LOG} ${OUT_FILE}' EXIT
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1: expected '$3', got '$2'"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+state() {
+    sudo ./hackontainer state ${CONTAINER} | jq -r "$1"
+}
+
+hooks_ran() {
+    echo $(sudo cat ${HOOK_LOG})
+}
+
+# wait_status <status> waits up to 5s for the container to get there
+wait_status() {
+    for _ in $(seq 50); do
+        [ "$(state .status)" = "$1" ] && return
+        sleep 0.1
+    done
+}
+
+# lifecycle <mode> creates, starts, kills and deletes the container
+lifecycle() {
+    local mode=$1
+    sudo truncate -s 0 ${HOOK_LOG}
+    echo "=== ${mode}: create ==="
+    # The container's output goes wherever create's went in full mode
+    sudo ./hackontainer create --bundle ${BUNDLE} --create-mode ${mode} ${CONTAINER} > ${OUT_FILE} 2>&1
+    check "${mode}: the mode is recorded in state" "$(state .createMode)" "${mode}"
+    check "${mode}: the mode is recorded with the create" \
+        "$(sudo jq -r .createMode ${ROOT}/${CONTAINER}/create-options.json)" "${mode}"
+    check "${mode}: the container is created" "$(state .status)" "created"
+
+    local pid
+    pid=$(state .pid)
+    if [ "${mode}" = "state-only" ]; then
+        check "state-only: there is no process" "${pid}" "0"
+        check "state-only: there is no monitor" "$(state '.monitorPid // 0')" "0"
+        check "state-only: no hook ran" "$(hooks_ran)" ""
+        if sudo ./hackontainer kill ${CONTAINER} KILL >/dev/null 2>&1; then
+            echo "FAIL: state-only: kill found a process to signal"
+            exit 1
+        fi
+        echo "PASS: state-only: kill has no process to signal"
+    else
+        if [ "${pid}" -le 0 ] || ! sudo test -d /proc/${pid}; then
+            echo "FAIL: full: no process waits for start (pid ${pid})"
+            exit 1
+        fi
+        echo "PASS: full: the process waits for start"
+        check "full: the create hooks ran" "$(hooks_ran)" "createRuntime"
+        if grep -q "^started" ${OUT_FILE}; then
+            echo "FAIL: full: the process ran before start"
+            exit 1
+        fi
+        echo "PASS: full: the process didn't exec yet"
+    fi
+
+    echo "=== ${mode}: start ==="
+    sudo ./hackontainer start ${CONTAINER} > /dev/null 2>&1
+    check "${mode}: the container is running" "$(state .status)" "running"
+    if [ "${mode}" = "full" ]; then
+        check "full: the pid is the one create recorded" "$(state .pid)" "${pid}"
+    fi
+    check "${mode}: the hooks ran by the end of start" "$(hooks_ran)" "createRuntime poststart"
+    if sudo ./hackontainer start ${CONTAINER} >/dev/null 2>&1; then
+        echo "FAIL: ${mode}: a second start succeeded"
+        exit 1
+    fi
+    echo "PASS: ${mode}: a second start fails"
+
+    echo "=== ${mode}: kill and delete ==="
+    sudo ./hackontainer kill ${CONTAINER} KILL
+    wait_status stopped
+    check "${mode}: the container stopped" "$(state .status)" "stopped"
+    check "${mode}: the exit is recorded" "$(state .exitStatus)" "137"
+    sudo ./hackontainer delete ${CONTAINER}
+    if sudo test -e ${ROOT}/${CONTAINER}; then
+        echo "FAIL: ${mode}: delete left the container root"
+        exit 1
+    fi
+    echo "PASS: ${mode}: deleted"
+}
+
+lifecycle state-only
+lifecycle full
+
+echo "=== full: kill before start ==="
+sudo ./hackontainer create --bundle ${BUNDLE} --create-mode full ${CONTAINER} > ${OUT_FILE} 2>&1
+sudo ./hackontainer kill ${CONTAINER} KILL
+wait_status stopped
+check "the waiting process was killed" "$(state .status)" "stopped"
+if sudo ./hackontainer start ${CONTAINER} >/dev/null 2>&1; then
+    echo "FAIL: a killed container started"
+    exit 1
+fi
+echo "PASS: a killed container doesn't start"
+sudo ./hackontainer delete ${CONTAINER}
+
+echo "=== full: delete before start ==="
+sudo ./hackontainer create --bundle ${BUNDLE} --create-mode full ${CONTAINER} > ${OUT_FILE} 2>&1
+PID=$(state .pid)
+sudo ./hackontainer delete ${CONTAINER}
+if sudo test -d /proc/${PID} && ! grep -q '^State:.*Z' /proc/${PID}/status 2>/dev/null; then
+    echo "FAIL: delete left the waiting process ${PID} behind"
+    exit 1
+fi
+echo "PASS: delete killed the waiting process"
+
+echo "=== An unknown mode is rejected ==="
+if sudo ./hackontainer create --bundle ${BUNDLE} --create-mode lazy ${CONTAINER} >/dev/null 2>&1; then
+    echo "FAIL: create accepted an unknown mode"
+    exit 1
+fi
+echo "PASS: unknown mode rejected"
+
+echo "=== All create mode tests passed ==="
@@ -0,0 +1,120 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mycreateoptions"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false | .process.args = ["sleep", "1000"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+ABS_BUNDLE=$(cd ${BUNDLE} && pwd)
+PIDFILE=$(mktemp -u)
+
+echo "=== A create with overrides records them ==="
+sudo ./hackontainer create --bundle ${BUNDLE} --pid-file ${PIDFILE} \
+    -e SECRET=hunter2 --env=TOKEN=abc --workdir /tmp --user 1000:1000 \
+    --cgroup-parent /test-create-options --restart on-failure:3 \
+    ${CONTAINER} --replace-args -- true >/dev/null
+sudo rm -f ${PIDFILE}
+OPTIONS=$(sudo cat /run/hackontainer/${CONTAINER}/create-options.json)
+
+# expect <jq filter> <value> checks a field of the record
+expect() {
+    got=$(echo "${OPTIONS}" | jq -c "$1")
+    if [ "${got}" != "$2" ]; then
+        echo "FAIL: expected $1 to be $2, got ${got}"
+        exit 1
+    fi
+}
+# The version the runtime records is the one start compares against
+VERSION=$(echo "${OPTIONS}" | jq -r .runtimeVersion)
+expect '.runtimeVersion | length > 0' 'true'
+expect .bundle "\"${ABS_BUNDLE}\""
+expect .configPath "\"${ABS_BUNDLE}/config.json\""
+expect .configSha256 "\"$(sha256sum ${BUNDLE}/config.json | cut -d' ' -f1)\""
+expect .args '["true"]'
+expect .replaceArgs 'true'
+expect .env '["SECRET=<redacted>","TOKEN=<redacted>"]'
+expect .cwd '"/tmp"'
+expect .user '"1000:1000"'
+expect .cgroupParent '"/test-create-options"'
+expect .restartPolicy '{"name":"on-failure","maxRetries":3}'
+expect '.commandLine | index("--pid-file") as $i | .[$i + 1]' "\"${PIDFILE}\""
+expect '.commandLine | map(select(startswith("-e") or startswith("--env") or contains("<redacted>")))' \
+    '["-e","SECRET=<redacted>","--env=TOKEN=<redacted>"]'
+echo "PASS: create-options.json records the overrides"
+
+if echo "${OPTIONS}" | grep -q "hunter2\|abc\""; then
+    echo "FAIL: an env override value was recorded: ${OPTIONS}"
+    exit 1
+fi
+echo "PASS: env override values are redacted"
+
+INSPECTED=$(sudo ./hackontainer inspect ${CONTAINER} | jq -S .createOptions)
+if [ "${INSPECTED}" != "$(echo "${OPTIONS}" | jq -S .)" ]; then
+    echo "FAIL: inspect shows different create options: ${INSPECTED}"
+    exit 1
+fi
+echo "PASS: inspect shows the create options"
+
+echo "=== Starting under the same version doesn't warn ==="
+out=$(sudo ./hackontainer start ${CONTAINER} 2>&1 | grep -v "^>>>" || true)
+if echo "${out}" | grep -q "WARNING: container ${CONTAINER} was created by"; then
+    echo "FAIL: unexpected version warning: ${out}"
+    exit 1
+fi
+# The overridden args exit at once
+for i in $(seq 1 50); do
+    [ "$(sudo ./hackontainer state ${CONTAINER} | jq -r .status)" = "stopped" ] && break
+    sleep 0.1
+done
+sudo ./hackontainer delete ${CONTAINER}
+echo "PASS: no warning"
+
+echo "=== Starting under another version warns ==="
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} >/dev/null
+sudo sh -c "jq '.runtimeVersion = \"0.0.1\"' /run/hackontainer/${CONTAINER}/create-options.json > /run/hackontainer/${CONTAINER}/create-options.json.tmp"
+sudo mv /run/hackontainer/${CONTAINER}/create-options.json.tmp /run/hackontainer/${CONTAINER}/create-options.json
+# The container holds on to start's output, so it can't go to a pipe
+sudo ./hackontainer start ${CONTAINER} >/tmp/${CONTAINER}.out 2>&1
+out=$(grep -v "^>>>" /tmp/${CONTAINER}.out || true)
+rm -f /tmp/${CONTAINER}.out
+sudo ./hackontainer kill ${CONTAINER} KILL
+sleep 1
+sudo ./hackontainer delete ${CONTAINER}
+if ! echo "${out}" | grep -q "WARNING: container ${CONTAINER} was created by hackontainer 0.0.1 but is started by ${VERSION}"; then
+    echo "FAIL: expected a version warning, got: ${out}"
+    exit 1
+fi
+echo "PASS: start warns about the version mismatch"
+
+echo "=== --record-env-values keeps the values ==="
+sudo ./hackontainer create --bundle ${BUNDLE} -e SECRET=hunter2 --record-env-values ${CONTAINER} >/dev/null
+ENV=$(sudo cat /run/hackontainer/${CONTAINER}/create-options.json | jq -c .env)
+sudo ./hackontainer delete ${CONTAINER}
+if [ "${ENV}" != '["SECRET=hunter2"]' ]; then
+    echo "FAIL: expected the value to be kept, got ${ENV}"
+    exit 1
+fi
+echo "PASS: env values recorded on request"
+
+echo "=== All create options tests passed ==="
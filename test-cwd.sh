@@ -0,0 +1,132 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mycwd"
+CLONE="mycwd-clone"
+BUNDLE="test-bundles/busybox"
+RUNTIME="$(pwd)/hackontainer"
+GONE="/tmp/hackontainer-cwd-gone"
+OUT="/tmp/hackontainer-cwd.out"
+REL_ROOT="test-bundles/cwd-root"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER} /run/hackontainer/${CLONE}
+
+cleanup() {
+    for id in ${CONTAINER} ${CLONE}; do
+        sudo ./hackontainer kill ${id} SIGKILL >/dev/null 2>&1 || true
+    done
+    sleep 1
+    for id in ${CONTAINER} ${CLONE}; do
+        sudo ./hackontainer delete --force ${id} >/dev/null 2>&1 || true
+    done
+    sudo rm -rf ${GONE} ${GONE}-spec ${OUT} ${REL_ROOT} || true
+}
+trap cleanup EXIT
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false | .process.args = ["sleep", "30"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+ABS_BUNDLE=$(realpath ${BUNDLE})
+
+# gone <args> runs the runtime from a working directory that was deleted
+# after the shell entered it, as a supervisor might leave it
+gone() {
+    (mkdir -p ${GONE} && cd ${GONE} && rmdir ${GONE} && sudo ${RUNTIME} "$@")
+}
+
+# works <what> <args> checks the runtime succeeds from a deleted
+# directory. The output goes to a file, since a started process keeps
+# the runtime's stdout open.
+works() {
+    local what=$1
+    shift
+    if ! gone "$@" > ${OUT} 2>&1; then
+        cat ${OUT}
+        echo "FAIL: ${what} failed from a deleted working directory"
+        exit 1
+    fi
+    echo "PASS: ${what}"
+}
+
+# refused <what> <error> <args> checks the runtime fails with error
+refused() {
+    local what=$1 error=$2
+    shift 2
+    ERR=$(gone "$@" 2>&1 || true)
+    if ! echo "${ERR}" | grep -q "${error}"; then
+        echo "${ERR}"
+        echo "FAIL: ${what}"
+        exit 1
+    fi
+    echo "PASS: ${what}"
+}
+
+echo "=== Every command works from a deleted working directory ==="
+works "create" create --bundle ${ABS_BUNDLE} ${CONTAINER}
+works "state" state ${CONTAINER}
+works "list" list
+works "inspect" inspect ${CONTAINER}
+works "clone" clone ${CONTAINER} ${CLONE}
+works "delete of the clone" delete ${CLONE}
+works "start" start ${CONTAINER}
+works "exec" exec ${CONTAINER} true
+works "ps" ps ${CONTAINER}
+works "stats" stats ${CONTAINER}
+works "update" update --pids-limit 100 ${CONTAINER}
+works "pause" pause ${CONTAINER}
+works "resume" resume ${CONTAINER}
+works "status" status
+works "kill" kill ${CONTAINER} KILL
+sleep 1
+works "delete" delete ${CONTAINER}
+works "features" features
+mkdir -p ${GONE}-spec
+works "spec" spec --bundle ${GONE}-spec
+check_spec=$(jq -r .ociVersion ${GONE}-spec/config.json)
+[ -n "${check_spec}" ] || { echo "FAIL: spec wrote no config"; exit 1; }
+
+jq '.process.args = ["true"]' ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+cp ${BUNDLE}/config.json ${BUNDLE}/config.json.orig
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+works "run" run --bundle ${ABS_BUNDLE} ${CONTAINER}
+mv ${BUNDLE}/config.json.orig ${BUNDLE}/config.json
+
+echo "=== Relative paths need a working directory ==="
+refused "run without --bundle" "no --bundle given and the working directory is unusable" run ${CONTAINER}
+refused "a relative --bundle" 'cannot resolve --bundle "busybox"' run --bundle busybox ${CONTAINER}
+refused "a relative --root" 'cannot resolve --root "state"' --root state list
+if sudo ./hackontainer state ${CONTAINER} >/dev/null 2>&1; then
+    echo "FAIL: a refused create left a container"
+    exit 1
+fi
+echo "PASS: nothing was created"
+
+echo "=== A relative --root is resolved when the runtime starts ==="
+sudo ./hackontainer --root ${REL_ROOT} create --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+works "the container is under the resolved --root" --root $(realpath ${REL_ROOT}) state ${CONTAINER}
+check_bundle=$(jq -r .bundle ${OUT})
+if [ "${check_bundle}" != "${ABS_BUNDLE}" ]; then
+    echo "FAIL: the relative --bundle was recorded as '${check_bundle}'"
+    exit 1
+fi
+echo "PASS: the relative --bundle is recorded absolute"
+sudo ./hackontainer --root ${REL_ROOT} delete ${CONTAINER}
+
+echo "=== All cwd tests passed ==="
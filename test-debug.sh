@@ -0,0 +1,116 @@
+#!/bin/bash
+set -e
+
+SOURCE="mydebugsrc"
+BUNDLE="test-bundles/busybox"
+ROOT="/run/hackontainer"
+PARENT="/hackontainer-debug"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf ${ROOT}/${SOURCE}
+
+cleanup() {
+    sudo ./hackontainer kill ${SOURCE} SIGKILL >/dev/null 2>&1 || true
+    sleep 1
+    sudo ./hackontainer delete --force ${SOURCE} >/dev/null 2>&1 || true
+}
+trap cleanup EXIT
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false
+    | .process.args = ["sleep", "30"]
+    | .process.env += ["SECRET=hunter2"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1: expected '$3', got '$2'"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+# cgroup_dirs lists the directories of the cgroup at path, one per
+# hierarchy on cgroup v1
+cgroup_dirs() {
+    ls -d /sys/fs/cgroup$1 /sys/fs/cgroup/*$1 2>/dev/null || true
+}
+
+sudo ./hackontainer create --bundle ${BUNDLE} --sensitive-env SECRET \
+    --cgroup-parent ${PARENT} ${SOURCE} >/dev/null 2>&1
+sudo ./hackontainer start ${SOURCE}
+CGROUP=$(sudo ./hackontainer state ${SOURCE} | jq -r .cgroupPath)
+check "the source is below the cgroup parent" "${CGROUP}" "${PARENT}/${SOURCE}"
+
+echo "=== An existing container needs --ephemeral-id ==="
+if sudo ./hackontainer debug ${SOURCE} -- true >/dev/null 2>&1; then
+    echo "FAIL: debug reused the id of an existing container"
+    exit 1
+fi
+echo "PASS: refused"
+
+echo "=== A directory named like the container doesn't stand in for it ==="
+mkdir -p ${SOURCE}
+cp ${BUNDLE}/config.json ${SOURCE}/config.json
+OUTPUT=$(sudo ./hackontainer debug ${SOURCE} -- true 2>&1 || true)
+rm -rf ${SOURCE}
+if ! echo "${OUTPUT}" | grep -q "already exists"; then
+    echo "FAIL: debug took the directory ${SOURCE} for the container: ${OUTPUT}"
+    exit 1
+fi
+echo "PASS: the container is found first"
+
+echo "=== The command after -- keeps its arguments whole ==="
+OUTPUT=$(sudo ./hackontainer debug --ephemeral-id ${SOURCE} -- sh -c 'echo "a  b"' 2>/dev/null)
+check "a quoted argument" "$(echo "${OUTPUT}" | tr -d '\r' | tail -1)" "a  b"
+
+echo "=== The debug container gets the real sensitive values ==="
+OUTPUT=$(sudo ./hackontainer debug --ephemeral-id ${SOURCE} -- env 2>/dev/null)
+check "the sensitive value is not the placeholder" "$(echo "${OUTPUT}" | grep "^SECRET=")" "SECRET=hunter2"
+
+echo "=== The debug container has a cgroup of its own ==="
+OUTPUT=$(sudo ./hackontainer debug --ephemeral-id ${SOURCE} -- cat /proc/self/cgroup 2>/dev/null)
+if echo "${OUTPUT}" | grep -q ":${CGROUP}\$"; then
+    echo "${OUTPUT}"
+    echo "FAIL: the debug container joined the source's cgroup"
+    exit 1
+fi
+if ! echo "${OUTPUT}" | grep -q "/${SOURCE}-debug-[0-9a-f]*\$"; then
+    echo "${OUTPUT}"
+    echo "FAIL: the debug container's cgroup is not named after it"
+    exit 1
+fi
+echo "PASS: a cgroup of its own"
+
+echo "=== Deleting the debug container leaves the source alone ==="
+if [ -z "$(cgroup_dirs ${CGROUP})" ]; then
+    echo "FAIL: the source's cgroup was removed with the debug container"
+    exit 1
+fi
+echo "PASS: the source's cgroup is still there"
+check "the source still runs" "$(sudo ./hackontainer state ${SOURCE} | jq -r .status)" "running"
+PID=$(sudo ./hackontainer state ${SOURCE} | jq .pid)
+if ! grep -q ":${CGROUP}\$" /proc/${PID}/cgroup; then
+    cat /proc/${PID}/cgroup
+    echo "FAIL: the source's process left its cgroup"
+    exit 1
+fi
+echo "PASS: the source's process is still in its cgroup"
+
+echo "=== All debug tests passed ==="
@@ -0,0 +1,192 @@
+#!/bin/bash
+set -e
+
+BUNDLE="test-bundles/busybox"
+ROOT="/run/hackontainer-delegate"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf ${ROOT}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+# A fake OCI runtime that logs how it was called and keeps a status file
+# per container under its --root
+FAKE_DIR=$(mktemp -d)
+FAKE=${FAKE_DIR}/fake-runtime
+LOG=${FAKE_DIR}/calls.log
+cat > ${FAKE} <<EOF
+#!/bin/bash
+echo "\$*" >> ${LOG}
+root=\$2 cmd=\$3
+shift 3
+bundle=
+while [ \$# -gt 1 ]; do
+    case \$1 in
+    --bundle) bundle=\$2; shift 2 ;;
+    --pid-file) echo 4242 > \$2; shift 2 ;;
+    --all|--force) shift ;;
+    *) break ;;
+    esac
+done
+id=\$1 dir=\$root/\$1
+case \$cmd in
+create)
+    case \$id in bad*) echo "fake: cannot create \$id" >&2; exit 5 ;; esac
+    mkdir -p \$dir && echo created > \$dir/status && echo \$bundle > \$dir/bundle ;;
+run)
+    echo "fake: ran \$id"; exit 42 ;;
+start)
+    [ "\$(cat \$dir/status)" = created ] || { echo "fake: \$id is not created" >&2; exit 3; }
+    echo running > \$dir/status ;;
+kill)
+    echo stopped > \$dir/status ;;
+delete)
+    rm -rf \$dir ;;
+state)
+    [ -d \$dir ] || { echo "fake: \$id does not exist" >&2; exit 4; }
+    printf '{"ociVersion":"1.0.2","id":"%s","status":"%s","pid":4242,"bundle":"%s","created":"2024-01-02T03:04:05Z"}\n' \$id \$(cat \$dir/status) \$(cat \$dir/bundle) ;;
+esac
+EOF
+chmod +x ${FAKE}
+
+jq --arg rt "${FAKE}" '.process.terminal = false | .annotations["org.hackontainer.delegate-runtime"] = $rt' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+ABS_BUNDLE=$(cd ${BUNDLE} && pwd)
+
+hk() {
+    sudo ./hackontainer --root ${ROOT} "$@"
+}
+
+cleanup() {
+    sudo rm -rf ${ROOT} ${FAKE_DIR}
+}
+trap cleanup EXIT
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1: got '$2', want '$3'"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+# last_call <command> prints the delegate's last invocation with command
+last_call() {
+    grep -- "/delegate $1 " ${LOG} | tail -1
+}
+
+echo "=== The annotation hands create to the delegate ==="
+hk create --bundle ${BUNDLE} --label tier=web --pid-file ${FAKE_DIR}/pid deleg >/dev/null
+check "create is passed on" "$(last_call create)" "--root ${ROOT}/deleg/delegate create --bundle ${ABS_BUNDLE} --pid-file ${FAKE_DIR}/pid deleg"
+check "the delegate wrote the pid file" "$(cat ${FAKE_DIR}/pid)" "4242"
+check "state comes from the delegate" "$(hk state deleg | jq -r .status)" "created"
+check "state is passed on" "$(last_call state)" "--root ${ROOT}/deleg/delegate state deleg"
+
+echo "=== Later commands are routed to the delegate ==="
+hk start deleg
+check "start is passed on" "$(last_call start)" "--root ${ROOT}/deleg/delegate start deleg"
+check "the container runs" "$(hk state deleg | jq -r .status)" "running"
+
+JSON=$(hk list --format json | grep -v "^>>>")
+check "list names the delegate" "$(echo "${JSON}" | jq -r '.[] | select(.id == "deleg") | .runtime')" "${FAKE}"
+check "list keeps the labels" "$(echo "${JSON}" | jq -r '.[] | select(.id == "deleg") | .labels.tier')" "web"
+check "the table's runtime column" "$(hk list | awk '$1 == "deleg" {print $3, $6}')" "running ${FAKE}"
+
+hk update --pids-limit 7 deleg
+check "update is passed on as a file" "$(last_call update | sed 's/--resources [^ ]*/--resources FILE/')" "--root ${ROOT}/deleg/delegate update --resources FILE deleg"
+
+hk checkpoint --leave-running --image-path /tmp/images deleg
+check "checkpoint is passed on" "$(last_call checkpoint)" "--root ${ROOT}/deleg/delegate checkpoint --image-path /tmp/images --leave-running deleg"
+
+hk kill --all deleg SIGKILL
+check "kill is passed on" "$(last_call kill)" "--root ${ROOT}/deleg/delegate kill --all deleg SIGKILL"
+
+if OUT=$(hk inspect deleg 2>&1); then
+    echo "FAIL: inspect of a delegated container succeeded"
+    exit 1
+fi
+if ! echo "${OUT}" | grep -q "delegated to ${FAKE}"; then
+    echo "FAIL: inspect doesn't say the container is delegated: ${OUT}"
+    exit 1
+fi
+echo "PASS: commands the delegate has no counterpart for are refused"
+
+echo "=== Errors and exit codes pass through ==="
+set +e
+OUT=$(hk start deleg 2>&1)
+RC=$?
+set -e
+check "the delegate's exit code" "${RC}" "3"
+check "the delegate's message" "${OUT}" "fake: deleg is not created"
+
+hk delete deleg
+check "delete is passed on" "$(last_call delete)" "--root ${ROOT}/deleg/delegate delete deleg"
+if [ -d ${ROOT}/deleg ]; then
+    echo "FAIL: delete left the delegation behind"
+    exit 1
+fi
+echo "PASS: delete removes the delegation"
+
+echo "=== A failed delegated create gives the ID back ==="
+set +e
+hk create --bundle ${BUNDLE} bad1 >/dev/null 2>&1
+RC=$?
+set -e
+check "the failed create's exit code" "${RC}" "5"
+if [ -d ${ROOT}/bad1 ]; then
+    echo "FAIL: a failed create left its delegation behind"
+    exit 1
+fi
+echo "PASS: a failed create leaves nothing"
+
+echo "=== A delegated run exits with the delegate's code ==="
+set +e
+OUT=$(hk run --bundle ${BUNDLE} deleg-run 2>&1)
+RC=$?
+set -e
+check "run's exit code" "${RC}" "42"
+check "run's output" "${OUT}" "fake: ran deleg-run"
+if [ -d ${ROOT}/deleg-run ]; then
+    echo "FAIL: a run the delegate cleaned up after left its delegation behind"
+    exit 1
+fi
+echo "PASS: the finished run leaves nothing"
+
+echo "=== The flag delegates a bundle without the annotation ==="
+jq 'del(.annotations["org.hackontainer.delegate-runtime"])' ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+hk create --bundle ${BUNDLE} --delegate-runtime ${FAKE} deleg-flag >/dev/null
+check "the flag's create is passed on" "$(last_call create)" "--root ${ROOT}/deleg-flag/delegate create --bundle ${ABS_BUNDLE} deleg-flag"
+hk delete deleg-flag
+
+echo "=== Flags the delegate wouldn't honour are refused ==="
+if OUT=$(hk create --bundle ${BUNDLE} --delegate-runtime ${FAKE} --restart always deleg-opt 2>&1); then
+    echo "FAIL: --restart was accepted for a delegated container"
+    exit 1
+fi
+if ! echo "${OUT}" | grep -q -- "--restart is not supported"; then
+    echo "FAIL: the refusal doesn't name --restart: ${OUT}"
+    exit 1
+fi
+if [ -d ${ROOT}/deleg-opt ]; then
+    echo "FAIL: the refused create left a delegation behind"
+    exit 1
+fi
+echo "PASS: runtime-only flags are refused"
+
+echo "=== All delegate tests passed ==="
@@ -0,0 +1,81 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mydelete"
+BUNDLE="test-bundles/busybox"
+COUNT="/tmp/hackontainer-poststop-${CONTAINER}"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+sudo rm -f ${COUNT}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+echo "=== Adding a slow poststop hook that counts its runs ==="
+jq --arg count "${COUNT}" '.process.terminal = false
+    | .process.args = ["true"]
+    | .hooks.poststop = [{"path": "/bin/sh", "args": ["sh", "-c", "sleep 1; echo ran >> \($count)"]}]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+
+check_gone() {
+    if [ -e /run/hackontainer/${CONTAINER} ] || ls -d /sys/fs/cgroup/*/hackontainer/${CONTAINER} /sys/fs/cgroup/hackontainer/${CONTAINER} 2>/dev/null | grep -q .; then
+        echo "FAIL: $1 left the container behind"
+        exit 1
+    fi
+    if [ "$(sudo wc -l < ${COUNT})" != "1" ]; then
+        echo "FAIL: $1 ran poststop $(sudo wc -l < ${COUNT}) times"
+        exit 1
+    fi
+    sudo rm -f ${COUNT}
+}
+
+echo "=== Deleting while the monitor is still recording the exit ==="
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER}
+sudo ./hackontainer start ${CONTAINER}
+# The process exits at once; delete has to wait for the monitor's hook
+sudo ./hackontainer delete ${CONTAINER}
+check_gone "delete racing the monitor"
+echo "PASS: delete waited for the monitor"
+
+echo "=== Deleting twice ==="
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER}
+sudo ./hackontainer delete ${CONTAINER}
+if OUTPUT=$(sudo ./hackontainer delete ${CONTAINER} 2>&1); then
+    echo "FAIL: second delete succeeded"
+    exit 1
+fi
+if ! echo "${OUTPUT}" | grep -q "does not exist"; then
+    echo "FAIL: unexpected error: ${OUTPUT}"
+    exit 1
+fi
+check_gone "a repeated delete"
+echo "PASS: second delete reports the container is gone"
+
+echo "=== Killing delete after each step ==="
+for step in marked poststop cgroup quota files state; do
+    sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER}
+    sudo ./hackontainer start ${CONTAINER}
+    sleep 2
+    if sudo HACKONTAINER_TEST_DELETE_CRASH=${step} ./hackontainer delete ${CONTAINER}; then
+        echo "FAIL: delete was not killed after ${step}"
+        exit 1
+    fi
+    sudo ./hackontainer delete ${CONTAINER}
+    check_gone "a delete killed after ${step}"
+    echo "PASS: delete killed after ${step} was finished"
+done
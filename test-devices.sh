@@ -0,0 +1,61 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mydevices"
+BUNDLE="test-bundles/busybox"
+# Optional: a statically linked libfuse "hello" example to mount inside
+# the container, e.g. FUSE_HELLO=/usr/local/bin/hello
+FUSE_HELLO="${FUSE_HELLO:-}"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+SCRIPT='test -c /dev/fuse && test -c /dev/net/tun && echo nodes-ok
+cat /dev/net/tun 2>&1 | grep -q "bad state" && echo tun-open-ok'
+if [ -n "${FUSE_HELLO}" ]; then
+    cp "${FUSE_HELLO}" ${BUNDLE}/rootfs/bin/hello
+    SCRIPT="${SCRIPT}
+mkdir -p /mnt/hello && hello /mnt/hello && cat /mnt/hello/hello && umount /mnt/hello && echo fuse-ok"
+fi
+
+echo "=== Adding /dev/fuse and /dev/net/tun under the default deny-all device rule ==="
+# Majors and minors are left out; the runtime knows these two devices
+jq --arg script "${SCRIPT}" '.process.terminal = false
+    | .process.args = ["sh", "-c", $script]
+    | .linux.devices = [{"path": "/dev/fuse"}, {"path": "/dev/net/tun"}]
+    | .process.capabilities.bounding += ["CAP_SYS_ADMIN", "CAP_NET_ADMIN"]
+    | .process.capabilities.effective += ["CAP_SYS_ADMIN", "CAP_NET_ADMIN"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+
+echo "=== Running container ==="
+OUTPUT=$(sudo ./hackontainer run --bundle ${BUNDLE} ${CONTAINER} 2>&1 || true)
+echo "${OUTPUT}"
+
+for check in nodes-ok tun-open-ok; do
+    if ! echo "${OUTPUT}" | grep -q "${check}"; then
+        echo "FAIL: ${check} missing"
+        exit 1
+    fi
+done
+if [ -n "${FUSE_HELLO}" ] && ! echo "${OUTPUT}" | grep -q fuse-ok; then
+    echo "FAIL: fuse mount did not work"
+    exit 1
+fi
+echo "PASS: devices usable inside the container"
@@ -0,0 +1,127 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myenvfile"
+BUNDLE="test-bundles/busybox"
+ROOT="/run/hackontainer"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf ${ROOT}/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false | .process.args = ["env"] |
+    .process.env = ["PATH=/bin:/usr/bin", "KEEP=spec"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+
+ENV_DIR=$(mktemp -d)
+trap 'rm -rf ${ENV_DIR}' EXIT
+
+# run_with runs the container with the given options and prints what
+# it printed, without the runtime's progress lines
+run_with() {
+    local output
+    output=$(sudo ./hackontainer run --bundle ${BUNDLE} "$@" 2>/dev/null | grep -v "^>>>")
+    sudo ./hackontainer delete ${CONTAINER}
+    echo "${output}"
+}
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1"
+        diff <(echo "$3") <(echo "$2") || true
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+echo "=== Comments, export and quoting ==="
+cat > ${ENV_DIR}/app.env <<'EOF'
+# a comment, then a blank line
+
+PLAIN=a b
+export EXPORTED=yes
+SINGLE='$HOME \n kept'
+DOUBLE="tab\there \"quoted\" \$HOME" # trailing comment
+KEEP=file
+EOF
+OUTPUT=$(run_with --env-file ${ENV_DIR}/app.env ${CONTAINER})
+EXPECTED=$(printf '%s\n' "PATH=/bin:/usr/bin" "KEEP=file" "PLAIN=a b" "EXPORTED=yes" \
+    'SINGLE=$HOME \n kept' "$(printf 'DOUBLE=tab\there "quoted" $HOME')")
+check "file variables set like -e" "${OUTPUT}" "${EXPECTED}"
+
+echo "=== -e overrides the env file ==="
+OUTPUT=$(run_with --env-file ${ENV_DIR}/app.env -e PLAIN=flag ${CONTAINER} | grep "^PLAIN=")
+check "-e wins" "${OUTPUT}" "PLAIN=flag"
+
+echo "=== Malformed env files are refused with the line ==="
+printf 'GOOD=1\nno equals here\n' > ${ENV_DIR}/bad.env
+OUTPUT=$(sudo ./hackontainer create --bundle ${BUNDLE} --env-file ${ENV_DIR}/bad.env ${CONTAINER} 2>&1 || true)
+sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true
+if ! echo "${OUTPUT}" | grep -q "bad.env:2"; then
+    echo "FAIL: expected the bad line to be named, got: ${OUTPUT}"
+    exit 1
+fi
+echo "PASS: bad line named"
+printf 'OPEN="never closed\n' > ${ENV_DIR}/bad.env
+if sudo ./hackontainer create --bundle ${BUNDLE} --env-file ${ENV_DIR}/bad.env ${CONTAINER} >/dev/null 2>&1; then
+    sudo ./hackontainer delete ${CONTAINER}
+    echo "FAIL: accepted an unterminated quote"
+    exit 1
+fi
+echo "PASS: unterminated quote refused"
+
+echo "=== A sensitive variable must be set ==="
+if sudo ./hackontainer create --bundle ${BUNDLE} --sensitive-env MISSING ${CONTAINER} >/dev/null 2>&1; then
+    sudo ./hackontainer delete ${CONTAINER}
+    echo "FAIL: accepted a sensitive variable that isn't set"
+    exit 1
+fi
+echo "PASS: unset sensitive variable refused"
+
+echo "=== Sensitive values reach the container and nothing else ==="
+SECRET="s3cr3t-$$-value"
+printf 'API_TOKEN="%s"\nPUBLIC=visible\n' "${SECRET}" > ${ENV_DIR}/secret.env
+sudo ./hackontainer create --bundle ${BUNDLE} --env-file ${ENV_DIR}/secret.env \
+    -e DB_PASSWORD="${SECRET}-db" --sensitive-env API_TOKEN,DB_PASSWORD --record-env-values \
+    ${CONTAINER} >/dev/null 2>&1
+trap 'sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true; rm -rf ${ENV_DIR}' EXIT
+
+MODE=$(sudo stat -c %a ${ROOT}/${CONTAINER}/sensitive-env.json)
+check "the side file is readable by root alone" "${MODE}" "600"
+
+ENV=$(sudo ./hackontainer inspect ${CONTAINER} | jq -c '.process.env')
+check "inspect shows the values redacted" "${ENV}" \
+    '["PATH=/bin:/usr/bin","KEEP=spec","API_TOKEN=<redacted>","PUBLIC=visible","DB_PASSWORD=<redacted>"]'
+RECORD=$(sudo ./hackontainer inspect ${CONTAINER} | jq -c '.createOptions | {env, sensitiveEnv}')
+check "create options keep other values but not sensitive ones" "${RECORD}" \
+    '{"env":["API_TOKEN=<redacted>","PUBLIC=visible","DB_PASSWORD=<redacted>"],"sensitiveEnv":["API_TOKEN","DB_PASSWORD"]}'
+
+sudo ./hackontainer start ${CONTAINER} > ${ENV_DIR}/out 2>&1
+for i in $(seq 1 50); do
+    [ "$(sudo ./hackontainer state ${CONTAINER} | jq -r .status)" = "stopped" ] && break
+    sleep 0.1
+done
+OUTPUT=$(grep -E "^(API_TOKEN|DB_PASSWORD)=" ${ENV_DIR}/out || true)
+check "the container sees the values" "${OUTPUT}" \
+    "$(printf '%s\n' "API_TOKEN=${SECRET}" "DB_PASSWORD=${SECRET}-db")"
+
+LEAKS=$(sudo grep -rl --exclude=sensitive-env.json -- "${SECRET}" ${ROOT} || true)
+check "no runtime file but the side file holds the values" "${LEAKS}" ""
+
+echo "=== All env file tests passed ==="
@@ -0,0 +1,47 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myenv"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+echo "=== Setting an env with an empty value, a duplicate and a reserved name ==="
+jq '.process.terminal = false | .process.args = ["env", "-0"] |
+    .process.env = ["PATH=/bin:/usr/bin", "EMPTY=", "DUP=first", "TERM=xterm",
+                    "HACKONTAINER_SECRET=x", "DUP=last"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+
+echo "=== Running env -0 with variables set in the runtime's environment ==="
+# The runtime logs its progress to stdout as well, hence the grep. The
+# NULs become newlines first so every variable is a line of its own
+OUTPUT=$(sudo env LEAKED=1 HACKONTAINER_TEST_LEAK=1 ./hackontainer run --bundle ${BUNDLE} ${CONTAINER} 2>/dev/null \
+    | tr '\0' '\n' | grep -v "^>>>")
+
+EXPECTED=$(printf '%s\n' "PATH=/bin:/usr/bin" "EMPTY=" "DUP=last" "TERM=xterm")
+if [ "${OUTPUT}" != "${EXPECTED}" ]; then
+    echo "FAIL: container env differs from the spec env"
+    diff <(echo "${EXPECTED}") <(echo "${OUTPUT}") || true
+    exit 1
+fi
+echo "PASS: container env is exactly the spec env, in order"
+
+echo "=== All env tests passed ==="
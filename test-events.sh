@@ -0,0 +1,80 @@
+#!/bin/bash
+set -e
+
+PREFIX="myevents"
+COUNT=5
+BUNDLE="test-bundles/busybox"
+OUTPUT="/tmp/hackontainer-events-${PREFIX}.jsonl"
+# test-lock-files.sh runs the lifecycles again in a root using lock files
+ROOT="${ROOT:-/run/hackontainer}"
+LOCK_ENV="${LOCK_ENV:-}"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+for i in $(seq ${COUNT}); do
+    sudo rm -rf ${ROOT}/${PREFIX}${i}
+done
+rm -f ${OUTPUT}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false | .process.args = ["true"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+
+echo "=== Following events ==="
+sudo env ${LOCK_ENV} ./hackontainer --root ${ROOT} events --all --follow --filter "id=${PREFIX}*" > ${OUTPUT} &
+FOLLOWER=$!
+sleep 1
+
+echo "=== Running ${COUNT} container lifecycles concurrently ==="
+PIDS=""
+for i in $(seq ${COUNT}); do
+    (
+        sudo env ${LOCK_ENV} ./hackontainer --root ${ROOT} create --bundle ${BUNDLE} ${PREFIX}${i}
+        sudo env ${LOCK_ENV} ./hackontainer --root ${ROOT} start ${PREFIX}${i}
+        sleep 1
+        sudo env ${LOCK_ENV} ./hackontainer --root ${ROOT} delete ${PREFIX}${i}
+    ) >/dev/null 2>&1 &
+    PIDS="${PIDS} $!"
+done
+wait ${PIDS}
+sleep 1
+
+sudo kill ${FOLLOWER}
+wait ${FOLLOWER} || true
+
+echo "=== Checking every lifecycle was streamed in order ==="
+FAILED=0
+for i in $(seq ${COUNT}); do
+    TYPES=$(jq -r --arg id "${PREFIX}${i}" 'select(.id == $id) | .type' ${OUTPUT} | tr '\n' ' ')
+    if [ "${TYPES}" != "create start stop delete " ]; then
+        echo "FAIL: ${PREFIX}${i} got: ${TYPES}"
+        FAILED=1
+    fi
+done
+
+echo "=== Checking --since drops older events ==="
+if [ -n "$(sudo env ${LOCK_ENV} ./hackontainer --root ${ROOT} events --all --since 0s --filter "id=${PREFIX}*")" ]; then
+    echo "FAIL: --since 0s returned past events"
+    FAILED=1
+fi
+
+rm -f ${OUTPUT}
+if [ ${FAILED} -ne 0 ]; then
+    exit 1
+fi
+echo "PASS: events streamed completely and in order"
@@ -0,0 +1,101 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myexecerrors"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false' ${BUNDLE}/config.json > ${BUNDLE}/config.json.orig
+OUT_FILE=$(mktemp)
+trap 'sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true; rm -f ${OUT_FILE}' EXIT
+
+# One file in the rootfs for each way an exec goes wrong
+APP=${BUNDLE}/rootfs/app
+mkdir -p ${APP}
+printf '#!/usr/bin/python3 -u\nprint("hello")\n' > ${APP}/run.py
+printf '#!/bin/sh\r\necho hello\r\n' > ${APP}/dos.sh
+printf 'echo no shebang\n' > ${APP}/plain
+# The shell with the ELF machine field set to an architecture the host isn't
+cp -L ${BUNDLE}/rootfs/bin/sh ${APP}/foreign
+if [ "$(uname -m)" = "aarch64" ]; then MACHINE='\x3e\x00'; else MACHINE='\xb7\x00'; fi
+printf "${MACHINE}" | dd of=${APP}/foreign bs=1 seek=18 conv=notrunc status=none
+# A dynamic binary whose loader the rootfs doesn't have
+LOADER=$(LC_ALL=C grep -ao '/lib[a-z0-9_/]*/ld-linux[a-z0-9_.-]*\.so\.[0-9]' /bin/true | head -1 || true)
+if [ -n "${LOADER}" ]; then
+    LC_ALL=C sed 's#ld-linux#ld-xxxxx#' /bin/true > ${APP}/noloader
+fi
+chmod +x ${APP}/*
+
+# start_with <path> [create flags...] creates the container running path
+# and starts it, leaving what start printed in OUT
+start_with() {
+    jq --arg path "$1" '.process.args = [$path]' ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+    sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true
+    sudo ./hackontainer create "${@:2}" --bundle ${BUNDLE} ${CONTAINER} >/dev/null
+    if sudo ./hackontainer start ${CONTAINER} > ${OUT_FILE} 2>&1; then
+        echo "FAIL: $1 started"
+        exit 1
+    fi
+    OUT=$(grep -v "^>>>" ${OUT_FILE} || true)
+}
+
+# expect <substring> <what> checks OUT
+expect() {
+    if ! echo "${OUT}" | grep -qF -- "$1"; then
+        echo "FAIL: $2: expected '$1', got: ${OUT}"
+        exit 1
+    fi
+    echo "PASS: $2"
+}
+
+echo "=== A script whose interpreter is missing ==="
+start_with /app/run.py
+expect "interpreter /usr/bin/python3 (from shebang of /app/run.py) not found in container" "the interpreter is named"
+
+echo "=== A script with DOS line endings ==="
+start_with /app/dos.sh
+expect "the script has DOS line endings" "the line endings are blamed"
+
+echo "=== An executable that is neither script nor binary ==="
+start_with /app/plain
+expect "/app/plain is neither a script starting with #! nor an ELF binary" "the missing shebang is pointed out"
+
+echo "=== A binary for another architecture, past the check at create ==="
+start_with /app/foreign --no-arch-check
+expect "/app/foreign is a binary for" "the architecture is named"
+expect "the image may be for a different architecture" "a different image is suggested"
+
+if [ -n "${LOADER}" ]; then
+    echo "=== A binary whose dynamic loader is missing ==="
+    start_with /app/noloader
+    expect "dynamic loader ${LOADER/ld-linux/ld-xxxxx} (from ELF header of /app/noloader) not found in container" "the loader is named"
+fi
+
+echo "=== A path that doesn't exist is still reported as it was ==="
+start_with /app/missing
+expect "no such file or directory" "plain ENOENT"
+if echo "${OUT}" | grep -q "interpreter\|loader"; then
+    echo "FAIL: a missing file was blamed on an interpreter: ${OUT}"
+    exit 1
+fi
+echo "PASS: nothing else is blamed"
+
+echo "=== All exec error tests passed ==="
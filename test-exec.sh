@@ -0,0 +1,127 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myexec"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false | .process.args = ["sleep", "30"] | .process.env += ["FROM_CONFIG=yes"]' ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+trap 'sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true' EXIT
+
+echo "=== Exec into a created container ==="
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+if sudo ./hackontainer exec ${CONTAINER} true 2>/dev/null; then
+    echo "FAIL: exec into a created container succeeded"
+    exit 1
+fi
+echo "PASS: exec into a created container is refused"
+
+sudo ./hackontainer start ${CONTAINER} >/dev/null 2>&1
+INIT_PID=$(sudo ./hackontainer state ${CONTAINER} | grep -v "^>>>" | jq -r .pid)
+
+echo "=== Exec output and exit code ==="
+OUT=$(sudo ./hackontainer exec ${CONTAINER} echo hello 2>/dev/null)
+if [ "$OUT" != "hello" ]; then
+    echo "FAIL: exec printed '$OUT', want hello"
+    exit 1
+fi
+echo "PASS: exec runs the command with the caller's stdout"
+
+set +e
+sudo ./hackontainer exec ${CONTAINER} sh -c 'exit 3' 2>/dev/null
+STATUS=$?
+set -e
+if [ $STATUS -ne 3 ]; then
+    echo "FAIL: exec exited $STATUS, want 3"
+    exit 1
+fi
+echo "PASS: exec exits with the command's exit code"
+
+set +e
+sudo ./hackontainer exec ${CONTAINER} sh -c 'kill -9 $$' 2>/dev/null
+STATUS=$?
+set -e
+if [ $STATUS -ne 137 ]; then
+    echo "FAIL: exec of a killed command exited $STATUS, want 137"
+    exit 1
+fi
+echo "PASS: a killed command exits 128 plus the signal"
+
+echo "=== Exec namespaces and cgroup ==="
+for ns in pid mnt uts ipc net; do
+    WANT=$(sudo readlink /proc/${INIT_PID}/ns/${ns})
+    GOT=$(sudo ./hackontainer exec ${CONTAINER} sh -c "readlink /proc/\$\$/ns/${ns}" 2>/dev/null)
+    if [ "$GOT" != "$WANT" ]; then
+        echo "FAIL: exec is in $ns namespace '$GOT', the container is in '$WANT'"
+        exit 1
+    fi
+done
+echo "PASS: exec joins the pid, mount, uts, ipc and network namespaces"
+
+INIT_PS=$(sudo ./hackontainer exec ${CONTAINER} ps -o pid,comm 2>/dev/null | awk '$1 == 1 {print $2}')
+if [ "$INIT_PS" != "sleep" ]; then
+    echo "FAIL: exec sees '$INIT_PS' as pid 1, want the container's sleep"
+    exit 1
+fi
+echo "PASS: exec sees the container's processes"
+
+WANT=$(sudo cat /proc/${INIT_PID}/cgroup | sort)
+GOT=$(sudo ./hackontainer exec ${CONTAINER} cat /proc/self/cgroup 2>/dev/null | sort)
+if [ "$GOT" != "$WANT" ]; then
+    echo "FAIL: exec's cgroups differ from the container process's:"
+    echo "$GOT"
+    exit 1
+fi
+echo "PASS: exec joins the container's cgroup"
+
+echo "=== Exec cwd, env and user ==="
+OUT=$(sudo ./hackontainer exec --workdir /tmp -e EXTRA=1 ${CONTAINER} sh -c 'echo $(pwd) $FROM_CONFIG $EXTRA' 2>/dev/null)
+if [ "$OUT" != "/tmp yes 1" ]; then
+    echo "FAIL: exec printed '$OUT', want '/tmp yes 1'"
+    exit 1
+fi
+echo "PASS: exec runs in --workdir with the container's env and -e"
+
+OUT=$(sudo ./hackontainer exec --user 1000:1001 ${CONTAINER} sh -c 'echo $(id -u) $(id -g)' 2>/dev/null)
+if [ "$OUT" != "1000 1001" ]; then
+    echo "FAIL: exec --user ran as '$OUT', want '1000 1001'"
+    exit 1
+fi
+echo "PASS: exec --user sets the uid and gid"
+
+ERR=$(sudo ./hackontainer exec ${CONTAINER} no-such-command 2>&1 >/dev/null | grep -v "^>>>" || true)
+if ! echo "$ERR" | grep -q "no-such-command"; then
+    echo "FAIL: exec of a missing command reported '$ERR'"
+    exit 1
+fi
+echo "PASS: exec of a missing command names it"
+
+echo "=== Exec into a stopped container ==="
+sudo ./hackontainer kill ${CONTAINER} KILL >/dev/null 2>&1
+sleep 1
+if sudo ./hackontainer exec ${CONTAINER} true 2>/dev/null; then
+    echo "FAIL: exec into a stopped container succeeded"
+    exit 1
+fi
+echo "PASS: exec into a stopped container is refused"
+
+echo "=== All exec tests passed ==="
@@ -0,0 +1,97 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myexitcodes"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false' ${BUNDLE}/config.json > ${BUNDLE}/config.json.orig
+trap 'sudo ./hackontainer delete --force ${CONTAINER} >/dev/null 2>&1 || true' EXIT
+
+# Files in the rootfs that exist but can't be run
+APP=${BUNDLE}/rootfs/app
+mkdir -p ${APP}/dir
+printf 'echo not executable\n' > ${APP}/noexec
+printf 'echo no shebang\n' > ${APP}/plain
+chmod 644 ${APP}/noexec
+chmod 755 ${APP}/plain
+
+# use <path> makes path the container's process
+use() {
+    jq --arg path "$1" '.process.args = [$path]' ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+}
+
+# expect_code <what> <want> <command...> runs a command that must fail
+# with exit status want
+expect_code() {
+    local desc=$1 want=$2
+    shift 2
+    local got=0
+    sudo "$@" >/dev/null 2>&1 || got=$?
+    if [ "${got}" != "${want}" ]; then
+        echo "FAIL: ${desc}: exit status ${got}, want ${want}"
+        exit 1
+    fi
+    echo "PASS: ${desc} exits ${want}"
+}
+
+# each <path> <code> checks path fails with code through run, through
+# start after a state-only create and through start after a full one
+each() {
+    use "$1"
+    sudo ./hackontainer delete --force ${CONTAINER} >/dev/null 2>&1 || true
+    expect_code "run $1" "$2" ./hackontainer run --bundle ${BUNDLE} ${CONTAINER}
+
+    sudo ./hackontainer delete --force ${CONTAINER} >/dev/null 2>&1 || true
+    sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+    expect_code "start $1" "$2" ./hackontainer start ${CONTAINER}
+
+    sudo ./hackontainer delete --force ${CONTAINER} >/dev/null 2>&1 || true
+    sudo ./hackontainer create --create-mode full --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+    expect_code "start $1 after a full create" "$2" ./hackontainer start ${CONTAINER}
+}
+
+echo "=== A missing executable exits 127 ==="
+each /app/missing 127
+
+echo "=== A missing executable looked up in PATH exits 127 ==="
+use nosuchcmd
+sudo ./hackontainer delete --force ${CONTAINER} >/dev/null 2>&1 || true
+expect_code "run nosuchcmd" 127 ./hackontainer run --bundle ${BUNDLE} ${CONTAINER}
+sudo ./hackontainer delete --force ${CONTAINER} >/dev/null 2>&1 || true
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+expect_code "start nosuchcmd" 127 ./hackontainer start ${CONTAINER}
+# A full create looks the executable up before it stops to wait for start
+sudo ./hackontainer delete --force ${CONTAINER} >/dev/null 2>&1 || true
+expect_code "full create nosuchcmd" 127 ./hackontainer create --create-mode full --bundle ${BUNDLE} ${CONTAINER}
+
+echo "=== A file that can't be executed exits 126 ==="
+each /app/noexec 126
+each /app/plain 126
+each /app/dir 126
+
+echo "=== A failure before exec exits 125 ==="
+jq '.process.args = ["true"] | .process.cwd = "/app/noexec/sub"' ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+sudo ./hackontainer delete --force ${CONTAINER} >/dev/null 2>&1 || true
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+expect_code "start with an unusable cwd" 125 ./hackontainer start ${CONTAINER}
+
+echo "=== All exit code tests passed ==="
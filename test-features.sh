@@ -0,0 +1,52 @@
+#!/bin/bash
+set -e
+
+FEATURES=$(mktemp)
+
+cleanup() {
+    rm -f ${FEATURES}
+}
+trap cleanup EXIT
+
+# check <jq filter> <what> checks the features document
+check() {
+    if ! jq -e "$1" ${FEATURES} >/dev/null; then
+        cat ${FEATURES}
+        echo "FAIL: $2"
+        exit 1
+    fi
+    echo "PASS: $2"
+}
+
+echo "=== Printing the features document ==="
+sudo ./hackontainer features > ${FEATURES}
+
+check '.ociVersionMin == "1.0.0" and (.ociVersionMax | startswith("1."))' "OCI versions"
+check '.hooks == ["prestart", "createRuntime", "createContainer", "startContainer", "poststart", "poststop"]' "hook kinds"
+check '.mountOptions | index("rbind") and index("ro") and index("rprivate") and index("replace-symlink")' "mount options"
+check '.mountOptions == (.mountOptions | sort)' "mount options sorted"
+check '.linux.namespaces | index("mount") and index("pid")' "namespaces"
+check '.linux.seccomp.enabled == false and .linux.apparmor.enabled == false and .linux.selinux.enabled == false' "confinement not applied"
+
+echo "=== The cgroup support is the host's ==="
+if [ -f /sys/fs/cgroup/cgroup.controllers ]; then
+    check '.linux.cgroup.v2 == true and .linux.cgroup.v1 == false and .annotations["org.hackontainer.cgroup-version"] == "2"' "cgroup v2"
+else
+    check '.linux.cgroup.v2 == false and .annotations["org.hackontainer.cgroup-version"] == "1"' "cgroup v1"
+fi
+
+echo "=== The namespaces are the host's ==="
+for ns in user time cgroup; do
+    if [ ! -e /proc/self/ns/${ns} ]; then
+        check ".linux.namespaces | index(\"${ns}\") | not" "no ${ns} namespace on this kernel"
+    fi
+done
+echo "PASS: only namespaces the kernel has"
+
+if sudo ./hackontainer features extra >/dev/null 2>&1; then
+    echo "FAIL: features took an argument"
+    exit 1
+fi
+echo "PASS: refused an argument"
+
+echo "=== All features tests passed ==="
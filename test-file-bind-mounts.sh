@@ -0,0 +1,117 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myfilebind"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false' ${BUNDLE}/config.json > ${BUNDLE}/config.json.orig
+
+# Sources of every kind, on a nosuid tmpfs so the remount has flags to keep
+SOURCES=$(mktemp -d)
+sudo mount -t tmpfs -o nosuid,nodev tmpfs ${SOURCES}
+trap 'sudo umount ${SOURCES}; rmdir ${SOURCES}' EXIT
+echo "from the host" | sudo tee ${SOURCES}/file >/dev/null
+sudo mkdir ${SOURCES}/dir
+sudo mkfifo ${SOURCES}/fifo
+sudo python3 -c "import socket; socket.socket(socket.AF_UNIX).bind('${SOURCES}/sock')"
+
+# run_with <mounts> <command> runs command in a container with mounts
+# added, leaving what it printed, errors included, in OUT
+run_with() {
+    jq --argjson mounts "$1" --arg cmd "$2" \
+        '.process.args = ["sh", "-c", $cmd] | .mounts += $mounts' \
+        ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+    OUT=$(sudo ./hackontainer run --bundle ${BUNDLE} ${CONTAINER} 2>&1 | grep -v "^>>>" || true)
+    sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true
+}
+
+# expect <substring> <what> checks OUT
+expect() {
+    if ! echo "${OUT}" | grep -qF -- "$1"; then
+        echo "FAIL: $2: expected '$1', got: ${OUT}"
+        exit 1
+    fi
+    echo "PASS: $2"
+}
+
+echo "=== A file is bound read-only with type bind alone ==="
+run_with "[{\"destination\": \"/etc/hostfile\", \"type\": \"bind\", \"source\": \"${SOURCES}/file\", \"options\": [\"ro\"]}]" \
+    'cat /etc/hostfile; echo x > /etc/hostfile 2>/dev/null || echo "write refused"; grep " /etc/hostfile " /proc/mounts'
+expect "from the host" "the file is visible"
+expect "write refused" "the file is read-only"
+expect "ro,nosuid,nodev" "the remount keeps the source's nosuid and nodev"
+
+echo "=== Missing parents of a file target are created ==="
+run_with "[{\"destination\": \"/new/dir/file\", \"type\": \"bind\", \"source\": \"${SOURCES}/file\", \"options\": [\"rbind\"]}]" \
+    'cat /new/dir/file'
+expect "from the host" "bound at a new path"
+
+echo "=== Sockets and fifos are bound like files ==="
+run_with "[{\"destination\": \"/fifo\", \"type\": \"bind\", \"source\": \"${SOURCES}/fifo\", \"options\": [\"rbind\"]},
+           {\"destination\": \"/sock\", \"type\": \"bind\", \"source\": \"${SOURCES}/sock\", \"options\": [\"rbind\"]}]" \
+    'test -p /fifo && echo fifo; test -S /sock && echo sock'
+expect "fifo" "a fifo is bound"
+expect "sock" "a socket is bound"
+
+echo "=== A dangling symlink in the image is followed by default ==="
+ln -sf /run/systemd/resolve/stub-resolv.conf ${BUNDLE}/rootfs/etc/resolv.conf
+run_with "[{\"destination\": \"/etc/resolv.conf\", \"type\": \"bind\", \"source\": \"${SOURCES}/file\", \"options\": [\"rbind\", \"ro\"]}]" \
+    'cat /etc/resolv.conf'
+expect "from the host" "the symlink leads to the mount"
+if [ ! -L ${BUNDLE}/rootfs/etc/resolv.conf ] || [ ! -f ${BUNDLE}/rootfs/run/systemd/resolve/stub-resolv.conf ]; then
+    echo "FAIL: expected the mountpoint to be made where the symlink points"
+    exit 1
+fi
+echo "PASS: the mountpoint is made at the symlink's target"
+
+echo "=== replace-symlink puts the mountpoint in the symlink's place ==="
+rm -r ${BUNDLE}/rootfs/run/systemd
+run_with "[{\"destination\": \"/etc/resolv.conf\", \"type\": \"bind\", \"source\": \"${SOURCES}/file\", \"options\": [\"rbind\", \"ro\", \"replace-symlink\"]}]" \
+    'cat /etc/resolv.conf'
+expect "from the host" "the file is mounted"
+if [ -L ${BUNDLE}/rootfs/etc/resolv.conf ] || [ -e ${BUNDLE}/rootfs/run/systemd ]; then
+    echo "FAIL: expected the symlink to be replaced"
+    exit 1
+fi
+echo "PASS: the symlink was replaced and nothing made where it pointed"
+
+echo "=== A missing target below a read-only mount is a clear error ==="
+run_with "[{\"destination\": \"/rodir\", \"type\": \"bind\", \"source\": \"${SOURCES}/dir\", \"options\": [\"rbind\", \"ro\"]},
+           {\"destination\": \"/rodir/file\", \"type\": \"bind\", \"source\": \"${SOURCES}/file\", \"options\": [\"rbind\"]}]" \
+    'true'
+expect "the mountpoint is missing and can't be created on a read-only mount" "the read-only parent is named as the cause"
+
+echo "=== A file can't cover a directory ==="
+run_with "[{\"destination\": \"/bin\", \"type\": \"bind\", \"source\": \"${SOURCES}/file\", \"options\": [\"rbind\"]}]" 'true'
+expect "which is not a directory, over directory /bin" "the type mismatch is reported"
+
+echo "=== A read-only file bind works in a user namespace ==="
+jq '.linux.namespaces += [{"type": "user"}]
+    | .linux.uidMappings = [{"containerID": 0, "hostID": 100000, "size": 65536}]
+    | .linux.gidMappings = [{"containerID": 0, "hostID": 100000, "size": 65536}]' \
+    ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json.userns
+mv ${BUNDLE}/config.json.userns ${BUNDLE}/config.json.orig
+run_with "[{\"destination\": \"/etc/hostfile\", \"type\": \"bind\", \"source\": \"${SOURCES}/file\", \"options\": [\"rbind\", \"ro\"]}]" \
+    'cat /etc/hostfile'
+expect "from the host" "the locked flags are kept on the remount"
+
+echo "=== All file bind mount tests passed ==="
@@ -0,0 +1,104 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myfootprint"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false | .process.args = ["sleep", "30"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1: got '$2', want '$3'"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+# cgroup_of prints the cgroup of a process, in the unified hierarchy or
+# the v1 pids hierarchy
+cgroup_of() {
+    if [ -f /sys/fs/cgroup/cgroup.controllers ]; then
+        grep "^0::" /proc/$1/cgroup | cut -d: -f3
+    else
+        grep ":pids:" /proc/$1/cgroup | cut -d: -f3
+    fi
+}
+
+state() {
+    sudo ./hackontainer state ${CONTAINER} | grep -v "^>>>"
+}
+
+inspect() {
+    sudo ./hackontainer inspect ${CONTAINER} | grep -v "^>>>"
+}
+
+echo "=== Starting the container ==="
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+sudo ./hackontainer start ${CONTAINER} >/dev/null 2>&1
+
+MONITOR=$(state | jq -r '.monitorPid')
+if [ -z "${MONITOR}" ] || [ "${MONITOR}" = "null" ]; then
+    echo "FAIL: state has no monitorPid"
+    exit 1
+fi
+echo "PASS: state reports monitor ${MONITOR}"
+check "the monitor is the runtime's monitor command" \
+    "$(tr '\0' ' ' < /proc/${MONITOR}/cmdline | awk '{print $2}')" "monitor"
+
+echo "=== The monitor is in the runtime cgroup ==="
+check "monitor cgroup" "$(basename "$(cgroup_of ${MONITOR})")" "hackontainer-runtime"
+CONTAINER_PID=$(state | jq -r '.pid')
+if [ "$(cgroup_of ${CONTAINER_PID})" = "$(cgroup_of ${MONITOR})" ]; then
+    echo "FAIL: the container process shares the monitor's cgroup"
+    exit 1
+fi
+echo "PASS: the container process has a cgroup of its own"
+
+echo "=== inspect reports the footprint ==="
+FOOTPRINT=$(inspect | jq -c '.footprint')
+check "inspect monitorPid" "$(echo "${FOOTPRINT}" | jq -r '.monitorPid')" "${MONITOR}"
+check "inspect monitorCgroup" "$(echo "${FOOTPRINT}" | jq -r '.monitorCgroup')" "$(cgroup_of ${MONITOR})"
+check "inspect monitorRssBytes is set" "$(echo "${FOOTPRINT}" | jq '.monitorRssBytes > 0')" "true"
+check "inspect monitorFds is set" "$(echo "${FOOTPRINT}" | jq '.monitorFds > 0')" "true"
+
+echo "=== gc --report aggregates the root ==="
+REPORT=$(sudo ./hackontainer gc --report | grep -v "^>>>")
+check "the container is listed" \
+    "$(echo "${REPORT}" | jq -r --arg id ${CONTAINER} '.containers[] | select(.id == $id) | .monitorPid')" "${MONITOR}"
+check "totals count every container" \
+    "$(echo "${REPORT}" | jq '.total.containers == (.containers | length)')" "true"
+check "totals add up the monitors" \
+    "$(echo "${REPORT}" | jq '.total.monitorRssBytes == ([.containers[].monitorRssBytes // 0] | add)')" "true"
+check "gc leaves a container of this boot running" \
+    "$(sudo ./hackontainer gc 2>/dev/null | grep -v "^>>>"; state | jq -r .status)" "running"
+
+echo "=== The monitor is gone once the container stops ==="
+sudo ./hackontainer kill ${CONTAINER} KILL
+sleep 1
+check "state has no monitorPid" "$(state | jq -r '.monitorPid')" "null"
+check "inspect has no monitor" "$(inspect | jq -r '.footprint.monitorPid')" "null"
+
+sudo ./hackontainer delete ${CONTAINER}
+
+echo "=== All footprint tests passed ==="
@@ -0,0 +1,138 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myhooks"
+BUNDLE="test-bundles/busybox"
+MARKER="/tmp/hackontainer-poststart-${CONTAINER}"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+sudo rm -f ${MARKER} ${MARKER}-*
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+echo "=== Adding hooks that record the state and env they were given ==="
+jq --arg marker "${MARKER}" '.process.terminal = false
+    | .process.args = ["sleep", "5"]
+    | .hooks.poststart = [{"path": "/bin/sh", "args": ["sh", "-c", "cat > \($marker)"]}]
+    | .hooks.prestart = [{"path": "/bin/sh", "args": ["sh", "-c", "cat > \($marker)-prestart.state; env > \($marker)-prestart.env"],
+        "env": ["HOOK_OWN=1"]}]
+    | .hooks.createContainer = [{"path": "/bin/sh", "args": ["sh", "-c", "cat > \($marker)-createContainer.state; env > \($marker)-createContainer.env"]}]
+    | .hooks.poststop = [{"path": "/bin/sh", "args": ["sh", "-c", "cat > \($marker)-poststop.state; env > \($marker)-poststop.env"]}]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+
+echo "=== Creating with --no-hooks (expect a failure) ==="
+if OUTPUT=$(sudo ./hackontainer --no-hooks create --bundle ${BUNDLE} ${CONTAINER} 2>&1); then
+    echo "FAIL: create accepted a config with hooks"
+    sudo ./hackontainer delete ${CONTAINER}
+    exit 1
+fi
+echo "${OUTPUT}"
+if ! echo "${OUTPUT}" | grep -q "hooks are disabled"; then
+    echo "FAIL: unexpected error"
+    exit 1
+fi
+if [ -e /run/hackontainer/${CONTAINER} ] || [ -e ${MARKER} ]; then
+    echo "FAIL: rejected create left something behind"
+    exit 1
+fi
+echo "PASS: create refused the hooks"
+
+echo "=== Creating and starting with hooks enabled ==="
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER}
+sudo env LEAKED_FROM_RUNTIME=1 ./hackontainer start ${CONTAINER}
+
+if ! sudo grep -q '"status":"running"' ${MARKER}; then
+    echo "FAIL: poststart hook did not run with the running state"
+    sudo ./hackontainer kill ${CONTAINER} KILL
+    sudo ./hackontainer delete ${CONTAINER}
+    exit 1
+fi
+echo "PASS: poststart hook ran"
+
+# hook_env <hook> <name> prints a variable the hook got
+hook_env() {
+    sudo sed -n "s/^$2=//p" ${MARKER}-$1.env
+}
+
+# check_hook_env <hook> checks the hook's env agrees with its stdin and
+# with state and inspect, and has nothing of the runtime's own
+check_hook_env() {
+    local hook=$1 stdin
+    stdin=$(sudo cat ${MARKER}-${hook}.state)
+    for field in id pid bundle; do
+        local name want
+        name=HACKONTAINER_$(echo ${field} | tr a-z A-Z)
+        want=$(echo "${stdin}" | jq -r .${field})
+        if [ "$(hook_env ${hook} ${name})" != "${want}" ]; then
+            echo "FAIL: ${hook} got ${name}='$(hook_env ${hook} ${name})', but '${want}' on stdin"
+            exit 1
+        fi
+    done
+    if [ "$(hook_env ${hook} HACKONTAINER_CGROUP)" != "${CGROUP}" ]; then
+        echo "FAIL: ${hook} got HACKONTAINER_CGROUP='$(hook_env ${hook} HACKONTAINER_CGROUP)', state has '${CGROUP}'"
+        exit 1
+    fi
+    if [ "$(hook_env ${hook} HACKONTAINER_ROOTFS)" != "${ROOTFS}" ]; then
+        echo "FAIL: ${hook} got HACKONTAINER_ROOTFS='$(hook_env ${hook} HACKONTAINER_ROOTFS)', inspect has '${ROOTFS}'"
+        exit 1
+    fi
+    if sudo grep -q "^LEAKED_FROM_RUNTIME=" ${MARKER}-${hook}.env; then
+        echo "FAIL: ${hook} got the runtime's own environment"
+        exit 1
+    fi
+    echo "PASS: ${hook} hook env agrees with its stdin, state and inspect"
+}
+
+echo "=== Hooks get the container in their env ==="
+CGROUP=$(sudo ./hackontainer state ${CONTAINER} | grep -v "^>>>" | jq -r '.cgroupPath // ""')
+ROOTFS=$(sudo ./hackontainer inspect ${CONTAINER} | grep -v "^>>>" | jq -r .rootfs)
+PID=$(sudo ./hackontainer state ${CONTAINER} | grep -v "^>>>" | jq -r .pid)
+check_hook_env prestart
+check_hook_env createContainer
+if [ "$(hook_env prestart HACKONTAINER_PID)" != "${PID}" ]; then
+    echo "FAIL: prestart got HACKONTAINER_PID='$(hook_env prestart HACKONTAINER_PID)', state has ${PID}"
+    exit 1
+fi
+if [ "$(hook_env prestart HOOK_OWN)" != "1" ]; then
+    echo "FAIL: prestart lost the env its config gives it"
+    exit 1
+fi
+echo "PASS: prestart keeps its own env and gets the container's pid"
+
+echo "=== The monitor gets the container in its env ==="
+MONITOR=$(sudo ./hackontainer state ${CONTAINER} | grep -v "^>>>" | jq -r .monitorPid)
+MONITOR_ENV=$(sudo cat /proc/${MONITOR}/environ | tr '\0' '\n')
+for want in "HACKONTAINER_ID=${CONTAINER}" "HACKONTAINER_CGROUP=${CGROUP}" "HACKONTAINER_ROOTFS=${ROOTFS}"; do
+    if ! echo "${MONITOR_ENV}" | grep -qx -- "${want}"; then
+        echo "FAIL: the monitor's env lacks ${want}: ${MONITOR_ENV}"
+        exit 1
+    fi
+done
+if echo "${MONITOR_ENV}" | grep -q "^LEAKED_FROM_RUNTIME="; then
+    echo "FAIL: the monitor got the runtime's own environment"
+    exit 1
+fi
+echo "PASS: the monitor's env describes the container and nothing else"
+
+echo "=== Cleaning up ==="
+sudo ./hackontainer kill ${CONTAINER} KILL
+sleep 1
+check_hook_env poststop
+sudo ./hackontainer delete ${CONTAINER}
+sudo rm -f ${MARKER} ${MARKER}-*
@@ -0,0 +1,109 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myhostname"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false | .hostname = "hk-test-host" | .process.args = ["sleep", "30"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.orig
+cp ${BUNDLE}/config.json.orig ${BUNDLE}/config.json
+trap 'sudo ./hackontainer kill ${CONTAINER} SIGKILL >/dev/null 2>&1 && sleep 1; sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true' EXIT
+
+HOST_HOSTNAME=$(hostname)
+
+# host_untouched fails unless the host still has its hostname
+host_untouched() {
+    if [ "$(hostname)" != "${HOST_HOSTNAME}" ]; then
+        echo "FAIL: $1 renamed the host to $(hostname)"
+        sudo hostname ${HOST_HOSTNAME}
+        exit 1
+    fi
+    echo "PASS: the host keeps its hostname after $1"
+}
+
+# with <jq filter> writes the config the next container is created from
+with() {
+    jq "$1" ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+}
+
+# refused <what> <want> <command...> runs a command that must fail and
+# name why
+refused() {
+    local desc=$1 want=$2
+    shift 2
+    local out
+    if out=$(sudo "$@" 2>&1); then
+        echo "FAIL: $desc succeeded"
+        exit 1
+    fi
+    if ! echo "$out" | grep -q "$want"; then
+        echo "FAIL: $desc: expected '$want' in: $out"
+        exit 1
+    fi
+    echo "PASS: $desc is refused"
+}
+
+echo "=== A running container reports the hostname it sees ==="
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+sudo ./hackontainer start ${CONTAINER} >/dev/null 2>&1
+INSPECT=$(sudo ./hackontainer inspect ${CONTAINER} | grep -v "^>>>")
+if [ "$(echo "${INSPECT}" | jq -r .hostname)" != "hk-test-host" ]; then
+    echo "FAIL: inspect hostname is $(echo "${INSPECT}" | jq -r .hostname), want hk-test-host"
+    exit 1
+fi
+echo "PASS: inspect shows the container's hostname"
+host_untouched "running a container"
+
+sudo ./hackontainer kill ${CONTAINER} SIGKILL >/dev/null 2>&1
+sleep 1
+if [ "$(sudo ./hackontainer inspect ${CONTAINER} | grep -v "^>>>" | jq -r '.hostname // empty')" != "" ]; then
+    echo "FAIL: a stopped container still reports a hostname"
+    exit 1
+fi
+echo "PASS: a stopped container reports none"
+sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1
+
+echo "=== A hostname without a UTS namespace is rejected ==="
+with '.linux.namespaces |= map(select(.type != "uts"))'
+refused "a hostname without a UTS namespace" "requires a UTS namespace" ./hackontainer run --bundle ${BUNDLE} ${CONTAINER}
+sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true
+host_untouched "the rejected create"
+
+echo "=== The child won't set the hostname in the runtime's UTS namespace ==="
+# Joining the host's namespace, this shell's, by path passes validation
+with ".linux.namespaces |= map(if .type == \"uts\" then .path = \"/proc/$$/ns/uts\" else . end)"
+refused "setting the hostname in the host's UTS namespace" "shares the runtime's UTS namespace" ./hackontainer run --bundle ${BUNDLE} ${CONTAINER}
+sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true
+host_untouched "the refused start"
+
+echo "=== Failed starts leave the host alone ==="
+with '.process.args = ["/no/such/binary"]'
+refused "a missing binary" "not found\|no such file" ./hackontainer run --bundle ${BUNDLE} ${CONTAINER}
+sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true
+host_untouched "a failed exec"
+
+with '.hooks.createRuntime = [{"path": "/bin/false"}]'
+refused "a failing createRuntime hook" "createRuntime" ./hackontainer create --create-mode full --bundle ${BUNDLE} ${CONTAINER}
+sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true
+host_untouched "a failed hook"
+
+echo "=== All hostname tests passed ==="
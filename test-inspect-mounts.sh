@@ -0,0 +1,147 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myinspectmounts"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+cleanup() {
+    sudo ./hackontainer kill ${CONTAINER} SIGKILL >/dev/null 2>&1 || true
+    sleep 1
+    sudo ./hackontainer delete --force ${CONTAINER} >/dev/null 2>&1 || true
+    rm -rf ${BUNDLE}/data || true
+}
+trap cleanup EXIT
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+mkdir -p ${BUNDLE}/data
+DATA=$(cd ${BUNDLE}/data && pwd)
+cp ${BUNDLE}/config.json ${BUNDLE}/config.json.orig
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1: expected '$3', got '$2'"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+# mount_field <destination> <field> prints a field of the mount inspect
+# reports at destination, the last one if there are several
+mount_field() {
+    sudo ./hackontainer inspect ${CONTAINER} | grep -v "^>>>" |
+        jq -r --arg dest "$1" "[.mounts[] | select(.destination == \$dest)] | last | .$2"
+}
+
+restart() {
+    sudo ./hackontainer kill ${CONTAINER} SIGKILL >/dev/null 2>&1 || true
+    sleep 1
+    sudo ./hackontainer delete --force ${CONTAINER} >/dev/null 2>&1 || true
+    sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+    sudo ./hackontainer start ${CONTAINER} >/dev/null 2>&1
+    check "the container runs" \
+        "$(sudo ./hackontainer state ${CONTAINER} | grep -v "^>>>" | jq -r .status)" "running"
+}
+
+jq --arg data "${DATA}" '.process.terminal = false | .process.args = ["sleep", "60"]
+    | .process.capabilities = {"bounding": ["CAP_SYS_ADMIN"], "effective": ["CAP_SYS_ADMIN"], "permitted": ["CAP_SYS_ADMIN"]}
+    | .mounts += [
+        {"destination": "/data", "type": "bind", "source": $data, "options": ["rbind", "ro"]},
+        {"destination": "/scratch", "type": "tmpfs", "source": "tmpfs", "options": ["nosuid", "size=1m"]}]' \
+    ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+
+echo "=== Inspect reports nothing before the container runs ==="
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+check "no mounts for a created container" \
+    "$(sudo ./hackontainer inspect ${CONTAINER} | grep -v "^>>>" | jq -c .mounts)" "null"
+sudo ./hackontainer start ${CONTAINER} >/dev/null 2>&1
+
+echo "=== The spec's mounts are applied ==="
+check "the rootfs is applied" "$(mount_field / status)" "applied"
+check "proc is applied" "$(mount_field /proc status)" "applied"
+check "the bind mount is applied" "$(mount_field /data status)" "applied"
+check "the bind mount is read-only" \
+    "$(mount_field /data 'options | index("ro") != null')" "true"
+check "the bind mount's directory is reported" \
+    "$(mount_field /data root)" "${DATA}"
+check "the tmpfs is applied" "$(mount_field /scratch type)" "tmpfs"
+check "the tmpfs has its options" \
+    "$(mount_field /scratch 'options | index("size=1024k") != null')" "true"
+check "propagation is reported" "$(mount_field /scratch propagation)" "private"
+check "masked paths aren't extra" \
+    "$(mount_field /proc/kcore status)" "$(sudo ./hackontainer exec ${CONTAINER} grep -q ' /proc/kcore ' /proc/self/mountinfo 2>/dev/null && echo applied || echo null)"
+
+echo "=== Mounts made since and mounts gone are told apart ==="
+sudo ./hackontainer exec ${CONTAINER} sh -c 'mkdir -p /mnt && mount -t tmpfs added /mnt'
+sudo ./hackontainer exec ${CONTAINER} umount /scratch
+check "a mount the container added is extra" "$(mount_field /mnt status)" "extra"
+check "the extra mount's source is reported" "$(mount_field /mnt source)" "added"
+check "a mount that went away is missing" "$(mount_field /scratch status)" "missing"
+check "the missing mount is described by the spec" "$(mount_field /scratch options | jq -r 'join(",")')" "nosuid,size=1m"
+
+sudo ./hackontainer exec ${CONTAINER} mount -t proc proc /scratch
+check "a mount of another type is extra" \
+    "$(sudo ./hackontainer inspect ${CONTAINER} | grep -v "^>>>" | jq -r '[.mounts[] | select(.destination == "/scratch") | .status] | join(",")')" "extra,missing"
+
+echo "=== Devices are listed from the container's /dev ==="
+DEVICES=$(sudo ./hackontainer inspect ${CONTAINER} | grep -v "^>>>" | jq -c '.devices')
+check "/dev/null is listed" \
+    "$(echo "${DEVICES}" | jq -c '.[] | select(.path == "/dev/null") | [.type, .major, .minor]')" '["c",1,3]'
+check "/dev/zero is listed" \
+    "$(echo "${DEVICES}" | jq -r '.[] | select(.path == "/dev/zero") | .minor')" "5"
+sudo ./hackontainer exec ${CONTAINER} mknod -m 600 /dev/added b 7 200
+check "a node the container made is listed" \
+    "$(sudo ./hackontainer inspect ${CONTAINER} | grep -v "^>>>" | jq -c '.devices[] | select(.path == "/dev/added") | [.type, .major, .minor, .fileMode]')" '["b",7,200,384]'
+
+echo "=== A hostile /dev can't lead inspect out of the container ==="
+# With no /dev of its own, the container keeps the rootfs's, a symlink
+# that leads to the host's /dev if resolved from the host, with links
+# out of the container below it
+(cd ${BUNDLE}/rootfs && rm -rf dev fakedev)
+mkdir -p ${BUNDLE}/rootfs/fakedev/sub
+sudo mknod ${BUNDLE}/rootfs/fakedev/marker c 1 3
+ln -s /../../../../../dev ${BUNDLE}/rootfs/fakedev/up
+ln -s ../../../../../../../dev ${BUNDLE}/rootfs/fakedev/sub/up
+ln -s /proc/1/root/dev ${BUNDLE}/rootfs/fakedev/magic
+ln -s /fakedev ${BUNDLE}/rootfs/dev
+jq '.process.terminal = false | .process.args = ["sleep", "60"]
+    | .mounts = [.mounts[] | select(.destination | startswith("/dev") | not)]
+    | .linux.devices = []' \
+    ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+restart
+check "only the container's node is listed" \
+    "$(sudo ./hackontainer inspect ${CONTAINER} | grep -v "^>>>" | jq -r '[.devices[].path] | join(",")')" "/dev/marker"
+
+ln -sfn /../../../../../dev ${BUNDLE}/rootfs/dev
+restart
+check "an absolute escape resolves inside the container" \
+    "$(sudo ./hackontainer inspect ${CONTAINER} | grep -v "^>>>" | jq -c '.devices')" "null"
+rm -f ${BUNDLE}/rootfs/dev
+ln -s ../../../../../../dev ${BUNDLE}/rootfs/dev
+restart
+check "a relative escape resolves inside the container" \
+    "$(sudo ./hackontainer inspect ${CONTAINER} | grep -v "^>>>" | jq -c '.devices')" "null"
+rm -f ${BUNDLE}/rootfs/dev
+ln -s /proc/self/root/dev ${BUNDLE}/rootfs/dev
+restart
+check "a magic link isn't followed" \
+    "$(sudo ./hackontainer inspect ${CONTAINER} | grep -v "^>>>" | jq -c '.devices')" "null"
+
+echo "=== All inspect mounts tests passed ==="
@@ -0,0 +1,83 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mykernelfeatures"
+BUNDLE="test-bundles/busybox"
+CACHE="/run/hackontainer/kernel-features.json"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.args = ["true"] | .process.terminal = false |
+    .linux.namespaces += [{"type": "user"}] |
+    .linux.uidMappings = [{"containerID": 0, "hostID": 100000, "size": 65536}] |
+    .linux.gidMappings = [{"containerID": 0, "hostID": 100000, "size": 65536}]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+
+echo "=== Create reports every missing feature at once ==="
+OUTPUT=$(sudo HACKONTAINER_TEST_MISSING_FEATURES="user namespace,pivot_root" \
+    ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} 2>&1) && {
+    sudo ./hackontainer delete ${CONTAINER}
+    echo "FAIL: create succeeded without the features it needs"
+    exit 1
+}
+for feature in "user namespace" "pivot_root"; do
+    if ! echo "${OUTPUT}" | grep -q "${feature}: simulated"; then
+        echo "FAIL: '${feature}' missing from the report: ${OUTPUT}"
+        exit 1
+    fi
+done
+echo "PASS: both missing features reported"
+if [ -e /run/hackontainer/${CONTAINER} ]; then
+    echo "FAIL: the failed create left a container behind"
+    exit 1
+fi
+echo "PASS: nothing left behind"
+
+echo "=== Features the config doesn't use don't matter ==="
+sudo HACKONTAINER_TEST_MISSING_FEATURES="time namespace" \
+    ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+sudo ./hackontainer delete ${CONTAINER}
+echo "PASS: created without a time namespace"
+
+echo "=== Probe results are cached for this boot ==="
+sudo rm -f ${CACHE}
+sudo ./hackontainer run --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+if [ "$(sudo jq -r .bootId ${CACHE})" != "$(cat /proc/sys/kernel/random/boot_id)" ]; then
+    echo "FAIL: cache is not keyed by this boot: $(sudo cat ${CACHE})"
+    exit 1
+fi
+if [ "$(sudo jq '.available | length' ${CACHE})" = "0" ]; then
+    echo "FAIL: nothing cached: $(sudo cat ${CACHE})"
+    exit 1
+fi
+echo "PASS: cache written for this boot"
+
+echo "=== A cache from a previous boot is ignored ==="
+sudo jq '.bootId = "00000000-0000-0000-0000-000000000000"' ${CACHE} > kernel-features.json.tmp
+sudo mv kernel-features.json.tmp ${CACHE}
+sudo ./hackontainer run --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+if [ "$(sudo jq -r .bootId ${CACHE})" != "$(cat /proc/sys/kernel/random/boot_id)" ]; then
+    echo "FAIL: stale cache kept"
+    exit 1
+fi
+echo "PASS: probed again and rewrote the cache"
+
+echo "=== All kernel feature tests passed ==="
@@ -0,0 +1,155 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mykillall"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+# Without a PID namespace killing init leaves the rest running, so only
+# signalling the whole cgroup stops the container. The pids limit keeps
+# the fork bomb from taking the host down with it.
+jq '.process.terminal = false |
+    .linux.namespaces |= map(select(.type != "pid")) |
+    .linux.resources.pids.limit = 512' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.orig
+
+# with_script points the config at a shell script
+with_script() {
+    jq --arg script "$1" '.process.args = ["sh", "-c", $script]' \
+        ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+}
+
+state() {
+    sudo ./hackontainer state ${CONTAINER} 2>/dev/null | grep -v "^>>>" | jq -r "$1"
+}
+
+# count_marked counts the host's processes running sleep for the marker
+# duration, which only the container starts
+count_marked() {
+    ps -eo args | grep -c "^sleep $1\$" || true
+}
+
+wait_stopped() {
+    for _ in $(seq 50); do
+        if [ "$(state .status)" = "stopped" ]; then
+            return 0
+        fi
+        sleep 0.1
+    done
+    return 1
+}
+
+echo "=== A fork bomb is killed by one kill --all ==="
+# A few long-lived sleepers mark the container's processes; the forkers
+# then start and reap short-lived children as fast as they can
+with_script 'for i in 1 2 3; do sleep 4242 & done; for i in 1 2 3 4; do (while true; do true & done) & done; wait'
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+sudo ./hackontainer start ${CONTAINER} >/dev/null 2>&1
+sleep 1
+if [ "$(count_marked 4242)" != "3" ]; then
+    echo "FAIL: fork bomb did not start"
+    exit 1
+fi
+
+sudo ./hackontainer kill --all ${CONTAINER} KILL
+if ! wait_stopped; then
+    echo "FAIL: container still $(state .status) after kill --all"
+    exit 1
+fi
+echo "PASS: container stopped"
+# The cgroup is only removed once it is empty
+if [ -e /sys/fs/cgroup/hackontainer/${CONTAINER} ] || [ -e /sys/fs/cgroup/pids/hackontainer/${CONTAINER} ]; then
+    echo "FAIL: processes survived kill --all"
+    exit 1
+fi
+if [ "$(count_marked 4242)" != "0" ]; then
+    echo "FAIL: sleepers survived kill --all"
+    exit 1
+fi
+echo "PASS: no process survived"
+sudo ./hackontainer delete ${CONTAINER}
+
+echo "=== kill --all reaches processes other than init ==="
+# init ignores TERM, but only once its children have started
+with_script 'for i in 1 2 3; do sleep 4343 & done; trap "" TERM; while true; do sleep 1; done'
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+sudo ./hackontainer start ${CONTAINER} >/dev/null 2>&1
+sleep 1
+if [ "$(count_marked 4343)" != "3" ]; then
+    echo "FAIL: expected 3 children, found $(count_marked 4343)"
+    exit 1
+fi
+
+sudo ./hackontainer kill --all ${CONTAINER} TERM
+sleep 0.5
+if [ "$(count_marked 4343)" != "0" ]; then
+    echo "FAIL: children survived kill --all TERM"
+    exit 1
+fi
+echo "PASS: every child got the signal"
+if [ "$(state .status)" != "running" ]; then
+    echo "FAIL: init should have ignored TERM"
+    exit 1
+fi
+echo "PASS: init ignored it"
+
+sudo ./hackontainer kill --all ${CONTAINER} KILL
+wait_stopped
+sudo ./hackontainer delete ${CONTAINER}
+
+echo "=== Without a cgroup, kill --all signals the init's process group ==="
+with_script 'for i in 1 2 3; do sleep 4444 & done; trap "" TERM; while true; do sleep 1; done'
+sudo ./hackontainer --cgroups none create --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+sudo ./hackontainer start ${CONTAINER} >/dev/null 2>&1
+sleep 1
+if [ "$(state .cgroupPath)" != "null" ]; then
+    echo "FAIL: expected no cgroup, got $(state .cgroupPath)"
+    exit 1
+fi
+if [ "$(count_marked 4444)" != "3" ]; then
+    echo "FAIL: expected 3 children, found $(count_marked 4444)"
+    exit 1
+fi
+
+sudo ./hackontainer kill --all ${CONTAINER} TERM
+sleep 0.5
+if [ "$(count_marked 4444)" != "0" ]; then
+    echo "FAIL: children survived kill --all TERM without a cgroup"
+    exit 1
+fi
+echo "PASS: every child got the signal"
+
+sudo ./hackontainer kill --all ${CONTAINER} KILL
+if ! wait_stopped; then
+    echo "FAIL: container still $(state .status) after kill --all without a cgroup"
+    exit 1
+fi
+echo "PASS: container stopped"
+sudo ./hackontainer delete ${CONTAINER}
+
+echo "=== kill --all needs a running container ==="
+if sudo ./hackontainer kill --all ${CONTAINER} KILL 2>/dev/null; then
+    echo "FAIL: kill --all of a missing container succeeded"
+    exit 1
+fi
+echo "PASS: refused"
+
+echo "=== All kill --all tests passed ==="
@@ -0,0 +1,91 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mykillcreated"
+BUNDLE="test-bundles/busybox"
+STATE_DIR="/run/hackontainer/${CONTAINER}"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf ${STATE_DIR}
+
+cleanup() {
+    sudo ./hackontainer kill ${CONTAINER} SIGKILL >/dev/null 2>&1 && sleep 1 || true
+    sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true
+}
+trap cleanup EXIT
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false | .process.args = ["sleep", "100"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1: expected '$3', got '$2'"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+state() {
+    sudo ./hackontainer state ${CONTAINER} | jq -r "$1"
+}
+
+# stopped waits for the monitor to record the container stopped
+stopped() {
+    for i in $(seq 30); do
+        [ "$(state .status)" = "stopped" ] && return
+        sleep 0.1
+    done
+}
+
+# <signal> <exit status>
+for CASE in "KILL 137" "TERM 143" "USR1 138" "RTMIN+2 164"; do
+    set -- ${CASE}
+    echo "=== SIG$1 stops a container waiting for start ==="
+    sudo ./hackontainer create --create-mode full --bundle ${BUNDLE} ${CONTAINER} >/dev/null
+    check "created" "$(state .status)" "created"
+    sudo ./hackontainer kill ${CONTAINER} $1
+    stopped
+    check "SIG$1: stopped" "$(state .status)" "stopped"
+    check "SIG$1: exit status" "$(state .exitStatus)" "$2"
+    if sudo test -e ${STATE_DIR}/exec.fifo; then
+        echo "FAIL: SIG$1: exec fifo left behind"
+        exit 1
+    fi
+    echo "PASS: SIG$1: exec fifo removed"
+    if OUT=$(sudo ./hackontainer start ${CONTAINER} 2>&1); then
+        echo "FAIL: SIG$1: started a stopped container"
+        exit 1
+    fi
+    if ! echo "${OUT}" | grep -q "cannot start a container that has stopped"; then
+        echo "FAIL: SIG$1: unexpected error: ${OUT}"
+        exit 1
+    fi
+    echo "PASS: SIG$1: ${OUT}"
+    sudo ./hackontainer delete ${CONTAINER}
+    echo "PASS: SIG$1: deleted"
+done
+
+echo "=== A signal that doesn't end a process leaves it waiting ==="
+sudo ./hackontainer create --create-mode full --bundle ${BUNDLE} ${CONTAINER} >/dev/null
+sudo ./hackontainer kill ${CONTAINER} WINCH
+sleep 1
+check "still created" "$(state .status)" "created"
+sudo ./hackontainer start ${CONTAINER}
+check "starts" "$(state .status)" "running"
@@ -0,0 +1,123 @@
+#!/bin/bash
+set -e
+
+PREFIX="mylabels"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+for i in 1 2 3; do
+    sudo rm -rf /run/hackontainer/${PREFIX}${i}
+done
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false | .process.args = ["true"] | .annotations = {"from.bundle": "yes"}' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+trap 'for i in 1 2 3; do sudo ./hackontainer delete ${PREFIX}${i} >/dev/null 2>&1 || true; done' EXIT
+
+SINCE=$(date -u +%Y-%m-%dT%H:%M:%S.%NZ)
+
+echo "=== Creating labeled containers ==="
+sudo ./hackontainer create --bundle ${BUNDLE} --label env=prod --label team=core ${PREFIX}1 >/dev/null 2>&1
+sudo ./hackontainer create --bundle ${BUNDLE} --label env=dev --label team=core ${PREFIX}2 >/dev/null 2>&1
+sudo ./hackontainer create --bundle ${BUNDLE} ${PREFIX}3 >/dev/null 2>&1
+
+echo "=== Labels are kept apart from annotations and the OCI state ==="
+STATE=$(sudo ./hackontainer state ${PREFIX}1 | grep -v "^>>>")
+if echo "${STATE}" | grep -q "prod"; then
+    echo "FAIL: the state shows the labels: ${STATE}"
+    exit 1
+fi
+if [ "$(echo "${STATE}" | jq -r '.annotations["from.bundle"]')" != "yes" ]; then
+    echo "FAIL: the state lost the bundle's annotations: ${STATE}"
+    exit 1
+fi
+echo "PASS: state shows annotations but not labels"
+
+# Every command loads the container afresh, so this also checks the
+# labels persist
+LABELS=$(sudo ./hackontainer inspect ${PREFIX}1 | grep -v "^>>>" | jq -c .labels)
+if [ "${LABELS}" != '{"env":"prod","team":"core"}' ]; then
+    echo "FAIL: inspect shows labels ${LABELS}"
+    exit 1
+fi
+echo "PASS: inspect shows the labels"
+
+LABELS=$(sudo ./hackontainer inspect ${PREFIX}3 | grep -v "^>>>" | jq -c .labels)
+if [ "${LABELS}" != "null" ]; then
+    echo "FAIL: an unlabeled container shows labels ${LABELS}"
+    exit 1
+fi
+echo "PASS: an unlabeled container has none"
+
+echo "=== Invalid labels ==="
+for label in "nokey" "=value" "$(printf 'bad\tkey=v')"; do
+    if sudo ./hackontainer create --bundle ${BUNDLE} --label "${label}" ${PREFIX}9 >/dev/null 2>&1; then
+        sudo ./hackontainer delete ${PREFIX}9 >/dev/null 2>&1 || true
+        echo "FAIL: label '${label}' was accepted"
+        exit 1
+    fi
+done
+echo "PASS: labels without a key=value form or with a bad key are rejected"
+
+echo "=== Events carry labels and filter by them ==="
+for i in 1 2 3; do
+    sudo ./hackontainer delete ${PREFIX}${i} >/dev/null 2>&1
+done
+
+# events_ids <filters...> lists the containers whose delete event matches
+events_ids() {
+    local args=()
+    for filter in "$@"; do
+        args+=(--filter "${filter}")
+    done
+    sudo ./hackontainer events --all --since "${SINCE}" --filter "id=${PREFIX}*" "${args[@]}" | grep -v "^>>>" |
+        jq -r 'select(.type == "delete") | .id' | sort | tr '\n' ' '
+}
+
+DELETE=$(sudo ./hackontainer events --all --since "${SINCE}" --filter "id=${PREFIX}1" | grep -v "^>>>" | jq -c 'select(.type == "delete") | .labels')
+if [ "${DELETE}" != '{"env":"prod","team":"core"}' ]; then
+    echo "FAIL: the delete event carries labels ${DELETE}"
+    exit 1
+fi
+echo "PASS: the delete event carries the labels"
+
+expect_ids() {
+    local want="$1"
+    shift
+    local got
+    got=$(events_ids "$@")
+    if [ "${got}" != "${want}" ]; then
+        echo "FAIL: filters $* matched '${got}', want '${want}'"
+        exit 1
+    fi
+    echo "PASS: filters $* match '${want}'"
+}
+expect_ids "${PREFIX}1 ${PREFIX}2 " label=team
+expect_ids "${PREFIX}1 " label=env=prod
+expect_ids "${PREFIX}2 " label=team=core label=env=dev
+expect_ids "" label=env=prod label=env=dev
+expect_ids "" label=missing
+
+if sudo ./hackontainer events --all --filter label= >/dev/null 2>&1; then
+    echo "FAIL: an empty label filter was accepted"
+    exit 1
+fi
+echo "PASS: an empty label filter is rejected"
+
+echo "=== All label tests passed ==="
@@ -0,0 +1,157 @@
+#!/bin/bash
+set -e
+
+BUNDLE="test-bundles/busybox"
+FIXTURES="test/layout-fixtures"
+STATE_ROOT="/run/hackontainer"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+for id in layout-unfrozen layout-frozen layout-fresh; do
+    sudo rm -rf ${STATE_ROOT}/${id}
+done
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false | .process.args = ["true"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+ABS_BUNDLE=$(cd ${BUNDLE} && pwd)
+
+# The fixtures are container roots as earlier runtimes left them, with
+# the bundle path replaced by @BUNDLE@: layout-unfrozen from before
+# configs were frozen, layout-frozen from just before the layout file.
+install_fixture() {
+    sudo mkdir -m 0711 ${STATE_ROOT}/$1
+    for f in ${FIXTURES}/$1/*; do
+        sed "s#@BUNDLE@#${ABS_BUNDLE}#g" $f | sudo tee ${STATE_ROOT}/$1/$(basename $f) >/dev/null
+    done
+}
+
+layout_version() {
+    sudo cat ${STATE_ROOT}/$1/layout | jq -r .version
+}
+
+echo "=== A root without a frozen config is migrated on load ==="
+install_fixture layout-unfrozen
+sudo ./hackontainer state layout-unfrozen >/dev/null
+if [ "$(layout_version layout-unfrozen)" != "1" ]; then
+    echo "FAIL: expected layout version 1, got $(layout_version layout-unfrozen)"
+    exit 1
+fi
+ARGS=$(sudo cat ${STATE_ROOT}/layout-unfrozen/config.json | jq -c .process.args)
+if [ "${ARGS}" != '["true"]' ]; then
+    echo "FAIL: expected the bundle's config to be frozen, got args ${ARGS}"
+    exit 1
+fi
+CGROUP=$(sudo ./hackontainer state layout-unfrozen | jq -r .cgroupPath)
+if [ "${CGROUP}" != "/hackontainer/layout-unfrozen" ]; then
+    echo "FAIL: expected the cgroup path to be recorded, got ${CGROUP}"
+    exit 1
+fi
+echo "PASS: config frozen from the bundle and cgroup path recorded"
+
+echo "=== Loading a migrated root again changes nothing ==="
+BEFORE=$(sudo sh -c "cd ${STATE_ROOT}/layout-unfrozen && md5sum *")
+sudo ./hackontainer state layout-unfrozen >/dev/null
+AFTER=$(sudo sh -c "cd ${STATE_ROOT}/layout-unfrozen && md5sum *")
+if [ "${BEFORE}" != "${AFTER}" ]; then
+    echo "FAIL: a second load rewrote the root"
+    exit 1
+fi
+echo "PASS: migration is not repeated"
+
+echo "=== The migrated container runs its frozen config ==="
+# Editing the bundle now must not affect the container
+jq '.process.args = ["false"]' ${BUNDLE}/config.json > ${BUNDLE}/config.json.edited
+cp ${BUNDLE}/config.json ${BUNDLE}/config.json.orig
+mv ${BUNDLE}/config.json.edited ${BUNDLE}/config.json
+sudo ./hackontainer start layout-unfrozen
+for i in $(seq 1 50); do
+    STATUS=$(sudo ./hackontainer state layout-unfrozen | jq -r .status)
+    [ "${STATUS}" = "stopped" ] && break
+    sleep 0.1
+done
+EXIT=$(sudo ./hackontainer state layout-unfrozen | jq -r .exitStatus)
+mv ${BUNDLE}/config.json.orig ${BUNDLE}/config.json
+if [ "${EXIT}" != "0" ]; then
+    echo "FAIL: expected the frozen args to exit 0, got ${EXIT}"
+    exit 1
+fi
+sudo ./hackontainer delete layout-unfrozen
+echo "PASS: migrated container started and deleted"
+
+echo "=== A migration interrupted after freezing the config completes ==="
+install_fixture layout-unfrozen
+sudo cp ${FIXTURES}/layout-frozen/config.json ${STATE_ROOT}/layout-unfrozen/config.json.tmp
+sudo sed -i "s#@BUNDLE@#${ABS_BUNDLE}#g; s#\"args\":\[\"true\"\]#\"args\":[\"sh\"]#" \
+    ${STATE_ROOT}/layout-unfrozen/config.json.tmp
+sudo mv ${STATE_ROOT}/layout-unfrozen/config.json.tmp ${STATE_ROOT}/layout-unfrozen/config.json
+sudo ./hackontainer state layout-unfrozen >/dev/null
+ARGS=$(sudo cat ${STATE_ROOT}/layout-unfrozen/config.json | jq -c .process.args)
+if [ "${ARGS}" != '["sh"]' ] || [ "$(layout_version layout-unfrozen)" != "1" ]; then
+    echo "FAIL: expected the config frozen earlier to be kept, got args ${ARGS}"
+    exit 1
+fi
+sudo ./hackontainer delete layout-unfrozen
+echo "PASS: the earlier attempt's config is kept"
+
+echo "=== A root with a frozen config only gains the layout file ==="
+install_fixture layout-frozen
+sudo ./hackontainer state layout-frozen >/dev/null
+if [ "$(layout_version layout-frozen)" != "1" ]; then
+    echo "FAIL: expected layout version 1, got $(layout_version layout-frozen)"
+    exit 1
+fi
+if ! sed "s#@BUNDLE@#${ABS_BUNDLE}#g" ${FIXTURES}/layout-frozen/config.json |
+    sudo cmp -s - ${STATE_ROOT}/layout-frozen/config.json; then
+    echo "FAIL: the frozen config was rewritten"
+    exit 1
+fi
+echo "PASS: frozen config kept as is"
+
+echo "=== A layout newer than the runtime is refused ==="
+echo '{"version": 99}' | sudo tee ${STATE_ROOT}/layout-frozen/layout >/dev/null
+for op in state delete; do
+    out=$(sudo ./hackontainer ${op} layout-frozen 2>&1 | grep -v "^>>>" || true)
+    if ! echo "${out}" | grep -q "state layout version 99, but this hackontainer only understands up to 1"; then
+        echo "FAIL: ${op}: expected a layout error, got: ${out}"
+        exit 1
+    fi
+done
+sudo rm -rf ${STATE_ROOT}/layout-frozen
+echo "PASS: state and delete refuse a newer container layout"
+
+ROOT=$(mktemp -d)
+echo '{"version": 99}' > ${ROOT}/layout
+out=$(sudo ./hackontainer --root ${ROOT} state layout-frozen 2>&1 | grep -v "^>>>" || true)
+rm -rf ${ROOT}
+if ! echo "${out}" | grep -q "root ${ROOT} has state layout version 99"; then
+    echo "FAIL: expected a root layout error, got: ${out}"
+    exit 1
+fi
+echo "PASS: a newer root layout is refused"
+
+echo "=== New containers get the current layout ==="
+sudo ./hackontainer create --bundle ${BUNDLE} layout-fresh >/dev/null
+if [ "$(layout_version layout-fresh)" != "1" ]; then
+    echo "FAIL: expected layout version 1, got $(layout_version layout-fresh)"
+    exit 1
+fi
+sudo ./hackontainer delete layout-fresh
+echo "PASS: create writes the current layout"
+
+echo "=== All layout migration tests passed ==="
@@ -0,0 +1,117 @@
+#!/bin/bash
+set -e
+
+BUNDLE="test-bundles/busybox"
+ROOT="/run/hackontainer-list"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf ${ROOT}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false | .process.args = ["sleep", "30"]' ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+
+# hk runs the runtime against a root of its own, so only the containers
+# made here are listed
+hk() {
+    sudo ./hackontainer --root ${ROOT} "$@"
+}
+
+cleanup() {
+    hk kill list-running SIGKILL >/dev/null 2>&1 && sleep 1
+    for id in list-created list-running; do
+        hk delete ${id} >/dev/null 2>&1 || true
+    done
+    sudo rm -rf ${ROOT}
+}
+trap cleanup EXIT
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1: got '$2', want '$3'"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+echo "=== An empty root lists nothing ==="
+check "empty table has only its header" "$(hk list 2>&1 | grep -v "^>>>")" "ID  PID  STATUS  BUNDLE  CREATED  RUNTIME"
+check "empty json list" "$(hk list --format json 2>&1 | grep -v "^>>>")" "[]"
+
+echo "=== Containers are listed with their state ==="
+hk create --bundle ${BUNDLE} --label env=prod --label tier=web list-created >/dev/null 2>&1
+hk create --bundle ${BUNDLE} --label env=dev list-running >/dev/null 2>&1
+hk start list-running >/dev/null 2>&1
+RUNNING_PID=$(hk state list-running | grep -v "^>>>" | jq -r .pid)
+
+TABLE=$(hk list 2>&1 | grep -v "^>>>")
+echo "${TABLE}"
+check "table lists both containers in id order" "$(echo "${TABLE}" | awk 'NR > 1 {print $1}' | tr '\n' ' ')" "list-created list-running "
+check "created container's row" "$(echo "${TABLE}" | awk '$1 == "list-created" {print $2, $3}')" "0 created"
+check "running container's row" "$(echo "${TABLE}" | awk '$1 == "list-running" {print $2, $3}')" "${RUNNING_PID} running"
+ABS_BUNDLE=$(cd ${BUNDLE} && pwd)
+check "bundle column" "$(echo "${TABLE}" | awk '$1 == "list-running" {print $4}')" "${ABS_BUNDLE}"
+
+JSON=$(hk list --format json | grep -v "^>>>")
+check "json status" "$(echo "${JSON}" | jq -r '.[] | select(.id == "list-running") | .status')" "running"
+check "json carries labels" "$(echo "${JSON}" | jq -r '.[] | select(.id == "list-created") | .labels.tier')" "web"
+check "quiet prints ids only" "$(hk list --quiet | grep -v "^>>>" | tr '\n' ' ')" "list-created list-running "
+
+echo "=== Filters ==="
+check "label key and value" "$(hk list --quiet --filter label=env=prod | grep -v "^>>>")" "list-created"
+check "label key alone" "$(hk list --quiet --filter label=tier | grep -v "^>>>")" "list-created"
+check "id glob" "$(hk list --quiet --filter 'id=*-running' | grep -v "^>>>")" "list-running"
+check "filters are ANDed" "$(hk list --quiet --filter label=env --filter 'id=*-running' | grep -v "^>>>")" "list-running"
+if hk list --filter bogus >/dev/null 2>&1; then
+    echo "FAIL: an invalid filter was accepted"
+    exit 1
+fi
+echo "PASS: an invalid filter is rejected"
+if hk list --format yaml >/dev/null 2>&1; then
+    echo "FAIL: an unknown format was accepted"
+    exit 1
+fi
+echo "PASS: an unknown format is rejected"
+
+echo "=== Broken entries are reported without failing the listing ==="
+# A corrupt state.json, and a create that died before writing one
+sudo mkdir ${ROOT}/list-corrupt ${ROOT}/list-half
+sudo cp ${ROOT}/list-created/layout ${ROOT}/list-corrupt/
+sudo cp ${ROOT}/list-created/layout ${ROOT}/list-half/
+echo '{"id":' | sudo tee ${ROOT}/list-corrupt/state.json >/dev/null
+
+if ! OUT=$(hk list --quiet 2>/tmp/list-stderr); then
+    echo "FAIL: list failed with broken entries: $(cat /tmp/list-stderr)"
+    exit 1
+fi
+check "intact containers are still listed" "$(echo "${OUT}" | grep -v "^>>>" | tr '\n' ' ')" "list-created list-running "
+for id in list-corrupt list-half; do
+    if ! grep -q "WARNING: ${id}:" /tmp/list-stderr; then
+        echo "FAIL: ${id} not reported: $(cat /tmp/list-stderr)"
+        exit 1
+    fi
+done
+echo "PASS: broken entries are reported on stderr"
+if ! grep -q "list-half: no state" /tmp/list-stderr; then
+    echo "FAIL: the half-created entry's error doesn't say it has no state: $(cat /tmp/list-stderr)"
+    exit 1
+fi
+echo "PASS: the half-created entry is named as such"
+rm -f /tmp/list-stderr
+
+echo "=== All list tests passed ==="
@@ -0,0 +1,131 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mylockfiles"
+BUNDLE="test-bundles/busybox"
+ROOT="/run/hackontainer-lockfiles"
+EVENTS_ROOT="/run/hackontainer-lockfiles-events"
+NO_FLOCK="HACKONTAINER_TEST_NO_FLOCK=1"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf ${ROOT} ${EVENTS_ROOT}
+
+# hk runs the runtime as on a root without flock
+hk() {
+    sudo env ${NO_FLOCK} ./hackontainer --root ${ROOT} "$@"
+}
+
+cleanup() {
+    hk kill ${CONTAINER} SIGKILL >/dev/null 2>&1 && sleep 1 || true
+    hk delete --force ${CONTAINER} >/dev/null 2>&1 || true
+    sudo rm -rf ${ROOT} ${EVENTS_ROOT} || true
+}
+trap cleanup EXIT
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false | .process.args = ["sleep", "100"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+
+HOST=$(hostname)
+BOOT=$(cat /proc/sys/kernel/random/boot_id)
+LOCK=${ROOT}/${CONTAINER}/lock
+
+# hold <contents> leaves a lock file in the container directory
+hold() {
+    echo "$1" | sudo tee ${LOCK} >/dev/null
+}
+
+echo "=== The downgrade is reported once ==="
+FIRST=$(hk list 2>&1 >/dev/null)
+SECOND=$(hk list 2>&1 >/dev/null)
+if ! echo "${FIRST}" | grep -q "doesn't support flock"; then
+    echo "FAIL: no warning on first use: ${FIRST}"
+    exit 1
+fi
+if [ -n "${SECOND}" ]; then
+    echo "FAIL: warned again: ${SECOND}"
+    exit 1
+fi
+if ! sudo test -e ${ROOT}/file-locks; then
+    echo "FAIL: root not marked"
+    exit 1
+fi
+echo "PASS: downgrade reported once"
+
+echo "=== Containers are created using lock files ==="
+hk create --bundle ${BUNDLE} ${CONTAINER}
+hk start ${CONTAINER}
+if ! sudo test -e ${ROOT}/${CONTAINER}/file-locks; then
+    echo "FAIL: container not marked"
+    exit 1
+fi
+hk pause ${CONTAINER}
+hk resume ${CONTAINER}
+if sudo test -e ${LOCK}; then
+    echo "FAIL: lock file left behind: $(sudo cat ${LOCK})"
+    exit 1
+fi
+echo "PASS: lock files taken and released"
+
+echo "=== A lock of an exited process is broken ==="
+sleep 0 &
+DEAD=$!
+wait ${DEAD}
+hold "${HOST} ${DEAD} ${BOOT}"
+timeout 10 sudo env ${NO_FLOCK} ./hackontainer --root ${ROOT} pause ${CONTAINER}
+hk resume ${CONTAINER}
+echo "PASS: dead holder's lock broken"
+
+echo "=== A lock of an earlier boot is broken ==="
+hold "${HOST} 1 00000000-0000-0000-0000-000000000000"
+timeout 10 sudo env ${NO_FLOCK} ./hackontainer --root ${ROOT} pause ${CONTAINER}
+hk resume ${CONTAINER}
+echo "PASS: earlier boot's lock broken"
+
+echo "=== A lock of a live process is waited for ==="
+hold "${HOST} $$ ${BOOT}"
+if timeout 3 sudo env ${NO_FLOCK} ./hackontainer --root ${ROOT} pause ${CONTAINER}; then
+    echo "FAIL: paused while the lock was held"
+    exit 1
+fi
+sudo rm -f ${LOCK}
+echo "PASS: live holder's lock waited for"
+
+echo "=== The marks keep lock files in use without the override ==="
+hold "${HOST} $$ ${BOOT}"
+if timeout 3 sudo ./hackontainer --root ${ROOT} pause ${CONTAINER}; then
+    echo "FAIL: paused while the lock was held"
+    exit 1
+fi
+sudo rm -f ${LOCK}
+sudo ./hackontainer --root ${ROOT} pause ${CONTAINER}
+sudo ./hackontainer --root ${ROOT} resume ${CONTAINER}
+echo "PASS: lock files used without the override"
+
+hk kill ${CONTAINER} SIGKILL
+sleep 1
+hk delete ${CONTAINER}
+
+echo "=== Concurrent lifecycles under lock files ==="
+ROOT=${EVENTS_ROOT} LOCK_ENV=${NO_FLOCK} ./test-events.sh
+if ! sudo test -e ${EVENTS_ROOT}/file-locks; then
+    echo "FAIL: lifecycles didn't run under lock files"
+    exit 1
+fi
+echo "PASS: lock files serialise concurrent lifecycles"
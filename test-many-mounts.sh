@@ -0,0 +1,108 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mymanymounts"
+BUNDLE="test-bundles/busybox"
+COUNT=200
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+trap 'sudo ./hackontainer delete --force ${CONTAINER} >/dev/null 2>&1 || true; rm -rf ${BUNDLE}/volumes' EXIT
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+# One volume per mount, each holding its own number, the way a pod
+# mounts its secrets and config maps
+for i in $(seq 1 ${COUNT}); do
+    mkdir -p ${BUNDLE}/volumes/v${i}
+    echo ${i} > ${BUNDLE}/volumes/v${i}/value
+done
+mkdir -p ${BUNDLE}/volumes/inner ${BUNDLE}/volumes/last
+echo inner > ${BUNDLE}/volumes/inner/value
+echo last > ${BUNDLE}/volumes/last/value
+
+# The volumes are read-only binds below /run/secrets. The last one is
+# bound a second time, which must win, and a tmpfs gets a bind inside
+# it, which must come after it
+jq --argjson count ${COUNT} '.process.terminal = false |
+    .mounts += [range(1; $count + 1) as $i |
+        {destination: "/run/secrets/vol\($i)", type: "bind", source: "volumes/v\($i)", options: ["rbind", "ro", "rprivate"]}] |
+    .mounts += [{destination: "/run/secrets/vol\($count)", type: "bind", source: "volumes/last", options: ["rbind", "ro"]},
+        {destination: "/run/nested", type: "tmpfs", source: "tmpfs", options: ["nosuid"]},
+        {destination: "/run/nested/inner", type: "bind", source: "volumes/inner", options: ["bind", "ro"]},
+        {destination: "/run/private", type: "bind", source: "volumes/inner", options: ["bind", "shared", "rprivate", "private"]},
+        {destination: "/run/shared", type: "bind", source: "volumes/inner", options: ["bind", "private", "rshared"]}]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.orig
+
+# run_with <args> runs the container running args, leaving its output
+# in OUTPUT
+run_with() {
+    jq --argjson args "$1" '.process.args = $args' ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+    OUTPUT=$(sudo ./hackontainer run --bundle ${BUNDLE} ${CONTAINER} 2>&1)
+}
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "${OUTPUT}"
+        echo "FAIL: $1: got '$2', want '$3'"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+echo "=== ${COUNT} mounts are applied in order and stay read-only ==="
+SCRIPT='
+wrong=0; writable=0
+for i in $(seq 1 $((COUNT - 1))); do
+    [ "$(cat /run/secrets/vol$i/value)" = "$i" ] || wrong=$((wrong + 1))
+    touch /run/secrets/vol$i/new 2>/dev/null && writable=$((writable + 1))
+done
+echo "wrong=$wrong writable=$writable"
+echo "last=$(cat /run/secrets/vol$COUNT/value)"
+echo "inner=$(cat /run/nested/inner/value)"
+grep " /run/private " /proc/self/mountinfo | grep -q "shared:" && echo private=no || echo private=yes
+grep " /run/shared " /proc/self/mountinfo | grep -q "shared:" && echo shared=yes || echo shared=no
+'
+run_with "$(jq -n --arg script "COUNT=${COUNT}; ${SCRIPT}" '["sh", "-c", $script]')"
+check "every volume has its own content" "$(echo "${OUTPUT}" | grep "^wrong=" | cut -d' ' -f1)" "wrong=0"
+check "every volume is read-only" "$(echo "${OUTPUT}" | grep "^wrong=" | cut -d' ' -f2)" "writable=0"
+check "a later mount at the same destination wins" "$(echo "${OUTPUT}" | grep "^last=")" "last=last"
+check "a mount inside an earlier one is visible" "$(echo "${OUTPUT}" | grep "^inner=")" "inner=inner"
+check "the last propagation change holds" "$(echo "${OUTPUT}" | grep "^private=")" "private=yes"
+check "a recursive change after another holds" "$(echo "${OUTPUT}" | grep "^shared=")" "shared=yes"
+echo "$(echo "${OUTPUT}" | grep "Applied [0-9]* mounts")"
+if [ -e ${BUNDLE}/volumes/v1/new ]; then
+    echo "FAIL: a write reached a volume"
+    exit 1
+fi
+
+echo "=== A failing mount is named by its index ==="
+INDEX=$(jq '[.mounts[].destination] | index("/run/secrets/vol150")' ${BUNDLE}/config.json.orig)
+rm -r ${BUNDLE}/volumes/v150
+if run_with '["true"]'; then
+    echo "FAIL: ran without the source of a mount"
+    exit 1
+fi
+if ! echo "${OUTPUT}" | grep -q "failed to mount mounts\[${INDEX}\] /run/secrets/vol150: "; then
+    echo "${OUTPUT}"
+    echo "FAIL: error doesn't name mounts[${INDEX}]"
+    exit 1
+fi
+echo "PASS: error names mounts[${INDEX}] /run/secrets/vol150"
+
+echo "=== All many-mounts tests passed ==="
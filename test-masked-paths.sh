@@ -0,0 +1,90 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mymasked"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1: got '$2', want '$3'"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+echo "=== The spec command masks hardware information by default ==="
+rm -f ${BUNDLE}/config.json
+./hackontainer spec --bundle ${BUNDLE}
+for path in /sys/firmware /sys/class/dmi /proc/device-tree /proc/kcore; do
+    check "generated spec masks ${path}" \
+        "$(jq --arg p ${path} '.linux.maskedPaths | index($p) != null' ${BUNDLE}/config.json)" "true"
+done
+check "generated spec makes /proc/sys read-only" \
+    "$(jq '.linux.readonlyPaths | index("/proc/sys") != null' ${BUNDLE}/config.json)" "true"
+if ./hackontainer spec --bundle ${BUNDLE} 2>/dev/null; then
+    echo "FAIL: spec overwrote an existing config.json"
+    exit 1
+fi
+echo "PASS: spec leaves an existing config.json alone"
+
+# /sys/class/net/lo is a symlink into /sys/devices/virtual, and exists in
+# every network namespace; /sys/class/dmi/id may not exist at all
+jq '.process.terminal = false |
+    .linux.maskedPaths += ["/sys/class/net/lo", "/sys/class/dmi/id", "/does/not/exist"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+
+# run_in prints what a shell command printed inside the container
+run_in() {
+    local output
+    output=$(sudo ./hackontainer run --bundle ${BUNDLE} --replace-args ${CONTAINER} -- sh -c "$1" 2>/dev/null | grep -v "^>>>")
+    sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true
+    echo "${output}"
+}
+
+echo "=== Masked directories are empty ==="
+check "/sys/firmware is empty" "$(run_in 'ls -A /sys/firmware | wc -l')" "0"
+check "/sys/firmware is read-only" "$(run_in 'mkdir /sys/firmware/x 2>/dev/null && echo writable || echo ro')" "ro"
+if [ -e /sys/class/dmi/id ]; then
+    check "/sys/class/dmi/id is empty" "$(run_in 'ls -A /sys/class/dmi/id/ | wc -l')" "0"
+fi
+
+echo "=== A symlink in sysfs masks what it points to ==="
+check "/sys/class/net/lo is still a link" "$(run_in '[ -L /sys/class/net/lo ] && echo link')" "link"
+check "its target is empty" "$(run_in 'ls -A /sys/class/net/lo/ | wc -l')" "0"
+check "the resolved path is empty too" "$(run_in 'ls -A /sys/devices/virtual/net/lo | wc -l')" "0"
+
+echo "=== Masked files read as empty ==="
+check "/proc/kcore is empty" "$(run_in 'cat /proc/kcore | wc -c')" "0"
+check "/proc/keys is empty" "$(run_in 'cat /proc/keys | wc -c')" "0"
+
+echo "=== Read-only paths ==="
+check "/proc/sys is read-only" \
+    "$(run_in 'echo other > /proc/sys/kernel/hostname 2>/dev/null && echo writable || echo ro')" "ro"
+check "/proc/sys is still readable" "$(run_in 'cat /proc/sys/kernel/hostname')" "hackontainer"
+
+echo "=== Relative paths are refused ==="
+jq '.linux.maskedPaths += ["relative/path"]' ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+if sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1; then
+    sudo ./hackontainer delete ${CONTAINER}
+    echo "FAIL: accepted a relative masked path"
+    exit 1
+fi
+echo "PASS: refused a relative masked path"
+
+echo "=== All masked path tests passed ==="
@@ -0,0 +1,145 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mymaxruntime"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+# sleep as pid 1 ignores SIGTERM, so only the SIGKILL after the grace
+# period stops it
+jq '.process.terminal = false | .process.args = ["sleep", "100"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+cp ${BUNDLE}/config.json ${BUNDLE}/config.json.orig
+cleanup() {
+    sudo ./hackontainer kill ${CONTAINER} SIGKILL >/dev/null 2>&1 && sleep 1 || true
+    sudo ./hackontainer delete --force ${CONTAINER} >/dev/null 2>&1 || true
+    mv ${BUNDLE}/config.json.orig ${BUNDLE}/config.json || true
+}
+trap cleanup EXIT
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1: got '$2', want '$3'"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+# refused <what> <want> <command...> runs a command that must fail and
+# name why
+refused() {
+    local desc=$1 want=$2
+    shift 2
+    local out
+    if out=$(sudo "$@" 2>&1); then
+        echo "FAIL: $desc succeeded"
+        exit 1
+    fi
+    if ! echo "$out" | grep -q -- "$want"; then
+        echo "FAIL: $desc: expected '$want' in: $out"
+        exit 1
+    fi
+    echo "PASS: $desc is refused"
+}
+
+state() {
+    sudo ./hackontainer state ${CONTAINER} | grep -v "^>>>" | jq -r "$1"
+}
+
+# launch <create flags...> creates and starts the container afresh
+launch() {
+    sudo ./hackontainer kill ${CONTAINER} SIGKILL >/dev/null 2>&1 && sleep 1 || true
+    sudo ./hackontainer delete --force ${CONTAINER} >/dev/null 2>&1 || true
+    sudo ./hackontainer create --bundle ${BUNDLE} "$@" ${CONTAINER} >/dev/null 2>&1
+    sudo ./hackontainer start ${CONTAINER} >/dev/null 2>&1
+}
+
+echo "=== Bad limits are refused ==="
+refused "a max runtime that isn't a duration" "invalid max runtime" \
+    ./hackontainer create --bundle ${BUNDLE} --max-runtime soon ${CONTAINER}
+refused "a zero max runtime" "invalid max runtime" \
+    ./hackontainer create --bundle ${BUNDLE} --max-runtime 0s ${CONTAINER}
+refused "--stop-signal without --max-runtime" "need --max-runtime" \
+    ./hackontainer create --bundle ${BUNDLE} --stop-signal SIGINT ${CONTAINER}
+
+echo "=== A container outrunning its limit is killed ==="
+SINCE=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+launch --max-runtime 2s --stop-timeout 1s
+check "the limit is recorded" "$(state .maxRuntime.limit)" "2s"
+check "the grace period is recorded" "$(state .maxRuntime.gracePeriod)" "1s"
+sleep 1
+check "the container runs within its limit" "$(state .status)" "running"
+sleep 3
+check "the container stopped" "$(state .status)" "stopped"
+check "it is recorded as killed by the timeout" "$(state .killedByTimeout)" "true"
+check "the SIGKILL after the grace period stopped it" "$(state .exitStatus)" "137"
+EVENT=$(sudo ./hackontainer events --all --since "${SINCE}" --filter "id=${CONTAINER}" | grep -v "^>>>" |
+    jq -r 'select(.type == "timeout") | "\(.data.maxRuntime) \(.data.signal)"')
+check "a timeout event is recorded" "${EVENT}" "2s 15"
+
+echo "=== A process that handles the stop signal exits on it ==="
+launch --max-runtime 2s --stop-signal SIGINT --args sh --args -c \
+    --args 'trap "exit 7" INT; while true; do sleep 0.1; done' --replace-args
+sleep 3
+check "the container stopped" "$(state .status)" "stopped"
+check "it exited on the stop signal" "$(state .exitStatus)" "7"
+check "it is recorded as killed by the timeout" "$(state .killedByTimeout)" "true"
+
+echo "=== A container that exits in time isn't marked ==="
+launch --max-runtime 5s --args true --replace-args
+sleep 1
+check "the container stopped" "$(state .status)" "stopped"
+check "it isn't recorded as killed by the timeout" "$(state '.killedByTimeout // false')" "false"
+
+echo "=== The restart policy doesn't bring a timed-out container back ==="
+launch --max-runtime 1s --stop-timeout 0s --restart always
+sleep 3
+check "the container stays stopped" "$(state .status)" "stopped"
+check "it was never restarted" "$(state '.restartCount // 0')" "0"
+
+echo "=== Unless --restart-on-timeout lets it ==="
+launch --max-runtime 1s --stop-timeout 0s --restart on-failure:2 --restart-on-timeout
+sleep 6
+check "the container was restarted up to its limit" "$(state .restartCount)" "2"
+check "every run was timed" "$(state .killedByTimeout)" "true"
+
+echo "=== A paused container's clock keeps running ==="
+launch --max-runtime 2s --stop-timeout 1s
+sudo ./hackontainer pause ${CONTAINER} >/dev/null 2>&1
+check "the container is paused" "$(state .status)" "paused"
+sleep 4
+check "the paused container was killed" "$(state .status)" "stopped"
+check "it is recorded as killed by the timeout" "$(state .killedByTimeout)" "true"
+
+echo "=== The annotation sets a limit too ==="
+jq '.annotations["org.hackontainer.max-runtime"] = "1s"' ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+launch
+check "the annotation's limit is recorded" "$(state .maxRuntime.limit)" "1s"
+check "with the default signal" "$(state .maxRuntime.stopSignal)" "15"
+launch --max-runtime 1m
+check "--max-runtime overrides the annotation" "$(state .maxRuntime.limit)" "1m0s"
+jq '.annotations["org.hackontainer.max-runtime"] = "never"' ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+refused "an invalid annotation" "invalid org.hackontainer.max-runtime annotation" \
+    ./hackontainer create --bundle ${BUNDLE} ${CONTAINER}-bad
+
+echo ""
+echo "=== All max runtime tests passed ==="
@@ -0,0 +1,120 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mymountcleanup"
+BUNDLE="test-bundles/busybox"
+STATE="/run/hackontainer/${CONTAINER}"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf ${STATE}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+ABS_BUNDLE=$(cd ${BUNDLE} && pwd)
+
+jq '.process.args = ["sleep", "30"] | .process.terminal = false' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.orig
+cp ${BUNDLE}/config.json.orig ${BUNDLE}/config.json
+
+# The scratch rootfs has nested mounts of its own, which the pinned
+# rootfs clones
+mkdir -p ${BUNDLE}/rootfs/mnt/a ${BUNDLE}/rootfs/mnt/c
+sudo mount -t tmpfs scratch-a ${BUNDLE}/rootfs/mnt/a
+sudo mkdir -p ${BUNDLE}/rootfs/mnt/a/b
+sudo mount -t tmpfs scratch-b ${BUNDLE}/rootfs/mnt/a/b
+sudo mount -t tmpfs scratch-c ${BUNDLE}/rootfs/mnt/c
+echo deep | sudo tee ${BUNDLE}/rootfs/mnt/a/b/marker >/dev/null
+
+cleanup() {
+    sudo ./hackontainer delete --force ${CONTAINER} >/dev/null 2>&1 || true
+    sudo umount -R ${ABS_BUNDLE}/rootfs/mnt/a ${ABS_BUNDLE}/rootfs/mnt/c 2>/dev/null || true
+}
+trap cleanup EXIT
+
+# mounts_under counts the mounts at or below the container's state dir
+mounts_under() {
+    awk -v dir="${STATE}" '$5 == dir || index($5, dir "/") == 1' /proc/self/mountinfo | wc -l
+}
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1: got '$2', want '$3'"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+create_pinned() {
+    sudo bash -c "exec 7<${BUNDLE}/rootfs &&
+        ./hackontainer create --rootfs-fd 7 --bundle ${BUNDLE} ${CONTAINER}" 2>&1 | grep -v "^>>>" || true
+}
+
+echo "=== Delete unmounts everything under the pinned rootfs ==="
+create_pinned
+check "pinned rootfs and its three nested mounts are mounted" "$(mounts_under)" "4"
+
+# Mounts made under the pinned rootfs after create are found too
+sudo mount -t tmpfs later ${STATE}/rootfs/tmp
+sudo mkdir -p ${STATE}/rootfs/tmp/deeper
+sudo mount -t tmpfs later-deeper ${STATE}/rootfs/tmp/deeper
+sudo mount --bind ${STATE}/rootfs/mnt/c ${STATE}/rootfs/mnt/a/b
+check "later mounts are mounted" "$(mounts_under)" "7"
+
+sudo ./hackontainer delete ${CONTAINER} 2>&1 | grep -v "^>>>" || true
+check "nothing under the container root is left in mountinfo" "$(mounts_under)" "0"
+if [ -e ${STATE} ]; then
+    echo "FAIL: delete left ${STATE} behind"
+    exit 1
+fi
+echo "PASS: container root removed"
+check "the scratch rootfs's own mounts survive delete" \
+    "$(cat ${BUNDLE}/rootfs/mnt/a/b/marker)" "deep"
+
+echo "=== A mount still in use is detached ==="
+create_pinned
+sudo mount -t tmpfs busy ${STATE}/rootfs/tmp
+sudo bash -c "cd ${STATE}/rootfs/tmp && exec sleep 30" &
+HOLDER=$!
+sleep 0.5
+OUT=$(sudo ./hackontainer delete ${CONTAINER} 2>&1)
+sudo kill ${HOLDER} 2>/dev/null || true
+if ! echo "${OUT}" | grep -q "still busy, detaching it"; then
+    echo "FAIL: expected the busy mount to be detached, got: ${OUT}"
+    exit 1
+fi
+check "nothing under the container root is left with a busy mount" "$(mounts_under)" "0"
+
+echo "=== A failed create unmounts everything it pinned ==="
+# A full create runs the createRuntime hooks, so this fails after pinning
+jq '.hooks.createRuntime = [{"path": "/bin/false"}]' \
+    ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+if sudo bash -c "exec 7<${BUNDLE}/rootfs &&
+    ./hackontainer create --create-mode full --rootfs-fd 7 --bundle ${BUNDLE} ${CONTAINER}" >/dev/null 2>&1; then
+    echo "FAIL: create succeeded with a failing createRuntime hook"
+    exit 1
+fi
+check "nothing under the container root is left after a failed create" "$(mounts_under)" "0"
+if [ -e ${STATE} ]; then
+    echo "FAIL: failed create left ${STATE} behind"
+    exit 1
+fi
+echo "PASS: failed create removed the container root"
+check "the scratch rootfs's own mounts survive a failed create" \
+    "$(cat ${BUNDLE}/rootfs/mnt/a/b/marker)" "deep"
+cp ${BUNDLE}/config.json.orig ${BUNDLE}/config.json
+
+echo "=== All mount cleanup tests passed ==="
@@ -0,0 +1,174 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myweb"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/alice/${CONTAINER} /run/hackontainer/bob/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false | .process.args = ["sleep", "5"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+
+cleanup() {
+    for tenant in alice bob; do
+        sudo ./hackontainer --namespace ${tenant} kill ${CONTAINER} KILL 2>/dev/null || true
+    done
+    sleep 1
+    for tenant in alice bob; do
+        sudo ./hackontainer --namespace ${tenant} delete ${CONTAINER} 2>/dev/null || true
+    done
+}
+
+echo "=== Creating the same id in two namespaces ==="
+sudo ./hackontainer --namespace alice create --bundle ${BUNDLE} ${CONTAINER}
+sudo ./hackontainer --namespace bob create --bundle ${BUNDLE} ${CONTAINER}
+if [ ! -e /run/hackontainer/alice/${CONTAINER}/state.json ] || [ ! -e /run/hackontainer/bob/${CONTAINER}/state.json ]; then
+    echo "FAIL: containers not under their namespace directories"
+    cleanup
+    exit 1
+fi
+echo "PASS: both created"
+
+echo "=== Starting alice's container only ==="
+sudo ./hackontainer --namespace alice start ${CONTAINER}
+PID=$(sudo ./hackontainer --namespace alice state ${CONTAINER} | jq .pid)
+if ! grep -q "/hackontainer/alice/${CONTAINER}" /proc/${PID}/cgroup; then
+    echo "FAIL: cgroup does not include the namespace"
+    cat /proc/${PID}/cgroup
+    cleanup
+    exit 1
+fi
+if ! sudo ./hackontainer --namespace bob state ${CONTAINER} | grep -q '"status":"created"'; then
+    echo "FAIL: starting alice's container affected bob's"
+    cleanup
+    exit 1
+fi
+echo "PASS: namespaces are independent"
+
+echo "=== Global flags after the command ==="
+if ! sudo ./hackontainer state --namespace bob ${CONTAINER} | grep -q '"status":"created"'; then
+    echo "FAIL: --namespace after the command was taken for the container id"
+    cleanup
+    exit 1
+fi
+if ! sudo ./hackontainer state --root=/run/hackontainer ${CONTAINER} --namespace alice | grep -q '"status":"running"'; then
+    echo "FAIL: --root and --namespace after the id were not applied"
+    cleanup
+    exit 1
+fi
+echo "PASS: global flags apply wherever they are"
+
+echo "=== Reaching across namespaces (expect failures) ==="
+for args in "--namespace bob state ../alice/${CONTAINER}" \
+            "--namespace ../alice state ${CONTAINER}" \
+            "state ${CONTAINER}"; do
+    if sudo ./hackontainer ${args}; then
+        echo "FAIL: '${args}' reached a container outside its namespace"
+        cleanup
+        exit 1
+    fi
+done
+echo "PASS: other namespaces are unreachable"
+
+echo "=== Events stay in their namespace ==="
+if sudo ./hackontainer --namespace bob events --all | grep -q '"type":"start"'; then
+    echo "FAIL: bob sees alice's start event"
+    cleanup
+    exit 1
+fi
+if ! sudo ./hackontainer --namespace alice events --all | grep -q '"type":"start"'; then
+    echo "FAIL: alice's start event missing"
+    cleanup
+    exit 1
+fi
+echo "PASS: events are per namespace"
+
+echo "=== Listing stays in its namespace ==="
+if sudo ./hackontainer --namespace bob list --quiet | grep -q "/"; then
+    echo "FAIL: a namespace's listing shows other namespaces"
+    cleanup
+    exit 1
+fi
+if [ "$(sudo ./hackontainer --namespace bob list --format json | jq -r '.[].status')" != "created" ]; then
+    echo "FAIL: bob's listing does not show just bob's created container"
+    cleanup
+    exit 1
+fi
+if sudo ./hackontainer list --quiet | grep -qx "${CONTAINER}"; then
+    echo "FAIL: the root's listing shows namespaced containers"
+    cleanup
+    exit 1
+fi
+echo "PASS: list is per namespace"
+
+echo "=== Listing all namespaces ==="
+ALL=$(sudo ./hackontainer list --all-namespaces --quiet)
+for tenant in alice bob; do
+    if ! echo "${ALL}" | grep -qx "${tenant}/${CONTAINER}"; then
+        echo "FAIL: ${tenant}/${CONTAINER} missing from --all-namespaces: ${ALL}"
+        cleanup
+        exit 1
+    fi
+done
+TABLE=$(sudo ./hackontainer list --all-namespaces)
+if ! echo "${TABLE}" | head -1 | grep -q "^NAMESPACE" || \
+   ! echo "${TABLE}" | grep -q "^alice *${CONTAINER} .*running" || \
+   ! echo "${TABLE}" | grep -q "^bob *${CONTAINER} .*created"; then
+    echo "FAIL: the table does not show each container with its namespace"
+    echo "${TABLE}"
+    cleanup
+    exit 1
+fi
+if [ "$(sudo ./hackontainer list --all-namespaces --format json | jq -r '[.[] | select(.id == "'${CONTAINER}'") | .namespace] | sort | join(",")')" != "alice,bob" ]; then
+    echo "FAIL: the JSON listing does not carry the namespaces"
+    cleanup
+    exit 1
+fi
+echo "PASS: --all-namespaces lists every namespace"
+
+echo "=== --all-namespaces is refused where it doesn't belong (expect failures) ==="
+if sudo ./hackontainer --namespace alice list --all-namespaces; then
+    echo "FAIL: --all-namespaces combined with --namespace"
+    cleanup
+    exit 1
+fi
+if [ "$(id -u)" != "0" ] && ./hackontainer list --all-namespaces; then
+    echo "FAIL: --all-namespaces allowed without root"
+    cleanup
+    exit 1
+fi
+echo "PASS: refused"
+
+echo "=== gc stays in its namespace ==="
+if [ "$(sudo ./hackontainer --namespace bob gc --report | jq -r '[.containers[].namespace] | unique | join(",")')" != "bob" ]; then
+    echo "FAIL: bob's gc report covers other namespaces"
+    cleanup
+    exit 1
+fi
+if [ "$(sudo ./hackontainer gc --report --all-namespaces | jq '[.containers[] | select(.id == "'${CONTAINER}'")] | length')" != "2" ]; then
+    echo "FAIL: gc --all-namespaces does not cover both namespaces"
+    cleanup
+    exit 1
+fi
+echo "PASS: gc is per namespace"
+
+echo "=== Cleaning up ==="
+cleanup
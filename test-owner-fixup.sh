@@ -0,0 +1,124 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myownerfixup"
+BUNDLE="test-bundles/busybox"
+VOLUMES=$(mktemp -d /tmp/hackontainer-volumes.XXXXXX)
+OUTSIDE=$(mktemp -d /tmp/hackontainer-outside.XXXXXX)
+trap 'sudo rm -rf ${VOLUMES} ${OUTSIDE}' EXIT
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false | .process.args = ["true"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.orig
+
+# use_volume points the config at a bind mount of $1 on /data with the
+# given options
+use_volume() {
+    jq --arg src "$1" --argjson options "$2" \
+        '.mounts += [{"destination": "/data", "type": "bind", "source": $src, "options": $options}]' \
+        ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+}
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1: got '$2', want '$3'"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+refused() {
+    local what=$1
+    shift
+    if sudo ./hackontainer create --bundle ${BUNDLE} "$@" ${CONTAINER} >/dev/null 2>&1; then
+        sudo ./hackontainer delete ${CONTAINER}
+        echo "FAIL: accepted ${what}"
+        exit 1
+    fi
+    echo "PASS: refused ${what}"
+}
+
+owner() {
+    sudo stat -c %u:%g "$1"
+}
+
+# A root-owned volume with a nested tree
+sudo mkdir -p ${VOLUMES}/data/sub
+sudo touch ${VOLUMES}/data/sub/file
+sudo ln -s /etc/hostname ${VOLUMES}/data/link
+BIND='["rbind", "owner-fixup"]'
+
+echo "=== Allow-list enforcement ==="
+use_volume ${VOLUMES}/data "${BIND}"
+refused "owner-fixup without an allow-list" --user 1000:1001
+refused "allowing /" --user 1000:1001 --owner-fixup-allow /
+refused "allowing /etc" --user 1000:1001 --owner-fixup-allow /etc
+refused "allowing a directory containing /etc" --user 1000:1001 --owner-fixup-allow /etc/..
+refused "a relative allowed directory" --user 1000:1001 --owner-fixup-allow tmp
+refused "a source outside the allow-list" --user 1000:1001 --owner-fixup-allow ${OUTSIDE}
+
+use_volume ${VOLUMES} "${BIND}"
+refused "the allowed directory itself as source" --user 1000:1001 --owner-fixup-allow ${VOLUMES}
+
+sudo ln -s /etc ${VOLUMES}/escape
+use_volume ${VOLUMES}/escape "${BIND}"
+refused "a symlink out of the allowed directory" --user 1000:1001 --owner-fixup-allow ${VOLUMES}
+check "/etc keeps its owner" "$(owner /etc)" "0:0"
+
+use_volume ${VOLUMES}/data '["owner-fixup"]'
+jq '.mounts[-1].type = "tmpfs"' ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+refused "owner-fixup on a tmpfs" --user 1000:1001 --owner-fixup-allow ${VOLUMES}
+check "nothing was chowned" "$(owner ${VOLUMES}/data):$(owner ${VOLUMES}/data/sub/file)" "0:0:0:0"
+
+echo "=== The source is chowned to the container user ==="
+use_volume ${VOLUMES}/data "${BIND}"
+sudo ./hackontainer run --bundle ${BUNDLE} --user 1000:1001 --owner-fixup-allow ${VOLUMES} \
+    --replace-args ${CONTAINER} -- sh -c 'echo written > /data/sub/new' >/dev/null 2>&1
+check "the volume" "$(owner ${VOLUMES}/data)" "1000:1001"
+check "a nested file" "$(owner ${VOLUMES}/data/sub/file)" "1000:1001"
+check "the container user could write" "$(sudo cat ${VOLUMES}/data/sub/new)" "written"
+check "a symlink is chowned, not followed" "$(sudo stat -c %u:%g ${VOLUMES}/data/link):$(owner /etc/hostname)" "1000:1001:0:0"
+check "the marker" "$(sudo cat ${VOLUMES}/data/.hackontainer-owner)" "1000:1001"
+
+echo "=== Fixup is idempotent ==="
+sudo chown 0:0 ${VOLUMES}/data/sub/file
+sudo ./hackontainer create --bundle ${BUNDLE} --user 1000:1001 --owner-fixup-allow ${VOLUMES} ${CONTAINER} >/dev/null 2>&1
+sudo ./hackontainer delete ${CONTAINER}
+check "a second container as the same user leaves the tree alone" "$(owner ${VOLUMES}/data/sub/file)" "0:0"
+
+sudo ./hackontainer create --bundle ${BUNDLE} --user 2000:2000 --owner-fixup-allow ${VOLUMES} ${CONTAINER} >/dev/null 2>&1
+sudo ./hackontainer delete ${CONTAINER}
+check "a container as another user chowns again" "$(owner ${VOLUMES}/data/sub/file)" "2000:2000"
+check "the marker follows" "$(sudo cat ${VOLUMES}/data/.hackontainer-owner)" "2000:2000"
+
+echo "=== User namespaces use the mapped host ids ==="
+jq '.linux.namespaces += [{"type": "user"}] |
+    .linux.uidMappings = [{"containerID": 0, "hostID": 100000, "size": 65536}] |
+    .linux.gidMappings = [{"containerID": 0, "hostID": 100000, "size": 65536}]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+sudo ./hackontainer create --bundle ${BUNDLE} --user 1000:1001 --owner-fixup-allow ${VOLUMES} ${CONTAINER} >/dev/null 2>&1
+sudo ./hackontainer delete ${CONTAINER}
+check "the volume belongs to the mapped ids" "$(owner ${VOLUMES}/data/sub/file)" "101000:101001"
+refused "an unmapped user" --user 70000:70000 --owner-fixup-allow ${VOLUMES}
+
+echo "=== All owner fixup tests passed ==="
@@ -0,0 +1,59 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mypaths"
+BUNDLE="test-bundles/bundle dir (тест)"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf "${BUNDLE}"
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p "${BUNDLE}/rootfs"
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C "${BUNDLE}/rootfs"
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd "${BUNDLE}"
+runc spec
+cd -
+
+echo "=== Bind mounting a bundle file whose name has spaces ==="
+echo "payload" > "${BUNDLE}/data file (тест).txt"
+jq '.process.terminal = false
+    | .process.args = ["cat", "/mnt/data file (тест).txt"]
+    | .mounts += [{"destination": "/mnt/data file (тест).txt", "type": "bind",
+                   "source": "data file (тест).txt", "options": ["bind", "ro"]}]' \
+    "${BUNDLE}/config.json" > "${BUNDLE}/config.json.tmp"
+mv "${BUNDLE}/config.json.tmp" "${BUNDLE}/config.json"
+
+echo "=== Running container ==="
+OUTPUT=$(sudo ./hackontainer run --keep --bundle "${BUNDLE}" ${CONTAINER} 2>&1)
+echo "${OUTPUT}"
+if ! echo "${OUTPUT}" | grep -q "^payload$"; then
+    echo "FAIL: container did not read the bind mounted file"
+    sudo ./hackontainer delete ${CONTAINER}
+    exit 1
+fi
+
+echo "=== Checking state ==="
+BUNDLE_ABS=$(cd "${BUNDLE}" && pwd)
+STATE_BUNDLE=$(sudo ./hackontainer state ${CONTAINER} | jq -r '.bundle')
+if [ "${STATE_BUNDLE}" != "${BUNDLE_ABS}" ]; then
+    echo "FAIL: state has bundle '${STATE_BUNDLE}', expected '${BUNDLE_ABS}'"
+    sudo ./hackontainer delete ${CONTAINER}
+    exit 1
+fi
+
+echo "=== Deleting container ==="
+sudo ./hackontainer delete ${CONTAINER}
+if [ -e /run/hackontainer/${CONTAINER} ]; then
+    echo "FAIL: container state left behind"
+    exit 1
+fi
+echo "PASS: bundle path with spaces and non-ASCII characters works end to end"
@@ -0,0 +1,137 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mypause"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+# The container appends a line to /ticks ten times a second for as long
+# as it isn't frozen
+jq '.process.terminal = false | .root.readonly = false |
+    .process.args = ["sh", "-c", "while true; do echo tick >> /ticks; sleep 0.1; done"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+rm -f ${BUNDLE}/rootfs/ticks
+trap 'sudo ./hackontainer kill ${CONTAINER} SIGKILL >/dev/null 2>&1 && sleep 1; sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true' EXIT
+
+status() {
+    sudo ./hackontainer state ${CONTAINER} | grep -v "^>>>" | jq -r .status
+}
+
+ticks() {
+    wc -l < ${BUNDLE}/rootfs/ticks
+}
+
+# freezer_state prints FROZEN or THAWED for the container's cgroup
+freezer_state() {
+    local pid=$(sudo ./hackontainer state ${CONTAINER} | grep -v "^>>>" | jq -r .pid)
+    if [ -f /sys/fs/cgroup/cgroup.controllers ]; then
+        local dir=/sys/fs/cgroup$(awk -F: '$1 == "0" {print $3}' /proc/${pid}/cgroup)
+        [ "$(cat ${dir}/cgroup.freeze)" = 1 ] && echo FROZEN || echo THAWED
+    else
+        local dir=/sys/fs/cgroup/freezer$(awk -F: '$2 == "freezer" {print $3}' /proc/${pid}/cgroup)
+        cat ${dir}/freezer.state
+    fi
+}
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1: got '$2', want '$3'"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+# refused runs a command that must fail and name why
+refused() {
+    local desc=$1 want=$2
+    shift 2
+    local out
+    if out=$(sudo "$@" 2>&1); then
+        echo "FAIL: $desc succeeded"
+        exit 1
+    fi
+    if ! echo "$out" | grep -q "$want"; then
+        echo "FAIL: $desc: expected '$want' in: $out"
+        exit 1
+    fi
+    echo "PASS: $desc is refused"
+}
+
+echo "=== Pausing a created container ==="
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+refused "pausing a created container" "is created" ./hackontainer pause ${CONTAINER}
+refused "resuming a created container" "not paused" ./hackontainer resume ${CONTAINER}
+
+sudo ./hackontainer start ${CONTAINER} >/dev/null 2>&1
+sleep 0.5
+
+echo "=== Pause freezes the container ==="
+sudo ./hackontainer pause ${CONTAINER} 2>&1 | grep -v "^>>>" || true
+check "state is paused" "$(status)" "paused"
+check "cgroup is frozen" "$(freezer_state)" "FROZEN"
+BEFORE=$(ticks)
+sleep 1
+check "no ticks while paused" "$(ticks)" "${BEFORE}"
+
+echo "=== A paused container refuses what needs it running ==="
+refused "pausing twice" "already paused" ./hackontainer pause ${CONTAINER}
+refused "starting a paused container" "paused" ./hackontainer start ${CONTAINER}
+refused "deleting a paused container" "paused" ./hackontainer delete ${CONTAINER}
+refused "exec into a paused container" "paused" ./hackontainer exec ${CONTAINER} true
+check "state is still paused" "$(status)" "paused"
+
+echo "=== Resume thaws the container ==="
+sudo ./hackontainer resume ${CONTAINER} 2>&1 | grep -v "^>>>" || true
+check "state is running" "$(status)" "running"
+check "cgroup is thawed" "$(freezer_state)" "THAWED"
+BEFORE=$(ticks)
+sleep 1
+if [ "$(ticks)" -le "${BEFORE}" ]; then
+    echo "FAIL: no ticks after resume"
+    exit 1
+fi
+echo "PASS: the container runs again after resume"
+refused "resuming a running container" "not paused" ./hackontainer resume ${CONTAINER}
+
+EVENTS=$(sudo ./hackontainer events --all --filter id=${CONTAINER} | grep -v "^>>>" | jq -r .type | tr '\n' ' ')
+if ! echo "${EVENTS}" | grep -q "pause resume"; then
+    echo "FAIL: expected pause and resume events, got: ${EVENTS}"
+    exit 1
+fi
+echo "PASS: pause and resume are recorded as events"
+
+echo "=== SIGKILL stops a paused container ==="
+sudo ./hackontainer pause ${CONTAINER} 2>&1 | grep -v "^>>>" || true
+sudo ./hackontainer kill ${CONTAINER} SIGKILL 2>&1 | grep -v "^>>>" || true
+for i in $(seq 1 50); do
+    [ "$(status)" = "stopped" ] && break
+    sleep 0.1
+done
+check "killed paused container is stopped" "$(status)" "stopped"
+sudo ./hackontainer delete ${CONTAINER} 2>&1 | grep -v "^>>>" || true
+if [ -d /run/hackontainer/${CONTAINER} ]; then
+    echo "FAIL: delete after kill left the container behind"
+    exit 1
+fi
+echo "PASS: the killed container deletes"
+
+echo "=== All pause tests passed ==="
@@ -0,0 +1,152 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mypivot"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+cleanup() {
+    sudo ./hackontainer delete --force ${CONTAINER} >/dev/null 2>&1 || true
+}
+trap cleanup EXIT
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+cp ${BUNDLE}/config.json ${BUNDLE}/config.json.orig
+
+# with_hook <script> runs script as a createContainer hook, in the
+# container's mount namespace just before pivot_root
+with_hook() {
+    jq --arg script "$1" '.process.terminal = false
+        | .process.args = ["echo", "pivoted"]
+        | .hooks.createContainer = [{"path": "/bin/sh", "args": ["sh", "-c", $script]}]' \
+        ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+}
+
+# run_container prints everything the run wrote
+run_container() {
+    sudo ./hackontainer run --bundle ${BUNDLE} ${CONTAINER} 2>&1 || true
+    cleanup
+}
+
+echo "=== A rootfs reached through a symlink ==="
+ln -sfn rootfs ${BUNDLE}/rootfs-link
+jq '.process.terminal = false | .process.args = ["echo", "pivoted"] | .root.path = "rootfs-link"' \
+    ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+OUTPUT=$(run_container)
+if ! echo "${OUTPUT}" | grep -q "^pivoted$" || echo "${OUTPUT}" | grep -q "pivot_root precondition"; then
+    echo "${OUTPUT}"
+    echo "FAIL: symlinked rootfs did not pivot cleanly"
+    exit 1
+fi
+echo "PASS: pivoted"
+
+echo "=== A rootfs made shared before the pivot ==="
+with_hook 'mount --make-shared "$HACKONTAINER_ROOTFS"'
+OUTPUT=$(run_container)
+if ! echo "${OUTPUT}" | grep -q "Fixing pivot_root precondition: rootfs .* has shared propagation"; then
+    echo "${OUTPUT}"
+    echo "FAIL: shared rootfs was not noticed"
+    exit 1
+fi
+if ! echo "${OUTPUT}" | grep -q "^pivoted$"; then
+    echo "${OUTPUT}"
+    echo "FAIL: container did not run after the fix"
+    exit 1
+fi
+echo "PASS: made private and pivoted"
+
+echo "=== A rootfs that is no longer a mount point ==="
+with_hook 'umount -l "$HACKONTAINER_ROOTFS"'
+OUTPUT=$(run_container)
+if ! echo "${OUTPUT}" | grep -q "Fixing pivot_root precondition: rootfs .* is not a mount point"; then
+    echo "${OUTPUT}"
+    echo "FAIL: unmounted rootfs was not noticed"
+    exit 1
+fi
+if ! echo "${OUTPUT}" | grep -q "^pivoted$"; then
+    echo "${OUTPUT}"
+    echo "FAIL: container did not run after the fix"
+    exit 1
+fi
+if echo "${OUTPUT}" | grep -q "invalid argument"; then
+    echo "${OUTPUT}"
+    echo "FAIL: pivot_root still failed with EINVAL"
+    exit 1
+fi
+echo "PASS: bound again and pivoted"
+
+echo "=== The old root is out of reach, with pivot_root or without ==="
+# The bundle holds the rootfs; the container tries to climb out to it
+touch ${BUNDLE}/outside-marker
+ESCAPE='cd /; cd ../../..; for p in /../outside-marker ../outside-marker outside-marker; do [ -e "$p" ] && echo "reached $p"; done; echo checked'
+jq --arg script "${ESCAPE}" '.process.terminal = false | .process.args = ["sh", "-c", $script]' \
+    ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+for flag in "" "--no-pivot"; do
+    OUTPUT=$(sudo ./hackontainer run ${flag} --bundle ${BUNDLE} ${CONTAINER} 2>&1 || true)
+    cleanup
+    if ! echo "${OUTPUT}" | grep -q "^checked$" || echo "${OUTPUT}" | grep -q "^reached"; then
+        echo "${OUTPUT}"
+        echo "FAIL: ${flag:-pivot_root}: the old root is reachable"
+        exit 1
+    fi
+    echo "PASS: ${flag:-pivot_root}: the old root is out of reach"
+done
+rm -f ${BUNDLE}/outside-marker
+if ! echo "${OUTPUT}" | grep -q "Moving the rootfs onto / instead of pivoting"; then
+    echo "${OUTPUT}"
+    echo "FAIL: --no-pivot still pivoted"
+    exit 1
+fi
+echo "PASS: --no-pivot moved the rootfs"
+
+echo "=== --no-pivot is kept with the container ==="
+jq '.process.terminal = false | .process.args = ["echo", "moved"]' ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+sudo ./hackontainer create --no-pivot --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+if [ "$(sudo ./hackontainer inspect ${CONTAINER} | jq -r .createOptions.noPivotRoot)" != "true" ]; then
+    echo "FAIL: --no-pivot is not recorded"
+    exit 1
+fi
+OUTPUT=$(sudo ./hackontainer start ${CONTAINER} 2>&1 || true)
+cleanup
+if ! echo "${OUTPUT}" | grep -q "Moving the rootfs onto /"; then
+    echo "${OUTPUT}"
+    echo "FAIL: start pivoted a container created with --no-pivot"
+    exit 1
+fi
+echo "PASS: start doesn't pivot either"
+
+echo "=== --no-pivot doesn't need pivot_root from the kernel ==="
+OUTPUT=$(sudo HACKONTAINER_TEST_MISSING_FEATURES="pivot_root" \
+    ./hackontainer run --no-pivot --bundle ${BUNDLE} ${CONTAINER} 2>&1 || true)
+cleanup
+if ! echo "${OUTPUT}" | grep -q "^moved$"; then
+    echo "${OUTPUT}"
+    echo "FAIL: --no-pivot was refused for a host without pivot_root"
+    exit 1
+fi
+echo "PASS: ran without pivot_root"
+
+if sudo sh -c "./hackontainer create --no-pivot --rootfs-fd 3 --bundle ${BUNDLE} ${CONTAINER} 3<${BUNDLE}/rootfs" >/dev/null 2>&1; then
+    echo "FAIL: --no-pivot was accepted with a pinned rootfs"
+    exit 1
+fi
+cleanup
+echo "PASS: --no-pivot with a pinned rootfs is refused"
+
+echo "=== All pivot_root tests passed ==="
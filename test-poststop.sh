@@ -0,0 +1,63 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mypoststop"
+BUNDLE="test-bundles/busybox"
+MARKER="/tmp/hackontainer-poststop-${CONTAINER}"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+sudo rm -f ${MARKER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+echo "=== Adding a poststop hook that records the state it was given ==="
+jq --arg marker "${MARKER}" '.process.terminal = false
+    | .process.args = ["sleep", "1000"]
+    | .hooks.poststop = [{"path": "/bin/sh", "args": ["sh", "-c", "cat > \($marker)"]}]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+
+echo "=== Creating and starting container ==="
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER}
+sudo ./hackontainer start ${CONTAINER}
+PID=$(sudo ./hackontainer state ${CONTAINER} | jq -r '.pid')
+
+echo "=== Killing PID ${PID} from outside the runtime ==="
+# Every hackontainer invocation has exited; only the monitor is left to
+# notice the exit
+sleep 2
+sudo kill -KILL ${PID}
+sleep 1
+
+if ! sudo grep -q '"status":"stopped"' ${MARKER}; then
+    echo "FAIL: poststop hook did not run with the stopped state"
+    sudo ./hackontainer delete ${CONTAINER}
+    exit 1
+fi
+echo "PASS: poststop hook ran"
+
+if ! sudo grep -q "\"type\":\"stop\",\"id\":\"${CONTAINER}\"" /run/hackontainer/events.log; then
+    echo "FAIL: no stop event recorded"
+    sudo ./hackontainer delete ${CONTAINER}
+    exit 1
+fi
+echo "PASS: stop event recorded"
+
+echo "=== Cleaning up ==="
+sudo ./hackontainer delete ${CONTAINER}
+sudo rm -f ${MARKER}
@@ -0,0 +1,133 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mypreservefds"
+BUNDLE="test-bundles/busybox"
+FD_DIR="/tmp/hackontainer-preserve-fds"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+cleanup() {
+    sudo ./hackontainer kill ${CONTAINER} SIGKILL >/dev/null 2>&1 || true
+    sleep 1
+    sudo ./hackontainer delete --force ${CONTAINER} >/dev/null 2>&1 || true
+    sudo rm -rf ${FD_DIR} || true
+}
+trap cleanup EXIT
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false' ${BUNDLE}/config.json > ${BUNDLE}/config.json.orig
+
+mkdir -p ${FD_DIR}
+echo "first" > ${FD_DIR}/a
+echo "second" > ${FD_DIR}/b
+echo "stray" > ${FD_DIR}/c
+
+# use_args <args> sets the container process
+use_args() {
+    jq --argjson args "$1" '.process.args = $args' ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+}
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1: expected '$3', got '$2'"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+# The fds are opened by the shell sudo runs, since sudo itself closes
+# every fd above stderr. The container lists the fds of its shell, not
+# of ls, which has one of its own open.
+echo "=== run hands the fds on at the same numbers ==="
+use_args '["sh", "-c", "cat <&3; cat <&4"]'
+OUT=$(sudo sh -c "./hackontainer run --preserve-fds 2 --bundle ${BUNDLE} ${CONTAINER} 3<${FD_DIR}/a 4<${FD_DIR}/b" 2>/dev/null | grep -v "^>>>")
+check "run: fds 3 and 4 are readable" "$(echo ${OUT})" "first second"
+
+# The stray fd is 9, above the runtime's own fds, which would otherwise
+# take its number
+echo "=== fds past the ones preserved are closed ==="
+use_args '["sh", "-c", "cat <&3; ls /proc/$$/fd"]'
+OUT=$(sudo sh -c "./hackontainer run --preserve-fds 1 --bundle ${BUNDLE} ${CONTAINER} 3<${FD_DIR}/a 9<${FD_DIR}/c" 2>/dev/null | grep -v "^>>>")
+check "run: fd 3 is readable" "$(echo "${OUT}" | head -1)" "first"
+if echo "${OUT}" | tail -n +2 | grep -qx 9; then
+    echo "FAIL: fd 9 reached the container without being preserved"
+    exit 1
+fi
+echo "PASS: fd 9 was closed"
+
+use_args '["sh", "-c", "ls /proc/$$/fd"]'
+OUT=$(sudo sh -c "./hackontainer run --bundle ${BUNDLE} ${CONTAINER} 9<${FD_DIR}/c" 2>/dev/null | grep -v "^>>>")
+if echo "${OUT}" | grep -qx 9; then
+    echo "FAIL: fd 9 reached the container without --preserve-fds"
+    exit 1
+fi
+echo "PASS: without --preserve-fds no fd is handed on"
+
+echo "=== A closed fd is an error ==="
+use_args '["true"]'
+ERR=$(sudo sh -c "./hackontainer run --preserve-fds 2 --bundle ${BUNDLE} ${CONTAINER} 3<${FD_DIR}/a" 2>&1 || true)
+if ! echo "${ERR}" | grep -q "cannot preserve fd 4: it is not open"; then
+    echo "${ERR}"
+    echo "FAIL: a closed fd wasn't reported"
+    exit 1
+fi
+echo "PASS: the closed fd is named"
+if sudo ./hackontainer state ${CONTAINER} >/dev/null 2>&1; then
+    echo "FAIL: a container was created anyway"
+    exit 1
+fi
+echo "PASS: nothing was created"
+
+ERR=$(sudo ./hackontainer run --preserve-fds many --bundle ${BUNDLE} ${CONTAINER} 2>&1 || true)
+check "an invalid count is rejected" "$(echo "${ERR}" | grep -o 'invalid --preserve-fds "many"')" 'invalid --preserve-fds "many"'
+
+echo "=== create hands the fds to the process start runs ==="
+use_args '["sh", "-c", "cat <&3; sleep 30"]'
+sudo sh -c "./hackontainer create --preserve-fds 1 --bundle ${BUNDLE} ${CONTAINER} 3<${FD_DIR}/a > ${FD_DIR}/out 2>&1"
+check "create: full mode is implied" "$(sudo ./hackontainer state ${CONTAINER} | jq -r .createMode)" "full"
+sudo ./hackontainer start ${CONTAINER} >/dev/null 2>&1
+for _ in $(seq 50); do
+    grep -qx first ${FD_DIR}/out && break
+    sleep 0.1
+done
+check "create: fd 3 is readable after start" "$(grep -x first ${FD_DIR}/out)" "first"
+
+echo "=== exec hands the fds on too ==="
+OUT=$(sudo sh -c "./hackontainer exec --preserve-fds 1 ${CONTAINER} sh -c 'cat <&3' 3<${FD_DIR}/b" 2>/dev/null)
+check "exec: fd 3 is readable" "${OUT}" "second"
+OUT=$(sudo sh -c "./hackontainer exec ${CONTAINER} sh -c 'ls /proc/\$\$/fd' 9<${FD_DIR}/c" 2>/dev/null)
+if echo "${OUT}" | grep -qx 9; then
+    echo "FAIL: exec handed on fd 9 without --preserve-fds"
+    exit 1
+fi
+echo "PASS: exec without --preserve-fds hands no fd on"
+
+sudo ./hackontainer kill ${CONTAINER} KILL
+sleep 1
+sudo ./hackontainer delete ${CONTAINER}
+
+echo "=== A state-only create can't preserve fds ==="
+if sudo sh -c "./hackontainer create --preserve-fds 1 --create-mode state-only --bundle ${BUNDLE} ${CONTAINER} 3<${FD_DIR}/a" >/dev/null 2>&1; then
+    echo "FAIL: a state-only create accepted --preserve-fds"
+    exit 1
+fi
+echo "PASS: state-only create refused"
+
+echo "=== All preserve-fds tests passed ==="
@@ -0,0 +1,93 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myprivileges"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false | .process.args = ["true"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+
+# A root unprivileged users can write to
+NOBODY_ROOT=$(mktemp -d)
+chmod 777 ${NOBODY_ROOT}
+trap 'sudo rm -rf ${NOBODY_ROOT}' EXIT
+
+# expect_missing <expected> <unexpected> <command...> runs the runtime
+# with --rootless=false under command, which drops privileges, and checks
+# which privileges the error names
+expect_missing() {
+    local expected=$1 unexpected=$2
+    shift 2
+    out=$("$@" ./hackontainer --root ${NOBODY_ROOT}/root --rootless=false state ${CONTAINER} 2>&1 || true)
+    IFS=',' read -ra names <<< "${expected}"
+    for name in "${names[@]}"; do
+        if ! echo "${out}" | grep -q "${name}: "; then
+            echo "FAIL: expected ${name} to be reported missing, got: ${out}"
+            exit 1
+        fi
+    done
+    if [ -n "${unexpected}" ] && echo "${out}" | grep -q "${unexpected}: "; then
+        echo "FAIL: ${unexpected} was reported missing: ${out}"
+        exit 1
+    fi
+    if ! echo "${out}" | grep -q "use --rootless=true to run without them$"; then
+        echo "FAIL: expected a hint to use --rootless=true, got: ${out}"
+        exit 1
+    fi
+    echo "PASS: reported missing: ${expected}"
+}
+
+echo "=== Dropping CAP_SYS_ADMIN ==="
+expect_missing "CAP_SYS_ADMIN,mount propagation control" "device node creation" \
+    sudo setpriv --bounding-set -sys_admin
+
+echo "=== Dropping CAP_MKNOD ==="
+expect_missing "device node creation" "CAP_SYS_ADMIN" \
+    sudo setpriv --bounding-set -mknod
+
+echo "=== An unprivileged user lacks every privilege, listed in one error ==="
+expect_missing "CAP_SYS_ADMIN,writable cgroup hierarchy,device node creation,mount propagation control" "" \
+    sudo setpriv --reuid=65534 --regid=65534 --clear-groups
+
+echo "=== auto and true don't require the privileges ==="
+for mode in auto true; do
+    out=$(sudo setpriv --reuid=65534 --regid=65534 --clear-groups \
+        ./hackontainer --root ${NOBODY_ROOT}/root --rootless=${mode} state ${CONTAINER} 2>&1 || true)
+    if ! echo "${out}" | grep -q "does not exist"; then
+        echo "FAIL: --rootless=${mode}: expected the factory to load, got: ${out}"
+        exit 1
+    fi
+done
+echo "PASS: auto and true run without the privileges"
+
+echo "=== Root passes the check and runs containers ==="
+sudo ./hackontainer --rootless=false run --bundle ${BUNDLE} ${CONTAINER} >/dev/null
+echo "PASS: --rootless=false runs a container as root"
+
+if sudo ./hackontainer --rootless=maybe state ${CONTAINER} >/dev/null 2>&1; then
+    echo "FAIL: an invalid --rootless was accepted"
+    exit 1
+fi
+echo "PASS: an invalid --rootless is rejected"
+
+echo "=== All privilege tests passed ==="
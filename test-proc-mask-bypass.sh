@@ -0,0 +1,90 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myprocmask"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+# A user namespace container that tries to read /proc/keys, which the
+# spec masks, through a proc of its own
+jq '.process.terminal = false
+    | .process.args = ["sh", "-c", "wc -c < /proc/keys; if mount -t proc proc /tmp 2>/dev/null; then echo mounted; else echo refused; fi"]
+    | .process.capabilities = {"bounding": ["CAP_KILL"], "effective": ["CAP_KILL"], "permitted": ["CAP_KILL"]}
+    | .linux.maskedPaths = ["/proc/keys"]
+    | .linux.namespaces += [{"type": "user"}]
+    | .linux.uidMappings = [{"containerID": 0, "hostID": 100000, "size": 65536}]
+    | .linux.gidMappings = [{"containerID": 0, "hostID": 100000, "size": 65536}]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+cp ${BUNDLE}/config.json ${BUNDLE}/config.json.orig
+
+# run_container runs the container, leaving what it printed in OUT and
+# the runtime's warnings in WARNINGS
+run_container() {
+    sudo ./hackontainer run --bundle ${BUNDLE} ${CONTAINER} >/tmp/${CONTAINER}.out 2>/tmp/${CONTAINER}.err || true
+    sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true
+    OUT=$(grep -v "^>>>" /tmp/${CONTAINER}.out || true)
+    WARNINGS=$(grep "^WARNING" /tmp/${CONTAINER}.err || true)
+    rm -f /tmp/${CONTAINER}.out /tmp/${CONTAINER}.err
+}
+
+echo "=== Without CAP_SYS_ADMIN the masks hold ==="
+run_container
+if [ "$(echo "${OUT}" | head -1)" != "0" ]; then
+    echo "FAIL: expected /proc/keys to be masked, got: ${OUT}"
+    exit 1
+fi
+if [ "$(echo "${OUT}" | tail -1)" != "refused" ]; then
+    echo "FAIL: expected a fresh proc mount to be refused, got: ${OUT}"
+    exit 1
+fi
+if echo "${WARNINGS}" | grep -q "maskedPaths"; then
+    echo "FAIL: unexpected mask warning: ${WARNINGS}"
+    exit 1
+fi
+echo "PASS: masked files are unreadable and no fresh proc can be mounted"
+
+echo "=== With CAP_SYS_ADMIN the runtime warns ==="
+jq '.process.capabilities |= with_entries(.value += ["CAP_SYS_ADMIN"])' \
+    ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+run_container
+if ! echo "${WARNINGS}" | grep -q "linux.maskedPaths do not confine a process with CAP_SYS_ADMIN in its user namespace"; then
+    echo "FAIL: expected a warning about the masks, got: ${WARNINGS}"
+    exit 1
+fi
+if [ "$(echo "${OUT}" | head -1)" != "0" ]; then
+    echo "FAIL: expected /proc/keys to be masked, got: ${OUT}"
+    exit 1
+fi
+echo "PASS: the residual risk is reported"
+
+echo "=== Without masks there is nothing to warn about ==="
+jq '.linux.maskedPaths = []' ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+run_container
+if echo "${WARNINGS}" | grep -q "maskedPaths"; then
+    echo "FAIL: unexpected mask warning: ${WARNINGS}"
+    exit 1
+fi
+mv ${BUNDLE}/config.json.orig ${BUNDLE}/config.json
+echo "PASS: no warning"
+
+echo "=== All proc mask bypass tests passed ==="
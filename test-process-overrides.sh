@@ -0,0 +1,84 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myoverrides"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false | .process.args = ["env"] |
+    .process.env = ["PATH=/bin:/usr/bin", "TERM=xterm", "KEEP=spec"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+
+# run_with runs the container with the given options and prints what
+# it printed, without the runtime's progress lines
+run_with() {
+    local output
+    output=$(sudo ./hackontainer run --bundle ${BUNDLE} "$@" 2>/dev/null | grep -v "^>>>")
+    sudo ./hackontainer delete ${CONTAINER}
+    echo "${output}"
+}
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1"
+        diff <(echo "$3") <(echo "$2") || true
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+echo "=== Repeated -e and --env, an empty value and a key the spec sets ==="
+OUTPUT=$(run_with -e A=1 --env B=2 -e TERM=dumb -e EMPTY= --env=C=3 ${CONTAINER})
+EXPECTED=$(printf '%s\n' "PATH=/bin:/usr/bin" "TERM=dumb" "KEEP=spec" "A=1" "B=2" "EMPTY=" "C=3")
+check "env merged in order, spec keys replaced in place" "${OUTPUT}" "${EXPECTED}"
+
+echo "=== A value containing = and spaces ==="
+OUTPUT=$(run_with -e "OPTS=a=b c" ${CONTAINER} | grep "^OPTS=")
+check "value kept whole" "${OUTPUT}" "OPTS=a=b c"
+
+echo "=== --workdir ==="
+OUTPUT=$(run_with --workdir /tmp --replace-args ${CONTAINER} -- sh -c pwd)
+check "process starts in --workdir" "${OUTPUT}" "/tmp"
+
+echo "=== --user ==="
+OUTPUT=$(run_with --user 1000:1001 --replace-args ${CONTAINER} -- cat /proc/self/status | grep "^[UG]id:" | awk '{print $2}' | tr '\n' ' ')
+check "process runs as uid 1000, gid 1001" "${OUTPUT}" "1000 1001 "
+OUTPUT=$(run_with --user 1000 --replace-args ${CONTAINER} -- cat /proc/self/status | grep "^[UG]id:" | awk '{print $2}' | tr '\n' ' ')
+check "a uid alone keeps the spec's gid" "${OUTPUT}" "1000 0 "
+
+echo "=== inspect shows the effective values ==="
+sudo ./hackontainer create --bundle ${BUNDLE} -e TERM=dumb -e NEW=1 --workdir /tmp --user 1000:1001 ${CONTAINER} >/dev/null 2>&1
+PROCESS=$(sudo ./hackontainer inspect ${CONTAINER} | jq -c '.process | {env, cwd, uid, gid}')
+sudo ./hackontainer delete ${CONTAINER}
+check "inspect" "${PROCESS}" '{"env":["PATH=/bin:/usr/bin","TERM=dumb","KEEP=spec","NEW=1"],"cwd":"/tmp","uid":1000,"gid":1001}'
+
+echo "=== Invalid values are refused ==="
+for opts in "-e NOEQUALS" "-e =value" "--workdir relative/path" "--user alice" "--user 1000:staff"; do
+    if sudo ./hackontainer create --bundle ${BUNDLE} ${opts} ${CONTAINER} >/dev/null 2>&1; then
+        sudo ./hackontainer delete ${CONTAINER}
+        echo "FAIL: accepted ${opts}"
+        exit 1
+    fi
+    echo "PASS: refused ${opts}"
+done
+
+echo "=== All process override tests passed ==="
@@ -0,0 +1,113 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myps"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+# The shell stays as init with two children
+jq '.process.terminal = false | .process.args = ["sh", "-c", "sleep 101 & sleep 102; true"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+trap 'sudo ./hackontainer kill --all ${CONTAINER} SIGKILL >/dev/null 2>&1 && sleep 1; sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true' EXIT
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1: got '$2', want '$3'"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+# ps_out runs ps with the given arguments, without the runtime's chatter
+ps_out() {
+    sudo ./hackontainer ps "$@" | grep -v "^>>>"
+}
+
+echo "=== A container that isn't running has no processes to list ==="
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+if OUT=$(sudo ./hackontainer ps ${CONTAINER} 2>&1); then
+    echo "FAIL: ps of a created container succeeded"
+    exit 1
+fi
+if ! echo "${OUT}" | grep -q "container not running"; then
+    echo "FAIL: expected 'container not running', got: ${OUT}"
+    exit 1
+fi
+echo "PASS: ps of a created container is refused"
+
+sudo ./hackontainer start ${CONTAINER} >/dev/null 2>&1
+sleep 0.5
+INIT_PID=$(sudo ./hackontainer state ${CONTAINER} | grep -v "^>>>" | jq -r .pid)
+
+echo "=== JSON lists the pids of the container's cgroup ==="
+JSON=$(ps_out --format json ${CONTAINER})
+check "three processes" "$(echo "${JSON}" | jq length)" "3"
+check "init is among them" "$(echo "${JSON}" | jq --argjson pid ${INIT_PID} 'index($pid) != null')" "true"
+for pid in $(echo "${JSON}" | jq -r '.[]'); do
+    if [ "${pid}" != "${INIT_PID}" ] && [ "$(awk '{print $4}' /proc/${pid}/stat)" != "${INIT_PID}" ]; then
+        echo "FAIL: ${pid} is not init or one of its children"
+        exit 1
+    fi
+done
+echo "PASS: the other pids are init's children"
+
+echo "=== The table is ps output for those pids ==="
+TABLE=$(ps_out ${CONTAINER})
+echo "${TABLE}"
+check "the header comes first" "$(echo "${TABLE}" | head -1 | awk '{print $2}')" "PID"
+check "a line per process" "$(echo "${TABLE}" | tail -n +2 | wc -l)" "3"
+check "the children are shown" "$(echo "${TABLE}" | grep -c " sleep 10[12]$")" "2"
+
+TABLE=$(ps_out ${CONTAINER} -- -o pid,comm)
+check "ps options after --" "$(echo "${TABLE}" | head -1 | awk '{print $1, $2}')" "PID COMMAND"
+check "init's line with custom options" "$(echo "${TABLE}" | awk -v pid=${INIT_PID} '$1 == pid {print $2}')" "sh"
+if sudo ./hackontainer ps ${CONTAINER} -- -o comm >/dev/null 2>&1; then
+    echo "FAIL: ps options without a PID column were accepted"
+    exit 1
+fi
+echo "PASS: ps options without a PID column are refused"
+
+echo "=== A stopped container has no processes to list ==="
+sudo ./hackontainer kill --all ${CONTAINER} SIGKILL >/dev/null 2>&1
+for i in $(seq 1 50); do
+    [ "$(sudo ./hackontainer state ${CONTAINER} | grep -v "^>>>" | jq -r .status)" = "stopped" ] && break
+    sleep 0.1
+done
+if OUT=$(sudo ./hackontainer ps --format json ${CONTAINER} 2>&1); then
+    echo "FAIL: ps of a stopped container succeeded"
+    exit 1
+fi
+if ! echo "${OUT}" | grep -q "container not running"; then
+    echo "FAIL: expected 'container not running', got: ${OUT}"
+    exit 1
+fi
+echo "PASS: ps of a stopped container is refused"
+sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1
+
+echo "=== Without a cgroup only init is listed ==="
+sudo ./hackontainer --cgroups none create --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+sudo ./hackontainer start ${CONTAINER} >/dev/null 2>&1
+sleep 0.5
+INIT_PID=$(sudo ./hackontainer state ${CONTAINER} | grep -v "^>>>" | jq -r .pid)
+check "only the init pid" "$(ps_out --format json ${CONTAINER} | jq -c .)" "[${INIT_PID}]"
+
+echo "=== All ps tests passed ==="
@@ -0,0 +1,133 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myreadonlytmpfs"
+BUNDLE="test-bundles/busybox"
+STATE_DIR="/run/hackontainer/${CONTAINER}"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf ${STATE_DIR}
+
+cleanup() {
+    sudo ./hackontainer kill ${CONTAINER} SIGKILL >/dev/null 2>&1 && sleep 1 || true
+    sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true
+}
+trap cleanup EXIT
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+# The process lists the scratch mounts, "path type options", and
+# whether each path is writable
+SCRIPT='for d in /tmp /run /var/tmp; do
+    grep " $d " /proc/mounts | cut -d" " -f2,3,4
+    touch $d/probe 2>/dev/null && echo "$d writable" || echo "$d read-only"
+done'
+jq --arg script "${SCRIPT}" \
+    '.root.readonly = true | .process.terminal = false | .process.args = ["sh", "-c", $script]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.readonly
+cp ${BUNDLE}/config.json.readonly ${BUNDLE}/config.json
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1: expected '$3', got '$2'"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+# has <what> <output> <pattern> fails unless output has a line matching
+# pattern
+has() {
+    if ! echo "$2" | grep -qE "$3"; then
+        echo "FAIL: $1: no line matching '$3' in:"
+        echo "$2"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+echo "=== A read-only root without scratch space is warned about ==="
+OUT=$(sudo ./hackontainer run --bundle ${BUNDLE} ${CONTAINER} 2>&1)
+has "warning" "${OUT}" "WARNING: root.readonly is set and nothing outside /dev, /proc and /sys is writable"
+has "/tmp read-only" "${OUT}" "^/tmp read-only$"
+
+echo "=== --read-only-auto-tmpfs mounts the scratch areas ==="
+OUT=$(sudo ./hackontainer run --keep --read-only-auto-tmpfs --bundle ${BUNDLE} ${CONTAINER} 2>&1)
+if echo "${OUT}" | grep -q WARNING; then
+    echo "FAIL: warned with scratch space: ${OUT}"
+    exit 1
+fi
+for d in /tmp /run /var/tmp; do
+    has "${d} is tmpfs" "${OUT}" "^${d} tmpfs "
+    has "${d} writable" "${OUT}" "^${d} writable$"
+done
+has "/tmp noexec" "${OUT}" "^/tmp tmpfs .*noexec.*size=65536k"
+has "/run capped" "${OUT}" "^/run tmpfs .*size=16384k"
+check "frozen config records them" \
+    "$(sudo jq -r '[.mounts[] | select(.type == "tmpfs") | .destination] | map(select(. == "/tmp" or . == "/run" or . == "/var/tmp")) | join(" ")' ${STATE_DIR}/config.json)" \
+    "/tmp /run /var/tmp"
+sudo ./hackontainer delete ${CONTAINER}
+
+echo "=== The annotation does the same, and exec lifts /tmp's noexec ==="
+jq '.annotations["org.hackontainer.read-only-auto-tmpfs"] = "exec"' ${BUNDLE}/config.json.readonly > ${BUNDLE}/config.json
+OUT=$(sudo ./hackontainer run --bundle ${BUNDLE} ${CONTAINER} 2>&1)
+has "/tmp mounted" "${OUT}" "^/tmp tmpfs "
+if echo "${OUT}" | grep -E "^/tmp tmpfs " | grep -q noexec; then
+    echo "FAIL: /tmp noexec with exec: ${OUT}"
+    exit 1
+fi
+echo "PASS: /tmp allows exec"
+has "/var/tmp still noexec" "${OUT}" "^/var/tmp tmpfs .*noexec"
+
+echo "=== An invalid annotation is refused ==="
+jq '.annotations["org.hackontainer.read-only-auto-tmpfs"] = "yes"' ${BUNDLE}/config.json.readonly > ${BUNDLE}/config.json
+if OUT=$(sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} 2>&1); then
+    echo "FAIL: invalid annotation accepted"
+    exit 1
+fi
+echo "PASS: ${OUT}"
+
+echo "=== Mounts the config has are kept ==="
+jq '.mounts += [{"destination": "/tmp", "type": "tmpfs", "source": "tmpfs", "options": ["size=1m"]}, {"destination": "/run/secrets", "type": "tmpfs", "source": "tmpfs", "options": ["ro"]}]' \
+    ${BUNDLE}/config.json.readonly > ${BUNDLE}/config.json
+OUT=$(sudo ./hackontainer run --read-only-auto-tmpfs --bundle ${BUNDLE} ${CONTAINER} 2>&1)
+has "explicit /tmp kept" "${OUT}" "^/tmp tmpfs .*size=1024k"
+check "/tmp mounted once" "$(echo "${OUT}" | grep -c "^/tmp tmpfs ")" "1"
+has "/run added" "${OUT}" "^/run tmpfs .*size=16384k"
+jq '.process.args = ["sh", "-c", "grep \" /run/secrets \" /proc/mounts | cut -d\" \" -f2"]' ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+OUT=$(sudo ./hackontainer run --read-only-auto-tmpfs --bundle ${BUNDLE} ${CONTAINER} 2>&1)
+has "mount below /run not hidden" "${OUT}" "^/run/secrets$"
+
+echo "=== The size cap is enforced ==="
+jq '.process.args = ["sh", "-c", "dd if=/dev/zero of=/tmp/big bs=1M count=100 2>/dev/null && echo filled; du -m /tmp/big | cut -f1"]' \
+    ${BUNDLE}/config.json.readonly > ${BUNDLE}/config.json
+OUT=$(sudo ./hackontainer run --read-only-auto-tmpfs --bundle ${BUNDLE} ${CONTAINER} 2>&1)
+if echo "${OUT}" | grep -qx filled; then
+    echo "FAIL: wrote 100M to /tmp"
+    exit 1
+fi
+check "writes stop at the cap" "$(echo "${OUT}" | tail -1)" "64"
+
+echo "=== A writable root is left alone ==="
+jq '.root.readonly = false' ${BUNDLE}/config.json.readonly > ${BUNDLE}/config.json
+OUT=$(sudo ./hackontainer run --read-only-auto-tmpfs --bundle ${BUNDLE} ${CONTAINER} 2>&1)
+if echo "${OUT}" | grep -q "^/tmp tmpfs "; then
+    echo "FAIL: tmpfs added to a writable root: ${OUT}"
+    exit 1
+fi
+has "/tmp writable" "${OUT}" "^/tmp writable$"
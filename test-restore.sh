@@ -0,0 +1,296 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myrestore"
+BUNDLE="test-bundles/busybox"
+FAKE=$(mktemp -d)
+SOCK=${FAKE}/console.sock
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false | .process.args = ["sleep", "100"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+RECEIVER=""
+cleanup() {
+    [ -n "${RECEIVER}" ] && sudo kill ${RECEIVER} 2>/dev/null || true
+    sudo ./hackontainer kill ${CONTAINER} SIGKILL >/dev/null 2>&1 && sleep 1 || true
+    sudo ./hackontainer delete --force ${CONTAINER} >/dev/null 2>&1 || true
+    sudo rm -rf ${FAKE}
+}
+trap cleanup EXIT
+
+go build -o ${FAKE}/recvtty ./test/recvtty
+
+# A stand-in for criu. Its dump kills the tree, as a real one does; its
+# restore leaves a stopped tree of two processes behind, detached, and
+# writes the pid of its root to --pidfile. Given a pty to inherit, the
+# tree has it as stdio and says so on it
+cat > ${FAKE}/criu <<'CRIU'
+#!/bin/sh
+pid= images= pidfile= inherit=
+prev=
+for arg in "$@"; do
+    case "$prev" in
+    --tree) pid=$arg ;;
+    --images-dir) images=$arg ;;
+    --pidfile) pidfile=$arg ;;
+    --inherit-fd) inherit=$arg ;;
+    esac
+    prev=$arg
+done
+echo "$@" > "$images/$1.args"
+case "$1" in
+dump)
+    touch "$images/inventory.img"
+    kill -9 "$pid"
+    ;;
+restore)
+    [ -f "$images/inventory.img" ] || { echo "no images in $images"; exit 1; }
+    if [ -n "$inherit" ]; then
+        [ -t 3 ] || { echo "fd 3 is not a terminal"; exit 1; }
+        echo "restored on a terminal" >&3
+        sh -c 'sleep 100 & exec sleep 101' <&3 >&3 2>&3 3>&- &
+    else
+        sh -c 'sleep 100 & exec sleep 101' </dev/null >/dev/null 2>&1 &
+    fi
+    root=$!
+    sleep 0.2
+    kill -STOP $root $(cat /proc/$root/task/*/children)
+    echo $root > "$pidfile"
+    ;;
+esac
+CRIU
+chmod +x ${FAKE}/criu
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1: got '$2', want '$3'"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+# refused <what> <want> <command...> runs a command that must fail and
+# name why
+refused() {
+    local desc=$1 want=$2
+    shift 2
+    local out
+    if out=$(sudo "$@" 2>&1); then
+        echo "FAIL: $desc succeeded"
+        exit 1
+    fi
+    if ! echo "$out" | grep -q -- "$want"; then
+        echo "FAIL: $desc: expected '$want' in: $out"
+        exit 1
+    fi
+    echo "PASS: $desc is refused"
+}
+
+state() {
+    sudo ./hackontainer state $1 | grep -v "^>>>" | jq -r "$2"
+}
+
+# receive starts a console socket receiver, writing what it gets to
+# ${FAKE}/out
+receive() {
+    sudo rm -f ${SOCK} ${FAKE}/out
+    sudo ${FAKE}/recvtty ${SOCK} > ${FAKE}/out 2>/dev/null &
+    RECEIVER=$!
+    for i in $(seq 50); do
+        sudo test -S ${SOCK} && return
+        sleep 0.1
+    done
+    echo "FAIL: receiver didn't listen"
+    exit 1
+}
+
+# in_cgroup <pid> <cgroup path> reports whether pid is in the container's
+# cgroup
+in_cgroup() {
+    if grep -q ":${2}\$" /proc/$1/cgroup; then echo yes; else echo no; fi
+}
+
+echo "=== Checkpointing a running container ==="
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+sudo ./hackontainer start ${CONTAINER} >/dev/null 2>&1
+sudo ./hackontainer --criu ${FAKE}/criu checkpoint --image-path ${FAKE}/images ${CONTAINER}
+check "the checkpointed container stopped" "$(state ${CONTAINER} .status)" "stopped"
+
+echo "=== A restore can't reuse an existing id ==="
+refused "restoring over an existing container" "already exists" \
+    ./hackontainer --criu ${FAKE}/criu restore --bundle ${BUNDLE} --image-path ${FAKE}/images ${CONTAINER}
+check "the existing container is untouched" "$(state ${CONTAINER} .status)" "stopped"
+sudo ./hackontainer delete ${CONTAINER}
+
+echo "=== A restore needs its images and criu ==="
+refused "restoring without --image-path" "--image-path is required" \
+    ./hackontainer --criu ${FAKE}/criu restore --bundle ${BUNDLE} ${CONTAINER}
+refused "restoring from a missing checkpoint" "no checkpoint at" \
+    ./hackontainer --criu ${FAKE}/criu restore --bundle ${BUNDLE} --image-path ${FAKE}/nothing ${CONTAINER}
+refused "restoring without criu" "criu not found" \
+    ./hackontainer --criu /nonexistent/criu restore --bundle ${BUNDLE} --image-path ${FAKE}/images ${CONTAINER}
+if sudo test -e /run/hackontainer/${CONTAINER}; then
+    echo "FAIL: a failed restore left the container behind"
+    exit 1
+fi
+echo "PASS: a failed restore leaves no container"
+
+echo "=== Restoring brings the container back running ==="
+sudo ./hackontainer --criu ${FAKE}/criu restore --bundle ${BUNDLE} --image-path ${FAKE}/images \
+    --work-path ${FAKE}/work --pid-file ${FAKE}/pid ${CONTAINER}
+check "the restored container runs" "$(state ${CONTAINER} .status)" "running"
+PID=$(state ${CONTAINER} .pid)
+check "the pid file has the restored pid" "$(cat ${FAKE}/pid)" "${PID}"
+check "the restored pid is the root of the tree" "$(awk '{print $2}' /proc/${PID}/stat)" "(sleep)"
+ARGS=$(cat ${FAKE}/images/restore.args)
+for want in "--images-dir ${FAKE}/images" "--work-dir ${FAKE}/work" "--root"; do
+    if ! echo " ${ARGS} " | grep -q -- " ${want} "; then
+        echo "FAIL: criu was not given '${want}': ${ARGS}"
+        exit 1
+    fi
+done
+echo "PASS: criu restores from the image path"
+if grep -q "^State:.*stopped" /proc/${PID}/status; then
+    echo "FAIL: the restored process was left stopped"
+    exit 1
+fi
+echo "PASS: the restored process is running"
+
+CHILD=$(cat /proc/${PID}/task/*/children | awk '{print $1}')
+CGROUP=$(state ${CONTAINER} .cgroupPath)
+if [ -n "${CGROUP}" ] && [ "${CGROUP}" != "null" ]; then
+    check "the restored process is in the container's cgroup" "$(in_cgroup ${PID} ${CGROUP})" "yes"
+    check "the rest of the tree is in the container's cgroup" "$(in_cgroup ${CHILD} ${CGROUP})" "yes"
+fi
+
+echo "=== A restored container stops when its process exits ==="
+# The fake tree isn't in a pid namespace, whose init would take it along
+sudo kill -9 ${PID} ${CHILD}
+sleep 1
+check "the restored container stopped" "$(state ${CONTAINER} .status)" "stopped"
+sudo ./hackontainer delete ${CONTAINER}
+
+echo "=== Checkpointing a container with a terminal ==="
+jq '.process.terminal = true | .process.consoleSize = {"height": 24, "width": 91}' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+receive
+sudo ./hackontainer create --bundle ${BUNDLE} --console-socket ${SOCK} ${CONTAINER} >/dev/null 2>&1
+sudo ./hackontainer start ${CONTAINER} >/dev/null 2>&1
+sudo ./hackontainer --criu ${FAKE}/criu checkpoint --image-path ${FAKE}/tty ${CONTAINER}
+sudo kill ${RECEIVER} 2>/dev/null || true
+RECEIVER=""
+TTY=$(sed -n 's/.*--external \(tty\[[0-9a-f]*:[0-9a-f]*\]\).*/\1/p' ${FAKE}/tty/dump.args)
+if [ -z "${TTY}" ]; then
+    echo "FAIL: criu dump was not told the pty is external: $(cat ${FAKE}/tty/dump.args)"
+    exit 1
+fi
+echo "PASS: the pty is dumped as ${TTY}"
+sudo ./hackontainer delete ${CONTAINER}
+
+echo "=== A terminal restore needs a console socket ==="
+refused "restoring a terminal without --console-socket" "no console socket was given" \
+    ./hackontainer --criu ${FAKE}/criu restore --bundle ${BUNDLE} --image-path ${FAKE}/tty ${CONTAINER}
+if [ -e ${FAKE}/tty/restore.args ]; then
+    echo "FAIL: criu ran before the restore was refused"
+    exit 1
+fi
+echo "PASS: refused before criu ran"
+
+echo "=== Restoring a terminal sends a new one to the console socket ==="
+receive
+sudo ./hackontainer --criu ${FAKE}/criu restore --bundle ${BUNDLE} --image-path ${FAKE}/tty \
+    --console-socket ${SOCK} ${CONTAINER}
+check "the restored container runs" "$(state ${CONTAINER} .status)" "running"
+PID=$(state ${CONTAINER} .pid)
+if ! grep -qF -- "--inherit-fd fd[3]:${TTY}" ${FAKE}/tty/restore.args; then
+    echo "FAIL: criu was not given the new pty in place of ${TTY}: $(cat ${FAKE}/tty/restore.args)"
+    exit 1
+fi
+echo "PASS: criu inherits the new pty"
+case "$(sudo readlink /proc/${PID}/fd/0)" in
+/dev/pts/*) echo "PASS: the restored process has a pty as stdio" ;;
+*) echo "FAIL: the restored process's stdio is $(sudo readlink /proc/${PID}/fd/0)"; exit 1 ;;
+esac
+check "the console size is the spec's" "$(sudo stty -F /proc/${PID}/fd/0 size)" "24 91"
+for i in $(seq 50); do
+    grep -q "restored on a terminal" ${FAKE}/out && break
+    sleep 0.1
+done
+if ! grep -q "restored on a terminal" ${FAKE}/out; then
+    echo "FAIL: the console socket didn't get the restored process's terminal: $(cat ${FAKE}/out)"
+    exit 1
+fi
+echo "PASS: the console socket has the restored process's terminal"
+CHILD=$(cat /proc/${PID}/task/*/children | awk '{print $1}')
+sudo kill -9 ${PID} ${CHILD}
+sleep 1
+check "the restored container stopped" "$(state ${CONTAINER} .status)" "stopped"
+sudo ./hackontainer delete ${CONTAINER}
+
+echo "=== Typing into a shell restored by criu ==="
+if ! command -v criu >/dev/null 2>&1; then
+    echo "SKIP: criu is not installed"
+else
+    jq '.process.args = ["sh"]' ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+    mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+    receive
+    sudo ./hackontainer create --bundle ${BUNDLE} --console-socket ${SOCK} ${CONTAINER} >/dev/null 2>&1
+    sudo ./hackontainer start ${CONTAINER} >/dev/null 2>&1
+    sudo ./hackontainer checkpoint --image-path ${FAKE}/real ${CONTAINER}
+    sudo kill ${RECEIVER} 2>/dev/null || true
+    sudo ./hackontainer delete ${CONTAINER}
+
+    # What is written to the fifo is typed into the restored terminal
+    mkfifo ${FAKE}/in
+    sudo rm -f ${SOCK} ${FAKE}/out
+    sudo ${FAKE}/recvtty ${SOCK} < ${FAKE}/in > ${FAKE}/out 2>/dev/null &
+    RECEIVER=$!
+    exec 9> ${FAKE}/in
+    for i in $(seq 50); do
+        sudo test -S ${SOCK} && break
+        sleep 0.1
+    done
+    sudo ./hackontainer restore --bundle ${BUNDLE} --image-path ${FAKE}/real \
+        --console-socket ${SOCK} ${CONTAINER}
+    check "the restored shell runs" "$(state ${CONTAINER} .status)" "running"
+    echo 'echo typed-$((6 * 7))' >&9
+    for i in $(seq 50); do
+        grep -q "typed-42" ${FAKE}/out && break
+        sleep 0.1
+    done
+    if ! grep -q "typed-42" ${FAKE}/out; then
+        echo "FAIL: the restored shell didn't run what was typed: $(cat ${FAKE}/out)"
+        exit 1
+    fi
+    echo "PASS: the restored shell runs what is typed on its new terminal"
+    echo 'exit' >&9
+    exec 9>&-
+    for i in $(seq 50); do
+        [ "$(state ${CONTAINER} .status)" = "stopped" ] && break
+        sleep 0.1
+    done
+    check "the restored container stopped with its shell" "$(state ${CONTAINER} .status)" "stopped"
+fi
+
+echo ""
+echo "=== All restore tests passed ==="
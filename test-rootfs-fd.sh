@@ -0,0 +1,95 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myrootfsfd"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+ABS_BUNDLE=$(cd ${BUNDLE} && pwd)
+
+# evil is what a symlink swapped in for the rootfs points at
+cp -a ${BUNDLE}/rootfs ${BUNDLE}/evil
+echo real > ${BUNDLE}/rootfs/marker
+echo evil > ${BUNDLE}/evil/marker
+
+jq '.process.args = ["cat", "/marker"] | .process.terminal = false | .root.readonly = false' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.orig
+cp ${BUNDLE}/config.json.orig ${BUNDLE}/config.json
+
+swap='mv rootfs rootfs.real && ln -s evil rootfs'
+unswap='rm rootfs && mv rootfs.real rootfs'
+
+# run_pinned opens the rootfs as fd 7, runs $1 in the bundle and then
+# the container with the rootfs given as fd 7, passing run the rest of
+# the arguments
+run_pinned() {
+    sudo bash -c "exec 7<${BUNDLE}/rootfs && (cd ${BUNDLE} && $1) &&
+        ./hackontainer run --rootfs-fd 7 ${*:2} --bundle ${BUNDLE} ${CONTAINER}" 2>&1 | grep -v "^>>>"
+}
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1: got '$2', want '$3'"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+echo "=== A symlink swapped in before create is ignored ==="
+check "container sees the rootfs the fd was opened on" "$(run_pinned "${swap}")" "real"
+(cd ${BUNDLE} && eval "${unswap}")
+
+echo "=== A symlink swapped in while the container starts is ignored ==="
+# createRuntime hooks run between the mounts and pivot_root
+jq --arg swap "cd ${ABS_BUNDLE} && ${swap}" \
+    '.hooks.createRuntime = [{"path": "/bin/sh", "args": ["sh", "-c", $swap]}]' \
+    ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+check "container pivots into the pinned rootfs" "$(run_pinned true)" "real"
+(cd ${BUNDLE} && eval "${unswap}")
+cp ${BUNDLE}/config.json.orig ${BUNDLE}/config.json
+
+echo "=== A pinned rootfs reaches a container in a user namespace ==="
+jq '.linux.namespaces += [{"type": "user"}] |
+    .linux.uidMappings = [{"containerID": 0, "hostID": 100000, "size": 65536}] |
+    .linux.gidMappings = [{"containerID": 0, "hostID": 100000, "size": 65536}]' \
+    ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+check "user namespace container sees the pinned rootfs" "$(run_pinned "${swap}" --keep)" "real"
+(cd ${BUNDLE} && eval "${unswap}")
+cp ${BUNDLE}/config.json.orig ${BUNDLE}/config.json
+
+echo "=== Delete unpins the rootfs and leaves its files alone ==="
+sudo ./hackontainer delete ${CONTAINER}
+if grep -q "/${CONTAINER}/rootfs " /proc/self/mountinfo; then
+    echo "FAIL: pinned rootfs still mounted"
+    exit 1
+fi
+check "rootfs files survive delete" "$(cat ${BUNDLE}/rootfs/marker)" "real"
+
+echo "=== A fd that isn't a directory is refused ==="
+if sudo bash -c "exec 7<${BUNDLE}/config.json &&
+    ./hackontainer create --rootfs-fd 7 --bundle ${BUNDLE} ${CONTAINER}" >/dev/null 2>&1; then
+    sudo ./hackontainer delete ${CONTAINER}
+    echo "FAIL: accepted a regular file as the rootfs"
+    exit 1
+fi
+echo "PASS: regular file refused"
+
+echo "=== All rootfs fd tests passed ==="
@@ -0,0 +1,131 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myrootfsfile"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+ABS_BUNDLE=$(cd ${BUNDLE} && pwd)
+
+# Every link below points at HOST_DIR, which must stay empty: anything
+# the runtime creates through them belongs inside the rootfs
+HOST_DIR=$(mktemp -d)
+trap 'rm -rf ${HOST_DIR}' EXIT
+
+ln -s ${HOST_DIR} ${BUNDLE}/rootfs/escape
+ln -s chain2 ${BUNDLE}/rootfs/chain1
+ln -s ../../../../../../../..${HOST_DIR} ${BUNDLE}/rootfs/chain2
+ln -s /proc/self/root${HOST_DIR} ${BUNDLE}/rootfs/magic
+echo bound > ${BUNDLE}/bindsrc
+
+jq '.process.terminal = false | .root.readonly = false' ${BUNDLE}/config.json > ${BUNDLE}/config.json.orig
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1: got '$2', want '$3'"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+check_host_dir() {
+    if [ -n "$(sudo ls -A ${HOST_DIR})" ]; then
+        echo "FAIL: $1: created on the host: $(sudo ls -A ${HOST_DIR})"
+        exit 1
+    fi
+    echo "PASS: $1 stayed in the rootfs"
+}
+
+# fstype_at <path> prints the type of the filesystem mounted at path,
+# given the container's mountinfo
+fstype_at() {
+    grep " $1 " | sed 's/.* - \([^ ]*\) .*/\1/'
+}
+
+# run_with <env> <jq filter> <command> runs command in the container with
+# the filter applied to the config and env set for the runtime
+run_with() {
+    jq --arg cmd "$3" ".process.args = [\"sh\", \"-c\", \$cmd] | $2" \
+        ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+    sudo env $1 ./hackontainer run --bundle ${BUNDLE} ${CONTAINER} 2>&1
+    sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true
+}
+
+for mechanism in openat2 securejoin; do
+    env=""
+    if [ ${mechanism} = securejoin ]; then
+        env="HACKONTAINER_TEST_NO_OPENAT2=1"
+    fi
+
+    echo "=== [${mechanism}] The mechanism is reported ==="
+    out=$(run_with "${env}" '.' 'true')
+    if ! echo "${out}" | grep -q "Resolving paths in the rootfs with ${mechanism}"; then
+        echo "FAIL: expected ${mechanism} in the debug log"
+        echo "${out}"
+        exit 1
+    fi
+    echo "PASS: debug log reports ${mechanism}"
+
+    echo "=== [${mechanism}] Absolute symlink as a mount destination ==="
+    out=$(run_with "${env}" \
+        '.mounts += [{"destination": "/escape/sub", "type": "tmpfs", "source": "tmpfs"}]' \
+        'cat /proc/self/mountinfo' | fstype_at ${HOST_DIR}/sub)
+    check "tmpfs lands behind the link inside the rootfs" "${out}" "tmpfs"
+    check_host_dir "tmpfs mountpoint"
+
+    echo "=== [${mechanism}] Absolute symlink as a file bind mount destination ==="
+    out=$(run_with "${env}" \
+        ".mounts += [{\"destination\": \"/escape/file\", \"type\": \"bind\", \"source\": \"${ABS_BUNDLE}/bindsrc\", \"options\": [\"bind\"]}]" \
+        'cat /escape/file' | grep -v "^>>>")
+    check "file is bound behind the link inside the rootfs" "${out}" "bound"
+    check_host_dir "file mountpoint"
+
+    echo "=== [${mechanism}] Relative symlink chain climbing past the root ==="
+    out=$(run_with "${env}" \
+        '.mounts += [{"destination": "/chain1/sub", "type": "tmpfs", "source": "tmpfs"}]' \
+        'cat /proc/self/mountinfo' | fstype_at ${HOST_DIR}/sub)
+    check ".. stops at the rootfs" "${out}" "tmpfs"
+    check_host_dir "chained mountpoint"
+
+    echo "=== [${mechanism}] Magic /proc/self/root link ==="
+    # openat2 refuses magic links outright; the fallback reads the link's
+    # text, which stays inside the rootfs. Either way nothing escapes.
+    run_with "${env}" \
+        '.mounts += [{"destination": "/magic/sub", "type": "tmpfs", "source": "tmpfs"}]' \
+        'true' >/dev/null || true
+    check_host_dir "magic link mountpoint"
+
+    echo "=== [${mechanism}] Device nodes under a symlinked /dev ==="
+    mv ${BUNDLE}/rootfs/dev ${BUNDLE}/rootfs/dev.real
+    ln -s ${HOST_DIR} ${BUNDLE}/rootfs/dev
+    out=$(run_with "${env}" \
+        '.mounts |= map(select(.destination | startswith("/dev") | not)) |
+         .linux.devices = [{"path": "/dev/mynull", "type": "c", "major": 1, "minor": 3}]' \
+        'test -c /dev/mynull && echo created' | grep -v "^>>>")
+    rm ${BUNDLE}/rootfs/dev
+    mv ${BUNDLE}/rootfs/dev.real ${BUNDLE}/rootfs/dev
+    check "device created behind the link inside the rootfs" "${out}" "created"
+    check_host_dir "device node"
+done
+
+cp ${BUNDLE}/config.json.orig ${BUNDLE}/config.json
+
+echo "=== All rootfsfile tests passed ==="
@@ -0,0 +1,98 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myruncleanup"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+cleanup() {
+    sudo ./hackontainer kill ${CONTAINER} SIGKILL >/dev/null 2>&1 || true
+    sleep 1
+    sudo ./hackontainer delete --force ${CONTAINER} >/dev/null 2>&1 || true
+}
+trap cleanup EXIT
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false' ${BUNDLE}/config.json > ${BUNDLE}/config.json.orig
+
+# use_args <args> sets the container process
+use_args() {
+    jq --argjson args "$1" '.process.args = $args' ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+}
+
+# expect_gone <what> checks that neither the container nor its cgroup
+# is left
+expect_gone() {
+    if sudo ./hackontainer state ${CONTAINER} >/dev/null 2>&1 || [ -e /run/hackontainer/${CONTAINER} ]; then
+        echo "FAIL: $1: container left behind"
+        exit 1
+    fi
+    if [ -e /sys/fs/cgroup/hackontainer/${CONTAINER} ] || [ -e /sys/fs/cgroup/pids/hackontainer/${CONTAINER} ]; then
+        echo "FAIL: $1: cgroup left behind"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+echo "=== run removes the container once its process exits ==="
+use_args '["sh", "-c", "exit 3"]'
+STATUS=0
+sudo ./hackontainer run --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1 || STATUS=$?
+if [ ${STATUS} != 3 ]; then
+    echo "FAIL: run exited ${STATUS}, expected the process's 3"
+    exit 1
+fi
+echo "PASS: exit code kept"
+expect_gone "removed after exiting"
+
+use_args '["true"]'
+sudo ./hackontainer run --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+expect_gone "the same id runs again"
+
+echo "=== A process killed by a signal is cleaned up after too ==="
+use_args '["sleep", "100"]'
+STATUS=0
+sudo ./hackontainer run --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1 &
+RUN=$!
+for i in $(seq 1 25); do
+    [ "$(sudo ./hackontainer state ${CONTAINER} 2>/dev/null | jq -r .status)" = "running" ] && break
+    sleep 0.2
+done
+sudo ./hackontainer kill ${CONTAINER} KILL
+wait ${RUN} || STATUS=$?
+if [ ${STATUS} != 137 ]; then
+    echo "FAIL: run exited ${STATUS}, expected 137"
+    exit 1
+fi
+expect_gone "removed after SIGKILL"
+
+echo "=== --keep leaves the stopped container ==="
+use_args '["sh", "-c", "exit 3"]'
+sudo ./hackontainer run --keep --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1 || true
+STATE=$(sudo ./hackontainer state ${CONTAINER})
+if [ "$(echo "${STATE}" | jq -r .status)" != "stopped" ] || [ "$(echo "${STATE}" | jq -r .exitStatus)" != "3" ]; then
+    echo "${STATE}"
+    echo "FAIL: --keep didn't keep the stopped container"
+    exit 1
+fi
+sudo ./hackontainer delete ${CONTAINER}
+echo "PASS: kept stopped, with its exit status"
+
+echo "=== All run cleanup tests passed ==="
@@ -0,0 +1,69 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mynsdo"
+BUNDLE="test-bundles/busybox"
+NSDO="test-bundles/nsdo"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+# A file only the container's mount namespace has at /nsdo-marker
+jq '.process.terminal = false
+    | .process.args = ["sleep", "1000"]
+    | .linux.namespaces |= (map(select(.type != "network")) + [{"type": "network"}])' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+echo "hello" > ${BUNDLE}/rootfs/nsdo-marker
+
+echo "=== Building the embedding program ==="
+CGO_ENABLED=0 go build -o ${NSDO} ./test/nsdo
+
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} >/dev/null
+sudo ./hackontainer start ${CONTAINER} >/dev/null 2>&1
+trap 'sudo ./hackontainer kill ${CONTAINER} KILL >/dev/null 2>&1 || true; sleep 1; sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true; rm -f ${NSDO}' EXIT
+
+out=$(sudo ${NSDO} /run/hackontainer ${CONTAINER} /nsdo-marker 2>&1 | grep -v "^>>>" || true)
+
+# check <line> expects nsdo to have printed line
+check() {
+    if ! echo "${out}" | grep -qx "$1"; then
+        echo "FAIL: expected '$1', got: ${out}"
+        exit 1
+    fi
+    echo "PASS: $2"
+}
+check "net-helper lo" "a host binary run in the container's netns sees only its interfaces"
+check "net-inproc lo" "NetNSDo lists the container's interfaces in process"
+check "mnt-helper nsdo-marker 6" "a host binary run in the container's mount ns stats its files"
+
+echo "=== A stopped container's namespaces are refused ==="
+sudo ./hackontainer kill ${CONTAINER} KILL
+for i in $(seq 1 50); do
+    [ "$(sudo ./hackontainer state ${CONTAINER} | jq -r .status)" = "stopped" ] && break
+    sleep 0.1
+done
+out=$(sudo ${NSDO} /run/hackontainer ${CONTAINER} /nsdo-marker 2>&1 || true)
+if ! echo "${out}" | grep -q "container is not running"; then
+    echo "FAIL: expected a stopped container to be refused, got: ${out}"
+    exit 1
+fi
+echo "PASS: stopped container refused"
+
+echo "=== All run in namespaces tests passed ==="
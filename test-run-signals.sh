@@ -0,0 +1,114 @@
+#!/bin/bash
+set -e
+# Background jobs of a job-control shell don't start with INT and QUIT
+# ignored, which a non-interactive one would pass on to the run
+set -m
+
+CONTAINER="myrunsignals"
+BUNDLE="test-bundles/busybox"
+LOG=${BUNDLE}/rootfs/signals
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+RUN=""
+cleanup() {
+    [ -n "${RUN}" ] && sudo kill -9 ${RUN} 2>/dev/null || true
+    sudo ./hackontainer kill ${CONTAINER} SIGKILL >/dev/null 2>&1 && sleep 1 || true
+    sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true
+}
+trap cleanup EXIT
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+# The container process records the signals it gets and exits 3 on
+# TERM; with CHILD set, a child of it records what it gets too
+cat > ${BUNDLE}/rootfs/traps.sh <<'EOF'
+prefix=$1
+for s in INT QUIT HUP USR1 USR2; do trap "echo $prefix$s >> /signals" $s; done
+trap "exit 3" TERM
+[ -z "$prefix" ] && [ -n "$CHILD" ] && sh /traps.sh child- &
+while :; do sleep 0.2; done
+EOF
+jq '.process.terminal = false | .root.readonly = false | .process.args = ["sh", "/traps.sh"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1: expected '$3', got '$2'"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+# started waits for the container to run
+started() {
+    for i in $(seq 50); do
+        [ "$(sudo ./hackontainer state ${CONTAINER} 2>/dev/null | jq -r .status)" = "running" ] && sleep 0.5 && return
+        sleep 0.1
+    done
+    echo "FAIL: container didn't start"
+    exit 1
+}
+
+# received <expected> waits for the log to hold expected, one per line
+received() {
+    for i in $(seq 30); do
+        [ "$(sudo sort ${LOG} 2>/dev/null | tr '\n' ' ')" = "$1 " ] && break
+        sleep 0.1
+    done
+    sudo sort ${LOG} 2>/dev/null | tr '\n' ' ' | sed 's/ $//'
+}
+
+echo "=== run forwards signals to the container process ==="
+sudo rm -f ${LOG}
+sudo ./hackontainer run --bundle ${BUNDLE} ${CONTAINER} &
+RUN=$!
+started
+for SIG in INT QUIT HUP USR1 USR2; do
+    sudo kill -${SIG} ${RUN}
+    sleep 0.3
+done
+check "forwarded signals" "$(received "HUP INT QUIT USR1 USR2")" "HUP INT QUIT USR1 USR2"
+
+echo "=== TERM is forwarded and the exit status kept ==="
+sudo kill -TERM ${RUN}
+STATUS=0
+wait ${RUN} || STATUS=$?
+RUN=""
+check "exit status" "${STATUS}" "3"
+if sudo ./hackontainer state ${CONTAINER} >/dev/null 2>&1; then
+    echo "FAIL: container left behind"
+    exit 1
+fi
+echo "PASS: container deleted"
+
+echo "=== run --all forwards them to every process ==="
+jq '.process.env += ["CHILD=1"]' ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+sudo rm -f ${LOG}
+sudo ./hackontainer run --all --bundle ${BUNDLE} ${CONTAINER} &
+RUN=$!
+started
+sudo kill -USR2 ${RUN}
+check "forwarded to all" "$(received "USR2 child-USR2")" "USR2 child-USR2"
+sudo kill -TERM ${RUN}
+STATUS=0
+wait ${RUN} || STATUS=$?
+RUN=""
+check "exit status with --all" "${STATUS}" "3"
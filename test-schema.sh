@@ -0,0 +1,100 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myschema"
+BUNDLE="test-bundles/busybox"
+VALIDATOR=$(mktemp)
+trap 'rm -f ${VALIDATOR}' EXIT
+
+echo "=== Checking the embedded schemas are up to date ==="
+go generate ./api/types
+if ! git diff --quiet -- api/types/schemas; then
+    git diff --stat -- api/types/schemas
+    echo "FAIL: schemas are stale; commit the output of go generate ./api/types"
+    exit 1
+fi
+echo "PASS: schemas match the Go types"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false | .process.args = ["sleep", "5"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+
+# Validates the subset of JSON Schema the generated schemas use. Unlike
+# the schemas themselves it rejects unknown properties, so a field that
+# bypasses the typed structs shows up here.
+cat > ${VALIDATOR} <<'JQ'
+def jtype: if type == "number" then (if . == floor then "integer" else "number" end) else type end;
+def errors($s; $p):
+  . as $v
+  | (if $s.type and ($v | jtype) != $s.type and ($s.type != "number" or ($v | jtype) != "integer")
+     then ["\($p): want \($s.type), got \($v | jtype)"] else [] end)
+  + (if $s.enum and ($s.enum | index($v)) == null then ["\($p): \($v) is not one of \($s.enum)"] else [] end)
+  + (if $s.type == "object" and ($v | type) == "object" then
+       [($s.required // [])[] as $k | select($v | has($k) | not) | "\($p): missing \($k)"]
+       + [$v | to_entries[] | .key as $k | .value as $x
+          | if $s.properties and $s.properties[$k] then ($x | errors($s.properties[$k]; "\($p).\($k)"))[]
+            elif $s.additionalProperties then ($x | errors($s.additionalProperties; "\($p).\($k)"))[]
+            else "\($p): unexpected property \($k)" end]
+     else [] end)
+  + (if $s.type == "array" and ($v | type) == "array" then
+       [$v | to_entries[] | .key as $i | (.value | errors($s.items; "\($p)[\($i)]"))[]]
+     else [] end);
+errors($schema; "$")[]
+JQ
+
+validate() {
+    local document=$1
+    local output=$2
+    local errors
+    errors=$(echo "${output}" | jq -r --argjson schema "$(./hackontainer schema ${document})" -f ${VALIDATOR})
+    if [ -n "${errors}" ]; then
+        echo "FAIL: ${document} output does not match its schema:"
+        echo "${errors}"
+        FAILED=1
+    else
+        echo "PASS: ${document}"
+    fi
+}
+
+echo "=== Validating command output against the schemas ==="
+FAILED=0
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER}
+validate state "$(sudo ./hackontainer state ${CONTAINER})"
+sudo ./hackontainer start ${CONTAINER}
+validate state "$(sudo ./hackontainer state ${CONTAINER})"
+validate inspect "$(sudo ./hackontainer inspect ${CONTAINER})"
+validate stats "$(sudo ./hackontainer stats ${CONTAINER})"
+validate ps "$(sudo ./hackontainer ps --format json ${CONTAINER})"
+validate stats-event "$(sudo ./hackontainer events --stats ${CONTAINER})"
+validate status "$(sudo ./hackontainer status)"
+validate version "$(./hackontainer --version --format json)"
+sleep 6
+validate state "$(sudo ./hackontainer state ${CONTAINER})"
+validate stats "$(sudo ./hackontainer stats --final ${CONTAINER})"
+validate inspect "$(sudo ./hackontainer inspect ${CONTAINER})"
+sudo ./hackontainer delete ${CONTAINER}
+
+sudo ./hackontainer events --all --filter "id=${CONTAINER}" | while read -r line; do
+    validate event "${line}"
+done | tee /dev/stderr | grep -q FAIL && FAILED=1
+
+exit ${FAILED}
@@ -0,0 +1,107 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myseccomp"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+cleanup() {
+    sudo ./hackontainer delete --force ${CONTAINER} >/dev/null 2>&1 || true
+}
+trap cleanup EXIT
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false | .root.readonly = false | .process.args = ["sh", "-c", "mkdir /tmp/logged && echo made"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.orig
+
+# use_seccomp <profile> sets linux.seccomp
+use_seccomp() {
+    jq --argjson seccomp "$1" '.linux.seccomp = $seccomp' ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+}
+
+# refused <profile> <error> <what> checks the profile is refused with error
+refused() {
+    use_seccomp "$1"
+    ERR=$(sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} 2>&1 || true)
+    if ! echo "${ERR}" | grep -q "$2"; then
+        echo "${ERR}"
+        echo "FAIL: $3"
+        exit 1
+    fi
+    if sudo ./hackontainer state ${CONTAINER} >/dev/null 2>&1; then
+        echo "FAIL: $3: a container was created anyway"
+        exit 1
+    fi
+    echo "PASS: $3"
+}
+
+LOG_ONLY='{"defaultAction": "SCMP_ACT_ALLOW", "flags": ["SECCOMP_FILTER_FLAG_LOG"],
+    "syscalls": [{"names": ["mkdir", "mkdirat"], "action": "SCMP_ACT_LOG"}]}'
+
+echo "=== A log-only profile is accepted ==="
+use_seccomp "${LOG_ONLY}"
+OUT=$(sudo ./hackontainer run --bundle ${BUNDLE} ${CONTAINER} 2>&1)
+if ! echo "${OUT}" | grep -qx made; then
+    echo "${OUT}"
+    echo "FAIL: the logged syscall didn't succeed"
+    exit 1
+fi
+echo "PASS: the logged syscall succeeds"
+if ! echo "${OUT}" | grep -q "WARNING: linux.seccomp is validated but not enforced"; then
+    echo "${OUT}"
+    echo "FAIL: no warning that the profile isn't enforced"
+    exit 1
+fi
+echo "PASS: the profile is reported not enforced"
+
+use_seccomp '{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"names": ["ptrace"], "action": "SCMP_ACT_TRACE", "errnoRet": 1}]}'
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+echo "PASS: SCMP_ACT_TRACE with an errnoRet is accepted"
+sudo ./hackontainer delete ${CONTAINER}
+
+echo "=== Profiles the kernel would reject are refused ==="
+refused '{"defaultAction": "SCMP_ACT_ALLOW", "flags": ["SECCOMP_FILTER_FLAG_TSYNC"], "listenerPath": "/run/listener.sock",
+    "syscalls": [{"names": ["mkdir"], "action": "SCMP_ACT_NOTIFY"}]}' \
+    "SECCOMP_FILTER_FLAG_TSYNC can't be combined with SCMP_ACT_NOTIFY" "TSYNC with notify"
+refused '{"defaultAction": "SCMP_ACT_ALLOW", "flags": ["SECCOMP_FILTER_FLAG_BOGUS"]}' \
+    'unknown seccomp flag "SECCOMP_FILTER_FLAG_BOGUS"' "an unknown flag"
+refused '{"defaultAction": "SCMP_ACT_MAYBE"}' 'unknown action "SCMP_ACT_MAYBE"' "an unknown action"
+refused '{"defaultAction": "SCMP_ACT_ALLOW", "defaultErrnoRet": 1}' \
+    "errnoRet is only valid with" "an errno on an action that returns none"
+refused '{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"names": ["mkdir"], "action": "SCMP_ACT_NOTIFY"}]}' \
+    "needs a listenerPath" "notify without a listener"
+refused '{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"names": ["mkdir"], "action": "SCMP_ACT_ERRNO",
+    "args": [{"index": 0, "value": 0, "op": "SCMP_CMP_ABOUT"}]}]}' \
+    'unknown operator "SCMP_CMP_ABOUT"' "an unknown operator"
+
+echo "=== The kernel's flags are listed in features ==="
+FEATURES=$(sudo ./hackontainer features)
+if ! echo "${FEATURES}" | jq -e '.linux.seccomp.knownFlags | index("SECCOMP_FILTER_FLAG_LOG") and index("SECCOMP_FILTER_FLAG_TSYNC")' >/dev/null; then
+    echo "FAIL: the known flags are missing"
+    exit 1
+fi
+echo "PASS: the known flags are listed"
+if ! echo "${FEATURES}" | jq -e '.linux.seccomp as $s | ($s.supportedFlags // []) - $s.knownFlags == []' >/dev/null; then
+    echo "FAIL: a supported flag isn't known"
+    exit 1
+fi
+echo "PASS: the supported flags are among the known ones"
+
+echo "=== All seccomp tests passed ==="
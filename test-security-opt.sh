@@ -0,0 +1,105 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mysecopt"
+BUNDLE="test-bundles/busybox"
+FROZEN="/run/hackontainer/${CONTAINER}/config.json"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+echo "=== Confining the config with seccomp, AppArmor and few capabilities ==="
+jq '.process.terminal = false | .process.args = ["true"] |
+    .linux.seccomp = {"defaultAction": "SCMP_ACT_ERRNO"} |
+    .process.apparmorProfile = "confined" |
+    .process.capabilities = {"bounding": ["CAP_KILL"], "effective": ["CAP_KILL"], "permitted": ["CAP_KILL"]}' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+
+# create_with creates the container with the given options and prints
+# its frozen config
+create_with() {
+    sudo ./hackontainer create --bundle ${BUNDLE} "$@" ${CONTAINER} >/dev/null 2>/tmp/secopt.err
+    sudo cat ${FROZEN}
+    sudo ./hackontainer delete ${CONTAINER}
+}
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1: got '$2', want '$3'"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+echo "=== Without options nothing changes ==="
+SPEC=$(create_with)
+check "seccomp kept" "$(echo "${SPEC}" | jq -c .linux.seccomp)" '{"defaultAction":"SCMP_ACT_ERRNO"}'
+check "profile kept" "$(echo "${SPEC}" | jq -r .process.apparmorProfile)" "confined"
+check "no record" "$(echo "${SPEC}" | jq -r '.annotations["org.hackontainer.security-overrides"]')" "null"
+
+echo "=== seccomp=unconfined ==="
+SPEC=$(create_with --security-opt seccomp=unconfined)
+check "seccomp dropped" "$(echo "${SPEC}" | jq -c .linux.seccomp)" "null"
+check "profile kept" "$(echo "${SPEC}" | jq -r .process.apparmorProfile)" "confined"
+check "recorded" "$(echo "${SPEC}" | jq -r '.annotations["org.hackontainer.security-overrides"]')" "seccomp=unconfined"
+if ! grep -q "^WARNING: .*weakened confinement" /tmp/secopt.err; then
+    echo "FAIL: no warning about weakened confinement"
+    exit 1
+fi
+echo "PASS: warned"
+
+echo "=== apparmor=unconfined ==="
+SPEC=$(create_with --security-opt apparmor=unconfined)
+check "profile unconfined" "$(echo "${SPEC}" | jq -r .process.apparmorProfile)" "unconfined"
+check "seccomp kept" "$(echo "${SPEC}" | jq -c .linux.seccomp)" '{"defaultAction":"SCMP_ACT_ERRNO"}'
+
+echo "=== --cap-add with names ==="
+SPEC=$(create_with --cap-add net_admin,SYS_PTRACE --cap-add CAP_KILL)
+for set in bounding effective permitted; do
+    check "${set}" "$(echo "${SPEC}" | jq -c .process.capabilities.${set})" '["CAP_KILL","CAP_NET_ADMIN","CAP_SYS_PTRACE"]'
+done
+check "inheritable untouched" "$(echo "${SPEC}" | jq -c .process.capabilities.inheritable)" "null"
+
+echo "=== --cap-add ALL ==="
+SPEC=$(create_with --cap-add ALL)
+check "every capability" "$(echo "${SPEC}" | jq '.process.capabilities.bounding | length')" "41"
+check "no duplicates" "$(echo "${SPEC}" | jq '.process.capabilities.effective | unique | length')" "41"
+
+echo "=== All options combined, as inspect shows them ==="
+sudo ./hackontainer create --bundle ${BUNDLE} --security-opt seccomp=unconfined \
+    --security-opt apparmor=unconfined --cap-add ALL ${CONTAINER} >/dev/null 2>&1
+RECORD=$(sudo ./hackontainer inspect ${CONTAINER} | jq -r '.annotations["org.hackontainer.security-overrides"]')
+sudo ./hackontainer delete ${CONTAINER}
+check "inspect" "${RECORD}" "seccomp=unconfined,apparmor=unconfined,cap-add=ALL"
+
+echo "=== Invalid options are refused ==="
+for opts in "--security-opt label=disable" "--security-opt seccomp=profile.json" \
+            "--security-opt seccomp" "--cap-add CAP_FLY"; do
+    if sudo ./hackontainer create --bundle ${BUNDLE} ${opts} ${CONTAINER} >/dev/null 2>&1; then
+        sudo ./hackontainer delete ${CONTAINER}
+        echo "FAIL: accepted ${opts}"
+        exit 1
+    fi
+    echo "PASS: refused ${opts}"
+done
+
+echo "=== Cleaning up ==="
+rm -f /tmp/secopt.err
+echo "=== All security option tests passed ==="
@@ -0,0 +1,84 @@
+#!/bin/bash
+set -e
+
+WORK_DIR=$(mktemp -d)
+OUT_FILE=$(mktemp)
+trap 'sudo rm -rf ${WORK_DIR} ${OUT_FILE}' EXIT
+
+# self_test <args...> runs the self-test in WORK_DIR, leaving its report
+# without the debug output in OUT
+self_test() {
+    if ! sudo ./hackontainer "$@" self-test --bundle-dir ${WORK_DIR} > ${OUT_FILE} 2>&1; then
+        grep -v "^>>>" ${OUT_FILE}
+        echo "FAIL: self-test $*: exited nonzero"
+        exit 1
+    fi
+    OUT=$(grep -v "^>>>" ${OUT_FILE})
+}
+
+# expect_check <verdict> <check> looks for the check's line in OUT
+expect_check() {
+    if ! echo "${OUT}" | grep -qx "$1 *$2"; then
+        echo "${OUT}"
+        echo "FAIL: expected $1 for $2"
+        exit 1
+    fi
+    echo "PASS: $2 reported as $1"
+}
+
+# expect_clean checks the self-test left nothing behind
+expect_clean() {
+    if [ -n "$(sudo ls -A ${WORK_DIR})" ]; then
+        echo "FAIL: the self-test left $(sudo ls -A ${WORK_DIR}) behind"
+        exit 1
+    fi
+    if ls -d /sys/fs/cgroup/*/hackontainer/self-test-* /sys/fs/cgroup/hackontainer/self-test-* >/dev/null 2>&1; then
+        echo "FAIL: the self-test left its cgroup behind"
+        exit 1
+    fi
+    echo "PASS: nothing left behind"
+}
+
+echo "=== Rootful self-test ==="
+self_test
+for check in setup create start namespaces hostname mounts devices "masked and read-only paths" \
+    "cgroup limits" exec kill delete cleanup; do
+    expect_check PASS "${check}"
+done
+if echo "${OUT}" | grep -q "^FAIL\|^SKIP"; then
+    echo "${OUT}"
+    echo "FAIL: a rootful check failed or was skipped"
+    exit 1
+fi
+echo "PASS: every check ran"
+expect_clean
+
+echo "=== Rootless self-test ==="
+self_test --rootless=true
+expect_check PASS namespaces
+expect_check PASS devices
+expect_check SKIP "cgroup limits"
+if echo "${OUT}" | grep -q "^FAIL"; then
+    echo "${OUT}"
+    echo "FAIL: a rootless check failed"
+    exit 1
+fi
+echo "PASS: no rootless check failed"
+expect_clean
+
+echo "=== A failing self-test exits nonzero and still cleans up ==="
+# A bundle directory the container can't exec from fails start
+sudo mount -t tmpfs -o noexec tmpfs ${WORK_DIR}
+if sudo ./hackontainer self-test --bundle-dir ${WORK_DIR} > ${OUT_FILE} 2>&1; then
+    sudo umount ${WORK_DIR}
+    echo "FAIL: the self-test passed from a noexec directory"
+    exit 1
+fi
+OUT=$(grep -v "^>>>" ${OUT_FILE})
+expect_check FAIL start
+expect_check SKIP exec
+expect_check PASS cleanup
+expect_clean
+sudo umount ${WORK_DIR}
+
+echo "=== All self-test tests passed ==="
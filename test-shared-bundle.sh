@@ -0,0 +1,72 @@
+#!/bin/bash
+set -e
+
+FIRST="myshared1"
+SECOND="myshared2"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${FIRST} /run/hackontainer/${SECOND}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+# The process checks /proc and /dev once a second for as long as it runs
+jq '.process.terminal = false
+    | .process.args = ["sh", "-c", "while sleep 1; do test -r /proc/self/status && echo ok > /dev/null || exit 1; done"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+
+echo "=== Starting two containers from the same bundle at once ==="
+sudo ./hackontainer create --bundle ${BUNDLE} ${FIRST} &
+sudo ./hackontainer create --bundle ${BUNDLE} ${SECOND} &
+wait
+sudo ./hackontainer start ${FIRST} &
+sudo ./hackontainer start ${SECOND} &
+wait
+
+for c in ${FIRST} ${SECOND}; do
+    if [ "$(sudo ./hackontainer state ${c} | jq -r '.status')" != "running" ]; then
+        echo "FAIL: ${c} is not running"
+        exit 1
+    fi
+done
+
+echo "=== Stopping and deleting ${FIRST} ==="
+sudo ./hackontainer kill ${FIRST} KILL
+sleep 1
+sudo ./hackontainer delete ${FIRST}
+
+echo "=== ${SECOND} must keep working ==="
+sleep 3
+if [ "$(sudo ./hackontainer state ${SECOND} | jq -r '.status')" != "running" ]; then
+    echo "FAIL: ${SECOND} stopped after ${FIRST} was removed"
+    sudo ./hackontainer delete ${SECOND}
+    exit 1
+fi
+echo "PASS: ${SECOND} still has a working /proc and /dev"
+
+echo "=== Checking the rootfs on disk is untouched ==="
+if [ -n "$(ls -A ${BUNDLE}/rootfs/proc)" ] || [ -c ${BUNDLE}/rootfs/dev/null ]; then
+    echo "FAIL: container mounts or devices leaked into the shared rootfs"
+    EXIT=1
+fi
+
+echo "=== Cleaning up ==="
+sudo ./hackontainer kill ${SECOND} KILL
+sleep 1
+sudo ./hackontainer delete ${SECOND}
+exit ${EXIT:-0}
@@ -0,0 +1,132 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myshared"
+BUNDLE="test-bundles/busybox"
+ROOT="/run/hackontainer-shared"
+# NFS_ROOT, if set, is a directory on a network filesystem for checking
+# that such a root is refused by default
+NFS_ROOT=${NFS_ROOT:-}
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf ${ROOT}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false | .process.args = ["sleep", "100"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+
+hk() {
+    sudo ./hackontainer --root ${ROOT} --allow-shared-root "$@"
+}
+
+# set_owner <hostname> stamps the container with another owner, as if
+# another host sharing the root had created it
+set_owner() {
+    sudo jq --arg host "$1" '.owner.hostname = $host' ${ROOT}/${CONTAINER}/state.json > /tmp/shared-state.json
+    sudo mv /tmp/shared-state.json ${ROOT}/${CONTAINER}/state.json
+}
+trap 'set_owner "$(hostname)" >/dev/null 2>&1 || true; hk kill ${CONTAINER} SIGKILL >/dev/null 2>&1 && sleep 1; hk delete --force ${CONTAINER} >/dev/null 2>&1 || true; sudo rm -rf ${ROOT}' EXIT
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1: got '$2', want '$3'"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+# refused <what> <want> <command...> runs a command that must fail and
+# name why
+refused() {
+    local desc=$1 want=$2
+    shift 2
+    local out
+    if out=$("$@" 2>&1); then
+        echo "FAIL: $desc succeeded"
+        exit 1
+    fi
+    if ! echo "$out" | grep -q -- "$want"; then
+        echo "FAIL: $desc: expected '$want' in: $out"
+        exit 1
+    fi
+    echo "PASS: $desc is refused"
+}
+
+state() {
+    hk state ${CONTAINER} | grep -v "^>>>" | jq -r "$1"
+}
+
+echo "=== A root on a network filesystem is refused by default ==="
+if [ -n "${NFS_ROOT}" ]; then
+    refused "a root on a network filesystem" "use --allow-shared-root" \
+        sudo ./hackontainer --root ${NFS_ROOT} list
+    sudo ./hackontainer --root ${NFS_ROOT} --allow-shared-root list >/dev/null
+    echo "PASS: --allow-shared-root allows it"
+else
+    echo "SKIP: set NFS_ROOT to a directory on a network filesystem to check the refusal"
+fi
+
+echo "=== A container on a shared root is stamped with its owner ==="
+hk create --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+hk start ${CONTAINER} >/dev/null 2>&1
+check "the owner is this host" "$(state .owner.hostname)" "$(hostname)"
+check "the owner's boot" "$(state .owner.bootId)" "$(cat /proc/sys/kernel/random/boot_id)"
+if ! sudo test -f ${ROOT}/${CONTAINER}/shared-root; then
+    echo "FAIL: the container is not marked as on a shared root"
+    exit 1
+fi
+echo "PASS: the container is marked as on a shared root"
+
+echo "=== Its owner manages it with lock files ==="
+hk pause ${CONTAINER}
+check "pause works" "$(state .status)" "paused"
+hk resume ${CONTAINER}
+check "resume works" "$(state .status)" "running"
+if sudo test -e ${ROOT}/${CONTAINER}/lock; then
+    echo "FAIL: the lock file is left behind"
+    exit 1
+fi
+echo "PASS: the lock file is removed on unlock"
+
+echo "=== A lock file left by a process that is gone is broken ==="
+echo "$(hostname) 999999999" | sudo tee ${ROOT}/${CONTAINER}/lock >/dev/null
+hk pause ${CONTAINER}
+hk resume ${CONTAINER}
+check "the stale lock did not block" "$(state .status)" "running"
+
+echo "=== Another host's container is left alone ==="
+set_owner "elsewhere"
+check "state reports what was recorded" "$(state .status)" "running"
+refused "signalling another host's container" "owned by host elsewhere" hk kill ${CONTAINER} SIGKILL
+refused "pausing another host's container" "owned by host elsewhere" hk pause ${CONTAINER}
+refused "updating another host's container" "owned by host elsewhere" hk update --pids-limit 10 ${CONTAINER}
+refused "exec in another host's container" "owned by host elsewhere" hk exec ${CONTAINER} true
+refused "deleting another host's container" "owned by host elsewhere" hk delete --force ${CONTAINER}
+check "the process still runs" "$(sudo kill -0 $(state .pid) && echo alive)" "alive"
+
+echo "=== Once its own again, the container can be managed ==="
+set_owner "$(hostname)"
+hk kill ${CONTAINER} SIGKILL
+sleep 1
+check "kill works" "$(state .status)" "stopped"
+hk delete ${CONTAINER}
+check "delete works" "$(sudo test -e ${ROOT}/${CONTAINER} || echo gone)" "gone"
+
+echo ""
+echo "=== All shared root tests passed ==="
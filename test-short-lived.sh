@@ -0,0 +1,102 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myshortlived"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false' ${BUNDLE}/config.json > ${BUNDLE}/config.json.orig
+OUT_FILE=$(mktemp)
+trap 'sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true; rm -f ${OUT_FILE}' EXIT
+
+# start_with <args> creates and starts a container running args, leaving
+# what start printed in OUT and its exit status in STATUS
+start_with() {
+    jq --argjson args "$1" '.process.args = $args' ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+    sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true
+    sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} >/dev/null
+    STATUS=0
+    sudo ./hackontainer start ${CONTAINER} > ${OUT_FILE} 2>&1 || STATUS=$?
+    OUT=$(grep -v "^>>>" ${OUT_FILE} || true)
+}
+
+# run_with <args> runs a container running args, like start_with
+run_with() {
+    jq --argjson args "$1" '.process.args = $args' ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+    sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true
+    STATUS=0
+    sudo ./hackontainer run --bundle ${BUNDLE} ${CONTAINER} > ${OUT_FILE} 2>&1 || STATUS=$?
+    OUT=$(grep -v "^>>>" ${OUT_FILE} || true)
+}
+
+# expect <status> <substring> <what> checks STATUS and OUT
+expect() {
+    if [ "${STATUS}" != "$1" ] || ! echo "${OUT}" | grep -qF -- "$2"; then
+        echo "FAIL: $3: expected status $1 and '$2', got status ${STATUS}: ${OUT}"
+        exit 1
+    fi
+    echo "PASS: $3"
+}
+
+echo "=== An entrypoint that doesn't exist fails the start ==="
+start_with '["/bin/foo"]'
+if [ "${STATUS}" = "0" ] || [ "$(sudo ./hackontainer state ${CONTAINER} | jq -r .status)" != "created" ]; then
+    echo "FAIL: expected the start to fail and the container to stay created, got status ${STATUS}: ${OUT}"
+    exit 1
+fi
+echo "PASS: missing entrypoint refused at exec"
+
+echo "=== A shell whose command isn't found exits immediately ==="
+start_with '["sh", "-c", "/bin/foo"]'
+expect 127 "container exited immediately with code 127" "start reports the exit code"
+expect 127 "/bin/foo: not found" "the container's stderr is shown"
+
+echo "=== A process exiting 3 at once ==="
+start_with '["sh", "-c", "exit 3"]'
+expect 3 "container exited immediately with code 3" "start fails with the code"
+STATE=$(sudo ./hackontainer state ${CONTAINER} | jq -c '{status, exitStatus}')
+if [ "${STATE}" != '{"status":"stopped","exitStatus":3}' ]; then
+    echo "FAIL: expected the exit to be recorded, got: ${STATE}"
+    exit 1
+fi
+echo "PASS: the exit is recorded"
+run_with '["sh", "-c", "exit 3"]'
+expect 3 "container exited immediately with code 3" "run fails with the code"
+
+echo "=== A process succeeding at once is no failure ==="
+start_with '["true"]'
+expect 0 "" "start succeeds"
+
+echo "=== A long-running process starts as usual ==="
+start_with '["sleep", "1000"]'
+expect 0 "" "start succeeds"
+sudo ./hackontainer kill ${CONTAINER} KILL
+
+echo "=== run exits with the code of a process that fails later ==="
+run_with '["sh", "-c", "sleep 0.5; exit 4"]'
+expect 4 "" "run exits 4"
+if echo "${OUT}" | grep -q "immediately"; then
+    echo "FAIL: a process that ran for a while was reported as exiting immediately: ${OUT}"
+    exit 1
+fi
+echo "PASS: not reported as immediate"
+
+echo "=== All short-lived container tests passed ==="
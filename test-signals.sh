@@ -0,0 +1,115 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mysignals"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+cleanup() {
+    sudo ./hackontainer kill ${CONTAINER} SIGKILL >/dev/null 2>&1 && sleep 1 || true
+    sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true
+}
+trap cleanup EXIT
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+# <form> <number it names>
+SIGNALS="TERM 15
+SIGTERM 15
+sigterm 15
+Term 15
+15 15
+HUP 1
+USR1 10
+IOT 6
+SIGIOT 6
+SIGRTMIN 34
+RTMIN 34
+SIGRTMIN+0 34
+SIGRTMIN+3 37
+sigrtmin+3 37
+RTMIN+3 37
+SIGRTMIN+30 64
+SIGRTMAX 64
+SIGRTMAX-1 63
+rtmax-1 63
+SIGRTMAX-30 34
+64 64"
+
+# The container records every signal it is sent, by number
+TRAPS=""
+for n in $(echo "${SIGNALS}" | awk '{print $2}' | sort -un); do
+    TRAPS="${TRAPS} trap 'echo ${n} >> /signals' ${n};"
+done
+jq --arg script "${TRAPS} while :; do sleep 1; done" \
+    '.process.terminal = false | .root.readonly = false | .process.args = ["sh", "-c", $script]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+LOG=${BUNDLE}/rootfs/signals
+
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER}
+sudo ./hackontainer start ${CONTAINER}
+sleep 1
+
+FAILED=0
+
+echo "=== Signals are sent by every form of their name ==="
+while read -r FORM EXPECTED; do
+    sudo rm -f ${LOG}
+    if ! sudo ./hackontainer kill ${CONTAINER} ${FORM}; then
+        echo "FAIL: ${FORM} refused"
+        FAILED=1
+        continue
+    fi
+    for i in $(seq 30); do
+        [ -s ${LOG} ] && break
+        sleep 0.1
+    done
+    GOT=$(sudo cat ${LOG} 2>/dev/null | tr '\n' ' ')
+    if [ "${GOT}" != "${EXPECTED} " ]; then
+        echo "FAIL: ${FORM}: expected ${EXPECTED}, got '${GOT}'"
+        FAILED=1
+    else
+        echo "PASS: ${FORM} is ${EXPECTED}"
+    fi
+done <<< "${SIGNALS}"
+
+echo "=== Invalid signals are refused ==="
+for FORM in SIGFOO FOO SIG 0 65 RTMIN+31 SIGRTMAX-31 SIGRTMIN-1 SIGRTMAX+1 RTMIN+ RTMIN+x "RTMIN+-1"; do
+    if OUT=$(sudo ./hackontainer kill ${CONTAINER} "${FORM}" 2>&1); then
+        echo "FAIL: ${FORM} accepted"
+        FAILED=1
+    else
+        echo "PASS: ${FORM} refused: ${OUT}"
+    fi
+done
+
+echo "=== Unknown names list the accepted forms ==="
+OUT=$(sudo ./hackontainer kill ${CONTAINER} SIGFOO 2>&1 || true)
+if ! echo "${OUT}" | grep -q "SIGRTMIN+n"; then
+    echo "FAIL: error doesn't list the accepted forms: ${OUT}"
+    FAILED=1
+else
+    echo "PASS: ${OUT}"
+fi
+
+if [ ${FAILED} -ne 0 ]; then
+    exit 1
+fi
+echo "PASS: kill takes the full signal table"
@@ -0,0 +1,85 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myspecconv"
+BUNDLE="test-bundles/busybox"
+ROOT="/run/hackontainer"
+
+echo "=== Converting the fixture specs of other container engines ==="
+go run ./test/specconv test/specconv-fixtures
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf ${ROOT}/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+# A bind mount with a source relative to the bundle, and no mount
+# namespace in the spec
+mkdir -p ${BUNDLE}/shared
+echo "from the bundle" > ${BUNDLE}/shared/marker
+jq '.process.terminal = false | .process.args = ["cat", "/mnt/marker"]
+    | .mounts += [{"destination": "/mnt/", "type": "bind", "source": "shared", "options": ["rbind", "ro"]}]
+    | .linux.namespaces |= map(select(.type != "mount"))' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+ABS_BUNDLE=$(cd ${BUNDLE} && pwd)
+OUT_FILE=$(mktemp)
+trap 'sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true; rm -f ${OUT_FILE}' EXIT
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1: expected '$3', got '$2'"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+echo "=== Create freezes the resolved config next to the spec ==="
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} >/dev/null
+FROZEN=${ROOT}/${CONTAINER}/config.json
+check "the spec stays at the top level" \
+    "$(sudo jq -c .process.args ${FROZEN})" '["cat","/mnt/marker"]'
+check "the rootfs is absolute" \
+    "$(sudo jq -r .resolved.rootfs ${FROZEN})" "${ABS_BUNDLE}/rootfs"
+check "the bind source is resolved against the bundle" \
+    "$(sudo jq -c '.resolved.mounts[] | select(.destination == "/mnt") | {source, bind}' ${FROZEN})" \
+    "{\"source\":\"${ABS_BUNDLE}/shared\",\"bind\":true}"
+check "the mount namespace is always there" \
+    "$(sudo jq '[.resolved.namespaces[].type] | index("mount") != null' ${FROZEN})" "true"
+check "the cgroup path matches the state" \
+    "$(sudo jq -r .resolved.cgroupsPath ${FROZEN})" "$(sudo ./hackontainer state ${CONTAINER} | jq -r .cgroupPath)"
+
+echo "=== The container is set up from the resolved config ==="
+# Without the bundle's config.json, start can only go by the frozen one
+mv ${BUNDLE}/config.json ${BUNDLE}/config.json.moved
+sudo ./hackontainer start ${CONTAINER} > ${OUT_FILE} 2>&1 || true
+mv ${BUNDLE}/config.json.moved ${BUNDLE}/config.json
+check "the bind mount is in place" "$(grep -v "^>>>" ${OUT_FILE} || true)" "from the bundle"
+
+echo "=== A config frozen before the resolved form is resolved on load ==="
+sudo ./hackontainer delete ${CONTAINER} >/dev/null
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} >/dev/null
+sudo jq -c 'del(.resolved)' ${FROZEN} | sudo tee ${FROZEN}.tmp >/dev/null
+sudo mv ${FROZEN}.tmp ${FROZEN}
+# Nor was its hash recorded then
+STATE_FILE=${ROOT}/${CONTAINER}/state.json
+sudo jq -c 'del(.frozenConfigSha256)' ${STATE_FILE} | sudo tee ${STATE_FILE}.tmp >/dev/null
+sudo mv ${STATE_FILE}.tmp ${STATE_FILE}
+sudo ./hackontainer start ${CONTAINER} > ${OUT_FILE} 2>&1 || true
+check "the old config still starts" "$(grep -v "^>>>" ${OUT_FILE} || true)" "from the bundle"
+
+echo "=== All specconv tests passed ==="
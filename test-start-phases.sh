@@ -0,0 +1,80 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myphases"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false | .process.args = ["true"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.orig
+
+# The events log outlives earlier runs of this test
+SINCE=$(date -u +%Y-%m-%dT%H:%M:%S.%NZ)
+
+# expect_phase <phase> <jq filter> breaks the config with the filter and
+# checks both run and create+start report the failure in phase
+expect_phase() {
+    jq "$2" ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+
+    out=$(sudo ./hackontainer run --bundle ${BUNDLE} ${CONTAINER} 2>&1 | grep -v "^>>>" || true)
+    sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true
+    if ! echo "${out}" | grep -q "start failed during $1: "; then
+        echo "FAIL: run: expected a $1 failure, got: ${out}"
+        exit 1
+    fi
+    echo "PASS: run reports $1"
+
+    # start goes through the monitor, which has to pass the phase on
+    sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} >/dev/null
+    out=$(sudo ./hackontainer start ${CONTAINER} 2>&1 | grep -v "^>>>" || true)
+    sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true
+    if ! echo "${out}" | grep -q "^Error: failed to start container: start failed during $1: "; then
+        echo "FAIL: start: expected a $1 failure, got: ${out}"
+        exit 1
+    fi
+    echo "PASS: start reports $1"
+}
+
+echo "=== A mount of an unknown filesystem fails in mounts ==="
+expect_phase mounts '.mounts += [{"destination": "/bogus", "type": "bogusfs", "source": "none"}]'
+
+echo "=== Overlapping uid mappings fail in usermap ==="
+expect_phase usermap '.linux.namespaces += [{"type": "user"}] |
+    .linux.uidMappings = [{"containerID": 0, "hostID": 100000, "size": 65536},
+                          {"containerID": 100, "hostID": 200000, "size": 10}] |
+    .linux.gidMappings = [{"containerID": 0, "hostID": 100000, "size": 65536}]'
+
+echo "=== A missing executable fails in exec ==="
+expect_phase exec '.process.args = ["/no/such/binary"]'
+
+cp ${BUNDLE}/config.json.orig ${BUNDLE}/config.json
+
+echo "=== Failures are counted per phase in the events log ==="
+COUNTS=$(sudo ./hackontainer events --all --since ${SINCE} --filter "id=${CONTAINER}" |
+    jq -rs 'map(select(.type == "start-failed")) | group_by(.data.phase) |
+        map("\(.[0].data.phase)=\(length)") | join(" ")')
+if [ "${COUNTS}" != "exec=2 mounts=2 usermap=2" ]; then
+    echo "FAIL: expected two start-failed events per phase, got: ${COUNTS}"
+    exit 1
+fi
+echo "PASS: start-failed events per phase: ${COUNTS}"
+
+echo "=== All start phase tests passed ==="
@@ -0,0 +1,95 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mywatch"
+BUNDLE="test-bundles/busybox"
+WATCH_OUT=$(mktemp)
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+WATCHER=""
+cleanup() {
+    [ -n "${WATCHER}" ] && sudo kill ${WATCHER} 2>/dev/null || true
+    sudo ./hackontainer kill ${CONTAINER} SIGKILL >/dev/null 2>&1 || true
+    sleep 1
+    sudo ./hackontainer delete --force ${CONTAINER} >/dev/null 2>&1 || true
+    rm -f ${WATCH_OUT}
+}
+trap cleanup EXIT
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false | .process.args = ["sleep", "2"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+
+echo "=== Watching a container through its lifecycle ==="
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} >/dev/null
+sudo ./hackontainer state --watch ${CONTAINER} > ${WATCH_OUT} &
+WATCHER=$!
+sleep 0.5
+sudo ./hackontainer start ${CONTAINER}
+
+# The process exits by itself, and the delete ends the watch
+for i in $(seq 1 50); do
+    [ "$(sudo ./hackontainer state ${CONTAINER} | jq -r .status)" = "stopped" ] && break
+    sleep 0.2
+done
+sudo ./hackontainer delete ${CONTAINER}
+
+for i in $(seq 1 25); do
+    sudo kill -0 ${WATCHER} 2>/dev/null || break
+    sleep 0.2
+done
+if sudo kill -0 ${WATCHER} 2>/dev/null; then
+    echo "FAIL: state --watch still running after the delete"
+    exit 1
+fi
+wait ${WATCHER}
+WATCHER=""
+echo "PASS: watch ended with the container"
+
+if ! jq -e -s 'all(.[]; .id == "'${CONTAINER}'")' ${WATCH_OUT} >/dev/null; then
+    cat ${WATCH_OUT}
+    echo "FAIL: watch printed something other than state lines"
+    exit 1
+fi
+STATUSES=$(jq -r .status ${WATCH_OUT} | uniq | tr '\n' ' ')
+if [ "${STATUSES}" != "created running stopped " ]; then
+    cat ${WATCH_OUT}
+    echo "FAIL: expected created running stopped, saw ${STATUSES}"
+    exit 1
+fi
+echo "PASS: saw ${STATUSES}"
+
+LINES=$(wc -l < ${WATCH_OUT})
+if [ "$(jq -c . ${WATCH_OUT} | uniq | wc -l)" != "${LINES}" ]; then
+    cat ${WATCH_OUT}
+    echo "FAIL: watch printed the same state twice in a row"
+    exit 1
+fi
+echo "PASS: every line is a change"
+
+echo "=== Watching a container that doesn't exist ==="
+if sudo ./hackontainer state --watch ${CONTAINER} >/dev/null 2>&1; then
+    echo "FAIL: watched a missing container"
+    exit 1
+fi
+echo "PASS: refused"
+
+echo "=== All state --watch tests passed ==="
@@ -0,0 +1,64 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mystatic"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+echo "=== Nothing refers to a separate init binary ==="
+if grep -rn "container-init" --include=*.go .; then
+    echo "FAIL: the runtime refers to an external container-init"
+    exit 1
+fi
+echo "PASS: init is reached by re-executing the runtime"
+
+# The scratch directory stands in for a host with nothing installed: it
+# holds the runtime, a bundle and the kernel filesystems, and no libc
+SCRATCH=$(mktemp -d)
+trap 'sudo rm -rf ${SCRATCH}' EXIT
+mkdir -p ${SCRATCH}/proc ${SCRATCH}/sys ${SCRATCH}/dev ${SCRATCH}/run ${SCRATCH}/bundle
+
+echo "=== Building a static binary ==="
+CGO_ENABLED=0 go build -o ${SCRATCH}/hackontainer ./cmd/hackontainer
+if ! go version -m ${SCRATCH}/hackontainer | grep -q "CGO_ENABLED=0"; then
+    echo "FAIL: binary was built with cgo"
+    exit 1
+fi
+echo "PASS: built without cgo"
+
+cp -a ${BUNDLE}/rootfs ${SCRATCH}/bundle/
+jq '.process.args = ["echo", "hello from scratch"] | .process.terminal = false' \
+    ${BUNDLE}/config.json > ${SCRATCH}/bundle/config.json
+
+echo "=== Running a container from the scratch directory ==="
+# The scratch directory is bind mounted onto itself so the chroot's root
+# is a mount point, which pivot_root needs
+OUTPUT=$(sudo unshare -m --propagation private sh -c "
+    mount --bind ${SCRATCH} ${SCRATCH} &&
+    mount --rbind /sys ${SCRATCH}/sys &&
+    mount --rbind /dev ${SCRATCH}/dev &&
+    mount -t proc proc ${SCRATCH}/proc &&
+    env -i $(command -v chroot) ${SCRATCH} /hackontainer run --bundle /bundle ${CONTAINER}
+" 2>&1 | grep -v "^>>>")
+if [ "${OUTPUT}" != "hello from scratch" ]; then
+    echo "FAIL: container did not run: ${OUTPUT}"
+    exit 1
+fi
+echo "PASS: container ran with nothing but the binary installed"
+
+echo "=== All static binary tests passed ==="
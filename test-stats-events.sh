@@ -0,0 +1,103 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mystatsevents"
+BUNDLE="test-bundles/busybox"
+OUTPUT="/tmp/hackontainer-stats-events-${CONTAINER}.jsonl"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+# A 64 MiB limit and a child, so every number has something to show
+jq '.process.terminal = false
+    | .linux.resources.memory.limit = 67108864
+    | .process.args = ["sh", "-c", "sleep 100 & wait"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+trap 'sudo ./hackontainer kill --all ${CONTAINER} SIGKILL >/dev/null 2>&1 && sleep 1; sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true; rm -f ${OUTPUT}' EXIT
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1: got '$2', want '$3'"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+echo "=== A container that isn't running has no usage to report ==="
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+if OUT=$(sudo ./hackontainer events --stats ${CONTAINER} 2>&1); then
+    echo "FAIL: a stats snapshot of a created container succeeded"
+    exit 1
+fi
+if ! echo "${OUT}" | grep -q "container is created"; then
+    echo "FAIL: expected 'container is created', got: ${OUT}"
+    exit 1
+fi
+echo "PASS: a snapshot of a created container is refused"
+sudo ./hackontainer start ${CONTAINER} >/dev/null 2>&1
+sleep 0.5
+
+echo "=== --stats prints one event in runc's shape ==="
+EVENT=$(sudo ./hackontainer events --stats ${CONTAINER} | grep -v "^>>>")
+echo "${EVENT}"
+check "a single line" "$(echo "${EVENT}" | wc -l)" "1"
+check "the type" "$(echo "${EVENT}" | jq -r .type)" "stats"
+check "the id" "$(echo "${EVENT}" | jq -r .id)" "${CONTAINER}"
+check "the memory limit" "$(echo "${EVENT}" | jq .data.memory.usage.limit)" "67108864"
+check "the pids" "$(echo "${EVENT}" | jq .data.pids.current)" "2"
+check "cpu time is in nanoseconds" "$(echo "${EVENT}" | jq '.data.cpu.usage.total > 0 and .data.cpu.usage.total % 1000 == 0')" "true"
+check "memory usage is below the limit" "$(echo "${EVENT}" | jq '.data.memory.usage.usage > 0 and .data.memory.usage.usage < 67108864')" "true"
+
+echo "=== The stream ends when the container stops ==="
+sudo ./hackontainer events --interval 1 ${CONTAINER} > ${OUTPUT} &
+EVENTS_PID=$!
+sleep 2.5
+sudo ./hackontainer kill --all ${CONTAINER} SIGKILL >/dev/null 2>&1
+for i in $(seq 1 30); do
+    kill -0 ${EVENTS_PID} 2>/dev/null || break
+    sleep 0.1
+done
+if kill -0 ${EVENTS_PID} 2>/dev/null; then
+    echo "FAIL: the stream outlived the container"
+    kill ${EVENTS_PID}
+    exit 1
+fi
+set +e
+wait ${EVENTS_PID}
+RC=$?
+set -e
+check "the stream exits cleanly" "${RC}" "0"
+LINES=$(grep -vc "^>>>" ${OUTPUT})
+if [ "${LINES}" -lt 2 ] || [ "${LINES}" -gt 4 ]; then
+    echo "FAIL: expected an event a second for about 2.5 seconds, got ${LINES}"
+    exit 1
+fi
+echo "PASS: ${LINES} events, one per interval"
+
+echo "=== Bad intervals are refused ==="
+for interval in 0 -1s soon; do
+    if sudo ./hackontainer events --interval=${interval} ${CONTAINER} >/dev/null 2>&1; then
+        echo "FAIL: --interval ${interval} was accepted"
+        exit 1
+    fi
+done
+echo "PASS: bad intervals are refused"
+
+echo "=== All stats events tests passed ==="
@@ -0,0 +1,81 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mystats"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+echo "=== Burning CPU for about two seconds ==="
+jq '.process.terminal = false
+    | .process.args = ["sh", "-c", "end=$(($(date +%s) + 2)); while [ $(date +%s) -lt $end ]; do :; done"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER}
+
+echo "=== Final stats before the container ran (expect a failure) ==="
+if sudo ./hackontainer stats --final ${CONTAINER}; then
+    echo "FAIL: final stats reported before the container exited"
+    sudo ./hackontainer delete ${CONTAINER}
+    exit 1
+fi
+
+sudo ./hackontainer start ${CONTAINER}
+
+echo "=== Live stats while running ==="
+sudo ./hackontainer stats ${CONTAINER}
+
+for i in $(seq 1 10); do
+    if sudo ./hackontainer state ${CONTAINER} | grep -q '"status": "stopped"'; then
+        break
+    fi
+    sleep 1
+done
+
+echo "=== Final stats after exit ==="
+STATS=$(sudo ./hackontainer stats --final ${CONTAINER})
+echo "${STATS}"
+
+# Two seconds of spinning is well over half a second of CPU, and no more
+# than the wall clock allows on a handful of CPUs
+CPU=$(echo "${STATS}" | jq '.cpuUsageUsec // 0')
+if [ "${CPU}" -lt 500000 ] || [ "${CPU}" -gt 20000000 ]; then
+    echo "FAIL: implausible cpuUsageUsec ${CPU}"
+    sudo ./hackontainer delete ${CONTAINER}
+    exit 1
+fi
+if [ "$(echo "${STATS}" | jq '.memoryPeakBytes // 0')" -le 0 ]; then
+    echo "FAIL: no memory peak recorded"
+    sudo ./hackontainer delete ${CONTAINER}
+    exit 1
+fi
+echo "PASS: final stats are plausible"
+
+echo "=== Final stats in inspect ==="
+if [ "$(sudo ./hackontainer inspect ${CONTAINER} | jq '.finalStats.cpuUsageUsec')" != "${CPU}" ]; then
+    echo "FAIL: inspect does not show the final stats"
+    sudo ./hackontainer delete ${CONTAINER}
+    exit 1
+fi
+echo "PASS: inspect shows the final stats"
+
+echo "=== Cleaning up ==="
+sudo ./hackontainer delete ${CONTAINER}
@@ -0,0 +1,132 @@
+#!/bin/bash
+set -e
+
+BUNDLE="test-bundles/busybox"
+ROOT="/run/hackontainer-status"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf ${ROOT}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false | .process.args = ["sleep", "100"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+
+# A bundle of its own, sharing the rootfs, for the container whose
+# bundle goes away
+ROOTFS=$(cd ${BUNDLE}/rootfs && pwd)
+SHORT_BUNDLE=$(mktemp -d)
+jq --arg rootfs "${ROOTFS}" '.root.path = $rootfs' ${BUNDLE}/config.json > ${SHORT_BUNDLE}/config.json
+
+# A delegate runtime whose state hangs once told to
+FAKE_DIR=$(mktemp -d)
+FAKE=${FAKE_DIR}/fake-runtime
+cat > ${FAKE} <<FAKE
+#!/bin/bash
+[ "\$3" = state ] || exit 0
+[ -f ${FAKE_DIR}/hang ] && sleep 5
+printf '{"ociVersion":"1.0.2","id":"%s","status":"created","pid":0,"bundle":"%s","created":"2024-01-02T03:04:05Z"}\n' "\$4" "${FAKE_DIR}"
+FAKE
+chmod +x ${FAKE}
+
+hk() {
+    sudo ./hackontainer --root ${ROOT} "$@"
+}
+
+cleanup() {
+    for id in st-running st-stopped; do
+        hk kill ${id} SIGKILL >/dev/null 2>&1 || true
+    done
+    sleep 1
+    for id in st-running st-created st-stopped; do
+        hk delete --force ${id} >/dev/null 2>&1 || true
+    done
+    sudo rm -rf ${ROOT} ${FAKE_DIR} ${SHORT_BUNDLE}
+}
+trap cleanup EXIT
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1: got '$2', want '$3'"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+echo "=== An empty root is healthy ==="
+check "no containers" "$(hk status | grep -v "^>>>" | jq -c '[.total, .anomalies]')" "[0,[]]"
+
+echo "=== Healthy containers are counted by status ==="
+hk create --bundle ${BUNDLE} st-running >/dev/null 2>&1
+hk start st-running >/dev/null 2>&1
+hk create --bundle ${SHORT_BUNDLE} st-created >/dev/null 2>&1
+hk create --bundle ${BUNDLE} st-stopped >/dev/null 2>&1
+hk start st-stopped >/dev/null 2>&1
+hk kill st-stopped SIGKILL >/dev/null 2>&1
+for i in $(seq 1 50); do
+    [ "$(hk state st-stopped | grep -v "^>>>" | jq -r '.exitStatus // empty')" != "" ] && break
+    sleep 0.1
+done
+
+set +e
+SUMMARY=$(hk status)
+RC=$?
+set -e
+SUMMARY=$(echo "${SUMMARY}" | grep -v "^>>>")
+echo "${SUMMARY}"
+check "healthy exits 0" "${RC}" "0"
+check "the counts" "$(echo "${SUMMARY}" | jq -c '[.total, .created, .running, .paused, .stopped, .unknown]')" "[3,1,1,0,1,0]"
+check "no anomalies" "$(echo "${SUMMARY}" | jq -c .anomalies)" "[]"
+
+echo "=== Broken containers are reported ==="
+# Recorded as running with a pid that has exited and no monitor
+DEAD_PID=$(sh -c 'echo $$')
+STATE=${ROOT}/st-stopped/state.json
+sudo sh -c "jq --argjson pid ${DEAD_PID} '.status = \"running\" | .pid = \$pid | del(.exitStatus, .monitorPid)' ${STATE} > ${STATE}.tmp && mv ${STATE}.tmp ${STATE}"
+# The bundle st-created was created from is removed
+rm -rf ${SHORT_BUNDLE}
+# A state file cut short
+sudo mkdir -p ${ROOT}/st-corrupt
+echo '{"id": "st-corrupt", "sta' | sudo tee ${ROOT}/st-corrupt/state.json >/dev/null
+sudo sh -c "echo '{\"version\": 1}' > ${ROOT}/st-corrupt/layout"
+# A create that died before writing state
+sudo mkdir -p ${ROOT}/st-half
+sudo sh -c "echo '{\"version\": 1}' > ${ROOT}/st-half/layout"
+# A delegate that stops answering
+hk create --bundle ${BUNDLE} --delegate-runtime ${FAKE} st-hang >/dev/null 2>&1
+touch ${FAKE_DIR}/hang
+
+START=$(date +%s)
+set +e
+SUMMARY=$(hk status)
+RC=$?
+set -e
+SUMMARY=$(echo "${SUMMARY}" | grep -v "^>>>")
+ELAPSED=$(($(date +%s) - START))
+echo "${SUMMARY}"
+check "anomalies exit 1" "${RC}" "1"
+check "the counts" "$(echo "${SUMMARY}" | jq -c '[.total, .created, .running, .paused, .stopped, .unknown]')" "[6,1,1,0,1,3]"
+check "the anomalies" "$(echo "${SUMMARY}" | jq -c '[.anomalies[] | [.id, .kind]]')" \
+    '[["st-corrupt","corrupt-state"],["st-created","missing-bundle"],["st-half","incomplete"],["st-hang","timeout"],["st-stopped","dead-pid"]]'
+if [ "${ELAPSED}" -ge 5 ]; then
+    echo "FAIL: the scan waited ${ELAPSED}s for the hanging delegate"
+    exit 1
+fi
+echo "PASS: the scan gave up on the hanging delegate after ${ELAPSED}s"
+
+echo "=== All status tests passed ==="
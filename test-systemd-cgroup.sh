@@ -0,0 +1,136 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mysystemd"
+BUNDLE="test-bundles/busybox"
+UNIT="hackontainer-${CONTAINER}.scope"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+cleanup() {
+    sudo ./hackontainer kill ${CONTAINER} SIGKILL >/dev/null 2>&1 || true
+    sleep 1
+    sudo ./hackontainer delete --force ${CONTAINER} >/dev/null 2>&1 || true
+}
+trap cleanup EXIT
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false | .process.args = ["sleep", "30"]
+    | .linux.resources = {"memory": {"limit": 67108864}, "pids": {"limit": 64}}' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.orig
+
+# use_cgroups_path <path> sets linux.cgroupsPath, or leaves it out
+use_cgroups_path() {
+    jq --arg path "$1" 'if $path == "" then . else .linux.cgroupsPath = $path end' \
+        ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+}
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1: expected '$3', got '$2'"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+# refused <what> <error> <args> checks create fails with error
+refused() {
+    local what=$1 error=$2
+    shift 2
+    ERR=$(sudo ./hackontainer "$@" create --bundle ${BUNDLE} ${CONTAINER} 2>&1 || true)
+    if ! echo "${ERR}" | grep -q "${error}"; then
+        echo "${ERR}"
+        echo "FAIL: ${what}"
+        exit 1
+    fi
+    if sudo ./hackontainer state ${CONTAINER} >/dev/null 2>&1; then
+        echo "FAIL: ${what}: a container was created anyway"
+        exit 1
+    fi
+    echo "PASS: ${what}"
+}
+
+echo "=== The scope is worked out at create ==="
+use_cgroups_path ""
+sudo ./hackontainer --systemd-cgroup create --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+check "the default scope is in system.slice" \
+    "$(sudo ./hackontainer state ${CONTAINER} | jq -r .cgroupPath)" "/system.slice/${UNIT}"
+check "the driver is recorded" \
+    "$(sudo ./hackontainer inspect ${CONTAINER} | jq -r .createOptions.systemdCgroup)" "true"
+sudo ./hackontainer delete ${CONTAINER}
+
+use_cgroups_path "machine-test.slice:hk-test:${CONTAINER}"
+sudo ./hackontainer --systemd-cgroup create --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+check "slice:prefix:name is honoured" \
+    "$(sudo ./hackontainer state ${CONTAINER} | jq -r .cgroupPath)" "/machine.slice/machine-test.slice/hk-test-${CONTAINER}.scope"
+sudo ./hackontainer delete ${CONTAINER}
+
+use_cgroups_path ":hk-test:${CONTAINER}"
+sudo ./hackontainer --systemd-cgroup create --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+check "an empty slice is system.slice" \
+    "$(sudo ./hackontainer state ${CONTAINER} | jq -r .cgroupPath)" "/system.slice/hk-test-${CONTAINER}.scope"
+sudo ./hackontainer delete ${CONTAINER}
+
+echo "=== Configs the driver can't honour are refused ==="
+use_cgroups_path "/hackontainer/${CONTAINER}"
+refused "a plain cgroup path" "must be slice:prefix:name" --systemd-cgroup
+use_cgroups_path "system.slice:hk:test.slice"
+refused "a slice for the container" "names a slice" --systemd-cgroup
+use_cgroups_path "bad:hk:${CONTAINER}"
+refused "an invalid slice" 'invalid slice name "bad"' --systemd-cgroup
+use_cgroups_path ""
+refused "--cgroup-parent" "give a slice in linux.cgroupsPath" --systemd-cgroup --cgroup-parent /tenant
+refused "--cgroups" "can't be combined with the systemd cgroup driver" --systemd-cgroup --cgroups root
+refused "rootless" "needs root" --systemd-cgroup --rootless true
+
+if [ ! -d /run/systemd/system ]; then
+    echo "SKIP: the host doesn't run systemd"
+    exit 0
+fi
+
+echo "=== systemd runs the container in its scope ==="
+sudo ./hackontainer --systemd-cgroup create --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+sudo ./hackontainer start ${CONTAINER} >/dev/null 2>&1
+PID=$(sudo ./hackontainer state ${CONTAINER} | jq -r .pid)
+check "the unit is active" "$(systemctl is-active ${UNIT})" "active"
+check "the process is in the scope" "$(grep -c "/system.slice/${UNIT}$" /proc/${PID}/cgroup)" "1"
+check "the unit has the memory limit" "$(systemctl show -p MemoryMax --value ${UNIT})" "67108864"
+check "the unit has the pids limit" "$(systemctl show -p TasksMax --value ${UNIT})" "64"
+if [ -f /sys/fs/cgroup/cgroup.controllers ]; then
+    check "the cgroup has the memory limit" "$(cat /sys/fs/cgroup/system.slice/${UNIT}/memory.max)" "67108864"
+fi
+
+echo "=== Later commands find the unit without the flag ==="
+sudo ./hackontainer update --memory 128m ${CONTAINER}
+check "update changes the unit" "$(systemctl show -p MemoryMax --value ${UNIT})" "134217728"
+sudo ./hackontainer kill ${CONTAINER} KILL
+sleep 1
+sudo ./hackontainer delete ${CONTAINER}
+if systemctl is-active -q ${UNIT}; then
+    echo "FAIL: the unit outlived the container"
+    exit 1
+fi
+echo "PASS: the unit is gone"
+if [ -d /sys/fs/cgroup/system.slice/${UNIT} ]; then
+    echo "FAIL: the scope's cgroup is left"
+    exit 1
+fi
+echo "PASS: the cgroup is gone"
+
+echo "=== All systemd cgroup tests passed ==="
@@ -0,0 +1,120 @@
+#!/bin/bash
+set -e
+
+CONTAINER="mytimeout"
+BUNDLE="test-bundles/busybox"
+FUSESTUB="test-bundles/fusestub"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false | .process.args = ["true"]' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.orig
+
+echo "=== Mounting a FUSE filesystem that never answers ==="
+go build -o ${FUSESTUB} ./test/fusestub
+STUCK=$(mktemp -d)
+sudo ${FUSESTUB} ${STUCK} &
+STUB=$!
+trap 'sudo kill ${STUB}; wait ${STUB} || true; rmdir ${STUCK}' EXIT
+sleep 1
+
+# Bind-mounting from below it blocks the init stage in mounts
+jq --arg src "${STUCK}/data" \
+    '.mounts += [{"destination": "/stuck", "type": "bind", "source": $src, "options": ["bind"]}]' \
+    ${BUNDLE}/config.json.orig > ${BUNDLE}/config.json
+
+# cgroup_dirs lists the container's cgroup directories on either hierarchy
+cgroup_dirs() {
+    ls -d /sys/fs/cgroup/hackontainer/${CONTAINER} /sys/fs/cgroup/*/hackontainer/${CONTAINER} 2>/dev/null || true
+}
+
+# expect_timeout <op> <rc> <out> checks a timed-out operation's exit code
+# and that its message names the stuck phase
+expect_timeout() {
+    if [ "$2" -ne 124 ]; then
+        echo "FAIL: $1: expected exit code 124, got $2: $3"
+        exit 1
+    fi
+    if ! echo "$3" | grep -q "start failed during mounts: context deadline exceeded"; then
+        echo "FAIL: $1: expected the mounts phase to be named, got: $3"
+        exit 1
+    fi
+    echo "PASS: $1 exits 124 naming the mounts phase"
+}
+
+echo "=== run --timeout gives up and removes the container ==="
+set +e
+out=$(sudo ./hackontainer run --timeout 3s --bundle ${BUNDLE} ${CONTAINER} 2>&1 | grep -v "^>>>"; exit ${PIPESTATUS[0]})
+rc=$?
+set -e
+expect_timeout run ${rc} "${out}"
+if sudo ./hackontainer state ${CONTAINER} >/dev/null 2>&1; then
+    echo "FAIL: run left the container behind"
+    exit 1
+fi
+if [ -n "$(cgroup_dirs)" ]; then
+    echo "FAIL: run left the cgroup behind: $(cgroup_dirs)"
+    exit 1
+fi
+echo "PASS: run removed the container and its cgroup"
+
+echo "=== start --timeout kills the child and leaves the container created ==="
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} >/dev/null
+set +e
+sudo ./hackontainer start --timeout 3s ${CONTAINER} >/tmp/${CONTAINER}.out 2>&1 &
+START=$!
+sleep 1
+# The cgroup is there while the start is stuck, so its absence later
+# means the teardown removed it
+if [ -z "$(cgroup_dirs)" ]; then
+    echo "FAIL: no cgroup while the start is stuck"
+    exit 1
+fi
+wait ${START}
+rc=$?
+set -e
+out=$(grep -v "^>>>" /tmp/${CONTAINER}.out)
+rm -f /tmp/${CONTAINER}.out
+expect_timeout start ${rc} "${out}"
+STATUS=$(sudo ./hackontainer state ${CONTAINER} | jq -r .status)
+if [ "${STATUS}" != "created" ]; then
+    echo "FAIL: expected the container to stay created, got ${STATUS}"
+    exit 1
+fi
+if [ -n "$(cgroup_dirs)" ]; then
+    echo "FAIL: start left the cgroup behind: $(cgroup_dirs)"
+    exit 1
+fi
+echo "PASS: start killed the child and removed its cgroup"
+sudo ./hackontainer delete ${CONTAINER}
+
+echo "=== A start within the timeout is unaffected ==="
+cp ${BUNDLE}/config.json.orig ${BUNDLE}/config.json
+sudo ./hackontainer run --timeout 30s --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+sudo ./hackontainer run --timeout 0 --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+echo "PASS: run completes under --timeout 30s and --timeout 0"
+
+if sudo ./hackontainer run --timeout soon --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1; then
+    echo "FAIL: an invalid --timeout was accepted"
+    exit 1
+fi
+echo "PASS: an invalid --timeout is rejected"
+
+echo "=== All timeout tests passed ==="
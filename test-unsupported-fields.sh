@@ -0,0 +1,7 @@
+#!/bin/bash
+set -e
+
+echo "=== Validating the fixture specs with unsupported fields ==="
+go run ./test/unsupported test/unsupported-fixtures
+
+echo "=== All unsupported field tests passed ==="
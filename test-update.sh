@@ -0,0 +1,128 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myupdate"
+BUNDLE="test-bundles/busybox"
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+jq '.process.terminal = false
+    | .process.args = ["sleep", "100"]
+    | .linux.resources.memory.limit = 67108864
+    | .linux.resources.pids.limit = 100' \
+    ${BUNDLE}/config.json > ${BUNDLE}/config.json.tmp
+mv ${BUNDLE}/config.json.tmp ${BUNDLE}/config.json
+trap 'sudo ./hackontainer kill ${CONTAINER} SIGKILL >/dev/null 2>&1 && sleep 1; sudo ./hackontainer delete ${CONTAINER} >/dev/null 2>&1 || true' EXIT
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1: got '$2', want '$3'"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+# refused <what> <want> <command...> runs a command that must fail and
+# name why
+refused() {
+    local desc=$1 want=$2
+    shift 2
+    local out
+    if out=$(sudo "$@" 2>&1); then
+        echo "FAIL: $desc succeeded"
+        exit 1
+    fi
+    if ! echo "$out" | grep -q -- "$want"; then
+        echo "FAIL: $desc: expected '$want' in: $out"
+        exit 1
+    fi
+    echo "PASS: $desc is refused"
+}
+
+# cgroup_file <v1 controller> <v1 file> <v2 file> prints a file of the
+# container's cgroup
+cgroup_file() {
+    local path
+    path=$(sudo ./hackontainer state ${CONTAINER} | grep -v "^>>>" | jq -r .cgroupPath)
+    if [ -f /sys/fs/cgroup/cgroup.controllers ]; then
+        cat /sys/fs/cgroup/${path}/$3
+    else
+        cat /sys/fs/cgroup/$1/${path}/$2
+    fi
+}
+
+memory_limit() {
+    sudo ./hackontainer events --stats ${CONTAINER} | grep -v "^>>>" | jq .data.memory.usage.limit
+}
+
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+sudo ./hackontainer start ${CONTAINER} >/dev/null 2>&1
+
+echo "=== Flags change the limits of a running container ==="
+check "the limit it was created with" "$(memory_limit)" "67108864"
+sudo ./hackontainer update --memory 128m --pids-limit 50 ${CONTAINER}
+check "the new memory limit" "$(memory_limit)" "134217728"
+check "the new pids limit" "$(cgroup_file pids pids.max pids.max)" "50"
+INSPECT=$(sudo ./hackontainer inspect ${CONTAINER} | grep -v "^>>>")
+check "inspect shows the new limits" "$(echo "${INSPECT}" | jq -c '[.resources.memory.limit, .resources.pids.limit]')" "[134217728,50]"
+check "the update is an event" \
+    "$(sudo ./hackontainer events --all --filter id=${CONTAINER} | grep -v "^>>>" | jq -c 'select(.type == "update") | .data' | tail -1)" \
+    '{"memory.limit":"134217728","pids.limit":"50"}'
+
+echo "=== Invalid updates are refused before anything is written ==="
+refused "a quota without a period" "needs a cpu period" \
+    ./hackontainer update --memory 256m --cpu-quota 50000 ${CONTAINER}
+refused "a reservation above the limit" "cannot exceed the memory limit" \
+    ./hackontainer update --resources <(echo '{"memory": {"limit": 268435456, "reservation": 536870912}}') ${CONTAINER}
+refused "a period out of range" "cpu period must be between" \
+    ./hackontainer update --cpu-period 10 ${CONTAINER}
+refused "device rules" "can be updated" \
+    ./hackontainer update --resources <(echo '{"devices": [{"allow": true, "access": "rwm"}]}') ${CONTAINER}
+refused "an update without limits" "nothing to update" ./hackontainer update ${CONTAINER}
+check "the memory limit is untouched" "$(memory_limit)" "134217728"
+check "inspect still shows the old limit" "$(sudo ./hackontainer inspect ${CONTAINER} | grep -v "^>>>" | jq .resources.memory.limit)" "134217728"
+
+echo "=== A quota with a period is applied ==="
+sudo ./hackontainer update --cpu-quota 50000 --cpu-period 100000 --cpu-shares 512 ${CONTAINER}
+if [ -f /sys/fs/cgroup/cgroup.controllers ]; then
+    check "cpu.max" "$(cgroup_file cpu none cpu.max)" "50000 100000"
+else
+    check "the quota" "$(cgroup_file cpu cpu.cfs_quota_us none)" "50000"
+    check "the period" "$(cgroup_file cpu cpu.cfs_period_us none)" "100000"
+    check "the shares" "$(cgroup_file cpu cpu.shares none)" "512"
+fi
+echo "=== A later quota keeps the period ==="
+sudo ./hackontainer update --cpu-quota 20000 ${CONTAINER}
+check "inspect shows quota and period" "$(sudo ./hackontainer inspect ${CONTAINER} | grep -v "^>>>" | jq -c '[.resources.cpu.quota, .resources.cpu.period]')" "[20000,100000]"
+
+echo "=== --resources - reads stdin ==="
+echo '{"pids": {"limit": 30}}' | sudo ./hackontainer update --resources - ${CONTAINER}
+check "the pids limit from stdin" "$(cgroup_file pids pids.max pids.max)" "30"
+check "the flags' earlier limits stay" "$(memory_limit)" "134217728"
+
+echo "=== A stopped container can't be updated ==="
+sudo ./hackontainer kill ${CONTAINER} SIGKILL >/dev/null 2>&1
+for i in $(seq 1 50); do
+    [ "$(sudo ./hackontainer state ${CONTAINER} | grep -v "^>>>" | jq -r .status)" = "stopped" ] && break
+    sleep 0.1
+done
+refused "updating a stopped container" "stopped" ./hackontainer update --memory 64m ${CONTAINER}
+
+echo "=== All update tests passed ==="
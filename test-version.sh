@@ -0,0 +1,80 @@
+#!/bin/bash
+set -e
+
+CONTAINER="myversion"
+BUNDLE="test-bundles/busybox"
+STAMPED=$(mktemp -d)
+
+echo "=== Cleaning up previous bundle ==="
+rm -rf ${BUNDLE}
+
+echo "=== Creating fresh bundle directory ==="
+mkdir -p ${BUNDLE}/rootfs
+
+echo "=== Cleaning up previous container state ==="
+sudo rm -rf /run/hackontainer/${CONTAINER}
+
+trap 'sudo ./hackontainer delete --force ${CONTAINER} >/dev/null 2>&1 || true; rm -rf ${STAMPED} || true' EXIT
+
+echo "=== Creating fresh Busybox rootfs using docker ==="
+docker create --name busybox-container busybox:latest >/dev/null 2>&1
+docker export busybox-container | tar -xf - -C ${BUNDLE}/rootfs
+docker rm busybox-container >/dev/null 2>&1
+
+echo "=== Generating OCI config.json using runc spec ==="
+cd ${BUNDLE}
+runc spec
+cd -
+
+check() {
+    if [ "$2" != "$3" ]; then
+        echo "FAIL: $1: expected '$3', got '$2'"
+        exit 1
+    fi
+    echo "PASS: $1"
+}
+
+echo "=== --version prints the versions ==="
+TEXT=$(./hackontainer --version)
+echo "${TEXT}"
+JSON=$(./hackontainer --version --format json)
+VERSION=$(echo "${JSON}" | jq -r .version)
+SPEC=$(echo "${JSON}" | jq -r .ociVersion)
+check "the first line names the runtime version" "$(echo "${TEXT}" | head -1)" "hackontainer version ${VERSION}"
+check "the spec version is printed" "$(echo "${TEXT}" | grep '^spec: ')" "spec: ${SPEC}"
+check "the go version is printed" "$(echo "${TEXT}" | grep '^go: ')" "go: $(go env GOVERSION)"
+check "-v is --version" "$(./hackontainer -v)" "${TEXT}"
+check "global flags may come first" "$(./hackontainer --root /nonexistent --version)" "${TEXT}"
+if ./hackontainer --version --format yaml >/dev/null 2>&1; then
+    echo "FAIL: an unknown format was accepted"
+    exit 1
+fi
+echo "PASS: an unknown format is refused"
+if ! ./hackontainer --help | grep -q "^Usage: "; then
+    echo "FAIL: --help doesn't print the usage"
+    exit 1
+fi
+echo "PASS: --help prints the usage"
+
+echo "=== A build from a checkout names its commit ==="
+check "the commit is the checkout's" \
+    "$(echo "${JSON}" | jq -r '.commit | sub("-dirty$"; "")')" "$(git rev-parse HEAD)"
+
+echo "=== The build can be stamped at link time ==="
+PKG=github.com/zakarynichols/hackontainer/version
+CGO_ENABLED=0 go build -buildvcs=false \
+    -ldflags "-X ${PKG}.Commit=0123abc -X ${PKG}.BuildDate=2024-01-02T03:04:05Z" \
+    -o ${STAMPED}/hackontainer ./cmd/hackontainer
+STAMP=$(${STAMPED}/hackontainer --version --format json)
+check "the stamped commit" "$(echo "${STAMP}" | jq -r .commit)" "0123abc"
+check "the stamped date" "$(echo "${STAMP}" | jq -r .buildDate)" "2024-01-02T03:04:05Z"
+CGO_ENABLED=0 go build -buildvcs=false -o ${STAMPED}/hackontainer ./cmd/hackontainer
+check "an unstamped build has no commit" \
+    "$(${STAMPED}/hackontainer --version --format json | jq -c '[.commit, .buildDate]')" "[null,null]"
+
+echo "=== State reports the same spec version ==="
+sudo ./hackontainer create --bundle ${BUNDLE} ${CONTAINER} >/dev/null 2>&1
+check "state's ociVersion" "$(sudo ./hackontainer state ${CONTAINER} | jq -r .ociVersion)" "${SPEC}"
+check "features' ociVersionMax" "$(./hackontainer features | jq -r .ociVersionMax)" "${SPEC}"
+
+echo "=== All version tests passed ==="
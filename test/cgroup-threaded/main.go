@@ -0,0 +1,199 @@
+// Command cgroup-threaded checks how libcontainer.PlanCgroupJoin treats
+// each cgroup v2 cgroup.type on the way to a container's cgroup. Every
+// case builds a fake cgroup hierarchy in a temporary directory, a tree
+// of directories with the cgroup.type files the kernel would show, so
+// it runs on any host:
+//
+//	go run ./test/cgroup-threaded
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/zakarynichols/hackontainer/libcontainer"
+)
+
+const threadedAnnotation = "org.hackontainer.cgroup-threaded"
+
+// testCase plans the join of the cgroup at path in a hierarchy of the
+// cgroups in tree, each mapped to its cgroup.type. The root has none.
+type testCase struct {
+	name string
+	tree map[string]string
+	path string
+	// threaded sets the annotation opting into cgroup.threads
+	threaded  bool
+	resources *specs.LinuxResources
+	// want is the join planned, or wantErr a part of the error
+	want    libcontainer.CgroupJoin
+	wantErr string
+}
+
+func int64Ptr(v int64) *int64 { return &v }
+
+var cpuOnly = &specs.LinuxResources{CPU: &specs.LinuxCPU{Quota: int64Ptr(50000), Cpus: "0"}}
+var withMemory = &specs.LinuxResources{
+	CPU:    &specs.LinuxCPU{Quota: int64Ptr(50000)},
+	Memory: &specs.LinuxMemory{Limit: int64Ptr(64 << 20)},
+}
+
+// threadedTree has a threaded domain /app with a threaded child /app/workers.
+var threadedTree = map[string]string{
+	"/app":         "domain threaded",
+	"/app/workers": "threaded",
+}
+
+var cases = []testCase{
+	{
+		name: "new cgroup below the root",
+		tree: map[string]string{},
+		path: "/hackontainer/c1",
+		want: libcontainer.CgroupJoinProcs,
+	},
+	{
+		name: "new cgroup below a domain",
+		tree: map[string]string{"/hackontainer": "domain"},
+		path: "/hackontainer/c1",
+		want: libcontainer.CgroupJoinProcs,
+	},
+	{
+		name: "existing domain cgroup",
+		tree: map[string]string{"/hackontainer": "domain", "/hackontainer/c1": "domain"},
+		path: "/hackontainer/c1",
+		want: libcontainer.CgroupJoinProcs,
+	},
+	{
+		name: "new cgroup below a threaded domain",
+		tree: threadedTree,
+		path: "/app/c1",
+		want: libcontainer.CgroupJoinProcs,
+	},
+	{
+		name:      "existing threaded domain",
+		tree:      threadedTree,
+		path:      "/app",
+		resources: withMemory,
+		want:      libcontainer.CgroupJoinProcs,
+	},
+	{
+		name:      "new cgroup below a threaded one, not opted in",
+		tree:      threadedTree,
+		path:      "/app/workers/c1",
+		resources: cpuOnly,
+		wantErr:   "set " + threadedAnnotation + "=true to join it through cgroup.threads",
+	},
+	{
+		name:      "new cgroup below a threaded one, opted in",
+		tree:      threadedTree,
+		path:      "/app/workers/c1",
+		threaded:  true,
+		resources: cpuOnly,
+		want:      libcontainer.CgroupJoinThreads,
+	},
+	{
+		name:      "new cgroup below a threaded one, with domain limits",
+		tree:      threadedTree,
+		path:      "/app/workers/c1",
+		threaded:  true,
+		resources: withMemory,
+		wantErr:   "sets memory limits, which need a domain cgroup",
+	},
+	{
+		name:    "existing threaded cgroup, not opted in",
+		tree:    threadedTree,
+		path:    "/app/workers",
+		wantErr: "cgroup /app/workers is in a threaded subtree (/app/workers is \"threaded\")",
+	},
+	{
+		name:     "existing threaded cgroup, opted in",
+		tree:     threadedTree,
+		path:     "/app/workers",
+		threaded: true,
+		want:     libcontainer.CgroupJoinThreads,
+	},
+	{
+		name:     "existing invalid domain, opted in",
+		tree:     map[string]string{"/app": "domain threaded", "/app/workers": "threaded", "/app/c1": "domain invalid"},
+		path:     "/app/c1",
+		threaded: true,
+		want:     libcontainer.CgroupJoinThreads,
+	},
+	{
+		name:     "new cgroup below an invalid domain",
+		tree:     map[string]string{"/app": "domain threaded", "/app/workers": "threaded", "/app/stale": "domain invalid"},
+		path:     "/app/stale/c1",
+		threaded: true,
+		wantErr:  "no cgroup below it can hold processes",
+	},
+	{
+		name:     "threaded cgroup without a threaded domain",
+		tree:     map[string]string{"/broken": "threaded"},
+		path:     "/broken/c1",
+		threaded: true,
+		wantErr:  "no ancestor is its threaded domain",
+	},
+	{
+		name: "unified limits of a domain controller",
+		tree: threadedTree,
+		path: "/app/workers/c1",
+		resources: &specs.LinuxResources{Unified: map[string]string{
+			"cpu.weight": "100",
+			"io.max":     "8:0 rbps=1048576",
+		}},
+		threaded: true,
+		wantErr:  "sets io limits",
+	},
+}
+
+func main() {
+	failed := false
+	for _, tc := range cases {
+		if err := run(tc); err != nil {
+			fmt.Printf("FAIL: %s: %v\n", tc.name, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("PASS: %s\n", tc.name)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// run builds the case's hierarchy and checks the join planned in it.
+func run(tc testCase) error {
+	root, err := os.MkdirTemp("", "cgroup-threaded-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(root)
+	for dir, cgType := range tc.tree {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(root, dir, "cgroup.type"), []byte(cgType+"\n"), 0644); err != nil {
+			return err
+		}
+	}
+
+	spec := &specs.Spec{Linux: &specs.Linux{Resources: tc.resources}}
+	if tc.threaded {
+		spec.Annotations = map[string]string{threadedAnnotation: "true"}
+	}
+	join, err := libcontainer.PlanCgroupJoin(root, tc.path, spec)
+	switch {
+	case tc.wantErr != "" && err == nil:
+		return fmt.Errorf("planned %s, want an error containing %q", join, tc.wantErr)
+	case tc.wantErr != "" && !strings.Contains(err.Error(), tc.wantErr):
+		return fmt.Errorf("error %q doesn't contain %q", err, tc.wantErr)
+	case tc.wantErr == "" && err != nil:
+		return fmt.Errorf("want %s, got error: %v", tc.want, err)
+	case join != tc.want:
+		return fmt.Errorf("planned %s, want %s", join, tc.want)
+	}
+	return nil
+}
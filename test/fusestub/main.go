@@ -0,0 +1,45 @@
+// Command fusestub mounts a FUSE filesystem that never answers, so any
+// access below the mountpoint blocks until the caller is killed. Tests
+// use it to get a step of a start stuck in the kernel.
+//
+// It must run as root and stays in the foreground until SIGTERM or
+// SIGINT, when it detaches the mount and exits:
+//
+//	sudo go run ./test/fusestub /mnt/stuck &
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+
+	"golang.org/x/sys/unix"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: fusestub <mountpoint>")
+		os.Exit(2)
+	}
+
+	fd, err := unix.Open("/dev/fuse", unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fusestub: %v\n", err)
+		os.Exit(1)
+	}
+	data := fmt.Sprintf("fd=%d,rootmode=40000,user_id=0,group_id=0", fd)
+	if err := unix.Mount("fusestub", os.Args[1], "fuse", 0, data); err != nil {
+		fmt.Fprintf(os.Stderr, "fusestub: mount %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+
+	// The kernel's first request is FUSE_INIT; leaving it unread keeps
+	// every request after it waiting
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, unix.SIGTERM, unix.SIGINT)
+	<-signals
+	if err := unix.Unmount(os.Args[1], unix.MNT_DETACH); err != nil {
+		fmt.Fprintf(os.Stderr, "fusestub: unmount %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,113 @@
+// Command nsdo looks inside a running container's namespaces through
+// libcontainer, the way a log collector or health checker embedding it
+// would. It prints what it finds, one line per check:
+//
+//	net-helper <interfaces>   cat of /proc/net/dev run by RunInNamespaces
+//	net-inproc <interfaces>   net.Interfaces called under NetNSDo
+//	mnt-helper <stat output>  nsdo itself run by RunInNamespaces, stat'ing
+//	                          path in the container's mount namespace
+//
+// It must run as root, and be built static so it can run in the
+// container's mount namespace:
+//
+//	CGO_ENABLED=0 go build -o nsdo ./test/nsdo
+//	sudo ./nsdo /run/hackontainer <id> <path>
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/zakarynichols/hackontainer/libcontainer"
+)
+
+func main() {
+	// RunInNamespaces re-executes this binary
+	if libcontainer.IsNamespaceHelper(os.Args) {
+		err := libcontainer.RunNamespaceHelper(os.Args)
+		fmt.Fprintf(os.Stderr, "nsdo: %v\n", err)
+		os.Exit(1)
+	}
+	// What RunInNamespaces runs for the mount check
+	if len(os.Args) == 3 && os.Args[1] == "stat" {
+		info, err := os.Stat(os.Args[2])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "nsdo: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s %d\n", info.Name(), info.Size())
+		return
+	}
+
+	if len(os.Args) != 4 {
+		fmt.Fprintln(os.Stderr, "usage: nsdo <root> <id> <path>")
+		os.Exit(2)
+	}
+	if err := run(os.Args[1], os.Args[2], os.Args[3]); err != nil {
+		fmt.Fprintf(os.Stderr, "nsdo: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(root, id, path string) error {
+	factory, err := libcontainer.New(root)
+	if err != nil {
+		return err
+	}
+	container, err := factory.Load(id)
+	if err != nil {
+		return err
+	}
+
+	// /proc/net/dev shows the network namespace of whoever reads it
+	var out bytes.Buffer
+	cat := exec.Command("cat", "/proc/net/dev")
+	cat.Stdout = &out
+	if err := container.RunInNamespaces([]libcontainer.NamespaceType{specs.NetworkNamespace}, cat); err != nil {
+		return fmt.Errorf("net-helper: %w", err)
+	}
+	var names []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if name, _, ok := strings.Cut(line, ":"); ok {
+			names = append(names, strings.TrimSpace(name))
+		}
+	}
+	sort.Strings(names)
+	fmt.Printf("net-helper %s\n", strings.Join(names, ","))
+
+	names = nil
+	err = container.NetNSDo(func() error {
+		interfaces, err := net.Interfaces()
+		for _, iface := range interfaces {
+			names = append(names, iface.Name)
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("net-inproc: %w", err)
+	}
+	sort.Strings(names)
+	fmt.Printf("net-inproc %s\n", strings.Join(names, ","))
+
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	stat := exec.Command(self, "stat", path)
+	out.Reset()
+	stat.Stdout = &out
+	if err := container.RunInNamespaces([]libcontainer.NamespaceType{specs.MountNamespace}, stat); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			err = fmt.Errorf("%w: %s", err, exitErr.Stderr)
+		}
+		return fmt.Errorf("mnt-helper: %w", err)
+	}
+	fmt.Printf("mnt-helper %s", out.String())
+	return nil
+}
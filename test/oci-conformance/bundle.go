@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// Paths of the payload and its output inside the container.
+const (
+	payloadPath = "/payload"
+	outDir      = "/out"
+	reportFile  = "report.json"
+	hookLogFile = "hooks.log"
+)
+
+// bundle is a bundle whose rootfs holds only the payload. The host
+// directory out is bind-mounted at /out so the payload and the hooks
+// can leave their findings there.
+type bundle struct {
+	dir  string
+	out  string
+	spec *specs.Spec
+}
+
+// newBundle creates a bundle under dir with the default spec running
+// the payload with args.
+func newBundle(dir, payload string, args ...string) (*bundle, error) {
+	b := &bundle{dir: dir, out: filepath.Join(dir, "out")}
+	for _, d := range []string{"proc", "dev", "sys", "tmp", "out"} {
+		if err := os.MkdirAll(filepath.Join(b.rootfs(), d), 0755); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(b.out, 0755); err != nil {
+		return nil, err
+	}
+	if err := copyFile(payload, filepath.Join(b.rootfs(), payloadPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to copy payload into rootfs: %w", err)
+	}
+
+	b.spec = defaultSpec(append([]string{payloadPath}, args...))
+	b.spec.Mounts = append(b.spec.Mounts, specs.Mount{
+		Destination: outDir,
+		Type:        "bind",
+		Source:      b.out,
+		Options:     []string{"rbind", "rw"},
+	})
+	return b, nil
+}
+
+func (b *bundle) rootfs() string {
+	return filepath.Join(b.dir, "rootfs")
+}
+
+// hostPayload is the payload as seen from the host, for hooks that
+// resolve paths in the runtime namespace.
+func (b *bundle) hostPayload() string {
+	return filepath.Join(b.rootfs(), payloadPath)
+}
+
+// save writes config.json.
+func (b *bundle) save() error {
+	data, err := json.MarshalIndent(b.spec, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(b.dir, "config.json"), data, 0644)
+}
+
+// report reads what the payload recorded, waiting up to timeout for it.
+func (b *bundle) report(timeout time.Duration) (*Report, error) {
+	path := filepath.Join(b.out, reportFile)
+	deadline := time.Now().Add(timeout)
+	for {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			var report Report
+			if err := json.Unmarshal(data, &report); err == nil {
+				return &report, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("payload wrote no report within %s", timeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// hasReport reports whether the payload has run at all.
+func (b *bundle) hasReport() bool {
+	_, err := os.Stat(filepath.Join(b.out, reportFile))
+	return err == nil
+}
+
+// hooks returns the hooks that have run so far, in order.
+func (b *bundle) hooks() ([]HookRecord, error) {
+	data, err := os.ReadFile(filepath.Join(b.out, hookLogFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var records []HookRecord
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for dec.More() {
+		var record HookRecord
+		if err := dec.Decode(&record); err != nil {
+			return nil, fmt.Errorf("corrupt hook log: %w", err)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// hook returns a hook that logs its name and state. inContainer hooks
+// resolve their path in the container's mount namespace.
+func (b *bundle) hook(name string, inContainer bool) specs.Hook {
+	path, log := b.hostPayload(), filepath.Join(b.out, hookLogFile)
+	if inContainer {
+		path, log = payloadPath, filepath.Join(outDir, hookLogFile)
+	}
+	return specs.Hook{Path: path, Args: []string{"payload", "hook", name, log}}
+}
+
+// defaultSpec is a minimal Linux spec along the lines of runc spec.
+func defaultSpec(args []string) *specs.Spec {
+	return &specs.Spec{
+		Version: specs.Version,
+		Root:    &specs.Root{Path: "rootfs"},
+		Process: &specs.Process{
+			User: specs.User{UID: 0, GID: 0},
+			Args: args,
+			Env:  []string{"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin", "TERM=xterm"},
+			Cwd:  "/",
+		},
+		Hostname: "conformance",
+		Mounts: []specs.Mount{
+			{Destination: "/proc", Type: "proc", Source: "proc"},
+			{Destination: "/dev", Type: "tmpfs", Source: "tmpfs", Options: []string{"nosuid", "strictatime", "mode=755", "size=65536k"}},
+		},
+		Linux: &specs.Linux{
+			Namespaces: []specs.LinuxNamespace{
+				{Type: specs.PIDNamespace},
+				{Type: specs.NetworkNamespace},
+				{Type: specs.IPCNamespace},
+				{Type: specs.UTSNamespace},
+				{Type: specs.MountNamespace},
+			},
+		},
+	}
+}
+
+// buildPayload builds the payload statically into dir.
+func buildPayload(dir string) (string, error) {
+	path := filepath.Join(dir, "payload")
+	cmd := exec.Command("go", "build", "-o", path, "github.com/zakarynichols/hackontainer/test/oci-conformance/payload")
+	cmd.Env = append(os.Environ(), "CGO_ENABLED=0")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to build payload: %v: %s", err, out)
+	}
+	return path, nil
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, mode)
+}
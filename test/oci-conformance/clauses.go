@@ -0,0 +1,493 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// clause is one requirement of the runtime spec and the scenario that
+// checks it.
+type clause struct {
+	id   string
+	text string
+	// known explains why the runtime deliberately deviates from the
+	// clause. Its failure is expected and doesn't fail the run.
+	known string
+	// tracking is the issue following the deviation, when there is one.
+	tracking string
+	run      func(h *harness, c *container) error
+}
+
+// container is the container a clause runs against. Its bundle runs the
+// payload, which writes a report and sleeps for a few seconds.
+type container struct {
+	id     string
+	bundle *bundle
+}
+
+const (
+	// payloadSleep keeps the payload running long enough to inspect it.
+	payloadSleep = "3"
+	waitTimeout  = 10 * time.Second
+)
+
+var clauses = []clause{
+	{
+		id:   "lifecycle/create-no-exec",
+		text: "The runtime MUST NOT run the user-specified program until start is invoked.",
+		run: func(h *harness, c *container) error {
+			if err := h.create(c); err != nil {
+				return err
+			}
+			time.Sleep(500 * time.Millisecond)
+			if c.bundle.hasReport() {
+				return fmt.Errorf("the payload ran after create")
+			}
+			if err := h.rt.start(c.id); err != nil {
+				return err
+			}
+			_, err := c.bundle.report(waitTimeout)
+			return err
+		},
+	},
+	{
+		id:   "create/unique-id",
+		text: "create MUST generate an error if the ID provided is not unique across all containers within the scope of the runtime.",
+		run: func(h *harness, c *container) error {
+			if err := h.create(c); err != nil {
+				return err
+			}
+			if err := h.rt.create(c.id, c.bundle.dir); err == nil {
+				return fmt.Errorf("second create with the same id succeeded")
+			}
+			return nil
+		},
+	},
+	{
+		id:   "state/created",
+		text: "state MUST report ociVersion, id, status created and the absolute bundle path after create.",
+		run: func(h *harness, c *container) error {
+			if err := h.create(c); err != nil {
+				return err
+			}
+			state, err := h.rt.state(c.id)
+			if err != nil {
+				return err
+			}
+			return checkState(state, c, specs.StateCreated)
+		},
+	},
+	{
+		id:   "state/created-pid",
+		text: "state MUST report the pid of the container process once it is created.",
+		known: "create in its default state-only mode records the container without " +
+			"starting a process; the monitor started by start runs it, so a created " +
+			"container has no pid unless it was created with --create-mode=full",
+		run: func(h *harness, c *container) error {
+			if err := h.create(c); err != nil {
+				return err
+			}
+			state, err := h.rt.state(c.id)
+			if err != nil {
+				return err
+			}
+			if state.Pid <= 0 {
+				return fmt.Errorf("created container reports pid %d", state.Pid)
+			}
+			return nil
+		},
+	},
+	{
+		id:   "state/running",
+		text: "state MUST report status running and the pid of the container process after start.",
+		run: func(h *harness, c *container) error {
+			if err := h.createAndStart(c); err != nil {
+				return err
+			}
+			state, err := h.rt.state(c.id)
+			if err != nil {
+				return err
+			}
+			if err := checkState(state, c, specs.StateRunning); err != nil {
+				return err
+			}
+			if _, err := os.Stat(fmt.Sprintf("/proc/%d", state.Pid)); err != nil {
+				return fmt.Errorf("reported pid %d does not exist", state.Pid)
+			}
+			return nil
+		},
+	},
+	{
+		id:   "state/stopped",
+		text: "state MUST report status stopped once the container process has exited.",
+		run: func(h *harness, c *container) error {
+			if err := h.createAndStart(c); err != nil {
+				return err
+			}
+			state, err := h.rt.waitStatus(c.id, specs.StateStopped, waitTimeout)
+			if err != nil {
+				return err
+			}
+			return checkState(state, c, specs.StateStopped)
+		},
+	},
+	{
+		id:   "start/not-created",
+		text: "start MUST generate an error if the container is not in the created state.",
+		run: func(h *harness, c *container) error {
+			if err := h.createAndStart(c); err != nil {
+				return err
+			}
+			if err := h.rt.start(c.id); err == nil {
+				return fmt.Errorf("start of a running container succeeded")
+			}
+			if _, err := h.rt.waitStatus(c.id, specs.StateStopped, waitTimeout); err != nil {
+				return err
+			}
+			if err := h.rt.start(c.id); err == nil {
+				return fmt.Errorf("start of a stopped container succeeded")
+			}
+			return nil
+		},
+	},
+	{
+		id:   "kill/signal",
+		text: "kill MUST send the specified signal to the container process.",
+		run: func(h *harness, c *container) error {
+			c.bundle.spec.Process.Args = []string{payloadPath, "sleep", "60"}
+			if err := h.createAndStart(c); err != nil {
+				return err
+			}
+			if err := h.rt.kill(c.id, "KILL"); err != nil {
+				return err
+			}
+			_, err := h.rt.waitStatus(c.id, specs.StateStopped, waitTimeout)
+			return err
+		},
+	},
+	{
+		id:   "kill/not-running",
+		text: "kill MUST generate an error if the container is neither created nor running.",
+		run: func(h *harness, c *container) error {
+			if err := h.createAndStart(c); err != nil {
+				return err
+			}
+			if _, err := h.rt.waitStatus(c.id, specs.StateStopped, waitTimeout); err != nil {
+				return err
+			}
+			if err := h.rt.kill(c.id, "KILL"); err == nil {
+				return fmt.Errorf("kill of a stopped container succeeded")
+			}
+			return nil
+		},
+	},
+	{
+		id:   "delete/not-stopped",
+		text: "delete MUST generate an error if the container is not stopped.",
+		run: func(h *harness, c *container) error {
+			if err := h.createAndStart(c); err != nil {
+				return err
+			}
+			if err := h.rt.delete(c.id); err == nil {
+				return fmt.Errorf("delete of a running container succeeded")
+			}
+			return nil
+		},
+	},
+	{
+		id:   "delete/removes",
+		text: "Once deleted, the container's ID can no longer be queried with state.",
+		run: func(h *harness, c *container) error {
+			if err := h.createAndStart(c); err != nil {
+				return err
+			}
+			if _, err := h.rt.waitStatus(c.id, specs.StateStopped, waitTimeout); err != nil {
+				return err
+			}
+			if err := h.rt.delete(c.id); err != nil {
+				return err
+			}
+			if _, err := h.rt.state(c.id); err == nil {
+				return fmt.Errorf("state of a deleted container succeeded")
+			}
+			return nil
+		},
+	},
+	{
+		id:   "config/process",
+		text: "The container process MUST run with the configured args and env.",
+		run: func(h *harness, c *container) error {
+			c.bundle.spec.Process.Env = append(c.bundle.spec.Process.Env, "CONFORMANCE=yes")
+			if err := h.createAndStart(c); err != nil {
+				return err
+			}
+			report, err := c.bundle.report(waitTimeout)
+			if err != nil {
+				return err
+			}
+			if !reflect.DeepEqual(report.Args, c.bundle.spec.Process.Args) {
+				return fmt.Errorf("args are %q, want %q", report.Args, c.bundle.spec.Process.Args)
+			}
+			if !reflect.DeepEqual(report.Env, c.bundle.spec.Process.Env) {
+				return fmt.Errorf("env is %q, want %q", report.Env, c.bundle.spec.Process.Env)
+			}
+			return nil
+		},
+	},
+	{
+		id:   "config/cwd",
+		text: "The container process MUST start in the configured cwd.",
+		run: func(h *harness, c *container) error {
+			if err := os.MkdirAll(filepath.Join(c.bundle.rootfs(), "work"), 0755); err != nil {
+				return err
+			}
+			c.bundle.spec.Process.Cwd = "/work"
+			if err := h.createAndStart(c); err != nil {
+				return err
+			}
+			report, err := c.bundle.report(waitTimeout)
+			if err != nil {
+				return err
+			}
+			if report.Cwd != "/work" {
+				return fmt.Errorf("cwd is %q, want /work", report.Cwd)
+			}
+			return nil
+		},
+	},
+	{
+		id:   "config/user",
+		text: "The container process MUST run as the configured uid and gid.",
+		run: func(h *harness, c *container) error {
+			// The payload reports as that user
+			if err := os.Chmod(c.bundle.out, 0777); err != nil {
+				return err
+			}
+			c.bundle.spec.Process.User = specs.User{UID: 1000, GID: 1001}
+			if err := h.createAndStart(c); err != nil {
+				return err
+			}
+			report, err := c.bundle.report(waitTimeout)
+			if err != nil {
+				return err
+			}
+			if report.UID != 1000 || report.GID != 1001 {
+				return fmt.Errorf("process runs as %d:%d, want 1000:1001", report.UID, report.GID)
+			}
+			return nil
+		},
+	},
+	{
+		id:   "config/hostname",
+		text: "The container MUST have the configured hostname.",
+		run: func(h *harness, c *container) error {
+			if err := h.createAndStart(c); err != nil {
+				return err
+			}
+			report, err := c.bundle.report(waitTimeout)
+			if err != nil {
+				return err
+			}
+			if report.Hostname != c.bundle.spec.Hostname {
+				return fmt.Errorf("hostname is %q, want %q", report.Hostname, c.bundle.spec.Hostname)
+			}
+			return nil
+		},
+	},
+	{
+		id:   "config/mounts",
+		text: "The runtime MUST mount entries in the listed order, so later mounts can sit on earlier ones.",
+		run: func(h *harness, c *container) error {
+			data := filepath.Join(c.bundle.dir, "data")
+			if err := os.MkdirAll(data, 0755); err != nil {
+				return err
+			}
+			if err := os.WriteFile(filepath.Join(data, "file"), []byte("bound"), 0644); err != nil {
+				return err
+			}
+			c.bundle.spec.Mounts = append(c.bundle.spec.Mounts,
+				specs.Mount{Destination: "/scratch", Type: "tmpfs", Source: "tmpfs"},
+				specs.Mount{Destination: "/scratch/data", Type: "bind", Source: data, Options: []string{"rbind", "ro"}},
+			)
+			c.bundle.spec.Process.Args = []string{payloadPath, "report", outDir + "/" + reportFile, "read", "/scratch/data/file"}
+			if err := h.createAndStart(c); err != nil {
+				return err
+			}
+			report, err := c.bundle.report(waitTimeout)
+			if err != nil {
+				return err
+			}
+
+			types := make(map[string]string)
+			for _, m := range report.Mounts {
+				types[m.Destination] = m.Type
+			}
+			if types["/scratch"] != "tmpfs" {
+				return fmt.Errorf("/scratch is not a tmpfs mount")
+			}
+			if _, ok := types["/scratch/data"]; !ok {
+				return fmt.Errorf("/scratch/data is not mounted")
+			}
+			if got := report.Files["/scratch/data/file"]; got != "bound" {
+				return fmt.Errorf("bind-mounted file reads %q, want %q", got, "bound")
+			}
+			return nil
+		},
+	},
+	{
+		id:   "hooks/order",
+		text: "Hooks MUST run in lifecycle order: prestart, createRuntime, createContainer, startContainer, poststart.",
+		run: func(h *harness, c *container) error {
+			c.bundle.spec.Hooks = lifecycleHooks(c.bundle)
+			if err := h.createAndStart(c); err != nil {
+				return err
+			}
+			if _, err := c.bundle.report(waitTimeout); err != nil {
+				return err
+			}
+			records, err := c.bundle.hooks()
+			if err != nil {
+				return err
+			}
+			want := []string{"prestart", "createRuntime", "createContainer", "startContainer", "poststart"}
+			if got := hookNames(records); !reflect.DeepEqual(got, want) {
+				return fmt.Errorf("hooks ran as %q, want %q", got, want)
+			}
+			return nil
+		},
+	},
+	{
+		id:   "hooks/state",
+		text: "Hooks MUST receive the state of the container on stdin.",
+		run: func(h *harness, c *container) error {
+			c.bundle.spec.Hooks = lifecycleHooks(c.bundle)
+			if err := h.createAndStart(c); err != nil {
+				return err
+			}
+			if _, err := h.rt.waitStatus(c.id, specs.StateStopped, waitTimeout); err != nil {
+				return err
+			}
+			if err := h.rt.delete(c.id); err != nil {
+				return err
+			}
+			records, err := c.bundle.hooks()
+			if err != nil {
+				return err
+			}
+			if len(records) == 0 {
+				return fmt.Errorf("no hook ran")
+			}
+			for _, record := range records {
+				state, err := record.state()
+				if err != nil {
+					return fmt.Errorf("%s hook: %w", record.Hook, err)
+				}
+				if state.ID != c.id || state.Bundle != c.bundle.dir || state.Version == "" {
+					return fmt.Errorf("%s hook got id %q, bundle %q, ociVersion %q", record.Hook, state.ID, state.Bundle, state.Version)
+				}
+			}
+			return nil
+		},
+	},
+	{
+		id:   "hooks/create-runtime-on-create",
+		text: "createRuntime and createContainer hooks MUST be called as part of the create operation.",
+		known: "no process exists until start, so the hooks that set it up " +
+			"run during start",
+		run: func(h *harness, c *container) error {
+			c.bundle.spec.Hooks = lifecycleHooks(c.bundle)
+			if err := h.create(c); err != nil {
+				return err
+			}
+			records, err := c.bundle.hooks()
+			if err != nil {
+				return err
+			}
+			got := hookNames(records)
+			if want := []string{"prestart", "createRuntime", "createContainer"}; !reflect.DeepEqual(got, want) {
+				return fmt.Errorf("after create hooks ran as %q, want %q", got, want)
+			}
+			return nil
+		},
+	},
+	{
+		id:   "hooks/poststop-on-delete",
+		text: "poststop hooks MUST be called after the container is deleted but before the delete operation returns.",
+		known: "the monitor runs poststop as soon as the container process exits, " +
+			"so cleanup doesn't wait for a delete that may never come",
+		run: func(h *harness, c *container) error {
+			c.bundle.spec.Hooks = lifecycleHooks(c.bundle)
+			if err := h.createAndStart(c); err != nil {
+				return err
+			}
+			if _, err := h.rt.waitStatus(c.id, specs.StateStopped, waitTimeout); err != nil {
+				return err
+			}
+			time.Sleep(500 * time.Millisecond)
+			records, err := c.bundle.hooks()
+			if err != nil {
+				return err
+			}
+			if names := hookNames(records); names[len(names)-1] == "poststop" {
+				return fmt.Errorf("poststop ran before delete")
+			}
+			if err := h.rt.delete(c.id); err != nil {
+				return err
+			}
+			if records, err = c.bundle.hooks(); err != nil {
+				return err
+			}
+			if names := hookNames(records); names[len(names)-1] != "poststop" {
+				return fmt.Errorf("poststop did not run on delete")
+			}
+			return nil
+		},
+	},
+}
+
+// lifecycleHooks logs every hook. startContainer runs in the container,
+// the rest in the runtime namespace.
+func lifecycleHooks(b *bundle) *specs.Hooks {
+	return &specs.Hooks{
+		Prestart:        []specs.Hook{b.hook("prestart", false)},
+		CreateRuntime:   []specs.Hook{b.hook("createRuntime", false)},
+		CreateContainer: []specs.Hook{b.hook("createContainer", false)},
+		StartContainer:  []specs.Hook{b.hook("startContainer", true)},
+		Poststart:       []specs.Hook{b.hook("poststart", false)},
+		Poststop:        []specs.Hook{b.hook("poststop", false)},
+	}
+}
+
+func hookNames(records []HookRecord) []string {
+	names := make([]string, 0, len(records))
+	for _, record := range records {
+		names = append(names, record.Hook)
+	}
+	return names
+}
+
+func checkState(state *specs.State, c *container, status specs.ContainerState) error {
+	var problems []string
+	if state.Version == "" {
+		problems = append(problems, "ociVersion is empty")
+	}
+	if state.ID != c.id {
+		problems = append(problems, fmt.Sprintf("id is %q", state.ID))
+	}
+	if state.Status != status {
+		problems = append(problems, fmt.Sprintf("status is %q, want %q", state.Status, status))
+	}
+	if state.Bundle != c.bundle.dir {
+		problems = append(problems, fmt.Sprintf("bundle is %q, want %q", state.Bundle, c.bundle.dir))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+// Command oci-conformance checks the runtime against the requirements of
+// the OCI runtime spec: lifecycle ordering, the state document, hooks,
+// mounts and the errors the operations must return. It drives the
+// runtime binary the way a higher-level engine would and prints a
+// verdict per spec clause.
+//
+// Clauses the runtime deliberately deviates from are listed as known
+// failures with the reason. They are reported as XFAIL and don't fail
+// the run; if one starts passing it is reported as XPASS so the
+// deviation can be dropped.
+//
+// It must run as root:
+//
+//	CGO_ENABLED=0 go build -o hackontainer ./cmd/hackontainer
+//	sudo go run ./test/oci-conformance -runtime ./hackontainer
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// Report mirrors the payload's report.
+type Report struct {
+	Args     []string          `json:"args"`
+	Env      []string          `json:"env"`
+	Cwd      string            `json:"cwd"`
+	Hostname string            `json:"hostname"`
+	UID      int               `json:"uid"`
+	GID      int               `json:"gid"`
+	Mounts   []Mount           `json:"mounts"`
+	Files    map[string]string `json:"files,omitempty"`
+}
+
+// Mount mirrors the payload's mount entry.
+type Mount struct {
+	Destination string `json:"destination"`
+	Type        string `json:"type"`
+	Options     string `json:"options"`
+}
+
+// HookRecord mirrors a line of the payload's hook log.
+type HookRecord struct {
+	Hook  string          `json:"hook"`
+	State json.RawMessage `json:"state"`
+	Time  time.Time       `json:"time"`
+}
+
+// state decodes the state the hook was given.
+func (r HookRecord) state() (*specs.State, error) {
+	var state specs.State
+	if err := json.Unmarshal(r.State, &state); err != nil {
+		return nil, fmt.Errorf("stdin is not a state document: %w", err)
+	}
+	return &state, nil
+}
+
+// Verdicts of a clause.
+const (
+	pass  = "PASS"
+	fail  = "FAIL"
+	xfail = "XFAIL"
+	xpass = "XPASS"
+)
+
+// result is the verdict on one clause, as written to the -json report.
+type result struct {
+	Clause   string `json:"clause"`
+	Text     string `json:"text"`
+	Verdict  string `json:"verdict"`
+	Error    string `json:"error,omitempty"`
+	Known    string `json:"known,omitempty"`
+	Tracking string `json:"tracking,omitempty"`
+}
+
+type harness struct {
+	rt      *runtime
+	payload string
+	dir     string
+	seq     int
+}
+
+// newContainer gives a clause a fresh bundle and container ID. By
+// default the payload writes its report and sleeps.
+func (h *harness) newContainer() (*container, error) {
+	h.seq++
+	id := fmt.Sprintf("conformance-%d-%d", os.Getpid(), h.seq)
+	dir := filepath.Join(h.dir, id)
+	b, err := newBundle(dir, h.payload, "report", outDir+"/"+reportFile, "sleep", payloadSleep)
+	if err != nil {
+		return nil, err
+	}
+	return &container{id: id, bundle: b}, nil
+}
+
+// create saves the bundle's config and creates the container.
+func (h *harness) create(c *container) error {
+	if err := c.bundle.save(); err != nil {
+		return err
+	}
+	return h.rt.create(c.id, c.bundle.dir)
+}
+
+func (h *harness) createAndStart(c *container) error {
+	if err := h.create(c); err != nil {
+		return err
+	}
+	return h.rt.start(c.id)
+}
+
+// check runs one clause and removes what it left behind.
+func (h *harness) check(cl clause, keep bool) result {
+	res := result{Clause: cl.id, Text: cl.text, Known: cl.known, Tracking: cl.tracking}
+
+	c, err := h.newContainer()
+	if err == nil {
+		err = cl.run(h, c)
+		h.rt.cleanup(c.id)
+		if !keep {
+			os.RemoveAll(c.bundle.dir)
+		}
+	}
+
+	switch {
+	case err == nil && cl.known != "":
+		res.Verdict = xpass
+	case err == nil:
+		res.Verdict = pass
+	case cl.known != "":
+		res.Verdict, res.Error = xfail, err.Error()
+	default:
+		res.Verdict, res.Error = fail, err.Error()
+	}
+	return res
+}
+
+func main() {
+	runtimePath := flag.String("runtime", "./hackontainer", "runtime binary under test")
+	root := flag.String("root", "", "runtime state root (default: a temporary directory)")
+	payload := flag.String("payload", "", "static payload binary (default: build it)")
+	keep := flag.Bool("keep", false, "keep bundles for inspection")
+	run := flag.String("run", "", "only check clauses matching this regexp")
+	jsonPath := flag.String("json", "", "also write the results as JSON to this file")
+	flag.Parse()
+
+	failed, err := conformance(*runtimePath, *root, *payload, *run, *jsonPath, *keep)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "oci-conformance: %v\n", err)
+		os.Exit(2)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// conformance checks the clauses matching run and reports whether any
+// failed unexpectedly.
+func conformance(runtimePath, root, payload, run, jsonPath string, keep bool) (bool, error) {
+	if os.Geteuid() != 0 {
+		return false, fmt.Errorf("must run as root")
+	}
+	runtimePath, err := filepath.Abs(runtimePath)
+	if err != nil {
+		return false, err
+	}
+	filter, err := regexp.Compile(run)
+	if err != nil {
+		return false, fmt.Errorf("invalid -run: %w", err)
+	}
+
+	dir, err := os.MkdirTemp("", "oci-conformance-")
+	if err != nil {
+		return false, err
+	}
+	if !keep {
+		defer os.RemoveAll(dir)
+	}
+	if root == "" {
+		root = filepath.Join(dir, "root")
+	}
+	if payload == "" {
+		if payload, err = buildPayload(dir); err != nil {
+			return false, err
+		}
+	}
+
+	h := &harness{rt: &runtime{path: runtimePath, root: root}, payload: payload, dir: dir}
+	var results []result
+	counts := make(map[string]int)
+	for _, cl := range clauses {
+		if !filter.MatchString(cl.id) {
+			continue
+		}
+		res := h.check(cl, keep)
+		results = append(results, res)
+		counts[res.Verdict]++
+
+		fmt.Printf("%-5s %s\n", res.Verdict, res.Clause)
+		if res.Verdict != pass {
+			fmt.Printf("      %s\n", res.Text)
+		}
+		if res.Error != "" {
+			fmt.Printf("      error: %s\n", res.Error)
+		}
+		if res.Known != "" {
+			fmt.Printf("      known deviation: %s\n", res.Known)
+		}
+		if res.Tracking != "" {
+			fmt.Printf("      tracking: %s\n", res.Tracking)
+		}
+	}
+
+	var summary []string
+	for _, verdict := range []string{pass, fail, xfail, xpass} {
+		summary = append(summary, fmt.Sprintf("%d %s", counts[verdict], verdict))
+	}
+	fmt.Println(strings.Join(summary, ", "))
+	if keep {
+		fmt.Printf("bundles kept in %s\n", dir)
+	}
+
+	if jsonPath != "" {
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return false, err
+		}
+		if err := os.WriteFile(jsonPath, append(data, '\n'), 0644); err != nil {
+			return false, err
+		}
+	}
+	return counts[fail] > 0, nil
+}
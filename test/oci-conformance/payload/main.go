@@ -0,0 +1,163 @@
+// Command payload runs inside conformance test containers. It reports
+// what the container process sees, and doubles as the hook binary so
+// hooks can run both on the host and inside the container rootfs. It
+// must be built statically, since the rootfs holds nothing else.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Report is what the container process saw.
+type Report struct {
+	Args     []string `json:"args"`
+	Env      []string `json:"env"`
+	Cwd      string   `json:"cwd"`
+	Hostname string   `json:"hostname"`
+	UID      int      `json:"uid"`
+	GID      int      `json:"gid"`
+	Mounts   []Mount  `json:"mounts"`
+	// Files holds the contents of the files named with -read.
+	Files map[string]string `json:"files,omitempty"`
+}
+
+// Mount is one entry of /proc/self/mountinfo.
+type Mount struct {
+	Destination string `json:"destination"`
+	Type        string `json:"type"`
+	Options     string `json:"options"`
+}
+
+// HookRecord is one line of a hook log.
+type HookRecord struct {
+	Hook  string          `json:"hook"`
+	State json.RawMessage `json:"state"`
+	Time  time.Time       `json:"time"`
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "hook" {
+		if err := runHook(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "payload hook: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "payload: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run handles "payload [report <path>] [read <file>]... [sleep <seconds>]
+// [exit <code>]", in that order.
+func run(args []string) error {
+	var reportPath string
+	var reads []string
+	sleep := 0
+	code := 0
+	for len(args) >= 2 {
+		switch args[0] {
+		case "report":
+			reportPath = args[1]
+		case "read":
+			reads = append(reads, args[1])
+		case "sleep":
+			sleep, _ = strconv.Atoi(args[1])
+		case "exit":
+			code, _ = strconv.Atoi(args[1])
+		default:
+			return fmt.Errorf("unknown argument %q", args[0])
+		}
+		args = args[2:]
+	}
+
+	if reportPath != "" {
+		if err := writeReport(reportPath, reads); err != nil {
+			return err
+		}
+	}
+	time.Sleep(time.Duration(sleep) * time.Second)
+	os.Exit(code)
+	return nil
+}
+
+func writeReport(path string, reads []string) error {
+	report := Report{
+		Args:  os.Args,
+		Env:   os.Environ(),
+		UID:   os.Getuid(),
+		GID:   os.Getgid(),
+		Files: make(map[string]string),
+	}
+	report.Cwd, _ = os.Getwd()
+	report.Hostname, _ = os.Hostname()
+
+	if data, err := os.ReadFile("/proc/self/mountinfo"); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			// id parent major:minor root mountpoint options ... - type source superoptions
+			pre, post, ok := strings.Cut(line, " - ")
+			if !ok {
+				continue
+			}
+			preFields, postFields := strings.Fields(pre), strings.Fields(post)
+			if len(preFields) < 6 || len(postFields) < 1 {
+				continue
+			}
+			report.Mounts = append(report.Mounts, Mount{
+				Destination: preFields[4],
+				Type:        postFields[0],
+				Options:     preFields[5],
+			})
+		}
+	}
+
+	for _, file := range reads {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			report.Files[file] = "error: " + err.Error()
+			continue
+		}
+		report.Files[file] = string(data)
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// runHook handles "payload hook <name> <log>": it appends the state it
+// was given on stdin to log.
+func runHook(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: payload hook <name> <log>")
+	}
+	state, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	if !json.Valid(state) {
+		return fmt.Errorf("stdin is not JSON: %q", state)
+	}
+
+	data, err := json.Marshal(HookRecord{Hook: args[0], State: state, Time: time.Now()})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(args[1], os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
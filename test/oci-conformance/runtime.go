@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// runtime drives the runtime binary under test through its command line.
+type runtime struct {
+	path string
+	root string
+}
+
+// run invokes the runtime and returns its stdout. The output goes to
+// files rather than pipes: start leaves a monitor behind that holds its
+// stdout open for as long as the container runs.
+func (r *runtime) run(args ...string) (string, error) {
+	stdout, err := os.CreateTemp("", "oci-conformance-stdout-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(stdout.Name())
+	defer stdout.Close()
+	stderr, err := os.CreateTemp("", "oci-conformance-stderr-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(stderr.Name())
+	defer stderr.Close()
+
+	cmd := exec.Command(r.path, append([]string{"--root", r.root}, args...)...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	runErr := cmd.Run()
+
+	out, _ := os.ReadFile(stdout.Name())
+	if runErr != nil {
+		msg, _ := os.ReadFile(stderr.Name())
+		return string(out), fmt.Errorf("%s %s: %v: %s", r.path, strings.Join(args, " "), runErr, strings.TrimSpace(string(msg)))
+	}
+	return string(out), nil
+}
+
+func (r *runtime) create(id, bundle string) error {
+	_, err := r.run("create", "--bundle", bundle, id)
+	return err
+}
+
+func (r *runtime) start(id string) error {
+	_, err := r.run("start", id)
+	return err
+}
+
+func (r *runtime) kill(id, signal string) error {
+	_, err := r.run("kill", id, signal)
+	return err
+}
+
+func (r *runtime) delete(id string) error {
+	_, err := r.run("delete", id)
+	return err
+}
+
+// state returns the state the runtime reports. Anything on stdout other
+// than the JSON document, such as progress logs, is skipped.
+func (r *runtime) state(id string) (*specs.State, error) {
+	out, err := r.run("state", id)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "{") {
+			continue
+		}
+		var state specs.State
+		if err := json.Unmarshal([]byte(line), &state); err != nil {
+			return nil, fmt.Errorf("state output is not a state document: %w", err)
+		}
+		return &state, nil
+	}
+	return nil, fmt.Errorf("state printed no JSON document: %q", out)
+}
+
+// waitStatus polls until the container reaches status.
+func (r *runtime) waitStatus(id string, status specs.ContainerState, timeout time.Duration) (*specs.State, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		state, err := r.state(id)
+		if err == nil && state.Status == status {
+			return state, nil
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("container still %s after %s, want %s", state.Status, timeout, status)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// cleanup removes a container whatever state it is in.
+func (r *runtime) cleanup(id string) {
+	if state, err := r.state(id); err == nil && state.Status == specs.StateRunning {
+		_ = r.kill(id, "KILL")
+		_, _ = r.waitStatus(id, specs.StateStopped, 5*time.Second)
+	}
+	_ = r.delete(id)
+}
@@ -0,0 +1,72 @@
+// Command recvtty is the receiving end of a console socket: it listens
+// on an AF_UNIX socket, takes the pty master a runtime sends there, and
+// copies what the container writes to its terminal to stdout until the
+// container closes it. What recvtty reads on stdin is typed into the
+// terminal. Tests use it to drive --console-socket:
+//
+//	go run ./test/recvtty /tmp/console.sock > out &
+//
+// The name the master was sent with is printed to stderr.
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: recvtty <socket>")
+		os.Exit(2)
+	}
+	if err := recvtty(os.Args[1]); err != nil {
+		fmt.Fprintf(os.Stderr, "recvtty: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func recvtty(path string) error {
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	name := make([]byte, 4096)
+	oob := make([]byte, unix.CmsgSpace(4))
+	n, oobn, _, _, err := conn.(*net.UnixConn).ReadMsgUnix(name, oob)
+	if err != nil {
+		return err
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return err
+	}
+	if len(msgs) != 1 {
+		return fmt.Errorf("got %d control messages, want 1", len(msgs))
+	}
+	fds, err := unix.ParseUnixRights(&msgs[0])
+	if err != nil {
+		return err
+	}
+	if len(fds) != 1 {
+		return fmt.Errorf("got %d fds, want 1", len(fds))
+	}
+	fmt.Fprintf(os.Stderr, "recvtty: got %s\n", name[:n])
+
+	master := os.NewFile(uintptr(fds[0]), string(name[:n]))
+	defer master.Close()
+	go io.Copy(master, os.Stdin)
+	// Reading fails with EIO once every slave is closed
+	io.Copy(os.Stdout, master)
+	return nil
+}
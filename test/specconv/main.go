@@ -0,0 +1,87 @@
+// Command specconv converts the fixture specs in a directory and checks
+// the result against what was resolved before. Each fixture is a
+// directory holding config.json, a spec as a container engine writes
+// it, and resolved.json, what specconv.Convert made of it:
+//
+//	go run ./test/specconv test/specconv-fixtures
+//
+// Fixtures are converted against the bundle /bundles/<fixture>, with
+// the fixture's name as the cgroup name. Neither needs to exist, since
+// the conversion doesn't look at the host. With -update the resolved
+// files are rewritten instead, for a change to the conversion to be
+// reviewed in their diff.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/zakarynichols/hackontainer/config"
+	"github.com/zakarynichols/hackontainer/libcontainer/specconv"
+)
+
+func main() {
+	update := flag.Bool("update", false, "rewrite resolved.json instead of checking it")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: specconv [-update] <fixtures dir>")
+		os.Exit(2)
+	}
+
+	fixtures, err := filepath.Glob(filepath.Join(flag.Arg(0), "*", "config.json"))
+	if err != nil || len(fixtures) == 0 {
+		fmt.Fprintf(os.Stderr, "specconv: no fixtures in %s\n", flag.Arg(0))
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, path := range fixtures {
+		dir := filepath.Dir(path)
+		name := filepath.Base(dir)
+		if err := check(dir, name, *update); err != nil {
+			fmt.Printf("FAIL: %s: %v\n", name, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("PASS: %s\n", name)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// check converts the fixture in dir and compares the result with its
+// resolved.json, or writes that when update is set.
+func check(dir, name string, update bool) error {
+	bundle := filepath.Join("/bundles", name)
+	cfg, err := config.LoadWithOptions(filepath.Join(dir, "config.json"), bundle, config.Options{Strict: true})
+	if err != nil {
+		return err
+	}
+	resolved, err := specconv.Convert(cfg.Spec, specconv.Options{Bundle: bundle, CgroupName: name})
+	if err != nil {
+		return err
+	}
+	got, err := json.MarshalIndent(resolved, "", "  ")
+	if err != nil {
+		return err
+	}
+	got = append(got, '\n')
+
+	resolvedPath := filepath.Join(dir, "resolved.json")
+	if update {
+		return os.WriteFile(resolvedPath, got, 0644)
+	}
+	want, err := os.ReadFile(resolvedPath)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("conversion differs from %s; got:\n%s", resolvedPath, got)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+// Command unsupported validates the fixture specs in a directory and
+// checks what config.Validate and config.Warnings say about the fields
+// the runtime doesn't support. Each fixture is a directory holding
+// config.json, a spec with such fields, and expected.txt, what was
+// reported for it before: the validation error on a line starting
+// "error: ", or else one "warning: " line per warning.
+//
+//	go run ./test/unsupported test/unsupported-fixtures
+//
+// With -update the expected files are rewritten instead, for a change
+// to the checks to be reviewed in their diff.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/zakarynichols/hackontainer/config"
+)
+
+func main() {
+	update := flag.Bool("update", false, "rewrite expected.txt instead of checking it")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: unsupported [-update] <fixtures dir>")
+		os.Exit(2)
+	}
+
+	fixtures, err := filepath.Glob(filepath.Join(flag.Arg(0), "*", "config.json"))
+	if err != nil || len(fixtures) == 0 {
+		fmt.Fprintf(os.Stderr, "unsupported: no fixtures in %s\n", flag.Arg(0))
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, path := range fixtures {
+		dir := filepath.Dir(path)
+		name := filepath.Base(dir)
+		if err := check(dir, *update); err != nil {
+			fmt.Printf("FAIL: %s: %v\n", name, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("PASS: %s\n", name)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// check validates the fixture in dir and compares the report with its
+// expected.txt, or writes that when update is set.
+func check(dir string, update bool) error {
+	cfg, err := config.LoadWithBundle(filepath.Join(dir, "config.json"), dir)
+	if err != nil {
+		return err
+	}
+	var got bytes.Buffer
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(&got, "error: %v\n", err)
+	} else {
+		for _, warning := range cfg.Warnings() {
+			fmt.Fprintf(&got, "warning: %s\n", warning)
+		}
+	}
+
+	expectedPath := filepath.Join(dir, "expected.txt")
+	if update {
+		return os.WriteFile(expectedPath, got.Bytes(), 0644)
+	}
+	want, err := os.ReadFile(expectedPath)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got.Bytes(), want) {
+		return fmt.Errorf("report differs from %s; got:\n%s", expectedPath, got.Bytes())
+	}
+	return nil
+}
@@ -31,7 +31,7 @@ fi
 
 if [ ! -f "hackontainer" ]; then
     echo "Building hackontainer..."
-    go build -o hackontainer ./cmd/hackontainer
+    CGO_ENABLED=0 go build -o hackontainer ./cmd/hackontainer
 fi
 
 echo "Running validation tests..."
@@ -0,0 +1,73 @@
+// Package version identifies the runtime build and the version of the
+// OCI runtime spec it implements.
+//
+// Commit and BuildDate come from the version control information the Go
+// toolchain stamps into binaries built inside a git checkout, where the
+// date is the commit's, so that rebuilding gives the same binary. Builds
+// from elsewhere, such as a source tarball, set them at link time:
+//
+//	go build -ldflags "-X github.com/zakarynichols/hackontainer/version.Commit=<sha> \
+//	    -X github.com/zakarynichols/hackontainer/version.BuildDate=<RFC 3339 date>" ./cmd/hackontainer
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/zakarynichols/hackontainer/api/types"
+)
+
+// Version is the runtime's version. Create records it, and start warns
+// when a container was created by another version.
+var Version = "1.0.0"
+
+// Commit and BuildDate identify the build. Commit has a -dirty suffix
+// when the checkout had changes.
+var (
+	Commit    = ""
+	BuildDate = ""
+)
+
+// OCIVersion is the version of the OCI runtime spec the runtime
+// implements, the one state reports and hooks are given. It is that of
+// the spec module the runtime is built with, so it moves with go.mod.
+var OCIVersion = specs.Version
+
+func init() {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	var revision, modified, date string
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.modified":
+			modified = s.Value
+		case "vcs.time":
+			date = s.Value
+		}
+	}
+	if Commit == "" && revision != "" {
+		Commit = revision
+		if modified == "true" {
+			Commit += "-dirty"
+		}
+	}
+	if BuildDate == "" {
+		BuildDate = date
+	}
+}
+
+// Info describes the running binary.
+func Info() types.VersionInfo {
+	return types.VersionInfo{
+		Version:    Version,
+		Commit:     Commit,
+		BuildDate:  BuildDate,
+		OCIVersion: OCIVersion,
+		GoVersion:  runtime.Version(),
+	}
+}